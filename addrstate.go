@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// addrState flags an interface address that should not be published yet (or any more)
+type addrState uint8
+
+const (
+	addrTentative    addrState = 1 << iota // Duplicate address detection has not finished
+	addrDeprecated                         // Preferred lifetime over; kept only for existing connections
+	addrDADFailed                          // Another host on the link uses the address
+	addrLeaseExpired                       // The DHCP lease for the address has expired
+)
+
+// String names the set flags, e.g. "tentative,deprecated"
+func (s addrState) String() string {
+	var names []string
+	for _, flag := range []struct {
+		bit  addrState
+		name string
+	}{
+		{addrTentative, "tentative"},
+		{addrDeprecated, "deprecated"},
+		{addrDADFailed, "dad-failed"},
+		{addrLeaseExpired, "lease-expired"},
+	} {
+		if s&flag.bit != 0 {
+			names = append(names, flag.name)
+		}
+	}
+	if len(names) == 0 {
+		return "ok"
+	}
+	return strings.Join(names, ",")
+}
+
+// linkLocalPrefixes are the IPv4 (RFC 3927) and IPv6 link-local ranges. Their addresses
+// are only valid on one link, so they are not published unless INCLUDE_LINK_LOCAL is set.
+var linkLocalPrefixes = mustParsePrefixes([]string{"169.254.0.0/16", "fe80::/10"})
+
+// defaultDHCPLeaseFiles are where dhclient keeps its leases on common distributions
+var defaultDHCPLeaseFiles = []string{
+	"/var/lib/dhcp/dhclient*.leases",
+	"/var/lib/dhclient/*.lease*",
+	"/var/lib/NetworkManager/*.lease",
+}
+
+// publishableAddrs drops the interface addresses that must not be published: link-local
+// addresses (unless config.IncludeLinkLocal) and addresses in an unstable state (unless
+// config.IncludeUnstableAddresses)
+func publishableAddrs(addrs []interfaceAddr, config *Config) []interfaceAddr {
+	result := make([]interfaceAddr, 0, len(addrs))
+	for _, a := range addrs {
+		switch {
+		case !config.IncludeLinkLocal && containsAddr(linkLocalPrefixes, a.Addr):
+			continue // Common on every interface, so not logged
+		case !config.IncludeUnstableAddresses && a.State != 0:
+			log.Printf("Skipping %s on interface %s: address is %s", a.Addr, a.Interface, a.State)
+			continue
+		}
+		result = append(result, a)
+	}
+	return result
+}
+
+// dhclientLeaseExpiries returns the expiry of the newest lease of each address found in
+// dhclient lease files matching the glob patterns. Unreadable files are skipped.
+func dhclientLeaseExpiries(patterns []string) map[netip.Addr]time.Time {
+	expiries := make(map[netip.Addr]time.Time)
+	for _, pattern := range patterns {
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			f, err := os.Open(file)
+			if err != nil {
+				continue
+			}
+			parseDHClientLeases(bufio.NewScanner(f), expiries)
+			f.Close()
+		}
+	}
+	return expiries
+}
+
+// parseDHClientLeases reads dhclient.leases(5) statements. Leases are appended as they
+// are renewed, so a later lease of the same address replaces an earlier one.
+//
+//	lease {
+//	  fixed-address 192.168.1.10;
+//	  expire 4 2026/10/15 18:30:00;
+//	}
+func parseDHClientLeases(scanner *bufio.Scanner, expiries map[netip.Addr]time.Time) {
+	var addr netip.Addr
+	var expire time.Time
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ";"))
+		switch {
+		case len(fields) >= 2 && fields[0] == "lease" && fields[1] == "{":
+			addr, expire = netip.Addr{}, time.Time{}
+		case len(fields) == 2 && fields[0] == "fixed-address":
+			addr, _ = parseAddr(fields[1])
+		case len(fields) >= 2 && fields[0] == "expire":
+			// "expire never" leaves the zero time, which never expires
+			if len(fields) == 4 {
+				// Times are UTC unless the lease file is written with db-time-format local
+				expire, _ = time.Parse("2006/01/02 15:04:05", fields[2]+" "+fields[3])
+			}
+		case len(fields) == 1 && fields[0] == "}":
+			if addr.IsValid() {
+				expiries[addr] = expire
+			}
+			addr = netip.Addr{}
+		}
+	}
+}
+
+// leaseStates flags the addresses whose newest DHCP lease expired before now
+func leaseStates(expiries map[netip.Addr]time.Time, now time.Time) map[netip.Addr]addrState {
+	states := make(map[netip.Addr]addrState)
+	for addr, expire := range expiries {
+		if !expire.IsZero() && expire.Before(now) {
+			states[addr] = addrLeaseExpired
+		}
+	}
+	return states
+}
+
+// warnLinkLocalRanges warns about custom ranges that can only match link-local addresses
+// while those are excluded
+func warnLinkLocalRanges(config *Config) {
+	if config.IncludeLinkLocal {
+		return
+	}
+	for _, r := range append(append([]CustomIPRange{}, config.CustomIPv4Ranges...), config.CustomIPv6Ranges...) {
+		for _, linkLocal := range linkLocalPrefixes {
+			if r.Prefix.IsValid() && r.Prefix.Bits() >= linkLocal.Bits() && linkLocal.Contains(r.Prefix.Addr()) {
+				log.Printf("WARNING: custom range %s only contains link-local addresses, which are skipped unless %sINCLUDE_LINK_LOCAL=true", r.CIDR, envPrefix)
+			}
+		}
+	}
+}
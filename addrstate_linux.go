@@ -0,0 +1,69 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// IPv6 address flags from linux/if_addr.h, as listed in /proc/net/if_inet6
+const (
+	ifaFlagDADFailed  = 0x08
+	ifaFlagDeprecated = 0x20
+	ifaFlagTentative  = 0x40
+)
+
+// platformAddrStates reads the IPv6 address states from /proc/net/if_inet6. The kernel
+// keeps no such state for IPv4 addresses; those are covered by the DHCP lease files.
+func platformAddrStates() map[netip.Addr]addrState {
+	f, err := os.Open("/proc/net/if_inet6")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parseIfInet6(f)
+}
+
+// parseIfInet6 parses lines of the form
+//
+//	fe800000000000000000000000000001 02 40 20 80 eth0
+//
+// (address, interface index, prefix length, scope, flags, interface name)
+func parseIfInet6(r io.Reader) map[netip.Addr]addrState {
+	states := make(map[netip.Addr]addrState)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil || len(raw) != 16 {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		var state addrState
+		if flags&ifaFlagTentative != 0 {
+			state |= addrTentative
+		}
+		if flags&ifaFlagDeprecated != 0 {
+			state |= addrDeprecated
+		}
+		if flags&ifaFlagDADFailed != 0 {
+			state |= addrDADFailed
+		}
+		if state != 0 {
+			states[netip.AddrFrom16([16]byte(raw))] |= state
+		}
+	}
+	return states
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParseIfInet6(t *testing.T) {
+	input := `fd000000000000000000000000000002 04 40 00 82     eth0
+fe8000000000000000fc00fffe000001 04 40 20 40     eth0
+20010db8000000000000000000000001 04 40 00 20     eth0
+20010db8000000000000000000000002 04 40 00 88     eth0
+00000000000000000000000000000001 01 80 10 80       lo
+`
+	states := parseIfInet6(strings.NewReader(input))
+	want := map[string]addrState{
+		"fe80::fc:ff:fe00:1": addrTentative,
+		"2001:db8::1":        addrDeprecated,
+		"2001:db8::2":        addrDADFailed,
+	}
+	if len(states) != len(want) {
+		t.Errorf("states = %v", states)
+	}
+	for addr, state := range want {
+		if got := states[netip.MustParseAddr(addr)]; got != state {
+			t.Errorf("%s = %s, want %s", addr, got, state)
+		}
+	}
+}
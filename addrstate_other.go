@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "net/netip"
+
+// platformAddrStates returns nothing: address states are only read on Linux, elsewhere
+// only the DHCP lease files are consulted
+func platformAddrStates() map[netip.Addr]addrState {
+	return nil
+}
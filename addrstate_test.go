@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishableAddrs(t *testing.T) {
+	addrs := []interfaceAddr{
+		{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("169.254.3.4"), Interface: "eth1"},
+		{Addr: netip.MustParseAddr("fe80::1"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("2001:db8::1"), Interface: "eth0", State: addrTentative},
+		{Addr: netip.MustParseAddr("2001:db8::2"), Interface: "eth0", State: addrDeprecated},
+		{Addr: netip.MustParseAddr("2001:db8::3"), Interface: "eth0"},
+	}
+	names := func(addrs []interfaceAddr) []string {
+		var s []string
+		for _, a := range addrs {
+			s = append(s, a.Addr.String())
+		}
+		return s
+	}
+
+	if got := names(publishableAddrs(addrs, &Config{})); !reflect.DeepEqual(got, []string{"192.168.1.10", "2001:db8::3"}) {
+		t.Errorf("default = %v", got)
+	}
+	got := names(publishableAddrs(addrs, &Config{IncludeLinkLocal: true, IncludeUnstableAddresses: true}))
+	if len(got) != len(addrs) {
+		t.Errorf("with everything included = %v", got)
+	}
+}
+
+func TestAddrStateString(t *testing.T) {
+	if got := (addrTentative | addrDADFailed).String(); got != "tentative,dad-failed" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := addrState(0).String(); got != "ok" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+const testLeases = `lease {
+  interface "eth0";
+  fixed-address 192.168.1.10;
+  option subnet-mask 255.255.255.0;
+  renew 4 2026/10/15 12:00:00;
+  expire 4 2026/10/15 18:00:00;
+}
+lease {
+  interface "eth0";
+  fixed-address 192.168.1.10;
+  expire 5 2026/10/16 18:00:00;
+}
+lease {
+  interface "eth1";
+  fixed-address 10.0.0.7;
+  expire 4 2026/10/15 18:00:00;
+}
+lease {
+  interface "eth2";
+  fixed-address 10.0.9.9;
+  expire never;
+}
+`
+
+func TestParseDHClientLeases(t *testing.T) {
+	expiries := make(map[netip.Addr]time.Time)
+	parseDHClientLeases(bufio.NewScanner(strings.NewReader(testLeases)), expiries)
+	if len(expiries) != 3 {
+		t.Fatalf("expiries = %v", expiries)
+	}
+
+	// The renewed lease of 192.168.1.10 replaces the expired one
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	states := leaseStates(expiries, now)
+	want := map[netip.Addr]addrState{netip.MustParseAddr("10.0.0.7"): addrLeaseExpired}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+}
+
+func TestDHClientLeaseExpiriesGlob(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "dhclient.eth0.leases"), []byte(testLeases), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expiries := dhclientLeaseExpiries([]string{filepath.Join(dir, "dhclient*.leases"), filepath.Join(dir, "missing", "*")})
+	if _, ok := expiries[netip.MustParseAddr("10.0.0.7")]; !ok || len(expiries) != 3 {
+		t.Errorf("expiries = %v", expiries)
+	}
+}
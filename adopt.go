@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strings"
+)
+
+// Records that already exist at a managed name before dynipupdate ever published there
+// (no heartbeat yet, no managed-by tag) were most likely created by hand or by another
+// tool. Reconciling would replace or delete them as stale, so they are handled explicitly:
+//
+//	warn    (default) log them and leave their domains alone
+//	adopt   (-adopt) take them over: tag them (with RECORD_TAGS) and reconcile as usual
+//	refuse  (-refuse-unmanaged) log them and abort the run
+
+// Handling of pre-existing unmanaged records
+const (
+	unmanagedWarn   = "warn"
+	unmanagedAdopt  = "adopt"
+	unmanagedRefuse = "refuse"
+)
+
+// unmanagedRecord is a pre-existing record at a managed name and type
+type unmanagedRecord struct {
+	Class  string // Domain class of the name
+	Record CFRecord
+}
+
+// ownHeartbeatClass reports whether a domain class carries its own heartbeat; all other
+// domains are covered by the host heartbeat
+func ownHeartbeatClass(class string) bool {
+	return class == "internal" || strings.HasPrefix(class, "ipv4_range_") || strings.HasPrefix(class, "ipv6_range_")
+}
+
+// findUnmanagedRecords returns the address and alias records at managed names that
+// dynipupdate has not published: neither the name nor (for domains covered by the host
+// heartbeat) the host heartbeat domain has a heartbeat, and the record does not carry
+// the managed-by tag
+func findUnmanagedRecords(cf providerClient, config *Config) []unmanagedRecord {
+	heartbeats := make(map[string]bool) // heartbeat name -> heartbeat exists
+	hasHeartbeat := func(name string) bool {
+		if exists, ok := heartbeats[name]; ok {
+			return exists
+		}
+		records, err := cf.getAllRecords(name, "TXT")
+		if err != nil {
+			// Unknown: do not treat the records as unmanaged because of a failed lookup
+			log.Printf("Error: %v", err)
+			return true
+		}
+		heartbeats[name] = false
+		for _, r := range records {
+			if _, ok := parseHeartbeat(r.Content); ok {
+				heartbeats[name] = true
+			}
+		}
+		return heartbeats[name]
+	}
+
+	var found []unmanagedRecord
+	for _, m := range managedRecords(config) {
+		if m.Role != "address" && m.Role != "alias" {
+			continue
+		}
+		records, err := cf.getAllRecords(m.Name, m.Type)
+		if err != nil {
+			log.Printf("Error: %v", err)
+			continue
+		}
+		if len(records) == 0 {
+			continue
+		}
+		// A heartbeat at the name itself also counts, so moving the host heartbeat
+		// (e.g. by adding TOP_LEVEL_DOMAIN) does not disown existing records
+		if hasHeartbeat(heartbeatRecordName(m.Name)) ||
+			(!ownHeartbeatClass(m.Class) && hasHeartbeat(heartbeatRecordName(hostHeartbeatDomain(config)))) {
+			continue
+		}
+		for _, r := range records {
+			if !slices.Contains(r.Tags, managedByTag) {
+				found = append(found, unmanagedRecord{Class: m.Class, Record: r})
+			}
+		}
+	}
+	return found
+}
+
+// handleUnmanagedRecords applies the configured handling to pre-existing unmanaged
+// records. It returns false if the run must be aborted (refuse mode).
+func handleUnmanagedRecords(cf providerClient, config *Config, mode string) bool {
+	found := findUnmanagedRecords(cf, config)
+	if len(found) == 0 {
+		return true
+	}
+
+	switch mode {
+	case unmanagedRefuse:
+		for _, u := range found {
+			log.Printf("ERROR: %s record %s (%s) was not created by dynipupdate", u.Record.Type, u.Record.Name, u.Record.Content)
+		}
+		log.Printf("ERROR: Refusing to update %d pre-existing record(s) - delete them or run with -adopt to manage them", len(found))
+		return false
+
+	case unmanagedAdopt:
+		for _, u := range found {
+			log.Printf("Adopting %s record %s (%s)", u.Record.Type, u.Record.Name, u.Record.Content)
+			// Rewriting the record attaches the tags; without tags the heartbeat marks it as managed
+			if len(config.RecordTags) > 0 {
+				succeeded(cf.updateRecord(u.Record.ID, u.Record.Name, u.Record.Type, u.Record.Content, config.proxiedFor(u.Record.Name, u.Record.Content)))
+			}
+		}
+		return true
+	}
+
+	skipped := make(map[string]bool)
+	for _, u := range found {
+		log.Printf("WARNING: %s record %s (%s) was not created by dynipupdate - leaving %s alone (run with -adopt to manage it)", u.Record.Type, u.Record.Name, u.Record.Content, u.Record.Name)
+		skipped[normalizeName(u.Record.Name)] = true
+	}
+	retainDomains(config, func(class, domain string) bool {
+		return !skipped[domain]
+	})
+	return true
+}
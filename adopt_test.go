@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindUnmanagedRecords(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	config := &Config{InternalDomain: "nas.i.example.com", CombinedDomain: "nas.example.com", ExternalDomain: "nas.e.example.com"}
+
+	// Hand-made records on a name that has never had a heartbeat
+	fake.add("A", "nas.i.example.com", "192.168.1.50")
+	fake.add("A", "nas.example.com", "203.0.113.9")
+	found := findUnmanagedRecords(cf, config)
+	if len(found) != 2 || found[0].Record.Name != "nas.example.com" || found[0].Class != "combined" || found[1].Class != "internal" {
+		t.Fatalf("found = %+v", found)
+	}
+
+	// Heartbeats mark names as published by dynipupdate: the internal domain has its own,
+	// the combined and external domains share the host heartbeat
+	fake.add("TXT", "nas.i.example.com", `"1700000000"`)
+	fake.add("TXT", "nas.example.com", `"1700000000"`)
+	fake.add("A", "nas.e.example.com", "203.0.113.9")
+	if found := findUnmanagedRecords(cf, config); len(found) != 0 {
+		t.Errorf("records under heartbeats reported: %+v", found)
+	}
+
+	// Moving the host heartbeat to a new top-level domain keeps the combined records owned
+	config.TopLevelDomain = "example.com"
+	if found := findUnmanagedRecords(cf, config); len(found) != 1 || found[0].Record.Name != "nas.e.example.com" {
+		t.Errorf("after adding a top-level domain: %+v", found)
+	}
+}
+
+func TestUnmanagedRecordTags(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	config := &Config{InternalDomain: "nas.example.com"}
+
+	// A TXT record that is not a heartbeat does not count, the managed-by tag does
+	fake.add("TXT", "nas.example.com", `"v=spf1 -all"`)
+	id := fake.add("A", "nas.example.com", "192.168.1.50")
+	if found := findUnmanagedRecords(cf, config); len(found) != 1 {
+		t.Fatalf("found = %+v (a non-heartbeat TXT record must not count)", found)
+	}
+
+	r := fake.records[id]
+	r.Tags = []string{"host:nas", managedByTag}
+	fake.records[id] = r
+	if found := findUnmanagedRecords(cf, config); len(found) != 0 {
+		t.Errorf("tagged record reported: %+v", found)
+	}
+}
+
+func TestHandleUnmanagedRecords(t *testing.T) {
+	newHost := func(t *testing.T) (*fakeCloudFlare, *CloudFlareClient, *Config) {
+		fake, cf := newFakeCloudFlare(t)
+		fake.add("A", "nas.example.com", "192.168.1.50")
+		return fake, cf, &Config{InternalDomain: "nas.example.com", ExternalDomain: "nas.e.example.com", RecordTTL: 120, HeartbeatTTL: 3600}
+	}
+	ips := &IPAddresses{InternalIPv4: []string{"192.168.1.10"}, ExternalIPv4: "203.0.113.1"}
+
+	t.Run("warn", func(t *testing.T) {
+		fake, cf, config := newHost(t)
+		if !handleUnmanagedRecords(cf, config, unmanagedWarn) {
+			t.Fatal("warn mode aborted")
+		}
+		if config.InternalDomain != "" || config.ExternalDomain != "nas.e.example.com" {
+			t.Errorf("domains = %q, %q; want only the internal domain left alone", config.InternalDomain, config.ExternalDomain)
+		}
+		reconcileRecords(cf, config, ips, config.now())
+		if got := fake.contents("nas.example.com", "A"); !reflect.DeepEqual(got, []string{"192.168.1.50"}) {
+			t.Errorf("unmanaged record changed: %v", got)
+		}
+	})
+
+	t.Run("refuse", func(t *testing.T) {
+		_, cf, config := newHost(t)
+		if handleUnmanagedRecords(cf, config, unmanagedRefuse) {
+			t.Error("refuse mode did not abort")
+		}
+	})
+
+	t.Run("adopt", func(t *testing.T) {
+		fake, cf, config := newHost(t)
+		config.RecordTags = []string{"host:nas", managedByTag}
+		cf.Tags = config.RecordTags
+		if !handleUnmanagedRecords(cf, config, unmanagedAdopt) || config.InternalDomain == "" {
+			t.Fatal("adopt mode did not keep the domain")
+		}
+		if got := fake.records["rec1"].Tags; !reflect.DeepEqual(got, config.RecordTags) {
+			t.Errorf("adopted record tags = %v", got)
+		}
+
+		// Adopted records are reconciled as usual, and are not reported again
+		reconcileRecords(cf, config, ips, config.now())
+		if got := fake.contents("nas.example.com", "A"); !reflect.DeepEqual(got, []string{"192.168.1.10"}) {
+			t.Errorf("records after reconcile = %v", got)
+		}
+		if found := findUnmanagedRecords(cf, config); len(found) != 0 {
+			t.Errorf("found after adoption = %+v", found)
+		}
+	})
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Audit log polling (CloudFlare only, daemon mode) watches the account audit log for DNS
+// changes to managed records made by anyone but this updater's API token - a colleague in
+// the dashboard, another tool, a compromised token - and reports each one through the
+// notifier. The updater repairs address records on its next cycle anyway; the point is to
+// learn that someone else is touching them.
+
+// auditLogPageSize is the number of audit log entries requested per page
+const auditLogPageSize = 1000
+
+// cfAuditEntry is one account audit log entry (the fields used here)
+type cfAuditEntry struct {
+	ID     string `json:"id"`
+	Action struct {
+		Time   string `json:"time"`
+		Type   string `json:"type"`   // create, update or delete
+		Result string `json:"result"` // success or failure
+	} `json:"action"`
+	Actor struct {
+		Email     string `json:"email"`
+		Context   string `json:"context"` // api_token, api_key, dash or oauth
+		TokenID   string `json:"token_id"`
+		TokenName string `json:"token_name"`
+	} `json:"actor"`
+	Resource struct {
+		Product  string          `json:"product"` // dns_records for DNS record changes
+		Request  json.RawMessage `json:"request"`
+		Response json.RawMessage `json:"response"`
+	} `json:"resource"`
+	Zone struct {
+		ID string `json:"id"`
+	} `json:"zone"`
+}
+
+type cfAuditResponse struct {
+	Success    bool              `json:"success"`
+	Errors     []json.RawMessage `json:"errors"`
+	Result     []cfAuditEntry    `json:"result"`
+	ResultInfo struct {
+		Cursor string `json:"cursor"`
+	} `json:"result_info"`
+}
+
+// record returns the DNS record an entry changed, from the response or else the request
+func (e *cfAuditEntry) record() CFRecord {
+	var record CFRecord
+	for _, raw := range []json.RawMessage{e.Resource.Response, e.Resource.Request} {
+		if len(raw) > 0 && json.Unmarshal(raw, &record) == nil && record.Name != "" {
+			return record
+		}
+	}
+	return CFRecord{}
+}
+
+// auditLogWatcher reports external changes to managed records found in the audit log
+type auditLogWatcher struct {
+	cf        *CloudFlareClient
+	accountID string
+	notifier  Notifier
+	now       func() time.Time
+	managed   map[string]bool // dynDNS2Key(name, type) of managed records
+
+	tokenID string          // ID of our own API token, looked up on the first poll
+	since   time.Time       // Start of the next poll window
+	seen    map[string]bool // Entries reported in the last window (windows overlap by a second)
+}
+
+func newAuditLogWatcher(cf *CloudFlareClient, config *Config, notifier Notifier) *auditLogWatcher {
+	w := &auditLogWatcher{
+		cf:        cf,
+		accountID: config.CFAccountID,
+		notifier:  notifier,
+		now:       config.now,
+		managed:   make(map[string]bool),
+		seen:      make(map[string]bool),
+	}
+	for _, r := range managedRecords(config) {
+		w.managed[dynDNS2Key(r.Name, r.Type)] = true
+	}
+	w.since = w.now()
+	return w
+}
+
+// ownTokenID returns the ID of the API token the updater authenticates with
+func (w *auditLogWatcher) ownTokenID() (string, error) {
+	if w.tokenID != "" {
+		return w.tokenID, nil
+	}
+	resp, err := w.cf.makeRequest("GET", "/user/tokens/verify", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool              `json:"success"`
+		Errors  []json.RawMessage `json:"errors"`
+		Result  struct {
+			ID string `json:"id"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decoding token details: %w", err)
+	}
+	if !result.Success || result.Result.ID == "" {
+		return "", fmt.Errorf("looking up the API token: %s", formatErrors(result.Errors))
+	}
+	w.tokenID = result.Result.ID
+	return w.tokenID, nil
+}
+
+// fetch returns the audit log entries between since and before, following the cursor
+func (w *auditLogWatcher) fetch(since, before time.Time) ([]cfAuditEntry, error) {
+	var entries []cfAuditEntry
+	cursor := ""
+	for {
+		query := url.Values{}
+		query.Set("since", since.UTC().Format(time.RFC3339))
+		query.Set("before", before.UTC().Format(time.RFC3339))
+		query.Set("resource_product", "dns_records")
+		query.Set("direction", "asc")
+		query.Set("limit", fmt.Sprint(auditLogPageSize))
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		resp, err := w.cf.makeRequest("GET", fmt.Sprintf("/accounts/%s/logs/audit?%s", w.accountID, query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		var result cfAuditResponse
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding audit log: %w", err)
+		}
+		if !result.Success {
+			return nil, fmt.Errorf("reading audit log: %s", formatErrors(result.Errors))
+		}
+
+		entries = append(entries, result.Result...)
+		cursor = result.ResultInfo.Cursor
+		if cursor == "" || len(result.Result) == 0 {
+			return entries, nil
+		}
+	}
+}
+
+// poll reports external changes made since the previous poll
+func (w *auditLogWatcher) poll() error {
+	tokenID, err := w.ownTokenID()
+	if err != nil {
+		return err
+	}
+
+	// Overlap windows by a second, as entries are timestamped to the second
+	since, before := w.since.Add(-time.Second), w.now()
+	entries, err := w.fetch(since, before)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for i := range entries {
+		entry := &entries[i]
+		if w.seen[entry.ID] {
+			seen[entry.ID] = true
+			continue
+		}
+		if entry.Actor.TokenID == tokenID || entry.Action.Result == "failure" ||
+			(entry.Zone.ID != "" && !w.cf.hasZone(entry.Zone.ID)) {
+			continue
+		}
+		record := entry.record()
+		name := strings.TrimSuffix(record.Name, ".")
+		if !w.managed[dynDNS2Key(name, record.Type)] {
+			continue
+		}
+
+		subject := fmt.Sprintf("DNS record %s (%s) was changed by someone else", name, record.Type)
+		log.Printf("WARNING: %s", subject)
+		if err := w.notifier.Notify("external-change", subject, formatAuditEntry(entry, record)); err != nil {
+			log.Printf("Failed to send external change notification: %v", err)
+		}
+		seen[entry.ID] = true
+	}
+
+	w.seen = seen
+	w.since = before
+	return nil
+}
+
+// formatAuditEntry describes an external change for a notification
+func formatAuditEntry(entry *cfAuditEntry, record CFRecord) string {
+	actor := entry.Actor.Email
+	if actor == "" {
+		actor = "unknown actor"
+	}
+	switch {
+	case entry.Actor.TokenName != "":
+		actor += fmt.Sprintf(" (API token %q)", entry.Actor.TokenName)
+	case entry.Actor.Context != "":
+		actor += fmt.Sprintf(" (%s)", entry.Actor.Context)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Action: %s %s record %s\n", entry.Action.Type, record.Type, strings.TrimSuffix(record.Name, "."))
+	if record.Content != "" {
+		fmt.Fprintf(&b, "Content: %s\n", record.Content)
+	}
+	fmt.Fprintf(&b, "By: %s\n", actor)
+	fmt.Fprintf(&b, "At: %s\n", entry.Action.Time)
+	return b.String()
+}
+
+// newAuditLogJob returns the audit log job for the daemon scheduler
+func newAuditLogJob(cf *CloudFlareClient, config *Config, notifier Notifier) func() time.Duration {
+	w := newAuditLogWatcher(cf, config, notifier)
+	return func() time.Duration {
+		if err := w.poll(); err != nil {
+			log.Printf("WARNING: Audit log poll failed: %v", err)
+		}
+		return time.Duration(config.AuditLogInterval) * time.Second
+	}
+}
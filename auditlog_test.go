@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// auditEntry builds an audit log entry for a DNS record change
+func auditEntry(id, at, tokenID, action, name, recordType, content string) cfAuditEntry {
+	var e cfAuditEntry
+	e.ID = id
+	e.Action.Time, e.Action.Type, e.Action.Result = at, action, "success"
+	e.Actor.Email, e.Actor.Context, e.Actor.TokenID = "someone@example.com", "dash", tokenID
+	if tokenID != "" {
+		e.Actor.Context = "api_token"
+	}
+	e.Resource.Product = "dns_records"
+	e.Resource.Response, _ = json.Marshal(CFRecord{Name: name, Type: recordType, Content: content})
+	e.Zone.ID = "zone"
+	return e
+}
+
+func TestAuditLogWatcherReportsExternalChanges(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	clock := newFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	config := &Config{CFAccountID: "acct", ExternalDomain: "home.example.com", InternalDomain: "nas.example.com", clock: clock}
+	notifier := &recordingNotifier{}
+	w := newAuditLogWatcher(cf, config, notifier)
+
+	fake.audit = []cfAuditEntry{
+		auditEntry("before-start", "2024-05-01T11:00:00Z", "", "update", "home.example.com", "A", "198.51.100.9"),
+		auditEntry("ours", "2024-05-01T12:01:00Z", "own-token", "update", "home.example.com", "A", "203.0.113.2"),
+		auditEntry("dashboard", "2024-05-01T12:02:00Z", "", "update", "home.example.com", "A", "198.51.100.1"),
+		auditEntry("unmanaged", "2024-05-01T12:03:00Z", "", "create", "www.example.com", "A", "198.51.100.2"),
+		auditEntry("other-token", "2024-05-01T12:04:00Z", "other-token", "create", "nas.example.com.", "TXT", "\"hi\""),
+		auditEntry("boundary", "2024-05-01T12:05:00Z", "", "delete", "home.example.com", "A", "198.51.100.1"),
+	}
+	other := auditEntry("other-zone", "2024-05-01T12:04:00Z", "", "update", "home.example.com", "A", "198.51.100.3")
+	other.Zone.ID = "elsewhere"
+	failed := auditEntry("failed", "2024-05-01T12:04:00Z", "", "delete", "home.example.com", "A", "")
+	failed.Action.Result = "failure"
+	fake.audit = append(fake.audit, other, failed)
+
+	clock.advance(5 * time.Minute)
+	if err := w.poll(); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	want := []string{
+		"external-change: DNS record home.example.com (A) was changed by someone else",
+		"external-change: DNS record nas.example.com (TXT) was changed by someone else",
+		"external-change: DNS record home.example.com (A) was changed by someone else",
+	}
+	if strings.Join(notifier.events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events = %q, want %q", notifier.events, want)
+	}
+
+	// Entries at the window boundary are not reported twice; later ones are
+	clock.advance(time.Minute)
+	if err := w.poll(); err != nil || len(notifier.events) != 3 {
+		t.Errorf("second poll: err %v, events %q", err, notifier.events)
+	}
+	fake.audit = append(fake.audit, auditEntry("later", "2024-05-01T12:06:30Z", "", "create", "home.example.com", "A", "198.51.100.4"))
+	clock.advance(time.Minute)
+	if err := w.poll(); err != nil || len(notifier.events) != 4 {
+		t.Errorf("third poll: err %v, events %q", err, notifier.events)
+	}
+}
+
+func TestFormatAuditEntry(t *testing.T) {
+	entry := auditEntry("1", "2024-05-01T12:02:00Z", "t1", "update", "home.example.com", "A", "198.51.100.1")
+	entry.Actor.TokenName = "terraform"
+	got := formatAuditEntry(&entry, entry.record())
+	want := "Action: update A record home.example.com\nContent: 198.51.100.1\n" +
+		"By: someone@example.com (API token \"terraform\")\nAt: 2024-05-01T12:02:00Z\n"
+	if got != want {
+		t.Errorf("formatAuditEntry =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAuditLogPermission(t *testing.T) {
+	reqs := requiredTokenPermissions(&Config{CFZoneID: "zone", AuditLogInterval: 300}, false)
+	if len(reqs.Permissions) != 2 || reqs.Permissions[1].Scope != "Account" || reqs.Permissions[1].Level != "Read" {
+		t.Errorf("permissions = %+v", reqs.Permissions)
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsCredentials are the keys used to sign AWS requests
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // Zero for long-lived credentials
+	Source          string    // Where the credentials came from (for logging)
+}
+
+// awsCredentialSource is one step of the credential chain; it returns (nil, nil) if not applicable
+type awsCredentialSource func(client *http.Client) (*awsCredentials, error)
+
+// awsCredentialChain resolves credentials like the AWS SDKs: environment variables, web identity
+// (EKS/IRSA), the shared credentials file, ECS container credentials and finally EC2 instance
+// metadata. Resolved credentials are cached until shortly before they expire.
+type awsCredentialChain struct {
+	sources []awsCredentialSource
+	client  *http.Client
+
+	mu     sync.Mutex
+	cached *awsCredentials
+}
+
+func newAWSCredentialChain() *awsCredentialChain {
+	return &awsCredentialChain{
+		sources: []awsCredentialSource{
+			awsEnvCredentials,
+			awsWebIdentityCredentials,
+			awsSharedFileCredentials,
+			awsContainerCredentials,
+			awsInstanceCredentials,
+		},
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// get returns valid credentials, resolving them again when the cached ones are about to expire
+func (c *awsCredentialChain) get() (*awsCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cached != nil && (c.cached.Expires.IsZero() || time.Until(c.cached.Expires) > 5*time.Minute) {
+		return c.cached, nil
+	}
+
+	var errs []string
+	for _, source := range c.sources {
+		creds, err := source(c.client)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if creds != nil {
+			c.cached = creds
+			return creds, nil
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("no AWS credentials found (%s)", strings.Join(errs, "; "))
+	}
+	return nil, errors.New("no AWS credentials found (set AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY, configure ~/.aws/credentials, or run with an instance/task role)")
+}
+
+// awsEnvCredentials reads AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY / AWS_SESSION_TOKEN
+func awsEnvCredentials(*http.Client) (*awsCredentials, error) {
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return nil, nil
+	}
+	return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN"), Source: "environment"}, nil
+}
+
+// awsSharedFileCredentials reads the profile (AWS_PROFILE, default "default") from the shared credentials file
+func awsSharedFileCredentials(*http.Client) (*awsCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values, err := parseINISection(f, profile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if values["aws_access_key_id"] == "" || values["aws_secret_access_key"] == "" {
+		return nil, nil
+	}
+	return &awsCredentials{
+		AccessKeyID:     values["aws_access_key_id"],
+		SecretAccessKey: values["aws_secret_access_key"],
+		SessionToken:    values["aws_session_token"],
+		Source:          fmt.Sprintf("%s [%s]", path, profile),
+	}, nil
+}
+
+// parseINISection returns the key/value pairs of one section of an INI file
+func parseINISection(r io.Reader, section string) (map[string]string, error) {
+	values := make(map[string]string)
+	current := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if current != section {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// awsTemporaryCredentials is the JSON shape returned by the ECS and EC2 credential endpoints
+type awsTemporaryCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+func (t awsTemporaryCredentials) credentials(source string) (*awsCredentials, error) {
+	if t.AccessKeyID == "" || t.SecretAccessKey == "" {
+		return nil, fmt.Errorf("%s returned incomplete credentials", source)
+	}
+	expires, _ := time.Parse(time.RFC3339, t.Expiration)
+	return &awsCredentials{AccessKeyID: t.AccessKeyID, SecretAccessKey: t.SecretAccessKey, SessionToken: t.Token, Expires: expires, Source: source}, nil
+}
+
+// awsContainerCredentials reads ECS task role credentials
+func awsContainerCredentials(client *http.Client) (*awsCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = "http://169.254.170.2" + relative
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	var creds awsTemporaryCredentials
+	if err := getJSON(client, req, &creds); err != nil {
+		return nil, fmt.Errorf("container credentials: %w", err)
+	}
+	return creds.credentials("container role")
+}
+
+// awsInstanceCredentials reads EC2 instance role credentials via IMDSv2
+func awsInstanceCredentials(client *http.Client) (*awsCredentials, error) {
+	if strings.ToLower(os.Getenv("AWS_EC2_METADATA_DISABLED")) == "true" {
+		return nil, nil
+	}
+	base := "http://169.254.169.254/latest"
+	if endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"); endpoint != "" {
+		base = strings.TrimSuffix(endpoint, "/") + "/latest"
+	}
+	// The metadata service only exists on EC2 - don't wait long elsewhere
+	imds := &http.Client{Timeout: time.Second}
+
+	tokenReq, err := http.NewRequest("PUT", base+"/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	tokenReq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	resp, err := imds.Do(tokenReq)
+	if err != nil {
+		return nil, nil // Not on EC2
+	}
+	tokenBytes, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	token := string(tokenBytes)
+
+	metadata := func(path string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", base+"/meta-data/iam/security-credentials/"+path, nil)
+		if err == nil {
+			req.Header.Set("X-aws-ec2-metadata-token", token)
+		}
+		return req, err
+	}
+
+	req, err := metadata("")
+	if err != nil {
+		return nil, err
+	}
+	resp, err = imds.Do(req)
+	if err != nil {
+		return nil, nil
+	}
+	roleBytes, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	role := strings.TrimSpace(strings.SplitN(string(roleBytes), "\n", 2)[0])
+	if resp.StatusCode != http.StatusOK || role == "" {
+		return nil, nil // No instance role attached
+	}
+
+	if req, err = metadata(role); err != nil {
+		return nil, err
+	}
+	var creds awsTemporaryCredentials
+	if err := getJSON(imds, req, &creds); err != nil {
+		return nil, fmt.Errorf("instance credentials: %w", err)
+	}
+	return creds.credentials("instance role " + role)
+}
+
+// awsWebIdentityCredentials exchanges a web identity token (EKS IRSA) for credentials via STS
+func awsWebIdentityCredentials(client *http.Client) (*awsCredentials, error) {
+	tokenFile, roleARN := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN")
+	if tokenFile == "" || roleARN == "" {
+		return nil, nil
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("web identity token: %w", err)
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "dynipupdate"
+	}
+
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	resp, err := client.PostForm("https://sts.amazonaws.com/", form)
+	if err != nil {
+		return nil, fmt.Errorf("web identity: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+			Expiration      string `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("web identity: status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("web identity: %w", err)
+	}
+	c := result.Credentials
+	return awsTemporaryCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, Token: c.SessionToken, Expiration: c.Expiration}.
+		credentials("web identity " + roleARN)
+}
+
+// getJSON performs req and decodes a JSON response
+func getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// AWS Signature Version 4
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes s as required by SigV4 (RFC 3986 unreserved characters are kept)
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signAWSRequestV4 adds SigV4 authentication headers to req. payload must be the request body.
+func signAWSRequestV4(req *http.Request, payload []byte, creds *awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// Canonical headers: host plus every x-amz-* header and content-type, sorted by name
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// Canonical query string: sorted by key, then value
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = awsURIEncode(unescaped, false)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.Join(pairs, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(payload),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// AWS SigV4 test suite: get-vanilla and get-vanilla-query-order-key-case
+func TestSignAWSRequestV4(t *testing.T) {
+	creds := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		url, signature string
+	}{
+		{"https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		signAWSRequestV4(req, nil, creds, "us-east-1", "service", now)
+
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + tt.signature
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.url, got, want)
+		}
+	}
+}
+
+func TestAWSSharedFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	os.WriteFile(path, []byte("[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = s1\n\n[dns]\n# comment\naws_access_key_id=AKIDDNS\naws_secret_access_key=s2\naws_session_token=tok\n"), 0o600)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+	t.Setenv("AWS_PROFILE", "dns")
+
+	creds, err := awsSharedFileCredentials(nil)
+	if err != nil || creds == nil {
+		t.Fatalf("awsSharedFileCredentials = %v, %v", creds, err)
+	}
+	if creds.AccessKeyID != "AKIDDNS" || creds.SecretAccessKey != "s2" || creds.SessionToken != "tok" {
+		t.Errorf("wrong profile read: %+v", creds)
+	}
+	if !strings.Contains(creds.Source, "[dns]") {
+		t.Errorf("Source = %q", creds.Source)
+	}
+}
+
+func TestAWSCredentialChainOrderAndCache(t *testing.T) {
+	calls := 0
+	chain := &awsCredentialChain{sources: []awsCredentialSource{
+		func(*http.Client) (*awsCredentials, error) { return nil, nil },
+		func(*http.Client) (*awsCredentials, error) {
+			calls++
+			return &awsCredentials{AccessKeyID: "second", Expires: time.Now().Add(time.Hour)}, nil
+		},
+		func(*http.Client) (*awsCredentials, error) {
+			t.Error("later sources must not be consulted")
+			return nil, nil
+		},
+	}}
+
+	for i := 0; i < 2; i++ {
+		creds, err := chain.get()
+		if err != nil || creds.AccessKeyID != "second" {
+			t.Fatalf("get = %v, %v", creds, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("credentials resolved %d times, want 1 (cached)", calls)
+	}
+
+	// Credentials close to expiry are refreshed
+	chain.cached.Expires = time.Now().Add(time.Minute)
+	chain.get()
+	if calls != 2 {
+		t.Errorf("expiring credentials not refreshed")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// azureManagementScope is the resource that Azure DNS access tokens are requested for
+const azureManagementScope = "https://management.azure.com/"
+
+// azureToken is an Azure AD access token for the management API
+type azureToken struct {
+	AccessToken string
+	Expires     time.Time
+	Source      string // Where the token came from (for logging)
+}
+
+// azureTokenSource is one step of the credential chain; it returns (nil, nil) if not applicable
+type azureTokenSource func(client *http.Client) (*azureToken, error)
+
+// azureCredentialChain obtains access tokens the way the Azure SDKs' DefaultAzureCredential
+// does: a service principal secret from the environment, then workload identity (AKS),
+// then managed identity (App Service / Container Apps, then VM instance metadata).
+// Tokens are cached until shortly before they expire.
+type azureCredentialChain struct {
+	sources []azureTokenSource
+	client  *http.Client
+
+	mu     sync.Mutex
+	cached *azureToken
+}
+
+func newAzureCredentialChain() *azureCredentialChain {
+	return &azureCredentialChain{
+		sources: []azureTokenSource{
+			azureClientSecretToken,
+			azureWorkloadIdentityToken,
+			azureManagedIdentityToken,
+		},
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// get returns a valid token, requesting a new one when the cached one is about to expire
+func (c *azureCredentialChain) get() (*azureToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cached != nil && time.Until(c.cached.Expires) > 5*time.Minute {
+		return c.cached, nil
+	}
+
+	var errs []string
+	for _, source := range c.sources {
+		token, err := source(c.client)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if token != nil {
+			c.cached = token
+			return token, nil
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("no Azure credentials found (%s)", strings.Join(errs, "; "))
+	}
+	return nil, errors.New("no Azure credentials found (set AZURE_TENANT_ID/AZURE_CLIENT_ID/AZURE_CLIENT_SECRET, or run with a managed or workload identity)")
+}
+
+// azureTokenResponse is the token endpoint response. Managed identity endpoints return
+// expires_in as a string, Azure AD as a number, so it is decoded via flexibleInt.
+type azureTokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	ExpiresIn   flexibleInt `json:"expires_in"`
+}
+
+// flexibleInt decodes a JSON number or a string containing one
+type flexibleInt int64
+
+func (f *flexibleInt) UnmarshalJSON(data []byte) error {
+	n, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", data)
+	}
+	*f = flexibleInt(n)
+	return nil
+}
+
+func (r azureTokenResponse) token(source string) (*azureToken, error) {
+	if r.AccessToken == "" {
+		return nil, fmt.Errorf("%s: response contained no access token", source)
+	}
+	return &azureToken{AccessToken: r.AccessToken, Expires: time.Now().Add(time.Duration(r.ExpiresIn) * time.Second), Source: source}, nil
+}
+
+// azureAuthorityHost returns the Azure AD endpoint (AZURE_AUTHORITY_HOST for sovereign clouds)
+func azureAuthorityHost() string {
+	if host := os.Getenv("AZURE_AUTHORITY_HOST"); host != "" {
+		return strings.TrimSuffix(host, "/")
+	}
+	return "https://login.microsoftonline.com"
+}
+
+// requestAzureADToken performs a client credentials grant with the given form values
+func requestAzureADToken(client *http.Client, tenant string, form url.Values, source string) (*azureToken, error) {
+	form.Set("grant_type", "client_credentials")
+	form.Set("scope", azureManagementScope+".default")
+
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", azureAuthorityHost(), url.PathEscape(tenant))
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result azureTokenResponse
+	if err := getJSON(client, req, &result); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return result.token(source)
+}
+
+// azureClientSecretToken uses AZURE_TENANT_ID / AZURE_CLIENT_ID / AZURE_CLIENT_SECRET
+func azureClientSecretToken(client *http.Client) (*azureToken, error) {
+	tenant, clientID, secret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET")
+	if tenant == "" || clientID == "" || secret == "" {
+		return nil, nil
+	}
+	return requestAzureADToken(client, tenant, url.Values{"client_id": {clientID}, "client_secret": {secret}}, "service principal "+clientID)
+}
+
+// azureWorkloadIdentityToken exchanges the federated token in AZURE_FEDERATED_TOKEN_FILE (AKS workload identity)
+func azureWorkloadIdentityToken(client *http.Client) (*azureToken, error) {
+	tokenFile, tenant, clientID := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"), os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID")
+	if tokenFile == "" || tenant == "" || clientID == "" {
+		return nil, nil
+	}
+	assertion, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("workload identity: %w", err)
+	}
+	return requestAzureADToken(client, tenant, url.Values{
+		"client_id":             {clientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}, "workload identity "+clientID)
+}
+
+// azureManagedIdentityToken uses the App Service / Container Apps identity endpoint when
+// present, otherwise the VM instance metadata service. AZURE_CLIENT_ID selects a
+// user-assigned identity.
+func azureManagedIdentityToken(client *http.Client) (*azureToken, error) {
+	var req *http.Request
+	var err error
+	query := url.Values{"resource": {azureManagementScope}}
+	if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+		query.Set("client_id", clientID)
+	}
+
+	if endpoint := os.Getenv("IDENTITY_ENDPOINT"); endpoint != "" && os.Getenv("IDENTITY_HEADER") != "" {
+		query.Set("api-version", "2019-08-01")
+		if req, err = http.NewRequest("GET", endpoint+"?"+query.Encode(), nil); err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER"))
+	} else {
+		query.Set("api-version", "2018-02-01")
+		if req, err = http.NewRequest("GET", "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil); err != nil {
+			return nil, err
+		}
+		req.Header.Set("Metadata", "true")
+		// Instance metadata answers within milliseconds on Azure; don't stall elsewhere
+		client = &http.Client{Timeout: time.Second}
+	}
+
+	var result azureTokenResponse
+	if err := getJSON(client, req, &result); err != nil {
+		if req.URL.Host == "169.254.169.254" {
+			return nil, nil // Not running on Azure
+		}
+		return nil, fmt.Errorf("managed identity: %w", err)
+	}
+	return result.token("managed identity")
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const azureDNSAPIVersion = "2018-05-01"
+
+// AzureDNSProvider implements DNSProvider (and the internal record operations) for
+// Azure DNS zones as a recordSetBackend, using the Azure Resource Manager REST API
+type AzureDNSProvider struct {
+	SubscriptionID string
+	ResourceGroup  string
+	ZoneName       string
+	Endpoint       string // Resource Manager endpoint, e.g. https://management.azure.com
+
+	credentials *azureCredentialChain
+	client      *http.Client
+
+	recordSetClient
+}
+
+// Verify AzureDNSProvider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*AzureDNSProvider)(nil)
+var _ DNSProvider = (*AzureDNSProvider)(nil)
+var _ providerClient = (*AzureDNSProvider)(nil)
+
+// Azure DNS API structures
+type azureARecord struct {
+	IPv4Address string `json:"ipv4Address"`
+}
+
+type azureAAAARecord struct {
+	IPv6Address string `json:"ipv6Address"`
+}
+
+type azureCNAMERecord struct {
+	CNAME string `json:"cname"`
+}
+
+type azureTXTRecord struct {
+	Value []string `json:"value"`
+}
+
+type azureRecordSetProperties struct {
+	TTL         int               `json:"TTL"`
+	FQDN        string            `json:"fqdn,omitempty"`
+	ARecords    []azureARecord    `json:"ARecords,omitempty"`
+	AAAARecords []azureAAAARecord `json:"AAAARecords,omitempty"`
+	CNAMERecord *azureCNAMERecord `json:"CNAMERecord,omitempty"`
+	TXTRecords  []azureTXTRecord  `json:"TXTRecords,omitempty"`
+}
+
+type azureRecordSet struct {
+	Name       string                   `json:"name,omitempty"`
+	Properties azureRecordSetProperties `json:"properties"`
+}
+
+type azureRecordSetList struct {
+	Value    []azureRecordSet `json:"value"`
+	NextLink string           `json:"nextLink"`
+}
+
+type azureErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// newAzureDNSProvider creates an Azure DNS provider and obtains a token up front so that
+// missing credentials are reported at startup rather than on the first change
+func newAzureDNSProvider(config *Config) (*AzureDNSProvider, error) {
+	a := &AzureDNSProvider{
+		SubscriptionID: config.AzureSubscriptionID,
+		ResourceGroup:  config.AzureResourceGroup,
+		ZoneName:       strings.ToLower(strings.TrimSuffix(config.AzureDNSZone, ".")),
+		Endpoint:       "https://management.azure.com",
+		credentials:    newAzureCredentialChain(),
+		client:         newProviderHTTPClient(config, providerAzure),
+	}
+	a.recordSetClient = newRecordSetClient(a, config)
+
+	token, err := a.credentials.get()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Using Azure DNS zone %s (resource group %s) with a token from %s", a.ZoneName, a.ResourceGroup, token.Source)
+
+	warnUnsupportedOptions(config, "Azure DNS")
+	return a, nil
+}
+
+// relativeName returns the record set name relative to the zone ("@" for the apex)
+func (a *AzureDNSProvider) relativeName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == a.ZoneName {
+		return "@", nil
+	}
+	if relative, ok := strings.CutSuffix(name, "."+a.ZoneName); ok {
+		return relative, nil
+	}
+	return "", fmt.Errorf("%s is not in zone %s", name, a.ZoneName)
+}
+
+// zoneURL returns the URL of the zone (with any extra path segments appended)
+func (a *AzureDNSProvider) zoneURL(segments ...string) string {
+	path := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/dnsZones/%s",
+		url.PathEscape(a.SubscriptionID), url.PathEscape(a.ResourceGroup), url.PathEscape(a.ZoneName))
+	for _, s := range segments {
+		path += "/" + url.PathEscape(s)
+	}
+	return a.Endpoint + path + "?api-version=" + azureDNSAPIVersion
+}
+
+// request performs an authenticated Resource Manager request
+func (a *AzureDNSProvider) request(method, requestURL string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, requestURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	token, err := a.credentials.get()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	log.Printf("API Request: %s %s", method, req.URL.Path)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	return resp, nil
+}
+
+// azureAPIError describes an unsuccessful Resource Manager response
+func azureAPIError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var errResp azureErrorResponse
+	if json.Unmarshal(data, &errResp) == nil && errResp.Error.Code != "" {
+		return statusError(resp.StatusCode, errResp.Error.Code+": "+errResp.Error.Message)
+	}
+	return statusError(resp.StatusCode, "")
+}
+
+// azureValues extracts record contents from a record set
+func azureValues(recordType string, props azureRecordSetProperties) []string {
+	var values []string
+	switch recordType {
+	case "A":
+		for _, r := range props.ARecords {
+			values = append(values, r.IPv4Address)
+		}
+	case "AAAA":
+		for _, r := range props.AAAARecords {
+			values = append(values, r.IPv6Address)
+		}
+	case "CNAME":
+		if props.CNAMERecord != nil {
+			values = append(values, strings.TrimSuffix(props.CNAMERecord.CNAME, "."))
+		}
+	case "TXT":
+		for _, r := range props.TXTRecords {
+			if len(r.Value) == 1 {
+				values = append(values, r.Value[0])
+			} else {
+				values = append(values, formatTXTStrings(r.Value))
+			}
+		}
+	}
+	return values
+}
+
+// azureProperties builds record set properties holding the given contents
+func azureProperties(recordType string, ttl int, values []string) (azureRecordSetProperties, error) {
+	props := azureRecordSetProperties{TTL: ttl}
+	for _, v := range values {
+		switch recordType {
+		case "A":
+			props.ARecords = append(props.ARecords, azureARecord{IPv4Address: v})
+		case "AAAA":
+			props.AAAARecords = append(props.AAAARecords, azureAAAARecord{IPv6Address: v})
+		case "CNAME":
+			props.CNAMERecord = &azureCNAMERecord{CNAME: v}
+		case "TXT":
+			props.TXTRecords = append(props.TXTRecords, azureTXTRecord{Value: parseTXTStrings(v)})
+		default:
+			return props, fmt.Errorf("record type %s is not supported", recordType)
+		}
+	}
+	return props, nil
+}
+
+// recordSetBackend implementation
+
+func (a *AzureDNSProvider) fetchValues(name, recordType string) ([]string, error) {
+	relative, err := a.relativeName(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.request("GET", a.zoneURL(recordType, relative), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, azureAPIError(resp)
+	}
+	var set azureRecordSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return azureValues(recordType, set.Properties), nil
+}
+
+func (a *AzureDNSProvider) storeValues(name, recordType string, ttl int, values []string) error {
+	relative, err := a.relativeName(name)
+	if err != nil {
+		return err
+	}
+
+	var resp *http.Response
+	if len(values) == 0 {
+		resp, err = a.request("DELETE", a.zoneURL(recordType, relative), nil)
+	} else {
+		props, propsErr := azureProperties(recordType, ttl, values)
+		if propsErr != nil {
+			return propsErr
+		}
+		resp, err = a.request("PUT", a.zoneURL(recordType, relative), azureRecordSet{Properties: props})
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	}
+	return azureAPIError(resp)
+}
+
+func (a *AzureDNSProvider) listRecords(recordType string) ([]CFRecord, error) {
+	records := []CFRecord{}
+	next := a.zoneURL(recordType)
+	for next != "" {
+		resp, err := a.request("GET", next, nil)
+		if err != nil {
+			return records, err
+		}
+		var page azureRecordSetList
+		if resp.StatusCode != http.StatusOK {
+			err = azureAPIError(resp)
+		} else if decodeErr := json.NewDecoder(resp.Body).Decode(&page); decodeErr != nil {
+			err = fmt.Errorf("decoding response: %w", decodeErr)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return records, err
+		}
+
+		for _, set := range page.Value {
+			name := strings.TrimSuffix(set.Properties.FQDN, ".")
+			records = append(records, valueRecords(name, recordType, azureValues(recordType, set.Properties))...)
+		}
+		next = page.NextLink
+	}
+	return records, nil
+}
+
+func (a *AzureDNSProvider) probe() bool {
+	resp, err := a.request("GET", a.zoneURL(), nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
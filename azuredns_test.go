@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAzureDNS is an in-memory Azure DNS record set API used by tests
+type fakeAzureDNS struct {
+	mu     sync.Mutex
+	sets   map[string]azureRecordSetProperties // "<type>/<relative name>" -> properties
+	server *httptest.Server
+}
+
+const fakeAzureZonePath = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/dnsZones/example.com"
+
+func newFakeAzureDNS(t *testing.T) (*fakeAzureDNS, *AzureDNSProvider) {
+	t.Helper()
+	fake := &fakeAzureDNS{sets: make(map[string]azureRecordSetProperties)}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(fake.server.Close)
+
+	chain := &azureCredentialChain{sources: []azureTokenSource{
+		func(*http.Client) (*azureToken, error) {
+			return &azureToken{AccessToken: "test-token", Expires: time.Now().Add(time.Hour), Source: "test"}, nil
+		},
+	}}
+	a := &AzureDNSProvider{
+		SubscriptionID: "sub",
+		ResourceGroup:  "rg",
+		ZoneName:       "example.com",
+		Endpoint:       fake.server.URL,
+		credentials:    chain,
+		client:         fake.server.Client(),
+	}
+	a.recordSetClient = recordSetClient{backend: a, TTL: 120, HeartbeatTTL: 3600}
+	return fake, a
+}
+
+func (f *fakeAzureDNS) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer test-token" || r.URL.Query().Get("api-version") != azureDNSAPIVersion {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, fakeAzureZonePath)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	switch {
+	case path == "" && r.Method == "GET":
+		w.Write([]byte(`{"name":"example.com"}`))
+
+	case len(parts) == 1 && r.Method == "GET":
+		// List one record set per page to exercise nextLink
+		var keys []string
+		for k := range f.sets {
+			if strings.HasPrefix(k, parts[0]+"/") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		skip := len(r.URL.Query().Get("skip"))
+		var page azureRecordSetList
+		if skip < len(keys) {
+			page.Value = []azureRecordSet{{Properties: f.sets[keys[skip]]}}
+			if skip+1 < len(keys) {
+				page.NextLink = fakeAzureNextLink(f.server.URL, r.URL.Path, strings.Repeat("x", skip+1))
+			}
+		}
+		json.NewEncoder(w).Encode(page)
+
+	case len(parts) == 2:
+		key := parts[0] + "/" + parts[1]
+		switch r.Method {
+		case "GET":
+			props, ok := f.sets[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":{"code":"NotFound","message":"The resource record was not found."}}`))
+				return
+			}
+			json.NewEncoder(w).Encode(azureRecordSet{Name: parts[1], Properties: props})
+		case "PUT":
+			var set azureRecordSet
+			if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fqdn := "example.com."
+			if parts[1] != "@" {
+				fqdn = parts[1] + "." + fqdn
+			}
+			set.Properties.FQDN = fqdn
+			f.sets[key] = set.Properties
+			w.WriteHeader(http.StatusCreated)
+		case "DELETE":
+			delete(f.sets, key)
+			w.WriteHeader(http.StatusOK)
+		}
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func fakeAzureNextLink(base, path, skip string) string {
+	return base + path + "?api-version=" + azureDNSAPIVersion + "&skip=" + skip
+}
+
+func TestAzureDNSRecordOperations(t *testing.T) {
+	fake, a := newFakeAzureDNS(t)
+
+	if a.upsertRecord("home.example.com", "A", "203.0.113.1", false) != nil ||
+		a.ensureRecordExists("home.example.com", "A", "203.0.113.2", false) != nil {
+		t.Fatal("create failed")
+	}
+	if got := azureValues("A", fake.sets["A/home"]); !reflect.DeepEqual(got, []string{"203.0.113.1", "203.0.113.2"}) {
+		t.Errorf("A values = %v", got)
+	}
+	if fake.sets["A/home"].TTL != 120 {
+		t.Errorf("TTL = %d", fake.sets["A/home"].TTL)
+	}
+
+	if a.updateRecord("203.0.113.1", "home.example.com", "A", "203.0.113.9", false) != nil {
+		t.Fatal("update failed")
+	}
+	if got := azureValues("A", fake.sets["A/home"]); !reflect.DeepEqual(got, []string{"203.0.113.2", "203.0.113.9"}) {
+		t.Errorf("A values after update = %v", got)
+	}
+
+	a.deleteRecord("203.0.113.2", "home.example.com", "A")
+	a.deleteRecordIfExists("home.example.com", "A")
+	if _, ok := fake.sets["A/home"]; ok {
+		t.Error("record set not deleted")
+	}
+
+	// The zone apex is addressed as "@"
+	a.createRecord("example.com", "CNAME", "all.example.com", false)
+	if record, _ := a.getRecord("example.com", "CNAME"); record == nil || record.Content != "all.example.com" {
+		t.Errorf("apex CNAME = %+v", record)
+	}
+
+	// Names outside the zone are rejected without an API call
+	if a.createRecord("home.example.org", "A", "203.0.113.1", false) == nil {
+		t.Error("created a record outside the zone")
+	}
+}
+
+func TestAzureDNSTXTValues(t *testing.T) {
+	fake, a := newFakeAzureDNS(t)
+
+	a.upsertRecord("home.example.com", "TXT", "heartbeat=1700000000", false)
+	multi := formatTXTStrings([]string{"entry one", "entry two"})
+	a.upsertRecord("_changelog.example.com", "TXT", multi, false)
+
+	if got := fake.sets["TXT/_changelog"].TXTRecords; len(got) != 1 || !reflect.DeepEqual(got[0].Value, []string{"entry one", "entry two"}) {
+		t.Errorf("changelog TXT = %+v", got)
+	}
+	if record, _ := a.getRecord("_changelog.example.com", "TXT"); record == nil || record.Content != multi {
+		t.Errorf("changelog content = %+v", record)
+	}
+
+	records, _ := a.getAllRecordsByType("TXT")
+	var names []string
+	for _, r := range records {
+		names = append(names, r.Name+"="+r.Content)
+	}
+	want := []string{"_changelog.example.com=" + multi, "home.example.com=heartbeat=1700000000"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("getAllRecordsByType = %v, want %v", names, want)
+	}
+}
+
+func TestAzureDNSUnreachable(t *testing.T) {
+	fake, a := newFakeAzureDNS(t)
+	var unreachable []string
+	a.OnUnreachable = func(action, recordType, name, content, recordID string) {
+		unreachable = append(unreachable, action)
+	}
+
+	if !a.probeConnectivity() {
+		t.Error("probeConnectivity failed with the API up")
+	}
+	fake.server.Close()
+	if a.upsertRecord("home.example.com", "A", "203.0.113.1", false) == nil {
+		t.Error("upsert succeeded with the API down")
+	}
+	if !reflect.DeepEqual(unreachable, []string{"create"}) {
+		t.Errorf("unreachable = %v", unreachable)
+	}
+	if a.probeConnectivity() {
+		t.Error("probeConnectivity reported the API as reachable")
+	}
+}
+
+func TestAzureClientSecretToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path != "/tenant/oauth2/v2.0/token" || r.Form.Get("client_secret") != "s3cret" ||
+			r.Form.Get("scope") != "https://management.azure.com/.default" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"token_type":"Bearer","expires_in":3599,"access_token":"tok"}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("AZURE_AUTHORITY_HOST", server.URL)
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_CLIENT_ID", "app")
+	t.Setenv("AZURE_CLIENT_SECRET", "s3cret")
+
+	token, err := azureClientSecretToken(server.Client())
+	if err != nil || token == nil || token.AccessToken != "tok" {
+		t.Fatalf("azureClientSecretToken = %+v, %v", token, err)
+	}
+	if until := time.Until(token.Expires); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("token expires in %s", until)
+	}
+
+	t.Setenv("AZURE_CLIENT_SECRET", "")
+	if token, err := azureClientSecretToken(server.Client()); token != nil || err != nil {
+		t.Errorf("without a secret: %+v, %v", token, err)
+	}
+}
+
+func TestAzureManagedIdentityEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-IDENTITY-HEADER") != "secret-header" || r.URL.Query().Get("resource") != azureManagementScope {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// The identity endpoint returns expires_in as a string
+		w.Write([]byte(`{"access_token":"mi-token","expires_in":"86399"}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("IDENTITY_ENDPOINT", server.URL)
+	t.Setenv("IDENTITY_HEADER", "secret-header")
+	t.Setenv("AZURE_CLIENT_ID", "")
+
+	token, err := azureManagedIdentityToken(server.Client())
+	if err != nil || token == nil || token.AccessToken != "mi-token" {
+		t.Fatalf("azureManagedIdentityToken = %+v, %v", token, err)
+	}
+}
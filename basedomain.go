@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// With BASE_DOMAIN=example.com every host of a fleet can share one configuration: the
+// record names are derived from the host label (the first label of the hostname, or
+// HOST_LABEL), e.g. on host "nas":
+//
+//	nas.int.example.com  internal addresses (INTERNAL_DOMAIN)
+//	nas.ext.example.com  external IPv4 and IPv6 (EXTERNAL_DOMAIN, IPV6_DOMAIN)
+//	nas.example.com      all addresses (COMBINED_DOMAIN)
+//
+// Names that are set explicitly are kept.
+
+// hostLabel turns a hostname into a DNS label: its first label, lowercased. Hostnames
+// that cannot be used as a label are rejected rather than silently rewritten.
+func hostLabel(hostname string) (string, error) {
+	label, _, _ := strings.Cut(strings.TrimSpace(hostname), ".")
+	label = strings.ToLower(label)
+	if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return "", fmt.Errorf("%q is not a valid DNS label", hostname)
+	}
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return "", fmt.Errorf("%q is not a valid DNS label", hostname)
+		}
+	}
+	return label, nil
+}
+
+// applyBaseDomain derives the unset domains of config from base and the host label
+func applyBaseDomain(config *Config, base, host string) {
+	base = strings.Trim(strings.ToLower(base), ".")
+	for _, d := range []struct {
+		field *string
+		name  string
+	}{
+		{&config.InternalDomain, host + ".int." + base},
+		{&config.ExternalDomain, host + ".ext." + base},
+		{&config.IPv6Domain, host + ".ext." + base},
+		{&config.CombinedDomain, host + "." + base},
+	} {
+		if *d.field == "" {
+			*d.field = d.name
+		}
+	}
+	log.Printf("Domains derived from %sBASE_DOMAIN for host %s: internal %s, external %s, IPv6 %s, combined %s",
+		envPrefix, host, config.InternalDomain, config.ExternalDomain, config.IPv6Domain, config.CombinedDomain)
+}
+
+// readBaseDomain applies BASE_DOMAIN, if set, with the label from HOST_LABEL or the hostname
+func readBaseDomain(config *Config) {
+	base := getEnv("BASE_DOMAIN")
+	if base == "" {
+		return
+	}
+	hostname := getEnv("HOST_LABEL")
+	if hostname == "" {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			configFatalf("ERROR: %sBASE_DOMAIN needs the hostname: %v (set %sHOST_LABEL)", envPrefix, err, envPrefix)
+		}
+	}
+	host, err := hostLabel(hostname)
+	if err != nil {
+		configFatalf("ERROR: cannot derive domains from %sBASE_DOMAIN: %v (set %sHOST_LABEL)", envPrefix, err, envPrefix)
+	}
+	applyBaseDomain(config, base, host)
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestHostLabel(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"nas", "nas"},
+		{"NAS-01.lan", "nas-01"},
+		{"web3.example.com", "web3"},
+	}
+	for _, tt := range tests {
+		if got, err := hostLabel(tt.in); err != nil || got != tt.want {
+			t.Errorf("hostLabel(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "-nas", "nas-", "my_host", "höst", ".lan"} {
+		if got, err := hostLabel(bad); err == nil {
+			t.Errorf("hostLabel(%q) = %q, want an error", bad, got)
+		}
+	}
+}
+
+func TestApplyBaseDomain(t *testing.T) {
+	config := &Config{ExternalDomain: "public.example.org"}
+	applyBaseDomain(config, "Example.com.", "nas")
+
+	for _, tt := range []struct{ name, got, want string }{
+		{"internal", config.InternalDomain, "nas.int.example.com"},
+		{"external (explicit)", config.ExternalDomain, "public.example.org"},
+		{"IPv6", config.IPv6Domain, "nas.ext.example.com"},
+		{"combined", config.CombinedDomain, "nas.example.com"},
+		{"top level", config.TopLevelDomain, ""},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s domain = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestReadBaseDomain(t *testing.T) {
+	t.Setenv(envPrefix+"BASE_DOMAIN", "example.com")
+	t.Setenv(envPrefix+"HOST_LABEL", "Laptop")
+	config := &Config{}
+	readBaseDomain(config)
+	if config.CombinedDomain != "laptop.example.com" {
+		t.Errorf("combined domain = %q", config.CombinedDomain)
+	}
+
+	t.Setenv(envPrefix+"BASE_DOMAIN", "")
+	unset := &Config{}
+	readBaseDomain(unset)
+	if unset.CombinedDomain != "" {
+		t.Errorf("domains derived without BASE_DOMAIN: %+v", unset)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+)
+
+// Large configurations (many custom ranges, several addresses each) make dozens of
+// sequential lookups and changes per run when every record is reconciled on its own.
+// Providers that can list a zone's records of a type in a few requests and take changes
+// concurrently (batchProvider) use the batch engine instead: the records of each managed
+// type are fetched once per run, the changes are computed in memory, and creates, updates
+// and deletes are applied by BEES_IP_UPDATE_UPDATE_CONCURRENCY workers, still paced by the
+// provider's rate limiter. Removals run after additions, so a name never goes without
+// records while its addresses change.
+
+// defaultUpdateConcurrency is the default number of changes applied at the same time
+const defaultUpdateConcurrency = 4
+
+// batchProvider is implemented by providers whose methods may be called concurrently and
+// whose getAllRecordsByType lists the whole zone cheaply
+type batchProvider interface {
+	providerClient
+	batchUpdates()
+}
+
+func (cf *CloudFlareClient) batchUpdates() {}
+
+// desiredRecordSet is what one managed name and type should hold after the run
+type desiredRecordSet struct {
+	Name    string
+	Type    string
+	Values  []string // No values removes the records
+	Single  bool     // One record updated in place (upsertRecord) instead of one record per value
+	Label   string   // Describes the records in log messages, e.g. "internal IPv4"
+	TTL     int      // Published TTL to enforce (0 = leave TTLs alone)
+	Proxied bool     // Publish public addresses and aliases proxied (proxiedContent)
+
+	Comments map[string]string // Comments of the records by value (interfaceComments, optional)
+
+	Heartbeat bool // Refresh the name's heartbeat with the values, or delete it without values
+}
+
+// recordOp is one change of a batch
+type recordOp struct {
+	Action   string // "create", "update", "delete", "heartbeat" or "delete-heartbeat"
+	Name     string
+	Type     string
+	Content  string
+	RecordID string
+	Proxied  bool   // Whether created and updated records are proxied
+	Message  string // Logged before the change (optional)
+}
+
+// desiredRecordSets lists the records the configuration manages, in the order the
+// sequential update reconciles them
+func desiredRecordSets(config *Config, ips *IPAddresses) []desiredRecordSet {
+	var sets []desiredRecordSet
+	heartbeat := heartbeatRecordContent(config, config.now())
+	ttl := config.ttlFor
+	proxied := config.proxiedName
+	comments := interfaceComments(config, ips)
+	addMulti := func(domain, recordType string, values []string, label string) {
+		sets = append(sets, desiredRecordSet{Name: domain, Type: recordType, Values: values, Label: label, TTL: ttl(domain), Proxied: proxied(domain), Comments: comments})
+		if len(values) > 0 {
+			sets = append(sets, desiredRecordSet{Name: heartbeatRecordName(domain), Type: "TXT", Values: []string{heartbeat}, Heartbeat: true})
+		} else {
+			sets = append(sets, desiredRecordSet{Name: heartbeatRecordName(domain), Type: "TXT", Heartbeat: true})
+		}
+	}
+	single := func(value string) []string {
+		if value == "" {
+			return nil
+		}
+		return []string{value}
+	}
+
+	if config.InternalDomain != "" {
+		addMulti(config.InternalDomain, "A", ips.InternalIPv4, "internal IPv4")
+	}
+	for _, customRange := range config.CustomIPv4Ranges {
+		addMulti(customRange.Domain, "A", ips.CustomRangeIPs[customRange.Domain], "custom range IPv4")
+	}
+	for _, customRange := range config.CustomIPv6Ranges {
+		addMulti(customRange.Domain, "AAAA", ips.CustomRangeIPs[customRange.Domain], "custom range IPv6")
+	}
+	if config.ExternalDomain != "" {
+		sets = append(sets, desiredRecordSet{Name: config.ExternalDomain, Type: "A", Values: single(ips.ExternalIPv4), Single: true, Label: "external IPv4", TTL: ttl(config.ExternalDomain), Proxied: proxied(config.ExternalDomain)})
+	}
+	if config.IPv6Domain != "" {
+		sets = append(sets, desiredRecordSet{Name: config.IPv6Domain, Type: "AAAA", Values: single(ips.ExternalIPv6), Single: true, Label: "external IPv6", TTL: ttl(config.IPv6Domain), Proxied: proxied(config.IPv6Domain)})
+	}
+	if config.CombinedDomain != "" {
+		allIPv4s := append([]string{}, ips.InternalIPv4...)
+		for _, customRange := range config.CustomIPv4Ranges {
+			allIPv4s = append(allIPv4s, ips.CustomRangeIPs[customRange.Domain]...)
+		}
+		if ips.ExternalIPv4 != "" {
+			allIPv4s = append(allIPv4s, ips.ExternalIPv4)
+		}
+		sets = append(sets,
+			desiredRecordSet{Name: config.CombinedDomain, Type: "A", Values: allIPv4s, Label: "combined domain IPv4", TTL: ttl(config.CombinedDomain), Proxied: proxied(config.CombinedDomain), Comments: comments},
+			desiredRecordSet{Name: config.CombinedDomain, Type: "AAAA", Values: single(ips.ExternalIPv6), Single: true, Label: "combined domain IPv6", TTL: ttl(config.CombinedDomain), Proxied: proxied(config.CombinedDomain)})
+		if config.TopLevelDomain != "" {
+			sets = append(sets, desiredRecordSet{Name: config.TopLevelDomain, Type: "CNAME", Values: []string{config.CombinedDomain}, Single: true, Label: "top-level alias", TTL: ttl(config.TopLevelDomain), Proxied: proxied(config.TopLevelDomain)})
+		}
+	}
+	return append(sets, serviceRecordSets(config)...)
+}
+
+// recordStreamer is implemented by providers that can pass the records of a large zone one
+// at a time, so that a snapshot holds only the managed ones
+type recordStreamer interface {
+	eachRecordByType(recordType string, each func(record *CFRecord)) error
+}
+
+// recordKey identifies the records of one name and type in a snapshot
+type recordKey struct {
+	Name string
+	Type string
+}
+
+// fetchRecordSnapshot lists the records of every type the sets manage, one listing per
+// type, keeping those at managed names
+func fetchRecordSnapshot(cf providerClient, sets []desiredRecordSet) (map[recordKey][]CFRecord, error) {
+	managed := make(map[recordKey]bool, len(sets))
+	var types []string
+	for _, set := range sets {
+		if set.Heartbeat {
+			continue // Heartbeats are refreshed without looking, like in the sequential update
+		}
+		managed[recordKey{normalizeName(set.Name), set.Type}] = true
+		if !slices.Contains(types, set.Type) {
+			types = append(types, set.Type)
+		}
+	}
+
+	snapshot := make(map[recordKey][]CFRecord, len(managed))
+	streamer, streaming := cf.(recordStreamer)
+	for _, recordType := range types {
+		if streaming {
+			// Keep only the managed records instead of listing the whole zone first
+			err := streamer.eachRecordByType(recordType, func(record *CFRecord) {
+				if key := (recordKey{normalizeName(record.Name), record.Type}); managed[key] {
+					snapshot[key] = append(snapshot[key], *record)
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		records, err := cf.getAllRecordsByType(recordType)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			key := recordKey{normalizeName(record.Name), record.Type}
+			if managed[key] {
+				snapshot[key] = append(snapshot[key], record)
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+// planRecordOps computes the changes that bring the snapshot in line with the sets:
+// additions and updates first, then removals. unchanged counts the values already in place.
+func planRecordOps(sets []desiredRecordSet, snapshot map[recordKey][]CFRecord) (adds, removals []recordOp, unchanged int) {
+	for _, set := range sets {
+		existing := snapshot[recordKey{normalizeName(set.Name), set.Type}]
+		switch {
+		case set.Heartbeat && len(set.Values) > 0:
+			adds = append(adds, recordOp{Action: "heartbeat", Name: set.Name, Type: "TXT", Content: set.Values[0]})
+
+		case set.Heartbeat:
+			removals = append(removals, recordOp{Action: "delete-heartbeat", Name: set.Name, Type: "TXT"})
+
+		case set.Single && len(set.Values) > 0:
+			content := set.Values[0]
+			proxied := proxiedContent(set.Proxied, content)
+			switch {
+			case len(existing) == 0:
+				adds = append(adds, recordOp{Action: "create", Name: set.Name, Type: set.Type, Content: content, Proxied: proxied})
+			case sameContent(existing[0].Content, content) && existing[0].Proxied != proxied:
+				adds = append(adds, recordOp{Action: "update", Name: set.Name, Type: set.Type, Content: content, RecordID: existing[0].ID, Proxied: proxied,
+					Message: fmt.Sprintf("Proxied changed for %s record %s: %t -> %t", set.Type, set.Name, existing[0].Proxied, proxied)})
+			case sameContent(existing[0].Content, content) && ttlDiffers(existing[0].TTL, set.TTL, proxied):
+				adds = append(adds, recordOp{Action: "update", Name: set.Name, Type: set.Type, Content: content, RecordID: existing[0].ID, Proxied: proxied,
+					Message: fmt.Sprintf("TTL changed for %s record %s: %d -> %d", set.Type, set.Name, existing[0].TTL, set.TTL)})
+			case sameContent(existing[0].Content, content):
+				log.Printf("No change needed for %s record %s (already %s)", set.Type, set.Name, content)
+				unchanged++
+			default:
+				adds = append(adds, recordOp{Action: "update", Name: set.Name, Type: set.Type, Content: content, RecordID: existing[0].ID, Proxied: proxied,
+					Message: "Content changed for " + set.Type + " record " + set.Name + ": " + existing[0].Content + " -> " + content})
+			}
+
+		case set.Single:
+			if len(existing) > 0 {
+				removals = append(removals, recordOp{Action: "delete", Name: set.Name, Type: set.Type, RecordID: existing[0].ID,
+					Message: "No " + set.Label + " address found - deleting record: " + existing[0].Content})
+			} else {
+				unchanged++
+			}
+
+		default:
+			for i, content := range set.Values {
+				var found *CFRecord
+				for j := range existing {
+					if found == nil && sameContent(existing[j].Content, content) {
+						found = &existing[j]
+					}
+				}
+				proxied := proxiedContent(set.Proxied, content)
+				if newAddrSet(set.Values[:i]).hasContent(content) {
+					unchanged++ // Also in another range, created once
+				} else if found != nil && found.Proxied != proxied {
+					adds = append(adds, recordOp{Action: "update", Name: set.Name, Type: set.Type, Content: content, RecordID: found.ID, Proxied: proxied,
+						Message: fmt.Sprintf("Proxied changed for %s record %s (%s): %t -> %t", set.Type, set.Name, content, found.Proxied, proxied)})
+				} else if found != nil && ttlDiffers(found.TTL, set.TTL, proxied) {
+					adds = append(adds, recordOp{Action: "update", Name: set.Name, Type: set.Type, Content: content, RecordID: found.ID, Proxied: proxied,
+						Message: fmt.Sprintf("TTL changed for %s record %s (%s): %d -> %d", set.Type, set.Name, content, found.TTL, set.TTL)})
+				} else if found != nil && commentChanged(found.Comment, set.Comments[content]) {
+					adds = append(adds, recordOp{Action: "update", Name: set.Name, Type: set.Type, Content: content, RecordID: found.ID, Proxied: proxied,
+						Message: fmt.Sprintf("Comment changed for %s record %s (%s): %q -> %q", set.Type, set.Name, content, found.Comment, set.Comments[content])})
+				} else if found != nil {
+					log.Printf("No change needed for %s record %s (already %s)", set.Type, set.Name, content)
+					unchanged++
+				} else {
+					adds = append(adds, recordOp{Action: "create", Name: set.Name, Type: set.Type, Content: content, Proxied: proxied})
+				}
+			}
+			detected := newAddrSet(set.Values)
+			for _, record := range existing {
+				if !detected.hasContent(record.Content) {
+					removals = append(removals, recordOp{Action: "delete", Name: set.Name, Type: set.Type, RecordID: record.ID,
+						Message: "Deleting stale " + set.Label + " record: " + record.Content})
+				}
+			}
+		}
+	}
+	return adds, removals, unchanged
+}
+
+// apply performs one change
+func (op recordOp) apply(cf providerClient) error {
+	if op.Message != "" {
+		log.Print(op.Message)
+	}
+	switch op.Action {
+	case "create":
+		return cf.createRecord(op.Name, op.Type, op.Content, op.Proxied)
+	case "update":
+		return cf.updateRecord(op.RecordID, op.Name, op.Type, op.Content, op.Proxied)
+	case "delete":
+		return cf.deleteRecord(op.RecordID, op.Name, op.Type)
+	case "heartbeat":
+		return cf.upsertHeartbeat(op.Name, op.Content)
+	default: // "delete-heartbeat"
+		return cf.deleteRecordIfExists(op.Name, "TXT")
+	}
+}
+
+// applyRecordOps applies changes with up to workers at a time and returns how many succeeded.
+// The hooks are serialized, so recorders installed by callers need no locking.
+func applyRecordOps(cf providerClient, ops []recordOp, workers int) int {
+	if len(ops) == 0 {
+		return 0
+	}
+	hooks := cf.hooks()
+	onChange, onUnreachable := hooks.OnChange, hooks.OnUnreachable
+	var hookMu sync.Mutex
+	if onChange != nil {
+		hooks.OnChange = func(action, recordType, name, content string) {
+			hookMu.Lock()
+			defer hookMu.Unlock()
+			onChange(action, recordType, name, content)
+		}
+	}
+	if onUnreachable != nil {
+		hooks.OnUnreachable = func(action, recordType, name, content, recordID string) {
+			hookMu.Lock()
+			defer hookMu.Unlock()
+			onUnreachable(action, recordType, name, content, recordID)
+		}
+	}
+	defer func() { hooks.OnChange, hooks.OnUnreachable = onChange, onUnreachable }()
+
+	jobs := make(chan recordOp)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	successCount := 0
+	for i := 0; i < min(max(workers, 1), len(ops)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for op := range jobs {
+				if succeeded(op.apply(cf)) {
+					mu.Lock()
+					successCount++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, op := range ops {
+		jobs <- op
+	}
+	close(jobs)
+	wg.Wait()
+	return successCount
+}
+
+// reconcileBatch reconciles the managed records with the batch engine. false means the
+// records could not be listed and the caller should update record by record instead.
+func reconcileBatch(cf batchProvider, config *Config, ips *IPAddresses) (successCount, totalCount int, ok bool) {
+	sets := desiredRecordSets(config, ips)
+	snapshot, err := fetchRecordSnapshot(cf, sets)
+	if err != nil {
+		log.Printf("Could not list the managed records (%v) - updating record by record", err)
+		return 0, 0, false
+	}
+	adds, removals, unchanged := planRecordOps(sets, snapshot)
+	log.Printf("Batch update: %d record(s) unchanged, %d to add or update, %d to remove (%d at a time)",
+		unchanged, len(adds), len(removals), config.UpdateConcurrency)
+
+	successCount = unchanged + applyRecordOps(cf, adds, config.UpdateConcurrency)
+	successCount += applyRecordOps(cf, removals, config.UpdateConcurrency)
+	return successCount, unchanged + len(adds) + len(removals), true
+}
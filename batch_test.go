@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newBatchTestHost returns a fake zone with records from an earlier run, a configuration
+// managing them and the addresses of this run
+func newBatchTestHost(t *testing.T, concurrency int) (*fakeCloudFlare, *CloudFlareClient, *Config, *IPAddresses) {
+	t.Helper()
+	fake, cf := newFakeCloudFlare(t)
+	fake.add("A", "nas.i.example.com", "10.0.0.1")
+	fake.add("A", "nas.i.example.com", "10.0.0.2")
+	fake.add("A", "nas.e.example.com", "198.51.100.1")
+	fake.add("A", "nas.lab.example.com", "172.16.0.5")
+	fake.add("AAAA", "nas.vpn.example.com", "fd00::5")
+	fake.add("TXT", "nas.vpn.example.com", `"1699990000"`)
+	fake.add("A", "other.example.com", "192.0.2.1") // Not managed by this host
+
+	config := &Config{
+		InternalDomain:    "nas.i.example.com",
+		ExternalDomain:    "nas.e.example.com",
+		IPv6Domain:        "nas.6.example.com",
+		CombinedDomain:    "nas.example.com",
+		TopLevelDomain:    "nas.example.net",
+		CustomIPv4Ranges:  []CustomIPRange{{CIDR: "172.16.0.0/12", Domain: "nas.lab.example.com"}},
+		CustomIPv6Ranges:  []CustomIPRange{{CIDR: "fd00::/8", Domain: "nas.vpn.example.com"}},
+		UpdateConcurrency: concurrency,
+		clock:             newFakeClock(time.Unix(1700000000, 0)),
+	}
+	ips := &IPAddresses{
+		InternalIPv4:   []string{"10.0.0.2", "10.0.0.3"},
+		ExternalIPv4:   "203.0.113.9",
+		CustomRangeIPs: map[string][]string{"nas.lab.example.com": {"172.16.0.5", "10.0.0.2"}},
+	}
+	return fake, cf, config, ips
+}
+
+// zoneRecords returns every record of the fake as sorted "type name content" lines
+func zoneRecords(fake *fakeCloudFlare) []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	var lines []string
+	for _, r := range fake.records {
+		lines = append(lines, r.Type+" "+r.Name+" "+r.Content)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestReconcileBatchMatchesRecordByRecord(t *testing.T) {
+	sequentialFake, sequentialCF, config, ips := newBatchTestHost(t, 0)
+	wantSuccess, wantTotal := reconcileRecords(sequentialCF, config, ips, config.now())
+
+	batchFake, batchCF, config, ips := newBatchTestHost(t, 4)
+	success, total := reconcileRecords(batchCF, config, ips, config.now())
+
+	if success != total {
+		t.Errorf("batch update: %d of %d succeeded", success, total)
+	}
+	if wantSuccess != wantTotal {
+		t.Errorf("record by record: %d of %d succeeded", wantSuccess, wantTotal)
+	}
+	want, got := zoneRecords(sequentialFake), zoneRecords(batchFake)
+	if !slices.Equal(got, want) {
+		t.Errorf("batch update left\n%v\nrecord by record left\n%v", got, want)
+	}
+	if got := batchFake.contents("nas.example.com", "A"); len(got) != 4 {
+		t.Errorf("combined A records = %v, want each address once", got)
+	}
+}
+
+func TestReconcileBatchListsOnce(t *testing.T) {
+	fake, cf, config, ips := newBatchTestHost(t, 4)
+	var lookups, lists atomic.Int32
+	handler := fake.server.Config.Handler
+	fake.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Query().Get("type") != "TXT" {
+			if r.URL.Query().Get("name") != "" {
+				lookups.Add(1)
+			} else {
+				lists.Add(1)
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	var changes []RecordChange
+	cf.hooks().OnChange = newChangeRecorder(&changes)
+	reconcileRecords(cf, config, ips, config.now())
+
+	if lookups.Load() != 0 || lists.Load() != 3 {
+		t.Errorf("%d per-name lookups and %d listings, want none and one per type (A, AAAA, CNAME)", lookups.Load(), lists.Load())
+	}
+	// 10.0.0.3, 10.0.0.2 in the lab range, combined x4, external update, CNAME; stale 10.0.0.1,
+	// the IPv6 range without addresses
+	if len(changes) != 10 {
+		t.Errorf("recorded %d changes, want 10: %+v", len(changes), changes)
+	}
+}
+
+func TestReconcileBatchFallsBack(t *testing.T) {
+	fake, cf, config, ips := newBatchTestHost(t, 4)
+	handler := fake.server.Config.Handler
+	fake.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Query().Get("type") == "AAAA" && r.URL.Query().Get("name") == "" {
+			http.Error(w, `{"success":false,"errors":[{"code":1000,"message":"boom"}]}`, http.StatusBadRequest)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	if success, total := reconcileRecords(cf, config, ips, config.now()); success != total {
+		t.Errorf("%d of %d succeeded after falling back", success, total)
+	}
+	if got := fake.contents("nas.i.example.com", "A"); !slices.Equal(got, []string{"10.0.0.2", "10.0.0.3"}) {
+		t.Errorf("internal records = %v", got)
+	}
+}
+
+func TestPlanRecordOps(t *testing.T) {
+	sets := []desiredRecordSet{
+		{Name: "a.example.com", Type: "A", Values: []string{"10.0.0.1", "10.0.0.2"}, Label: "internal IPv4"},
+		{Name: "e.example.com", Type: "A", Values: []string{"203.0.113.1"}, Single: true},
+		{Name: "6.example.com", Type: "AAAA", Single: true, Label: "external IPv6"},
+		{Name: "a.example.com", Type: "TXT", Values: []string{`"1700000000"`}, Heartbeat: true},
+	}
+	snapshot := map[recordKey][]CFRecord{
+		{"a.example.com", "A"}:    {{ID: "1", Content: "10.0.0.1"}, {ID: "2", Content: "10.0.0.9"}},
+		{"e.example.com", "A"}:    {{ID: "3", Content: "203.0.113.1"}},
+		{"6.example.com", "AAAA"}: {{ID: "4", Content: "2001:db8::1"}},
+	}
+	adds, removals, unchanged := planRecordOps(sets, snapshot)
+
+	var got []string
+	for _, op := range append(adds, removals...) {
+		got = append(got, op.Action+" "+op.Name+" "+op.Content+op.RecordID)
+	}
+	want := []string{
+		"create a.example.com 10.0.0.2",
+		`heartbeat a.example.com "1700000000"`,
+		"delete a.example.com 2",
+		"delete 6.example.com 4",
+	}
+	if !slices.Equal(got, want) || unchanged != 2 {
+		t.Errorf("planned %q (%d unchanged), want %q (2 unchanged)", got, unchanged, want)
+	}
+}
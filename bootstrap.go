@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// Bootstrap retry. At startup, a missing or failing prerequisite - a secret file not mounted
+// yet, a Vault or DNS provider API that is not reachable yet, a zone that cannot be read -
+// stops the process, and a container restarts into the same failure in a tight, noisy loop.
+// With BOOTSTRAP_TIMEOUT_SECONDS set, reading the configuration and connecting to the
+// provider are retried instead, with backoff (5 seconds, doubling up to a minute), until they
+// succeed or the timeout passes; only then does the process exit as before. The setting is
+// read from the environment, as it applies before any config file can be read. Docker mode
+// waits five minutes by default.
+
+// Backoff between bootstrap attempts, and docker mode's default timeout
+const (
+	bootstrapBackoff           = 5 * time.Second
+	bootstrapMaxBackoff        = time.Minute
+	dockerModeBootstrapTimeout = 300
+)
+
+// The clock timing the bootstrap and the wait between attempts (replaced by tests)
+var (
+	bootstrapClock Clock = systemClock{}
+	bootstrapSleep       = time.Sleep
+)
+
+// bootstrapTimeout returns how long startup waits for its prerequisites (0 = not at all)
+func bootstrapTimeout() time.Duration {
+	defaultSeconds := 0
+	if dockerModeEnabled() {
+		defaultSeconds = dockerModeBootstrapTimeout
+	}
+	return time.Duration(getEnvOrDefaultInt("BOOTSTRAP_TIMEOUT_SECONDS", defaultSeconds)) * time.Second
+}
+
+// bootstrap reads the configuration and creates the provider client, retrying failures for
+// up to BOOTSTRAP_TIMEOUT_SECONDS. prepare applies the command line to the configuration
+// before the client is created; its errors are not retried.
+func bootstrap(cleanupMode bool, prepare func(*Config) error) (*Config, providerClient) {
+	return bootstrapConfig(cleanupMode, true, prepare)
+}
+
+// bootstrapConfig is bootstrap for configurations that may lack credentials and domains
+// (see readConfig), like the relay's
+func bootstrapConfig(cleanupMode, requireCredentials bool, prepare func(*Config) error) (*Config, providerClient) {
+	timeout := bootstrapTimeout()
+	if timeout <= 0 {
+		config := readConfig(cleanupMode, requireCredentials)
+		if err := prepare(config); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		cf, err := newProviderClient(config)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		return config, cf
+	}
+
+	deadline := bootstrapClock.Now().Add(timeout)
+	policy := &retryPolicy{backoff: bootstrapBackoff, maxBackoff: bootstrapMaxBackoff}
+	for attempt := 1; ; attempt++ {
+		config, cf, err := bootstrapAttempt(cleanupMode, requireCredentials, prepare)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Startup prerequisites ready after %d attempts", attempt)
+			}
+			return config, cf
+		}
+		reason := strings.TrimPrefix(err.Error(), "ERROR: ")
+		delay := policy.delay(attempt)
+		if bootstrapClock.Now().Add(delay).After(deadline) {
+			log.Fatalf("ERROR: %s (still failing after %s, %sBOOTSTRAP_TIMEOUT_SECONDS)", reason, timeout, envPrefix)
+		}
+		log.Printf("WARNING: Startup not ready: %s - retrying in %s", reason, delay.Round(time.Second))
+		bootstrapSleep(delay)
+	}
+}
+
+// bootstrapAttempt reads the configuration and creates the provider client once
+func bootstrapAttempt(cleanupMode, requireCredentials bool, prepare func(*Config) error) (*Config, providerClient, error) {
+	config, err := tryReadConfig(cleanupMode, requireCredentials)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := prepare(config); err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	cf, err := newProviderClient(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, cf, nil
+}
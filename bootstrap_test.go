@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBootstrapWaitsForSecretFile(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "dyndns2_password")
+	t.Setenv(envPrefix+"PROVIDER", providerHosts)
+	t.Setenv(envPrefix+"HOSTS_FILE_PATH", filepath.Join(dir, "hosts"))
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+	t.Setenv(envPrefix+"DYNDNS2_PASSWORD_FILE", secret)
+	t.Setenv(envPrefix+"BOOTSTRAP_TIMEOUT_SECONDS", "120")
+
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	var waits []time.Duration
+	bootstrapClock = clock
+	bootstrapSleep = func(d time.Duration) {
+		waits = append(waits, d)
+		clock.advance(d)
+		if len(waits) == 2 {
+			// The secret is mounted while the second wait runs
+			if err := os.WriteFile(secret, []byte("hunter22\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	t.Cleanup(func() { bootstrapClock, bootstrapSleep = systemClock{}, time.Sleep })
+
+	prepared := 0
+	config, cf := bootstrap(false, func(*Config) error {
+		prepared++
+		return nil
+	})
+	if cf == nil || config.DynDNS2Password != "hunter22" {
+		t.Fatalf("config = %+v, client = %v", config, cf)
+	}
+	if len(waits) != 2 || prepared != 1 {
+		t.Errorf("waited %v, prepared %d time(s)", waits, prepared)
+	}
+	if waits[0] > bootstrapBackoff || waits[1] > 2*bootstrapBackoff || waits[1] < bootstrapBackoff {
+		t.Errorf("waits = %v, want backoff from %s", waits, bootstrapBackoff)
+	}
+}
+
+func TestBootstrapWithoutDomains(t *testing.T) {
+	// The relay has no domains of its own, but waits for its secrets all the same
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "dyndns2_password")
+	t.Setenv(envPrefix+"PROVIDER", providerHosts)
+	t.Setenv(envPrefix+"HOSTS_FILE_PATH", filepath.Join(dir, "hosts"))
+	t.Setenv(envPrefix+"DYNDNS2_PASSWORD_FILE", secret)
+	t.Setenv(envPrefix+"BOOTSTRAP_TIMEOUT_SECONDS", "120")
+
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	waits := 0
+	bootstrapClock = clock
+	bootstrapSleep = func(d time.Duration) {
+		waits++
+		clock.advance(d)
+		if err := os.WriteFile(secret, []byte("hunter22\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { bootstrapClock, bootstrapSleep = systemClock{}, time.Sleep })
+
+	config, cf := bootstrapConfig(false, false, func(*Config) error { return nil })
+	if cf == nil || config.DynDNS2Password != "hunter22" || waits != 1 {
+		t.Errorf("config = %+v, client = %v after %d wait(s)", config, cf, waits)
+	}
+}
+
+func TestBootstrapTimeout(t *testing.T) {
+	t.Setenv(envPrefix+"DOCKER_MODE", "")
+	if got := bootstrapTimeout(); got != 0 {
+		t.Errorf("default timeout = %s, want none", got)
+	}
+	t.Setenv(envPrefix+"DOCKER_MODE", "true")
+	if got := bootstrapTimeout(); got != 5*time.Minute {
+		t.Errorf("docker mode timeout = %s, want 5m", got)
+	}
+	t.Setenv(envPrefix+"BOOTSTRAP_TIMEOUT_SECONDS", "30")
+	if got := bootstrapTimeout(); got != 30*time.Second {
+		t.Errorf("timeout = %s, want 30s", got)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudFlare reports the state of the account's API rate limit (1200 requests per five
+// minutes, shared by every token of the user) in response headers, in the format of the
+// IETF RateLimit header draft:
+//
+//	Ratelimit: "default";r=1150;t=240
+//	Ratelimit-Policy: "default";q=1200;w=300
+//
+// r is the number of requests left, t the seconds until the window resets, q the quota
+// and w the window length. Earlier drafts' RateLimit-Remaining/-Reset/-Limit headers
+// (also with an X- prefix) are understood too.
+
+// rateLimitLowFraction is the share of the quota below which a warning is logged
+const rateLimitLowFraction = 0.1
+
+// rateLimitState is the rate limit state reported with one response
+type rateLimitState struct {
+	Remaining int
+	Reset     time.Duration // Until the window resets
+	Limit     int           // Quota of the window (0 if not reported)
+	Window    time.Duration // Length of the window (0 if not reported)
+}
+
+// rateLimitParams parses the key=value parameters of a rate limit header; a bare number
+// (as in "100;w=60") is returned under the key ""
+func rateLimitParams(value string) map[string]int {
+	params := make(map[string]int)
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ',' }) {
+		key, v, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			key, v = "", key
+		}
+		if n, err := strconv.Atoi(strings.Trim(strings.TrimSpace(v), `"`)); err == nil {
+			if _, seen := params[key]; !seen {
+				params[key] = n
+			}
+		}
+	}
+	return params
+}
+
+// headerInt returns the first integer header of the given names
+func headerInt(h http.Header, names ...string) (int, bool) {
+	for _, name := range names {
+		if n, err := strconv.Atoi(strings.TrimSpace(h.Get(name))); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// parseRateLimitHeaders extracts the rate limit state from response headers
+func parseRateLimitHeaders(h http.Header) (rateLimitState, bool) {
+	var state rateLimitState
+	remaining, reset := -1, -1
+	if value := h.Get("Ratelimit"); value != "" {
+		params := rateLimitParams(value)
+		for _, key := range []string{"r", "remaining"} {
+			if n, ok := params[key]; ok {
+				remaining = n
+			}
+		}
+		for _, key := range []string{"t", "reset"} {
+			if n, ok := params[key]; ok {
+				reset = n
+			}
+		}
+		state.Limit = params["limit"]
+	}
+	if value := h.Get("Ratelimit-Policy"); value != "" {
+		params := rateLimitParams(value)
+		if n, ok := params["q"]; ok {
+			state.Limit = n
+		} else if n, ok := params[""]; ok {
+			state.Limit = n
+		}
+		state.Window = time.Duration(params["w"]) * time.Second
+	}
+	if n, ok := headerInt(h, "Ratelimit-Remaining", "X-Ratelimit-Remaining"); ok && remaining < 0 {
+		remaining = n
+	}
+	if n, ok := headerInt(h, "Ratelimit-Reset", "X-Ratelimit-Reset"); ok && reset < 0 {
+		reset = n
+	}
+	if n, ok := headerInt(h, "Ratelimit-Limit", "X-Ratelimit-Limit"); ok && state.Limit == 0 {
+		state.Limit = n
+	}
+
+	if remaining < 0 || reset < 0 {
+		return rateLimitState{}, false
+	}
+	state.Remaining, state.Reset = remaining, time.Duration(reset)*time.Second
+	return state, true
+}
+
+// cloudFlareRateLimit is the most recent rate limit state reported by the CloudFlare API,
+// exported as metrics
+var cloudFlareRateLimit struct {
+	mu       sync.Mutex
+	state    rateLimitState
+	observed time.Time // Zero until a response carried the headers
+}
+
+// observeRateLimit records the rate limit state of a response and paces the client by it
+func (cf *CloudFlareClient) observeRateLimit(h http.Header, now time.Time) {
+	state, ok := parseRateLimitHeaders(h)
+	if !ok {
+		return
+	}
+	cloudFlareRateLimit.mu.Lock()
+	cloudFlareRateLimit.state, cloudFlareRateLimit.observed = state, now
+	cloudFlareRateLimit.mu.Unlock()
+
+	cf.Limiter.adapt(state.Remaining, state.Reset, now)
+	if state.Limit > 0 && float64(state.Remaining) < rateLimitLowFraction*float64(state.Limit) {
+		log.Printf("WARNING: CloudFlare API rate limit nearly used up (%d of %d requests left, resets in %s) - slowing down",
+			state.Remaining, state.Limit, state.Reset)
+	}
+}
+
+// rateLimitMetrics returns the CloudFlare rate limit metrics (none before the API
+// reported its rate limit)
+func rateLimitMetrics() []metricFamily {
+	cloudFlareRateLimit.mu.Lock()
+	defer cloudFlareRateLimit.mu.Unlock()
+	if cloudFlareRateLimit.observed.IsZero() {
+		return nil
+	}
+	state, observed := cloudFlareRateLimit.state, cloudFlareRateLimit.observed
+
+	families := []metricFamily{
+		{Name: "dynipupdate_cloudflare_ratelimit_remaining", Help: "Requests left in the CloudFlare API rate limit window, as last reported.", Type: "gauge",
+			Samples: []metricSample{{Value: float64(state.Remaining)}}},
+		{Name: "dynipupdate_cloudflare_ratelimit_reset_timestamp_seconds", Help: "Unix time at which the CloudFlare API rate limit window resets.", Type: "gauge",
+			Samples: []metricSample{{Value: float64(observed.Add(state.Reset).Unix())}}},
+	}
+	if state.Limit > 0 {
+		families = append(families, metricFamily{Name: "dynipupdate_cloudflare_ratelimit_limit", Help: "Requests allowed per CloudFlare API rate limit window.", Type: "gauge",
+			Samples: []metricSample{{Value: float64(state.Limit)}}})
+	}
+	if state.Window > 0 {
+		families = append(families, metricFamily{Name: "dynipupdate_cloudflare_ratelimit_window_seconds", Help: "Length of the CloudFlare API rate limit window.", Type: "gauge",
+			Samples: []metricSample{{Value: state.Window.Seconds()}}})
+	}
+	return families
+}
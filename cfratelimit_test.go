@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetCloudFlareRateLimit clears the observed rate limit state after the test
+func resetCloudFlareRateLimit(t *testing.T) {
+	t.Cleanup(func() {
+		cloudFlareRateLimit.mu.Lock()
+		cloudFlareRateLimit.state, cloudFlareRateLimit.observed = rateLimitState{}, time.Time{}
+		cloudFlareRateLimit.mu.Unlock()
+	})
+}
+
+func TestParseRateLimitHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    rateLimitState
+		ok      bool
+	}{
+		{"structured", map[string]string{"Ratelimit": `"default";r=1150;t=240`, "Ratelimit-Policy": `"default";q=1200;w=300`},
+			rateLimitState{Remaining: 1150, Reset: 240 * time.Second, Limit: 1200, Window: 300 * time.Second}, true},
+		{"draft", map[string]string{"Ratelimit": "limit=100, remaining=7, reset=12"},
+			rateLimitState{Remaining: 7, Reset: 12 * time.Second, Limit: 100}, true},
+		{"draft policy", map[string]string{"Ratelimit-Remaining": "40", "Ratelimit-Reset": "5", "Ratelimit-Policy": "100;w=60"},
+			rateLimitState{Remaining: 40, Reset: 5 * time.Second, Limit: 100, Window: time.Minute}, true},
+		{"x-prefixed", map[string]string{"X-Ratelimit-Remaining": "3", "X-Ratelimit-Reset": "9", "X-Ratelimit-Limit": "10"},
+			rateLimitState{Remaining: 3, Reset: 9 * time.Second, Limit: 10}, true},
+		{"remaining only", map[string]string{"X-Ratelimit-Remaining": "3"}, rateLimitState{}, false},
+		{"absent", nil, rateLimitState{}, false},
+	}
+	for _, tt := range tests {
+		h := make(http.Header)
+		for k, v := range tt.headers {
+			h.Set(k, v)
+		}
+		got, ok := parseRateLimitHeaders(h)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: parseRateLimitHeaders = %+v, %v, want %+v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCloudFlareRateLimitMetrics(t *testing.T) {
+	resetCloudFlareRateLimit(t)
+	if families := rateLimitMetrics(); families != nil {
+		t.Fatalf("metrics before any response: %+v", families)
+	}
+
+	_, cf := newFakeCloudFlare(t)
+	cf.Limiter = newRateLimiter(1000)
+	h := make(http.Header)
+	h.Set("Ratelimit", `"default";r=5;t=10`)
+	h.Set("Ratelimit-Policy", `"default";q=1200;w=300`)
+	now := time.Unix(1700000000, 0)
+	cf.observeRateLimit(h, now)
+
+	// 5 requests left in 10s paces the client at one request per 2s
+	cf.Limiter.reserve(now)
+	if got := cf.Limiter.reserve(now); got != 2*time.Second {
+		t.Errorf("paced wait = %s, want 2s", got)
+	}
+
+	out := formatPrometheus(rateLimitMetrics())
+	for _, want := range []string{
+		"dynipupdate_cloudflare_ratelimit_remaining 5\n",
+		"dynipupdate_cloudflare_ratelimit_limit 1200\n",
+		"dynipupdate_cloudflare_ratelimit_window_seconds 300\n",
+		"dynipupdate_cloudflare_ratelimit_reset_timestamp_seconds 1.70000001e+09\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+// CloudFlare trace source. "cloudflare-trace" in IPV4_SOURCES or IPV6_SOURCES asks
+// CloudFlare's /cdn-cgi/trace endpoint which address a request came from (its "ip=" line).
+// The endpoint is queried on the addresses of CloudFlare's public resolver, so it needs no
+// DNS lookup and keeps working where ad blockers or firewalls block the generic echo
+// services. With PROVIDER=cloudflare it adds no third party, and ECHO_SERVICES=false keeps it.
+
+// cloudflareTraceSource is the name of the CloudFlare trace source
+const cloudflareTraceSource = "cloudflare-trace"
+
+// cloudflareTraceURLs are the trace endpoints queried for each record type
+var cloudflareTraceURLs = map[string]string{
+	"A":    "https://1.1.1.1/cdn-cgi/trace#kv:ip",
+	"AAAA": "https://[2606:4700:4700::1111]/cdn-cgi/trace#kv:ip",
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum number of entries kept in the rolling changelog TXT record
+const changelogMaxEntries = 5
+
+// Maximum length of a single TXT character-string (RFC 1035)
+const txtStringMaxLen = 255
+
+// RecordChange describes a single successful mutation of a managed record
+type RecordChange struct {
+	Timestamp int64
+	Action    string // "create", "update" or "delete"
+	Type      string
+	Name      string
+	Content   string
+}
+
+// changelogActionCodes maps actions to their compact single-character encoding
+var changelogActionCodes = map[string]string{
+	"create": "+",
+	"update": "~",
+	"delete": "-",
+}
+
+// changelogRecordName returns the name of the changelog TXT record for a domain
+// Example: "anubis.bees.wtf" -> "_changelog.anubis.bees.wtf"
+func changelogRecordName(domain string) string {
+	return "_changelog." + domain
+}
+
+// encodeChangelogEntry encodes a change compactly, e.g. "1699564820 +A anubis.bees.wtf 192.168.1.10"
+func encodeChangelogEntry(change RecordChange) string {
+	entry := fmt.Sprintf("%d %s%s %s", change.Timestamp, changelogActionCodes[change.Action], change.Type, change.Name)
+	if change.Content != "" {
+		entry += " " + change.Content
+	}
+	if len(entry) > txtStringMaxLen {
+		entry = entry[:txtStringMaxLen]
+	}
+	return entry
+}
+
+// parseTXTStrings splits TXT record content into its character-strings.
+// Handles both quoted ("a" "b") and bare content.
+func parseTXTStrings(content string) []string {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil
+	}
+	if !strings.HasPrefix(content, "\"") {
+		return []string{content}
+	}
+
+	var result []string
+	var current strings.Builder
+	inQuotes := false
+	escaped := false
+
+	for _, r := range content {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && inQuotes:
+			escaped = true
+		case r == '"':
+			if inQuotes {
+				result = append(result, current.String())
+				current.Reset()
+			}
+			inQuotes = !inQuotes
+		case inQuotes:
+			current.WriteRune(r)
+		}
+	}
+
+	// Unterminated final string - keep what we have
+	if inQuotes && current.Len() > 0 {
+		result = append(result, current.String())
+	}
+
+	return result
+}
+
+// formatTXTStrings joins character-strings into quoted TXT record content
+func formatTXTStrings(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		v = strings.ReplaceAll(v, "\\", "\\\\")
+		quoted[i] = "\"" + strings.ReplaceAll(v, "\"", "\\\"") + "\""
+	}
+	return strings.Join(quoted, " ")
+}
+
+// mergeChangelog prepends new entries (newest first) and keeps at most max entries
+func mergeChangelog(existing []string, changes []RecordChange, max int) []string {
+	entries := make([]string, 0, len(changes)+len(existing))
+	for i := len(changes) - 1; i >= 0; i-- {
+		entries = append(entries, encodeChangelogEntry(changes[i]))
+	}
+	for _, entry := range existing {
+		// Skip anything that doesn't look like one of our entries
+		fields := strings.Fields(entry)
+		if len(fields) < 3 {
+			continue
+		}
+		if _, err := strconv.ParseInt(fields[0], 10, 64); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) > max {
+		entries = entries[:max]
+	}
+	return entries
+}
+
+// newChangeRecorder returns a hook for recordHooks.OnChange that collects record changes.
+// Heartbeat and changelog TXT writes are excluded so they don't drown out real changes.
+func newChangeRecorder(changes *[]RecordChange) func(action, recordType, name, content string) {
+	return func(action, recordType, name, content string) {
+		if recordType == "TXT" {
+			return
+		}
+		*changes = append(*changes, RecordChange{
+			Timestamp: time.Now().Unix(),
+			Action:    action,
+			Type:      recordType,
+			Name:      name,
+			Content:   content,
+		})
+	}
+}
+
+// updateChangelog writes the rolling changelog TXT record for domain
+func updateChangelog(cf providerClient, domain string, changes []RecordChange) error {
+	name := changelogRecordName(domain)
+
+	// Without the current entries the rewrite would lose them
+	record, err := cf.getRecord(name, "TXT")
+	if err != nil {
+		return err
+	}
+	var existing []string
+	if record != nil {
+		existing = parseTXTStrings(record.Content)
+	}
+
+	entries := mergeChangelog(existing, changes, changelogMaxEntries)
+	if err := cf.upsertRecord(name, "TXT", formatTXTStrings(entries), false); err != nil {
+		return err
+	}
+	log.Printf("Updated changelog %s with %d change(s)", name, len(changes))
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseTXTStrings verifies splitting of quoted TXT content into character-strings
+func TestParseTXTStrings(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{"empty", "", nil},
+		{"bare", "1699564820", []string{"1699564820"}},
+		{"single quoted", `"1699564820"`, []string{"1699564820"}},
+		{"multiple", `"a b" "c"`, []string{"a b", "c"}},
+		{"escaped quote", `"say \"hi\""`, []string{`say "hi"`}},
+		{"unterminated", `"a" "b`, []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parseTXTStrings(tt.content)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("parseTXTStrings(%q) = %q, expected %q", tt.content, result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestFormatTXTStringsRoundTrip verifies formatted content parses back to the same strings
+func TestFormatTXTStringsRoundTrip(t *testing.T) {
+	values := []string{"1699564820 +A anubis.bees.wtf 192.168.1.10", `quote " and \ backslash`}
+	if result := parseTXTStrings(formatTXTStrings(values)); !reflect.DeepEqual(result, values) {
+		t.Errorf("Round trip mismatch: got %q, expected %q", result, values)
+	}
+}
+
+// TestMergeChangelog verifies newest-first ordering, truncation and filtering of foreign entries
+func TestMergeChangelog(t *testing.T) {
+	existing := []string{
+		"100 +A old.example.com 10.0.0.1",
+		"not a changelog entry",
+		"90 -AAAA old.example.com",
+	}
+	changes := []RecordChange{
+		{Timestamp: 200, Action: "create", Type: "A", Name: "a.example.com", Content: "10.0.0.2"},
+		{Timestamp: 201, Action: "delete", Type: "A", Name: "a.example.com"},
+	}
+
+	result := mergeChangelog(existing, changes, 3)
+	expected := []string{
+		"201 -A a.example.com",
+		"200 +A a.example.com 10.0.0.2",
+		"100 +A old.example.com 10.0.0.1",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("mergeChangelog() = %q, expected %q", result, expected)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Chat notifications. Besides NOTIFY_URL, notifications can go straight to a chat:
+//
+//   - Telegram: NOTIFY_TELEGRAM_BOT_TOKEN and NOTIFY_TELEGRAM_CHAT_ID (a bot from @BotFather,
+//     added to the chat)
+//   - Discord: NOTIFY_DISCORD_URL, a channel webhook URL
+//   - Slack: NOTIFY_SLACK_URL, an incoming webhook URL
+//
+// Every configured notifier - push notifiers too (see pushnotify.go) - receives every
+// notification. On top of the reports and alerts
+// sent anyway, NOTIFY_EVENTS selects short messages about routine events (default all):
+//
+//   - ip-change: the external IPv4 or IPv6 address changed (needs STATE_FILE)
+//   - update-failed: an update run failed to update some records - once, until a run succeeds
+//   - cleanup-deleted: the cleanup service deleted the records of stale names
+//
+// Without a notifier these events are only logged, as they already are.
+
+// Routine events selectable with NOTIFY_EVENTS
+const (
+	notifyEventIPChange       = "ip-change"
+	notifyEventUpdateFailed   = "update-failed"
+	notifyEventCleanupDeleted = "cleanup-deleted"
+)
+
+// defaultNotifyEvents are the routine events sent when NOTIFY_EVENTS is unset
+var defaultNotifyEvents = []string{notifyEventIPChange, notifyEventUpdateFailed, notifyEventCleanupDeleted}
+
+// Telegram Bot API, and the longest message Telegram and Discord accept
+const (
+	telegramAPIURL      = "https://api.telegram.org"
+	telegramMaxMessage  = 4096
+	discordMaxMessage   = 2000
+	chatNotifierTimeout = 10 * time.Second
+)
+
+// TelegramNotifier sends notifications as Telegram bot messages
+type TelegramNotifier struct {
+	APIURL string // Bot API base URL (telegramAPIURL)
+	Token  string
+	ChatID string
+}
+
+func (t *TelegramNotifier) Notify(event, subject, message string) error {
+	text := truncateMessage(subject+"\n\n"+message, telegramMaxMessage)
+	return postChatMessage(t.APIURL+"/bot"+t.Token+"/sendMessage", map[string]string{"chat_id": t.ChatID, "text": text})
+}
+
+// DiscordNotifier sends notifications to a Discord channel webhook
+type DiscordNotifier struct {
+	URL string
+}
+
+func (d *DiscordNotifier) Notify(event, subject, message string) error {
+	text := truncateMessage("**"+subject+"**\n"+message, discordMaxMessage)
+	return postChatMessage(d.URL, map[string]string{"content": text})
+}
+
+// SlackNotifier sends notifications to a Slack incoming webhook
+type SlackNotifier struct {
+	URL string
+}
+
+func (s *SlackNotifier) Notify(event, subject, message string) error {
+	return postChatMessage(s.URL, map[string]string{"text": "*" + subject + "*\n" + message})
+}
+
+// multiNotifier sends each notification to several notifiers
+type multiNotifier []Notifier
+
+func (m multiNotifier) Notify(event, subject, message string) error {
+	var failed []string
+	for _, n := range m {
+		if err := n.Notify(event, subject, message); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// chatNotifiers returns the configured chat notifiers
+func chatNotifiers(config *Config) []Notifier {
+	var notifiers []Notifier
+	if config.NotifyTelegramBotToken != "" {
+		notifiers = append(notifiers, &TelegramNotifier{APIURL: telegramAPIURL, Token: config.NotifyTelegramBotToken, ChatID: config.NotifyTelegramChatID})
+	}
+	if config.NotifyDiscordURL != "" {
+		notifiers = append(notifiers, &DiscordNotifier{URL: config.NotifyDiscordURL})
+	}
+	if config.NotifySlackURL != "" {
+		notifiers = append(notifiers, &SlackNotifier{URL: config.NotifySlackURL})
+	}
+	return notifiers
+}
+
+// postChatMessage POSTs a chat message as JSON
+func postChatMessage(url string, payload map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: chatNotifierTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The URL holds the token or webhook secret: report the host only
+		return fmt.Errorf("%s: request failed", hostOf(url))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned status %d: %s", hostOf(url), resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// hostOf returns the host of a URL
+func hostOf(url string) string {
+	_, rest, _ := strings.Cut(url, "://")
+	host, _, _ := strings.Cut(rest, "/")
+	return host
+}
+
+// truncateMessage shortens text to at most limit characters
+func truncateMessage(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// validateNotifiers checks the chat notifier settings
+func validateNotifiers(config *Config) {
+	if config.NotifyTelegramBotToken != "" && config.NotifyTelegramChatID == "" {
+		configFatalf("ERROR: %sNOTIFY_TELEGRAM_BOT_TOKEN needs %sNOTIFY_TELEGRAM_CHAT_ID", envPrefix, envPrefix)
+	}
+	for _, setting := range []struct{ key, url string }{{"NOTIFY_DISCORD_URL", config.NotifyDiscordURL}, {"NOTIFY_SLACK_URL", config.NotifySlackURL}} {
+		if setting.url != "" && !strings.HasPrefix(setting.url, "https://") && !strings.HasPrefix(setting.url, "http://") {
+			configFatalf("ERROR: %s%s must be an http or https URL", envPrefix, setting.key)
+		}
+	}
+	for _, event := range config.NotifyEvents {
+		if !slices.Contains(defaultNotifyEvents, event) {
+			configFatalf("ERROR: %sNOTIFY_EVENTS: unknown event %q (use %s)", envPrefix, event, strings.Join(defaultNotifyEvents, ", "))
+		}
+	}
+	if len(chatNotifiers(config))+len(pushNotifiers(config)) > 0 && config.StateFile == "" && slices.Contains(config.NotifyEvents, notifyEventIPChange) {
+		log.Printf("WARNING: %sNOTIFY_EVENTS=%s needs %sSTATE_FILE to remember the external addresses - not notified", envPrefix, notifyEventIPChange, envPrefix)
+	}
+}
+
+// notifies reports whether a routine event is sent: a notifier is configured and
+// NOTIFY_EVENTS selects the event
+func (c *Config) notifies(event string) bool {
+	if _, logOnly := newNotifier(c).(LogNotifier); logOnly {
+		return false
+	}
+	return slices.Contains(c.NotifyEvents, event)
+}
+
+// notifyEvent sends a routine event to the configured notifiers if NOTIFY_EVENTS selects it
+func notifyEvent(config *Config, event, subject, message string) {
+	if !config.notifies(event) {
+		return
+	}
+	subject = statusPageHost(config) + ": " + subject
+	if err := newNotifier(config).Notify(event, subject, message); err != nil {
+		log.Printf("WARNING: Could not send %s notification: %v", event, err)
+	}
+}
+
+// notifyUpdateResult sends update-failed for the first failed run after a successful one.
+// Without a state file (nil state), every failed run is notified.
+func notifyUpdateResult(config *Config, state *State, successCount, totalCount int) {
+	failed := successCount < totalCount
+	if state != nil {
+		wasFailing := state.UpdateFailing
+		state.UpdateFailing = failed
+		if wasFailing {
+			return
+		}
+	}
+	if failed {
+		notifyEvent(config, notifyEventUpdateFailed, "DNS update failed",
+			fmt.Sprintf("%d of %d record operations failed - see the log for details", totalCount-successCount, totalCount))
+	}
+}
+
+// formatCleanupDeletions describes the records a cleanup run deleted, by domain
+func formatCleanupDeletions(deleted []cleanupDeletion) string {
+	var b strings.Builder
+	for i, d := range deleted {
+		if i == 0 || deleted[i-1].Domain != d.Domain {
+			fmt.Fprintf(&b, "%s (%s):\n", d.Domain, d.Reason)
+		}
+		if d.Record.Type == "TXT" {
+			fmt.Fprintf(&b, "  %s\n", d.Label)
+		} else {
+			fmt.Fprintf(&b, "  %s %s\n", d.Label, d.Record.Content)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chatServer records the chat messages POSTed to it
+type chatServer struct {
+	mu       sync.Mutex
+	paths    []string
+	messages []map[string]string
+}
+
+func newChatServer(t *testing.T) (*chatServer, *httptest.Server) {
+	c := &chatServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.paths = append(c.paths, r.URL.Path)
+		c.messages = append(c.messages, payload)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return c, srv
+}
+
+func (c *chatServer) received() []map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]map[string]string{}, c.messages...)
+}
+
+func TestChatNotifiers(t *testing.T) {
+	chat, srv := newChatServer(t)
+
+	notifiers := multiNotifier{
+		&TelegramNotifier{APIURL: srv.URL, Token: "123:abc", ChatID: "-10042"},
+		&DiscordNotifier{URL: srv.URL + "/discord"},
+		&SlackNotifier{URL: srv.URL + "/slack"},
+	}
+	if err := notifiers.Notify("ip-change", "External address changed", "IPv4: 203.0.113.1 -> 203.0.113.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := chat.received()
+	if len(got) != 3 {
+		t.Fatalf("got %d messages, want 3", len(got))
+	}
+	if chat.paths[0] != "/bot123:abc/sendMessage" || got[0]["chat_id"] != "-10042" || got[0]["text"] != "External address changed\n\nIPv4: 203.0.113.1 -> 203.0.113.2" {
+		t.Errorf("telegram: %s %v", chat.paths[0], got[0])
+	}
+	if got[1]["content"] != "**External address changed**\nIPv4: 203.0.113.1 -> 203.0.113.2" {
+		t.Errorf("discord: %v", got[1])
+	}
+	if got[2]["text"] != "*External address changed*\nIPv4: 203.0.113.1 -> 203.0.113.2" {
+		t.Errorf("slack: %v", got[2])
+	}
+
+	if n := len([]rune(truncateMessage(strings.Repeat("é", 3000), discordMaxMessage))); n != discordMaxMessage {
+		t.Errorf("truncated to %d characters, want %d", n, discordMaxMessage)
+	}
+}
+
+func TestChatNotifierErrorHidesSecret(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	err := (&SlackNotifier{URL: srv.URL + "/services/T000/B000/secret"}).Notify("update-failed", "s", "m")
+	if err == nil || strings.Contains(err.Error(), "secret") || !strings.Contains(err.Error(), "403") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestNotifyUpdateResult(t *testing.T) {
+	chat, srv := newChatServer(t)
+	config := &Config{NotifySlackURL: srv.URL, NotifyEvents: defaultNotifyEvents, HeartbeatHost: "nas", ExternalDomain: "home.example.com"}
+	state := newState()
+
+	notifyUpdateResult(config, state, 3, 3)
+	notifyUpdateResult(config, state, 1, 3)
+	notifyUpdateResult(config, state, 0, 3) // Still failing: not sent again
+	notifyUpdateResult(config, state, 3, 3)
+	notifyUpdateResult(config, state, 2, 3)
+	if got := chat.received(); len(got) != 2 || !strings.Contains(got[0]["text"], "2 of 3 record operations failed") {
+		t.Errorf("messages = %v", got)
+	}
+
+	// Not selected, or no notifier: nothing is sent
+	config.NotifyEvents = []string{notifyEventIPChange}
+	notifyUpdateResult(config, nil, 0, 3)
+	config.NotifyEvents, config.NotifySlackURL = defaultNotifyEvents, ""
+	notifyUpdateResult(config, nil, 0, 3)
+	if got := chat.received(); len(got) != 2 {
+		t.Errorf("%d messages, want 2", len(got))
+	}
+}
+
+func TestNotifyIPChangeToChat(t *testing.T) {
+	chat, srv := newChatServer(t)
+	config := &Config{
+		ExternalDomain:   "home.example.com",
+		HeartbeatHost:    "nas",
+		StateFile:        filepath.Join(t.TempDir(), "state.json"),
+		NotifyDiscordURL: srv.URL,
+		NotifyEvents:     []string{notifyEventIPChange},
+		clock:            newFakeClock(time.Unix(1700000000, 0)),
+	}
+
+	notifyIPChange(config, &IPAddresses{ExternalIPv4: "203.0.113.1"})
+	notifyIPChange(config, &IPAddresses{ExternalIPv4: "203.0.113.2"})
+	got := chat.received()
+	want := "**home.example.com: External address changed**\nIPv4: 203.0.113.1 -> 203.0.113.2\nDomains: home.example.com\n"
+	if len(got) != 1 || got[0]["content"] != want {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+}
+
+func TestFormatCleanupDeletions(t *testing.T) {
+	deleted := []cleanupDeletion{
+		{Domain: "old.example.com", Reason: "stale heartbeat (age: 900s)", Label: "A record", Record: CFRecord{Type: "A", Content: "192.168.1.5"}},
+		{Domain: "old.example.com", Reason: "stale heartbeat (age: 900s)", Label: "TXT heartbeat", Record: CFRecord{Type: "TXT"}},
+	}
+	want := "old.example.com (stale heartbeat (age: 900s)):\n  A record 192.168.1.5\n  TXT heartbeat\n"
+	if got := formatCleanupDeletions(deleted); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNotifierSettings(t *testing.T) {
+	t.Setenv(envPrefix+"CF_API_TOKEN", "token")
+	t.Setenv(envPrefix+"CF_ZONE_ID", "zone")
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+	t.Setenv(envPrefix+"NOTIFY_TELEGRAM_BOT_TOKEN", "123:abc")
+
+	if _, err := reloadConfig(false); err == nil || !strings.Contains(err.Error(), "NOTIFY_TELEGRAM_CHAT_ID") {
+		t.Errorf("bot token without chat: err = %v", err)
+	}
+	t.Setenv(envPrefix+"NOTIFY_TELEGRAM_CHAT_ID", "42")
+	t.Setenv(envPrefix+"NOTIFY_EVENTS", "ip-change,reboot")
+	if _, err := reloadConfig(false); err == nil || !strings.Contains(err.Error(), `"reboot"`) {
+		t.Errorf("unknown event: err = %v", err)
+	}
+	t.Setenv(envPrefix+"NOTIFY_EVENTS", "")
+	config, err := reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := newNotifier(config).(*TelegramNotifier); !ok {
+		t.Errorf("notifier = %T, want the Telegram notifier", newNotifier(config))
+	}
+
+	for _, name := range []string{"NOTIFY_SLACK_URL", "NotifyDiscordURL", "NOTIFY_TELEGRAM_BOT_TOKEN", "HistorySigningKey"} {
+		if !isSecretSetting(name) {
+			t.Errorf("%s is not a secret", name)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Check mode (-check, -dry-run) runs detection and the full reconcile against a client
+// that reads the live records but only plans writes. With -cleanup it computes the
+// cleanup plan (planCleanup) without executing it. The exit code tells configuration
+// management whether anything would change:
+//
+//	0  records are in sync
+//	1  detection or the provider failed
+//	2  changes are pending
+//
+// Heartbeat refreshes are not changes, so a host that is in sync stays "unchanged".
+
+// Exit codes of check mode
+const (
+	checkExitInSync  = 0
+	checkExitFailed  = 1
+	checkExitChanged = 2
+)
+
+// dryRunClient passes reads through to the provider and turns writes into planned
+// changes, reported through the OnChange hook like real ones
+type dryRunClient struct {
+	providerClient
+}
+
+func newDryRunClient(cf providerClient) *dryRunClient {
+	return &dryRunClient{providerClient: cf}
+}
+
+func (c *dryRunClient) plan(action, recordType, name, content string) error {
+	c.hooks().notifyChange(action, recordType, name, content)
+	return nil
+}
+
+func (c *dryRunClient) createRecord(name, recordType, content string, proxied bool) error {
+	return c.plan("create", recordType, name, content)
+}
+
+func (c *dryRunClient) updateRecord(recordID, name, recordType, content string, proxied bool) error {
+	return c.plan("update", recordType, name, content)
+}
+
+func (c *dryRunClient) deleteRecord(recordID, name, recordType string) error {
+	records, err := c.getAllRecords(name, recordType)
+	if err != nil {
+		return err
+	}
+	content := recordID
+	for _, r := range records {
+		if r.ID == recordID {
+			content = r.Content
+		}
+	}
+	return c.plan("delete", recordType, name, content)
+}
+
+// deleteRecordIfExists plans deleting the first record, as the providers delete one
+func (c *dryRunClient) deleteRecordIfExists(name, recordType string) error {
+	record, err := c.getRecord(name, recordType)
+	if record != nil {
+		return c.plan("delete", recordType, name, record.Content)
+	}
+	return err
+}
+
+func (c *dryRunClient) upsertRecord(name, recordType, content string, proxied bool) error {
+	existing, err := c.getRecord(name, recordType)
+	switch {
+	case err != nil:
+		return err
+	case existing == nil:
+		return c.plan("create", recordType, name, content)
+	case !sameContent(existing.Content, content), c.proxiedChanged(*existing, proxied), c.ttlChanged(*existing, proxied), c.commentChanged(*existing):
+		return c.plan("update", recordType, name, content)
+	}
+	return nil
+}
+
+func (c *dryRunClient) ensureRecordExists(name, recordType, content string, proxied bool) error {
+	records, err := c.getAllRecords(name, recordType)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if sameContent(r.Content, content) && (c.proxiedChanged(r, proxied) || c.ttlChanged(r, proxied) || c.commentChanged(r)) {
+			return c.plan("update", recordType, name, content)
+		}
+		if sameContent(r.Content, content) {
+			return nil
+		}
+	}
+	return c.plan("create", recordType, name, content)
+}
+
+// proxiedChanged reports whether CloudFlare would rewrite a record to change its proxying;
+// other providers never proxy
+func (c *dryRunClient) proxiedChanged(record CFRecord, proxied bool) bool {
+	_, ok := c.providerClient.(*CloudFlareClient)
+	return ok && record.Proxied != proxied
+}
+
+// ttlChanged reports whether the provider would rewrite a record for its TTL alone
+func (c *dryRunClient) ttlChanged(record CFRecord, proxied bool) bool {
+	ttls, ok := c.providerClient.(interface{ recordTTL(name string) int })
+	return ok && ttlDiffers(record.TTL, ttls.recordTTL(record.Name), proxied)
+}
+
+// commentChanged reports whether the provider would rewrite a record for its comment alone
+func (c *dryRunClient) commentChanged(record CFRecord) bool {
+	comments, ok := c.providerClient.(interface{ recordComment(content string) string })
+	return ok && commentChanged(record.Comment, comments.recordComment(record.Content))
+}
+
+// upsertHeartbeat does nothing: refreshing the timestamp is not a change
+func (c *dryRunClient) upsertHeartbeat(name, content string) error {
+	return nil
+}
+
+// runResult is the -ci summary of a run, printed as one JSON object on stdout
+type runResult struct {
+	Mode      string         `json:"mode"` // "check", "cleanup-check", "sweep-check", "replay" or "update"
+	Changed   bool           `json:"changed"`
+	Failed    bool           `json:"failed"`
+	TimedOut  bool           `json:"timed_out,omitempty"` // Ended by CYCLE_TIMEOUT_SECONDS
+	Succeeded int            `json:"succeeded"`
+	Total     int            `json:"total"`
+	Changes   []resultChange `json:"changes"`
+}
+
+type resultChange struct {
+	Action  string `json:"action"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Reason  string `json:"reason,omitempty"` // Why a cleanup would delete the record
+}
+
+// newRunResult summarizes record changes (TXT records such as heartbeats and the
+// changelog are bookkeeping and excluded), sorted so identical runs print identically
+func newRunResult(mode string, changes []RecordChange, successCount, totalCount int) *runResult {
+	result := &runResult{
+		Mode:      mode,
+		Changed:   len(changes) > 0,
+		Failed:    successCount < totalCount,
+		Succeeded: successCount,
+		Total:     totalCount,
+		Changes:   make([]resultChange, 0, len(changes)),
+	}
+	for _, c := range changes {
+		result.Changes = append(result.Changes, resultChange{Action: c.Action, Type: c.Type, Name: c.Name, Content: c.Content})
+	}
+	sort.Slice(result.Changes, func(i, j int) bool {
+		a, b := result.Changes[i], result.Changes[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		return a.Content < b.Content
+	})
+	return result
+}
+
+// exitCode returns the check mode exit code of the result
+func (r *runResult) exitCode() int {
+	switch {
+	case r.Failed:
+		return checkExitFailed
+	case r.Changed:
+		return checkExitChanged
+	}
+	return checkExitInSync
+}
+
+// write prints the result: one JSON line with -ci, otherwise a short plan for humans
+func (r *runResult) write(w io.Writer, ci bool) {
+	if ci {
+		json.NewEncoder(w).Encode(r)
+		return
+	}
+	for _, c := range r.Changes {
+		switch {
+		case c.Action != "delete":
+			fmt.Fprintf(w, "would %s %s %s -> %s\n", c.Action, c.Type, c.Name, c.Content)
+		case c.Reason != "":
+			fmt.Fprintf(w, "would delete stale %s %s (%s): %s\n", c.Type, c.Name, c.Content, c.Reason)
+		case c.Content != "":
+			fmt.Fprintf(w, "would delete %s %s (%s)\n", c.Type, c.Name, c.Content)
+		default:
+			fmt.Fprintf(w, "would delete %s %s\n", c.Type, c.Name)
+		}
+	}
+	switch {
+	case r.Failed:
+		fmt.Fprintf(w, "Check failed (%d of %d operations succeeded)\n", r.Succeeded, r.Total)
+	case r.Changed:
+		fmt.Fprintf(w, "%d change(s) pending\n", len(r.Changes))
+	default:
+		fmt.Fprintln(w, "No changes")
+	}
+}
+
+// runCheck plans an update without changing anything: no records, no state file, no
+// change journal, no metrics, no latency probes, no status page and no DynDNS2 or local
+// mirror updates
+func runCheck(cf providerClient, config *Config) *runResult {
+	disableSideEffects(config)
+
+	// An unreachable API would fail every read; report it once rather than per record
+	if !cf.probeConnectivity() {
+		log.Println("ERROR: DNS provider API is unreachable")
+		return &runResult{Mode: "check", Failed: true, Changes: []resultChange{}}
+	}
+
+	plan := newDryRunClient(cf)
+	var changes []RecordChange
+	plan.hooks().OnChange = newChangeRecorder(&changes)
+	_, successCount, totalCount := runUpdate(plan, config)
+	return newRunResult("check", changes, successCount, totalCount)
+}
+
+// disableSideEffects turns off everything a planned run must not touch besides the records
+func disableSideEffects(config *Config) {
+	config.StateFile = ""
+	config.HistoryFile = ""
+	config.MetricsFile = ""
+	config.LatencyProbeTarget = ""
+	config.StatusPageDir, config.StatusPageS3URL = "", ""
+	config.NotifyEvents = nil
+	if config.LocalMirror != "" {
+		log.Printf("Check mode: not checking the local %s mirror", config.LocalMirror)
+		config.LocalMirror = ""
+	}
+	if len(config.DynDNS2Hostnames) > 0 {
+		log.Printf("Check mode: not checking DynDNS2 hostnames (%s)", strings.Join(config.DynDNS2Hostnames, ", "))
+		config.DynDNS2Hostnames = nil
+	}
+}
+
+// runCleanupCheck plans one cleanup run without deleting anything. Unlike the update
+// plan, the cleanup plan includes heartbeat and changelog TXT records.
+func runCleanupCheck(cf providerClient, config *Config) *runResult {
+	if !cf.probeConnectivity() {
+		log.Println("ERROR: DNS provider API is unreachable")
+		return &runResult{Mode: "cleanup-check", Failed: true, Changes: []resultChange{}}
+	}
+
+	managedDomains := cleanupManagedDomains(config)
+	deletions, _, err := planCleanup(cf, config, func(name string) bool { return managedDomains[name] })
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return &runResult{Mode: "cleanup-check", Failed: true, Changes: []resultChange{}}
+	}
+	result := newRunResult("cleanup-check", nil, 1, 1)
+	for _, d := range deletions {
+		result.Changes = append(result.Changes, resultChange{Action: "delete", Type: d.Record.Type, Name: d.Record.Name, Content: d.Record.Content, Reason: d.Reason})
+	}
+	result.Changed = len(result.Changes) > 0
+	return result
+}
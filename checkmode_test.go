@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDryRunPlansWithoutWriting(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	fake.add("A", "home.example.com", "203.0.113.1")
+	fake.add("A", "nas.int.example.com", "192.168.1.10")
+	fake.add("A", "nas.int.example.com", "192.168.1.99") // Address the host no longer has
+
+	config := &Config{
+		InternalDomain: "nas.int.example.com",
+		ExternalDomain: "home.example.com",
+		IPv6Domain:     "home6.example.com",
+	}
+	ips := &IPAddresses{InternalIPv4: []string{"192.168.1.10", "192.168.1.11"}, ExternalIPv4: "203.0.113.2"}
+
+	plan := newDryRunClient(cf)
+	var changes []RecordChange
+	plan.hooks().OnChange = newChangeRecorder(&changes)
+	success, total := reconcileRecords(plan, config, ips, time.Now())
+
+	result := newRunResult("check", changes, success, total)
+	want := []resultChange{
+		{Action: "update", Type: "A", Name: "home.example.com", Content: "203.0.113.2"},
+		{Action: "create", Type: "A", Name: "nas.int.example.com", Content: "192.168.1.11"},
+		{Action: "delete", Type: "A", Name: "nas.int.example.com", Content: "192.168.1.99"},
+	}
+	if !reflect.DeepEqual(result.Changes, want) {
+		t.Errorf("changes = %v, want %v", result.Changes, want)
+	}
+	if result.exitCode() != checkExitChanged {
+		t.Errorf("exit code = %d, want %d", result.exitCode(), checkExitChanged)
+	}
+
+	// Nothing was written, not even the heartbeat
+	if got := fake.contents("home.example.com", "A"); !reflect.DeepEqual(got, []string{"203.0.113.1"}) {
+		t.Errorf("external record changed to %v", got)
+	}
+	if got := fake.contents("nas.int.example.com", "TXT"); len(got) != 0 {
+		t.Errorf("heartbeat written: %v", got)
+	}
+
+	// After a real update the check reports no changes
+	reconcileRecords(cf, config, ips, time.Now())
+	changes = nil
+	success, total = reconcileRecords(plan, config, ips, time.Now())
+	if result := newRunResult("check", changes, success, total); result.Changed || result.exitCode() != checkExitInSync {
+		t.Errorf("check after update = %+v", result)
+	}
+}
+
+func TestRunResultOutput(t *testing.T) {
+	changes := []RecordChange{
+		{Timestamp: 2, Action: "create", Type: "AAAA", Name: "b.example.com", Content: "2001:db8::1"},
+		{Timestamp: 1, Action: "create", Type: "A", Name: "b.example.com", Content: "203.0.113.1"},
+		{Timestamp: 3, Action: "delete", Type: "A", Name: "a.example.com", Content: "203.0.113.9"},
+	}
+	var out strings.Builder
+	newRunResult("check", changes, 4, 4).write(&out, true)
+	want := `{"mode":"check","changed":true,"failed":false,"succeeded":4,"total":4,"changes":[` +
+		`{"action":"delete","type":"A","name":"a.example.com","content":"203.0.113.9"},` +
+		`{"action":"create","type":"A","name":"b.example.com","content":"203.0.113.1"},` +
+		`{"action":"create","type":"AAAA","name":"b.example.com","content":"2001:db8::1"}]}` + "\n"
+	if out.String() != want {
+		t.Errorf("JSON =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	newRunResult("check", nil, 3, 3).write(&out, true)
+	if !strings.Contains(out.String(), `"changed":false`) || !strings.Contains(out.String(), `"changes":[]`) {
+		t.Errorf("unchanged JSON = %s", out.String())
+	}
+
+	out.Reset()
+	failed := newRunResult("check", changes, 2, 4)
+	failed.write(&out, false)
+	if failed.exitCode() != checkExitFailed || !strings.Contains(out.String(), "Check failed (2 of 4") {
+		t.Errorf("failed result: exit %d, output %q", failed.exitCode(), out.String())
+	}
+	if !strings.HasPrefix(out.String(), "would delete A a.example.com (203.0.113.9)\nwould create A b.example.com -> 203.0.113.1\n") {
+		t.Errorf("plan = %q", out.String())
+	}
+}
+
+func TestCleanupCheckPlansWithoutDeleting(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	now := time.Unix(1700000000, 0)
+	config := &Config{InternalDomain: "nas.int.example.com", ExternalDomain: "home.example.com",
+		StaleThreshold: 3600, clock: newFakeClock(now)}
+	fake.add("A", "nas.int.example.com", "192.168.1.10")
+	fake.add("TXT", "nas.int.example.com", heartbeatContent(now.Add(-2*time.Hour)))
+	fake.add("TXT", "_changelog.nas.int.example.com", "v1")
+	fake.add("A", "home.example.com", "203.0.113.1")
+	fake.add("TXT", "home.example.com", heartbeatContent(now))
+
+	result := runCleanupCheck(cf, config)
+	if result.exitCode() != checkExitChanged || len(result.Changes) != 3 {
+		t.Fatalf("exit %d, changes %+v", result.exitCode(), result.Changes)
+	}
+	var out strings.Builder
+	result.write(&out, false)
+	if !strings.HasPrefix(out.String(), "would delete stale A nas.int.example.com (192.168.1.10): stale heartbeat (age: 7200s)\n") {
+		t.Errorf("plan = %q", out.String())
+	}
+	if got := fake.contents("nas.int.example.com", "A"); len(got) != 1 {
+		t.Errorf("records deleted by a check: %v", got)
+	}
+
+	// Nothing stale: in sync
+	config.clock = newFakeClock(now.Add(-2 * time.Hour))
+	if result := runCleanupCheck(cf, config); result.exitCode() != checkExitInSync {
+		t.Errorf("exit %d with fresh heartbeats, changes %+v", result.exitCode(), result.Changes)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of heartbeats and cleanup. Production code uses the system
+// clock; tests install a fake one to age heartbeats without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock reads time.Now. Its readings carry Go's monotonic clock, so durations
+// between them (Sub, Since) are unaffected by NTP steps; heartbeat timestamps are
+// compared across hosts and use the wall clock (Unix).
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// now returns the current time of the configured clock
+func (c *Config) now() time.Time {
+	if c.clock == nil {
+		c.clock = systemClock{}
+	}
+	return c.clock.Now()
+}
+
+// Random is the source of random delays: update jitter and retry backoff. Production code
+// draws from math/rand; -replay installs a seeded source so that a replayed run waits
+// exactly as long on every replay.
+type Random interface {
+	Int63n(n int64) int64
+}
+
+// systemRandom draws from the global math/rand source
+type systemRandom struct{}
+
+func (systemRandom) Int63n(n int64) int64 {
+	return rand.Int63n(n)
+}
+
+// seededRandom is a deterministic source, safe for the concurrent batch updates
+type seededRandom struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newSeededRandom(seed int64) *seededRandom {
+	return &seededRandom{r: rand.New(rand.NewSource(seed))}
+}
+
+func (s *seededRandom) Int63n(n int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Int63n(n)
+}
+
+// jitterSource draws the random delays (replaced by -replay and tests)
+var jitterSource Random = systemRandom{}
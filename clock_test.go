@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when told to
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock(t time.Time) *fakeClock {
+	return &fakeClock{t: t}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// advance moves the clock forward by d
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestHeartbeatUsesClock(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	config := &Config{ExternalDomain: "home.example.com", clock: clock}
+
+	reconcileRecords(cf, config, &IPAddresses{ExternalIPv4: "203.0.113.1"}, config.now())
+	if got := fake.contents("home.example.com", "TXT"); len(got) != 1 || got[0] != `"1700000000"` {
+		t.Fatalf("heartbeat = %v, want \"1700000000\"", got)
+	}
+
+	clock.advance(90 * time.Second)
+	reconcileRecords(cf, config, &IPAddresses{ExternalIPv4: "203.0.113.1"}, config.now())
+	if got := fake.contents("home.example.com", "TXT"); len(got) != 1 || got[0] != `"1700000090"` {
+		t.Errorf("heartbeat after 90s = %v, want \"1700000090\"", got)
+	}
+}
+
+func TestCleanupStalenessFollowsClock(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	fake.add("TXT", "nas.example.com", strconv.FormatInt(clock.Now().Unix(), 10))
+	fake.add("A", "nas.example.com", "192.168.1.10")
+	config := &Config{InternalDomain: "nas.example.com", StaleThreshold: 3600, clock: clock}
+
+	// Exactly at the threshold the heartbeat is not stale yet
+	clock.advance(time.Hour)
+	runCleanup(cf, config)
+	if got := fake.contents("nas.example.com", "A"); len(got) != 1 {
+		t.Fatalf("cleaned up at the threshold: A records %v", got)
+	}
+
+	clock.advance(time.Second)
+	runCleanup(cf, config)
+	if got := fake.contents("nas.example.com", "A"); len(got) != 0 {
+		t.Errorf("A records left after the threshold: %v", got)
+	}
+	if got := fake.contents("nas.example.com", "TXT"); len(got) != 0 {
+		t.Errorf("heartbeat left after the threshold: %v", got)
+	}
+}
+
+func TestConfigDefaultsToSystemClock(t *testing.T) {
+	config := &Config{}
+	before := time.Now()
+	now := config.now()
+	if now.Before(before) || now.After(time.Now()) {
+		t.Errorf("now() = %s, not the system time", now)
+	}
+	if _, ok := config.clock.(systemClock); !ok {
+		t.Errorf("clock = %T, want systemClock", config.clock)
+	}
+}
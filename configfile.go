@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Key in a config file listing other config files to merge in first
+const configIncludeKey = "!include"
+
+// Values loaded from the config file (BEES_IP_UPDATE_CONFIG_FILE), keyed like
+// environment variables without the prefix. Environment variables take precedence.
+var configFileValues = make(map[string]string)
+
+// Track which config file keys have been consumed
+var consumedConfigKeys = make(map[string]bool)
+
+// configFileValue returns the config file value for key and tracks consumption
+func configFileValue(key string) string {
+	value, ok := configFileValues[key]
+	if ok {
+		consumedConfigKeys[key] = true
+	}
+	return value
+}
+
+// normalizeConfigKey maps a config file key ("external_domain", "BEES_IP_UPDATE_EXTERNAL_DOMAIN")
+// to the environment variable name without prefix ("EXTERNAL_DOMAIN")
+func normalizeConfigKey(key string) string {
+	key = strings.ToUpper(strings.TrimSpace(key))
+	key = strings.ReplaceAll(key, "-", "_")
+	return strings.TrimPrefix(key, envPrefix)
+}
+
+// loadConfigFile reads a JSON config file of "key": value pairs (or a YAML or TOML file with
+// sections, see configformats.go), validated against config.schema.json.
+// String values support ${VAR} and ${VAR:-default} expansion from the environment ($$ is a literal $).
+// A "!include" key (a path or list of paths, relative to the including file) merges shared
+// fragments in first; keys in the including file override included ones.
+func loadConfigFile(path string) (map[string]string, error) {
+	return loadConfigFileChain(path, nil)
+}
+
+func loadConfigFileChain(path string, chain []string) (map[string]string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, seen := range chain {
+		if seen == absPath {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(chain, " -> "), absPath)
+		}
+	}
+	chain = append(chain, absPath)
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := parseConfigFileData(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+
+	// Includes first, so the including file overrides them
+	if include, ok := raw[configIncludeKey]; ok {
+		paths, err := configStringList(include)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, configIncludeKey, err)
+		}
+		for _, includePath := range paths {
+			if includePath, err = expandConfigValue(includePath); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, configIncludeKey, err)
+			}
+			if !filepath.IsAbs(includePath) {
+				includePath = filepath.Join(filepath.Dir(absPath), includePath)
+			}
+			included, err := loadConfigFileChain(includePath, chain)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range included {
+				values[k] = v
+			}
+		}
+	}
+
+	for key, value := range raw {
+		if key == configIncludeKey || key == "$schema" {
+			continue
+		}
+		str, err := configValueString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		if str, err = expandConfigValue(str); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		values[normalizeConfigKey(key)] = str
+	}
+
+	return values, nil
+}
+
+// configValueString converts a JSON value to its environment-variable string form.
+// Lists become comma-separated, matching list-valued variables such as IPV4_SOURCES.
+func configValueString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items, err := configStringList(v)
+		return strings.Join(items, ","), err
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T (use a string, number, boolean or list)", value)
+	}
+}
+
+// configStringList accepts a single string or a list of scalars
+func configStringList(value interface{}) ([]string, error) {
+	if s, ok := value.(string); ok {
+		return []string{s}, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a string or a list of strings")
+	}
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		if _, nested := item.([]interface{}); nested {
+			return nil, fmt.Errorf("nested lists are not supported")
+		}
+		s, err := configValueString(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, s)
+	}
+	return items, nil
+}
+
+// expandConfigValue expands ${VAR} and ${VAR:-default} references from the environment.
+// Referencing an unset variable without a default is an error, so a missing secret
+// does not silently become an empty value.
+func expandConfigValue(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '$' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 < len(value) && value[i+1] == '$' {
+			b.WriteByte('$')
+			i++
+			continue
+		}
+		if i+1 >= len(value) || value[i+1] != '{' {
+			b.WriteByte(c)
+			continue
+		}
+
+		end := strings.IndexByte(value[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", value)
+		}
+		expr := value[i+2 : i+end]
+		name, defaultValue, hasDefault := strings.Cut(expr, ":-")
+		if name == "" {
+			return "", fmt.Errorf("empty variable name in %q", value)
+		}
+
+		if env, ok := os.LookupEnv(name); ok && env != "" {
+			b.WriteString(env)
+		} else if hasDefault {
+			b.WriteString(defaultValue)
+		} else {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		i += end
+	}
+	return b.String(), nil
+}
+
+// configFileFlag is the config file given with -config, which takes the place of CONFIG_FILE
+var configFileFlag string
+
+// loadConfigFileFromEnv loads the config file named by -config or CONFIG_FILE (if set)
+func loadConfigFileFromEnv() {
+	path := getEnv("CONFIG_FILE")
+	if configFileFlag != "" {
+		path = configFileFlag
+	}
+	if path == "" {
+		return
+	}
+
+	values, err := loadConfigFile(path)
+	if err != nil {
+		configFatalf("ERROR: Could not load config file: %v", err)
+	}
+	configFileValues = values
+	log.Printf("Loaded %d setting(s) from config file %s (environment variables take precedence)", len(values), path)
+}
+
+// validateUnusedConfigKeys warns about config file keys that were not consumed
+func validateUnusedConfigKeys() {
+	var unused []string
+	for key := range configFileValues {
+		if !consumedConfigKeys[key] && os.Getenv(envPrefix+key) == "" {
+			unused = append(unused, key)
+		}
+	}
+	if len(unused) == 0 {
+		return
+	}
+
+	sort.Strings(unused)
+	log.Printf("WARNING: Found %d unused config file key(s):", len(unused))
+	for _, key := range unused {
+		log.Printf("  - %s", key)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExpandConfigValue(t *testing.T) {
+	t.Setenv("DYNIP_TEST_TOKEN", "secret")
+	t.Setenv("DYNIP_TEST_EMPTY", "")
+
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"${DYNIP_TEST_TOKEN}", "secret"},
+		{"pre-${DYNIP_TEST_TOKEN}-post", "pre-secret-post"},
+		{"${DYNIP_TEST_UNSET:-fallback}", "fallback"},
+		{"${DYNIP_TEST_EMPTY:-fallback}", "fallback"},
+		{"cost $5", "cost $5"},
+		{"$${DYNIP_TEST_TOKEN}", "${DYNIP_TEST_TOKEN}"},
+	}
+	for _, tt := range tests {
+		got, err := expandConfigValue(tt.in)
+		if err != nil {
+			t.Errorf("expandConfigValue(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expandConfigValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"${DYNIP_TEST_UNSET}", "${DYNIP_TEST_TOKEN", "${}"} {
+		if _, err := expandConfigValue(bad); err == nil {
+			t.Errorf("expandConfigValue(%q) should fail", bad)
+		}
+	}
+}
+
+func TestLoadConfigFileInclude(t *testing.T) {
+	t.Setenv("DYNIP_TEST_TOKEN", "secret")
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "creds.json", `{"cf_api_token": "${DYNIP_TEST_TOKEN}", "cf_zone_id": "zone-a"}`)
+	path := writeConfigFile(t, dir, "laptop.json", `{
+		"!include": "creds.json",
+		"cf_zone_id": "zone-b",
+		"external_domain": "laptop.example.com",
+		"cf_proxied": false,
+		"verify_timeout_seconds": 60,
+		"ipv4_sources": ["https://a.example", "https://b.example"]
+	}`)
+
+	values, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"CF_API_TOKEN":           "secret",
+		"CF_ZONE_ID":             "zone-b",
+		"EXTERNAL_DOMAIN":        "laptop.example.com",
+		"CF_PROXIED":             "false",
+		"VERIFY_TIMEOUT_SECONDS": "60",
+		"IPV4_SOURCES":           "https://a.example,https://b.example",
+	}
+	for key, v := range want {
+		if values[key] != v {
+			t.Errorf("%s = %q, want %q", key, values[key], v)
+		}
+	}
+}
+
+func TestLoadConfigFileIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "a.json", `{"!include": "b.json"}`)
+	writeConfigFile(t, dir, "b.json", `{"!include": ["a.json"]}`)
+
+	_, err := loadConfigFile(filepath.Join(dir, "a.json"))
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected include cycle error, got %v", err)
+	}
+}
+
+func TestGetEnvFallsBackToConfigFile(t *testing.T) {
+	saved := configFileValues
+	t.Cleanup(func() { configFileValues = saved })
+	configFileValues = map[string]string{"EXTERNAL_DOMAIN": "file.example.com", "IPV6_DOMAIN": "v6.example.com"}
+
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "env.example.com")
+	t.Setenv(envPrefix+"IPV6_DOMAIN", "")
+
+	if got := getEnv("EXTERNAL_DOMAIN"); got != "env.example.com" {
+		t.Errorf("environment should take precedence, got %q", got)
+	}
+	if got := getEnv("IPV6_DOMAIN"); got != "v6.example.com" {
+		t.Errorf("expected config file value, got %q", got)
+	}
+}
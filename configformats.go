@@ -0,0 +1,498 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Structured config files. Besides the flat JSON file, a config file may be YAML (.yaml, .yml)
+// or TOML (.toml) with sections instead of the numbered and paired keys:
+//
+//	provider: cloudflare
+//	providers:
+//	  cloudflare: {api_token: "${CF_TOKEN}", zone_id: 0123456789abcdef}
+//	domains:
+//	  external: {name: home.example.com, ttl: 300}
+//	ranges:
+//	  ipv4:
+//	    - {cidr: 100.64.0.0/10, domain: vpn.example.com}
+//	ttls: {record: 120, heartbeat: 60}
+//	cleanup: {stale_threshold_seconds: 3600}
+//
+// The sections are flattened into the same keys the JSON file uses (domains.external.ttl ->
+// external_domain_ttl, ranges.ipv4[0].cidr -> ipv4_range_1) and validated against the schema,
+// so everything else (includes, ${VAR} expansion, environment precedence) works the same.
+// Both parsers implement the subset of the formats such files need: anchors, tags, block and
+// multi-line strings are rejected rather than misread.
+
+// configFileFormat returns the format of a config file by its extension: "yaml", "toml" or "json"
+func configFileFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+type configNodeKind int
+
+const (
+	scalarNode configNodeKind = iota
+	mapNode
+	listNode
+)
+
+// configNode is a value of a YAML or TOML config file with its position
+type configNode struct {
+	Kind     configNodeKind
+	Value    interface{}    // Scalars: string, float64, bool or nil
+	Fields   []*configField // Mappings, in file order
+	Items    []*configNode  // Lists
+	Position configPosition
+}
+
+// configField is one key of a mapping
+type configField struct {
+	Key      string
+	Node     *configNode
+	Position configPosition
+}
+
+// field returns the value of key in a mapping, or nil
+func (n *configNode) field(key string) *configNode {
+	for _, f := range n.Fields {
+		if f.Key == key {
+			return f.Node
+		}
+	}
+	return nil
+}
+
+// plain converts a scalar or a list of scalars to the value a JSON config file would hold
+func (n *configNode) plain() (interface{}, bool) {
+	switch n.Kind {
+	case scalarNode:
+		return n.Value, true
+	case listNode:
+		list := make([]interface{}, 0, len(n.Items))
+		for _, item := range n.Items {
+			if item.Kind != scalarNode {
+				return nil, false
+			}
+			list = append(list, item.Value)
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+// providerConfigPrefixes maps provider sections to the prefix of their keys where the two differ
+var providerConfigPrefixes = map[string]string{
+	providerCloudFlare: "cf",
+	"hosts":            "hosts_file",
+	"zonefile":         "zone_file",
+}
+
+// scanStructuredConfig parses a YAML or TOML config file into the entries of the equivalent
+// flat config file
+func scanStructuredConfig(schema *configSchema, path string, data []byte) ([]configEntry, error) {
+	var root *configNode
+	var err error
+	if configFileFormat(path) == "toml" {
+		root, err = parseTOMLConfig(path, data)
+	} else {
+		root, err = parseYAMLConfig(path, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if root.Kind != mapNode {
+		return nil, fmt.Errorf("%s:%s: config file must be a mapping of settings", path, root.Position)
+	}
+	f := &configFlattener{schema: schema, path: path}
+	for _, field := range root.Fields {
+		if err := f.section(field); err != nil {
+			return nil, err
+		}
+	}
+	return f.entries, nil
+}
+
+// configFlattener collects the flat entries of a structured config file
+type configFlattener struct {
+	schema  *configSchema
+	path    string
+	entries []configEntry
+}
+
+// resolve returns the first candidate key the schema knows, or the first candidate so that
+// validation reports it (with a suggestion)
+func (f *configFlattener) resolve(candidates ...string) string {
+	for _, key := range candidates {
+		if f.schema.lookup(key) != nil {
+			return key
+		}
+	}
+	return candidates[0]
+}
+
+// set adds a flat entry, which must hold a value rather than a section
+func (f *configFlattener) set(key string, field *configField) error {
+	value, ok := field.Node.plain()
+	if !ok {
+		return fmt.Errorf("%s:%s: %s: expected a value or a list of values, got a section", f.path, field.Position, field.Key)
+	}
+	f.entries = append(f.entries, configEntry{Key: key, Value: value, Position: field.Position})
+	return nil
+}
+
+// mapping returns the fields of a section, which must be a mapping
+func (f *configFlattener) mapping(field *configField) ([]*configField, error) {
+	if field.Node.Kind != mapNode {
+		return nil, fmt.Errorf("%s:%s: %s: expected a section of settings", f.path, field.Position, field.Key)
+	}
+	return field.Node.Fields, nil
+}
+
+// section flattens one top-level key
+func (f *configFlattener) section(field *configField) error {
+	name := structuredConfigKey(field.Key)
+	if field.Key == configIncludeKey || field.Key == "$schema" {
+		name = field.Key
+	}
+	if _, ok := field.Node.plain(); ok {
+		return f.set(name, field)
+	}
+	if name == "services" {
+		return f.list(field, "service", "name")
+	}
+	fields, err := f.mapping(field)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range fields {
+		key := structuredConfigKey(sub.Key)
+		switch name {
+		case "providers":
+			prefix, ok := providerConfigPrefixes[key]
+			if !ok {
+				prefix = key
+			}
+			settings, err := f.mapping(sub)
+			if err != nil {
+				return err
+			}
+			for _, setting := range settings {
+				k := structuredConfigKey(setting.Key)
+				if err := f.set(f.resolve(prefix+"_"+k, k), setting); err != nil {
+					return err
+				}
+			}
+		case "domains":
+			// external: home.example.com, or external: {name: ..., ttl: ..., proxied: ...}
+			if sub.Node.Kind != mapNode {
+				err = f.set(key+"_domain", sub)
+				break
+			}
+			for _, setting := range sub.Node.Fields {
+				k := key + "_domain"
+				if s := structuredConfigKey(setting.Key); s != "name" {
+					k += "_" + s
+				}
+				if err := f.set(k, setting); err != nil {
+					return err
+				}
+			}
+		case "ranges":
+			err = f.list(sub, key+"_range", "cidr")
+		case "ttls":
+			err = f.set(f.resolve(key+"_ttl", key+"_domain_ttl", key), sub)
+		case "cleanup":
+			err = f.set(f.resolve("cleanup_"+key, key), sub)
+		default:
+			err = f.nested(name, sub)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nested flattens any other section by joining the keys: local_mirror: {server: ...} is
+// local_mirror_server
+func (f *configFlattener) nested(prefix string, field *configField) error {
+	key := prefix + "_" + structuredConfigKey(field.Key)
+	if _, ok := field.Node.plain(); ok {
+		return f.set(key, field)
+	}
+	fields, err := f.mapping(field)
+	if err != nil {
+		return err
+	}
+	for _, sub := range fields {
+		if err := f.nested(key, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list flattens a list of numbered entries: the main field of the N-th item becomes
+// <prefix>_N and its other fields <prefix>_N_<field>
+func (f *configFlattener) list(field *configField, prefix, mainField string) error {
+	if field.Node.Kind != listNode {
+		return fmt.Errorf("%s:%s: %s: expected a list", f.path, field.Position, field.Key)
+	}
+	for i, item := range field.Node.Items {
+		if item.Kind != mapNode {
+			return fmt.Errorf("%s:%s: %s: item %d must be a mapping with %q", f.path, item.Position, field.Key, i+1, mainField)
+		}
+		base := fmt.Sprintf("%s_%d", prefix, i+1)
+		for _, setting := range item.Fields {
+			key := base
+			if s := structuredConfigKey(setting.Key); s != mainField {
+				key += "_" + s
+			}
+			if err := f.set(key, setting); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// structuredConfigKey normalizes a section or setting name ("Zone-ID" -> "zone_id")
+func structuredConfigKey(key string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(key), "-", "_"))
+}
+
+// stripConfigComment removes a # comment outside quoted strings. YAML only starts comments
+// after whitespace (so "a#b" is a value); TOML starts them anywhere.
+func stripConfigComment(line string, afterSpace bool) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// YAML quotes only open a scalar, not in the middle of one ("it's")
+			if !afterSpace || i == 0 || strings.IndexByte(" \t[{,:-", line[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (!afterSpace || i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// configNumberPattern matches decimal numbers (TOML's "_" separators are removed first)
+var configNumberPattern = regexp.MustCompile(`^[-+]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?$`)
+
+// parseConfigNumber parses a decimal number
+func parseConfigNumber(s string) (float64, bool) {
+	if !configNumberPattern.MatchString(s) {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return n, err == nil
+}
+
+// flowParser parses inline values: quoted strings, [lists] and {mappings} (YAML flow
+// collections and TOML arrays and inline tables)
+type flowParser struct {
+	path   string
+	s      string
+	i      int
+	pos    configPosition // Position of s[0]
+	keySep byte           // ':' in YAML, '=' in TOML
+	depth  int            // Nesting of the current collection
+	scalar func(token string) (interface{}, error)
+}
+
+func (p *flowParser) errorf(format string, args ...interface{}) error {
+	pos := p.pos
+	pos.Column += p.i
+	return fmt.Errorf("%s:%s: %s", p.path, pos, fmt.Sprintf(format, args...))
+}
+
+func (p *flowParser) position() configPosition {
+	pos := p.pos
+	pos.Column += p.i
+	return pos
+}
+
+func (p *flowParser) skipSpace() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// parseAll parses the whole input as one value
+func (p *flowParser) parseAll() (*configNode, error) {
+	node, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.i < len(p.s) {
+		return nil, p.errorf("unexpected %q after the value", p.s[p.i:])
+	}
+	return node, nil
+}
+
+func (p *flowParser) value() (*configNode, error) {
+	p.skipSpace()
+	pos := p.position()
+	if p.i >= len(p.s) {
+		return nil, p.errorf("missing value")
+	}
+	switch p.s[p.i] {
+	case '[':
+		p.i++
+		p.depth++
+		defer func() { p.depth-- }()
+		node := &configNode{Kind: listNode, Position: pos}
+		for {
+			p.skipSpace()
+			if p.i < len(p.s) && p.s[p.i] == ']' {
+				p.i++
+				return node, nil
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			node.Items = append(node.Items, item)
+			if err := p.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		p.i++
+		p.depth++
+		defer func() { p.depth-- }()
+		node := &configNode{Kind: mapNode, Position: pos}
+		for {
+			p.skipSpace()
+			if p.i < len(p.s) && p.s[p.i] == '}' {
+				p.i++
+				return node, nil
+			}
+			keyPos := p.position()
+			key, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if node.field(key) != nil {
+				return nil, fmt.Errorf("%s:%s: duplicate key %q", p.path, keyPos, key)
+			}
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			node.Fields = append(node.Fields, &configField{Key: key, Node: value, Position: keyPos})
+			if err := p.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		s, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		return &configNode{Kind: scalarNode, Value: s, Position: pos}, nil
+	}
+
+	// Unquoted values end at the separators of the collection they are in, if any
+	start := p.i
+	for p.i < len(p.s) && (p.depth == 0 || strings.IndexByte(",]}", p.s[p.i]) < 0) {
+		p.i++
+	}
+	value, err := p.scalar(strings.TrimSpace(p.s[start:p.i]))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s: %v", p.path, pos, err)
+	}
+	return &configNode{Kind: scalarNode, Value: value, Position: pos}, nil
+}
+
+// separator consumes the "," between items, or stops before the closing bracket
+func (p *flowParser) separator(closing byte) error {
+	p.skipSpace()
+	switch {
+	case p.i < len(p.s) && p.s[p.i] == ',':
+		p.i++
+		return nil
+	case p.i < len(p.s) && p.s[p.i] == closing:
+		return nil
+	}
+	return p.errorf("expected \",\" or %q", closing)
+}
+
+// key reads a key of an inline mapping and its separator
+func (p *flowParser) key() (string, error) {
+	p.skipSpace()
+	var key string
+	if p.i < len(p.s) && (p.s[p.i] == '"' || p.s[p.i] == '\'') {
+		var err error
+		if key, err = p.quoted(); err != nil {
+			return "", err
+		}
+	} else {
+		start := p.i
+		for p.i < len(p.s) && p.s[p.i] != p.keySep && strings.IndexByte(",]}", p.s[p.i]) < 0 {
+			p.i++
+		}
+		key = strings.TrimSpace(p.s[start:p.i])
+	}
+	p.skipSpace()
+	if key == "" || p.i >= len(p.s) || p.s[p.i] != p.keySep {
+		return "", p.errorf("expected \"key %c value\"", p.keySep)
+	}
+	p.i++
+	return key, nil
+}
+
+// quoted reads a "double" (with escapes) or 'single' quoted string. In YAML, two single
+// quotes in a row are a literal quote; TOML has no escapes in single quotes.
+func (p *flowParser) quoted() (string, error) {
+	quote := p.s[p.i]
+	start := p.i
+	for p.i++; p.i < len(p.s); p.i++ {
+		switch c := p.s[p.i]; {
+		case quote == '"' && c == '\\':
+			p.i++
+		case c == quote && quote == '\'' && p.keySep == ':' && p.i+1 < len(p.s) && p.s[p.i+1] == '\'':
+			p.i++
+		case c == quote:
+			p.i++
+			raw := p.s[start:p.i]
+			if quote == '\'' {
+				inner := raw[1 : len(raw)-1]
+				if p.keySep == ':' {
+					inner = strings.ReplaceAll(inner, "''", "'")
+				}
+				return inner, nil
+			}
+			s, err := strconv.Unquote(raw)
+			if err != nil {
+				return "", fmt.Errorf("%s:%s: invalid string %s", p.path, p.pos, raw)
+			}
+			return s, nil
+		}
+	}
+	p.i = start
+	return "", p.errorf("unterminated string")
+}
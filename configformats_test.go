@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// structuredConfigWant is the flat equivalent of the YAML and TOML test files
+var structuredConfigWant = map[string]string{
+	"PROVIDER":                 "cloudflare",
+	"CF_API_TOKEN":             "secret",
+	"CF_ZONE_ID":               "0123456789abcdef",
+	"EXTERNAL_DOMAIN":          "home.example.com",
+	"EXTERNAL_DOMAIN_TTL":      "300",
+	"EXTERNAL_DOMAIN_PROXIED":  "true",
+	"INTERNAL_DOMAIN":          "lan.example.com",
+	"IPV4_RANGE_1":             "100.64.0.0/10",
+	"IPV4_RANGE_1_DOMAIN":      "vpn.example.com",
+	"IPV4_RANGE_2":             "172.16.0.0/12",
+	"IPV4_RANGE_2_DOMAIN":      "lab.example.com",
+	"IPV4_RANGE_2_TTL":         "60",
+	"RECORD_TTL":               "120",
+	"COMBINED_DOMAIN_TTL":      "600",
+	"STALE_THRESHOLD_SECONDS":  "7200",
+	"CLEANUP_INTERVAL_SECONDS": "60",
+	"SERVICE_1":                "minecraft",
+	"SERVICE_1_PORT":           "25565",
+	"LOCAL_MIRROR_SERVER":      "127.0.0.1:53",
+	"IPV4_SOURCES":             "https://a.example,https://b.example",
+	"TUNNEL_ID":                "abc#def",
+}
+
+func checkStructuredConfig(t *testing.T, path string) {
+	t.Helper()
+	values, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range structuredConfigWant {
+		if values[key] != want {
+			t.Errorf("%s = %q, want %q", key, values[key], want)
+		}
+	}
+	if len(values) != len(structuredConfigWant) {
+		t.Errorf("got %d settings, want %d: %v", len(values), len(structuredConfigWant), values)
+	}
+}
+
+func TestLoadYAMLConfigFile(t *testing.T) {
+	t.Setenv("DYNIP_TEST_TOKEN", "secret")
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "creds.yaml", `
+providers:
+  cloudflare:
+    api_token: ${DYNIP_TEST_TOKEN}
+    zone_id: "0123456789abcdef"
+`)
+	path := writeConfigFile(t, dir, "home.yml", `# Home router
+---
+"!include": creds.yaml
+provider: cloudflare
+domains:
+  external:
+    name: home.example.com   # the public name
+    ttl: 300
+    proxied: true
+  internal: lan.example.com
+ranges:
+  ipv4:
+  - cidr: 100.64.0.0/10
+    domain: vpn.example.com
+  - {cidr: 172.16.0.0/12, domain: lab.example.com, ttl: 60}
+ttls: {record: 120, combined: 600}
+cleanup:
+  stale_threshold_seconds: 7200
+  interval_seconds: 60
+services:
+  - name: minecraft
+    port: 25565
+local_mirror:
+  server: '127.0.0.1:53'
+ipv4_sources: [https://a.example, "https://b.example"]
+tunnel:
+  id: abc#def
+`)
+	checkStructuredConfig(t, path)
+}
+
+func TestLoadTOMLConfigFile(t *testing.T) {
+	t.Setenv("DYNIP_TEST_TOKEN", "secret")
+	dir := t.TempDir()
+	path := writeConfigFile(t, dir, "home.toml", `# Home router
+provider = "cloudflare"
+ipv4_sources = [
+  "https://a.example", # primary
+  "https://b.example",
+]
+tunnel.id = "abc#def"
+
+[providers.cloudflare]
+api_token = "${DYNIP_TEST_TOKEN}"
+zone_id = '0123456789abcdef'
+
+[domains]
+internal = "lan.example.com"
+external = { name = "home.example.com", ttl = 300, proxied = true }
+
+[[ranges.ipv4]]
+cidr = "100.64.0.0/10"
+domain = "vpn.example.com"
+
+[[ranges.ipv4]]
+cidr = "172.16.0.0/12"
+domain = "lab.example.com"
+ttl = 60
+
+[ttls]
+record = 120
+combined = 600
+
+[cleanup]
+stale_threshold_seconds = 7_200
+interval_seconds = 60
+
+[[services]]
+name = "minecraft"
+port = 25565
+
+[local_mirror]
+server = "127.0.0.1:53"
+`)
+	checkStructuredConfig(t, path)
+}
+
+func TestStructuredConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"bad.yaml", "domains:\n  external: home.example.com\n    ttl: 300\n", "bad.yaml:3:5: unexpected indentation"},
+		{"bad.yaml", "domains:\n  externl: home.example.com\n", `bad.yaml:2:3: unknown key "externl_domain" (did you mean "external_domain"?)`},
+		{"bad.yaml", "ttls:\n  record: soon\n", "bad.yaml:2:3: record_ttl: expected integer, got string"},
+		{"bad.yaml", "external_domain: a.example.com\ndomains: {external: b.example.com}\n", `bad.yaml:2:11: duplicate key "external_domain" (first set at 1:1)`},
+		{"bad.yaml", "note: |\n  text\n", "bad.yaml:1:7: block scalars are not supported"},
+		{"bad.yaml", "ranges:\n  ipv4: [10.0.0.0/8]\n", "item 1 must be a mapping"},
+		{"bad.toml", "external_domain = home.example.com\n", "bad.toml:1:19: invalid value"},
+		{"bad.toml", "[domains]\nexternal = \"a\"\nexternal = \"b\"\n", `bad.toml:3:1: duplicate key "external"`},
+		{"bad.toml", "ipv4_sources = [\"a\"\n", `bad.toml:1:21: expected "," or ']'`},
+	}
+	for _, tt := range tests {
+		path := writeConfigFile(t, t.TempDir(), tt.name, tt.content)
+		_, err := loadConfigFile(path)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error = %v, want %q", tt.content, err, tt.want)
+		}
+	}
+}
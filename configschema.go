@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// configSchemaJSON is the JSON Schema for config files (also shipped as config.schema.json
+// so editors can offer completion and validation)
+//
+//go:embed config.schema.json
+var configSchemaJSON []byte
+
+// schemaProperty is the subset of JSON Schema used by config.schema.json
+type schemaProperty struct {
+	Description string          `json:"description"`
+	Type        schemaTypes     `json:"type"`
+	Items       *schemaProperty `json:"items"`
+	Enum        []interface{}   `json:"enum"`
+	Minimum     *float64        `json:"minimum"`
+}
+
+// schemaTypes accepts "type": "string" as well as "type": ["array", "string"]
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*t = multiple
+	return nil
+}
+
+type configSchema struct {
+	Properties        map[string]*schemaProperty `json:"properties"`
+	PatternProperties map[string]*schemaProperty `json:"patternProperties"`
+	patterns          map[*regexp.Regexp]*schemaProperty
+}
+
+// loadConfigSchema parses the embedded schema
+func loadConfigSchema() (*configSchema, error) {
+	var schema configSchema
+	if err := json.Unmarshal(configSchemaJSON, &schema); err != nil {
+		return nil, fmt.Errorf("parsing embedded config schema: %w", err)
+	}
+	schema.patterns = make(map[*regexp.Regexp]*schemaProperty)
+	for pattern, prop := range schema.PatternProperties {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("config schema pattern %q: %w", pattern, err)
+		}
+		schema.patterns[re] = prop
+	}
+	return &schema, nil
+}
+
+// lookup returns the schema for a (lower case) key, or nil if the key is not allowed
+func (s *configSchema) lookup(key string) *schemaProperty {
+	if prop, ok := s.Properties[key]; ok {
+		return prop
+	}
+	for re, prop := range s.patterns {
+		if re.MatchString(key) {
+			return prop
+		}
+	}
+	return nil
+}
+
+// configPosition is a 1-based line/column in a config file
+type configPosition struct {
+	Line, Column int
+}
+
+func (p configPosition) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// positionAt converts a byte offset into a line/column
+func positionAt(data []byte, offset int64) configPosition {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return configPosition{Line: line, Column: column}
+}
+
+// configEntry is one top-level key of a config file with its position
+type configEntry struct {
+	Key      string
+	Value    interface{}
+	Position configPosition
+}
+
+// scanConfigEntries parses the top-level object of a config file, keeping key positions
+func scanConfigEntries(path string, data []byte) ([]configEntry, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	syntaxError := func(err error) error {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			return fmt.Errorf("%s:%s: %v", path, positionAt(data, syntax.Offset), err)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%s:%s: unexpected end of file", path, positionAt(data, int64(len(data))))
+		}
+		return fmt.Errorf("%s:%s: %v", path, positionAt(data, dec.InputOffset()), err)
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, syntaxError(err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("%s:1:1: config file must be a JSON object of \"key\": value pairs", path)
+	}
+
+	var entries []configEntry
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, syntaxError(err)
+		}
+		key := tok.(string)
+		quoted, _ := json.Marshal(key)
+		position := positionAt(data, dec.InputOffset()-int64(len(quoted)))
+
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return nil, syntaxError(err)
+		}
+		entries = append(entries, configEntry{Key: key, Value: value, Position: position})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, syntaxError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%s:%s: unexpected data after the config object", path, positionAt(data, dec.InputOffset()))
+	}
+	return entries, nil
+}
+
+// validateConfigEntries checks entries against the schema, returning one message per problem
+func validateConfigEntries(schema *configSchema, path string, entries []configEntry) []string {
+	var problems []string
+	seen := make(map[string]configPosition)
+
+	for _, e := range entries {
+		key := e.Key
+		if key != configIncludeKey && key != "$schema" {
+			key = strings.ToLower(normalizeConfigKey(key))
+		}
+		where := fmt.Sprintf("%s:%s", path, e.Position)
+
+		if first, dup := seen[key]; dup {
+			problems = append(problems, fmt.Sprintf("%s: duplicate key %q (first set at %s)", where, e.Key, first))
+			continue
+		}
+		seen[key] = e.Position
+
+		prop := schema.lookup(key)
+		if prop == nil {
+			msg := fmt.Sprintf("%s: unknown key %q", where, e.Key)
+			if suggestion := suggestConfigKey(schema, key); suggestion != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+			}
+			problems = append(problems, msg)
+			continue
+		}
+		if problem := checkSchemaValue(prop, e.Value); problem != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s: %s", where, e.Key, problem))
+		}
+	}
+	return problems
+}
+
+// jsonTypeName returns the JSON Schema type name of a decoded value
+func jsonTypeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// checkSchemaValue validates a value against a property schema ("" if valid)
+func checkSchemaValue(prop *schemaProperty, value interface{}) string {
+	actual := jsonTypeName(value)
+	typeOK := len(prop.Type) == 0
+	for _, t := range prop.Type {
+		if t == actual || (t == "number" && actual == "integer") {
+			typeOK = true
+		}
+	}
+	if !typeOK {
+		hint := ""
+		if s, ok := value.(string); ok && strings.Contains(s, "${") {
+			hint = " (${VAR} expansion is only supported in string values)"
+		} else if s, ok := value.(string); ok && (s == "true" || s == "false") {
+			hint = fmt.Sprintf(" (write %s without quotes)", s)
+		}
+		return fmt.Sprintf("expected %s, got %s%s", strings.Join(prop.Type, " or "), actual, hint)
+	}
+
+	if len(prop.Enum) > 0 {
+		allowed := make([]string, len(prop.Enum))
+		for i, v := range prop.Enum {
+			if v == value {
+				return ""
+			}
+			allowed[i] = fmt.Sprintf("%v", v)
+		}
+		return fmt.Sprintf("must be one of %s, got %v", strings.Join(allowed, ", "), value)
+	}
+
+	if n, ok := value.(float64); ok && prop.Minimum != nil && n < *prop.Minimum {
+		return fmt.Sprintf("must be at least %v, got %v", *prop.Minimum, n)
+	}
+
+	if list, ok := value.([]interface{}); ok && prop.Items != nil {
+		for i, item := range list {
+			if problem := checkSchemaValue(prop.Items, item); problem != "" {
+				return fmt.Sprintf("item %d: %s", i+1, problem)
+			}
+		}
+	}
+	return ""
+}
+
+// suggestConfigKey returns the closest known key for a misspelt one ("" if nothing is close)
+func suggestConfigKey(schema *configSchema, key string) string {
+	known := make([]string, 0, len(schema.Properties))
+	for k := range schema.Properties {
+		known = append(known, k)
+	}
+	sort.Strings(known)
+
+	// A known key that only adds a qualifier, e.g. "proxied" -> "cf_proxied"
+	for _, k := range known {
+		if strings.HasSuffix(k, "_"+key) || strings.HasPrefix(k, key+"_") {
+			return k
+		}
+	}
+
+	// Otherwise the closest spelling, also ignoring a leading qualifier ("proxed" ~ "cf_proxied")
+	best, bestDistance := "", len(key)/3+2
+	for _, k := range known {
+		candidates := []string{k}
+		if _, unqualified, ok := strings.Cut(k, "_"); ok {
+			candidates = append(candidates, unqualified)
+		}
+		for _, c := range candidates {
+			if d := levenshtein(key, c); d < bestDistance {
+				best, bestDistance = k, d
+			}
+		}
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// parseConfigFileData parses and schema-validates a config file, returning its top-level object
+// (for YAML and TOML files, the flattened sections)
+func parseConfigFileData(path string, data []byte) (map[string]interface{}, error) {
+	schema, err := loadConfigSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []configEntry
+	if configFileFormat(path) == "json" {
+		entries, err = scanConfigEntries(path, data)
+	} else {
+		entries, err = scanStructuredConfig(schema, path, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if problems := validateConfigEntries(schema, path, entries); len(problems) > 0 {
+		return nil, fmt.Errorf("invalid config file:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	raw := make(map[string]interface{}, len(entries))
+	for _, e := range entries {
+		raw[e.Key] = e.Value
+	}
+	return raw, nil
+}
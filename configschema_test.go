@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigSchemaParses(t *testing.T) {
+	schema, err := loadConfigSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"cf_api_token", "ipv4_range_20_domain", "profile_3_ssid"} {
+		if schema.lookup(key) == nil {
+			t.Errorf("schema does not allow %q", key)
+		}
+	}
+	if schema.lookup("ipv4_range_21") != nil {
+		t.Error("schema should only allow ranges 1-20")
+	}
+}
+
+func TestParseConfigFileDataErrors(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{"typo", "{\n  \"external_domain\": \"a.example.com\",\n  \"proxed\": true\n}", `cfg.json:3:3: unknown key "proxed" (did you mean "cf_proxied"?)`},
+		{"qualifier", `{"proxied": true}`, `(did you mean "cf_proxied"?)`},
+		{"type", "{\n\t\"cf_proxied\": \"true\"\n}", `cfg.json:2:2: cf_proxied: expected boolean, got string (write true without quotes)`},
+		{"minimum", `{"stale_threshold_seconds": 0}`, `must be at least 1`},
+		{"items", `{"ipv4_sources": ["https://a.example", 5]}`, `item 2: expected string, got integer`},
+		{"duplicate", `{"cf_zone_id": "a", "CF_ZONE_ID": "b"}`, `duplicate key "CF_ZONE_ID" (first set at 1:2)`},
+		{"syntax", "{\n  \"cf_zone_id\": \"a\",,\n}", `cfg.json:2:`},
+		{"not object", `["cf_zone_id"]`, `must be a JSON object`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfigFileData("cfg.json", []byte(tt.data))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileDataValid(t *testing.T) {
+	raw, err := parseConfigFileData("cfg.json", []byte(`{
+		"$schema": "./config.schema.json",
+		"!include": ["creds.json"],
+		"BEES_IP_UPDATE_EXTERNAL_DOMAIN": "a.example.com",
+		"ipv6_range_1": "fd00::/8",
+		"profile_1_domains": ["internal", "combined"],
+		"verify_timeout_seconds": 30
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 6 {
+		t.Errorf("got %d entries, want 6", len(raw))
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	if d := levenshtein("state_fiel", "state_file"); d != 2 {
+		t.Errorf("levenshtein = %d, want 2", d)
+	}
+	if d := levenshtein("", "abc"); d != 3 {
+		t.Errorf("levenshtein = %d, want 3", d)
+	}
+}
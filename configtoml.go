@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tomlDatePattern matches TOML dates and times, which are kept as strings
+var tomlDatePattern = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}([T ][0-9:.]+(Z|[-+][0-9:]+)?)?$|^[0-9]{2}:[0-9]{2}:[0-9]{2}`)
+
+// parseTOMLConfig parses a TOML config file: key = value pairs (with dotted keys), [tables],
+// [[arrays of tables]], and arrays and inline tables as values
+func parseTOMLConfig(path string, data []byte) (*configNode, error) {
+	root := &configNode{Kind: mapNode, Position: configPosition{Line: 1, Column: 1}}
+	current := root
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		raw := strings.TrimRight(stripConfigComment(strings.TrimRight(lines[i], "\r"), false), " \t")
+		text := strings.TrimLeft(raw, " \t")
+		if text == "" {
+			continue
+		}
+		position := configPosition{Line: i + 1, Column: len(raw) - len(text) + 1}
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s:%s: %s", path, position, fmt.Sprintf(format, args...))
+		}
+
+		if text[0] == '[' {
+			array := strings.HasPrefix(text, "[[")
+			inner := strings.TrimPrefix(text, "[")
+			closing := "]"
+			if array {
+				inner, closing = strings.TrimPrefix(inner, "["), "]]"
+			}
+			if !strings.HasSuffix(inner, closing) {
+				return nil, errorf("expected %q at the end of the table header", closing)
+			}
+			keys, err := splitTOMLKey(strings.TrimSuffix(inner, closing))
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			parent, err := tomlTable(root, keys[:len(keys)-1], position)
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			last := keys[len(keys)-1]
+			existing := parent.field(last)
+			switch {
+			case array && existing == nil:
+				existing = &configNode{Kind: listNode, Position: position}
+				parent.Fields = append(parent.Fields, &configField{Key: last, Node: existing, Position: position})
+				fallthrough
+			case array && existing.Kind == listNode:
+				current = &configNode{Kind: mapNode, Position: position}
+				existing.Items = append(existing.Items, current)
+			case !array && existing == nil:
+				current = &configNode{Kind: mapNode, Position: position}
+				parent.Fields = append(parent.Fields, &configField{Key: last, Node: current, Position: position})
+			case !array && existing.Kind == mapNode:
+				current = existing
+			default:
+				return nil, errorf("%s is already defined as a value", strings.Join(keys, "."))
+			}
+			continue
+		}
+
+		keyText, valueText, ok := cutTOMLAssignment(text)
+		if !ok {
+			return nil, errorf("expected \"key = value\", got %q", text)
+		}
+		if strings.HasPrefix(valueText, `"""`) || strings.HasPrefix(valueText, "'''") {
+			return nil, errorf("multi-line strings are not supported")
+		}
+		valuePosition := position
+		valuePosition.Column += strings.Index(text, valueText)
+		// Arrays may continue over several lines until the brackets balance
+		for !tomlBalanced(valueText) && i+1 < len(lines) {
+			i++
+			valueText += " " + strings.TrimSpace(stripConfigComment(strings.TrimRight(lines[i], "\r"), false))
+		}
+		keys, err := splitTOMLKey(keyText)
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		table, err := tomlTable(current, keys[:len(keys)-1], position)
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		last := keys[len(keys)-1]
+		if table.field(last) != nil {
+			return nil, errorf("duplicate key %q", strings.Join(keys, "."))
+		}
+		flow := &flowParser{path: path, s: valueText, pos: valuePosition, keySep: '=', scalar: tomlScalar}
+		value, err := flow.parseAll()
+		if err != nil {
+			return nil, err
+		}
+		table.Fields = append(table.Fields, &configField{Key: last, Node: value, Position: position})
+	}
+	return root, nil
+}
+
+// tomlTable returns the table at the dotted path below node, creating missing tables. A path
+// through an array of tables continues in its last table.
+func tomlTable(node *configNode, keys []string, position configPosition) (*configNode, error) {
+	for i, key := range keys {
+		next := node.field(key)
+		switch {
+		case next == nil:
+			next = &configNode{Kind: mapNode, Position: position}
+			node.Fields = append(node.Fields, &configField{Key: key, Node: next, Position: position})
+		case next.Kind == listNode && len(next.Items) > 0 && next.Items[len(next.Items)-1].Kind == mapNode:
+			next = next.Items[len(next.Items)-1]
+		case next.Kind != mapNode:
+			return nil, fmt.Errorf("%s is already defined as a value", strings.Join(keys[:i+1], "."))
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// tomlScalar interprets an unquoted TOML value: booleans, numbers and dates
+func tomlScalar(token string) (interface{}, error) {
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if n, ok := parseConfigNumber(strings.ReplaceAll(token, "_", "")); ok {
+		return n, nil
+	}
+	if tomlDatePattern.MatchString(token) {
+		return token, nil
+	}
+	return nil, fmt.Errorf("invalid value %q (strings must be quoted)", token)
+}
+
+// splitTOMLKey splits a dotted key (a.b, "a.b".c) into its parts
+func splitTOMLKey(text string) ([]string, error) {
+	var keys []string
+	flow := &flowParser{s: strings.TrimSpace(text), keySep: '='}
+	for {
+		flow.skipSpace()
+		var key string
+		if flow.i < len(flow.s) && (flow.s[flow.i] == '"' || flow.s[flow.i] == '\'') {
+			quoted, err := flow.quoted()
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %q", text)
+			}
+			key = quoted
+		} else {
+			start := flow.i
+			for flow.i < len(flow.s) && flow.s[flow.i] != '.' && flow.s[flow.i] != ' ' {
+				flow.i++
+			}
+			key = flow.s[start:flow.i]
+			if key == "" {
+				return nil, fmt.Errorf("invalid key %q", text)
+			}
+		}
+		keys = append(keys, key)
+		flow.skipSpace()
+		if flow.i >= len(flow.s) {
+			return keys, nil
+		}
+		if flow.s[flow.i] != '.' {
+			return nil, fmt.Errorf("invalid key %q", text)
+		}
+		flow.i++
+	}
+}
+
+// cutTOMLAssignment splits "key = value" at the first "=" outside a quoted key
+func cutTOMLAssignment(text string) (key, value string, ok bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '=':
+			key, value = strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+			return key, value, key != "" && value != ""
+		}
+	}
+	return "", "", false
+}
+
+// tomlBalanced reports whether the brackets of a value outside strings are closed
+func tomlBalanced(value string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// yamlLine is a non-empty line of a YAML config file without its indentation and comment
+type yamlLine struct {
+	indent int
+	text   string
+	line   int
+}
+
+// yamlParser parses the block structure of YAML config files: nested mappings and lists,
+// with flow collections and quoted or plain scalars as values
+type yamlParser struct {
+	path  string
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAMLConfig parses a YAML config file
+func parseYAMLConfig(path string, data []byte) (*configNode, error) {
+	p := &yamlParser{path: path}
+	for i, raw := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(stripConfigComment(strings.TrimRight(raw, "\r"), true), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" || trimmed == "..." || strings.HasPrefix(trimmed, "%") {
+			continue
+		}
+		if trimmed[0] == '\t' {
+			return nil, fmt.Errorf("%s:%d:%d: tabs are not allowed in YAML indentation", path, i+1, len(text)-len(trimmed)+1)
+		}
+		p.lines = append(p.lines, yamlLine{indent: len(text) - len(trimmed), text: trimmed, line: i + 1})
+	}
+	if len(p.lines) == 0 {
+		return &configNode{Kind: mapNode, Position: configPosition{Line: 1, Column: 1}}, nil
+	}
+
+	root, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf(p.lines[p.pos], "unexpected indentation")
+	}
+	return root, nil
+}
+
+func (p *yamlParser) errorf(l yamlLine, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d:%d: %s", p.path, l.line, l.indent+1, fmt.Sprintf(format, args...))
+}
+
+// isYAMLListItem reports whether a line starts a list item
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block parses the mapping or list starting at the current line
+func (p *yamlParser) block(indent int) (*configNode, error) {
+	if isYAMLListItem(p.lines[p.pos].text) {
+		return p.list(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) mapping(indent int) (*configNode, error) {
+	first := p.lines[p.pos]
+	node := &configNode{Kind: mapNode, Position: configPosition{Line: first.line, Column: first.indent + 1}}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf(l, "unexpected indentation")
+		}
+		key, rest, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, p.errorf(l, "expected \"key: value\", got %q", l.text)
+		}
+		position := configPosition{Line: l.line, Column: l.indent + 1}
+		if node.field(key) != nil {
+			return nil, p.errorf(l, "duplicate key %q", key)
+		}
+		p.pos++
+
+		var value *configNode
+		var err error
+		switch next := p.next(); {
+		case rest != "":
+			value, err = p.scalar(l, rest)
+		case next != nil && next.indent > indent:
+			value, err = p.block(next.indent)
+		case next != nil && next.indent == indent && isYAMLListItem(next.text):
+			value, err = p.list(indent) // Lists may start at the key's indentation
+		default:
+			value = &configNode{Kind: scalarNode, Position: position}
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.Fields = append(node.Fields, &configField{Key: key, Node: value, Position: position})
+	}
+	return node, nil
+}
+
+func (p *yamlParser) list(indent int) (*configNode, error) {
+	first := p.lines[p.pos]
+	node := &configNode{Kind: listNode, Position: configPosition{Line: first.line, Column: first.indent + 1}}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent != indent || !isYAMLListItem(l.text) {
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		itemIndent := l.indent + len(l.text) - len(rest)
+
+		var item *configNode
+		var err error
+		_, _, isMapping := splitYAMLKey(rest)
+		switch {
+		case rest == "":
+			p.pos++
+			if next := p.next(); next != nil && next.indent > indent {
+				item, err = p.block(next.indent)
+			} else {
+				item = &configNode{Kind: scalarNode, Position: configPosition{Line: l.line, Column: itemIndent + 1}}
+			}
+		case isMapping || isYAMLListItem(rest):
+			// "- key: value" starts a mapping whose other keys line up with key
+			p.lines[p.pos] = yamlLine{indent: itemIndent, text: rest, line: l.line}
+			item, err = p.block(itemIndent)
+		default:
+			p.pos++
+			item, err = p.scalar(yamlLine{indent: itemIndent, text: rest, line: l.line}, rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.Items = append(node.Items, item)
+	}
+	return node, nil
+}
+
+// next returns the current line, or nil at the end of the file
+func (p *yamlParser) next() *yamlLine {
+	if p.pos >= len(p.lines) {
+		return nil
+	}
+	return &p.lines[p.pos]
+}
+
+// scalar parses the value after "key:" or "-"
+func (p *yamlParser) scalar(l yamlLine, text string) (*configNode, error) {
+	column := l.indent + len(l.text) - len(text) + 1
+	switch text[0] {
+	case '|', '>':
+		return nil, fmt.Errorf("%s:%d:%d: block scalars are not supported, use a quoted string", p.path, l.line, column)
+	case '&', '*', '!':
+		return nil, fmt.Errorf("%s:%d:%d: anchors, aliases and tags are not supported", p.path, l.line, column)
+	}
+	flow := &flowParser{path: p.path, s: text, pos: configPosition{Line: l.line, Column: column}, keySep: ':', scalar: yamlPlainScalar}
+	return flow.parseAll()
+}
+
+// yamlPlainScalar interprets an unquoted YAML value
+func yamlPlainScalar(token string) (interface{}, error) {
+	switch token {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "", "~", "null", "Null", "NULL":
+		return nil, nil
+	}
+	if n, ok := parseConfigNumber(token); ok {
+		return n, nil
+	}
+	return token, nil
+}
+
+// splitYAMLKey splits "key: value" (or "key:") into key and value
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	if text == "" || text[0] == '[' || text[0] == '{' || isYAMLListItem(text) {
+		return "", "", false
+	}
+	end := 0
+	if text[0] == '"' || text[0] == '\'' {
+		flow := &flowParser{s: text, keySep: ':'}
+		quoted, err := flow.quoted()
+		if err != nil {
+			return "", "", false
+		}
+		key, end = quoted, flow.i
+		for end < len(text) && text[end] == ' ' {
+			end++
+		}
+		if end >= len(text) || text[end] != ':' {
+			return "", "", false
+		}
+	} else {
+		end = strings.Index(text, ": ")
+		if end < 0 {
+			if !strings.HasSuffix(text, ":") {
+				return "", "", false
+			}
+			end = len(text) - 1
+		}
+		key = strings.TrimSpace(text[:end])
+	}
+	if end+1 < len(text) && text[end+1] != ' ' {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(text[end+1:]), key != ""
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Cycle deadline. Echo services and provider APIs have their own request timeouts, but a run
+// can still take far longer than expected: retries with backoff, a service that trickles its
+// response, many records behind a slow API. With CYCLE_TIMEOUT_SECONDS set, a run that is
+// still going at the deadline is ended: the changes applied so far are reported and the
+// process exits with exitCycleTimeout, so that a cron slot or systemd timer is never blocked.
+// Outstanding requests are abandoned with the process; records are left as the last
+// completed change wrote them and the next run reconciles the rest.
+//
+// A daemon's update cycle is cancelled instead: at the deadline its provider API requests
+// fail (in flight ones included, see retryTransport), the cycle unwinds quickly, logs the
+// changes it applied and the daemon carries on with its schedule.
+
+// exitCycleTimeout is the exit code of a run ended by the cycle deadline (1 is a failed
+// run, 2 pending changes in check mode)
+const exitCycleTimeout = 3
+
+// cycleWatchdog ends the process when a run exceeds the cycle deadline. It records the
+// run's changes itself, since the run is still writing its own list when the deadline hits.
+type cycleWatchdog struct {
+	mu      sync.Mutex
+	changes []RecordChange
+	timer   *time.Timer
+	ctx     context.Context // Cancelled at the deadline of a daemon's cycle (nil otherwise)
+}
+
+// cycleExit ends the process at the deadline (replaced in tests)
+var cycleExit = os.Exit
+
+// errCycleTimeout is the cause of the requests cancelled by the deadline of a daemon's cycle
+var errCycleTimeout = errors.New("update cycle exceeded " + envPrefix + "CYCLE_TIMEOUT_SECONDS")
+
+// runningCycle holds the context of the daemon's running update cycle, which the provider
+// API requests check
+var runningCycle struct {
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+// cycleContext returns the context of the running update cycle (Background if none)
+func cycleContext() context.Context {
+	runningCycle.mu.Lock()
+	defer runningCycle.mu.Unlock()
+	if runningCycle.ctx == nil {
+		return context.Background()
+	}
+	return runningCycle.ctx
+}
+
+// cycleCancelled returns errCycleTimeout once the running update cycle has been cancelled
+func cycleCancelled() error {
+	if ctx := cycleContext(); ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return nil
+}
+
+// startCycleWatchdog arms the deadline of a run; report is called with the changes applied
+// so far before the process exits. A timeout of 0 never fires.
+func startCycleWatchdog(timeout time.Duration, report func(changes []RecordChange)) *cycleWatchdog {
+	w := &cycleWatchdog{}
+	if timeout <= 0 {
+		return w
+	}
+	w.timer = time.AfterFunc(timeout, func() {
+		changes := w.snapshot()
+		log.Printf("ERROR: Run exceeded %sCYCLE_TIMEOUT_SECONDS (%s) - aborting with %d change(s) applied", envPrefix, timeout, len(changes))
+		for _, c := range changes {
+			log.Printf("  %s %s %s %s", c.Action, c.Type, c.Name, c.Content)
+		}
+		if report != nil {
+			report(changes)
+		}
+		cycleExit(exitCycleTimeout)
+	})
+	return w
+}
+
+// startCycleCancel arms the deadline of a daemon's update cycle: the cycle's provider API
+// requests are cancelled at the deadline, and the process keeps running. A timeout of 0
+// never fires.
+func startCycleCancel(timeout time.Duration) *cycleWatchdog {
+	w := &cycleWatchdog{}
+	if timeout <= 0 {
+		return w
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	w.ctx = ctx
+	runningCycle.mu.Lock()
+	runningCycle.ctx = ctx
+	runningCycle.mu.Unlock()
+	w.timer = time.AfterFunc(timeout, func() {
+		log.Printf("ERROR: Update cycle exceeded %sCYCLE_TIMEOUT_SECONDS (%s) - cancelling its provider requests", envPrefix, timeout)
+		cancel(errCycleTimeout)
+	})
+	return w
+}
+
+// expired reports whether the deadline cancelled the cycle
+func (w *cycleWatchdog) expired() bool {
+	return w.ctx != nil && w.ctx.Err() != nil
+}
+
+// recorder wraps an OnChange hook so that the watchdog sees the changes too
+func (w *cycleWatchdog) recorder(next func(action, recordType, name, content string)) func(action, recordType, name, content string) {
+	record := newChangeRecorder(&w.changes)
+	return func(action, recordType, name, content string) {
+		w.mu.Lock()
+		record(action, recordType, name, content)
+		w.mu.Unlock()
+		if next != nil {
+			next(action, recordType, name, content)
+		}
+	}
+}
+
+// snapshot returns the changes recorded so far
+func (w *cycleWatchdog) snapshot() []RecordChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]RecordChange(nil), w.changes...)
+}
+
+// stop disarms the deadline once the run has finished
+func (w *cycleWatchdog) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if w.ctx != nil {
+		runningCycle.mu.Lock()
+		if runningCycle.ctx == w.ctx {
+			runningCycle.ctx = nil
+		}
+		runningCycle.mu.Unlock()
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCycleWatchdogReportsPartialResults(t *testing.T) {
+	exited := make(chan int, 1)
+	cycleExit = func(code int) { exited <- code }
+	t.Cleanup(func() { cycleExit = os.Exit })
+
+	reported := make(chan []RecordChange, 1)
+	var runChanges []RecordChange
+	w := startCycleWatchdog(50*time.Millisecond, func(changes []RecordChange) { reported <- changes })
+	hook := w.recorder(newChangeRecorder(&runChanges))
+	hook("update", "A", "home.example.com", "198.51.100.7")
+	hook("update", "TXT", "home.example.com", "1700000000") // Bookkeeping, not a change
+
+	select {
+	case code := <-exited:
+		if code != exitCycleTimeout {
+			t.Errorf("exit code = %d, want %d", code, exitCycleTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchdog did not fire")
+	}
+	changes := <-reported
+	if len(changes) != 1 || changes[0].Name != "home.example.com" || changes[0].Content != "198.51.100.7" {
+		t.Errorf("reported changes = %v, want the A record update", changes)
+	}
+	if len(runChanges) != 1 {
+		t.Errorf("run's own changes = %v, want the wrapped recorder called", runChanges)
+	}
+}
+
+func TestCycleWatchdogStop(t *testing.T) {
+	exited := make(chan int, 1)
+	cycleExit = func(code int) { exited <- code }
+	t.Cleanup(func() { cycleExit = os.Exit })
+
+	startCycleWatchdog(0, nil) // Disabled
+	w := startCycleWatchdog(50*time.Millisecond, nil)
+	w.stop()
+	select {
+	case code := <-exited:
+		t.Errorf("watchdog fired with %d after the run finished", code)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestCycleCancel(t *testing.T) {
+	exited := make(chan int, 1)
+	cycleExit = func(code int) { exited <- code }
+	t.Cleanup(func() { cycleExit = os.Exit })
+
+	// A hung request is cancelled at the deadline, and so are the ones after it
+	hung := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	client := &http.Client{Transport: &retryTransport{policy: &retryPolicy{attempts: 3, sleep: func(time.Duration) {}}, base: hung}}
+	w := startCycleCancel(50 * time.Millisecond)
+	if _, err := client.Get("http://api.example.com/records"); !errors.Is(err, errCycleTimeout) {
+		t.Errorf("hung request: err = %v, want the cycle timeout", err)
+	}
+	if _, err := client.Get("http://api.example.com/records"); !errors.Is(err, errCycleTimeout) {
+		t.Errorf("later request: err = %v, want the cycle timeout", err)
+	}
+	if !w.expired() {
+		t.Error("cycle not expired after the deadline")
+	}
+	select {
+	case code := <-exited:
+		t.Errorf("daemon cycle exited with %d", code)
+	default:
+	}
+
+	// The next cycle's requests go through again
+	w.stop()
+	if cycleCancelled() != nil {
+		t.Error("cycle still cancelled after stop")
+	}
+	if w := startCycleCancel(0); w.expired() || cycleContext().Err() != nil {
+		t.Error("disabled deadline cancelled the cycle")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Duplicate address probing checks, before publishing, that no other host on the LAN
+// answers for an interface address. An interface can keep listing an address after a
+// DHCP server handed it to another host (e.g. after a long suspend); publishing it then
+// points the record at the wrong machine. IPv4 addresses are probed with ARP (RFC 5227),
+// IPv6 addresses with a neighbor solicitation as in duplicate address detection
+// (RFC 4862). Both need raw link-layer access, so probing only works on Linux with
+// CAP_NET_RAW; without it addresses are published unprobed.
+
+// defaultDADProbeTimeout is how long to wait for another host to answer a probe
+const defaultDADProbeTimeout = 500 * time.Millisecond
+
+// errDADProbeUnsupported is returned when probing is not possible on this platform
+var errDADProbeUnsupported = errors.New("duplicate address probing is only supported on Linux")
+
+// addressProber sends a probe for an address out of its interface and reports whether
+// another host answered for it
+type addressProber interface {
+	probeConflict(addr interfaceAddr, timeout time.Duration) (bool, error)
+}
+
+// dadProbeWarning limits probe errors (usually a missing CAP_NET_RAW) to one log line
+var dadProbeWarning sync.Once
+
+// probeAddrs drops the addresses another host answered for. Probes run concurrently;
+// addresses whose probe fails are kept, since the failure says nothing about the LAN.
+func probeAddrs(addrs []interfaceAddr, prober addressProber, timeout time.Duration) []interfaceAddr {
+	conflicts := make([]bool, len(addrs))
+	var wg sync.WaitGroup
+	for i, a := range addrs {
+		if a.Addr.IsLoopback() {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, a interfaceAddr) {
+			defer wg.Done()
+			conflict, err := prober.probeConflict(a, timeout)
+			if err != nil {
+				dadProbeWarning.Do(func() {
+					log.Printf("WARNING: duplicate address probing unavailable, publishing addresses unprobed: %v", err)
+				})
+				return
+			}
+			conflicts[i] = conflict
+		}(i, a)
+	}
+	wg.Wait()
+
+	result := make([]interfaceAddr, 0, len(addrs))
+	for i, a := range addrs {
+		if conflicts[i] {
+			log.Printf("Skipping %s on interface %s: another host on the LAN answers for it", a.Addr, a.Interface)
+			continue
+		}
+		result = append(result, a)
+	}
+	return result
+}
+
+// Ethernet frame layout
+const (
+	etherTypeARP  = 0x0806
+	etherTypeIPv6 = 0x86dd
+	etherHeader   = 14
+	ipv6Header    = 40
+	icmpv6NS      = 135 // Neighbor solicitation
+	icmpv6NA      = 136 // Neighbor advertisement
+)
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// etherFrame builds an Ethernet header followed by payload
+func etherFrame(dst, src net.HardwareAddr, etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 0, etherHeader+len(payload))
+	frame = append(frame, dst...)
+	frame = append(frame, src...)
+	frame = binary.BigEndian.AppendUint16(frame, etherType)
+	return append(frame, payload...)
+}
+
+// buildARPProbe builds an ARP probe for addr: a broadcast request with sender address
+// 0.0.0.0, so that it does not disturb the ARP caches of other hosts
+func buildARPProbe(mac net.HardwareAddr, addr netip.Addr) []byte {
+	arp := []byte{0, 1, 0x08, 0x00, 6, 4, 0, 1} // Ethernet, IPv4, request
+	arp = append(arp, mac...)
+	arp = append(arp, 0, 0, 0, 0)         // Sender protocol address
+	arp = append(arp, make([]byte, 6)...) // Target hardware address
+	arp = append(arp, addr.AsSlice()...)  // Target protocol address
+	return etherFrame(broadcastMAC, mac, etherTypeARP, arp)
+}
+
+// arpConflict reports whether frame is an ARP packet from another host claiming addr
+func arpConflict(frame []byte, mac net.HardwareAddr, addr netip.Addr) bool {
+	if len(frame) < etherHeader+28 || binary.BigEndian.Uint16(frame[12:14]) != etherTypeARP {
+		return false
+	}
+	arp := frame[etherHeader:]
+	sender, senderAddr := net.HardwareAddr(arp[8:14]), arp[14:18]
+	return !bytes.Equal(sender, mac) && bytes.Equal(senderAddr, addr.AsSlice())
+}
+
+// solicitedNode returns the solicited-node multicast address of addr and its MAC
+func solicitedNode(addr netip.Addr) (netip.Addr, net.HardwareAddr) {
+	a := addr.As16()
+	group := [16]byte{0xff, 0x02, 10: 0, 11: 0x01, 12: 0xff, 13: a[13], 14: a[14], 15: a[15]}
+	return netip.AddrFrom16(group), net.HardwareAddr{0x33, 0x33, 0xff, a[13], a[14], a[15]}
+}
+
+// buildNDPProbe builds a duplicate address detection neighbor solicitation for addr:
+// sent from the unspecified address to the solicited-node group of addr
+func buildNDPProbe(mac net.HardwareAddr, addr netip.Addr) []byte {
+	group, groupMAC := solicitedNode(addr)
+	target := addr.As16()
+
+	icmp := make([]byte, 8, 24)
+	icmp[0] = icmpv6NS
+	icmp = append(icmp, target[:]...)
+
+	src, dst := netip.IPv6Unspecified().As16(), group.As16()
+	binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(src, dst, icmp))
+
+	ip := make([]byte, ipv6Header, ipv6Header+len(icmp))
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(len(icmp)))
+	ip[6] = 58  // ICMPv6
+	ip[7] = 255 // Hop limit required for neighbor discovery
+	copy(ip[8:24], src[:])
+	copy(ip[24:40], dst[:])
+	return etherFrame(groupMAC, mac, etherTypeIPv6, append(ip, icmp...))
+}
+
+// icmpv6Checksum computes the ICMPv6 checksum including the IPv6 pseudo-header
+func icmpv6Checksum(src, dst [16]byte, icmp []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src[:])
+	add(dst[:])
+	sum += uint32(len(icmp)) + 58
+	add(icmp)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// ndpConflict reports whether frame is a neighbor advertisement from another host for
+// addr, or another host's own duplicate address detection probe for it
+func ndpConflict(frame []byte, mac net.HardwareAddr, addr netip.Addr) bool {
+	if len(frame) < etherHeader+ipv6Header+24 || binary.BigEndian.Uint16(frame[12:14]) != etherTypeIPv6 {
+		return false
+	}
+	if bytes.Equal(frame[6:12], mac) {
+		return false // Our own probe
+	}
+	ip := frame[etherHeader:]
+	icmp := ip[ipv6Header:]
+	unspecified := netip.IPv6Unspecified().As16()
+	switch {
+	case ip[6] != 58:
+		return false
+	case icmp[0] == icmpv6NS && !bytes.Equal(ip[8:24], unspecified[:]):
+		return false // Address resolution by a neighbor that wants to reach us
+	case icmp[0] != icmpv6NA && icmp[0] != icmpv6NS:
+		return false
+	}
+	target := addr.As16()
+	return bytes.Equal(icmp[8:24], target[:])
+}
+
+// dadProber returns the prober for config, creating it on first use
+func (c *Config) dadProber() addressProber {
+	if c.prober == nil {
+		c.prober = newSystemProber()
+	}
+	return c.prober
+}
+
+// dadProbeTimeout returns the configured probe timeout
+func (c *Config) dadProbeTimeout() time.Duration {
+	if c.DADProbeTimeoutMs <= 0 {
+		return defaultDADProbeTimeout
+	}
+	return time.Duration(c.DADProbeTimeoutMs) * time.Millisecond
+}
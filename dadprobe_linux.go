@@ -0,0 +1,84 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// packetOutgoing is the packet type of frames this host sent (PACKET_OUTGOING)
+const packetOutgoing = 4
+
+// packetProber probes with AF_PACKET sockets bound to the address's interface
+type packetProber struct{}
+
+func newSystemProber() addressProber {
+	return packetProber{}
+}
+
+// htons converts a 16-bit value to network byte order, as sockaddr_ll expects
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func (packetProber) probeConflict(a interfaceAddr, timeout time.Duration) (bool, error) {
+	iface, err := net.InterfaceByName(a.Interface)
+	if err != nil {
+		return false, err
+	}
+	if len(iface.HardwareAddr) != 6 || iface.Flags&net.FlagLoopback != 0 {
+		return false, nil // No Ethernet link layer (tunnels, WireGuard), nothing to probe
+	}
+
+	etherType := uint16(etherTypeARP)
+	frame := buildARPProbe(iface.HardwareAddr, a.Addr)
+	conflict := arpConflict
+	if a.Addr.Is6() {
+		etherType = etherTypeIPv6
+		frame = buildNDPProbe(iface.HardwareAddr, a.Addr)
+		conflict = ndpConflict
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(etherType)))
+	if err != nil {
+		return false, err // EPERM without CAP_NET_RAW
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(etherType), Ifindex: iface.Index}); err != nil {
+		return false, err
+	}
+
+	dst := &syscall.SockaddrLinklayer{Protocol: htons(etherType), Ifindex: iface.Index, Halen: 6}
+	copy(dst.Addr[:], frame[0:6])
+	if err := syscall.Sendto(fd, frame, 0, dst); err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1514)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false, nil
+		}
+		tv := syscall.NsecToTimeval(remaining.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return false, err
+		}
+		n, from, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok && ll.Pkttype == packetOutgoing {
+			continue
+		}
+		if conflict(buf[:n], iface.HardwareAddr, a.Addr) {
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import "time"
+
+// unsupportedProber is used where raw link-layer sockets are not available
+type unsupportedProber struct{}
+
+func newSystemProber() addressProber {
+	return unsupportedProber{}
+}
+
+func (unsupportedProber) probeConflict(a interfaceAddr, timeout time.Duration) (bool, error) {
+	return false, errDADProbeUnsupported
+}
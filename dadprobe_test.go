@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProber reports conflicts for a fixed set of addresses
+type fakeProber struct {
+	conflicts addrSet
+	err       error
+
+	mu     sync.Mutex
+	probed []string
+}
+
+func (p *fakeProber) probeConflict(a interfaceAddr, timeout time.Duration) (bool, error) {
+	p.mu.Lock()
+	p.probed = append(p.probed, a.Addr.String())
+	p.mu.Unlock()
+	return p.conflicts[a.Addr], p.err
+}
+
+func TestProbeAddrs(t *testing.T) {
+	addrs := []interfaceAddr{
+		{Addr: netip.MustParseAddr("127.0.0.1"), Interface: "lo"},
+		{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("192.168.1.11"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("2001:db8::1"), Interface: "eth0"},
+	}
+	prober := &fakeProber{conflicts: newAddrSet([]string{"192.168.1.11"})}
+	got := probeAddrs(addrs, prober, time.Second)
+
+	want := []interfaceAddr{addrs[0], addrs[1], addrs[3]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("probeAddrs = %v, want %v", got, want)
+	}
+	if len(prober.probed) != 3 {
+		t.Errorf("probed %v, loopback should be skipped", prober.probed)
+	}
+
+	// Probe errors keep the addresses
+	failing := &fakeProber{conflicts: newAddrSet([]string{"192.168.1.11"}), err: errDADProbeUnsupported}
+	if got := probeAddrs(addrs, failing, time.Second); len(got) != len(addrs) {
+		t.Errorf("addresses dropped on probe errors: %v", got)
+	}
+}
+
+var (
+	ourMAC   = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	otherMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+func TestARPProbe(t *testing.T) {
+	addr := netip.MustParseAddr("192.168.1.10")
+	probe := buildARPProbe(ourMAC, addr)
+	if len(probe) != 42 || !reflect.DeepEqual(net.HardwareAddr(probe[0:6]), broadcastMAC) {
+		t.Fatalf("probe = %x", probe)
+	}
+	if !reflect.DeepEqual(probe[28:32], []byte{0, 0, 0, 0}) || !reflect.DeepEqual(probe[38:42], addr.AsSlice()) {
+		t.Errorf("probe addresses = %x", probe[28:42])
+	}
+	if arpConflict(probe, ourMAC, addr) {
+		t.Error("our own probe is a conflict")
+	}
+
+	// Another host answering for the address
+	reply := buildARPProbe(otherMAC, netip.MustParseAddr("192.168.1.1"))
+	binary.BigEndian.PutUint16(reply[20:22], 2)
+	copy(reply[28:32], addr.AsSlice())
+	if !arpConflict(reply, ourMAC, addr) {
+		t.Error("reply from another host is not a conflict")
+	}
+	if arpConflict(reply, ourMAC, netip.MustParseAddr("192.168.1.11")) {
+		t.Error("reply for another address is a conflict")
+	}
+}
+
+// ndpFrame builds an ICMPv6 neighbor discovery message from src for target
+func ndpFrame(mac net.HardwareAddr, icmpType byte, src, target netip.Addr) []byte {
+	frame := buildNDPProbe(mac, target)
+	s := src.As16()
+	copy(frame[etherHeader+8:etherHeader+24], s[:])
+	frame[etherHeader+ipv6Header] = icmpType
+	return frame
+}
+
+func TestNDPProbe(t *testing.T) {
+	addr := netip.MustParseAddr("2001:db8::12:3456")
+	probe := buildNDPProbe(ourMAC, addr)
+	if got := net.HardwareAddr(probe[0:6]).String(); got != "33:33:ff:12:34:56" {
+		t.Errorf("destination MAC = %s", got)
+	}
+	if group, _ := solicitedNode(addr); group.String() != "ff02::1:ff12:3456" {
+		t.Errorf("solicited-node group = %s", group)
+	}
+
+	// A valid checksum sums to zero over the pseudo-header and message
+	ip := probe[etherHeader:]
+	var src, dst [16]byte
+	copy(src[:], ip[8:24])
+	copy(dst[:], ip[24:40])
+	if sum := icmpv6Checksum(src, dst, ip[ipv6Header:]); sum != 0 {
+		t.Errorf("checksum does not verify: %#04x", sum)
+	}
+
+	other := netip.MustParseAddr("fe80::2")
+	tests := []struct {
+		name  string
+		frame []byte
+		want  bool
+	}{
+		{"own probe", probe, false},
+		{"advertisement from another host", ndpFrame(otherMAC, icmpv6NA, other, addr), true},
+		{"another host's DAD probe", ndpFrame(otherMAC, icmpv6NS, netip.IPv6Unspecified(), addr), true},
+		{"address resolution by a neighbor", ndpFrame(otherMAC, icmpv6NS, other, addr), false},
+		{"advertisement for another address", ndpFrame(otherMAC, icmpv6NA, other, netip.MustParseAddr("2001:db8::1")), false},
+	}
+	for _, tt := range tests {
+		if got := ndpConflict(tt.frame, ourMAC, addr); got != tt.want {
+			t.Errorf("%s: conflict = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDADProbeTimeout(t *testing.T) {
+	if got := (&Config{}).dadProbeTimeout(); got != defaultDADProbeTimeout {
+		t.Errorf("default timeout = %s", got)
+	}
+	if got := (&Config{DADProbeTimeoutMs: 250}).dadProbeTimeout(); got != 250*time.Millisecond {
+		t.Errorf("timeout = %s", got)
+	}
+}
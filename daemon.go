@@ -0,0 +1,340 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Daemon mode ("-daemon" or BEES_IP_UPDATE_INTERVAL_SECONDS, optionally with "-cleanup")
+// keeps one process running the update and cleanup jobs on their own schedules. Both jobs
+// share the provider client (and with it the credentials and API rate limiter) and one
+// metrics endpoint. SIGTERM and SIGINT stop the daemon once the running job has finished.
+
+// scheduledJob is a job run by the scheduler. run performs one cycle and returns the
+// delay until the next one.
+type scheduledJob struct {
+	name string
+	run  func() time.Duration
+	next time.Time
+}
+
+// scheduler runs jobs one at a time, each on its own schedule. Because jobs never
+// overlap they can share a provider client, whose hooks are swapped per run.
+type scheduler struct {
+	jobs []*scheduledJob
+	wake chan string // Names of jobs to run as soon as possible
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{wake: make(chan string, 8)}
+}
+
+// add registers a job; jobs first run in the order they were added
+func (s *scheduler) add(name string, run func() time.Duration) {
+	s.jobs = append(s.jobs, &scheduledJob{name: name, run: run})
+}
+
+// addOnDemand registers a job that only runs when requested; its run's delay is ignored
+func (s *scheduler) addOnDemand(name string, run func()) {
+	s.jobs = append(s.jobs, &scheduledJob{name: name, run: func() time.Duration {
+		run()
+		return onDemand
+	}, next: time.Now().Add(onDemand)})
+}
+
+// onDemand is the delay of jobs that only run when requested
+const onDemand = 100 * 365 * 24 * time.Hour
+
+// set replaces the run of a job, keeping its schedule, or adds it
+func (s *scheduler) set(name string, run func() time.Duration) {
+	for _, job := range s.jobs {
+		if job.name == name {
+			job.run = run
+			return
+		}
+	}
+	s.add(name, run)
+}
+
+// remove unregisters a job, if there is one by that name
+func (s *scheduler) remove(name string) {
+	for i, job := range s.jobs {
+		if job.name == name {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// requestRun asks for a job to run as soon as the current one (if any) finishes
+func (s *scheduler) requestRun(name string) {
+	select {
+	case s.wake <- name:
+	default: // Enough requests are pending already
+	}
+}
+
+// due returns the job that should run next
+func (s *scheduler) due() *scheduledJob {
+	next := s.jobs[0]
+	for _, job := range s.jobs[1:] {
+		if job.next.Before(next.next) {
+			next = job
+		}
+	}
+	return next
+}
+
+// loop runs jobs until stop is closed (nil runs forever)
+func (s *scheduler) loop(stop <-chan struct{}) {
+	for {
+		job := s.due()
+		if wait := time.Until(job.next); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case name := <-s.wake:
+				timer.Stop()
+				for _, j := range s.jobs {
+					if j.name == name {
+						j.next = time.Time{}
+					}
+				}
+				continue
+			case <-timer.C:
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		job.next = time.Now().Add(job.run())
+	}
+}
+
+// metricsEndpoint serves the latest update and cleanup metrics over HTTP
+type metricsEndpoint struct {
+	mu      sync.Mutex
+	update  []metricFamily
+	cleanup []metricFamily
+}
+
+func (m *metricsEndpoint) setUpdate(families []metricFamily) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.update = families
+}
+
+func (m *metricsEndpoint) setCleanup(families []metricFamily) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleanup = families
+}
+
+func (m *metricsEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	m.mu.Lock()
+	families := append(append([]metricFamily{}, m.update...), m.cleanup...)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(formatPrometheus(families)))
+}
+
+// start serves the endpoint on config.MetricsListen in the background (if set)
+func (m *metricsEndpoint) start(config *Config) {
+	if config.MetricsListen == "" {
+		return
+	}
+	server := &http.Server{Addr: config.MetricsListen, Handler: m, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("Serving metrics on http://%s/metrics", config.MetricsListen)
+		log.Fatalf("ERROR: Metrics endpoint stopped: %v", server.ListenAndServe())
+	}()
+}
+
+// buildCleanupMetrics returns the cleanup service metrics
+func buildCleanupMetrics(lastRun time.Time, deletedTotal int) []metricFamily {
+	return []metricFamily{
+		{Name: "dynipupdate_last_cleanup_timestamp_seconds", Help: "Unix time of the last cleanup cycle.", Type: "gauge",
+			Samples: []metricSample{{Value: float64(lastRun.Unix())}}},
+		{Name: "dynipupdate_cleanup_records_deleted_total", Help: "Stale records deleted by the cleanup service.", Type: "counter",
+			Samples: []metricSample{{Value: float64(deletedTotal)}}},
+	}
+}
+
+// newUpdateJob returns the update job: select the network profile, detect, replay the
+// offline queue, reconcile, publish events (hub may be nil), metrics and health. After a
+// change the next cycle runs shortly after the TTL expires.
+func newUpdateJob(cf providerClient, daemonConfig *Config, interval time.Duration, hub *eventHub, metrics *metricsEndpoint, health *healthEndpoint) func() time.Duration {
+	var queue []PendingMutation // Changes queued while the provider was unreachable
+	return func() time.Duration {
+		config := cycleConfig(daemonConfig)
+		if config == nil {
+			health.recordCycle(0, 0)
+			return interval + jitter(daemonConfig.UpdateJitter)
+		}
+
+		var changedAt time.Time
+		watchdog := startCycleCancel(time.Duration(config.CycleTimeout) * time.Second)
+		defer watchdog.stop()
+		cf.hooks().OnChange = watchdog.recorder(func(action, recordType, name, content string) {
+			changedAt = time.Now()
+			if hub != nil {
+				hub.publish(apiEvent{Type: eventRecordChanged, Record: &eventRecord{Action: action, Type: recordType, Name: name, Content: content}})
+			}
+		})
+		defer func() { cf.hooks().OnChange = nil }()
+
+		log.Println(tr("update.start"))
+		detectedAt := time.Now()
+		ips := detectIPs(config)
+		saveLastDetection(config, ips, detectedAt)
+		if hub != nil {
+			hub.publish(apiEvent{Type: eventDetected, Timestamp: detectedAt.Unix(), IPs: ips})
+		}
+
+		// Apply what earlier cycles queued, then queue what this one cannot reach
+		queue = replayQueue(cf, config, queue, ips)
+		var queued []PendingMutation
+		cf.hooks().OnUnreachable = newUnreachableRecorder(&queued)
+		successCount, totalCount := reconcileRecords(cf, config, ips, detectedAt)
+		cf.hooks().OnUnreachable = nil
+		if watchdog.expired() {
+			// The cancelled requests were not unreachable; the next cycle reconciles them
+			changes := watchdog.snapshot()
+			log.Printf("ERROR: Update cycle cancelled with %d change(s) applied (%d of %d updates succeeded)", len(changes), successCount, totalCount)
+			for _, c := range changes {
+				log.Printf("  %s %s %s %s", c.Action, c.Type, c.Name, c.Content)
+			}
+		} else {
+			queue = updateQueue(config, queue, queued)
+		}
+
+		writeRunMetrics(config, ips, successCount, totalCount)
+		metrics.setUpdate(buildRunMetrics(config, ips, successCount, totalCount, time.Now()))
+		health.recordCycle(successCount, totalCount)
+		log.Println(tr("update.completed", successCount, totalCount))
+		if hub != nil {
+			hub.publish(apiEvent{Type: eventUpdateCompleted, Succeeded: &successCount, Attempted: &totalCount})
+		}
+
+		delay := nextCycleDelay(interval+jitter(config.UpdateJitter), config.RecordTTL, changedAt, time.Now())
+		if delay < interval {
+			log.Printf("Records changed - next update in %s (after the TTL expires)", delay.Round(time.Second))
+		}
+		return delay
+	}
+}
+
+// jitter returns a random delay of up to maxSeconds, so that hosts started together
+// (e.g. after a power cut) spread their updates
+func jitter(maxSeconds int) time.Duration {
+	if maxSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(jitterSource.Int63n(int64(maxSeconds) * int64(time.Second)))
+}
+
+// shutdownSignal returns a channel closed on the first SIGTERM or SIGINT
+func shutdownSignal() <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	stop := make(chan struct{})
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s - stopping after the running job", sig)
+		signal.Stop(signals)
+		close(stop)
+	}()
+	return stop
+}
+
+// newCleanupJob returns the cleanup job, counting deleted records for the metrics
+func newCleanupJob(cf providerClient, config *Config, metrics *metricsEndpoint) func() time.Duration {
+	deletedTotal := 0
+	return func() time.Duration {
+		cf.hooks().OnChange = func(action, recordType, name, content string) {
+			if action == "delete" {
+				deletedTotal++
+			}
+		}
+		defer func() { cf.hooks().OnChange = nil }()
+
+		runCleanup(cf, config)
+		metrics.setCleanup(buildCleanupMetrics(config.now(), deletedTotal))
+		return time.Duration(config.CleanupInterval) * time.Second
+	}
+}
+
+// runDaemon runs the update job every interval and, with cleanup, the cleanup service
+// on its own interval, until the process receives SIGTERM or SIGINT. SIGHUP reloads the
+// configuration between jobs.
+func runDaemon(cf providerClient, config *Config, interval time.Duration, cleanup bool, reload configReloader) {
+	metrics := &metricsEndpoint{}
+	metrics.start(config)
+	health := newHealthEndpoint(config, interval)
+	health.start(config)
+
+	// Republish the last known addresses before the first (potentially slow) detection
+	startFastStart(cf, config)
+
+	s := newScheduler()
+	verifications := newVerifyQueue(s)
+	config.verifications = verifications
+	setDaemonJobs(s, cf, config, interval, cleanup, metrics, health)
+	if cleanup {
+		log.Println(tr("cleanup.start"))
+		log.Printf("Daemon running: update every %s, cleanup every %ds", interval, config.CleanupInterval)
+	} else {
+		log.Printf("Daemon running: update every %s", interval)
+	}
+	startNetworkWatch(config, func() { s.requestRun("update") })
+	if config.UpdateJitter > 0 {
+		log.Printf("Adding up to %ds of jitter to each update interval", config.UpdateJitter)
+	}
+
+	if reload != nil {
+		s.addOnDemand("reload", func() {
+			if next, nextCF, ok := applyReload(reload, config); ok {
+				cf, config = nextCF, next
+				config.verifications = verifications
+				setDaemonJobs(s, cf, config, interval, cleanup, metrics, health)
+			}
+		})
+		go func() {
+			for range reloadSignal() {
+				s.requestRun("reload")
+			}
+		}()
+	}
+	s.loop(shutdownSignal())
+	log.Println("Daemon stopped")
+}
+
+// setDaemonJobs (re)registers the daemon's jobs for a configuration, keeping their schedules
+func setDaemonJobs(s *scheduler, cf providerClient, config *Config, interval time.Duration, cleanup bool, metrics *metricsEndpoint, health *healthEndpoint) {
+	s.set("update", newUpdateJob(cf, config, interval, nil, metrics, health))
+	if cleanup {
+		s.set("cleanup", newCleanupJob(cf, config, metrics))
+	}
+	s.remove("audit-log")
+	if config.AuditLogInterval > 0 {
+		if client, ok := cf.(*CloudFlareClient); ok {
+			s.set("audit-log", newAuditLogJob(client, config, newNotifier(config)))
+			log.Printf("Watching the audit log for external changes every %ds", config.AuditLogInterval)
+		}
+	}
+}
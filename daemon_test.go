@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRunsJobsOnOwnSchedules(t *testing.T) {
+	s := newScheduler()
+	var order []string
+	stop := make(chan struct{})
+	s.add("update", func() time.Duration {
+		order = append(order, "update")
+		if len(order) == 4 {
+			close(stop)
+		}
+		return 20 * time.Millisecond
+	})
+	s.add("cleanup", func() time.Duration {
+		order = append(order, "cleanup")
+		return time.Hour
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.loop(stop)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler did not stop")
+	}
+
+	// Both run first in order added, then only the short-interval job comes due
+	if strings.Join(order, ",") != "update,cleanup,update,update" {
+		t.Errorf("order = %v", order)
+	}
+}
+
+func TestSchedulerRequestRunWakesJob(t *testing.T) {
+	s := newScheduler()
+	runs := make(chan struct{}, 4)
+	s.add("update", func() time.Duration {
+		runs <- struct{}{}
+		return time.Hour
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.loop(stop)
+
+	<-runs // Initial run
+	s.requestRun("update")
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("requestRun did not wake the job")
+	}
+
+	// Unknown names are ignored
+	s.requestRun("nope")
+	select {
+	case <-runs:
+		t.Error("unknown job name triggered a run")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMetricsEndpointServesUpdateAndCleanup(t *testing.T) {
+	m := &metricsEndpoint{}
+	m.setUpdate([]metricFamily{{Name: "dynipupdate_last_run_timestamp_seconds", Help: "h", Type: "gauge",
+		Samples: []metricSample{{Value: 1}}}})
+	m.setCleanup(buildCleanupMetrics(time.Unix(1700000000, 0), 3))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"dynipupdate_last_run_timestamp_seconds 1",
+		"dynipupdate_last_cleanup_timestamp_seconds 1.7e+09",
+		"dynipupdate_cleanup_records_deleted_total 3",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/other", nil))
+	if rec.Code != 404 {
+		t.Errorf("status for /other = %d, want 404", rec.Code)
+	}
+}
+
+func TestCleanupJobCountsDeletes(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	stale := strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+	fake.add("TXT", "gone.example.com", stale)
+	fake.add("A", "gone.example.com", "192.0.2.1")
+
+	config := &Config{InternalDomain: "gone.example.com", StaleThreshold: 3600, CleanupInterval: 60}
+	metrics := &metricsEndpoint{}
+	job := newCleanupJob(cf, config, metrics)
+
+	if delay := job(); delay != time.Minute {
+		t.Errorf("delay = %s, want 1m", delay)
+	}
+	if got := fake.contents("gone.example.com", "A"); len(got) != 0 {
+		t.Errorf("A records left: %v", got)
+	}
+	if cf.hooks().OnChange != nil {
+		t.Error("cleanup job left its hook installed")
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "dynipupdate_cleanup_records_deleted_total 2") {
+		t.Errorf("deleted count not exported:\n%s", rec.Body.String())
+	}
+}
+
+func TestJitter(t *testing.T) {
+	if d := jitter(0); d != 0 {
+		t.Errorf("jitter(0) = %s", d)
+	}
+	spread := false
+	first := jitter(60)
+	for i := 0; i < 100; i++ {
+		d := jitter(60)
+		if d < 0 || d >= time.Minute {
+			t.Fatalf("jitter(60) = %s, want [0, 1m)", d)
+		}
+		if d != first {
+			spread = true
+		}
+	}
+	if !spread {
+		t.Error("jitter always returned the same delay")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Delete verification. CloudFlare occasionally reports a delete as successful while the
+// record stays listed for a while (eventual consistency between its API nodes), and a run
+// that trusted the success flag leaves a stale address published. With VERIFY_DELETES=true,
+// every deleted record is looked up again after VERIFY_DELETE_DELAY_SECONDS (default 2); a
+// record still listed is deleted once more and looked up again after the same delay. A record
+// that survives both is logged as a ghost: the delete is not failed, the next run finds the
+// record and deletes it again.
+
+// Default delay before a deleted record is looked up again
+const defaultVerifyDeleteDelay = 2
+
+// deleteVerifier looks up deleted records again
+type deleteVerifier struct {
+	delay time.Duration
+	sleep func(time.Duration) // nil = time.Sleep
+}
+
+// newDeleteVerifier returns the configured verifier (nil if deletes are not verified)
+func newDeleteVerifier(config *Config) *deleteVerifier {
+	if !config.VerifyDeletes {
+		return nil
+	}
+	return &deleteVerifier{delay: time.Duration(config.VerifyDeleteDelay) * time.Second}
+}
+
+// wait sleeps for the verification delay
+func (v *deleteVerifier) wait() {
+	sleep := v.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	sleep(v.delay)
+}
+
+// verifyDeleted checks that the record deleted from name is no longer listed, deleting it
+// once more if it is. Records that persist are logged as ghosts.
+func (cf *CloudFlareClient) verifyDeleted(recordID, name, recordType string) {
+	if cf.VerifyDeletes == nil {
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		cf.VerifyDeletes.wait()
+		listed, err := cf.recordListed(recordID, name, recordType)
+		switch {
+		case err != nil:
+			log.Printf("WARNING: Could not verify the delete of %s record %s (%s): %v", recordType, name, recordID, err)
+			return
+		case !listed:
+			if attempt > 1 {
+				log.Printf("Verified the delete of %s record %s after deleting it again", recordType, name)
+			}
+			return
+		case attempt > 1:
+			log.Printf("WARNING: Ghost %s record %s (%s) is still listed after being deleted twice - the next run deletes it again", recordType, name, recordID)
+			return
+		}
+
+		log.Printf("%s record %s (%s) is still listed after its delete succeeded - deleting it again", recordType, name, recordID)
+		path := fmt.Sprintf("/zones/%s/dns_records/%s", cf.zoneFor(name), recordID)
+		if err := cf.change("DELETE", path, nil); err != nil && !errors.Is(err, errRecordNotFound) {
+			log.Printf("WARNING: Deleting %s record %s (%s) again failed: %v", recordType, name, recordID, err)
+			return
+		}
+	}
+}
+
+// recordListed reports whether the record with recordID is listed at name
+func (cf *CloudFlareClient) recordListed(recordID, name, recordType string) (bool, error) {
+	records, err := cf.getAllRecords(name, recordType)
+	if err != nil {
+		return false, err
+	}
+	for _, record := range records {
+		if record.ID == recordID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withGhostDeletes points cf at a server answering the first n deletes with success without
+// deleting anything, and passing everything else to fake
+func withGhostDeletes(t *testing.T, fake *fakeCloudFlare, cf *CloudFlareClient, n int) {
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ghost := r.Method == http.MethodDelete && n > 0
+		if ghost {
+			n--
+		}
+		mu.Unlock()
+		if ghost {
+			json.NewEncoder(w).Encode(map[string]any{"success": true, "errors": []any{}, "result": map[string]string{}})
+			return
+		}
+		fake.handle(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	cf.BaseURL = srv.URL
+}
+
+func TestVerifyDeletes(t *testing.T) {
+	var slept []time.Duration
+	verifier := &deleteVerifier{delay: 2 * time.Second, sleep: func(d time.Duration) { slept = append(slept, d) }}
+
+	// A delete that did not take is retried once
+	fake, cf := newFakeCloudFlare(t)
+	cf.VerifyDeletes = verifier
+	withGhostDeletes(t, fake, cf, 1)
+	id := fake.add("A", "home.example.com", "198.51.100.9")
+	if err := cf.deleteRecord(id, "home.example.com", "A"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.contents("home.example.com", "A"); len(got) != 0 {
+		t.Errorf("record left after the verified delete: %v", got)
+	}
+	if len(slept) != 2 {
+		t.Errorf("waited %v, want twice", slept)
+	}
+
+	// A ghost surviving both deletes is only warned about
+	slept = nil
+	fake, cf = newFakeCloudFlare(t)
+	cf.VerifyDeletes = verifier
+	withGhostDeletes(t, fake, cf, 2)
+	id = fake.add("A", "home.example.com", "198.51.100.9")
+	if err := cf.deleteRecord(id, "home.example.com", "A"); err != nil {
+		t.Errorf("ghost failed the delete: %v", err)
+	}
+	if got := fake.contents("home.example.com", "A"); len(got) != 1 {
+		t.Errorf("ghost record = %v", got)
+	}
+
+	// Without verification, nothing is looked up again
+	slept = nil
+	fake, cf = newFakeCloudFlare(t)
+	id = fake.add("A", "home.example.com", "198.51.100.9")
+	if err := cf.deleteRecord(id, "home.example.com", "A"); err != nil || len(slept) != 0 {
+		t.Errorf("unverified delete: err = %v, waited %v", err, slept)
+	}
+}
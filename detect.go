@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Detection-only mode ("dynipupdate detect") skips DNS entirely and emits the
+// detected addresses to stdout, a webhook or an MQTT broker whenever they change.
+
+// Supported values for BEES_IP_UPDATE_DETECT_OUTPUT
+const (
+	detectOutputStdout  = "stdout"
+	detectOutputWebhook = "webhook"
+	detectOutputMQTT    = "mqtt"
+)
+
+// detectionEvent is the JSON document emitted for each detection result
+type detectionEvent struct {
+	Event     string       `json:"event"` // Always "ip.detected"
+	Timestamp int64        `json:"timestamp"`
+	Hostname  string       `json:"hostname,omitempty"`
+	IPs       IPAddresses  `json:"ips"`
+	Previous  *IPAddresses `json:"previous,omitempty"` // Last emitted addresses, if known
+}
+
+// detectionEmitter delivers detection events to an external consumer
+type detectionEmitter interface {
+	Emit(event detectionEvent) error
+}
+
+// stdoutEmitter writes one JSON document per line
+type stdoutEmitter struct {
+	w io.Writer
+}
+
+func (s stdoutEmitter) Emit(event detectionEvent) error {
+	return json.NewEncoder(s.w).Encode(event)
+}
+
+// webhookEmitter POSTs each event as JSON
+type webhookEmitter struct {
+	URL string
+}
+
+func (w *webhookEmitter) Emit(event detectionEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// mqttEmitter publishes each event as a retained QoS 0 message (MQTT 3.1.1).
+// URL format: mqtt://[user:password@]host[:1883] or mqtts://...[:8883]
+type mqttEmitter struct {
+	URL      *url.URL
+	Topic    string
+	ClientID string
+}
+
+func (m *mqttEmitter) Emit(event detectionEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	host := m.URL.Host
+	if m.URL.Port() == "" {
+		if m.URL.Scheme == "mqtts" {
+			host = net.JoinHostPort(m.URL.Hostname(), "8883")
+		} else {
+			host = net.JoinHostPort(m.URL.Hostname(), "1883")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	if m.URL.Scheme == "mqtts" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: m.URL.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	password, _ := m.URL.User.Password()
+	if _, err := conn.Write(mqttConnectPacket(m.ClientID, m.URL.User.Username(), password)); err != nil {
+		return err
+	}
+
+	// CONNACK: 0x20, remaining length 2, session present flag, return code
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(bufio.NewReader(conn), ack); err != nil {
+		return fmt.Errorf("reading CONNACK: %w", err)
+	}
+	if ack[0] != 0x20 || ack[1] != 2 {
+		return fmt.Errorf("unexpected reply from broker (0x%02x)", ack[0])
+	}
+	if ack[3] != 0 {
+		return fmt.Errorf("broker refused connection (return code %d)", ack[3])
+	}
+
+	if _, err := conn.Write(mqttPublishPacket(m.Topic, payload, true)); err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte{0xE0, 0x00}) // DISCONNECT
+	return err
+}
+
+// mqttString encodes a length-prefixed MQTT UTF-8 string
+func mqttString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// mqttPacket prepends the fixed header (type/flags and variable-length remaining length)
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func mqttConnectPacket(clientID, username, password string) []byte {
+	flags := byte(0x02) // Clean session
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+
+	body := append(mqttString("MQTT"), 4, flags, 0, 60) // Protocol level 4 (3.1.1), 60s keep alive
+	body = append(body, mqttString(clientID)...)
+	if username != "" {
+		body = append(body, mqttString(username)...)
+		if password != "" {
+			body = append(body, mqttString(password)...)
+		}
+	}
+	return mqttPacket(0x10, body)
+}
+
+func mqttPublishPacket(topic string, payload []byte, retain bool) []byte {
+	header := byte(0x30) // PUBLISH, QoS 0
+	if retain {
+		header |= 0x01
+	}
+	return mqttPacket(header, append(mqttString(topic), payload...))
+}
+
+// newDetectionEmitter builds the emitter selected by BEES_IP_UPDATE_DETECT_OUTPUT
+func newDetectionEmitter(config *Config, stdout io.Writer) (detectionEmitter, error) {
+	switch config.DetectOutput {
+	case detectOutputStdout:
+		return stdoutEmitter{w: stdout}, nil
+	case detectOutputWebhook:
+		if config.DetectWebhookURL == "" {
+			return nil, fmt.Errorf("%sDETECT_WEBHOOK_URL is required with DETECT_OUTPUT=webhook", envPrefix)
+		}
+		return &webhookEmitter{URL: config.DetectWebhookURL}, nil
+	case detectOutputMQTT:
+		if config.MQTTURL == "" {
+			return nil, fmt.Errorf("%sMQTT_URL is required with DETECT_OUTPUT=mqtt", envPrefix)
+		}
+		u, err := url.Parse(config.MQTTURL)
+		if err != nil || (u.Scheme != "mqtt" && u.Scheme != "mqtts") || u.Hostname() == "" {
+			return nil, fmt.Errorf("%sMQTT_URL must look like mqtt://host:1883 or mqtts://host:8883", envPrefix)
+		}
+		hostname, _ := os.Hostname()
+		topic := config.MQTTTopic
+		if topic == "" {
+			topic = "dynipupdate/" + hostname + "/ips"
+		}
+		return &mqttEmitter{URL: u, Topic: topic, ClientID: "dynipupdate-" + hostname}, nil
+	}
+	return nil, fmt.Errorf("%sDETECT_OUTPUT must be %s, %s or %s, got %q",
+		envPrefix, detectOutputStdout, detectOutputWebhook, detectOutputMQTT, config.DetectOutput)
+}
+
+// normalizedIPs returns a copy with sorted address lists so detections can be compared
+func normalizedIPs(ips *IPAddresses) IPAddresses {
+	result := IPAddresses{
+		InternalIPv4: append([]string(nil), ips.InternalIPv4...),
+		ExternalIPv4: ips.ExternalIPv4,
+		ExternalIPv6: ips.ExternalIPv6,
+	}
+	sort.Strings(result.InternalIPv4)
+	if len(ips.CustomRangeIPs) > 0 {
+		result.CustomRangeIPs = make(map[string][]string, len(ips.CustomRangeIPs))
+		for domain, addrs := range ips.CustomRangeIPs {
+			sorted := append([]string(nil), addrs...)
+			sort.Strings(sorted)
+			result.CustomRangeIPs[domain] = sorted
+		}
+	}
+	return result
+}
+
+// sameDetection reports whether two detection results contain the same addresses
+func sameDetection(a, b *IPAddresses) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return reflect.DeepEqual(normalizedIPs(a), normalizedIPs(b))
+}
+
+// detectAndEmit runs one detection and emits it if it differs from last (or always is set).
+// Returns the addresses to compare the next detection against.
+func detectAndEmit(config *Config, emitter detectionEmitter, last *IPAddresses, always bool) (*IPAddresses, error) {
+	ips := detectIPs(config)
+	if !always && sameDetection(ips, last) {
+		log.Println("Detected addresses unchanged - nothing emitted")
+		return last, nil
+	}
+
+	hostname, _ := os.Hostname()
+	event := detectionEvent{Event: "ip.detected", Timestamp: time.Now().Unix(), Hostname: hostname, IPs: *ips, Previous: last}
+	if err := emitter.Emit(event); err != nil {
+		return last, err
+	}
+	log.Printf("Emitted detection result via %s", config.DetectOutput)
+	return ips, nil
+}
+
+// lastEmittedDetection returns the addresses last emitted by a previous run (state file only)
+func lastEmittedDetection(config *Config) *IPAddresses {
+	if config.StateFile == "" {
+		return nil
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil || state.LastEmitted == nil {
+		return nil
+	}
+	return &state.LastEmitted.IPs
+}
+
+// saveLastEmitted records the emitted addresses so the next run only emits on change
+func saveLastEmitted(config *Config, ips *IPAddresses) {
+	if config.StateFile == "" {
+		return
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, emitted detection not recorded: %v", err)
+		return
+	}
+	state.LastEmitted = &CachedDetection{Timestamp: time.Now().Unix(), IPs: *ips}
+	if err := state.save(config.StateFile); err != nil {
+		log.Printf("WARNING: Could not save state: %v", err)
+	}
+}
+
+// runDetect implements the "detect" subcommand
+func runDetect(args []string) int {
+	fs := flag.NewFlagSet("detect", flag.ContinueOnError)
+	interval := fs.Int("interval", 0, "Keep running and detect every N seconds (0 detects once and exits)")
+	always := fs.Bool("always", false, "Emit every detection, even if the addresses have not changed")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config := readConfig(false, false)
+	emitter, err := newDetectionEmitter(config, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+	if config.StateFile == "" && *interval == 0 && !*always {
+		log.Printf("NOTE: %sSTATE_FILE is not set - every run emits its detection result", envPrefix)
+	}
+
+	last := lastEmittedDetection(config)
+	for {
+		emitted, err := detectAndEmit(config, emitter, last, *always)
+		if err != nil {
+			log.Printf("ERROR: Could not emit detection result: %v", err)
+			if *interval == 0 {
+				return 1
+			}
+		} else if emitted != last {
+			saveLastEmitted(config, emitted)
+			last = emitted
+		}
+
+		if *interval == 0 {
+			return 0
+		}
+		time.Sleep(time.Duration(*interval) * time.Second)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSameDetection(t *testing.T) {
+	a := &IPAddresses{InternalIPv4: []string{"192.168.1.2", "10.0.0.2"}, ExternalIPv4: "203.0.113.1",
+		CustomRangeIPs: map[string][]string{"ts.example.com": {"100.64.0.2", "100.64.0.1"}}}
+	b := &IPAddresses{InternalIPv4: []string{"10.0.0.2", "192.168.1.2"}, ExternalIPv4: "203.0.113.1",
+		CustomRangeIPs: map[string][]string{"ts.example.com": {"100.64.0.1", "100.64.0.2"}}}
+
+	if !sameDetection(a, b) {
+		t.Error("order differences should not count as a change")
+	}
+	b.ExternalIPv4 = "203.0.113.2"
+	if sameDetection(a, b) {
+		t.Error("changed external address not detected")
+	}
+	if sameDetection(a, nil) || !sameDetection(nil, nil) {
+		t.Error("nil handling")
+	}
+	if a.InternalIPv4[0] != "192.168.1.2" {
+		t.Error("sameDetection must not reorder its arguments")
+	}
+}
+
+func TestStdoutAndWebhookEmitters(t *testing.T) {
+	event := detectionEvent{Event: "ip.detected", Timestamp: 1700000000, IPs: IPAddresses{ExternalIPv4: "203.0.113.1"}}
+
+	var buf bytes.Buffer
+	if err := (stdoutEmitter{w: &buf}).Emit(event); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), "\n") || !strings.Contains(buf.String(), `"external_ipv4":"203.0.113.1"`) {
+		t.Errorf("stdout output = %q", buf.String())
+	}
+
+	var received detectionEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	if err := (&webhookEmitter{URL: server.URL}).Emit(event); err != nil {
+		t.Fatal(err)
+	}
+	if received.IPs.ExternalIPv4 != "203.0.113.1" || received.Event != "ip.detected" {
+		t.Errorf("webhook received %+v", received)
+	}
+}
+
+// readMQTTPacket reads one packet and returns its fixed header byte and body
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func TestMQTTEmitter(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	type packet struct {
+		header byte
+		body   []byte
+	}
+	packets := make(chan packet, 3)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			header, body, err := readMQTTPacket(r)
+			if err != nil {
+				close(packets)
+				return
+			}
+			packets <- packet{header, body}
+			if header == 0x10 {
+				conn.Write([]byte{0x20, 0x02, 0x00, 0x00}) // CONNACK accepted
+			}
+		}
+	}()
+
+	u, _ := url.Parse("mqtt://user:pass@" + listener.Addr().String())
+	emitter := &mqttEmitter{URL: u, Topic: "home/ips", ClientID: "test"}
+	// A payload over 127 bytes exercises the multi-byte remaining length
+	event := detectionEvent{Event: "ip.detected", IPs: IPAddresses{ExternalIPv4: "203.0.113.1", ExternalIPv6: "2001:db8::1",
+		InternalIPv4: []string{"192.168.1.2", "192.168.1.3", "192.168.1.4"}}}
+	if err := emitter.Emit(event); err != nil {
+		t.Fatal(err)
+	}
+
+	connect := <-packets
+	if connect.header != 0x10 || !bytes.Contains(connect.body, []byte("MQTT")) ||
+		connect.body[7]&0xC0 != 0xC0 || !bytes.HasSuffix(connect.body, append(mqttString("user"), mqttString("pass")...)) {
+		t.Errorf("CONNECT = %x %q", connect.header, connect.body)
+	}
+
+	publish := <-packets
+	if publish.header != 0x31 {
+		t.Errorf("PUBLISH header = %x, want retained QoS 0", publish.header)
+	}
+	topic := mqttString("home/ips")
+	if !bytes.HasPrefix(publish.body, topic) {
+		t.Fatalf("PUBLISH body = %q", publish.body)
+	}
+	var got detectionEvent
+	if err := json.Unmarshal(publish.body[len(topic):], &got); err != nil || got.IPs.ExternalIPv6 != "2001:db8::1" {
+		t.Errorf("payload = %+v, %v", got, err)
+	}
+
+	if disconnect := <-packets; disconnect.header != 0xE0 {
+		t.Errorf("last packet = %x, want DISCONNECT", disconnect.header)
+	}
+}
+
+func TestNewDetectionEmitter(t *testing.T) {
+	tests := []struct {
+		config  Config
+		wantErr bool
+	}{
+		{Config{DetectOutput: "stdout"}, false},
+		{Config{DetectOutput: "webhook"}, true},
+		{Config{DetectOutput: "webhook", DetectWebhookURL: "https://example.com/hook"}, false},
+		{Config{DetectOutput: "mqtt", MQTTURL: "http://broker"}, true},
+		{Config{DetectOutput: "mqtt", MQTTURL: "mqtts://broker.example.com"}, false},
+		{Config{DetectOutput: "syslog"}, true},
+	}
+	for _, tt := range tests {
+		_, err := newDetectionEmitter(&tt.config, io.Discard)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: err = %v, wantErr %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
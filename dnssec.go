@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// DNSSEC wire format constants
+const (
+	dnsTypeOPT   = 41
+	dnsTypeRRSIG = 46
+
+	dnsFlagRD = 0x0100 // Recursion desired
+	dnsFlagAD = 0x0020 // Authentic data: the resolver validated the answer
+
+	dnsRcodeServFail = 2
+
+	ednsDO      = 0x8000 // DNSSEC OK bit in the OPT record's TTL field
+	ednsUDPSize = 1232
+)
+
+// dnssecQuery asks a (validating) resolver for a record set with the DO bit set, so the
+// answer carries the signatures and the AD flag. TCP avoids truncation by the signatures.
+func dnssecQuery(server, name string, recordType uint16, timeout time.Duration) (*dnsMessage, error) {
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	request := &dnsMessage{
+		ID:         newMessageID(),
+		Flags:      dnsFlagRD | dnsFlagAD,
+		Question:   []dnsRR{{Name: name, Type: recordType, Class: dnsClassIN}},
+		Additional: []dnsRR{{Type: dnsTypeOPT, Class: ednsUDPSize, TTL: ednsDO}},
+	}
+	packed, err := request.pack()
+	if err != nil {
+		return nil, err
+	}
+	c := &rfc2136Conn{conn}
+	if err := c.write(packed); err != nil {
+		return nil, err
+	}
+	raw, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := unpackDNSMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ID != request.ID {
+		return nil, fmt.Errorf("response ID %d does not match request ID %d", resp.ID, request.ID)
+	}
+	return resp, nil
+}
+
+// verifyDNSSEC checks that the resolver serves the expected values of a record set with a
+// validated signature. A signed zone whose signing pipeline did not pick up the new records
+// answers unsigned (no AD flag) or bogus (SERVFAIL from a validating resolver).
+func verifyDNSSEC(server, name, recordType string, expected []string, timeout time.Duration) error {
+	qtype, ok := dnsTypes[recordType]
+	if !ok {
+		return fmt.Errorf("unsupported record type %s", recordType)
+	}
+	resp, err := dnssecQuery(server, name, qtype, timeout)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.rcode() == dnsRcodeServFail:
+		return fmt.Errorf("%s failed to validate %s %s (bogus or missing signatures?)", server, recordType, name)
+	case resp.rcode() != 0:
+		return fmt.Errorf("%s answered %s for %s %s", server, rcodeName(resp.rcode()), recordType, name)
+	}
+
+	var values []string
+	signed := false
+	for _, rr := range resp.Answer {
+		switch {
+		case rr.Type == qtype:
+			if v, ok := rrContent(rr); ok {
+				values = append(values, v)
+			}
+		case rr.Type == dnsTypeRRSIG && len(rr.Data) >= 2 && binary.BigEndian.Uint16(rr.Data) == qtype:
+			signed = true
+		}
+	}
+	for _, v := range expected {
+		if !slices.Contains(values, v) {
+			return fmt.Errorf("%s does not serve %s %s %s", server, recordType, name, v)
+		}
+	}
+	if !signed {
+		return fmt.Errorf("%s %s is served without an RRSIG", recordType, name)
+	}
+	if resp.Flags&dnsFlagAD == 0 {
+		return fmt.Errorf("%s did not authenticate %s %s (is it a validating resolver?)", server, recordType, name)
+	}
+	return nil
+}
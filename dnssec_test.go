@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeValidatingResolver answers one query per connection over TCP with the response built
+// by answer, returning the server address
+func fakeValidatingResolver(t *testing.T, answer func(q *dnsMessage) *dnsMessage) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c := &rfc2136Conn{conn}
+			if raw, err := c.read(); err == nil {
+				if q, err := unpackDNSMessage(raw); err == nil {
+					resp := answer(q)
+					resp.ID = q.ID
+					packed, _ := resp.pack()
+					c.write(packed)
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestVerifyDNSSEC(t *testing.T) {
+	rrsig := func(covered uint16) dnsRR {
+		return dnsRR{Name: "nas.example.com", Type: dnsTypeRRSIG, Class: dnsClassIN, TTL: 300,
+			Data: binary.BigEndian.AppendUint16(nil, covered)}
+	}
+	address := dnsRR{Name: "nas.example.com", Type: dnsTypeA, Class: dnsClassIN, TTL: 300, Data: []byte{203, 0, 113, 1}}
+
+	tests := []struct {
+		name    string
+		flags   uint16
+		answer  []dnsRR
+		wantErr string
+	}{
+		{"validated", dnsFlagAD, []dnsRR{address, rrsig(dnsTypeA)}, ""},
+		{"unsigned", 0, []dnsRR{address}, "without an RRSIG"},
+		{"signature of another type", dnsFlagAD, []dnsRR{address, rrsig(dnsTypeAAAA)}, "without an RRSIG"},
+		{"not validated", 0, []dnsRR{address, rrsig(dnsTypeA)}, "did not authenticate"},
+		{"bogus", dnsRcodeServFail, nil, "failed to validate"},
+		{"stale address", dnsFlagAD, []dnsRR{{Name: "nas.example.com", Type: dnsTypeA, Class: dnsClassIN, Data: []byte{203, 0, 113, 2}}, rrsig(dnsTypeA)}, "does not serve"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query *dnsMessage
+			server := fakeValidatingResolver(t, func(q *dnsMessage) *dnsMessage {
+				query = q
+				return &dnsMessage{Flags: 0x8000 | tt.flags, Question: q.Question, Answer: tt.answer}
+			})
+			err := verifyDNSSEC(server, "nas.example.com", "A", []string{"203.0.113.1"}, 5*time.Second)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("verifyDNSSEC = %v, want error containing %q", err, tt.wantErr)
+			}
+			if query == nil || len(query.Additional) != 1 || query.Additional[0].Type != dnsTypeOPT || query.Additional[0].TTL&ednsDO == 0 {
+				t.Errorf("query lacks an OPT record with the DO bit: %+v", query)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Docker mode. DOCKER_MODE=true sets the defaults of the common "one hostname, one container"
+// deployment, so it needs two settings - the API token and the name:
+//
+//	docker run -d -v dynipupdate:/data -p 8080:8080 \
+//	  -e BEES_IP_UPDATE_DOCKER_MODE=true \
+//	  -e BEES_IP_UPDATE_CF_API_TOKEN=... \
+//	  -e BEES_IP_UPDATE_DOMAIN=home.example.com dynipupdate
+//
+// DOMAIN is published as the external domain (the container's own addresses are of no use
+// outside it), the state file is kept in /data, the health endpoint listens on :8080, logs
+// are JSON and the container updates every five minutes as a daemon. The zone is discovered
+// from the name, and startup waits up to five minutes for the API (BOOTSTRAP_TIMEOUT_SECONDS,
+// see bootstrap.go). Each default only applies to a setting that is not given, so any of
+// them can still be set as usual.
+
+// dockerDataDir is the directory holding the state in docker mode, mounted as a volume
+const dockerDataDir = "/data"
+
+// dockerModeDefaults are the settings of docker mode, used when not given; nil outside it
+var dockerModeDefaults map[string]string
+
+// applyDockerMode turns docker mode's defaults on or off for the configuration being read
+func applyDockerMode() bool {
+	dockerModeDefaults = nil
+	if strings.ToLower(getEnv("DOCKER_MODE")) != "true" {
+		return false
+	}
+	dockerModeDefaults = map[string]string{
+		"EXTERNAL_DOMAIN":  getEnv("DOMAIN"),
+		"STATE_FILE":       filepath.Join(dockerDataDir, "state.json"),
+		"HEALTH_LISTEN":    ":8080",
+		"LOG_FORMAT":       logFormatJSON,
+		"INTERVAL_SECONDS": "300",
+	}
+	if info, err := os.Stat(dockerDataDir); err != nil || !info.IsDir() {
+		log.Printf("WARNING: %sDOCKER_MODE keeps the state in %s - mount a volume there, or it is lost with the container", envPrefix, dockerDataDir)
+	}
+	return true
+}
+
+// dockerModeDefault returns docker mode's default for a setting ("" outside docker mode)
+func dockerModeDefault(key string) string {
+	return dockerModeDefaults[key]
+}
+
+// dockerModeEnabled reports whether the environment turns docker mode on, before the
+// configuration is read
+func dockerModeEnabled() bool {
+	return strings.ToLower(os.Getenv(envPrefix+"DOCKER_MODE")) == "true"
+}
+
+// earlyLogFormat is the log format used until the configuration is read
+func earlyLogFormat() string {
+	format := os.Getenv(envPrefix + "LOG_FORMAT")
+	if format == "" && dockerModeEnabled() {
+		return logFormatJSON
+	}
+	return format
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestDockerModeDefaults(t *testing.T) {
+	t.Cleanup(func() { dockerModeDefaults = nil })
+	t.Setenv(envPrefix+"CF_API_TOKEN", "token")
+	t.Setenv(envPrefix+"DOMAIN", "home.example.com")
+
+	if _, err := reloadConfig(false); err == nil {
+		t.Error("DOMAIN accepted without docker mode")
+	}
+
+	t.Setenv(envPrefix+"DOCKER_MODE", "true")
+	config, err := reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.DockerMode || config.ExternalDomain != "home.example.com" || config.InternalDomain != "" {
+		t.Errorf("domains = %q internal, %q external (docker mode %v)", config.InternalDomain, config.ExternalDomain, config.DockerMode)
+	}
+	if config.StateFile != "/data/state.json" || config.HealthListen != ":8080" || config.LogFormat != logFormatJSON || config.UpdateInterval != 300 {
+		t.Errorf("defaults = state %q, health %q, log %q, interval %d", config.StateFile, config.HealthListen, config.LogFormat, config.UpdateInterval)
+	}
+
+	// Settings given win over the defaults
+	t.Setenv(envPrefix+"STATE_FILE", "/var/lib/dynipupdate/state.json")
+	t.Setenv(envPrefix+"LOG_FORMAT", logFormatPlain)
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "wan.example.com")
+	config, err = reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.StateFile != "/var/lib/dynipupdate/state.json" || config.LogFormat != logFormatPlain || config.ExternalDomain != "wan.example.com" {
+		t.Errorf("overrides = state %q, log %q, external %q", config.StateFile, config.LogFormat, config.ExternalDomain)
+	}
+
+	t.Setenv(envPrefix+"DOCKER_MODE", "false")
+	config, err = reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DockerMode || config.HealthListen != "" {
+		t.Errorf("defaults kept after leaving docker mode: health %q", config.HealthListen)
+	}
+}
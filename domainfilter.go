@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// -only and -skip reconcile a subset of the configured domains, e.g. while debugging one
+// record set without touching the rest. Both take a comma-separated list of domain
+// classes (internal, external, ipv6, combined, toplevel, ipv4_range_N, ipv6_range_N) or
+// full domain names, as network profiles do. Filtered domains are left alone entirely:
+// they are neither updated nor deleted, and cleanup does not consider them.
+
+// domainClasses returns the configured domains with their class names
+func domainClasses(config *Config) [][2]string {
+	var domains [][2]string
+	add := func(class, domain string) {
+		if domain != "" {
+			domains = append(domains, [2]string{class, domain})
+		}
+	}
+	add("internal", config.InternalDomain)
+	add("external", config.ExternalDomain)
+	add("ipv6", config.IPv6Domain)
+	add("combined", config.CombinedDomain)
+	add("toplevel", config.TopLevelDomain)
+	for i, r := range config.CustomIPv4Ranges {
+		add(fmt.Sprintf("ipv4_range_%d", i+1), r.Domain)
+	}
+	for i, r := range config.CustomIPv6Ranges {
+		add(fmt.Sprintf("ipv6_range_%d", i+1), r.Domain)
+	}
+	return domains
+}
+
+// filterDomains applies -only (keep just these) and then -skip (drop these) to config.
+// Selectors matching no configured domain are errors, since a typo would otherwise
+// silently select nothing, and so is a filter that leaves no domain at all.
+func filterDomains(config *Config, only, skip []string) error {
+	if len(only) == 0 && len(skip) == 0 {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	for _, d := range domainClasses(config) {
+		known[d[0]] = true
+		known[normalizeName(d[1])] = true
+	}
+	selector := func(flagName string, list []string) (func(class, domain string) bool, error) {
+		set := make(map[string]bool)
+		for _, s := range list {
+			s = normalizeName(s)
+			if !known[s] {
+				return nil, fmt.Errorf("-%s: %q is not a configured domain or domain class", flagName, s)
+			}
+			set[s] = true
+		}
+		return func(class, domain string) bool {
+			return set[class] || set[normalizeName(domain)]
+		}, nil
+	}
+
+	if len(only) > 0 {
+		selected, err := selector("only", only)
+		if err != nil {
+			return err
+		}
+		retainDomains(config, selected)
+	}
+	if len(skip) > 0 {
+		skipped, err := selector("skip", skip)
+		if err != nil {
+			return err
+		}
+		retainDomains(config, func(class, domain string) bool {
+			return domain != "" && !skipped(class, domain)
+		})
+	}
+
+	remaining := domainClasses(config)
+	if len(remaining) == 0 {
+		return fmt.Errorf("-only/-skip leave no domain to reconcile")
+	}
+	names := make([]string, len(remaining))
+	for i, d := range remaining {
+		names[i] = d[1]
+	}
+	log.Printf("Reconciling only: %s", strings.Join(names, ", "))
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func filterTestConfig() *Config {
+	return &Config{
+		InternalDomain:   "nas.int.example.com",
+		ExternalDomain:   "nas.ext.example.com",
+		IPv6Domain:       "nas.ext.example.com",
+		CombinedDomain:   "nas.example.com",
+		TopLevelDomain:   "www.example.com",
+		CustomIPv4Ranges: []CustomIPRange{{CIDR: "100.64.0.0/10", Domain: "nas.ts.example.com"}},
+	}
+}
+
+func configuredDomains(config *Config) []string {
+	var classes []string
+	for _, d := range domainClasses(config) {
+		classes = append(classes, d[0]+"="+d[1])
+	}
+	return classes
+}
+
+func TestFilterDomains(t *testing.T) {
+	tests := []struct {
+		name       string
+		only, skip []string
+		want       []string
+	}{
+		{"no filters", nil, nil, []string{"internal=nas.int.example.com", "external=nas.ext.example.com", "ipv6=nas.ext.example.com", "combined=nas.example.com", "toplevel=www.example.com", "ipv4_range_1=nas.ts.example.com"}},
+		{"only classes", []string{"external", "combined"}, nil, []string{"external=nas.ext.example.com", "combined=nas.example.com"}},
+		{"only by name", []string{"NAS.ts.example.com."}, nil, []string{"ipv4_range_1=nas.ts.example.com"}},
+		{"name shared by classes", []string{"nas.ext.example.com"}, nil, []string{"external=nas.ext.example.com", "ipv6=nas.ext.example.com"}},
+		{"skip", nil, []string{"internal", "ipv4_range_1", "toplevel"}, []string{"external=nas.ext.example.com", "ipv6=nas.ext.example.com", "combined=nas.example.com"}},
+		{"only then skip", []string{"external", "ipv6", "combined"}, []string{"ipv6"}, []string{"external=nas.ext.example.com", "combined=nas.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := filterTestConfig()
+			if err := filterDomains(config, tt.only, tt.skip); err != nil {
+				t.Fatal(err)
+			}
+			if got := configuredDomains(config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("domains = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterDomainsErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		only, skip []string
+		want       string
+	}{
+		{"typo", []string{"extrenal"}, nil, `-only: "extrenal" is not a configured domain`},
+		{"unconfigured class", nil, []string{"ipv6_range_1"}, `-skip: "ipv6_range_1" is not`},
+		{"nothing left", []string{"combined"}, []string{"nas.example.com"}, "leave no domain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := filterDomains(filterTestConfig(), tt.only, tt.skip)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilteredDomainsAreLeftAlone(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	fake.add("A", "nas.int.example.com", "192.168.1.99") // Stale, but filtered out
+
+	config := &Config{InternalDomain: "nas.int.example.com", ExternalDomain: "nas.ext.example.com"}
+	if err := filterDomains(config, nil, []string{"internal"}); err != nil {
+		t.Fatal(err)
+	}
+	reconcileRecords(cf, config, &IPAddresses{InternalIPv4: []string{"192.168.1.10"}, ExternalIPv4: "203.0.113.1"}, config.now())
+
+	if got := fake.contents("nas.int.example.com", "A"); !reflect.DeepEqual(got, []string{"192.168.1.99"}) {
+		t.Errorf("skipped domain changed: %v", got)
+	}
+	if got := fake.contents("nas.ext.example.com", "A"); !reflect.DeepEqual(got, []string{"203.0.113.1"}) {
+		t.Errorf("external A = %v", got)
+	}
+}
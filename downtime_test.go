@@ -0,0 +1,171 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Downtime simulations: a host keeps its records alive with heartbeats, goes offline,
+// its heartbeats age on a fake clock until the cleanup service removes its records, and
+// it comes back. All against the fake CloudFlare API.
+
+// downtimeHost is a host publishing all its addresses at one combined domain, which
+// also carries its heartbeat and changelog
+func downtimeHost(clock Clock) *Config {
+	return &Config{CombinedDomain: "nas.example.com", Changelog: true, clock: clock}
+}
+
+// downtimeCleanup is the cleanup service managing the host's domain
+func downtimeCleanup(clock Clock) *Config {
+	return &Config{CombinedDomain: "nas.example.com", StaleThreshold: 3600, clock: clock}
+}
+
+var downtimeIPs = &IPAddresses{
+	InternalIPv4: []string{"192.168.1.10"},
+	ExternalIPv4: "203.0.113.1",
+	ExternalIPv6: "2001:db8::10",
+}
+
+// downtimeRecords returns the address records of the host, by domain and type
+func downtimeRecords(fake *fakeCloudFlare) map[string][]string {
+	records := make(map[string][]string)
+	for _, recordType := range []string{"A", "AAAA"} {
+		if contents := fake.contents("nas.example.com", recordType); len(contents) > 0 {
+			records[recordType] = contents
+		}
+	}
+	return records
+}
+
+// cleanupRun runs one cleanup cycle and returns the number of records it deleted
+func cleanupRun(cf providerClient, config *Config) int {
+	deleted := 0
+	cf.hooks().OnChange = func(action, recordType, name, content string) {
+		if action == "delete" {
+			deleted++
+		}
+	}
+	defer func() { cf.hooks().OnChange = nil }()
+	runCleanup(cf, config)
+	return deleted
+}
+
+func TestDowntimeRecordLifecycle(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	host := downtimeHost(clock)
+	cleanup := downtimeCleanup(clock)
+	fake.add("A", "web.int.example.com", "192.168.1.20") // Another host, not managed by this cleanup
+
+	// Online: updates every 5 minutes keep the heartbeats fresh through many cleanup cycles
+	for i := 0; i < 24; i++ {
+		if success, total := reconcileRecords(cf, host, downtimeIPs, clock.Now()); success != total {
+			t.Fatalf("update %d: %d of %d operations succeeded", i, success, total)
+		}
+		clock.advance(5 * time.Minute)
+		if deleted := cleanupRun(cf, cleanup); deleted != 0 {
+			t.Fatalf("cleanup deleted %d records of a host that is online", deleted)
+		}
+	}
+	if got := fake.contents("nas.example.com", "A"); len(got) != 2 {
+		t.Fatalf("online host A records = %v, want internal and external", got)
+	}
+	if len(fake.contents("_changelog.nas.example.com", "TXT")) == 0 {
+		t.Fatal("no changelog was written")
+	}
+
+	// Offline: heartbeats age, records survive until the threshold is passed
+	clock.advance(55 * time.Minute)
+	if deleted := cleanupRun(cf, cleanup); deleted != 0 {
+		t.Fatalf("cleanup deleted %d records before the heartbeat was stale", deleted)
+	}
+	clock.advance(time.Minute)
+	if deleted := cleanupRun(cf, cleanup); deleted == 0 {
+		t.Fatal("cleanup deleted nothing after the heartbeat went stale")
+	}
+	if got := downtimeRecords(fake); len(got) != 0 {
+		t.Errorf("records left after cleanup: %v", got)
+	}
+	for _, name := range []string{"nas.example.com", "_changelog.nas.example.com"} {
+		if got := fake.contents(name, "TXT"); len(got) != 0 {
+			t.Errorf("TXT records left at %s: %v", name, got)
+		}
+	}
+	if got := fake.contents("web.int.example.com", "A"); len(got) != 1 {
+		t.Errorf("cleanup touched an unmanaged host: %v", got)
+	}
+	if deleted := cleanupRun(cf, cleanup); deleted != 0 {
+		t.Errorf("second cleanup deleted %d more records", deleted)
+	}
+
+	// Back online: the first update recreates everything with fresh heartbeats
+	clock.advance(6 * time.Hour)
+	if success, total := reconcileRecords(cf, host, downtimeIPs, clock.Now()); success != total {
+		t.Fatalf("update after return: %d of %d operations succeeded", success, total)
+	}
+	if got := downtimeRecords(fake); len(got["A"]) != 2 || len(got["AAAA"]) != 1 {
+		t.Errorf("returned host records = %v", got)
+	}
+	clock.advance(30 * time.Minute)
+	if deleted := cleanupRun(cf, cleanup); deleted != 0 {
+		t.Errorf("cleanup deleted %d records of the returned host", deleted)
+	}
+}
+
+func TestShortOutageKeepsRecords(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	host := downtimeHost(clock)
+	cleanup := downtimeCleanup(clock)
+
+	reconcileRecords(cf, host, downtimeIPs, clock.Now())
+	before := downtimeRecords(fake)
+
+	// A 50 minute outage with cleanup running every 10 minutes
+	for i := 0; i < 5; i++ {
+		clock.advance(10 * time.Minute)
+		if deleted := cleanupRun(cf, cleanup); deleted != 0 {
+			t.Fatalf("cleanup deleted %d records during a short outage", deleted)
+		}
+	}
+
+	// Returning with the same addresses changes nothing but the heartbeats
+	var changes []RecordChange
+	cf.hooks().OnChange = newChangeRecorder(&changes)
+	reconcileRecords(cf, host, downtimeIPs, clock.Now())
+	cf.hooks().OnChange = nil
+	if len(changes) != 0 {
+		t.Errorf("return after a short outage changed records: %+v", changes)
+	}
+	if got := downtimeRecords(fake); !reflect.DeepEqual(got, before) {
+		t.Errorf("records = %v, want %v", got, before)
+	}
+
+	// The heartbeat restarts the staleness clock
+	clock.advance(50 * time.Minute)
+	if deleted := cleanupRun(cf, cleanup); deleted != 0 {
+		t.Errorf("cleanup deleted %d records 50 minutes after the return", deleted)
+	}
+}
+
+func TestReturnWithNewAddressAfterCleanup(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	host := downtimeHost(clock)
+	cleanup := downtimeCleanup(clock)
+
+	reconcileRecords(cf, host, downtimeIPs, clock.Now())
+	clock.advance(2 * time.Hour)
+	cleanupRun(cf, cleanup)
+
+	// The host comes back from another network: only the new addresses are published
+	moved := &IPAddresses{InternalIPv4: []string{"10.0.0.5"}, ExternalIPv4: "198.51.100.7"}
+	reconcileRecords(cf, host, moved, clock.Now())
+	if got := fake.contents("nas.example.com", "A"); !reflect.DeepEqual(got, []string{"10.0.0.5", "198.51.100.7"}) {
+		t.Errorf("A = %v, want [10.0.0.5 198.51.100.7]", got)
+	}
+	if got := fake.contents("nas.example.com", "AAAA"); len(got) != 0 {
+		t.Errorf("stale AAAA published again: %v", got)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dynDNS2UserAgent identifies the client, as DynDNS2 services require
+const dynDNS2UserAgent = "richleigh-dynipupdate/1.0"
+
+// DynDNS2Provider pushes addresses to services speaking the DynDNS2 update protocol
+// (GET /nic/update?hostname=...&myip=... with basic auth), such as No-IP, Dyn and many
+// registrars. The protocol can only set the address of a hostname, so this is a
+// recordSetBackend that supports single A and AAAA values and nothing else.
+//
+// Services block clients that repeat unchanged updates, so the last address sent for
+// each hostname is remembered (seeded from DNS) and unchanged addresses are not sent.
+type DynDNS2Provider struct {
+	Server   string // Base URL, e.g. https://dynupdate.no-ip.com
+	Username string
+	Password string
+
+	client   *http.Client
+	resolver *net.Resolver
+
+	mu               sync.Mutex
+	sent             map[string]string // hostname/type -> last address the service confirmed
+	disabled         error             // Set after a response that forbids further updates
+	heartbeatWarning sync.Once
+
+	recordSetClient
+}
+
+// Verify DynDNS2Provider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*DynDNS2Provider)(nil)
+var _ DNSProvider = (*DynDNS2Provider)(nil)
+var _ providerClient = (*DynDNS2Provider)(nil)
+
+// Responses after which the service expects no more updates until the configuration is
+// fixed; retrying them can get the account blocked
+var dynDNS2FatalResponses = map[string]string{
+	"badauth":  "invalid username or password",
+	"!donator": "the requested feature needs a paid account",
+	"notfqdn":  "the hostname is not a fully qualified domain name",
+	"nohost":   "the hostname does not exist in this account",
+	"numhost":  "too many hostnames in one update",
+	"abuse":    "the hostname is blocked for abuse",
+	"badagent": "the client is blocked",
+}
+
+// newDynDNS2Provider creates a DynDNS2 provider
+func newDynDNS2Provider(config *Config) (*DynDNS2Provider, error) {
+	server := strings.TrimSuffix(config.DynDNS2Server, "/")
+	if !strings.HasPrefix(server, "https://") && !strings.HasPrefix(server, "http://") {
+		server = "https://" + server
+	}
+	if strings.HasPrefix(server, "http://") {
+		log.Printf("WARNING: %sDYNDNS2_SERVER uses http - the password is sent in clear text", envPrefix)
+	}
+
+	// Not retried: the protocol asks clients to back off for minutes after server errors
+	stack := newProviderStack(config, providerDynDNS2)
+	stack.retry = nil
+
+	d := &DynDNS2Provider{
+		Server:   server,
+		Username: config.DynDNS2Username,
+		Password: config.DynDNS2Password,
+		client:   stack.client(),
+		resolver: net.DefaultResolver,
+		sent:     make(map[string]string),
+	}
+	d.recordSetClient = newRecordSetClient(d, config)
+	return d, nil
+}
+
+// update sends one address for a hostname and interprets the response
+func (d *DynDNS2Provider) update(hostname, address string) error {
+	d.mu.Lock()
+	disabled := d.disabled
+	d.mu.Unlock()
+	if disabled != nil {
+		return fmt.Errorf("updates stopped after an earlier error (%v) - fix the configuration and restart", disabled)
+	}
+
+	query := url.Values{"hostname": {hostname}, "myip": {address}}
+	req, err := http.NewRequest("GET", d.Server+"/nic/update?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(d.Username, d.Password)
+	req.Header.Set("User-Agent", dynDNS2UserAgent)
+
+	log.Printf("API Request: GET %s/nic/update (hostname=%s)", d.Server, hostname)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	answer := strings.TrimSpace(string(body))
+	code, _, _ := strings.Cut(answer, " ")
+
+	switch {
+	case code == "good" || code == "nochg":
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		code = "badauth"
+	}
+	if reason, fatal := dynDNS2FatalResponses[code]; fatal {
+		err := fmt.Errorf("%s: %s", code, reason)
+		if code == "badauth" {
+			err = fmt.Errorf("%w: %s: %s", errProviderAuth, code, reason)
+		}
+		d.mu.Lock()
+		d.disabled = err
+		d.mu.Unlock()
+		return err
+	}
+	if code == "911" || code == "dnserr" || resp.StatusCode >= 500 {
+		return fmt.Errorf("service error %q - will retry on the next run", answer)
+	}
+	return fmt.Errorf("unexpected response (status %d): %q", resp.StatusCode, answer)
+}
+
+// upsertHeartbeat does nothing: DynDNS2 services cannot publish TXT records, so
+// heartbeats (and with them the cleanup service) are not available
+func (d *DynDNS2Provider) upsertHeartbeat(name, content string) error {
+	d.heartbeatWarning.Do(func() {
+		log.Println("Heartbeats are not published with DynDNS2 (TXT records are not supported)")
+	})
+	return nil
+}
+
+// dynDNS2Key is the cache key of a hostname and record type
+func dynDNS2Key(name, recordType string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "/" + recordType
+}
+
+// recordSetBackend implementation
+
+// fetchValues returns the address last sent for the hostname. Before the first update
+// the address is looked up in DNS, so a restart does not resend an unchanged address.
+func (d *DynDNS2Provider) fetchValues(name, recordType string) ([]string, error) {
+	if recordType != "A" && recordType != "AAAA" {
+		return nil, nil
+	}
+	key := dynDNS2Key(name, recordType)
+	d.mu.Lock()
+	address, ok := d.sent[key]
+	d.mu.Unlock()
+	if !ok {
+		network := "ip4"
+		if recordType == "AAAA" {
+			network = "ip6"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if addrs, err := d.resolver.LookupNetIP(ctx, network, name); err == nil && len(addrs) > 0 {
+			address = normalizeAddr(addrs[0]).String()
+		}
+		d.mu.Lock()
+		d.sent[key] = address
+		d.mu.Unlock()
+	}
+	if address == "" {
+		return nil, nil
+	}
+	return []string{address}, nil
+}
+
+func (d *DynDNS2Provider) storeValues(name, recordType string, ttl int, values []string) error {
+	switch {
+	case recordType != "A" && recordType != "AAAA":
+		return fmt.Errorf("DynDNS2 services only support A and AAAA records, not %s", recordType)
+	case len(values) == 0:
+		return errors.New("DynDNS2 services cannot delete records")
+	case len(values) > 1:
+		return errors.New("DynDNS2 services only support one address per hostname")
+	}
+
+	name = strings.TrimSuffix(name, ".")
+	if err := d.update(name, values[0]); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.sent[dynDNS2Key(name, recordType)] = values[0]
+	d.mu.Unlock()
+	return nil
+}
+
+// listRecords returns nothing: the protocol cannot list records, so cleanup has no effect
+func (d *DynDNS2Provider) listRecords(recordType string) ([]CFRecord, error) {
+	return []CFRecord{}, nil
+}
+
+func (d *DynDNS2Provider) probe() bool {
+	req, err := http.NewRequest("HEAD", d.Server+"/", nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", dynDNS2UserAgent)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// dynDNS2Mirror is the client used for DYNDNS2_HOSTNAMES, kept across runs so that
+// unchanged addresses are not resent
+var (
+	dynDNS2MirrorMu       sync.Mutex
+	dynDNS2Mirror         *DynDNS2Provider
+	dynDNS2MirrorSettings string // dynDNS2MirrorKey of the configuration it was created from
+)
+
+// dynDNS2MirrorKey returns the settings of a configuration the mirror client is created from
+func dynDNS2MirrorKey(config *Config) string {
+	return strings.Join([]string{config.DynDNS2Server, config.DynDNS2Username, config.DynDNS2Password}, "\x00")
+}
+
+// dynDNS2MirrorFor returns the mirror client of a configuration. A reload that changes the
+// server or credentials gets a new client, which drops the addresses sent to the old
+// settings and updates stopped after an earlier error (a bad password, say).
+func dynDNS2MirrorFor(config *Config) *DynDNS2Provider {
+	dynDNS2MirrorMu.Lock()
+	defer dynDNS2MirrorMu.Unlock()
+	if key := dynDNS2MirrorKey(config); dynDNS2Mirror == nil || key != dynDNS2MirrorSettings {
+		dynDNS2Mirror, _ = newDynDNS2Provider(config)
+		dynDNS2MirrorSettings = key
+	}
+	return dynDNS2Mirror
+}
+
+// pushDynDNS2Hostnames sends the external addresses to every DYNDNS2_HOSTNAMES hostname,
+// alongside the main provider. Returns the number of successful/attempted operations.
+func pushDynDNS2Hostnames(config *Config, ips *IPAddresses) (successCount, totalCount int) {
+	if len(config.DynDNS2Hostnames) == 0 {
+		return 0, 0
+	}
+	mirror := dynDNS2MirrorFor(config)
+
+	for _, hostname := range config.DynDNS2Hostnames {
+		for _, target := range []struct{ recordType, address string }{
+			{"A", ips.ExternalIPv4},
+			{"AAAA", ips.ExternalIPv6},
+		} {
+			if target.address == "" {
+				continue // Nothing to send; the service keeps the last address
+			}
+			totalCount++
+			if succeeded(mirror.upsertRecord(hostname, target.recordType, target.address, false)) {
+				successCount++
+			}
+		}
+	}
+	return successCount, totalCount
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDynDNS2 is a DynDNS2 update service answering with a fixed response
+type fakeDynDNS2 struct {
+	mu       sync.Mutex
+	response string
+	requests []string // hostname=myip of each update
+}
+
+func newFakeDynDNS2(t *testing.T) (*fakeDynDNS2, *DynDNS2Provider) {
+	t.Helper()
+	fake := &fakeDynDNS2{response: "good"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if r.URL.Path != "/nic/update" || !ok || user != "user" || pass != "secret" {
+			http.Error(w, "badauth", http.StatusUnauthorized)
+			return
+		}
+		if r.UserAgent() != dynDNS2UserAgent {
+			w.Write([]byte("badagent"))
+			return
+		}
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.requests = append(fake.requests, r.URL.Query().Get("hostname")+"="+r.URL.Query().Get("myip"))
+		w.Write([]byte(fake.response + " " + r.URL.Query().Get("myip")))
+	}))
+	t.Cleanup(server.Close)
+
+	d, err := newDynDNS2Provider(&Config{DynDNS2Server: server.URL, DynDNS2Username: "user", DynDNS2Password: "secret", RecordTTL: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing resolves, so the first update is always sent
+	d.resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("no DNS in tests")
+	}}
+	return fake, d
+}
+
+func (f *fakeDynDNS2) sent() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return strings.Join(f.requests, ",")
+}
+
+func TestDynDNS2UpdatesOnlyOnChange(t *testing.T) {
+	fake, d := newFakeDynDNS2(t)
+
+	for _, address := range []string{"203.0.113.1", "203.0.113.1", "203.0.113.2"} {
+		if d.upsertRecord("home.example.com", "A", address, false) != nil {
+			t.Fatalf("upsert %s failed", address)
+		}
+	}
+	if d.upsertRecord("home.example.com", "AAAA", "2001:db8::1", false) != nil {
+		t.Fatal("AAAA upsert failed")
+	}
+	want := "home.example.com=203.0.113.1,home.example.com=203.0.113.2,home.example.com=2001:db8::1"
+	if got := fake.sent(); got != want {
+		t.Errorf("updates = %s, want %s", got, want)
+	}
+	if got, _ := d.getRecord("home.example.com", "A"); got == nil || got.Content != "203.0.113.2" {
+		t.Errorf("cached record = %+v", got)
+	}
+}
+
+func TestDynDNS2Unsupported(t *testing.T) {
+	fake, d := newFakeDynDNS2(t)
+	d.upsertRecord("home.example.com", "A", "203.0.113.1", false)
+
+	if d.deleteRecordIfExists("home.example.com", "A") == nil {
+		t.Error("delete reported success")
+	}
+	if d.createRecord("home.example.com", "TXT", "hello", false) == nil {
+		t.Error("TXT create reported success")
+	}
+	if d.upsertHeartbeat("_heartbeat.home.example.com", "1700000000") != nil {
+		t.Error("heartbeat should be skipped without failing the run")
+	}
+	if got := fake.sent(); got != "home.example.com=203.0.113.1" {
+		t.Errorf("updates = %s", got)
+	}
+}
+
+func TestDynDNS2FatalResponseStopsUpdates(t *testing.T) {
+	fake, d := newFakeDynDNS2(t)
+	fake.response = "nohost"
+
+	err := d.storeValues("home.example.com", "A", 60, []string{"203.0.113.1"})
+	if err == nil || !strings.Contains(err.Error(), "nohost") {
+		t.Fatalf("err = %v", err)
+	}
+	fake.response = "good"
+	if err := d.storeValues("home.example.com", "A", 60, []string{"203.0.113.2"}); err == nil {
+		t.Error("update sent after a fatal response")
+	}
+	if got := fake.sent(); got != "home.example.com=203.0.113.1" {
+		t.Errorf("updates = %s", got)
+	}
+}
+
+func TestDynDNS2Errors(t *testing.T) {
+	fake, d := newFakeDynDNS2(t)
+
+	// Temporary service errors are retried later
+	fake.response = "911"
+	if err := d.storeValues("home.example.com", "A", 60, []string{"203.0.113.1"}); err == nil {
+		t.Error("expected an error for 911")
+	}
+	fake.response = "good"
+	if err := d.storeValues("home.example.com", "A", 60, []string{"203.0.113.1"}); err != nil {
+		t.Errorf("retry failed: %v", err)
+	}
+
+	// Wrong credentials disable the client
+	d.Password = "wrong"
+	if err := d.storeValues("home.example.com", "A", 60, []string{"203.0.113.2"}); err == nil || !strings.Contains(err.Error(), "badauth") {
+		t.Errorf("err = %v", err)
+	}
+
+	// An unreachable service is reported as such
+	unreachable, _ := newDynDNS2Provider(&Config{DynDNS2Server: "http://127.0.0.1:1"})
+	if err := unreachable.storeValues("home.example.com", "A", 60, []string{"203.0.113.1"}); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("err = %v, want unreachable", err)
+	}
+}
+
+func TestPushDynDNS2Hostnames(t *testing.T) {
+	fake, d := newFakeDynDNS2(t)
+	config := &Config{DynDNS2Server: d.Server, DynDNS2Username: "user", DynDNS2Password: "secret",
+		DynDNS2Hostnames: []string{"a.example.net", "b.example.net"}}
+	dynDNS2Mirror, dynDNS2MirrorSettings = d, dynDNS2MirrorKey(config)
+	t.Cleanup(func() { dynDNS2Mirror, dynDNS2MirrorSettings = nil, "" })
+
+	ips := &IPAddresses{ExternalIPv4: "203.0.113.1"}
+	if success, total := pushDynDNS2Hostnames(config, ips); success != 2 || total != 2 {
+		t.Errorf("counts = %d/%d, want 2/2", success, total)
+	}
+	if got := fake.sent(); got != "a.example.net=203.0.113.1,b.example.net=203.0.113.1" {
+		t.Errorf("updates = %s", got)
+	}
+
+	if success, total := pushDynDNS2Hostnames(&Config{}, ips); success != 0 || total != 0 {
+		t.Errorf("counts without hostnames = %d/%d", success, total)
+	}
+
+	// Changed credentials (a reload fixing a bad password) get a client that is not disabled
+	d.Password = "wrong"
+	if success, total := pushDynDNS2Hostnames(config, &IPAddresses{ExternalIPv4: "203.0.113.2"}); success != 0 || total != 2 {
+		t.Errorf("counts with a bad password = %d/%d", success, total)
+	}
+	if dynDNS2MirrorFor(config) != d {
+		t.Error("mirror replaced although its settings are unchanged")
+	}
+	config.DynDNS2Password = "fixed"
+	if mirror := dynDNS2MirrorFor(config); mirror == d || mirror.Password != "fixed" || mirror.disabled != nil {
+		t.Errorf("mirror after the reload = %+v", mirror)
+	}
+}
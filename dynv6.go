@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Dynv6Provider implements DNSProvider (and the internal record operations) for zones at
+// dynv6.com. A zone's own addresses are fields of the zone (ipv4address, ipv6prefix);
+// names below it are individual records. Two transports are supported:
+//
+//   - The REST API (default) manages both, so heartbeats, cleanup and the changelog work.
+//   - The update URL (/api/update?zone=...&token=...) only sets the zone's own addresses,
+//     like DynDNS2, for tokens that are not allowed to use the API.
+//
+// With a prefix length, the zone's IPv6 field holds the delegated prefix (e.g. the /56 of
+// the detected address) instead of the address. dynv6 completes AAAA records whose data is
+// only an interface identifier (::1:2:3:4) with that prefix, so devices behind the router
+// follow prefix changes without running an updater themselves.
+type Dynv6Provider struct {
+	Zone         string // Zone name, e.g. home.dynv6.net
+	Endpoint     string // e.g. https://dynv6.com
+	Token        string
+	UpdateURL    bool // Use the update URL instead of the REST API
+	PrefixLength int  // Publish the zone's IPv6 as a prefix of this length (0 = the address)
+
+	client *http.Client
+
+	mu               sync.Mutex
+	zoneID           int64
+	sent             map[string]string // Zone addresses by record type, as last stored
+	heartbeatWarning sync.Once
+
+	recordSetClient
+}
+
+// Verify Dynv6Provider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*Dynv6Provider)(nil)
+var _ DNSProvider = (*Dynv6Provider)(nil)
+var _ providerClient = (*Dynv6Provider)(nil)
+
+// dynv6 API structures
+type dynv6Zone struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	IPv4Address string `json:"ipv4address"`
+	IPv6Prefix  string `json:"ipv6prefix"`
+}
+
+type dynv6Record struct {
+	ID   int64  `json:"id,omitempty"`
+	Type string `json:"type"`
+	Name string `json:"name"` // Relative to the zone
+	Data string `json:"data"`
+}
+
+// newDynv6Provider creates a dynv6 provider
+func newDynv6Provider(config *Config) (*Dynv6Provider, error) {
+	p := &Dynv6Provider{
+		Zone:         normalizeName(config.Dynv6Zone),
+		Endpoint:     "https://dynv6.com",
+		Token:        config.Dynv6Token,
+		UpdateURL:    config.Dynv6UpdateURL,
+		PrefixLength: config.Dynv6PrefixLength,
+		client:       newProviderHTTPClient(config, providerDynv6),
+		sent:         make(map[string]string),
+	}
+	if p.PrefixLength != 0 && (p.PrefixLength < 32 || p.PrefixLength > 64) {
+		return nil, fmt.Errorf("%sDYNV6_PREFIX_LENGTH must be between 32 and 64, got %d", envPrefix, p.PrefixLength)
+	}
+	p.recordSetClient = newRecordSetClient(p, config)
+
+	transport := "REST API"
+	if p.UpdateURL {
+		transport = "update URL"
+		for _, d := range domainClasses(config) {
+			if normalizeName(d[1]) != p.Zone {
+				log.Printf("WARNING: the dynv6 update URL only sets the addresses of the zone %s - %s needs the REST API", p.Zone, d[1])
+			}
+		}
+	}
+	log.Printf("Using dynv6 zone %s (%s)", p.Zone, transport)
+	if p.PrefixLength != 0 {
+		log.Printf("Publishing the /%d prefix of the external IPv6 address as the zone's prefix", p.PrefixLength)
+	}
+
+	warnUnsupportedOptions(config, "dynv6")
+	return p, nil
+}
+
+// relativeName returns the record name relative to the zone ("" for the zone itself)
+func (p *Dynv6Provider) relativeName(name string) (string, error) {
+	name = normalizeName(name)
+	if name == p.Zone {
+		return "", nil
+	}
+	if relative, ok := strings.CutSuffix(name, "."+p.Zone); ok {
+		return relative, nil
+	}
+	return "", fmt.Errorf("%s is not in zone %s", name, p.Zone)
+}
+
+// request performs an authenticated REST API request and decodes the response into result
+func (p *Dynv6Provider) request(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, p.Endpoint+"/api/v2"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+	req.Header.Set("Accept", "application/json")
+
+	log.Printf("API Request: %s /api/v2%s", method, path)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message := strings.TrimSpace(string(data))
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error != "" {
+			message = errResp.Error
+		}
+		return statusError(resp.StatusCode, message)
+	}
+	if result != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+	}
+	return nil
+}
+
+// zone returns the zone, remembering its ID for record requests
+func (p *Dynv6Provider) zone() (*dynv6Zone, error) {
+	var zone dynv6Zone
+	if err := p.request("GET", "/zones/by-name/"+url.PathEscape(p.Zone), nil, &zone); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.zoneID = zone.ID
+	p.mu.Unlock()
+	return &zone, nil
+}
+
+// recordsPath returns the path of the zone's records, looking the zone up on first use
+func (p *Dynv6Provider) recordsPath() (string, error) {
+	p.mu.Lock()
+	id := p.zoneID
+	p.mu.Unlock()
+	if id == 0 {
+		zone, err := p.zone()
+		if err != nil {
+			return "", err
+		}
+		id = zone.ID
+	}
+	return fmt.Sprintf("/zones/%d/records", id), nil
+}
+
+// records returns the records of a relative name and type
+func (p *Dynv6Provider) records(relative, recordType string) ([]dynv6Record, error) {
+	path, err := p.recordsPath()
+	if err != nil {
+		return nil, err
+	}
+	var all []dynv6Record
+	if err := p.request("GET", path, nil, &all); err != nil {
+		return nil, err
+	}
+	var records []dynv6Record
+	for _, r := range all {
+		if strings.EqualFold(r.Name, relative) && r.Type == recordType {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// zoneValue converts a zone address to publish: with a prefix length, the IPv6 address
+// becomes its delegated prefix
+func (p *Dynv6Provider) zoneValue(recordType, value string) (string, error) {
+	if recordType != "AAAA" || p.PrefixLength == 0 {
+		return value, nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return "", err
+	}
+	prefix, err := addr.Prefix(p.PrefixLength)
+	if err != nil {
+		return "", err
+	}
+	return prefix.String(), nil
+}
+
+// sentZoneValue returns the zone address last stored, if the zone still holds it
+func (p *Dynv6Provider) sentZoneValue(recordType, current string) (string, bool) {
+	p.mu.Lock()
+	sent, ok := p.sent[recordType]
+	p.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	if p.UpdateURL {
+		return sent, true // The update URL cannot read the zone back
+	}
+	if value, err := p.zoneValue(recordType, sent); err == nil && sameContent(value, current) {
+		return sent, true
+	}
+	return "", false
+}
+
+// update sets the zone's addresses with the update URL
+func (p *Dynv6Provider) update(recordType, value string) error {
+	query := url.Values{"zone": {p.Zone}, "token": {p.Token}}
+	switch {
+	case recordType == "A":
+		query.Set("ipv4", value)
+	case p.PrefixLength != 0:
+		query.Set("ipv6prefix", value)
+	default:
+		query.Set("ipv6", value)
+	}
+	req, err := http.NewRequest("GET", p.Endpoint+"/api/update?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("API Request: GET %s/api/update (zone=%s)", p.Endpoint, p.Zone)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// upsertHeartbeat publishes the heartbeat, except with the update URL, which cannot
+// set TXT records (heartbeats and the cleanup service are then not available)
+func (p *Dynv6Provider) upsertHeartbeat(name, content string) error {
+	if p.UpdateURL {
+		p.heartbeatWarning.Do(func() {
+			log.Println("Heartbeats are not published with the dynv6 update URL (TXT records need the REST API)")
+		})
+		return nil
+	}
+	return p.recordSetClient.upsertHeartbeat(name, content)
+}
+
+// recordSetBackend implementation
+
+func (p *Dynv6Provider) fetchValues(name, recordType string) ([]string, error) {
+	relative, err := p.relativeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if relative == "" && (recordType == "A" || recordType == "AAAA") {
+		current := ""
+		if !p.UpdateURL {
+			zone, err := p.zone()
+			if err != nil {
+				return nil, err
+			}
+			current = zone.IPv4Address
+			if recordType == "AAAA" {
+				current = zone.IPv6Prefix
+			}
+		}
+		// With a prefix length the zone holds the prefix, not the address that was stored
+		if sent, ok := p.sentZoneValue(recordType, current); ok {
+			current = sent
+		}
+		if current == "" {
+			return nil, nil
+		}
+		return []string{current}, nil
+	}
+	if p.UpdateURL {
+		return nil, nil
+	}
+
+	records, err := p.records(relative, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, r := range records {
+		values = append(values, r.Data)
+	}
+	return values, nil
+}
+
+func (p *Dynv6Provider) storeValues(name, recordType string, ttl int, values []string) error {
+	relative, err := p.relativeName(name)
+	if err != nil {
+		return err
+	}
+
+	if relative == "" && (recordType == "A" || recordType == "AAAA") {
+		if len(values) > 1 {
+			return fmt.Errorf("a dynv6 zone holds one %s address - publish more on names below it", recordType)
+		}
+		value := ""
+		if len(values) == 1 {
+			if value, err = p.zoneValue(recordType, values[0]); err != nil {
+				return err
+			}
+		}
+		if p.UpdateURL {
+			if value == "" {
+				return errors.New("the dynv6 update URL cannot remove addresses")
+			}
+			err = p.update(recordType, value)
+		} else {
+			field := map[string]string{"ipv4address": value}
+			if recordType == "AAAA" {
+				field = map[string]string{"ipv6prefix": value}
+			}
+			var zone *dynv6Zone
+			if zone, err = p.zone(); err == nil {
+				err = p.request("PATCH", fmt.Sprintf("/zones/%d", zone.ID), field, nil)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		p.mu.Lock()
+		if len(values) == 1 {
+			p.sent[recordType] = values[0]
+		} else {
+			delete(p.sent, recordType)
+		}
+		p.mu.Unlock()
+		return nil
+	}
+	if p.UpdateURL {
+		return fmt.Errorf("the dynv6 update URL only sets the addresses of the zone %s, not %s %s", p.Zone, recordType, name)
+	}
+
+	path, err := p.recordsPath()
+	if err != nil {
+		return err
+	}
+	existing, err := p.records(relative, recordType)
+	if err != nil {
+		return err
+	}
+
+	// Keep records that already hold a wanted value, delete the rest, create what is missing
+	kept, stale := matchValues(existing, values, func(r dynv6Record, v string) bool {
+		return sameContent(r.Data, porkbunContent(recordType, v))
+	})
+	for _, r := range stale {
+		if err := p.request("DELETE", fmt.Sprintf("%s/%d", path, r.ID), nil, nil); err != nil {
+			return err
+		}
+	}
+	for i, v := range values {
+		if kept[i] {
+			continue
+		}
+		record := dynv6Record{Type: recordType, Name: relative, Data: porkbunContent(recordType, v)}
+		if err := p.request("POST", path, record, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Dynv6Provider) listRecords(recordType string) ([]CFRecord, error) {
+	if p.UpdateURL {
+		return []CFRecord{}, nil // The update URL cannot list records, so cleanup has no effect
+	}
+	path, err := p.recordsPath()
+	if err != nil {
+		return []CFRecord{}, err
+	}
+	var all []dynv6Record
+	if err := p.request("GET", path, nil, &all); err != nil {
+		return []CFRecord{}, err
+	}
+	records := []CFRecord{}
+	for _, r := range all {
+		if r.Type != recordType {
+			continue
+		}
+		name := p.Zone
+		if r.Name != "" {
+			name = r.Name + "." + p.Zone
+		}
+		records = append(records, valueRecords(name, recordType, []string{r.Data})...)
+	}
+	return records, nil
+}
+
+func (p *Dynv6Provider) probe() bool {
+	req, err := http.NewRequest("HEAD", p.Endpoint+"/", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDynv6 is an in-memory dynv6 API for the zone home.dynv6.net (zone ID 42)
+type fakeDynv6 struct {
+	mu      sync.Mutex
+	zone    dynv6Zone
+	records map[int64]dynv6Record
+	nextID  int64
+	calls   []string // Methods and paths of all requests
+	updates []string // Queries of update URL requests, without the token
+}
+
+func newFakeDynv6(t *testing.T, config *Config) (*fakeDynv6, *Dynv6Provider) {
+	t.Helper()
+	fake := &fakeDynv6{zone: dynv6Zone{ID: 42, Name: "home.dynv6.net"}, records: make(map[int64]dynv6Record)}
+	server := httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(server.Close)
+
+	config.Dynv6Zone, config.Dynv6Token, config.RecordTTL = "Home.dynv6.net.", "test-token", 120
+	p, err := newDynv6Provider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Endpoint = server.URL
+	return fake, p
+}
+
+// contents returns the records as name/type/data, sorted
+func (f *fakeDynv6) contents() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []string
+	for _, r := range f.records {
+		result = append(result, r.Name+"/"+r.Type+"/"+r.Data)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (f *fakeDynv6) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+
+	reply := func(status int, body interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	if r.URL.Path == "/api/update" {
+		query := r.URL.Query()
+		if query.Get("token") != "test-token" || query.Get("zone") != "home.dynv6.net" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "invalid authentication token")
+			return
+		}
+		query.Del("token")
+		f.updates = append(f.updates, query.Encode())
+		fmt.Fprint(w, "addresses updated")
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		reply(http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v2")
+	switch {
+	case path == "/zones/by-name/home.dynv6.net" && r.Method == "GET":
+		reply(http.StatusOK, f.zone)
+	case path == "/zones/42" && r.Method == "PATCH":
+		var change map[string]string
+		json.NewDecoder(r.Body).Decode(&change)
+		if v, ok := change["ipv4address"]; ok {
+			f.zone.IPv4Address = v
+		}
+		if v, ok := change["ipv6prefix"]; ok {
+			f.zone.IPv6Prefix = v
+		}
+		reply(http.StatusOK, f.zone)
+	case path == "/zones/42/records" && r.Method == "GET":
+		records := []dynv6Record{}
+		for _, rec := range f.records {
+			records = append(records, rec)
+		}
+		sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+		reply(http.StatusOK, records)
+	case path == "/zones/42/records" && r.Method == "POST":
+		var rec dynv6Record
+		json.NewDecoder(r.Body).Decode(&rec)
+		f.nextID++
+		rec.ID = f.nextID
+		f.records[rec.ID] = rec
+		reply(http.StatusOK, rec)
+	case strings.HasPrefix(path, "/zones/42/records/") && r.Method == "DELETE":
+		var id int64
+		fmt.Sscan(strings.TrimPrefix(path, "/zones/42/records/"), &id)
+		if _, ok := f.records[id]; !ok {
+			reply(http.StatusNotFound, map[string]string{"error": "record not found"})
+			return
+		}
+		delete(f.records, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		reply(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+func TestDynv6ZoneAddresses(t *testing.T) {
+	fake, p := newFakeDynv6(t, &Config{})
+
+	if p.upsertRecord("home.dynv6.net", "A", "203.0.113.1", false) != nil || p.upsertRecord("home.dynv6.net", "AAAA", "2001:db8:1200:5::10", false) != nil {
+		t.Fatal("upsertRecord failed")
+	}
+	if fake.zone.IPv4Address != "203.0.113.1" || fake.zone.IPv6Prefix != "2001:db8:1200:5::10" {
+		t.Errorf("zone = %+v", fake.zone)
+	}
+	if r, _ := p.getRecord("home.dynv6.net", "AAAA"); r == nil || r.Content != "2001:db8:1200:5::10" {
+		t.Errorf("getRecord = %+v", r)
+	}
+
+	// A zone holds one address of each family
+	if p.ensureRecordExists("home.dynv6.net", "A", "203.0.113.2", false) == nil {
+		t.Error("added a second address to the zone")
+	}
+	if len(fake.contents()) != 0 {
+		t.Errorf("zone addresses written as records: %v", fake.contents())
+	}
+}
+
+func TestDynv6RecordOperations(t *testing.T) {
+	fake, p := newFakeDynv6(t, &Config{})
+
+	for _, ip := range []string{"192.168.1.10", "192.168.1.11"} {
+		if p.ensureRecordExists("nas.home.dynv6.net", "A", ip, false) != nil {
+			t.Fatalf("ensureRecordExists(%s) failed", ip)
+		}
+	}
+	if p.upsertHeartbeat("nas.home.dynv6.net", `"1700000000"`) != nil {
+		t.Fatal("upsertHeartbeat failed")
+	}
+	want := []string{"nas/A/192.168.1.10", "nas/A/192.168.1.11", "nas/TXT/1700000000"}
+	if got := fake.contents(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("records = %v, want %v", got, want)
+	}
+
+	records, _ := p.getAllRecordsByType("TXT")
+	if len(records) != 1 || records[0].Name != "nas.home.dynv6.net" || records[0].Content != "1700000000" {
+		t.Errorf("listed = %+v", records)
+	}
+
+	// An address that is already published causes no write
+	fake.calls = nil
+	if p.ensureRecordExists("nas.home.dynv6.net", "A", "192.168.1.10", false) != nil {
+		t.Fatal("ensureRecordExists failed")
+	}
+	for _, call := range fake.calls {
+		if !strings.HasPrefix(call, "GET ") {
+			t.Errorf("unchanged record written: %s", call)
+		}
+	}
+
+	if p.deleteRecord("192.168.1.11", "nas.home.dynv6.net", "A") != nil {
+		t.Fatal("deleteRecord failed")
+	}
+	if got := fake.contents(); !reflect.DeepEqual(got, []string{"nas/A/192.168.1.10", "nas/TXT/1700000000"}) {
+		t.Errorf("records after delete = %v", got)
+	}
+	if p.upsertRecord("nas.example.com", "A", "192.0.2.1", false) == nil {
+		t.Error("accepted a name outside the zone")
+	}
+}
+
+func TestDynv6PrefixDelegation(t *testing.T) {
+	fake, p := newFakeDynv6(t, &Config{Dynv6PrefixLength: 56})
+
+	if p.upsertRecord("home.dynv6.net", "AAAA", "2001:db8:1200:5::10", false) != nil {
+		t.Fatal("upsertRecord failed")
+	}
+	if fake.zone.IPv6Prefix != "2001:db8:1200::/56" {
+		t.Errorf("zone prefix = %q, want 2001:db8:1200::/56", fake.zone.IPv6Prefix)
+	}
+
+	// The stored address is recognised in the prefix, so the next run does not write
+	fake.calls = nil
+	if p.upsertRecord("home.dynv6.net", "AAAA", "2001:db8:1200:5::10", false) != nil {
+		t.Fatal("upsertRecord failed")
+	}
+	for _, call := range fake.calls {
+		if !strings.HasPrefix(call, "GET ") {
+			t.Errorf("unchanged prefix written: %s", call)
+		}
+	}
+
+	// A new delegation is published
+	if p.upsertRecord("home.dynv6.net", "AAAA", "2001:db8:3400:5::10", false) != nil || fake.zone.IPv6Prefix != "2001:db8:3400::/56" {
+		t.Errorf("zone prefix = %q after the delegation changed", fake.zone.IPv6Prefix)
+	}
+
+	if _, err := newDynv6Provider(&Config{Dynv6Zone: "home.dynv6.net", Dynv6PrefixLength: 72}); err == nil {
+		t.Error("accepted a prefix length of 72")
+	}
+}
+
+func TestDynv6UpdateURL(t *testing.T) {
+	fake, p := newFakeDynv6(t, &Config{Dynv6UpdateURL: true, Dynv6PrefixLength: 64})
+
+	if p.upsertRecord("home.dynv6.net", "A", "203.0.113.1", false) != nil || p.upsertRecord("home.dynv6.net", "AAAA", "2001:db8:1200:5::10", false) != nil {
+		t.Fatal("upsertRecord failed")
+	}
+	want := []string{"ipv4=203.0.113.1&zone=home.dynv6.net", "ipv6prefix=2001%3Adb8%3A1200%3A5%3A%3A%2F64&zone=home.dynv6.net"}
+	if !reflect.DeepEqual(fake.updates, want) {
+		t.Errorf("updates = %v, want %v", fake.updates, want)
+	}
+
+	// Unchanged addresses are not sent again
+	p.upsertRecord("home.dynv6.net", "A", "203.0.113.1", false)
+	if len(fake.updates) != 2 {
+		t.Errorf("unchanged address sent again: %v", fake.updates)
+	}
+
+	// Only the zone's addresses can be set; heartbeats are skipped
+	if p.upsertRecord("nas.home.dynv6.net", "A", "192.168.1.10", false) == nil {
+		t.Error("set a record below the zone with the update URL")
+	}
+	if p.upsertHeartbeat("home.dynv6.net", `"1700000000"`) != nil {
+		t.Error("upsertHeartbeat failed")
+	}
+	if records, _ := p.getAllRecordsByType("TXT"); len(records) != 0 {
+		t.Errorf("listed = %+v", records)
+	}
+	for _, call := range fake.calls {
+		if call != "GET /api/update" {
+			t.Errorf("REST API used with the update URL: %s", call)
+		}
+	}
+}
+
+func TestDynv6Errors(t *testing.T) {
+	_, p := newFakeDynv6(t, &Config{})
+
+	if !p.probeConnectivity() {
+		t.Error("probe failed")
+	}
+	p.Token = "wrong"
+	if err := p.storeValues("nas.home.dynv6.net", "A", 120, []string{"192.0.2.1"}); err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Errorf("err = %v", err)
+	}
+	p.UpdateURL = true
+	if err := p.storeValues("home.dynv6.net", "A", 120, []string{"192.0.2.1"}); err == nil || !strings.Contains(err.Error(), "invalid authentication token") {
+		t.Errorf("update URL err = %v", err)
+	}
+
+	p.Endpoint = "http://127.0.0.1:1"
+	if err := p.storeValues("home.dynv6.net", "A", 120, []string{"192.0.2.1"}); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("err = %v, want unreachable", err)
+	}
+	if p.probeConnectivity() {
+		t.Error("probe succeeded without a server")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Maximum echo response body size read
+const maxEchoResponseBytes = 64 * 1024
+
+// echoResponseFormat extracts the caller's address from an echo service response
+type echoResponseFormat interface {
+	Extract(resp *http.Response, body []byte) (string, error)
+}
+
+// echoFormats maps a format name to its constructor. A source selects a format with a
+// URL fragment: "https://echo.example.com/ip#json:client.ip". Without a fragment, the
+// body is treated as plain text.
+var echoFormats = map[string]func(arg string) (echoResponseFormat, error){
+	"plain": func(arg string) (echoResponseFormat, error) {
+		return plainEchoFormat{}, nil
+	},
+	"json": func(arg string) (echoResponseFormat, error) {
+		if arg == "" {
+			return nil, fmt.Errorf("json format needs a field path, e.g. #json:ip")
+		}
+		return jsonFieldEchoFormat{path: strings.Split(arg, ".")}, nil
+	},
+	"header": func(arg string) (echoResponseFormat, error) {
+		if arg == "" {
+			return nil, fmt.Errorf("header format needs a header name, e.g. #header:X-Forwarded-For")
+		}
+		return headerEchoFormat{name: arg}, nil
+	},
+	"kv": func(arg string) (echoResponseFormat, error) {
+		if arg == "" {
+			return nil, fmt.Errorf("kv format needs a key, e.g. #kv:ip")
+		}
+		return keyValueEchoFormat{key: arg}, nil
+	},
+}
+
+// plainEchoFormat reads the address from a plain-text body
+type plainEchoFormat struct{}
+
+func (plainEchoFormat) Extract(_ *http.Response, body []byte) (string, error) {
+	return string(body), nil
+}
+
+// jsonFieldEchoFormat reads the address from a (dotted) field of a JSON body.
+// Numeric path elements index into arrays: "clients.0.ip".
+type jsonFieldEchoFormat struct {
+	path []string
+}
+
+func (f jsonFieldEchoFormat) Extract(_ *http.Response, body []byte) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", fmt.Errorf("invalid JSON response: %w", err)
+	}
+	for _, key := range f.path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("no element %q in JSON response", strings.Join(f.path, "."))
+			}
+			value = v[i]
+		default:
+			value = nil
+		}
+		if value == nil {
+			return "", fmt.Errorf("no field %q in JSON response", strings.Join(f.path, "."))
+		}
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q is not a string", strings.Join(f.path, "."))
+	}
+	return s, nil
+}
+
+// headerEchoFormat reads the address from a response header, e.g. a self-hosted
+// endpoint behind a reverse proxy that echoes X-Forwarded-For
+type headerEchoFormat struct {
+	name string
+}
+
+func (f headerEchoFormat) Extract(resp *http.Response, _ []byte) (string, error) {
+	value := resp.Header.Get(f.name)
+	if value == "" {
+		return "", fmt.Errorf("no %s header in response", f.name)
+	}
+	return value, nil
+}
+
+// keyValueEchoFormat reads the address from a "key=value" line of the body, as served by
+// CloudFlare's /cdn-cgi/trace
+type keyValueEchoFormat struct {
+	key string
+}
+
+func (f keyValueEchoFormat) Extract(_ *http.Response, body []byte) (string, error) {
+	for _, line := range strings.Split(string(body), "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok && key == f.key {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("no %s= line in response", f.key)
+}
+
+// parseEchoSource splits a source into the URL to query and its response format
+func parseEchoSource(source string) (string, echoResponseFormat, error) {
+	url, fragment, hasFormat := strings.Cut(source, "#")
+	if !hasFormat {
+		return url, plainEchoFormat{}, nil
+	}
+
+	name, arg, _ := strings.Cut(fragment, ":")
+	constructor, ok := echoFormats[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(echoFormats))
+		for n := range echoFormats {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", nil, fmt.Errorf("unknown response format %q in %s (available: %s)", name, source, strings.Join(names, ", "))
+	}
+	format, err := constructor(arg)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return url, format, nil
+}
+
+// clientAddress normalises an extracted value to a single address. Forwarding headers list
+// the original client first ("client, proxy1, proxy2"); ports and IPv6 brackets are stripped.
+func clientAddress(value string) string {
+	value, _, _ = strings.Cut(value, ",")
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") {
+		if end := strings.Index(value, "]"); end > 0 {
+			return value[1:end]
+		}
+	}
+	if host, _, ok := strings.Cut(value, ":"); ok && strings.Count(value, ":") == 1 {
+		return host // IPv4 with port
+	}
+	return value
+}
+
+// validateEchoSources warns about sources with an invalid response format
+func validateEchoSources(sources []string) {
+	for _, source := range sources {
+		if isLocalSource(source) || source == cloudflareTraceSource {
+			continue
+		}
+		if _, _, err := parseEchoSource(source); err != nil {
+			log.Printf("WARNING: Invalid detection source: %v", err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryEchoServiceFormats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/plain":
+			fmt.Fprintln(w, "203.0.113.7")
+		case "/json":
+			fmt.Fprint(w, `{"client": {"ip": "203.0.113.8", "port": 4242}, "hops": [{"ip": "198.51.100.1"}]}`)
+		case "/cdn-cgi/trace":
+			fmt.Fprint(w, "fl=123f45\nh=1.1.1.1\nip=203.0.113.10\nts=1700000000.123\nvisit_scheme=https\n")
+		case "/header":
+			w.Header().Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		source, want string
+	}{
+		{server.URL + "/plain", "203.0.113.7"},
+		{server.URL + "/plain#plain", "203.0.113.7"},
+		{server.URL + "/json#json:client.ip", "203.0.113.8"},
+		{server.URL + "/json#json:hops.0.ip", "198.51.100.1"},
+		{server.URL + "/header#header:X-Forwarded-For", "203.0.113.9"},
+		{server.URL + "/cdn-cgi/trace#kv:ip", "203.0.113.10"},
+	}
+	for _, tt := range tests {
+		got, err := queryEchoService(server.Client(), tt.source)
+		if err != nil {
+			t.Errorf("%s: %v", tt.source, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+
+	for _, source := range []string{
+		server.URL + "/json#json:client.port",
+		server.URL + "/json#json:missing",
+		server.URL + "/plain#header:X-Real-IP",
+		server.URL + "/plain#xml:ip",
+		server.URL + "/plain#kv:ip",
+		server.URL + "/cdn-cgi/trace#kv",
+	} {
+		if _, err := queryEchoService(server.Client(), source); err == nil {
+			t.Errorf("%s: expected an error", source)
+		}
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.1":              "203.0.113.1",
+		" 203.0.113.1 , 10.0.0.1":  "203.0.113.1",
+		"203.0.113.1:8443":         "203.0.113.1",
+		"[2001:db8::1]:8443":       "2001:db8::1",
+		"2001:db8::1":              "2001:db8::1",
+		"2001:db8::1, 2001:db8::2": "2001:db8::1",
+	}
+	for in, want := range tests {
+		if got := clientAddress(in); got != want {
+			t.Errorf("clientAddress(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// etcdLeafPrefix starts the key of every value written by the etcd provider
+const etcdLeafPrefix = "dynipupdate-"
+
+// EtcdProvider writes the records into etcd in the format of CoreDNS's etcd plugin
+// (originally SkyDNS), so CoreDNS - e.g. in a Kubernetes cluster - serves the detected
+// addresses without a public DNS provider. A name is stored below the path prefix with its
+// labels reversed, one key per value:
+//
+//	/skydns/com/example/nas/dynipupdate-a-3f2b...  {"host":"192.168.1.10","ttl":300}
+//	/skydns/com/example/www/dynipupdate-cname-...  {"host":"nas.example.com","ttl":300}
+//
+// CoreDNS derives the record type from the value: addresses are A/AAAA records, other hosts
+// CNAMEs and text TXT records. etcd is accessed through its v3 JSON gateway (/v3/kv/...).
+type EtcdProvider struct {
+	Endpoint string // etcd client URL, e.g. http://127.0.0.1:2379
+	Prefix   string // Path prefix of the CoreDNS etcd plugin, e.g. /skydns
+	Username string // etcd user; empty when authentication is disabled
+	Password string
+
+	client  *http.Client
+	tokenMu sync.Mutex
+	token   string // Auth token from /v3/auth/authenticate
+
+	recordSetClient
+}
+
+// Verify EtcdProvider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*EtcdProvider)(nil)
+var _ DNSProvider = (*EtcdProvider)(nil)
+var _ providerClient = (*EtcdProvider)(nil)
+
+// etcdService is the value of a key, as read by CoreDNS (msg.Service)
+type etcdService struct {
+	Host string `json:"host,omitempty"`
+	Text string `json:"text,omitempty"`
+	Mail bool   `json:"mail,omitempty"`
+	TTL  int    `json:"ttl,omitempty"`
+}
+
+// etcd v3 JSON gateway structures (keys and values are base64)
+type etcdKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+// etcdEntry is a decoded key of a name
+type etcdEntry struct {
+	Key     string
+	Type    string
+	Value   string
+	Service etcdService
+}
+
+// newEtcdProvider creates an etcd provider
+func newEtcdProvider(config *Config) (*EtcdProvider, error) {
+	prefix := "/" + strings.Trim(config.EtcdPrefix, "/")
+	if prefix == "/" {
+		return nil, fmt.Errorf("%sETCD_PREFIX must not be empty", envPrefix)
+	}
+	stack := newProviderStack(config, providerEtcd)
+	stack.limiter = nil // A key-value store, without the API limits of a DNS provider
+
+	p := &EtcdProvider{
+		Endpoint: strings.TrimSuffix(config.EtcdEndpoint, "/"),
+		Prefix:   prefix,
+		Username: config.EtcdUsername,
+		Password: config.EtcdPassword,
+		client:   stack.client(),
+	}
+	p.recordSetClient = newRecordSetClient(p, config)
+	log.Printf("Writing records to etcd at %s below %s", p.Endpoint, p.Prefix)
+
+	warnUnsupportedOptions(config, "etcd")
+	if config.Changelog {
+		log.Printf("WARNING: %sCHANGELOG has no effect with etcd - CoreDNS serves a single string per TXT record", envPrefix)
+		config.Changelog = false
+	}
+	return p, nil
+}
+
+// namePath returns the key below which the values of a name are stored
+func (p *EtcdProvider) namePath(name string) string {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	slices.Reverse(labels)
+	return p.Prefix + "/" + strings.Join(labels, "/")
+}
+
+// pathName returns the name of a key, ignoring a leaf written by this provider
+func (p *EtcdProvider) pathName(key string) string {
+	labels := strings.Split(strings.TrimPrefix(key, p.Prefix+"/"), "/")
+	if strings.HasPrefix(labels[len(labels)-1], etcdLeafPrefix) {
+		labels = labels[:len(labels)-1]
+	}
+	slices.Reverse(labels)
+	return strings.Join(labels, ".")
+}
+
+// leafKey returns the key of one value of a name
+func (p *EtcdProvider) leafKey(name, recordType, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return fmt.Sprintf("%s/%s%s-%s", p.namePath(name), etcdLeafPrefix, strings.ToLower(recordType), hex.EncodeToString(sum[:8]))
+}
+
+// etcdServiceType returns the record type CoreDNS serves for a value ("" for types not managed here)
+func etcdServiceType(s etcdService) string {
+	switch {
+	case s.Mail:
+		return ""
+	case s.Text != "" && s.Host == "":
+		return "TXT"
+	case s.Host == "":
+		return ""
+	}
+	if addr, err := parseAddr(s.Host); err == nil {
+		if addr.Is4() {
+			return "A"
+		}
+		return "AAAA"
+	}
+	return "CNAME"
+}
+
+// etcdServiceValue returns the record content of a value
+func etcdServiceValue(s etcdService) string {
+	if s.Host == "" {
+		return s.Text
+	}
+	return strings.TrimSuffix(s.Host, ".")
+}
+
+// newEtcdService returns the value stored for a record content
+func newEtcdService(recordType, value string, ttl int) etcdService {
+	if recordType == "TXT" {
+		return etcdService{Text: strings.Join(parseTXTStrings(value), ""), TTL: ttl}
+	}
+	return etcdService{Host: value, TTL: ttl}
+}
+
+// authenticate fetches an auth token for the configured user
+func (p *EtcdProvider) authenticate() error {
+	var result struct {
+		Token string `json:"token"`
+	}
+	body := map[string]string{"name": p.Username, "password": p.Password}
+	if err := p.call("/v3/auth/authenticate", body, &result, false); err != nil {
+		if errors.Is(err, errProviderUnreachable) {
+			return fmt.Errorf("authenticating as %s: %w", p.Username, err)
+		}
+		return fmt.Errorf("%w: authenticating as %s: %v", errProviderAuth, p.Username, err)
+	}
+	p.tokenMu.Lock()
+	p.token = result.Token
+	p.tokenMu.Unlock()
+	return nil
+}
+
+// request performs a gateway request, authenticating first (and again once the token expired)
+func (p *EtcdProvider) request(path string, body, result interface{}) error {
+	if p.Username == "" {
+		return p.call(path, body, result, false)
+	}
+	p.tokenMu.Lock()
+	haveToken := p.token != ""
+	p.tokenMu.Unlock()
+	if !haveToken {
+		if err := p.authenticate(); err != nil {
+			return err
+		}
+	}
+	err := p.call(path, body, result, true)
+	if !errors.Is(err, errEtcdUnauthenticated) {
+		return err
+	}
+	if err := p.authenticate(); err != nil {
+		return err
+	}
+	return p.call(path, body, result, true)
+}
+
+// errEtcdUnauthenticated is returned for requests with a missing or expired token
+var errEtcdUnauthenticated = errors.New("etcd: unauthenticated")
+
+// call POSTs one gateway request and decodes the response into result
+func (p *EtcdProvider) call(path string, body, result interface{}, authenticated bool) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", p.Endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authenticated {
+		p.tokenMu.Lock()
+		req.Header.Set("Authorization", p.token)
+		p.tokenMu.Unlock()
+	}
+
+	log.Printf("API Request: POST %s", path)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respData, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		var errResp struct {
+			Message string `json:"message"`
+			Code    int    `json:"code"`
+		}
+		json.Unmarshal(respData, &errResp)
+		// gRPC code 16: UNAUTHENTICATED (the gateway answers 401)
+		if authenticated && (resp.StatusCode == http.StatusUnauthorized || errResp.Code == 16) {
+			return errEtcdUnauthenticated
+		}
+		return statusError(resp.StatusCode, errResp.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// rangePrefix returns all keys starting with prefix
+func (p *EtcdProvider) rangePrefix(prefix string) ([]etcdKeyValue, error) {
+	// The range end is the prefix with its last byte incremented
+	end := []byte(prefix)
+	end[len(end)-1]++
+	body := map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(end),
+	}
+	var result etcdRangeResponse
+	if err := p.request("/v3/kv/range", body, &result); err != nil {
+		return nil, err
+	}
+
+	kvs := make([]etcdKeyValue, 0, len(result.Kvs))
+	for _, kv := range result.Kvs {
+		key, errKey := base64.StdEncoding.DecodeString(kv.Key)
+		value, errValue := base64.StdEncoding.DecodeString(kv.Value)
+		if errKey != nil || errValue != nil {
+			return nil, fmt.Errorf("decoding key %q: invalid base64", kv.Key)
+		}
+		kvs = append(kvs, etcdKeyValue{Key: string(key), Value: string(value)})
+	}
+	return kvs, nil
+}
+
+// entries returns the values of a name: the key of the name itself and the keys directly
+// below it. Keys further down belong to names below this one.
+func (p *EtcdProvider) entries(name, recordType string) ([]etcdEntry, error) {
+	path := p.namePath(name)
+	kvs, err := p.rangePrefix(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []etcdEntry
+	for _, kv := range kvs {
+		if kv.Key != path && (!strings.HasPrefix(kv.Key, path+"/") || strings.Contains(kv.Key[len(path)+1:], "/")) {
+			continue
+		}
+		var s etcdService
+		if json.Unmarshal([]byte(kv.Value), &s) != nil || etcdServiceType(s) != recordType {
+			continue
+		}
+		entries = append(entries, etcdEntry{Key: kv.Key, Type: recordType, Value: etcdServiceValue(s), Service: s})
+	}
+	return entries, nil
+}
+
+// recordSetBackend implementation
+
+func (p *EtcdProvider) fetchValues(name, recordType string) ([]string, error) {
+	entries, err := p.entries(name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, e := range entries {
+		values = append(values, e.Value)
+	}
+	return values, nil
+}
+
+func (p *EtcdProvider) storeValues(name, recordType string, ttl int, values []string) error {
+	entries, err := p.entries(name, recordType)
+	if err != nil {
+		return err
+	}
+
+	// Keep values that are already stored with the TTL, delete the others
+	stored := make([]bool, len(values))
+	for _, e := range entries {
+		keep := false
+		for i, v := range values {
+			if !stored[i] && sameContent(e.Value, etcdServiceValue(newEtcdService(recordType, v, ttl))) && e.Service.TTL == ttl {
+				stored[i], keep = true, true
+				break
+			}
+		}
+		if keep {
+			continue
+		}
+		body := map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(e.Key))}
+		if err := p.request("/v3/kv/deleterange", body, nil); err != nil {
+			return err
+		}
+	}
+
+	for i, v := range values {
+		if stored[i] {
+			continue
+		}
+		data, err := json.Marshal(newEtcdService(recordType, v, ttl))
+		if err != nil {
+			return err
+		}
+		body := map[string]string{
+			"key":   base64.StdEncoding.EncodeToString([]byte(p.leafKey(name, recordType, v))),
+			"value": base64.StdEncoding.EncodeToString(data),
+		}
+		if err := p.request("/v3/kv/put", body, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *EtcdProvider) listRecords(recordType string) ([]CFRecord, error) {
+	kvs, err := p.rangePrefix(p.Prefix + "/")
+	if err != nil {
+		return []CFRecord{}, err
+	}
+	records := []CFRecord{}
+	for _, kv := range kvs {
+		var s etcdService
+		if json.Unmarshal([]byte(kv.Value), &s) != nil || etcdServiceType(s) != recordType {
+			continue
+		}
+		records = append(records, valueRecords(p.pathName(kv.Key), recordType, []string{etcdServiceValue(s)})...)
+	}
+	return records, nil
+}
+
+func (p *EtcdProvider) probe() bool {
+	err := p.request("/v3/maint/status", map[string]string{}, nil)
+	return !errors.Is(err, errProviderUnreachable)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeEtcd is an in-memory etcd v3 JSON gateway with optional authentication
+type fakeEtcd struct {
+	mu       sync.Mutex
+	kv       map[string]string
+	password string // Required password of user "dns"; empty disables authentication
+	tokens   int    // Number of tokens issued
+	token    string // Currently valid token
+}
+
+func newFakeEtcd(t *testing.T, password string) (*fakeEtcd, *EtcdProvider) {
+	t.Helper()
+	fake := &fakeEtcd{kv: make(map[string]string), password: password}
+	server := httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(server.Close)
+
+	config := &Config{EtcdEndpoint: server.URL + "/", EtcdPrefix: "/skydns/", RecordTTL: 120}
+	if password != "" {
+		config.EtcdUsername, config.EtcdPassword = "dns", password
+	}
+	p, err := newEtcdProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, p
+}
+
+func (f *fakeEtcd) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var req map[string]string
+	json.NewDecoder(r.Body).Decode(&req)
+	decode := func(field string) string {
+		b, _ := base64.StdEncoding.DecodeString(req[field])
+		return string(b)
+	}
+
+	if r.URL.Path == "/v3/auth/authenticate" {
+		if req["name"] != "dns" || req["password"] != f.password {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": 3, "message": "etcdserver: authentication failed, invalid user ID or password"})
+			return
+		}
+		f.tokens++
+		f.token = "token" + strings.Repeat("x", f.tokens)
+		json.NewEncoder(w).Encode(map[string]string{"token": f.token})
+		return
+	}
+	if f.password != "" && r.Header.Get("Authorization") != f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": 16, "message": "etcdserver: invalid auth token"})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		start, end := decode("key"), decode("range_end")
+		var keys []string
+		for k := range f.kv {
+			if k >= start && k < end {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		var kvs []etcdKeyValue
+		for _, k := range keys {
+			kvs = append(kvs, etcdKeyValue{Key: base64.StdEncoding.EncodeToString([]byte(k)), Value: base64.StdEncoding.EncodeToString([]byte(f.kv[k]))})
+		}
+		json.NewEncoder(w).Encode(etcdRangeResponse{Kvs: kvs})
+	case "/v3/kv/put":
+		f.kv[decode("key")] = decode("value")
+		w.Write([]byte("{}"))
+	case "/v3/kv/deleterange":
+		delete(f.kv, decode("key"))
+		w.Write([]byte("{}"))
+	case "/v3/maint/status":
+		w.Write([]byte(`{"version":"3.5.9"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// values returns the decoded values below a key prefix, sorted
+func (f *fakeEtcd) values(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var values []string
+	for k, v := range f.kv {
+		if strings.HasPrefix(k, prefix) {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+func TestEtcdProviderCoreDNSFormat(t *testing.T) {
+	fake, p := newFakeEtcd(t, "")
+
+	if p.upsertRecord("NAS.example.com", "A", "192.168.1.10", false) != nil ||
+		p.ensureRecordExists("nas.example.com", "A", "192.168.1.11", false) != nil ||
+		p.upsertRecord("nas.example.com", "AAAA", "2001:db8::10", false) != nil ||
+		p.upsertRecord("www.example.com", "CNAME", "nas.example.com", false) != nil ||
+		p.upsertHeartbeat("hb.nas.example.com", "1700000000") != nil {
+		t.Fatal("record operation failed")
+	}
+
+	want := []string{
+		`{"host":"192.168.1.10","ttl":120}`,
+		`{"host":"192.168.1.11","ttl":120}`,
+		`{"host":"2001:db8::10","ttl":120}`,
+	}
+	// hb.nas.example.com is below nas.example.com, so compare the direct children only
+	if got := fake.values("/skydns/com/example/nas/dynipupdate-"); !reflect.DeepEqual(got, want) {
+		t.Errorf("nas.example.com values = %v, want %v", got, want)
+	}
+	if got := fake.values("/skydns/com/example/www/dynipupdate-cname-"); !reflect.DeepEqual(got, []string{`{"host":"nas.example.com","ttl":120}`}) {
+		t.Errorf("www.example.com values = %v", got)
+	}
+
+	// Values are read per type, ignoring names below
+	if values, err := p.fetchValues("nas.example.com", "A"); err != nil || !reflect.DeepEqual(values, []string{"192.168.1.10", "192.168.1.11"}) {
+		t.Errorf("fetchValues(A) = %v, %v", values, err)
+	}
+	if values, _ := p.fetchValues("nas.example.com", "TXT"); len(values) != 0 {
+		t.Errorf("fetchValues(TXT) = %v", values)
+	}
+	if r, _ := p.getRecord("hb.nas.example.com", "TXT"); r == nil || r.Content != "1700000000" {
+		t.Errorf("heartbeat = %+v", r)
+	}
+
+	if p.deleteRecord("192.168.1.11", "nas.example.com", "A") != nil || p.upsertRecord("nas.example.com", "A", "192.168.1.20", false) != nil ||
+		p.deleteRecordIfExists("nas.example.com", "AAAA") != nil {
+		t.Fatal("update or delete failed")
+	}
+	list, err := p.listRecords("A")
+	if err != nil || len(list) != 1 || list[0].Name != "nas.example.com" || list[0].Content != "192.168.1.20" {
+		t.Errorf("listRecords(A) = %+v, %v", list, err)
+	}
+	list, err = p.listRecords("TXT")
+	if err != nil || len(list) != 1 || list[0].Name != "hb.nas.example.com" {
+		t.Errorf("listRecords(TXT) = %+v, %v", list, err)
+	}
+	if !p.probe() {
+		t.Error("probe failed")
+	}
+}
+
+func TestEtcdProviderReplacesForeignValues(t *testing.T) {
+	fake, p := newFakeEtcd(t, "")
+	fake.kv["/skydns/com/example/nas"] = `{"host":"10.0.0.1"}`
+	fake.kv["/skydns/com/example/nas/x1"] = `{"host":"mail.example.com","mail":true}`
+
+	if values, _ := p.fetchValues("nas.example.com", "A"); !reflect.DeepEqual(values, []string{"10.0.0.1"}) {
+		t.Errorf("fetchValues = %v", values)
+	}
+	if p.upsertRecord("nas.example.com", "A", "10.0.0.2", false) != nil {
+		t.Fatal("upsert failed")
+	}
+	if _, ok := fake.kv["/skydns/com/example/nas"]; ok {
+		t.Error("replaced value not deleted")
+	}
+	if _, ok := fake.kv["/skydns/com/example/nas/x1"]; !ok {
+		t.Error("MX value deleted")
+	}
+}
+
+func TestEtcdProviderAuthentication(t *testing.T) {
+	fake, p := newFakeEtcd(t, "secret")
+
+	if p.upsertRecord("nas.example.com", "A", "192.168.1.10", false) != nil {
+		t.Fatal("upsert failed")
+	}
+	if fake.tokens != 1 {
+		t.Errorf("tokens issued = %d, want 1", fake.tokens)
+	}
+
+	// An expired token is renewed once
+	fake.token = "expired"
+	if values, err := p.fetchValues("nas.example.com", "A"); err != nil || len(values) != 1 {
+		t.Errorf("fetchValues after expiry = %v, %v", values, err)
+	}
+	if fake.tokens != 2 {
+		t.Errorf("tokens issued = %d, want 2", fake.tokens)
+	}
+
+	p.Password = "wrong"
+	fake.token = "expired"
+	if _, err := p.fetchValues("nas.example.com", "A"); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("fetchValues with a wrong password = %v", err)
+	}
+}
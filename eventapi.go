@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The event API lets integrators follow detection and reconcile events in real time and
+// request an immediate update. It is served by "dynipupdate serve" as the gRPC Events
+// service of api/events.proto (see eventgrpc.go) and, for curl and scripts, as
+// newline-delimited JSON over HTTP(S):
+//
+//	GET  /v1/events         stream of apiEvent documents, one per line
+//	POST /v1/trigger-update request an update run now (202 Accepted)
+//
+// Every request must carry "Authorization: Bearer <token>" with one of the configured tokens.
+
+// Event types sent on the event stream
+const (
+	eventDetected        = "ip.detected"
+	eventRecordChanged   = "record.changed"
+	eventUpdateCompleted = "update.completed"
+)
+
+// apiEvent is one event on the stream. Only the fields relevant to the type are set.
+type apiEvent struct {
+	Type      string       `json:"type"`
+	Timestamp int64        `json:"timestamp"`
+	IPs       *IPAddresses `json:"ips,omitempty"`    // ip.detected
+	Record    *eventRecord `json:"record,omitempty"` // record.changed
+	Succeeded *int         `json:"succeeded,omitempty"`
+	Attempted *int         `json:"attempted,omitempty"`
+}
+
+// eventRecord describes a record create/update/delete
+type eventRecord struct {
+	Action  string `json:"action"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content,omitempty"`
+}
+
+// eventHub fans events out to all connected subscribers
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan apiEvent]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan apiEvent]struct{})}
+}
+
+func (h *eventHub) subscribe() chan apiEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan apiEvent, 64)
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan apiEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, ch)
+}
+
+// publish sends an event to every subscriber; events are dropped for subscribers that fall behind
+func (h *eventHub) publish(event apiEvent) {
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Println("WARNING: Event API subscriber is too slow - event dropped")
+		}
+	}
+}
+
+// eventAPI serves the event stream and update trigger
+type eventAPI struct {
+	hub     *eventHub
+	tokens  []string
+	trigger chan struct{} // Buffered (1); a pending trigger absorbs further requests
+}
+
+// authorized checks the bearer token in constant time
+func (a *eventAPI) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// requestUpdate asks for an update run; a pending request absorbs it
+func (a *eventAPI) requestUpdate() {
+	select {
+	case a.trigger <- struct{}{}:
+	default: // An update is already pending
+	}
+}
+
+func (a *eventAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isGRPCRequest(r) {
+		a.serveGRPC(w, r)
+		return
+	}
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dynipupdate"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/events":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.streamEvents(w, r)
+	case "/v1/trigger-update":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.requestUpdate()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// streamEvents writes events as newline-delimited JSON until the client disconnects
+func (a *eventAPI) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := a.hub.subscribe()
+	defer a.hub.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// startEventAPI starts the event API server in the background
+func startEventAPI(config *Config, api *eventAPI) error {
+	if len(api.tokens) == 0 {
+		return fmt.Errorf("%sEVENTS_TOKENS must list at least one token to enable the event API", envPrefix)
+	}
+	if (config.EventsTLSCert == "") != (config.EventsTLSKey == "") {
+		return fmt.Errorf("%sEVENTS_TLS_CERT and %sEVENTS_TLS_KEY must be set together", envPrefix, envPrefix)
+	}
+
+	// gRPC clients speak HTTP/2, negotiated over TLS and sent directly (h2c) without it
+	server := &http.Server{Addr: config.EventsListen, Handler: api, ReadHeaderTimeout: 10 * time.Second, Protocols: new(http.Protocols)}
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+	go func() {
+		var err error
+		if config.EventsTLSCert != "" {
+			log.Printf("Event API listening on https://%s", config.EventsListen)
+			err = server.ListenAndServeTLS(config.EventsTLSCert, config.EventsTLSKey)
+		} else {
+			log.Printf("WARNING: Event API listening on http://%s without TLS - tokens are sent in clear text", config.EventsListen)
+			err = server.ListenAndServe()
+		}
+		log.Fatalf("ERROR: Event API stopped: %v", err)
+	}()
+	return nil
+}
+
+// runServe implements the "serve" subcommand: update periodically (or when triggered)
+// and publish events through the event API
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	interval := fs.Int("interval", 300, "Seconds between update runs")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -interval must be positive")
+		return 2
+	}
+
+	config, cf := bootstrap(false, func(config *Config) error {
+		setupLogging(config.LogFormat, config.LogLevel, config.Provider)
+		return nil
+	})
+	// Like -daemon, leave alone the domains holding records that dynipupdate did not create
+	if !handleUnmanagedRecords(cf, config, unmanagedWarn) {
+		return 1
+	}
+
+	api := &eventAPI{hub: newEventHub(), tokens: config.EventsTokens, trigger: make(chan struct{}, 1)}
+	if config.EventsListen != "" {
+		if err := startEventAPI(config, api); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 2
+		}
+	}
+
+	metrics := &metricsEndpoint{}
+	metrics.start(config)
+	health := newHealthEndpoint(config, time.Duration(*interval)*time.Second)
+	health.start(config)
+	startFastStart(cf, config)
+
+	s := newScheduler()
+	config.verifications = newVerifyQueue(s)
+	s.add("update", newUpdateJob(cf, config, time.Duration(*interval)*time.Second, api.hub, metrics, health))
+	go func() {
+		for range api.trigger {
+			log.Println("Update triggered through the event API")
+			s.requestRun("update")
+		}
+	}()
+	s.loop(nil)
+	return 0
+}
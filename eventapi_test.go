@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestEventAPI(t *testing.T) (*eventAPI, *httptest.Server) {
+	t.Helper()
+	api := &eventAPI{hub: newEventHub(), tokens: []string{"old-token", "new-token"}, trigger: make(chan struct{}, 1)}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func eventAPIRequest(t *testing.T, method, url, token string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestEventAPIAuth(t *testing.T) {
+	_, server := newTestEventAPI(t)
+
+	for _, token := range []string{"", "wrong"} {
+		resp := eventAPIRequest(t, "POST", server.URL+"/v1/trigger-update", token)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("token %q: status %d, want 401", token, resp.StatusCode)
+		}
+	}
+
+	// Any configured token is accepted (allows rotation)
+	resp := eventAPIRequest(t, "POST", server.URL+"/v1/trigger-update", "old-token")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status %d, want 202", resp.StatusCode)
+	}
+}
+
+func TestEventAPITriggerUpdate(t *testing.T) {
+	api, server := newTestEventAPI(t)
+
+	// Repeated triggers coalesce into one pending update
+	for i := 0; i < 3; i++ {
+		resp := eventAPIRequest(t, "POST", server.URL+"/v1/trigger-update", "new-token")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("status %d", resp.StatusCode)
+		}
+	}
+	if len(api.trigger) != 1 {
+		t.Errorf("pending triggers = %d, want 1", len(api.trigger))
+	}
+
+	resp := eventAPIRequest(t, "GET", server.URL+"/v1/trigger-update", "new-token")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET trigger status %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestEventAPIStream(t *testing.T) {
+	api, server := newTestEventAPI(t)
+
+	resp := eventAPIRequest(t, "GET", server.URL+"/v1/events", "new-token")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// The response headers are only sent once subscribed, so the event is not lost
+	api.hub.publish(apiEvent{Type: eventRecordChanged, Record: &eventRecord{Action: "update", Type: "A", Name: "home.example.com", Content: "203.0.113.1"}})
+	succeeded, attempted := 2, 2
+	api.hub.publish(apiEvent{Type: eventUpdateCompleted, Succeeded: &succeeded, Attempted: &attempted})
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	var events []apiEvent
+	for len(events) < 2 {
+		select {
+		case line := <-lines:
+			var event apiEvent
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatalf("bad event line %q: %v", line, err)
+			}
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+	}
+
+	if events[0].Type != eventRecordChanged || events[0].Record.Name != "home.example.com" || events[0].Timestamp == 0 {
+		t.Errorf("first event = %+v", events[0])
+	}
+	if events[1].Type != eventUpdateCompleted || *events[1].Succeeded != 2 {
+		t.Errorf("second event = %+v", events[1])
+	}
+}
+
+func TestStartEventAPIValidation(t *testing.T) {
+	api := &eventAPI{hub: newEventHub(), trigger: make(chan struct{}, 1)}
+	if err := startEventAPI(&Config{EventsListen: "127.0.0.1:0"}, api); err == nil {
+		t.Error("event API started without tokens")
+	}
+
+	api.tokens = []string{"token"}
+	if err := startEventAPI(&Config{EventsListen: "127.0.0.1:0", EventsTLSCert: "cert.pem"}, api); err == nil {
+		t.Error("event API started with a certificate but no key")
+	}
+}
+
+func TestReconcileRecordsKeepsCallerHook(t *testing.T) {
+	_, cf := newFakeCloudFlare(t)
+	var published []string
+	cf.OnChange = func(action, recordType, name, content string) {
+		published = append(published, action+" "+recordType+" "+name)
+	}
+
+	config := &Config{ExternalDomain: "home.example.com"}
+	reconcileRecords(cf, config, &IPAddresses{ExternalIPv4: "203.0.113.1"}, time.Now())
+
+	if len(published) == 0 || published[0] != "create A home.example.com" {
+		t.Errorf("caller hook saw %v", published)
+	}
+	if cf.OnChange == nil {
+		t.Error("caller hook not restored after reconcile")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gRPC event API. Next to the JSON endpoints, "dynipupdate serve" answers the Events
+// service of api/events.proto on the same listener: Subscribe streams the events of the
+// hub as Event messages, TriggerUpdate requests an update run like POST /v1/trigger-update.
+// The bearer token travels as "authorization" metadata, which is the HTTP header the JSON
+// endpoints read. Like the plugin client (grpcplugin.go), the server is plain net/http
+// HTTP/2 with the messages encoded by protowire.go: over TLS with EVENTS_TLS_CERT and
+// _KEY, and as h2c otherwise.
+
+// eventsServicePath is the path prefix of the Events methods
+const eventsServicePath = "/dynipupdate.events.v1.Events/"
+
+// isGRPCRequest reports whether a request is a gRPC call
+func isGRPCRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcFail ends a call with a status and no messages (a trailers-only response)
+func grpcFail(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(message))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveGRPC answers a call to the Events service
+func (a *eventAPI) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.Method != http.MethodPost {
+		http.Error(w, "gRPC requires HTTP/2 POST requests", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if !a.authorized(r) {
+		grpcFail(w, grpcUnauthenticated, "a bearer token of "+envPrefix+"EVENTS_TOKENS is required")
+		return
+	}
+	// Both requests are empty messages; newer clients may send fields this version ignores
+	if _, err := readGRPCMessage(r.Body); err != nil && err != io.EOF {
+		grpcFail(w, grpcInvalidArgument, err.Error())
+		return
+	}
+
+	method, _ := strings.CutPrefix(r.URL.Path, eventsServicePath)
+	switch method {
+	case "Subscribe":
+		a.subscribeGRPC(w, r)
+	case "TriggerUpdate":
+		a.requestUpdate()
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(grpcFrame(nil)) // TriggerUpdateResponse has no fields
+		w.Header().Set("Grpc-Status", strconv.Itoa(grpcOK))
+	default:
+		grpcFail(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+	}
+}
+
+// subscribeGRPC streams events as Event messages until the client cancels the call
+func (a *eventAPI) subscribeGRPC(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		grpcFail(w, grpcUnimplemented, "streaming not supported")
+		return
+	}
+
+	ch := a.hub.subscribe()
+	defer a.hub.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			if _, err := w.Write(grpcFrame(encodeEventMessage(event))); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// encodeEventMessage encodes an event as an Event message
+func encodeEventMessage(event apiEvent) []byte {
+	var b []byte
+	b = protoAppendString(b, 1, event.Type)
+	b = protoAppendInt64(b, 2, event.Timestamp)
+	if event.IPs != nil {
+		b = protoAppendMessage(b, 3, encodeAddressesMessage(event.IPs))
+	}
+	if r := event.Record; r != nil {
+		var record []byte
+		record = protoAppendString(record, 1, r.Action)
+		record = protoAppendString(record, 2, r.Type)
+		record = protoAppendString(record, 3, r.Name)
+		record = protoAppendString(record, 4, r.Content)
+		b = protoAppendMessage(b, 4, record)
+	}
+	if event.Succeeded != nil {
+		b = protoAppendInt(b, 5, *event.Succeeded)
+	}
+	if event.Attempted != nil {
+		b = protoAppendInt(b, 6, *event.Attempted)
+	}
+	return b
+}
+
+// encodeAddressesMessage encodes detected addresses as an Addresses message; map entries
+// are sorted by key, so equal events encode alike
+func encodeAddressesMessage(ips *IPAddresses) []byte {
+	var b []byte
+	for _, ip := range ips.InternalIPv4 {
+		b = protoAppendMessage(b, 1, []byte(ip)) // A repeated string is encoded like a message
+	}
+	b = protoAppendString(b, 2, ips.ExternalIPv4)
+	b = protoAppendString(b, 3, ips.ExternalIPv6)
+	for _, domain := range sortedKeys(ips.CustomRangeIPs) {
+		var list []byte
+		for _, ip := range ips.CustomRangeIPs[domain] {
+			list = protoAppendMessage(list, 1, []byte(ip))
+		}
+		b = protoAppendMessage(b, 4, protoAppendMessage(protoAppendString(nil, 1, domain), 2, list))
+	}
+	for _, address := range sortedKeys(ips.Interfaces) {
+		b = protoAppendMessage(b, 5, protoAppendString(protoAppendString(nil, 1, address), 2, ips.Interfaces[address]))
+	}
+	return b
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
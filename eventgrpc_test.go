@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestEventGRPC serves the event API over h2c and returns a gRPC-capable client
+func newTestEventGRPC(t *testing.T) (*eventAPI, *httptest.Server, *http.Client) {
+	t.Helper()
+	api := &eventAPI{hub: newEventHub(), tokens: []string{"token"}, trigger: make(chan struct{}, 1)}
+	server := httptest.NewUnstartedServer(api)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	t.Cleanup(transport.CloseIdleConnections)
+	return api, server, &http.Client{Transport: transport}
+}
+
+// eventGRPCCall starts a call and returns the response, its body still to be read
+func eventGRPCCall(t *testing.T, client *http.Client, url, method, token string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("POST", url+eventsServicePath+method, bytes.NewReader(grpcFrame(nil)))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestEventMessageEncoding(t *testing.T) {
+	// As protoc-generated code encodes Addresses{internal_ipv4: ["a"], custom_range_ips: {"d": {addresses: ["b"]}}}
+	encoded := encodeAddressesMessage(&IPAddresses{InternalIPv4: []string{"a"}, CustomRangeIPs: map[string][]string{"d": {"b"}}})
+	if want := []byte{0x0a, 0x01, 'a', 0x22, 0x08, 0x0a, 0x01, 'd', 0x12, 0x03, 0x0a, 0x01, 'b'}; !bytes.Equal(encoded, want) {
+		t.Errorf("encoded = % x, want % x", encoded, want)
+	}
+
+	// An update that succeeded nowhere still says how many it attempted
+	succeeded, attempted := 0, 3
+	fields, err := protoDecode(encodeEventMessage(apiEvent{Type: eventUpdateCompleted, Timestamp: 1700000000, Succeeded: &succeeded, Attempted: &attempted}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 3 || string(fields[0].Bytes) != eventUpdateCompleted || fields[1].Varint != 1700000000 || fields[2].Num != 6 || fields[2].Varint != 3 {
+		t.Errorf("fields = %+v", fields)
+	}
+}
+
+func TestEventGRPCAuth(t *testing.T) {
+	api, server, client := newTestEventGRPC(t)
+
+	for _, token := range []string{"", "wrong"} {
+		resp := eventGRPCCall(t, client, server.URL, "TriggerUpdate", token)
+		if status := resp.Header.Get("Grpc-Status"); status != strconv.Itoa(grpcUnauthenticated) {
+			t.Errorf("token %q: gRPC status %q, want UNAUTHENTICATED", token, status)
+		}
+	}
+	if len(api.trigger) != 0 {
+		t.Error("unauthenticated call triggered an update")
+	}
+
+	resp := eventGRPCCall(t, client, server.URL, "Unknown", "token")
+	if status := resp.Header.Get("Grpc-Status"); status != strconv.Itoa(grpcUnimplemented) {
+		t.Errorf("unknown method: gRPC status %q, want UNIMPLEMENTED", status)
+	}
+}
+
+func TestEventGRPCTriggerUpdate(t *testing.T) {
+	api, server, client := newTestEventGRPC(t)
+
+	for i := 0; i < 2; i++ {
+		resp := eventGRPCCall(t, client, server.URL, "TriggerUpdate", "token")
+		if _, err := readGRPCMessage(resp.Body); err != nil {
+			t.Fatalf("response: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		if err := grpcStatus(resp); err != nil {
+			t.Fatalf("status: %v", err)
+		}
+	}
+	if len(api.trigger) != 1 {
+		t.Errorf("pending triggers = %d, want 1", len(api.trigger))
+	}
+}
+
+func TestEventGRPCSubscribe(t *testing.T) {
+	api, server, client := newTestEventGRPC(t)
+
+	// The response headers are only sent once subscribed, so the event is not lost
+	resp := eventGRPCCall(t, client, server.URL, "Subscribe", "token")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc" || resp.ProtoMajor != 2 {
+		t.Fatalf("status %d, content type %q, HTTP/%d", resp.StatusCode, resp.Header.Get("Content-Type"), resp.ProtoMajor)
+	}
+	api.hub.publish(apiEvent{Type: eventRecordChanged, Record: &eventRecord{Action: "update", Type: "A", Name: "home.example.com", Content: "203.0.113.1"}})
+
+	messages := make(chan []byte)
+	go func() {
+		message, err := readGRPCMessage(resp.Body)
+		if err != nil {
+			t.Error(err)
+			close(messages)
+			return
+		}
+		messages <- message
+	}()
+	var message []byte
+	select {
+	case message = <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the event")
+	}
+
+	fields, err := protoDecode(message)
+	if err != nil || len(fields) != 3 || string(fields[0].Bytes) != eventRecordChanged || fields[1].Num != 2 || fields[1].Varint == 0 {
+		t.Fatalf("event fields = %+v, %v", fields, err)
+	}
+	record, _ := protoDecode(fields[2].Bytes)
+	var values []string
+	for _, f := range record {
+		values = append(values, string(f.Bytes))
+	}
+	if fields[2].Num != 4 || len(values) != 4 || values[0] != "update" || values[2] != "home.example.com" || values[3] != "203.0.113.1" {
+		t.Errorf("record = %q", values)
+	}
+}
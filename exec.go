@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execProtocolVersion is the version of the exec provider protocol sent with every request
+const execProtocolVersion = 1
+
+// ExecProvider implements DNSProvider (and the internal record operations) by running a
+// user-supplied executable for every record operation, so DNS services without a built-in
+// provider can be supported by a small script instead of a fork. Each run receives one
+// JSON request on stdin and writes one JSON response to stdout:
+//
+//	{"version": 1, "action": "get", "record": {"name": "nas.example.com", "type": "A"}}
+//	{"ok": true, "records": [{"id": "17", "name": "nas.example.com", "type": "A", "content": "192.0.2.1", "ttl": 120}]}
+//
+// Actions are get (records of a name and type), list (all records of a type), create,
+// update and delete (by id) and probe. A failed operation answers {"ok": false, "error":
+// "..."}, adding "unreachable": true when the DNS service could not be reached, so the
+// change is queued for retry like with the built-in providers. stderr is passed through
+// to the log.
+type ExecProvider struct {
+	Command string   // Path of the executable
+	Args    []string // Arguments passed on every run
+	Timeout time.Duration
+
+	recordSetClient
+}
+
+// Verify ExecProvider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*ExecProvider)(nil)
+var _ DNSProvider = (*ExecProvider)(nil)
+var _ providerClient = (*ExecProvider)(nil)
+
+// Exec protocol structures
+type execRecord struct {
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Content string `json:"content,omitempty"` // TXT: the text, or quoted strings if several
+	TTL     int    `json:"ttl,omitempty"`
+}
+
+type execRequest struct {
+	Version int         `json:"version"`
+	Action  string      `json:"action"`
+	Record  *execRecord `json:"record,omitempty"`
+}
+
+type execResponse struct {
+	OK          bool         `json:"ok"`
+	Error       string       `json:"error"`
+	Unreachable bool         `json:"unreachable"`
+	Records     []execRecord `json:"records"`
+}
+
+// newExecProvider creates an exec provider
+func newExecProvider(config *Config) (*ExecProvider, error) {
+	command, err := exec.LookPath(config.ExecCommand)
+	if err != nil {
+		return nil, fmt.Errorf("%sEXEC_COMMAND: %w", envPrefix, err)
+	}
+	if config.ExecTimeout <= 0 {
+		return nil, fmt.Errorf("%sEXEC_TIMEOUT_SECONDS must be positive, got %d", envPrefix, config.ExecTimeout)
+	}
+	p := &ExecProvider{
+		Command: command,
+		Args:    config.ExecArgs,
+		Timeout: time.Duration(config.ExecTimeout) * time.Second,
+	}
+	p.recordSetClient = newRecordSetClient(p, config)
+	log.Printf("Using exec provider %s", p.Command)
+
+	warnUnsupportedOptions(config, "the exec provider")
+	return p, nil
+}
+
+// run runs the executable with a request and returns its successful response.
+// Timeouts and responses marked unreachable wrap errProviderUnreachable.
+func (p *ExecProvider) run(action string, record *execRecord) (*execResponse, error) {
+	request, err := json.Marshal(execRequest{Version: execProtocolVersion, Action: action, Record: record})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stderr = os.Stderr
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	log.Printf("Exec Request: %s %s", action, execDescribe(record))
+	runErr := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: %s timed out after %s", errProviderUnreachable, p.Command, p.Timeout)
+	}
+
+	var response execResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("%s: %v", p.Command, runErr)
+		}
+		return nil, fmt.Errorf("%s: invalid response: %v", p.Command, err)
+	}
+	switch {
+	case response.Unreachable:
+		return nil, fmt.Errorf("%w: %s", errProviderUnreachable, response.Error)
+	case !response.OK || runErr != nil:
+		message := response.Error
+		if message == "" && runErr != nil {
+			message = runErr.Error()
+		}
+		return nil, fmt.Errorf("%s %s failed: %s", p.Command, action, message)
+	}
+	return &response, nil
+}
+
+// execDescribe describes a request's record for the log
+func execDescribe(record *execRecord) string {
+	if record == nil {
+		return ""
+	}
+	return strings.TrimSpace(record.Type + " " + record.Name + " " + record.ID)
+}
+
+// records returns the records of a name and type
+func (p *ExecProvider) records(name, recordType string) ([]execRecord, error) {
+	response, err := p.run("get", &execRecord{Name: strings.TrimSuffix(name, "."), Type: recordType})
+	if err != nil {
+		return nil, err
+	}
+	return response.Records, nil
+}
+
+// recordSetBackend implementation
+
+func (p *ExecProvider) fetchValues(name, recordType string) ([]string, error) {
+	records, err := p.records(name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, r := range records {
+		values = append(values, r.Content)
+	}
+	return values, nil
+}
+
+func (p *ExecProvider) storeValues(name, recordType string, ttl int, values []string) error {
+	name = strings.TrimSuffix(name, ".")
+	existing, err := p.records(name, recordType)
+	if err != nil {
+		return err
+	}
+
+	// Keep records that already hold a wanted value
+	wanted := make([]string, len(values))
+	for i, v := range values {
+		wanted[i] = porkbunContent(recordType, v)
+	}
+	kept, stale := matchValues(existing, wanted, func(r execRecord, w string) bool { return sameContent(r.Content, w) })
+
+	// Change stale records to missing values in place, then create or delete the rest
+	for i, w := range wanted {
+		if kept[i] {
+			continue
+		}
+		record := execRecord{Name: name, Type: recordType, Content: w, TTL: ttl}
+		action := "create"
+		if len(stale) > 0 {
+			record.ID, stale = stale[0].ID, stale[1:]
+			action = "update"
+		}
+		if _, err := p.run(action, &record); err != nil {
+			return err
+		}
+	}
+	for _, r := range stale {
+		if _, err := p.run("delete", &execRecord{ID: r.ID, Name: name, Type: recordType}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ExecProvider) listRecords(recordType string) ([]CFRecord, error) {
+	response, err := p.run("list", &execRecord{Type: recordType})
+	if err != nil {
+		return []CFRecord{}, err
+	}
+	records := []CFRecord{}
+	for _, r := range response.Records {
+		if r.Type == recordType {
+			records = append(records, valueRecords(r.Name, recordType, []string{r.Content})...)
+		}
+	}
+	return records, nil
+}
+
+// probe reports whether the DNS service answers; executables that do not implement
+// probe (or report another error) still count as reachable
+func (p *ExecProvider) probe() bool {
+	_, err := p.run("probe", nil)
+	return !errors.Is(err, errProviderUnreachable)
+}
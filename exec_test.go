@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// execHelperState is the DNS service of the helper executable, kept in a file between runs
+type execHelperState struct {
+	Records []execRecord
+	NextID  int
+	Actions []string // Actions and record IDs of all requests
+}
+
+// TestExecHelperProcess is not a real test: the exec provider tests run the test binary
+// as the provider executable, which then serves one request from a state file
+func TestExecHelperProcess(t *testing.T) {
+	statePath := os.Getenv("DYNIPUPDATE_EXEC_STATE")
+	if statePath == "" {
+		return
+	}
+	reply := func(response execResponse) {
+		json.NewEncoder(os.Stdout).Encode(response)
+		os.Exit(0)
+	}
+	switch os.Getenv("DYNIPUPDATE_EXEC_MODE") {
+	case "unreachable":
+		reply(execResponse{Error: "connection refused", Unreachable: true})
+	case "hang":
+		time.Sleep(time.Minute)
+	case "crash":
+		fmt.Fprintln(os.Stderr, "plugin crashed")
+		os.Exit(3)
+	}
+
+	var state execHelperState
+	if data, err := os.ReadFile(statePath); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	var request execRequest
+	json.NewDecoder(os.Stdin).Decode(&request)
+	if request.Version != execProtocolVersion {
+		reply(execResponse{Error: "unsupported protocol version"})
+	}
+	r := request.Record
+	if r == nil {
+		r = &execRecord{}
+	}
+	state.Actions = append(state.Actions, strings.TrimSpace(request.Action+" "+r.ID))
+
+	response := execResponse{OK: true}
+	find := func(id string) int {
+		for i, rec := range state.Records {
+			if rec.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+	switch request.Action {
+	case "get", "list":
+		for _, rec := range state.Records {
+			if rec.Type == r.Type && (request.Action == "list" || rec.Name == r.Name) {
+				response.Records = append(response.Records, rec)
+			}
+		}
+	case "create":
+		state.NextID++
+		r.ID = fmt.Sprint(state.NextID)
+		state.Records = append(state.Records, *r)
+		response.Records = []execRecord{*r}
+	case "update":
+		if i := find(r.ID); i >= 0 {
+			state.Records[i] = *r
+		} else {
+			response = execResponse{Error: "no record " + r.ID}
+		}
+	case "delete":
+		if i := find(r.ID); i >= 0 {
+			state.Records = append(state.Records[:i], state.Records[i+1:]...)
+		} else {
+			response = execResponse{Error: "no record " + r.ID}
+		}
+	case "probe":
+	default:
+		response = execResponse{Error: "unknown action " + request.Action}
+	}
+
+	data, _ := json.Marshal(state)
+	os.WriteFile(statePath, data, 0o600)
+	reply(response)
+}
+
+// newExecTestProvider returns a provider running the helper executable with an empty state
+func newExecTestProvider(t *testing.T) (*ExecProvider, string) {
+	t.Helper()
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	t.Setenv("DYNIPUPDATE_EXEC_STATE", statePath)
+	t.Setenv("DYNIPUPDATE_EXEC_MODE", "")
+
+	p, err := newExecProvider(&Config{
+		ExecCommand:  os.Args[0],
+		ExecArgs:     []string{"-test.run=^TestExecHelperProcess$"},
+		ExecTimeout:  10,
+		RecordTTL:    120,
+		HeartbeatTTL: 3600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, statePath
+}
+
+// readExecState returns the records (as name/type/content/ttl, sorted) and actions of the helper
+func readExecState(t *testing.T, statePath string) ([]string, []string) {
+	t.Helper()
+	var state execHelperState
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(data, &state)
+	var records []string
+	for _, r := range state.Records {
+		records = append(records, fmt.Sprintf("%s/%s/%s/%d", r.Name, r.Type, r.Content, r.TTL))
+	}
+	sort.Strings(records)
+	return records, state.Actions
+}
+
+func TestExecRecordOperations(t *testing.T) {
+	p, statePath := newExecTestProvider(t)
+
+	for _, ip := range []string{"192.168.1.10", "192.168.1.11"} {
+		if p.ensureRecordExists("nas.example.com", "A", ip, false) != nil {
+			t.Fatalf("ensureRecordExists(%s) failed", ip)
+		}
+	}
+	if p.upsertHeartbeat("nas.example.com", `"1700000000"`) != nil {
+		t.Fatal("upsertHeartbeat failed")
+	}
+	records, _ := readExecState(t, statePath)
+	want := []string{"nas.example.com/A/192.168.1.10/120", "nas.example.com/A/192.168.1.11/120", "nas.example.com/TXT/1700000000/3600"}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("records = %v, want %v", records, want)
+	}
+
+	listed, _ := p.getAllRecordsByType("TXT")
+	if len(listed) != 1 || listed[0].Name != "nas.example.com" || listed[0].Content != "1700000000" {
+		t.Errorf("listed = %+v", listed)
+	}
+
+	// A changed address updates the record in place
+	if p.upsertRecord("home.example.com", "A", "203.0.113.1", false) != nil || p.upsertRecord("home.example.com", "A", "203.0.113.2", false) != nil {
+		t.Fatal("upsertRecord failed")
+	}
+	_, actions := readExecState(t, statePath)
+	if last := actions[len(actions)-1]; last != "update 4" {
+		t.Errorf("last action = %q, want update 4 (actions %v)", last, actions)
+	}
+
+	if p.deleteRecord("192.168.1.11", "nas.example.com", "A") != nil {
+		t.Fatal("deleteRecord failed")
+	}
+	records, _ = readExecState(t, statePath)
+	want = []string{"home.example.com/A/203.0.113.2/120", "nas.example.com/A/192.168.1.10/120", "nas.example.com/TXT/1700000000/3600"}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %v, want %v", records, want)
+	}
+	if !p.probeConnectivity() {
+		t.Error("probe failed")
+	}
+}
+
+func TestExecErrors(t *testing.T) {
+	p, _ := newExecTestProvider(t)
+
+	if err := p.storeValues("nas.example.com", "A", 120, nil); err != nil {
+		t.Errorf("deleting nothing: %v", err)
+	}
+	if _, err := p.run("frobnicate", nil); err == nil || !strings.Contains(err.Error(), "unknown action frobnicate") {
+		t.Errorf("err = %v", err)
+	}
+
+	t.Setenv("DYNIPUPDATE_EXEC_MODE", "unreachable")
+	if err := p.storeValues("nas.example.com", "A", 120, []string{"192.0.2.1"}); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("err = %v, want unreachable", err)
+	}
+	if p.probeConnectivity() {
+		t.Error("probe succeeded while unreachable")
+	}
+
+	t.Setenv("DYNIPUPDATE_EXEC_MODE", "crash")
+	if _, err := p.fetchValues("nas.example.com", "A"); err == nil || errors.Is(err, errProviderUnreachable) || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("err = %v, want exit status 3", err)
+	}
+
+	t.Setenv("DYNIPUPDATE_EXEC_MODE", "hang")
+	p.Timeout = 200 * time.Millisecond
+	if _, err := p.fetchValues("nas.example.com", "A"); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("err = %v, want unreachable after the timeout", err)
+	}
+
+	if _, err := newExecProvider(&Config{ExecCommand: "dynipupdate-no-such-plugin", ExecTimeout: 10}); err == nil {
+		t.Error("accepted a missing executable")
+	}
+	if _, err := newExecProvider(&Config{ExecCommand: os.Args[0]}); err == nil {
+		t.Error("accepted a zero timeout")
+	}
+}
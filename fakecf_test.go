@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCloudFlare is an in-memory CloudFlare DNS API used by tests
+type fakeCloudFlare struct {
+	mu       sync.Mutex
+	records  map[string]CFRecord // id -> record
+	nextID   int
+	zoneType string         // Reported zone setup type ("" reports "full")
+	audit    []cfAuditEntry // Account audit log, served two entries per page
+	server   *httptest.Server
+}
+
+// newFakeCloudFlare starts a fake API server and returns it with a client pointed at it
+func newFakeCloudFlare(t *testing.T) (*fakeCloudFlare, *CloudFlareClient) {
+	t.Helper()
+	fake := &fakeCloudFlare{records: make(map[string]CFRecord)}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(fake.server.Close)
+
+	return fake, &CloudFlareClient{
+		APIToken: "test-token",
+		ZoneID:   "zone",
+		BaseURL:  fake.server.URL,
+	}
+}
+
+// add inserts a record directly and returns its ID
+func (f *fakeCloudFlare) add(recordType, name, content string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	id := fmt.Sprintf("rec%d", f.nextID)
+	f.records[id] = CFRecord{ID: id, Type: recordType, Name: name, Content: content}
+	return id
+}
+
+// contents returns the sorted-by-insertion contents of records matching name and type
+func (f *fakeCloudFlare) contents(name, recordType string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []string
+	for i := 1; i <= f.nextID; i++ {
+		if r, ok := f.records[fmt.Sprintf("rec%d", i)]; ok && r.Name == name && r.Type == recordType {
+			result = append(result, r.Content)
+		}
+	}
+	return result
+}
+
+func (f *fakeCloudFlare) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/zones/zone")
+	switch {
+	case r.URL.Path == "/user/tokens/verify":
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "errors": []interface{}{}, "result": map[string]string{"id": "own-token", "status": "active"}})
+
+	case r.URL.Path == "/accounts/acct/logs/audit" && r.Method == "GET":
+		since, _ := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+		before, _ := time.Parse(time.RFC3339, r.URL.Query().Get("before"))
+		var entries []cfAuditEntry
+		for _, e := range f.audit {
+			at, _ := time.Parse(time.RFC3339, e.Action.Time)
+			if !at.Before(since) && !at.After(before) {
+				entries = append(entries, e)
+			}
+		}
+		start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		response := cfAuditResponse{Success: true, Result: []cfAuditEntry{}}
+		if start < len(entries) {
+			end := min(start+2, len(entries))
+			response.Result = entries[start:end]
+			if end < len(entries) {
+				response.ResultInfo.Cursor = strconv.Itoa(end)
+			}
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case path == "" && r.Method == "GET":
+		zoneType := f.zoneType
+		if zoneType == "" {
+			zoneType = zoneTypeFull
+		}
+		json.NewEncoder(w).Encode(CFZoneResponse{Success: true, Result: CFZone{ID: "zone", Name: "example.com", Type: zoneType, Status: "active"}})
+
+	case path == "/dns_records" && r.Method == "GET":
+		name, recordType := r.URL.Query().Get("name"), r.URL.Query().Get("type")
+		result := []CFRecord{}
+		for i := 1; i <= f.nextID; i++ {
+			rec, ok := f.records[fmt.Sprintf("rec%d", i)]
+			if ok && (name == "" || rec.Name == name) && (recordType == "" || rec.Type == recordType) {
+				result = append(result, rec)
+			}
+		}
+		// Paged like the API: 100 records per page unless per_page is given
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		if perPage <= 0 {
+			perPage = 100
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		page = max(page, 1)
+		start, end := min((page-1)*perPage, len(result)), min(page*perPage, len(result))
+		info := CFResultInfo{Page: page, PerPage: perPage, Count: end - start, TotalCount: len(result), TotalPages: (len(result) + perPage - 1) / perPage}
+		json.NewEncoder(w).Encode(CFListResponse{Success: true, Result: result[start:end], ResultInfo: info})
+
+	case path == "/dns_records" && r.Method == "POST":
+		var req CFCreateUpdateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.nextID++
+		rec := CFRecord{ID: fmt.Sprintf("rec%d", f.nextID), Type: req.Type, Name: req.Name, Content: req.Content, TTL: req.TTL, Proxied: req.Proxied, Tags: req.Tags, Comment: req.Comment}
+		setFakeRecordData(&rec, req)
+		f.records[rec.ID] = rec
+		json.NewEncoder(w).Encode(CFSingleResponse{Success: true, Result: rec})
+
+	case strings.HasPrefix(path, "/dns_records/") && r.Method == "PUT":
+		id := strings.TrimPrefix(path, "/dns_records/")
+		var req CFCreateUpdateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		rec := CFRecord{ID: id, Type: req.Type, Name: req.Name, Content: req.Content, TTL: req.TTL, Proxied: req.Proxied, Tags: req.Tags, Comment: req.Comment}
+		setFakeRecordData(&rec, req)
+		f.records[id] = rec
+		json.NewEncoder(w).Encode(CFSingleResponse{Success: true, Result: rec})
+
+	case strings.HasPrefix(path, "/dns_records/") && r.Method == "DELETE":
+		id := strings.TrimPrefix(path, "/dns_records/")
+		rec := f.records[id]
+		delete(f.records, id)
+		json.NewEncoder(w).Encode(CFSingleResponse{Success: true, Result: rec})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "errors": []string{"not found"}})
+	}
+}
+
+// setFakeRecordData keeps the data of SRV and HTTPS records like the API, which derives
+// their content from it (SRV content leaves out the priority)
+func setFakeRecordData(rec *CFRecord, req CFCreateUpdateRequest) {
+	if req.Data == nil {
+		return
+	}
+	raw, _ := json.Marshal(req.Data)
+	rec.Data = &cfRecordData{}
+	json.Unmarshal(raw, rec.Data)
+	if rec.Type == "SRV" {
+		rec.Content = fmt.Sprintf("%d %d %s", rec.Data.Weight, rec.Data.Port, rec.Data.Target)
+	} else {
+		rec.Content = strings.TrimSpace(fmt.Sprintf("%d %s %s", rec.Data.Priority, rec.Data.Target, rec.Data.Value))
+	}
+}
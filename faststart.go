@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// saveLastDetection caches the detection result in the state file (if configured)
+func saveLastDetection(config *Config, ips *IPAddresses, detectedAt time.Time) {
+	if config.StateFile == "" {
+		return
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, detection not cached: %v", err)
+		return
+	}
+	state.LastDetection = &CachedDetection{Timestamp: detectedAt.Unix(), IPs: *ips}
+	if err := state.save(config.StateFile); err != nil {
+		log.Printf("WARNING: Could not save state: %v", err)
+	}
+}
+
+// freshCachedDetection returns the cached detection if it is no older than maxAge
+func freshCachedDetection(state *State, now time.Time, maxAge time.Duration) *IPAddresses {
+	if state.LastDetection == nil {
+		return nil
+	}
+	age := now.Sub(time.Unix(state.LastDetection.Timestamp, 0))
+	if age < 0 || age > maxAge {
+		return nil
+	}
+	ips := state.LastDetection.IPs
+	if ips.CustomRangeIPs == nil {
+		ips.CustomRangeIPs = make(map[string][]string)
+	}
+	return &ips
+}
+
+// runFastStart reconciles records from the cached last-known addresses (if fresh) so that
+// records recover immediately after a reboot, before external echo services are queried.
+// The full detection and update that follows corrects anything that changed meanwhile, and
+// reports the run: fast start only writes the records, without statistics, journal
+// entries, notifications or propagation checks made from cached data.
+func runFastStart(cf providerClient, config *Config) {
+	if config.StateFile == "" {
+		log.Printf("WARNING: %sFAST_START requires %sSTATE_FILE - skipping fast start", envPrefix, envPrefix)
+		return
+	}
+
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, skipping fast start: %v", err)
+		return
+	}
+
+	ips := freshCachedDetection(state, time.Now(), time.Duration(config.FastStartMaxAge)*time.Second)
+	if ips == nil {
+		log.Println("Fast start: no fresh cached addresses - waiting for full detection")
+		return
+	}
+
+	log.Printf("Fast start: republishing cached addresses from %s", time.Unix(state.LastDetection.Timestamp, 0).Format(time.RFC3339))
+	_, successCount, totalCount := applyRecords(cf, config, ips)
+	log.Printf("Fast start: %d/%d records reconciled from cache", successCount, totalCount)
+}
+
+// startFastStart runs fast start (if enabled) before the first cycle of daemon and serve
+// mode, for the domains of the network profile matching now
+func startFastStart(cf providerClient, config *Config) {
+	if !config.FastStart {
+		return
+	}
+	if cycle := cycleConfig(config); cycle != nil {
+		runFastStart(cf, cycle)
+	}
+}
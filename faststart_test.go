@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFreshCachedDetection verifies that only sufficiently recent cached detections are used
+func TestFreshCachedDetection(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	state := newState()
+
+	if freshCachedDetection(state, now, time.Hour) != nil {
+		t.Error("Expected nil without a cached detection")
+	}
+
+	state.LastDetection = &CachedDetection{
+		Timestamp: now.Add(-30 * time.Minute).Unix(),
+		IPs:       IPAddresses{ExternalIPv4: "203.0.113.1"},
+	}
+	ips := freshCachedDetection(state, now, time.Hour)
+	if ips == nil || ips.ExternalIPv4 != "203.0.113.1" {
+		t.Fatalf("Expected cached detection to be used, got %+v", ips)
+	}
+	if ips.CustomRangeIPs == nil {
+		t.Error("Expected CustomRangeIPs to be initialised")
+	}
+
+	if freshCachedDetection(state, now, 10*time.Minute) != nil {
+		t.Error("Expected stale cached detection to be ignored")
+	}
+}
+
+// TestStartFastStart verifies that daemon mode republishes the cached addresses of the
+// domains in the active network profile, leaving the run's reports to the full update
+func TestStartFastStart(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, _ := writeTestSigningKey(t, dir)
+	source := &fakeInterfaces{addrs: []interfaceAddr{{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "eth0"}}}
+	config := &Config{
+		ExternalDomain:    "home.example.com",
+		InternalDomain:    "nas.i.example.com",
+		StateFile:         filepath.Join(dir, "state.json"),
+		HostsFilePath:     filepath.Join(dir, "hosts"),
+		FastStart:         true,
+		FastStartMaxAge:   3600,
+		RecordTTL:         120,
+		HistoryFile:       filepath.Join(dir, "history.jsonl"),
+		HistorySigningKey: keyPath,
+		NetworkProfiles:   []NetworkProfile{{Name: "home", Subnets: []string{"192.168.1.0/24"}, Domains: []string{"external"}}},
+		interfaces:        newInterfaceScanner(source, time.Minute),
+	}
+	saveLastDetection(config, &IPAddresses{ExternalIPv4: "203.0.113.1", InternalIPv4: []string{"192.168.1.10"}}, time.Now())
+	cf, err := newHostsFileProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startFastStart(cf, config)
+	data, _ := os.ReadFile(config.HostsFilePath)
+	if !strings.Contains(string(data), "203.0.113.1\thome.example.com") || strings.Contains(string(data), "nas.i.example.com") {
+		t.Errorf("hosts file after fast start:\n%s", data)
+	}
+	if _, err := os.Stat(config.HistoryFile); !os.IsNotExist(err) {
+		t.Errorf("fast start wrote the change journal: %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// fleetHost is one inventory entry: column (CSV) or key (YAML) -> value. Every host needs
+// a "host" field, which also names its output file by default.
+type fleetHost map[string]string
+
+// parseInventory reads a host inventory. Files ending in .yaml/.yml are a list of flat
+// mappings, .json an array of objects, anything else CSV with a header row.
+func parseInventory(path string, data []byte) ([]fleetHost, error) {
+	var hosts []fleetHost
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		hosts, err = parseInventoryYAML(data)
+	case ".json":
+		err = json.Unmarshal(data, &hosts)
+	default:
+		hosts, err = parseInventoryCSV(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i, h := range hosts {
+		name := h["host"]
+		if name == "" {
+			return nil, fmt.Errorf("%s: entry %d has no host", path, i+1)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s: host %s is listed twice", path, name)
+		}
+		seen[name] = true
+	}
+	return hosts, nil
+}
+
+// parseInventoryCSV reads CSV with a header row naming the fields
+func parseInventoryCSV(data []byte) ([]fleetHost, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("empty inventory")
+	}
+	header := rows[0]
+	hosts := make([]fleetHost, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		h := make(fleetHost, len(header))
+		for i, field := range header {
+			h[strings.TrimSpace(field)] = strings.TrimSpace(row[i])
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// parseInventoryYAML reads the YAML subset inventories need, a list of flat mappings:
+//
+//   - host: nas
+//     site: home
+//   - host: "web-1"
+//
+// Anything else (nesting, multi-line values, anchors) is rejected with its line number.
+func parseInventoryYAML(data []byte) ([]fleetHost, error) {
+	var hosts []fleetHost
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+
+		entry := strings.TrimPrefix(text, "  ")
+		switch {
+		case strings.HasPrefix(text, "- "):
+			hosts = append(hosts, make(fleetHost))
+			entry = text[2:]
+		case len(hosts) == 0 || !strings.HasPrefix(text, "  ") || strings.HasPrefix(entry, " "):
+			return nil, fmt.Errorf("line %d: expected \"- key: value\" or \"  key: value\"", line)
+		}
+
+		key, value, ok := strings.Cut(entry, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line)
+		}
+		value, err := yamlScalar(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		hosts[len(hosts)-1][key] = value
+	}
+	return hosts, scanner.Err()
+}
+
+// yamlScalar unquotes a plain or quoted scalar, dropping a trailing comment
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := strings.LastIndex(s, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		var value string
+		if err := json.Unmarshal([]byte(s[:end+1]), &value); err != nil {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return value, nil
+	case strings.HasPrefix(s, "'"):
+		end := strings.LastIndex(s, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:end], "''", "'"), nil
+	case s != "" && strings.ContainsRune("[{&*|>", rune(s[0])):
+		return "", fmt.Errorf("unsupported value %s (only plain and quoted strings)", s)
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// renderFleetFile renders the template for one host. Fields the template uses but the
+// host lacks are errors, so a missing CSV column never renders as "<no value>".
+func renderFleetFile(tmpl *template.Template, host fleetHost) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Option("missingkey=error").Execute(&buf, host); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// validateFleetFile checks a rendered file against the config schema: JSON files are
+// validated like config files, env files by their BEES_IP_UPDATE_* names
+func validateFleetFile(name string, data []byte) error {
+	if strings.HasSuffix(name, ".json") {
+		_, err := parseConfigFileData(name, data)
+		return err
+	}
+
+	schema, err := loadConfigSchema()
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "export "))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, ok := strings.Cut(line, "=")
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s:%d: expected NAME=value", name, i+1))
+			continue
+		}
+		if !strings.HasPrefix(key, envPrefix) {
+			continue // Other variables of the environment file
+		}
+		configKey := strings.ToLower(strings.TrimPrefix(key, envPrefix))
+		if schema.lookup(configKey) == nil {
+			problem := fmt.Sprintf("%s:%d: unknown variable %s", name, i+1, key)
+			if suggestion := suggestConfigKey(schema, configKey); suggestion != "" {
+				problem += fmt.Sprintf(" (did you mean %s%s?)", envPrefix, strings.ToUpper(suggestion))
+			}
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid env file:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// defaultFleetFilename names output files after the host and the template's extension:
+// fleet.env.tmpl renders nas.env, config.json.tmpl renders nas.json
+func defaultFleetFilename(templatePath string) string {
+	base := filepath.Base(templatePath)
+	for _, suffix := range []string{".tmpl", ".tpl"} {
+		base = strings.TrimSuffix(base, suffix)
+	}
+	ext := filepath.Ext(base)
+	if ext == "" {
+		ext = ".env"
+	}
+	return "{{.host}}" + ext
+}
+
+// fleetFile is a rendered file of one host
+type fleetFile struct {
+	Name    string
+	Content []byte
+}
+
+// generateFleet renders every host, returning the files in inventory order
+func generateFleet(hosts []fleetHost, templatePath string, templateData []byte, filenamePattern string) ([]fleetFile, error) {
+	tmpl, err := template.New(filepath.Base(templatePath)).Parse(string(templateData))
+	if err != nil {
+		return nil, err
+	}
+	nameTmpl, err := template.New("filename").Parse(filenamePattern)
+	if err != nil {
+		return nil, fmt.Errorf("filename pattern: %w", err)
+	}
+
+	files := make([]fleetFile, 0, len(hosts))
+	names := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		nameData, err := renderFleetFile(nameTmpl, host)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: filename: %w", host["host"], err)
+		}
+		name := string(nameData)
+		if name == "" || filepath.Base(name) != name {
+			return nil, fmt.Errorf("host %s: invalid file name %q", host["host"], name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("host %s: file name %s is used by another host", host["host"], name)
+		}
+
+		content, err := renderFleetFile(tmpl, host)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %w", host["host"], err)
+		}
+		if err := validateFleetFile(name, content); err != nil {
+			return nil, fmt.Errorf("host %s: %w", host["host"], err)
+		}
+		names[name] = true
+		files = append(files, fleetFile{Name: name, Content: content})
+	}
+	return files, nil
+}
+
+// runGenFleet implements the gen-fleet subcommand: renders one env or config file per
+// host of an inventory from a template, for rolling the updater out to many machines
+func runGenFleet(args []string) int {
+	fs := flag.NewFlagSet("gen-fleet", flag.ContinueOnError)
+	inventoryPath := fs.String("inventory", "", "Host inventory (.csv, .yaml/.yml or .json)")
+	templatePath := fs.String("template", "", "Go text/template rendered per host, e.g. fleet.env.tmpl or config.json.tmpl")
+	output := fs.String("output", "fleet", "Directory to write files to")
+	filename := fs.String("filename", "", "File name pattern (default {{.host}} plus the template's extension)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *inventoryPath == "" || *templatePath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: dynipupdate gen-fleet -inventory hosts.csv -template fleet.env.tmpl [-output dir]")
+		return 2
+	}
+	if *filename == "" {
+		*filename = defaultFleetFilename(*templatePath)
+	}
+
+	if err := genFleet(*inventoryPath, *templatePath, *output, *filename, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// genFleet renders all files before writing any, so an error leaves no partial fleet
+func genFleet(inventoryPath, templatePath, output, filename string, out io.Writer) error {
+	inventory, err := os.ReadFile(inventoryPath)
+	if err != nil {
+		return err
+	}
+	hosts, err := parseInventory(inventoryPath, inventory)
+	if err != nil {
+		return err
+	}
+	templateData, err := os.ReadFile(templatePath)
+	if err != nil {
+		return err
+	}
+	files, err := generateFleet(hosts, templatePath, templateData, filename)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(output, 0o755); err != nil {
+		return err
+	}
+	for _, file := range files {
+		path := filepath.Join(output, file.Name)
+		// Rendered files usually hold API tokens
+		if err := os.WriteFile(path, file.Content, 0o600); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, path)
+	}
+	return nil
+}
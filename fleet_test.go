@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInventoryFormats(t *testing.T) {
+	want := []fleetHost{
+		{"host": "nas", "site": "home"},
+		{"host": "web-1", "site": "dc 1"},
+	}
+	inventories := map[string]string{
+		"hosts.csv": "# Fleet\nhost, site\nnas, home\nweb-1,\"dc 1\"\n",
+		"hosts.yaml": `---
+# Fleet
+- host: nas
+  site: home   # Main site
+- host: "web-1"
+  site: 'dc 1'
+`,
+		"hosts.json": `[{"host": "nas", "site": "home"}, {"host": "web-1", "site": "dc 1"}]`,
+	}
+	for path, data := range inventories {
+		hosts, err := parseInventory(path, []byte(data))
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(hosts, want) {
+			t.Errorf("%s = %v, want %v", path, hosts, want)
+		}
+	}
+}
+
+func TestParseInventoryErrors(t *testing.T) {
+	for path, data := range map[string]string{
+		"missing-host.csv": "host,site\n,home\n",
+		"duplicate.csv":    "host\nnas\nnas\n",
+		"ragged.csv":       "host,site\nnas\n",
+		"nested.yaml":      "- host: nas\n  tags:\n    - a\n",
+		"flow.yaml":        "- host: nas\n  tags: [a, b]\n",
+		"no-list.yaml":     "host: nas\n",
+	} {
+		if hosts, err := parseInventory(path, []byte(data)); err == nil {
+			t.Errorf("%s accepted: %v", path, hosts)
+		}
+	}
+}
+
+func TestGenerateFleet(t *testing.T) {
+	hosts := []fleetHost{{"host": "nas", "site": "home"}, {"host": "web1", "site": "dc1"}}
+	tmpl := []byte("BEES_IP_UPDATE_COMBINED_DOMAIN={{.host}}.{{.site}}.example.com\nTZ=UTC\n")
+
+	files, err := generateFleet(hosts, "fleet.env.tmpl", tmpl, defaultFleetFilename("fleet.env.tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != "nas.env" || files[1].Name != "web1.env" {
+		t.Fatalf("files = %v", files)
+	}
+	if got := string(files[1].Content); got != "BEES_IP_UPDATE_COMBINED_DOMAIN=web1.dc1.example.com\nTZ=UTC\n" {
+		t.Errorf("web1.env = %q", got)
+	}
+
+	// A field missing for one host fails the run
+	_, err = generateFleet(append(hosts, fleetHost{"host": "laptop"}), "fleet.env.tmpl", tmpl, "{{.host}}.env")
+	if err == nil || !strings.Contains(err.Error(), "laptop") {
+		t.Errorf("missing field: err = %v", err)
+	}
+
+	// Misspelt variables are caught before the files reach the fleet
+	_, err = generateFleet(hosts, "fleet.env.tmpl", []byte("BEES_IP_UPDATE_COMBINED_DOMIAN={{.host}}\n"), "{{.host}}.env")
+	if err == nil || !strings.Contains(err.Error(), "BEES_IP_UPDATE_COMBINED_DOMAIN?") {
+		t.Errorf("misspelt variable: err = %v", err)
+	}
+
+	// File names must not escape the output directory or collide
+	if _, err := generateFleet(hosts, "fleet.env.tmpl", tmpl, "../{{.host}}"); err == nil {
+		t.Error("accepted a file name outside the output directory")
+	}
+	if _, err := generateFleet(hosts, "fleet.env.tmpl", tmpl, "all.env"); err == nil {
+		t.Error("accepted the same file name for two hosts")
+	}
+}
+
+func TestGenerateFleetJSON(t *testing.T) {
+	hosts := []fleetHost{{"host": "nas"}}
+	if defaultFleetFilename("config.json.tmpl") != "{{.host}}.json" {
+		t.Errorf("filename = %s", defaultFleetFilename("config.json.tmpl"))
+	}
+	good := []byte(`{"combined_domain": "{{.host}}.example.com", "record_ttl": 300}`)
+	if _, err := generateFleet(hosts, "config.json.tmpl", good, "{{.host}}.json"); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+	bad := []byte(`{"combined_domain": "{{.host}}.example.com", "record_ttl": "soon"}`)
+	if _, err := generateFleet(hosts, "config.json.tmpl", bad, "{{.host}}.json"); err == nil {
+		t.Error("invalid config accepted")
+	}
+}
+
+func TestGenFleetWritesFiles(t *testing.T) {
+	dir := t.TempDir()
+	inventory := filepath.Join(dir, "hosts.csv")
+	tmpl := filepath.Join(dir, "fleet.env.tmpl")
+	os.WriteFile(inventory, []byte("host\nnas\n"), 0o644)
+	os.WriteFile(tmpl, []byte("BEES_IP_UPDATE_BASE_DOMAIN=example.com\nBEES_IP_UPDATE_HOST_LABEL={{.host}}\n"), 0o644)
+
+	output := filepath.Join(dir, "out")
+	var listing strings.Builder
+	if err := genFleet(inventory, tmpl, output, "{{.host}}.env", &listing); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(output, "nas.env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	if listing.String() != filepath.Join(output, "nas.env")+"\n" {
+		t.Errorf("listing = %q", listing.String())
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "log"
+
+// Force mode (-force) rewrites every managed record the update reconciles, even when its
+// content already matches the detected address. It recovers from states where the
+// content is right but something else about the record is not: a TTL, proxied flag or
+// tags changed by hand in the dashboard, or a record left behind by an interrupted edit.
+// Records are rewritten in place (same ID) with the configured TTL, proxied flag and tags.
+// Providers that only store content and TTL may skip writes that would change nothing.
+
+// forceClient passes everything through to the provider, but rewrites records whose
+// content matches instead of leaving them alone
+type forceClient struct {
+	providerClient
+}
+
+func newForceClient(cf providerClient) *forceClient {
+	return &forceClient{providerClient: cf}
+}
+
+func (c *forceClient) rewrite(recordID, name, recordType, content string, proxied bool) error {
+	log.Printf("Forcing rewrite of %s record %s (%s)", recordType, name, content)
+	return c.updateRecord(recordID, name, recordType, content, proxied)
+}
+
+func (c *forceClient) upsertRecord(name, recordType, content string, proxied bool) error {
+	existing, err := c.getRecord(name, recordType)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return c.createRecord(name, recordType, content, proxied)
+	}
+	if !sameContent(existing.Content, content) {
+		log.Printf("Content changed for %s record %s: %s -> %s", recordType, name, existing.Content, content)
+		return c.updateRecord(existing.ID, name, recordType, content, proxied)
+	}
+	return c.rewrite(existing.ID, name, recordType, content, proxied)
+}
+
+func (c *forceClient) ensureRecordExists(name, recordType, content string, proxied bool) error {
+	records, err := c.getAllRecords(name, recordType)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if sameContent(record.Content, content) {
+			return c.rewrite(record.ID, name, recordType, content, proxied)
+		}
+	}
+	return c.createRecord(name, recordType, content, proxied)
+}
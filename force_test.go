@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestForceRewritesMatchingRecords(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	cf.TTL = 120
+	cf.Tags = []string{"managed-by:dynipupdate"}
+	var changes []RecordChange
+	cf.OnChange = newChangeRecorder(&changes)
+
+	// Content matches, but the TTL and tags were changed in the dashboard
+	aID := fake.add("A", "home.example.com", "203.0.113.1")
+	fake.add("A", "nas.example.com", "192.168.1.10")
+	nasID := fake.add("A", "nas.example.com", "192.168.1.11")
+
+	if cf.upsertRecord("home.example.com", "A", "203.0.113.1", false) != nil || len(changes) != 0 {
+		t.Fatalf("unforced upsert wrote: %+v", changes)
+	}
+
+	force := newForceClient(cf)
+	if force.upsertRecord("home.example.com", "A", "203.0.113.1", false) != nil {
+		t.Fatal("upsertRecord failed")
+	}
+	if force.ensureRecordExists("nas.example.com", "A", "192.168.1.11", false) != nil {
+		t.Fatal("ensureRecordExists failed")
+	}
+	for _, id := range []string{aID, nasID} {
+		r := fake.records[id]
+		if r.TTL != 120 || !reflect.DeepEqual(r.Tags, cf.Tags) {
+			t.Errorf("record %s not rewritten in place: %+v", id, r)
+		}
+	}
+	if len(changes) != 2 || changes[0].Action != "update" || changes[1].Action != "update" {
+		t.Errorf("changes = %+v, want two updates", changes)
+	}
+	if got := fake.contents("nas.example.com", "A"); !reflect.DeepEqual(got, []string{"192.168.1.10", "192.168.1.11"}) {
+		t.Errorf("nas records = %v", got)
+	}
+
+	// Records that differ or are missing are handled as without -force
+	if force.upsertRecord("home.example.com", "A", "203.0.113.2", false) != nil || force.ensureRecordExists("nas.example.com", "A", "192.168.1.12", false) != nil {
+		t.Fatal("update or create failed")
+	}
+	if got := fake.contents("home.example.com", "A"); !reflect.DeepEqual(got, []string{"203.0.113.2"}) {
+		t.Errorf("home records = %v", got)
+	}
+	if got := fake.contents("nas.example.com", "A"); len(got) != 3 {
+		t.Errorf("nas records = %v", got)
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gRPC provider plugins. With PROVIDER=grpc, record operations go to a plugin process
+// serving the DNSProvider service of plugin/dnsprovider.proto on the unix socket
+// GRPC_SOCKET, so a provider can live out of tree, in any language, with stubs generated
+// from the contract. The plugin is started on its own (by systemd, as a sidecar, ...) and
+// may restart at any time: calls connect as needed, and one that cannot reach the plugin is
+// queued for retry like a change to an unreachable DNS service. The plugin's log lines are
+// streamed with the Logs RPC into the updater's log.
+//
+// The client speaks gRPC over unencrypted HTTP/2 with the standard library, encoding the
+// messages after the contract (protowire.go), so the updater stays free of dependencies.
+// Unary calls go through the provider middleware stack (transport.go): rate limit, retries
+// of calls that did not reach the plugin, metrics and fault injection apply as with the
+// HTTP providers.
+
+// grpcServicePath is the path prefix of the DNSProvider methods
+const grpcServicePath = "/dynipupdate.plugin.v1.DNSProvider/"
+
+// grpcMaxMessage bounds the messages accepted from a plugin
+const grpcMaxMessage = 4 << 20
+
+// grpcLogsRetry is the wait before the Logs stream is opened again after it ended
+const grpcLogsRetry = 5 * time.Second
+
+// gRPC status codes told apart
+const (
+	grpcOK               = 0
+	grpcInvalidArgument  = 3
+	grpcDeadlineExceeded = 4
+	grpcUnimplemented    = 12
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+// GRPCProvider implements DNSProvider (and the internal record operations) with a gRPC
+// plugin. Like the exec provider, it sees the plugin's records of a name and type as one
+// record set.
+type GRPCProvider struct {
+	Socket string // Unix socket the plugin listens on
+
+	client *http.Client // Unary calls, through the provider middleware stack
+	stream *http.Client // The Logs stream, which has no timeout
+
+	recordSetClient
+}
+
+// Verify GRPCProvider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*GRPCProvider)(nil)
+var _ DNSProvider = (*GRPCProvider)(nil)
+var _ providerClient = (*GRPCProvider)(nil)
+
+// grpcRecord is the Record message
+type grpcRecord struct {
+	ID      string
+	Name    string
+	Type    string
+	Content string
+	TTL     int
+	Proxied bool
+}
+
+// grpcStatusError is a call the plugin answered with a gRPC status other than OK
+type grpcStatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *grpcStatusError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.Code, e.Message)
+}
+
+// grpcLogSockets are the sockets whose Logs stream is followed, once per process however
+// often the provider is created (e.g. on configuration reloads)
+var grpcLogSockets sync.Map
+
+// newGRPCProvider creates a gRPC plugin provider
+func newGRPCProvider(config *Config) (*GRPCProvider, error) {
+	socket := config.GRPCSocket
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+
+	stack := newProviderStack(config, providerGRPC)
+	stack.base = transport
+	p := &GRPCProvider{
+		Socket: socket,
+		client: stack.client(),
+		stream: &http.Client{Transport: transport},
+	}
+	p.recordSetClient = newRecordSetClient(p, config)
+	log.Printf("Using gRPC provider plugin at %s", socket)
+
+	if _, following := grpcLogSockets.LoadOrStore(socket, true); !following {
+		go p.followLogs()
+	}
+	if len(config.RecordTags) > 0 {
+		log.Printf("WARNING: %sRECORD_TAGS has no effect with a gRPC plugin - the contract has no tags", envPrefix)
+	}
+	return p, nil
+}
+
+// grpcFrame frames a message as gRPC requires: uncompressed, behind its length
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// grpcRequest returns the request of a call, its message framed
+func grpcRequest(method string, message []byte) (*http.Request, error) {
+	req, err := http.NewRequest("POST", "http://plugin"+grpcServicePath+method, bytes.NewReader(grpcFrame(message)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	return req, nil
+}
+
+// readGRPCMessage reads the next message of a response body (io.EOF after the last one)
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("truncated gRPC message")
+		}
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed gRPC messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > grpcMaxMessage {
+		return nil, fmt.Errorf("gRPC message of %d bytes is too large", length)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, fmt.Errorf("truncated gRPC message")
+	}
+	return message, nil
+}
+
+// grpcStatus returns the error of a finished call's status (from the trailers, or the
+// headers of a response without messages); statuses meaning the plugin or the DNS service
+// behind it cannot be reached wrap errProviderUnreachable
+func grpcStatus(resp *http.Response) error {
+	value := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if value == "" {
+		value, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if value == "" {
+		return fmt.Errorf("the plugin sent no gRPC status (HTTP %s)", resp.Status)
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid gRPC status %q", value)
+	}
+	if code == grpcOK {
+		return nil
+	}
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	statusErr := &grpcStatusError{Code: code, Message: message}
+	if code == grpcUnavailable || code == grpcDeadlineExceeded {
+		return fmt.Errorf("%w: %w", errProviderUnreachable, statusErr)
+	}
+	return statusErr
+}
+
+// call makes a unary call and returns the response message
+func (p *GRPCProvider) call(method string, message []byte) ([]byte, error) {
+	req, err := grpcRequest(method, message)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	response, readErr := readGRPCMessage(resp.Body)
+	io.Copy(io.Discard, io.LimitReader(resp.Body, grpcMaxMessage)) // The trailers follow the body
+	if err := grpcStatus(resp); err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("%s: %w: %v", method, errProviderUnreachable, readErr)
+	}
+	return response, nil
+}
+
+// encodeGRPCRecord encodes a Record message
+func encodeGRPCRecord(r grpcRecord) []byte {
+	var b []byte
+	b = protoAppendString(b, 1, r.ID)
+	b = protoAppendString(b, 2, r.Name)
+	b = protoAppendString(b, 3, r.Type)
+	b = protoAppendString(b, 4, r.Content)
+	b = protoAppendInt(b, 5, r.TTL)
+	return protoAppendBool(b, 6, r.Proxied)
+}
+
+// decodeGRPCRecord decodes a Record message
+func decodeGRPCRecord(data []byte) (grpcRecord, error) {
+	fields, err := protoDecode(data)
+	if err != nil {
+		return grpcRecord{}, err
+	}
+	var r grpcRecord
+	for _, f := range fields {
+		switch f.Num {
+		case 1:
+			r.ID = string(f.Bytes)
+		case 2:
+			r.Name = string(f.Bytes)
+		case 3:
+			r.Type = string(f.Bytes)
+		case 4:
+			r.Content = string(f.Bytes)
+		case 5:
+			r.TTL = int(int32(f.Varint))
+		case 6:
+			r.Proxied = f.Varint != 0
+		}
+	}
+	return r, nil
+}
+
+// encodeGRPCRecordKey encodes a RecordKey message
+func encodeGRPCRecordKey(name, recordType string) []byte {
+	return protoAppendString(protoAppendString(nil, 1, name), 2, recordType)
+}
+
+// records calls a method answering a RecordsResponse
+func (p *GRPCProvider) records(method, name, recordType string) ([]grpcRecord, error) {
+	response, err := p.call(method, encodeGRPCRecordKey(strings.TrimSuffix(name, "."), recordType))
+	if err != nil {
+		return nil, err
+	}
+	fields, err := protoDecode(response)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	var records []grpcRecord
+	for _, f := range fields {
+		if f.Num != 1 {
+			continue
+		}
+		record, err := decodeGRPCRecord(f.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", method, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// change calls a method answering a ChangeResponse
+func (p *GRPCProvider) change(method string, record grpcRecord) error {
+	log.Printf("Plugin Request: %s %s %s", method, record.Type, record.Name)
+	response, err := p.call(method, protoAppendMessage(nil, 1, encodeGRPCRecord(record)))
+	if err != nil {
+		return err
+	}
+	fields, err := protoDecode(response)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	var ok, unreachable bool
+	var message string
+	for _, f := range fields {
+		switch f.Num {
+		case 1:
+			ok = f.Varint != 0
+		case 2:
+			message = string(f.Bytes)
+		case 3:
+			unreachable = f.Varint != 0
+		}
+	}
+	switch {
+	case unreachable:
+		return fmt.Errorf("%w: %s", errProviderUnreachable, message)
+	case !ok:
+		return fmt.Errorf("plugin %s failed: %s", method, message)
+	}
+	return nil
+}
+
+// recordSetBackend implementation
+
+func (p *GRPCProvider) fetchValues(name, recordType string) ([]string, error) {
+	records, err := p.records("GetAllRecords", name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, r := range records {
+		values = append(values, r.Content)
+	}
+	return values, nil
+}
+
+func (p *GRPCProvider) storeValues(name, recordType string, ttl int, values []string) error {
+	return p.storeProxiedValues(name, recordType, ttl, false, values)
+}
+
+// storeProxiedValues changes stale records to missing values in place, then creates or
+// deletes the rest
+func (p *GRPCProvider) storeProxiedValues(name, recordType string, ttl int, proxied bool, values []string) error {
+	name = strings.TrimSuffix(name, ".")
+	existing, err := p.records("GetAllRecords", name, recordType)
+	if err != nil {
+		return err
+	}
+	wanted := make([]string, len(values))
+	for i, v := range values {
+		wanted[i] = porkbunContent(recordType, v)
+	}
+	kept, stale := matchValues(existing, wanted, func(r grpcRecord, w string) bool { return sameContent(r.Content, w) })
+
+	for i, w := range wanted {
+		if kept[i] {
+			continue
+		}
+		record := grpcRecord{Name: name, Type: recordType, Content: w, TTL: ttl, Proxied: proxied}
+		method := "CreateRecord"
+		if len(stale) > 0 {
+			record.ID, stale = stale[0].ID, stale[1:]
+			method = "UpdateRecord"
+		}
+		if err := p.change(method, record); err != nil {
+			return err
+		}
+	}
+	for _, r := range stale {
+		if err := p.change("DeleteRecord", grpcRecord{ID: r.ID, Name: name, Type: recordType}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *GRPCProvider) listRecords(recordType string) ([]CFRecord, error) {
+	records, err := p.records("ListRecords", "", recordType)
+	if err != nil {
+		return []CFRecord{}, err
+	}
+	result := []CFRecord{}
+	for _, r := range records {
+		if r.Type == recordType {
+			result = append(result, valueRecords(r.Name, recordType, []string{r.Content})...)
+		}
+	}
+	return result, nil
+}
+
+// probe reports whether the plugin and the DNS service behind it answer; a plugin that
+// does not implement Probe counts as reachable once it answers at all
+func (p *GRPCProvider) probe() bool {
+	response, err := p.call("Probe", nil)
+	var status *grpcStatusError
+	if errors.As(err, &status) && status.Code == grpcUnimplemented {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	fields, err := protoDecode(response)
+	if err != nil {
+		return false
+	}
+	for _, f := range fields {
+		if f.Num == 1 {
+			return f.Varint != 0
+		}
+	}
+	return false
+}
+
+// followLogs copies the plugin's log lines into the log for as long as the process runs,
+// opening the stream again whenever it ends; plugins without Logs are left alone
+func (p *GRPCProvider) followLogs() {
+	for {
+		err := p.streamLogs()
+		var status *grpcStatusError
+		if errors.As(err, &status) && status.Code == grpcUnimplemented {
+			return
+		}
+		time.Sleep(grpcLogsRetry)
+	}
+}
+
+// streamLogs reads one Logs stream until it ends
+func (p *GRPCProvider) streamLogs() error {
+	req, err := grpcRequest("Logs", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.stream.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	for {
+		message, err := readGRPCMessage(resp.Body)
+		if err == io.EOF {
+			return grpcStatus(resp)
+		}
+		if err != nil {
+			return err
+		}
+		fields, err := protoDecode(message)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if f.Num == 1 {
+				log.Printf("Plugin: %s", f.Bytes)
+			}
+		}
+	}
+}
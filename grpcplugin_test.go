@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// grpcTestPlugin is a DNS service behind the DNSProvider contract, answering like a
+// plugin built from the generated stubs
+type grpcTestPlugin struct {
+	mu      sync.Mutex
+	records []grpcRecord
+	nextID  int
+	calls   []string // Methods and record IDs of the changes
+	status  int      // Status answered to every call, if not OK
+	logs    []string // Lines sent by Logs (UNIMPLEMENTED if none)
+}
+
+// newGRPCTestProvider serves a plugin on a unix socket and returns a provider calling it
+func newGRPCTestProvider(t *testing.T) (*GRPCProvider, *grpcTestPlugin) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "grpc") // Short, for the socket path limit
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "plugin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin := &grpcTestPlugin{}
+	server := &http.Server{Handler: plugin, Protocols: new(http.Protocols)}
+	server.Protocols.SetUnencryptedHTTP2(true)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	grpcLogSockets.Store(socket, true) // Tests read the Logs stream themselves
+	p, err := newGRPCProvider(&Config{GRPCSocket: socket, RecordTTL: 120, HeartbeatTTL: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, plugin
+}
+
+func (g *grpcTestPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, _ := strings.CutPrefix(r.URL.Path, grpcServicePath)
+	request, err := readGRPCMessage(r.Body)
+	if err != nil || r.Header.Get("Content-Type") != "application/grpc" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	fields, _ := protoDecode(request)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fail := func(code int, message string) {
+		// Trailers-only response, as gRPC servers send for a call failing without a message
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", fmt.Sprint(code))
+		w.Header().Set("Grpc-Message", message)
+		w.WriteHeader(http.StatusOK)
+	}
+	if g.status != grpcOK {
+		fail(g.status, "plugin%20status")
+		return
+	}
+
+	var responses [][]byte
+	var key, change grpcRecord
+	for _, f := range fields {
+		if method == "CreateRecord" || method == "UpdateRecord" || method == "DeleteRecord" {
+			change, _ = decodeGRPCRecord(f.Bytes)
+		} else if f.Num == 1 {
+			key.Name = string(f.Bytes)
+		} else if f.Num == 2 {
+			key.Type = string(f.Bytes)
+		}
+	}
+	switch method {
+	case "GetAllRecords", "ListRecords":
+		var response []byte
+		for _, rec := range g.records {
+			if rec.Type == key.Type && (method == "ListRecords" || rec.Name == key.Name) {
+				response = protoAppendMessage(response, 1, encodeGRPCRecord(rec))
+			}
+		}
+		responses = append(responses, response)
+	case "CreateRecord":
+		g.nextID++
+		change.ID = fmt.Sprint(g.nextID)
+		g.records = append(g.records, change)
+		responses = append(responses, protoAppendBool(nil, 1, true))
+	case "UpdateRecord", "DeleteRecord":
+		g.calls = append(g.calls, method+" "+change.ID)
+		kept := g.records[:0]
+		for _, rec := range g.records {
+			switch {
+			case rec.ID != change.ID:
+				kept = append(kept, rec)
+			case method == "UpdateRecord":
+				kept = append(kept, change)
+			}
+		}
+		g.records = kept
+		responses = append(responses, protoAppendBool(nil, 1, true))
+	case "Probe":
+		responses = append(responses, protoAppendBool(nil, 1, true))
+	case "Logs":
+		if len(g.logs) == 0 {
+			fail(grpcUnimplemented, "")
+			return
+		}
+		for _, line := range g.logs {
+			responses = append(responses, protoAppendString(nil, 1, line))
+		}
+	default:
+		fail(grpcUnimplemented, "unknown method "+method)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	for _, response := range responses {
+		frame := make([]byte, 5)
+		frame[4] = byte(len(response)) // Test messages are shorter than 256 bytes
+		w.Write(append(frame, response...))
+	}
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// grpcTestRecords returns the plugin's records as name/type/content/ttl, sorted
+func (g *grpcTestPlugin) grpcTestRecords() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var records []string
+	for _, r := range g.records {
+		records = append(records, fmt.Sprintf("%s/%s/%s/%d", r.Name, r.Type, r.Content, r.TTL))
+	}
+	sort.Strings(records)
+	return records
+}
+
+func TestGRPCRecordEncoding(t *testing.T) {
+	// As protoc-generated code encodes Record{id: "1", name: "a", ttl: 120, proxied: true}
+	encoded := encodeGRPCRecord(grpcRecord{ID: "1", Name: "a", TTL: 120, Proxied: true})
+	if want := []byte{0x0a, 0x01, '1', 0x12, 0x01, 'a', 0x28, 0x78, 0x30, 0x01}; !bytes.Equal(encoded, want) {
+		t.Errorf("encoded = % x, want % x", encoded, want)
+	}
+	decoded, err := decodeGRPCRecord(append(encoded, 0x3d, 1, 2, 3, 4)) // Unknown fixed32 field 7
+	if err != nil || decoded != (grpcRecord{ID: "1", Name: "a", TTL: 120, Proxied: true}) {
+		t.Errorf("decoded = %+v, %v", decoded, err)
+	}
+	if _, err := decodeGRPCRecord(encoded[:4]); !errors.Is(err, errProtoTruncated) {
+		t.Errorf("truncated: err = %v", err)
+	}
+}
+
+func TestGRPCRecordOperations(t *testing.T) {
+	p, plugin := newGRPCTestProvider(t)
+
+	for _, ip := range []string{"192.168.1.10", "192.168.1.11"} {
+		if p.ensureRecordExists("nas.example.com", "A", ip, false) != nil {
+			t.Fatalf("ensureRecordExists(%s) failed", ip)
+		}
+	}
+	if p.upsertHeartbeat("nas.example.com", `"1700000000"`) != nil {
+		t.Fatal("upsertHeartbeat failed")
+	}
+	want := []string{"nas.example.com/A/192.168.1.10/120", "nas.example.com/A/192.168.1.11/120", "nas.example.com/TXT/1700000000/3600"}
+	if records := plugin.grpcTestRecords(); !reflect.DeepEqual(records, want) {
+		t.Fatalf("records = %v, want %v", records, want)
+	}
+
+	listed, _ := p.getAllRecordsByType("TXT")
+	if len(listed) != 1 || listed[0].Name != "nas.example.com" || listed[0].Content != "1700000000" {
+		t.Errorf("listed = %+v", listed)
+	}
+
+	// A changed address updates the record in place
+	if p.upsertRecord("home.example.com", "A", "203.0.113.1", false) != nil || p.upsertRecord("home.example.com", "A", "203.0.113.2", false) != nil {
+		t.Fatal("upsertRecord failed")
+	}
+	if p.deleteRecord("192.168.1.11", "nas.example.com", "A") != nil {
+		t.Fatal("deleteRecord failed")
+	}
+	want = []string{"home.example.com/A/203.0.113.2/120", "nas.example.com/A/192.168.1.10/120", "nas.example.com/TXT/1700000000/3600"}
+	if records := plugin.grpcTestRecords(); !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %v, want %v", records, want)
+	}
+	if want := []string{"UpdateRecord 4", "DeleteRecord 2"}; !reflect.DeepEqual(plugin.calls, want) {
+		t.Errorf("calls = %v, want %v", plugin.calls, want)
+	}
+	if !p.probeConnectivity() {
+		t.Error("probe failed")
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	p, plugin := newGRPCTestProvider(t)
+
+	plugin.status = grpcUnavailable
+	if err := p.storeValues("nas.example.com", "A", 120, []string{"192.0.2.1"}); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("err = %v, want unreachable", err)
+	}
+	if p.probeConnectivity() {
+		t.Error("probe succeeded while unavailable")
+	}
+
+	plugin.status = 13 // INTERNAL
+	_, err := p.fetchValues("nas.example.com", "A")
+	var status *grpcStatusError
+	if !errors.As(err, &status) || status.Code != 13 || status.Message != "plugin status" || errors.Is(err, errProviderUnreachable) {
+		t.Errorf("err = %v, want status 13", err)
+	}
+
+	// A plugin without Probe is reachable once it answers
+	plugin.status = grpcUnimplemented
+	if !p.probeConnectivity() {
+		t.Error("probe failed with Probe unimplemented")
+	}
+
+	// A plugin that is not running is unreachable
+	socket := filepath.Join(filepath.Dir(p.Socket), "stopped.sock")
+	grpcLogSockets.Store(socket, true)
+	stopped, _ := newGRPCProvider(&Config{GRPCSocket: socket})
+	if _, err := stopped.fetchValues("nas.example.com", "A"); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("err = %v, want unreachable without the plugin", err)
+	}
+}
+
+func TestGRPCLogs(t *testing.T) {
+	p, plugin := newGRPCTestProvider(t)
+	buf := captureLogs(t, logFormatPlain, "info")
+
+	plugin.logs = []string{"connected to the DNS API", "token expires in 3 days"}
+	if err := p.streamLogs(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Plugin: connected to the DNS API\n", "Plugin: token expires in 3 days\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, buf.String())
+		}
+	}
+
+	plugin.logs = nil
+	var status *grpcStatusError
+	if err := p.streamLogs(); !errors.As(err, &status) || status.Code != grpcUnimplemented {
+		t.Errorf("err = %v, want UNIMPLEMENTED", err)
+	}
+}
+
+func TestGRPCSetting(t *testing.T) {
+	t.Setenv(envPrefix+"PROVIDER", providerGRPC)
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+	if _, err := reloadConfig(false); err == nil || !strings.Contains(err.Error(), "GRPC_SOCKET") {
+		t.Errorf("without a socket: err = %v", err)
+	}
+
+	t.Setenv(envPrefix+"GRPC_SOCKET", "/run/dns-plugin/plugin.sock")
+	config, err := reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.GRPCSocket != "/run/dns-plugin/plugin.sock" || config.CFAPIToken != "" {
+		t.Errorf("config = %+v", config)
+	}
+}
+
+// readGRPCMessage must not trust the length of a message
+func TestReadGRPCMessageLimits(t *testing.T) {
+	if _, err := readGRPCMessage(bytes.NewReader([]byte{0, 0xff, 0xff, 0xff, 0xff})); err == nil {
+		t.Error("accepted a 4 GB message")
+	}
+	if _, err := readGRPCMessage(bytes.NewReader([]byte{0, 0, 0, 0, 5, 1})); err == nil || err == io.EOF {
+		t.Errorf("truncated message: err = %v", err)
+	}
+	if _, err := readGRPCMessage(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("end of stream: err = %v", err)
+	}
+}
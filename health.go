@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health endpoints. With HEALTH_LISTEN set, daemon and serve modes answer healthchecks on
+// their own listener:
+//
+//   - /healthz (liveness) fails when no update cycle has finished for HEALTH_MAX_AGE_SECONDS
+//     (default three update intervals), i.e. the daemon is wedged and should be restarted
+//   - /readyz (readiness) additionally fails until a cycle has finished, and while the last
+//     one failed to update some of its records
+//
+// Both answer 200 or 503 with a JSON body describing the last cycle, so Kubernetes probes and
+// docker-compose healthchecks (curl -f) can use them as they are. Failed cycles do not fail
+// /healthz: restarting does not fix an unreachable API.
+
+// Statuses reported by the health endpoints
+const (
+	healthOK       = "ok"
+	healthStale    = "stale"
+	healthStarting = "starting"
+	healthFailing  = "failing"
+)
+
+// healthEndpoint tracks the update cycles and serves /healthz and /readyz
+type healthEndpoint struct {
+	mu          sync.Mutex
+	maxAge      time.Duration
+	started     time.Time
+	lastCycle   time.Time // When the last cycle finished (zero before the first)
+	lastSuccess time.Time // When the last cycle updating every record finished
+	succeeded   int
+	attempted   int
+	now         func() time.Time // nil = time.Now
+}
+
+// healthReport is the JSON body of the health endpoints
+type healthReport struct {
+	Status              string `json:"status"`
+	LastCycle           int64  `json:"last_cycle,omitempty"`             // Unix time the last cycle finished
+	LastCycleAgeSeconds int64  `json:"last_cycle_age_seconds,omitempty"` // Seconds since then
+	LastSuccess         int64  `json:"last_success,omitempty"`           // Unix time of the last fully successful cycle
+	Succeeded           int    `json:"succeeded"`                        // Records updated by the last cycle
+	Attempted           int    `json:"attempted"`                        // Records the last cycle tried to update
+	MaxAgeSeconds       int64  `json:"max_age_seconds"`
+}
+
+// newHealthEndpoint returns the health endpoint of a daemon updating every interval
+func newHealthEndpoint(config *Config, interval time.Duration) *healthEndpoint {
+	maxAge := time.Duration(config.HealthMaxAge) * time.Second
+	if maxAge <= 0 {
+		maxAge = 3 * interval
+	}
+	return &healthEndpoint{maxAge: maxAge, started: time.Now()}
+}
+
+func (h *healthEndpoint) clock() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+// recordCycle records a finished update cycle (h may be nil)
+func (h *healthEndpoint) recordCycle(succeeded, attempted int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastCycle = h.clock()
+	h.succeeded, h.attempted = succeeded, attempted
+	if succeeded == attempted {
+		h.lastSuccess = h.lastCycle
+	}
+}
+
+// report describes the daemon's health; ready adds the readiness conditions
+func (h *healthEndpoint) report(ready bool) (healthReport, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.clock()
+	report := healthReport{Status: healthOK, Succeeded: h.succeeded, Attempted: h.attempted, MaxAgeSeconds: int64(h.maxAge / time.Second)}
+	since := h.started
+	if !h.lastCycle.IsZero() {
+		since = h.lastCycle
+		report.LastCycle = h.lastCycle.Unix()
+		report.LastCycleAgeSeconds = int64(now.Sub(h.lastCycle) / time.Second)
+	}
+	if !h.lastSuccess.IsZero() {
+		report.LastSuccess = h.lastSuccess.Unix()
+	}
+
+	switch {
+	case now.Sub(since) > h.maxAge:
+		report.Status = healthStale
+	case !ready:
+	case h.lastCycle.IsZero():
+		report.Status = healthStarting
+	case h.succeeded != h.attempted:
+		report.Status = healthFailing
+	}
+	return report, report.Status == healthOK
+}
+
+func (h *healthEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/healthz" && r.URL.Path != "/readyz" {
+		http.NotFound(w, r)
+		return
+	}
+	report, ok := h.report(r.URL.Path == "/readyz")
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// start serves the endpoints on config.HealthListen in the background (if set)
+func (h *healthEndpoint) start(config *Config) {
+	if config.HealthListen == "" {
+		return
+	}
+	server := &http.Server{Addr: config.HealthListen, Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("Serving health checks on http://%s/healthz and /readyz (stale after %s)", config.HealthListen, h.maxAge)
+		log.Fatalf("ERROR: Health endpoint stopped: %v", server.ListenAndServe())
+	}()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoint(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	h := newHealthEndpoint(&Config{}, 5*time.Minute)
+	h.started, h.now = clock.Now(), clock.Now
+
+	check := func(path string, wantCode int, wantStatus string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var report healthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: %v (%s)", path, err, rec.Body)
+		}
+		if rec.Code != wantCode || report.Status != wantStatus {
+			t.Errorf("%s = %d %q, want %d %q", path, rec.Code, report.Status, wantCode, wantStatus)
+		}
+	}
+
+	check("/healthz", 200, healthOK)
+	check("/readyz", 503, healthStarting)
+
+	clock.advance(time.Minute)
+	h.recordCycle(3, 3)
+	check("/healthz", 200, healthOK)
+	check("/readyz", 200, healthOK)
+
+	clock.advance(5 * time.Minute)
+	h.recordCycle(2, 3)
+	check("/healthz", 200, healthOK)
+	check("/readyz", 503, healthFailing)
+	if report, _ := h.report(true); report.LastSuccess != 1700000060 || report.Succeeded != 2 || report.Attempted != 3 {
+		t.Errorf("report = %+v", report)
+	}
+
+	clock.advance(16 * time.Minute) // More than three intervals without a cycle
+	check("/healthz", 503, healthStale)
+	check("/readyz", 503, healthStale)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/other", nil))
+	if rec.Code != 404 {
+		t.Errorf("status for /other = %d, want 404", rec.Code)
+	}
+}
+
+func TestHealthEndpointMaxAge(t *testing.T) {
+	if h := newHealthEndpoint(&Config{HealthMaxAge: 60}, 5*time.Minute); h.maxAge != time.Minute {
+		t.Errorf("maxAge = %s, want 1m", h.maxAge)
+	}
+	var h *healthEndpoint
+	h.recordCycle(1, 1) // Nil endpoints ignore cycles
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Heartbeat payloads. HEARTBEAT_FORMAT picks the serializer writing the heartbeat TXT record:
+//
+//   - timestamp (default): the Unix time of the run, "1699564820"
+//   - json: {"ts":1699564820,"host":"nas","v":1}, split into 255-byte TXT strings when
+//     longer, so that later versions can carry more metadata
+//
+// Reading does not depend on the setting: every serializer is tried, so the cleanup service
+// understands hosts using either format. JSON fields a version does not know are ignored,
+// and content without a timestamp is not a heartbeat, so other TXT records at managed names
+// are still left alone.
+
+// Supported values for HEARTBEAT_FORMAT
+const (
+	heartbeatFormatTimestamp = "timestamp"
+	heartbeatFormatJSON      = "json"
+)
+
+// heartbeatPayloadVersion is the "v" of JSON heartbeats written by this version
+const heartbeatPayloadVersion = 1
+
+// heartbeatPayload is what a heartbeat says about its host
+type heartbeatPayload struct {
+	Timestamp int64  `json:"ts"`
+	Host      string `json:"host,omitempty"`
+	Version   int    `json:"v,omitempty"`
+}
+
+// heartbeatSerializer writes and reads one heartbeat format. decode gets the record's
+// character-strings joined, and reports false for content in another format.
+type heartbeatSerializer interface {
+	encode(payload heartbeatPayload) string
+	decode(text string) (heartbeatPayload, bool)
+}
+
+// heartbeatSerializers are tried in this order when reading a heartbeat
+var heartbeatSerializers = []struct {
+	name       string
+	serializer heartbeatSerializer
+}{
+	{heartbeatFormatTimestamp, timestampHeartbeat{}},
+	{heartbeatFormatJSON, jsonHeartbeat{}},
+}
+
+// heartbeatSerializerFor returns the serializer of a HEARTBEAT_FORMAT value
+func heartbeatSerializerFor(format string) (heartbeatSerializer, bool) {
+	for _, s := range heartbeatSerializers {
+		if s.name == format {
+			return s.serializer, true
+		}
+	}
+	return nil, false
+}
+
+// parseHeartbeatFormat reads HEARTBEAT_FORMAT
+func parseHeartbeatFormat() string {
+	format := strings.ToLower(getEnvOrDefault("HEARTBEAT_FORMAT", heartbeatFormatTimestamp))
+	if _, ok := heartbeatSerializerFor(format); !ok {
+		names := make([]string, len(heartbeatSerializers))
+		for i, s := range heartbeatSerializers {
+			names[i] = s.name
+		}
+		configFatalf("ERROR: %sHEARTBEAT_FORMAT must be one of %s, got %q", envPrefix, strings.Join(names, ", "), format)
+	}
+	return format
+}
+
+// heartbeatHost returns the host name JSON heartbeats carry: TAG_HOST, or the hostname
+func heartbeatHost() string {
+	if host := getEnv("TAG_HOST"); host != "" {
+		return host
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// heartbeatRecordContent returns the heartbeat TXT content of a run at now, in the
+// configured format
+func heartbeatRecordContent(config *Config, now time.Time) string {
+	serializer, ok := heartbeatSerializerFor(config.HeartbeatFormat)
+	if !ok {
+		serializer = timestampHeartbeat{}
+	}
+	return serializer.encode(heartbeatPayload{Timestamp: now.Unix(), Host: config.HeartbeatHost, Version: heartbeatPayloadVersion})
+}
+
+// decodeHeartbeat reads heartbeat TXT content in any format
+func decodeHeartbeat(content string) (heartbeatPayload, bool) {
+	text := strings.Join(parseTXTStrings(content), "")
+	for _, s := range heartbeatSerializers {
+		if payload, ok := s.serializer.decode(text); ok {
+			return payload, true
+		}
+	}
+	return heartbeatPayload{}, false
+}
+
+// timestampHeartbeat is the original format: only the time of the run
+type timestampHeartbeat struct{}
+
+func (timestampHeartbeat) encode(payload heartbeatPayload) string {
+	return heartbeatContent(time.Unix(payload.Timestamp, 0))
+}
+
+func (timestampHeartbeat) decode(text string) (heartbeatPayload, bool) {
+	if text == "" || len(text) > 18 {
+		return heartbeatPayload{}, false
+	}
+	for _, r := range text {
+		if r < '0' || r > '9' {
+			return heartbeatPayload{}, false
+		}
+	}
+	timestamp, err := strconv.ParseInt(text, 10, 64)
+	return heartbeatPayload{Timestamp: timestamp}, err == nil && timestamp > 0
+}
+
+// jsonHeartbeat is a JSON object with the time of the run and metadata about the host
+type jsonHeartbeat struct{}
+
+func (jsonHeartbeat) encode(payload heartbeatPayload) string {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		// Not reached: the payload only holds strings and numbers
+		return heartbeatContent(time.Unix(payload.Timestamp, 0))
+	}
+	return formatTXTStrings(splitTXTChunks(string(data)))
+}
+
+func (jsonHeartbeat) decode(text string) (heartbeatPayload, bool) {
+	if !strings.HasPrefix(text, "{") {
+		return heartbeatPayload{}, false
+	}
+	var payload heartbeatPayload
+	if err := json.Unmarshal([]byte(text), &payload); err != nil || payload.Timestamp <= 0 {
+		return heartbeatPayload{}, false
+	}
+	return payload, true
+}
+
+// splitTXTChunks splits text into character-strings of at most txtStringMaxLen bytes,
+// without cutting a UTF-8 sequence in two
+func splitTXTChunks(text string) []string {
+	var chunks []string
+	for len(text) > txtStringMaxLen {
+		cut := txtStringMaxLen
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	return append(chunks, text)
+}
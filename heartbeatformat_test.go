@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatFormatsRoundTrip(t *testing.T) {
+	now := time.Unix(1699564820, 0)
+	for _, format := range []string{heartbeatFormatTimestamp, heartbeatFormatJSON} {
+		config := &Config{HeartbeatFormat: format, HeartbeatHost: "nas"}
+		content := heartbeatRecordContent(config, now)
+		payload, ok := decodeHeartbeat(content)
+		if !ok || payload.Timestamp != now.Unix() {
+			t.Errorf("%s: decodeHeartbeat(%s) = %+v, %v", format, content, payload, ok)
+		}
+		if timestamp, ok := parseHeartbeat(content); !ok || timestamp != now.Unix() {
+			t.Errorf("%s: parseHeartbeat(%s) = %d, %v", format, content, timestamp, ok)
+		}
+	}
+
+	content := heartbeatRecordContent(&Config{HeartbeatFormat: heartbeatFormatJSON, HeartbeatHost: "nas"}, now)
+	if want := `"{\"ts\":1699564820,\"host\":\"nas\",\"v\":1}"`; content != want {
+		t.Errorf("JSON heartbeat = %s, want %s", content, want)
+	}
+	if payload, _ := decodeHeartbeat(content); payload.Host != "nas" || payload.Version != heartbeatPayloadVersion {
+		t.Errorf("JSON heartbeat decoded as %+v", payload)
+	}
+}
+
+func TestJSONHeartbeatChunking(t *testing.T) {
+	host := strings.Repeat("h", 300) + "é"
+	content := jsonHeartbeat{}.encode(heartbeatPayload{Timestamp: 1699564820, Host: host, Version: 1})
+	chunks := parseTXTStrings(content)
+	if len(chunks) != 2 {
+		t.Fatalf("got %d TXT strings, want 2: %s", len(chunks), content)
+	}
+	for _, chunk := range chunks {
+		if len(chunk) > txtStringMaxLen {
+			t.Errorf("TXT string of %d bytes", len(chunk))
+		}
+	}
+	if payload, ok := decodeHeartbeat(content); !ok || payload.Host != host {
+		t.Errorf("chunked heartbeat decoded as %+v, %v", payload, ok)
+	}
+	if chunks := splitTXTChunks(strings.Repeat("a", 254) + "é"); len(chunks) != 2 || chunks[0] != strings.Repeat("a", 254) {
+		t.Errorf("split a UTF-8 sequence: %q", chunks)
+	}
+}
+
+func TestDecodeHeartbeatIgnoresOtherContent(t *testing.T) {
+	for content, want := range map[string]bool{
+		`1699564820`:        true,
+		`{"ts":1699564820}`: true,
+		`"{\"ts\":1699564820,\"host\":\"nas\",\"v\":7,\"site\":\"home\"}"`: true, // A later version
+		`{"ts":0,"host":"nas"}`:        false,
+		`{"host":"nas"}`:               false,
+		`{"site":"home"}`:              false,
+		`{not json`:                    false,
+		`"v=spf1 include:example.com"`: false,
+		`google-site-verification=abc`: false,
+	} {
+		if _, ok := decodeHeartbeat(content); ok != want {
+			t.Errorf("decodeHeartbeat(%s) = %v, want %v", content, ok, want)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// HistoryEntry is one line of the signed change journal (BEES_IP_UPDATE_HISTORY_FILE).
+// Entries form a hash chain like a certificate transparency log: each names the SHA-256 of
+// the previous line, so rewriting or removing an entry breaks every entry after it, and
+// each is signed with an Ed25519 key, so the chain cannot be rebuilt without the key.
+type HistoryEntry struct {
+	Seq       int             `json:"seq"`
+	Timestamp int64           `json:"ts"`
+	Changes   []HistoryChange `json:"changes"`
+	Detection *IPAddresses    `json:"detection,omitempty"` // Addresses the run reconciled to, for -replay (none for relayed changes)
+	Prev      string          `json:"prev"`                // Hex SHA-256 of the previous line ("" for the first entry)
+	Signature string          `json:"sig,omitempty"`       // Base64 Ed25519 signature of the entry without this field
+}
+
+// HistoryChange is one record change of a journal entry
+type HistoryChange struct {
+	Action  string `json:"action"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// signedBytes returns the encoding of the entry that is signed
+func (e HistoryEntry) signedBytes() ([]byte, error) {
+	e.Signature = ""
+	return json.Marshal(e)
+}
+
+// historyHash returns the hex SHA-256 of a journal line, as named by the next entry
+func historyHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadSigningKey reads an Ed25519 private key in PKCS#8 PEM format, as written by
+// "openssl genpkey -algorithm ed25519"
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return priv, nil
+}
+
+// loadVerifyKey reads an Ed25519 public key in PEM format ("openssl pkey -pubout"); a
+// private key is accepted too
+func loadVerifyKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	if block.Type != "PUBLIC KEY" {
+		priv, err := loadSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return priv.Public().(ed25519.PublicKey), nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return pub, nil
+}
+
+// lastHistoryLine returns the last line of the journal (nil if it is missing or empty)
+func lastHistoryLine(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\n")
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// historyMu serializes appends, which read the last entry to chain the next one to it
+var historyMu sync.Mutex
+
+// appendHistory appends a signed entry holding the changes and the detection they were
+// made for (may be nil) to the journal
+func appendHistory(path string, key ed25519.PrivateKey, changes []RecordChange, ips *IPAddresses, now time.Time) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	last, err := lastHistoryLine(path)
+	if err != nil {
+		return err
+	}
+	entry := HistoryEntry{Timestamp: now.Unix(), Detection: ips}
+	if last != nil {
+		var prev HistoryEntry
+		if err := json.Unmarshal(last, &prev); err != nil {
+			return fmt.Errorf("%s: last entry: %w", path, err)
+		}
+		entry.Seq = prev.Seq + 1
+		entry.Prev = historyHash(last)
+	}
+	for _, c := range changes {
+		entry.Changes = append(entry.Changes, HistoryChange{Action: c.Action, Type: c.Type, Name: c.Name, Content: c.Content})
+	}
+
+	signed, err := entry.signedBytes()
+	if err != nil {
+		return err
+	}
+	entry.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, signed))
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// recordHistory appends this run's changes and detection to the journal, if one is configured
+func recordHistory(config *Config, changes []RecordChange, ips *IPAddresses) {
+	if config.HistoryFile == "" || len(changes) == 0 {
+		return
+	}
+	key, err := loadSigningKey(config.HistorySigningKey)
+	if err == nil {
+		err = appendHistory(config.HistoryFile, key, changes, ips, config.now())
+	}
+	if err != nil {
+		log.Printf("WARNING: Could not record changes in the history journal: %v", err)
+	}
+}
+
+// verifyHistory checks the signature and chaining of every entry of a journal, returning
+// the number of entries and the hash of the last line
+func verifyHistory(r io.Reader, pub ed25519.PublicKey) (int, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	count, head := 0, ""
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return count, head, fmt.Errorf("entry %d: %w", count, err)
+		}
+		if entry.Seq != count {
+			return count, head, fmt.Errorf("entry %d has sequence number %d (entries removed or reordered)", count, entry.Seq)
+		}
+		if entry.Prev != head {
+			return count, head, fmt.Errorf("entry %d does not follow the previous entry (history rewritten)", count)
+		}
+		sig, err := base64.StdEncoding.DecodeString(entry.Signature)
+		if err != nil {
+			return count, head, fmt.Errorf("entry %d: invalid signature encoding", count)
+		}
+		signed, err := entry.signedBytes()
+		if err != nil {
+			return count, head, err
+		}
+		if !ed25519.Verify(pub, signed, sig) {
+			return count, head, fmt.Errorf("entry %d has an invalid signature", count)
+		}
+		count++
+		head = historyHash(line)
+	}
+	return count, head, scanner.Err()
+}
+
+// runVerifyHistory implements "dynipupdate verify-history"
+func runVerifyHistory(args []string) int {
+	fs := flag.NewFlagSet("verify-history", flag.ContinueOnError)
+	file := fs.String("file", "", "Journal to verify (default: "+envPrefix+"HISTORY_FILE)")
+	keyPath := fs.String("key", "", "Ed25519 public key in PEM format (default: the key of "+envPrefix+"HISTORY_SIGNING_KEY)")
+	head := fs.String("head", "", "Previously recorded head hash that must still be part of the journal")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *file == "" || *keyPath == "" {
+		config := readConfig(false, false)
+		if *file == "" {
+			*file = config.HistoryFile
+		}
+		if *keyPath == "" {
+			*keyPath = config.HistorySigningKey
+		}
+	}
+	if *file == "" || *keyPath == "" {
+		fmt.Fprintf(os.Stderr, "Set %sHISTORY_FILE and %sHISTORY_SIGNING_KEY, or pass -file and -key\n", envPrefix, envPrefix)
+		return 2
+	}
+
+	pub, err := loadVerifyKey(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading key: %v\n", err)
+		return 1
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	count, last, err := verifyHistory(f, pub)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "History verification FAILED: %v\n", err)
+		return 1
+	}
+	if *head != "" {
+		if err := historyContains(*file, *head); err != nil {
+			fmt.Fprintf(os.Stderr, "History verification FAILED: %v\n", err)
+			return 1
+		}
+	}
+	fmt.Printf("%d entries verified, head %s\n", count, last)
+	return 0
+}
+
+// historyContains checks that a line of the journal has the given hash, i.e. that the
+// history up to a previously recorded head is still present
+func historyContains(path, head string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if historyHash(scanner.Bytes()) == head {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("head " + head + " is no longer part of the journal (history truncated or rewritten)")
+}
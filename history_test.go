@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTestSigningKey writes a new Ed25519 key in PKCS#8 PEM format and returns its path
+func writeTestSigningKey(t *testing.T, dir string) (string, ed25519.PrivateKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "history.key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, priv
+}
+
+func TestHistoryJournal(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, _ := writeTestSigningKey(t, dir)
+	config := &Config{
+		HistoryFile:       filepath.Join(dir, "history.jsonl"),
+		HistorySigningKey: keyPath,
+		clock:             newFakeClock(time.Unix(1700000000, 0)),
+	}
+
+	recordHistory(config, nil, nil) // Runs without changes are not recorded
+	recordHistory(config, []RecordChange{{Action: "create", Type: "A", Name: "nas.example.com", Content: "203.0.113.1"}}, nil)
+	recordHistory(config, []RecordChange{{Action: "update", Type: "A", Name: "nas.example.com", Content: "203.0.113.2"}}, nil)
+	recordHistory(config, []RecordChange{{Action: "delete", Type: "AAAA", Name: "nas.example.com", Content: "2001:db8::1"}}, nil)
+
+	pub, err := loadVerifyKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(config.HistoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, head, err := verifyHistory(bytes.NewReader(data), pub)
+	if err != nil || count != 3 {
+		t.Fatalf("verifyHistory = %d, %v", count, err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	if head != historyHash([]byte(lines[2])) {
+		t.Errorf("head = %s, want the hash of the last line", head)
+	}
+	if err := historyContains(config.HistoryFile, historyHash([]byte(strings.TrimSuffix(lines[1], "\n")))); err != nil {
+		t.Errorf("historyContains(entry 1) = %v", err)
+	}
+	if err := historyContains(config.HistoryFile, strings.Repeat("0", 64)); err == nil {
+		t.Error("historyContains accepted an unknown head")
+	}
+
+	tampered := []struct {
+		name    string
+		journal string
+		wantErr string
+	}{
+		{"edited content", strings.Replace(string(data), "203.0.113.2", "198.51.100.2", 1), "invalid signature"},
+		{"removed entry", lines[0] + lines[2], "sequence number"},
+		{"reordered entries", lines[1] + lines[0] + lines[2], "sequence number"},
+	}
+	for _, tt := range tampered {
+		if _, _, err := verifyHistory(strings.NewReader(tt.journal), pub); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: verifyHistory = %v, want error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// A journal signed by another key does not verify
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	if _, _, err := verifyHistory(bytes.NewReader(data), otherPriv.Public().(ed25519.PublicKey)); err == nil {
+		t.Error("journal verified with another key")
+	}
+}
+
+func TestHistoryRewrittenChain(t *testing.T) {
+	dir := t.TempDir()
+	_, priv := writeTestSigningKey(t, dir)
+	path := filepath.Join(dir, "history.jsonl")
+	now := time.Unix(1700000000, 0)
+	for _, content := range []string{"203.0.113.1", "203.0.113.2"} {
+		if err := appendHistory(path, priv, []RecordChange{{Action: "update", Type: "A", Name: "nas.example.com", Content: content}}, nil, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Re-signing an edited entry with the key still breaks the link from the next entry
+	data, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	rewritten := filepath.Join(dir, "rewritten.jsonl")
+	if err := appendHistory(rewritten, priv, []RecordChange{{Action: "update", Type: "A", Name: "nas.example.com", Content: "198.51.100.1"}}, nil, now); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := os.ReadFile(rewritten)
+	_, _, err := verifyHistory(strings.NewReader(string(first)+lines[1]), priv.Public().(ed25519.PublicKey))
+	if err == nil || !strings.Contains(err.Error(), "history rewritten") {
+		t.Errorf("verifyHistory of a rewritten chain = %v", err)
+	}
+}
+
+// Concurrent appends (relay agents) must still form one chain
+func TestHistoryConcurrentAppends(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, _ := writeTestSigningKey(t, dir)
+	config := &Config{HistoryFile: filepath.Join(dir, "history.jsonl"), HistorySigningKey: keyPath}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recordHistory(config, []RecordChange{{Action: "update", Type: "A", Name: "nas.example.com", Content: "203.0.113.1"}}, nil)
+		}()
+	}
+	wg.Wait()
+
+	pub, err := loadVerifyKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(config.HistoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _, err := verifyHistory(bytes.NewReader(data), pub); err != nil || count != 10 {
+		t.Errorf("verifyHistory = %d, %v", count, err)
+	}
+}
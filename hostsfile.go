@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Markers delimiting the block of the hosts file written by the hosts provider
+const (
+	hostsBlockBegin = "# BEGIN dynipupdate managed records - do not edit"
+	hostsBlockEnd   = "# END dynipupdate managed records"
+)
+
+// HostsFileProvider writes the records to a block of a hosts-format file instead of a DNS
+// service, so LAN resolvers such as dnsmasq (addn-hosts) or Pi-hole (custom.list) serve
+// the internal records directly. Lines outside the block are left alone, so the block can
+// live in /etc/hosts. Hosts files only map names to addresses: a CNAME is written as the
+// addresses of its target (and kept as a comment to be followed on later updates), other
+// record types such as heartbeats are not written, so the cleanup service has no effect.
+//
+//	# BEGIN dynipupdate managed records - do not edit
+//	192.168.1.10	nas.example.com
+//	# CNAME www.example.com -> nas.example.com
+//	192.168.1.10	www.example.com
+//	# END dynipupdate managed records
+type HostsFileProvider struct {
+	Path string
+
+	mu               sync.Mutex
+	heartbeatWarning sync.Once
+	typeWarning      sync.Once
+
+	recordSetClient
+}
+
+// Verify HostsFileProvider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*HostsFileProvider)(nil)
+var _ DNSProvider = (*HostsFileProvider)(nil)
+var _ providerClient = (*HostsFileProvider)(nil)
+
+// hostsEntries is the content of the managed block
+type hostsEntries struct {
+	names   []string                       // Names in order of first appearance
+	values  map[string]map[string][]string // name -> A/AAAA -> addresses
+	aliases map[string]string              // CNAME name -> target
+}
+
+// newHostsFileProvider creates a hosts file provider
+func newHostsFileProvider(config *Config) (*HostsFileProvider, error) {
+	if _, err := os.Stat(filepath.Dir(config.HostsFilePath)); err != nil {
+		return nil, fmt.Errorf("%sHOSTS_FILE_PATH: %w", envPrefix, err)
+	}
+	p := &HostsFileProvider{Path: config.HostsFilePath}
+	p.recordSetClient = newRecordSetClient(p, config)
+	log.Printf("Writing records to hosts file %s", p.Path)
+
+	warnUnsupportedOptions(config, "hosts files")
+	if config.Changelog {
+		log.Printf("WARNING: %sCHANGELOG has no effect with hosts files - TXT records are not written", envPrefix)
+		config.Changelog = false
+	}
+	return p, nil
+}
+
+// read returns the lines before and after the managed block and the block's entries.
+// A missing file reads as empty.
+func (p *HostsFileProvider) read() (before, after []string, entries *hostsEntries, err error) {
+	entries = &hostsEntries{values: make(map[string]map[string][]string), aliases: make(map[string]string)}
+	data, err := os.ReadFile(p.Path)
+	if os.IsNotExist(err) {
+		return nil, nil, entries, nil
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	section := 0 // 0 before the block, 1 inside, 2 after
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case section == 0 && line == hostsBlockBegin:
+			section = 1
+		case section == 1 && line == hostsBlockEnd:
+			section = 2
+		case section == 0:
+			before = append(before, line)
+		case section == 2:
+			after = append(after, line)
+		default:
+			entries.parse(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	if section == 1 {
+		return nil, nil, nil, fmt.Errorf("%s: managed block is not terminated by %q", p.Path, hostsBlockEnd)
+	}
+	return before, after, entries, nil
+}
+
+// parse adds one line of the managed block
+func (e *hostsEntries) parse(line string) {
+	fields := strings.Fields(line)
+	if len(fields) == 5 && fields[0] == "#" && fields[1] == "CNAME" && fields[3] == "->" {
+		e.add(fields[2])
+		e.aliases[fields[2]] = fields[4]
+		return
+	}
+	if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+		return
+	}
+	ip := net.ParseIP(fields[0])
+	if ip == nil {
+		return
+	}
+	recordType := "AAAA"
+	if ip.To4() != nil {
+		recordType = "A"
+	}
+	for _, name := range fields[1:] {
+		if _, alias := e.aliases[name]; alias {
+			continue // Addresses of a CNAME are derived from its target
+		}
+		e.add(name)
+		if e.values[name] == nil {
+			e.values[name] = make(map[string][]string)
+		}
+		e.values[name][recordType] = append(e.values[name][recordType], fields[0])
+	}
+}
+
+// add remembers the position of a name
+func (e *hostsEntries) add(name string) {
+	if !slices.Contains(e.names, name) {
+		e.names = append(e.names, name)
+	}
+}
+
+// addresses returns the addresses a name resolves to, following CNAMEs
+func (e *hostsEntries) addresses(name string) []string {
+	for hops := 0; hops < 8; hops++ {
+		target, ok := e.aliases[name]
+		if !ok {
+			break
+		}
+		name = target
+	}
+	return append(append([]string(nil), e.values[name]["A"]...), e.values[name]["AAAA"]...)
+}
+
+// format renders the managed block
+func (e *hostsEntries) format() []string {
+	lines := []string{hostsBlockBegin}
+	for _, name := range e.names {
+		if target, ok := e.aliases[name]; ok {
+			lines = append(lines, fmt.Sprintf("# CNAME %s -> %s", name, target))
+		}
+		for _, address := range e.addresses(name) {
+			lines = append(lines, address+"\t"+name)
+		}
+	}
+	return append(lines, hostsBlockEnd)
+}
+
+// write replaces the file with the block and the lines around it
+func (p *HostsFileProvider) write(before, after []string, entries *hostsEntries) error {
+	lines := append(append(append([]string(nil), before...), entries.format()...), after...)
+	return replaceFileAtomic(p.Path, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// replaceFileAtomic replaces a file read by a resolver atomically (write to temp file, then
+// rename), keeping its mode. New files are created with mode 0644.
+func replaceFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// upsertHeartbeat does nothing: hosts files cannot hold TXT records
+func (p *HostsFileProvider) upsertHeartbeat(name, content string) error {
+	p.heartbeatWarning.Do(func() {
+		log.Println("Heartbeats are not written to hosts files")
+	})
+	return nil
+}
+
+// recordSetBackend implementation
+
+func (p *HostsFileProvider) fetchValues(name, recordType string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _, entries, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	name = strings.TrimSuffix(name, ".")
+	if recordType == "CNAME" {
+		if target, ok := entries.aliases[name]; ok {
+			return []string{target}, nil
+		}
+		return nil, nil
+	}
+	return entries.values[name][recordType], nil
+}
+
+func (p *HostsFileProvider) storeValues(name, recordType string, ttl int, values []string) error {
+	if recordType != "A" && recordType != "AAAA" && recordType != "CNAME" {
+		p.typeWarning.Do(func() {
+			log.Printf("Only A, AAAA and CNAME records are written to hosts files - skipping %s records", recordType)
+		})
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	before, after, entries, err := p.read()
+	if err != nil {
+		return err
+	}
+
+	name = strings.TrimSuffix(name, ".")
+	switch {
+	case recordType == "CNAME" && len(values) > 0:
+		delete(entries.values, name)
+		entries.add(name)
+		entries.aliases[name] = strings.TrimSuffix(values[0], ".")
+	case recordType == "CNAME":
+		delete(entries.aliases, name)
+	default:
+		if len(values) > 0 {
+			delete(entries.aliases, name)
+			entries.add(name)
+			if entries.values[name] == nil {
+				entries.values[name] = make(map[string][]string)
+			}
+		}
+		if entries.values[name] != nil {
+			entries.values[name][recordType] = values
+		}
+	}
+
+	// Forget names left without records
+	kept := entries.names[:0]
+	for _, n := range entries.names {
+		if _, alias := entries.aliases[n]; alias || len(entries.values[n]["A"])+len(entries.values[n]["AAAA"]) > 0 {
+			kept = append(kept, n)
+		} else {
+			delete(entries.values, n)
+		}
+	}
+	entries.names = kept
+	return p.write(before, after, entries)
+}
+
+func (p *HostsFileProvider) listRecords(recordType string) ([]CFRecord, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _, entries, err := p.read()
+	if err != nil {
+		return []CFRecord{}, err
+	}
+	records := []CFRecord{}
+	for _, name := range entries.names {
+		if target, ok := entries.aliases[name]; ok {
+			if recordType == "CNAME" {
+				records = append(records, valueRecords(name, recordType, []string{target})...)
+			}
+			continue
+		}
+		records = append(records, valueRecords(name, recordType, entries.values[name][recordType])...)
+	}
+	return records, nil
+}
+
+// probe reports whether the file can be read
+func (p *HostsFileProvider) probe() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _, _, err := p.read()
+	return err == nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestHostsFile(t *testing.T, content string) (*HostsFileProvider, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hosts")
+	if content != "" {
+		if err := os.WriteFile(path, []byte(content), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := newHostsFileProvider(&Config{HostsFilePath: path, RecordTTL: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, path
+}
+
+func readHostsFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHostsFileProviderWritesManagedBlock(t *testing.T) {
+	p, path := newTestHostsFile(t, "127.0.0.1\tlocalhost\n::1\tlocalhost\n")
+
+	if p.upsertRecord("nas.example.com", "A", "192.168.1.10", false) != nil ||
+		p.ensureRecordExists("nas.example.com", "A", "192.168.1.11", false) != nil ||
+		p.upsertRecord("nas.example.com", "AAAA", "2001:db8::10", false) != nil ||
+		p.upsertRecord("www.example.com", "CNAME", "nas.example.com", false) != nil {
+		t.Fatal("record operation failed")
+	}
+	want := "127.0.0.1\tlocalhost\n::1\tlocalhost\n" + hostsBlockBegin + "\n" +
+		"192.168.1.10\tnas.example.com\n192.168.1.11\tnas.example.com\n2001:db8::10\tnas.example.com\n" +
+		"# CNAME www.example.com -> nas.example.com\n" +
+		"192.168.1.10\twww.example.com\n192.168.1.11\twww.example.com\n2001:db8::10\twww.example.com\n" +
+		hostsBlockEnd + "\n"
+	if got := readHostsFile(t, path); got != want {
+		t.Errorf("hosts file =\n%s\nwant\n%s", got, want)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("file mode not kept: %v %v", info.Mode(), err)
+	}
+
+	// Records are read back from the file, and the alias follows its target
+	if r, _ := p.getRecord("www.example.com", "CNAME"); r == nil || r.Content != "nas.example.com" {
+		t.Errorf("CNAME = %+v", r)
+	}
+	if p.deleteRecord("192.168.1.11", "nas.example.com", "A") != nil || p.upsertRecord("nas.example.com", "A", "192.168.1.20", false) != nil ||
+		p.deleteRecordIfExists("nas.example.com", "AAAA") != nil {
+		t.Fatal("update or delete failed")
+	}
+	got := readHostsFile(t, path)
+	if !strings.Contains(got, "192.168.1.20\twww.example.com\n") || strings.Contains(got, "2001:db8::10") || strings.Contains(got, "192.168.1.11") {
+		t.Errorf("hosts file after update =\n%s", got)
+	}
+
+	list, err := p.listRecords("A")
+	if err != nil || len(list) != 1 || list[0].Name != "nas.example.com" || list[0].Content != "192.168.1.20" {
+		t.Errorf("listRecords(A) = %+v, %v", list, err)
+	}
+
+	// Removing every record leaves an empty block
+	if p.deleteRecordIfExists("www.example.com", "CNAME") != nil || p.deleteRecordIfExists("nas.example.com", "A") != nil {
+		t.Fatal("delete failed")
+	}
+	if got := readHostsFile(t, path); got != "127.0.0.1\tlocalhost\n::1\tlocalhost\n"+hostsBlockBegin+"\n"+hostsBlockEnd+"\n" {
+		t.Errorf("hosts file after deleting everything =\n%s", got)
+	}
+}
+
+func TestHostsFileProviderKeepsSurroundingLines(t *testing.T) {
+	p, path := newTestHostsFile(t, "# header\n"+hostsBlockBegin+"\n10.0.0.1 old.example.com\n"+hostsBlockEnd+"\n10.0.0.9 after.example.com\n")
+
+	if values, err := p.fetchValues("old.example.com", "A"); err != nil || !reflect.DeepEqual(values, []string{"10.0.0.1"}) {
+		t.Errorf("fetchValues = %v, %v", values, err)
+	}
+	if p.upsertRecord("new.example.com", "A", "10.0.0.2", false) != nil {
+		t.Fatal("upsert failed")
+	}
+	want := "# header\n" + hostsBlockBegin + "\n10.0.0.1\told.example.com\n10.0.0.2\tnew.example.com\n" + hostsBlockEnd + "\n10.0.0.9 after.example.com\n"
+	if got := readHostsFile(t, path); got != want {
+		t.Errorf("hosts file =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHostsFileProviderSkipsOtherTypes(t *testing.T) {
+	p, path := newTestHostsFile(t, "")
+
+	if p.upsertHeartbeat("nas.example.com", "heartbeat") != nil || p.upsertRecord("nas.example.com", "TXT", "hello", false) != nil {
+		t.Fatal("heartbeat or TXT reported failure")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file written for TXT records: %v", err)
+	}
+	if !p.probe() {
+		t.Error("probe of a missing file failed")
+	}
+}
+
+func TestHostsFileProviderUnterminatedBlock(t *testing.T) {
+	p, path := newTestHostsFile(t, hostsBlockBegin+"\n10.0.0.1 old.example.com\n")
+
+	if p.probe() || p.upsertRecord("nas.example.com", "A", "10.0.0.2", false) == nil {
+		t.Error("unterminated block accepted")
+	}
+	if got := readHostsFile(t, path); strings.Contains(got, "nas.example.com") {
+		t.Errorf("file changed: %s", got)
+	}
+}
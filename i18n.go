@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Default locale used when nothing else is configured or a message has no translation
+const defaultLocale = "en"
+
+// Active locale for operator-facing messages (set once at startup)
+var currentLocale = defaultLocale
+
+// messageCatalog maps locale -> message ID -> format string.
+// Format strings use the same verbs as fmt.Sprintf and must take the same
+// arguments in the same order across all locales.
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		// Configuration loading
+		"token.quoted":        "WARNING: API token appears to have quotes around it (len=%d, first char=%q, last char=%q)",
+		"token.loaded":        "API token loaded (length: %d chars, starts with: %.8s..., ends with: ...%.4s)",
+		"config.no_domains":   "At least one domain must be configured (%sBASE_DOMAIN, %sINTERNAL_DOMAIN, %sEXTERNAL_DOMAIN, %sIPV6_DOMAIN, %sIPV4_RANGE_N/%sIPV6_RANGE_N, %sCOMBINED_DOMAIN, or %sTOP_LEVEL_DOMAIN)",
+		"config.ipv4_ranges":  "Configured %d custom IPv4 range(s):",
+		"config.ipv6_ranges":  "Configured %d custom IPv6 range(s):",
+		"config.cleanup":      "Cleanup Configuration:",
+		"config.stale":        "  Stale Threshold: %d seconds",
+		"config.interval":     "  Cleanup Interval: %d seconds",
+		"config.cleanup_mode": "  Mode: Will only clean up configured managed domains",
+		"config.invalid_int":  "Invalid integer value for %s%s: %s, using default %d",
+		"config.required":     "Required environment variable %s%s not set",
+
+		// Custom range parsing
+		"range.missing_pair": "WARNING: %s%s is set but %s%s is not - skipping",
+		"range.invalid_cidr": "WARNING: Invalid CIDR notation in %s%s: %s (%v) - skipping",
+
+		// Unused environment variables
+		"unused.header":        "WARNING: Found %d unused environment variable(s) with %s prefix:",
+		"unused.entry":         "  - %s (value: %q) - %s",
+		"unused.unknown":       "Unknown configuration variable",
+		"unused.footer":        "These variables had no effect. Check for typos or see documentation for valid variable names.",
+		"unused.missing_pair":  "Missing companion variable %s%s (both CIDR and DOMAIN must be set)",
+		"unused.cidr_paired":   "Paired CIDR variable exists but may have validation issues",
+		"unused.domain_paired": "Paired DOMAIN variable exists but may have validation issues",
+		"unused.invalid_cidr":  "Invalid CIDR notation: %v",
+
+		// Update summary
+		"update.start":                "Starting Dynamic DNS Updater",
+		"update.toplevel_no_combined": "WARNING: TOP_LEVEL_DOMAIN is set but COMBINED_DOMAIN is not - skipping CNAME creation",
+		"update.completed":            "Completed: %d/%d records updated successfully",
+		"update.all_ok":               "All updates successful!",
+		"update.some_failed":          "Some updates failed",
+		"update.all_failed":           "All updates failed",
+
+		// Cleanup summary
+		"cleanup.start":      "Starting DNS Cleanup Service",
+		"cleanup.running":    "Cleanup service running. Will check every %d seconds for records older than %d seconds",
+		"cleanup.cycle":      "Running cleanup cycle...",
+		"cleanup.none_stale": "No stale domains found",
+		"cleanup.done_zero":  "Cleanup cycle complete. Total deleted: 0",
+		"cleanup.found":      "Found %d stale domain(s) to clean up",
+		"cleanup.done":       "Cleanup cycle complete. Total deleted: %d records from %d domain(s)",
+	},
+	"de": {
+		"token.quoted":        "WARNUNG: Das API-Token scheint in Anführungszeichen zu stehen (Länge=%d, erstes Zeichen=%q, letztes Zeichen=%q)",
+		"token.loaded":        "API-Token geladen (Länge: %d Zeichen, beginnt mit: %.8s..., endet mit: ...%.4s)",
+		"config.no_domains":   "Es muss mindestens eine Domain konfiguriert sein (%sBASE_DOMAIN, %sINTERNAL_DOMAIN, %sEXTERNAL_DOMAIN, %sIPV6_DOMAIN, %sIPV4_RANGE_N/%sIPV6_RANGE_N, %sCOMBINED_DOMAIN oder %sTOP_LEVEL_DOMAIN)",
+		"config.ipv4_ranges":  "%d benutzerdefinierte(r) IPv4-Bereich(e) konfiguriert:",
+		"config.ipv6_ranges":  "%d benutzerdefinierte(r) IPv6-Bereich(e) konfiguriert:",
+		"config.cleanup":      "Bereinigungskonfiguration:",
+		"config.stale":        "  Veraltet nach: %d Sekunden",
+		"config.interval":     "  Prüfintervall: %d Sekunden",
+		"config.cleanup_mode": "  Modus: Bereinigt nur die konfigurierten verwalteten Domains",
+		"config.invalid_int":  "Ungültiger Ganzzahlwert für %s%s: %s, verwende Standardwert %d",
+		"config.required":     "Erforderliche Umgebungsvariable %s%s ist nicht gesetzt",
+
+		"range.missing_pair": "WARNUNG: %s%s ist gesetzt, aber %s%s nicht - wird übersprungen",
+		"range.invalid_cidr": "WARNUNG: Ungültige CIDR-Notation in %s%s: %s (%v) - wird übersprungen",
+
+		"unused.header":        "WARNUNG: %d nicht verwendete Umgebungsvariable(n) mit Präfix %s gefunden:",
+		"unused.entry":         "  - %s (Wert: %q) - %s",
+		"unused.unknown":       "Unbekannte Konfigurationsvariable",
+		"unused.footer":        "Diese Variablen hatten keine Wirkung. Bitte auf Tippfehler prüfen oder die Dokumentation für gültige Variablennamen konsultieren.",
+		"unused.missing_pair":  "Zugehörige Variable %s%s fehlt (CIDR und DOMAIN müssen beide gesetzt sein)",
+		"unused.cidr_paired":   "Zugehörige CIDR-Variable existiert, ist aber möglicherweise ungültig",
+		"unused.domain_paired": "Zugehörige DOMAIN-Variable existiert, ist aber möglicherweise ungültig",
+		"unused.invalid_cidr":  "Ungültige CIDR-Notation: %v",
+
+		"update.start":                "Dynamischer DNS-Updater wird gestartet",
+		"update.toplevel_no_combined": "WARNUNG: TOP_LEVEL_DOMAIN ist gesetzt, COMBINED_DOMAIN aber nicht - CNAME wird nicht angelegt",
+		"update.completed":            "Abgeschlossen: %d/%d Einträge erfolgreich aktualisiert",
+		"update.all_ok":               "Alle Aktualisierungen erfolgreich!",
+		"update.some_failed":          "Einige Aktualisierungen sind fehlgeschlagen",
+		"update.all_failed":           "Alle Aktualisierungen sind fehlgeschlagen",
+
+		"cleanup.start":      "DNS-Bereinigungsdienst wird gestartet",
+		"cleanup.running":    "Bereinigungsdienst läuft. Prüft alle %d Sekunden auf Einträge, die älter als %d Sekunden sind",
+		"cleanup.cycle":      "Bereinigungsdurchlauf wird ausgeführt...",
+		"cleanup.none_stale": "Keine veralteten Domains gefunden",
+		"cleanup.done_zero":  "Bereinigungsdurchlauf abgeschlossen. Insgesamt gelöscht: 0",
+		"cleanup.found":      "%d veraltete Domain(s) zum Bereinigen gefunden",
+		"cleanup.done":       "Bereinigungsdurchlauf abgeschlossen. Insgesamt gelöscht: %d Einträge aus %d Domain(s)",
+	},
+}
+
+// tr returns the localized message for id formatted with args.
+// Falls back to English, then to the message ID itself.
+func tr(id string, args ...interface{}) string {
+	format, ok := messageCatalog[currentLocale][id]
+	if !ok {
+		format, ok = messageCatalog[defaultLocale][id]
+	}
+	if !ok {
+		format = id
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// selectLocale picks the message locale.
+// Order: BEES_IP_UPDATE_LOCALE, then the standard LC_ALL, LC_MESSAGES and LANG variables.
+func selectLocale() string {
+	candidates := []string{
+		getEnv("LOCALE"),
+		os.Getenv("LC_ALL"),
+		os.Getenv("LC_MESSAGES"),
+		os.Getenv("LANG"),
+	}
+
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if locale := normalizeLocale(candidate); locale != "" {
+			return locale
+		}
+	}
+
+	return defaultLocale
+}
+
+// normalizeLocale converts a POSIX locale string (e.g. "de_DE.UTF-8") to a
+// supported catalog key (e.g. "de"). Returns "" if the language is not supported.
+func normalizeLocale(value string) string {
+	lang := strings.ToLower(strings.TrimSpace(value))
+	if i := strings.IndexAny(lang, "_.@-"); i >= 0 {
+		lang = lang[:i]
+	}
+	if lang == "c" || lang == "posix" {
+		return defaultLocale
+	}
+	if _, ok := messageCatalog[lang]; ok {
+		return lang
+	}
+	return ""
+}
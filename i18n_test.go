@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+// TestNormalizeLocale verifies POSIX locale strings map to catalog keys
+func TestNormalizeLocale(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"de_DE.UTF-8", "de"},
+		{"de", "de"},
+		{"en_GB.UTF-8", "en"},
+		{"EN-us", "en"},
+		{"C", "en"},
+		{"POSIX", "en"},
+		{"fr_FR.UTF-8", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if result := normalizeLocale(tt.input); result != tt.expected {
+				t.Errorf("normalizeLocale(%q) = %q, expected %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTrFallback verifies missing translations fall back to English, then the message ID
+func TestTrFallback(t *testing.T) {
+	defer func() { currentLocale = defaultLocale }()
+
+	currentLocale = "de"
+	if result := tr("update.completed", 2, 3); result != "Abgeschlossen: 2/3 Einträge erfolgreich aktualisiert" {
+		t.Errorf("Unexpected German message: %q", result)
+	}
+
+	messageCatalog["en"]["test.only_en"] = "only %s"
+	defer delete(messageCatalog["en"], "test.only_en")
+	if result := tr("test.only_en", "english"); result != "only english" {
+		t.Errorf("Expected English fallback, got %q", result)
+	}
+
+	if result := tr("no.such.message"); result != "no.such.message" {
+		t.Errorf("Expected message ID fallback, got %q", result)
+	}
+}
+
+// TestCatalogConsistency verifies every translation has an English source message
+func TestCatalogConsistency(t *testing.T) {
+	for locale, messages := range messageCatalog {
+		for id := range messages {
+			if _, ok := messageCatalog[defaultLocale][id]; !ok {
+				t.Errorf("Locale %s defines %q which has no English message", locale, id)
+			}
+		}
+	}
+}
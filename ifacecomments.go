@@ -0,0 +1,93 @@
+package main
+
+import "log"
+
+// Interface comments. With RECORD_INTERFACE_COMMENTS=true, the CloudFlare records of
+// addresses found on a network interface (the internal domain, custom ranges, and those
+// addresses in the combined domain) carry the interface and host in their comment, e.g.
+// "eth0 on nas", so an unexpected address in DNS can be traced to the interface that leaked
+// it without logging in to the host. External addresses come from echo services and get no
+// comment. Records whose comment differs are updated; the comments of other records are left
+// alone.
+
+// cfMaxCommentLength is the longest record comment every CloudFlare plan accepts
+const cfMaxCommentLength = 100
+
+// recordCommenter is implemented by providers that can attach comments to records
+type recordCommenter interface {
+	setRecordComments(comments map[string]string)
+}
+
+// setRecordComments sets the comments of the records publishing each address
+func (cf *CloudFlareClient) setRecordComments(comments map[string]string) {
+	cf.Comments = comments
+}
+
+// recordComment returns the comment of the records publishing content ("" = none)
+func (cf *CloudFlareClient) recordComment(content string) string {
+	return cf.Comments[content]
+}
+
+// commentChanged reports whether a record needs rewriting for its comment
+func commentChanged(published, wanted string) bool {
+	return wanted != "" && published != wanted
+}
+
+// setRecordComments passes the comments on, so that planned updates match a real run
+func (c *dryRunClient) setRecordComments(comments map[string]string) {
+	if commenter, ok := c.providerClient.(recordCommenter); ok {
+		commenter.setRecordComments(comments)
+	}
+}
+
+// interfaceComments returns the record comment of each address found on an interface
+// (nil unless RECORD_INTERFACE_COMMENTS is enabled)
+func interfaceComments(config *Config, ips *IPAddresses) map[string]string {
+	if !config.RecordInterfaceComments || len(ips.Interfaces) == 0 {
+		return nil
+	}
+	comments := make(map[string]string, len(ips.Interfaces))
+	for addr, iface := range ips.Interfaces {
+		comment := iface
+		if config.HeartbeatHost != "" {
+			comment += " on " + config.HeartbeatHost
+		}
+		if len(comment) > cfMaxCommentLength {
+			comment = comment[:cfMaxCommentLength]
+		}
+		comments[addr] = comment
+	}
+	return comments
+}
+
+// applyInterfaceComments hands the comments of this run's addresses to the provider
+func applyInterfaceComments(cf providerClient, config *Config, ips *IPAddresses) {
+	if !config.RecordInterfaceComments {
+		return
+	}
+	commenter, ok := cf.(recordCommenter)
+	if !ok {
+		log.Printf("WARNING: %sRECORD_INTERFACE_COMMENTS is only supported by CloudFlare - records are published without comments", envPrefix)
+		return
+	}
+	commenter.setRecordComments(interfaceComments(config, ips))
+}
+
+// recordInterfaces remembers the interface of each published address
+func recordInterfaces(ips *IPAddresses, addrs []interfaceAddr) {
+	published := append([]string{}, ips.InternalIPv4...)
+	for _, rangeIPs := range ips.CustomRangeIPs {
+		published = append(published, rangeIPs...)
+	}
+	publishedSet := newAddrSet(published)
+	for _, a := range addrs {
+		addr := a.Addr.String()
+		if _, seen := ips.Interfaces[addr]; seen || !publishedSet[a.Addr] {
+			continue
+		}
+		if ips.Interfaces == nil {
+			ips.Interfaces = make(map[string]string)
+		}
+		ips.Interfaces[addr] = a.Interface
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestRecordInterfaces(t *testing.T) {
+	ips := &IPAddresses{InternalIPv4: []string{"192.168.1.10"}, CustomRangeIPs: map[string][]string{"lab.example.com": {"10.0.0.5"}}}
+	recordInterfaces(ips, []interfaceAddr{
+		{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "br0"}, // The first interface wins
+		{Addr: netip.MustParseAddr("10.0.0.5"), Interface: "wlan0"},
+		{Addr: netip.MustParseAddr("fd00::1"), Interface: "eth0"}, // Not published
+	})
+	want := map[string]string{"192.168.1.10": "eth0", "10.0.0.5": "wlan0"}
+	if !reflect.DeepEqual(ips.Interfaces, want) {
+		t.Errorf("interfaces = %v, want %v", ips.Interfaces, want)
+	}
+}
+
+func TestInterfaceComments(t *testing.T) {
+	for _, concurrency := range []int{0, 4} {
+		fake, cf := newFakeCloudFlare(t)
+		fake.add("A", "lan.example.com", "192.168.1.10")
+		config := &Config{InternalDomain: "lan.example.com", ExternalDomain: "home.example.com", RecordInterfaceComments: true, HeartbeatHost: "nas", UpdateConcurrency: concurrency}
+		ips := &IPAddresses{
+			InternalIPv4: []string{"192.168.1.10", "192.168.1.20"},
+			ExternalIPv4: "203.0.113.1",
+			Interfaces:   map[string]string{"192.168.1.10": "eth0", "192.168.1.20": "wlan0"},
+		}
+		reconcileRecords(cf, config, ips, config.now())
+
+		want := map[string]string{"192.168.1.10": "eth0 on nas", "192.168.1.20": "wlan0 on nas", "203.0.113.1": ""}
+		fake.mu.Lock()
+		for _, record := range fake.records {
+			if wanted, ok := want[record.Content]; ok && record.Comment != wanted {
+				t.Errorf("concurrency %d: comment of %s = %q, want %q", concurrency, record.Content, record.Comment, wanted)
+			}
+		}
+		fake.mu.Unlock()
+
+		// Comments in place: nothing left to change
+		var changes int
+		cf.hooks().OnChange = func(action, recordType, name, content string) {
+			if recordType != "TXT" {
+				changes++
+			}
+		}
+		reconcileRecords(cf, config, ips, config.now())
+		if changes != 0 {
+			t.Errorf("concurrency %d: %d change(s) on the second run", concurrency, changes)
+		}
+	}
+}
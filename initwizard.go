@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Domain layouts offered by the init wizard
+const (
+	layoutExternal         = "external"
+	layoutInternalExternal = "internal+external"
+	layoutCombined         = "combined"
+)
+
+// Deployment snippets offered by the init wizard
+const (
+	deploySystemd = "systemd"
+	deployCompose = "compose"
+	deployNone    = "none"
+)
+
+// initAnswers holds everything the init wizard asks for
+type initAnswers struct {
+	Provider   string
+	Token      string
+	ZoneID     string
+	ZoneName   string
+	Host       string
+	Layout     string
+	Deployment string
+}
+
+// initWizard asks questions on out and reads answers from in
+type initWizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints a question and returns the answer, or defaultValue if the answer is empty
+func (w *initWizard) ask(question, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading answer: %w", err)
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return defaultValue, nil
+}
+
+// askRequired repeats a question until it gets a non-empty answer
+func (w *initWizard) askRequired(question string) (string, error) {
+	for {
+		answer, err := w.ask(question, "")
+		if err != nil || answer != "" {
+			return answer, err
+		}
+		fmt.Fprintln(w.out, "  A value is required.")
+	}
+}
+
+// choose offers numbered options and returns the value of the selected one
+func (w *initWizard) choose(question string, options [][2]string) (string, error) {
+	fmt.Fprintln(w.out, question)
+	for i, option := range options {
+		fmt.Fprintf(w.out, "  %d) %s\n", i+1, option[1])
+	}
+	for {
+		answer, err := w.ask("Choice", "1")
+		if err != nil {
+			return "", err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return options[n-1][0], nil
+		}
+		fmt.Fprintf(w.out, "  Enter a number between 1 and %d.\n", len(options))
+	}
+}
+
+// run asks all questions. When verify is set, the token and zone are checked against baseURL
+// and the zone name is taken from the API instead of being asked for.
+func (w *initWizard) run(baseURL string, verify bool) (*initAnswers, error) {
+	var a initAnswers
+	var err error
+
+	if a.Provider, err = w.choose("DNS provider:", [][2]string{{"cloudflare", "CloudFlare"}}); err != nil {
+		return nil, err
+	}
+	if a.Token, err = w.askRequired("CloudFlare API token (Zone > DNS > Edit)"); err != nil {
+		return nil, err
+	}
+	if a.ZoneID, err = w.askRequired("CloudFlare zone ID"); err != nil {
+		return nil, err
+	}
+
+	if verify {
+		fmt.Fprintln(w.out, "Verifying access...")
+		if err := verifyCloudFlareToken(baseURL, a.Token); err != nil {
+			return nil, fmt.Errorf("token verification failed: %w", err)
+		}
+		cf := &CloudFlareClient{APIToken: a.Token, ZoneID: a.ZoneID, BaseURL: baseURL}
+		zone, err := cf.getZone()
+		if err != nil {
+			return nil, fmt.Errorf("cannot access zone %s: %w", a.ZoneID, err)
+		}
+		if err := adaptToZoneSetup(&Config{}, zone); err != nil {
+			return nil, err
+		}
+		a.ZoneName = zone.Name
+		fmt.Fprintf(w.out, "Token and zone %s verified\n", a.ZoneName)
+	} else if a.ZoneName, err = w.askRequired("Zone name (e.g. example.com)"); err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	hostname, _, _ = strings.Cut(hostname, ".")
+	if a.Host, err = w.ask("Host label for this machine", strings.ToLower(hostname)); err != nil {
+		return nil, err
+	}
+	if a.Host == "" {
+		if a.Host, err = w.askRequired("Host label for this machine"); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.Layout, err = w.choose("Domain layout:", [][2]string{
+		{layoutExternal, fmt.Sprintf("External only       (%s.%s -> public IPv4/IPv6)", a.Host, a.ZoneName)},
+		{layoutInternalExternal, fmt.Sprintf("Internal + external (%s.int.%s -> LAN, %s.%s -> public)", a.Host, a.ZoneName, a.Host, a.ZoneName)},
+		{layoutCombined, fmt.Sprintf("Combined            (%s.%s -> all addresses)", a.Host, a.ZoneName)},
+	}); err != nil {
+		return nil, err
+	}
+
+	if a.Deployment, err = w.choose("Also generate:", [][2]string{
+		{deploySystemd, "systemd service + timer"},
+		{deployCompose, "Docker compose snippet"},
+		{deployNone, "Nothing"},
+	}); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// buildInitConfig turns wizard answers into config file settings
+func buildInitConfig(a *initAnswers) map[string]interface{} {
+	config := map[string]interface{}{
+		"cf_api_token": a.Token,
+		"cf_zone_id":   a.ZoneID,
+	}
+	host := a.Host + "." + a.ZoneName
+
+	switch a.Layout {
+	case layoutExternal:
+		config["external_domain"] = host
+		config["ipv6_domain"] = host
+	case layoutInternalExternal:
+		config["internal_domain"] = a.Host + ".int." + a.ZoneName
+		config["external_domain"] = host
+		config["ipv6_domain"] = host
+	case layoutCombined:
+		config["combined_domain"] = host
+	}
+	return config
+}
+
+// systemdUnits returns a service and timer running the updater every 5 minutes.
+// stateDirectory (optional) is created under /var/lib by systemd before each run.
+func systemdUnits(binary, configPath, stateDirectory string) (service, timer string) {
+	service = fmt.Sprintf(`[Unit]
+Description=Dynamic DNS Updater
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+Environment=%sCONFIG_FILE=%s
+ExecStart=%s
+`, envPrefix, configPath, binary)
+	if stateDirectory != "" {
+		service += fmt.Sprintf("StateDirectory=%s\n", stateDirectory)
+	}
+
+	timer = `[Unit]
+Description=Dynamic DNS Updater Timer
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=5min
+
+[Install]
+WantedBy=timers.target
+`
+	return service, timer
+}
+
+// composeSnippet returns a docker-compose service mounting the config file
+func composeSnippet(configPath string) string {
+	return fmt.Sprintf(`services:
+  dns-updater:
+    image: richleigh/dynipupdate:latest
+    network_mode: host
+    restart: always
+    environment:
+      %sCONFIG_FILE: /config/%s
+    volumes:
+      - %s:/config/%s:ro
+`, envPrefix, filepath.Base(configPath), configPath, filepath.Base(configPath))
+}
+
+// writeNewFile writes data to path, refusing to replace an existing file unless force is set
+func writeNewFile(path string, data []byte, perm os.FileMode, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists (use -force to overwrite)", path)
+		}
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runInit implements the init subcommand: an interactive wizard writing a working config file
+func runInit(args []string) int {
+	return runInitWith(args, os.Stdin, os.Stdout, "https://api.cloudflare.com/client/v4")
+}
+
+func runInitWith(args []string, in io.Reader, out io.Writer, baseURL string) int {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	output := fs.String("output", "dynipupdate.json", "Config file to write")
+	skipVerify := fs.Bool("skip-verify", false, "Do not verify the token and zone with CloudFlare")
+	force := fs.Bool("force", false, "Overwrite existing files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	wizard := &initWizard{in: bufio.NewReader(in), out: out}
+	answers, err := wizard.run(baseURL, !*skipVerify)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	configPath, err := filepath.Abs(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	data, _ := json.MarshalIndent(buildInitConfig(answers), "", "  ")
+	// The file contains the API token - keep it private
+	if err := writeNewFile(configPath, append(data, '\n'), 0o600, *force); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "\nWrote %s\n", configPath)
+
+	dir := filepath.Dir(configPath)
+	switch answers.Deployment {
+	case deploySystemd:
+		binary, err := os.Executable()
+		if err != nil {
+			binary = "/usr/local/bin/dynipupdate"
+		}
+		service, timer := systemdUnits(binary, configPath, "")
+		for name, content := range map[string]string{"dynipupdate.service": service, "dynipupdate.timer": timer} {
+			if err := writeNewFile(filepath.Join(dir, name), []byte(content), 0o644, *force); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+		}
+		fmt.Fprintf(out, "Wrote %s and %s\n", filepath.Join(dir, "dynipupdate.service"), filepath.Join(dir, "dynipupdate.timer"))
+		fmt.Fprintln(out, "Install with:")
+		fmt.Fprintf(out, "  sudo cp %s/dynipupdate.service %s/dynipupdate.timer /etc/systemd/system/\n", dir, dir)
+		fmt.Fprintln(out, "  sudo systemctl enable --now dynipupdate.timer")
+
+	case deployCompose:
+		path := filepath.Join(dir, "docker-compose.dynipupdate.yml")
+		if err := writeNewFile(path, []byte(composeSnippet(configPath)), 0o644, *force); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(out, "Wrote %s\n", path)
+		fmt.Fprintf(out, "Start with:\n  docker compose -f %s up -d\n", path)
+
+	default:
+		fmt.Fprintf(out, "Run with:\n  %sCONFIG_FILE=%s dynipupdate\n", envPrefix, configPath)
+	}
+	return 0
+}
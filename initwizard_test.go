@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunInitWritesConfigAndCompose(t *testing.T) {
+	fake, _ := newFakeCloudFlare(t)
+	dir := t.TempDir()
+	output := filepath.Join(dir, "laptop.json")
+
+	// provider, token, zone ID, host, layout, deployment
+	answers := "1\ntoken-123\nzone\nlaptop\n2\n2\n"
+	var out bytes.Buffer
+	if code := runInitWith([]string{"-output", output}, strings.NewReader(answers), &out, fake.server.URL); code != 0 {
+		t.Fatalf("runInit exited %d; output:\n%s", code, out.String())
+	}
+
+	values, err := loadConfigFile(output)
+	if err != nil {
+		t.Fatalf("generated config does not load: %v", err)
+	}
+	want := map[string]string{
+		"CF_API_TOKEN":    "token-123",
+		"CF_ZONE_ID":      "zone",
+		"INTERNAL_DOMAIN": "laptop.int.example.com",
+		"EXTERNAL_DOMAIN": "laptop.example.com",
+	}
+	for key, v := range want {
+		if values[key] != v {
+			t.Errorf("%s = %q, want %q", key, values[key], v)
+		}
+	}
+
+	info, err := os.Stat(output)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("config file should be private, got %v (%v)", info.Mode().Perm(), err)
+	}
+	compose, err := os.ReadFile(filepath.Join(dir, "docker-compose.dynipupdate.yml"))
+	if err != nil || !strings.Contains(string(compose), envPrefix+"CONFIG_FILE: /config/laptop.json") {
+		t.Errorf("compose snippet missing or wrong: %s (%v)", compose, err)
+	}
+
+	// A second run must not overwrite without -force
+	if code := runInitWith([]string{"-output", output, "-skip-verify"}, strings.NewReader("1\nt\nz\nexample.com\nh\n1\n3\n"), &out, fake.server.URL); code == 0 {
+		t.Error("expected refusal to overwrite existing config")
+	}
+}
+
+func TestRunInitRejectsSecondaryZone(t *testing.T) {
+	fake, _ := newFakeCloudFlare(t)
+	fake.zoneType = zoneTypeSecondary
+
+	var out bytes.Buffer
+	output := filepath.Join(t.TempDir(), "c.json")
+	if code := runInitWith([]string{"-output", output}, strings.NewReader("1\ntoken\nzone\n"), &out, fake.server.URL); code == 0 {
+		t.Error("expected failure for a secondary zone")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Error("no config should be written when verification fails")
+	}
+}
+
+func TestBuildInitConfigCombined(t *testing.T) {
+	config := buildInitConfig(&initAnswers{Token: "t", ZoneID: "z", ZoneName: "example.com", Host: "nas", Layout: layoutCombined})
+	if config["combined_domain"] != "nas.example.com" {
+		t.Errorf("combined_domain = %v", config["combined_domain"])
+	}
+	if _, ok := config["external_domain"]; ok {
+		t.Error("combined layout should not set external_domain")
+	}
+}
+
+func TestWizardChooseRetriesInvalidInput(t *testing.T) {
+	var out bytes.Buffer
+	w := &initWizard{in: bufio.NewReader(strings.NewReader("9\nx\n2\n")), out: &out}
+	got, err := w.choose("Pick:", [][2]string{{"a", "A"}, {"b", "B"}})
+	if err != nil || got != "b" {
+		t.Errorf("choose = %q, %v; want b", got, err)
+	}
+}
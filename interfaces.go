@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// defaultInterfaceScanMaxAge is how long an interface scan is reused. It only needs to
+// cover one cycle (profile selection and detection), so the next cycle scans again.
+const defaultInterfaceScanMaxAge = 10 * time.Second
+
+// interfaceAddr is an address configured on a network interface
+type interfaceAddr struct {
+	Addr      netip.Addr
+	Interface string
+	State     addrState // Zero when the address is usable
+}
+
+// InterfaceSource enumerates the addresses of the host's network interfaces
+type InterfaceSource interface {
+	InterfaceAddrs() ([]interfaceAddr, error)
+}
+
+// systemInterfaces is the InterfaceSource reading the host's interfaces. Address states
+// come from the kernel where available and from the DHCP lease files matching leaseFiles.
+type systemInterfaces struct {
+	leaseFiles []string
+}
+
+func (s systemInterfaces) InterfaceAddrs() ([]interfaceAddr, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	states := platformAddrStates()
+	leases := leaseStates(dhclientLeaseExpiries(s.leaseFiles), time.Now())
+
+	var result []interfaceAddr
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if a, ok := addrFromNet(addr); ok {
+				result = append(result, interfaceAddr{Addr: a, Interface: iface.Name, State: states[a] | leases[a]})
+			}
+		}
+	}
+	return result, nil
+}
+
+// InterfaceSnapshot is one scan of the interface addresses. All consumers in a cycle
+// (network profiles, internal and custom range detection) read the same snapshot.
+type InterfaceSnapshot struct {
+	Addrs   []interfaceAddr
+	TakenAt time.Time
+}
+
+// Addresses returns the addresses in the snapshot
+func (s *InterfaceSnapshot) Addresses() []netip.Addr {
+	addrs := make([]netip.Addr, len(s.Addrs))
+	for i, a := range s.Addrs {
+		addrs[i] = a.Addr
+	}
+	return addrs
+}
+
+// interfaceScanner takes snapshots from a source, reusing one for up to maxAge
+type interfaceScanner struct {
+	source InterfaceSource
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	current *InterfaceSnapshot
+}
+
+func newInterfaceScanner(source InterfaceSource, maxAge time.Duration) *interfaceScanner {
+	return &interfaceScanner{source: source, maxAge: maxAge}
+}
+
+// snapshot returns the current snapshot, scanning the interfaces if it is too old
+func (s *interfaceScanner) snapshot(now time.Time) *InterfaceSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil && now.Sub(s.current.TakenAt) < s.maxAge {
+		return s.current
+	}
+
+	addrs, err := s.source.InterfaceAddrs()
+	if err != nil {
+		log.Printf("Error getting network interfaces: %v", err)
+	}
+	if elapsed := time.Since(now); elapsed > time.Second {
+		log.Printf("Scanning network interfaces took %s", elapsed.Round(time.Millisecond))
+	}
+	s.current = &InterfaceSnapshot{Addrs: addrs, TakenAt: now}
+	return s.current
+}
+
+// invalidate makes the next snapshot scan the interfaces again
+func (s *interfaceScanner) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = nil
+}
+
+// interfaceSnapshot returns the interface snapshot for the current cycle
+func (c *Config) interfaceSnapshot() *InterfaceSnapshot {
+	if c.interfaces == nil {
+		maxAge := time.Duration(c.InterfaceScanMaxAge) * time.Second
+		if maxAge <= 0 {
+			maxAge = defaultInterfaceScanMaxAge
+		}
+		c.interfaces = newInterfaceScanner(systemInterfaces{leaseFiles: c.DHCPLeaseFiles}, maxAge)
+	}
+	return c.interfaces.snapshot(time.Now())
+}
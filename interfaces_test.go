@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeInterfaces is an InterfaceSource returning fixed addresses and counting scans
+type fakeInterfaces struct {
+	addrs []interfaceAddr
+	err   error
+	scans int
+}
+
+func (f *fakeInterfaces) InterfaceAddrs() ([]interfaceAddr, error) {
+	f.scans++
+	return f.addrs, f.err
+}
+
+func TestInterfaceScannerReusesSnapshot(t *testing.T) {
+	source := &fakeInterfaces{addrs: []interfaceAddr{{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "eth0"}}}
+	scanner := newInterfaceScanner(source, 10*time.Second)
+	start := time.Unix(1700000000, 0)
+
+	first := scanner.snapshot(start)
+	if again := scanner.snapshot(start.Add(5 * time.Second)); again != first || source.scans != 1 {
+		t.Errorf("snapshot not reused within max age (scans = %d)", source.scans)
+	}
+	if later := scanner.snapshot(start.Add(11 * time.Second)); later == first || source.scans != 2 {
+		t.Errorf("snapshot reused after max age (scans = %d)", source.scans)
+	}
+	if got := first.Addresses(); !reflect.DeepEqual(got, []netip.Addr{netip.MustParseAddr("192.168.1.10")}) {
+		t.Errorf("Addresses = %v", got)
+	}
+}
+
+func TestInterfaceScannerError(t *testing.T) {
+	source := &fakeInterfaces{err: errors.New("not permitted")}
+	snapshot := newInterfaceScanner(source, time.Second).snapshot(time.Now())
+	if len(snapshot.Addrs) != 0 {
+		t.Errorf("Addrs = %v, want none", snapshot.Addrs)
+	}
+}
+
+func TestConfigSharesInterfaceSnapshot(t *testing.T) {
+	source := &fakeInterfaces{addrs: []interfaceAddr{
+		{Addr: netip.MustParseAddr("10.50.3.4"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("100.64.0.5"), Interface: "tailscale0"},
+	}}
+	config := &Config{
+		NetworkProfiles:  []NetworkProfile{{Name: "office", Subnets: []string{"10.50.0.0/16"}, Domains: []string{"ipv4_range_1"}}},
+		CustomIPv4Ranges: []CustomIPRange{testRange("100.64.0.0/10", "vpn.example.com")},
+		interfaces:       newInterfaceScanner(source, time.Minute),
+	}
+
+	// Profile selection and range detection read the same scan
+	env := NetworkEnvironment{Addresses: config.interfaceSnapshot().Addresses()}
+	if profile := matchNetworkProfile(config.NetworkProfiles, env); profile == nil || profile.Name != "office" {
+		t.Fatalf("profile = %v", profile)
+	}
+	found := config.customRangeMatcher().match(config.interfaceSnapshot().Addrs)
+	if !reflect.DeepEqual(found, [][]string{{"100.64.0.5"}}) {
+		t.Errorf("found = %v", found)
+	}
+	if source.scans != 1 {
+		t.Errorf("interfaces scanned %d times, want 1", source.scans)
+	}
+}
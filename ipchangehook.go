@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// IP change webhook. With IP_CHANGE_WEBHOOK_URL set, a JSON request is POSTed whenever the
+// detected external IPv4 or IPv6 address differs from the one last announced, so firewalls,
+// VPN configs and other systems pinned to the address can follow it:
+//
+//	{"event": "ip-change", "host": "nas.example.com", "timestamp": 1700000000,
+//	 "ipv4": {"old": "203.0.113.1", "new": "203.0.113.2"},
+//	 "domains": ["home.example.com", "nas.example.com"]}
+//
+// Only the changed families are included; domains lists the names publishing them. With
+// IP_CHANGE_WEBHOOK_SECRET set, the body is signed with HMAC-SHA256 in the
+// X-Dynipupdate-Signature header, as the webhook provider signs its requests. The announced
+// addresses are kept in the state file: the first detection is only remembered, a failed
+// detection announces nothing, and a failed request is repeated by the next run. The change
+// is also sent to the notifiers as an ip-change event (see chatnotify.go).
+
+// ExternalAddresses are the external addresses last announced by the IP change webhook
+type ExternalAddresses struct {
+	IPv4 string `json:"ipv4,omitempty"`
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+// addressChange is one changed family of an IP change event
+type addressChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// ipChangeEvent is the body of an IP change webhook request
+type ipChangeEvent struct {
+	Event     string         `json:"event"` // Always "ip-change"
+	Host      string         `json:"host"`
+	Timestamp int64          `json:"timestamp"`
+	IPv4      *addressChange `json:"ipv4,omitempty"`
+	IPv6      *addressChange `json:"ipv6,omitempty"`
+	Domains   []string       `json:"domains"`
+}
+
+// validateIPChangeWebhook checks the IP change webhook settings
+func validateIPChangeWebhook(config *Config) {
+	if config.IPChangeWebhookURL == "" {
+		return
+	}
+	if !strings.HasPrefix(config.IPChangeWebhookURL, "https://") && !strings.HasPrefix(config.IPChangeWebhookURL, "http://") {
+		configFatalf("ERROR: %sIP_CHANGE_WEBHOOK_URL must be an http or https URL, got %q", envPrefix, config.IPChangeWebhookURL)
+	}
+	if config.StateFile == "" {
+		configFatalf("ERROR: %sIP_CHANGE_WEBHOOK_URL needs %sSTATE_FILE to remember the announced addresses", envPrefix, envPrefix)
+	}
+}
+
+// notifyIPChange announces external addresses that changed since the last announcement
+func notifyIPChange(config *Config, ips *IPAddresses) {
+	webhook, notify := config.IPChangeWebhookURL != "", config.notifies(notifyEventIPChange)
+	if (!webhook && !notify) || config.StateFile == "" {
+		return
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, IP changes not announced: %v", err)
+		return
+	}
+
+	last := state.LastExternal
+	if last == nil {
+		// The first detection is remembered: nothing is known to have changed yet
+		state.LastExternal = &ExternalAddresses{IPv4: ips.ExternalIPv4, IPv6: ips.ExternalIPv6}
+		saveIPChangeState(config, state)
+		return
+	}
+	next := *last
+	event := ipChangeEvent{Event: "ip-change", Host: statusPageHost(config), Timestamp: config.now().Unix()}
+	if ips.ExternalIPv4 != "" && ips.ExternalIPv4 != last.IPv4 {
+		if last.IPv4 != "" {
+			event.IPv4 = &addressChange{Old: last.IPv4, New: ips.ExternalIPv4}
+		}
+		next.IPv4 = ips.ExternalIPv4
+	}
+	if ips.ExternalIPv6 != "" && ips.ExternalIPv6 != last.IPv6 {
+		if last.IPv6 != "" {
+			event.IPv6 = &addressChange{Old: last.IPv6, New: ips.ExternalIPv6}
+		}
+		next.IPv6 = ips.ExternalIPv6
+	}
+	if next == *last {
+		return
+	}
+
+	if event.IPv4 != nil || event.IPv6 != nil {
+		event.Domains = ipChangeDomains(config, event.IPv4 != nil, event.IPv6 != nil)
+		if webhook {
+			if err := sendIPChange(config, event); err != nil {
+				log.Printf("WARNING: IP change webhook failed (retried next run): %v", err)
+				return
+			}
+			log.Printf("Announced external address change to %s", config.IPChangeWebhookURL)
+		}
+		if notify {
+			notifyEvent(config, notifyEventIPChange, "External address changed", formatIPChange(event))
+		}
+	}
+	state.LastExternal = &next
+	saveIPChangeState(config, state)
+}
+
+// formatIPChange describes an IP change event for the notifiers
+func formatIPChange(event ipChangeEvent) string {
+	var b strings.Builder
+	if event.IPv4 != nil {
+		fmt.Fprintf(&b, "IPv4: %s -> %s\n", event.IPv4.Old, event.IPv4.New)
+	}
+	if event.IPv6 != nil {
+		fmt.Fprintf(&b, "IPv6: %s -> %s\n", event.IPv6.Old, event.IPv6.New)
+	}
+	if len(event.Domains) > 0 {
+		fmt.Fprintf(&b, "Domains: %s\n", strings.Join(event.Domains, ", "))
+	}
+	return b.String()
+}
+
+// saveIPChangeState saves the announced addresses
+func saveIPChangeState(config *Config, state *State) {
+	if err := state.save(config.StateFile); err != nil {
+		log.Printf("WARNING: Could not save state: %v", err)
+	}
+}
+
+// ipChangeDomains returns the names publishing the changed families
+func ipChangeDomains(config *Config, ipv4, ipv6 bool) []string {
+	set := make(map[string]bool)
+	add := func(names ...string) {
+		for _, name := range names {
+			if name != "" {
+				set[name] = true
+			}
+		}
+	}
+	if ipv4 {
+		add(config.ExternalDomain)
+	}
+	if ipv6 {
+		add(config.IPv6Domain)
+	}
+	add(config.CombinedDomain, config.TopLevelDomain)
+	add(config.DynDNS2Hostnames...)
+
+	domains := make([]string, 0, len(set))
+	for name := range set {
+		domains = append(domains, name)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// sendIPChange POSTs an IP change event
+func sendIPChange(config *Config, event ipChangeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", config.IPChangeWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", dynDNS2UserAgent)
+	if config.IPChangeWebhookSecret != "" {
+		req.Header.Set(webhookSignatureHeader, webhookSignature(config.IPChangeWebhookSecret, body))
+	}
+
+	resp, err := newRetryHTTPClient(newRetryPolicy(config), providerRequestTimeout).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifyIPChange(t *testing.T) {
+	var mu sync.Mutex
+	var events []ipChangeEvent
+	failing := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(webhookSignatureHeader); got != webhookSignature("secret", body) {
+			t.Errorf("signature = %q", got)
+		}
+		var event ipChangeEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Error(err)
+		}
+		events = append(events, event)
+	}))
+	defer srv.Close()
+
+	config := &Config{
+		ExternalDomain:        "home.example.com",
+		IPv6Domain:            "home6.example.com",
+		CombinedDomain:        "nas.example.com",
+		HeartbeatHost:         "nas",
+		StateFile:             filepath.Join(t.TempDir(), "state.json"),
+		IPChangeWebhookURL:    srv.URL,
+		IPChangeWebhookSecret: "secret",
+		APIRetryAttempts:      1,
+		clock:                 newFakeClock(time.Unix(1700000000, 0)),
+	}
+	sent := func() []ipChangeEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]ipChangeEvent{}, events...)
+	}
+
+	// The first detection is only remembered, and unchanged or failed detections send nothing
+	notifyIPChange(config, &IPAddresses{ExternalIPv4: "203.0.113.1", ExternalIPv6: "2001:db8::1"})
+	notifyIPChange(config, &IPAddresses{ExternalIPv4: "203.0.113.1", ExternalIPv6: "2001:db8::1"})
+	notifyIPChange(config, &IPAddresses{})
+	if got := sent(); len(got) != 0 {
+		t.Fatalf("events without a change: %+v", got)
+	}
+
+	notifyIPChange(config, &IPAddresses{ExternalIPv4: "203.0.113.2", ExternalIPv6: "2001:db8::1"})
+	want := ipChangeEvent{
+		Event: "ip-change", Host: "nas.example.com", Timestamp: 1700000000,
+		IPv4:    &addressChange{Old: "203.0.113.1", New: "203.0.113.2"},
+		Domains: []string{"home.example.com", "nas.example.com"},
+	}
+	if got := sent(); len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Fatalf("events = %+v, want %+v", got, want)
+	}
+
+	// A failed request is repeated by the next run
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	notifyIPChange(config, &IPAddresses{ExternalIPv4: "203.0.113.2", ExternalIPv6: "2001:db8::2"})
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	notifyIPChange(config, &IPAddresses{ExternalIPv4: "203.0.113.2", ExternalIPv6: "2001:db8::2"})
+	got := sent()
+	if len(got) != 2 || got[1].IPv4 != nil || !reflect.DeepEqual(got[1].IPv6, &addressChange{Old: "2001:db8::1", New: "2001:db8::2"}) ||
+		!reflect.DeepEqual(got[1].Domains, []string{"home6.example.com", "nas.example.com"}) {
+		t.Errorf("events after a failed request = %+v", got)
+	}
+}
+
+func TestValidateIPChangeWebhook(t *testing.T) {
+	t.Setenv(envPrefix+"CF_API_TOKEN", "token")
+	t.Setenv(envPrefix+"CF_ZONE_ID", "zone")
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+	t.Setenv(envPrefix+"IP_CHANGE_WEBHOOK_URL", "https://firewall.example.com/hook")
+	if _, err := reloadConfig(false); err == nil {
+		t.Error("IP change webhook without state file accepted")
+	}
+	t.Setenv(envPrefix+"STATE_FILE", filepath.Join(t.TempDir(), "state.json"))
+	if _, err := reloadConfig(false); err != nil {
+		t.Error(err)
+	}
+	t.Setenv(envPrefix+"IP_CHANGE_WEBHOOK_URL", "firewall.example.com")
+	if _, err := reloadConfig(false); err == nil {
+		t.Error("IP change webhook URL without scheme accepted")
+	}
+}
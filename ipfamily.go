@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// Addresses are handled as netip.Addr values, normalized so that equal addresses compare
+// equal: IPv4-mapped IPv6 forms (::ffff:192.0.2.1) become plain IPv4, and interface zones
+// (fe80::1%eth0) are dropped since they cannot be published in DNS. Only the results of
+// detection (IPAddresses) and record contents remain strings.
+
+// rfc1918Prefixes are the RFC1918 private ranges, parsed once
+var rfc1918Prefixes = mustParsePrefixes(rfc1918Ranges)
+
+// mustParsePrefixes parses built-in CIDRs, panicking on a typo
+func mustParsePrefixes(cidrs []string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefixes = append(prefixes, netip.MustParsePrefix(cidr))
+	}
+	return prefixes
+}
+
+// normalizeAddr unmaps IPv4-mapped addresses and drops any zone
+func normalizeAddr(addr netip.Addr) netip.Addr {
+	return addr.Unmap().WithZone("")
+}
+
+// parseAddr parses and normalizes an address
+func parseAddr(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return normalizeAddr(addr), nil
+}
+
+// parseFamilyAddr parses an address that must belong to the family of recordType
+// ("A" for IPv4, "AAAA" for IPv6), returning it in canonical form
+func parseFamilyAddr(s, recordType string) (netip.Addr, error) {
+	addr, err := parseAddr(s)
+	if err != nil || addr.Is4() != (recordType == "A") {
+		family := "IPv6"
+		if recordType == "A" {
+			family = "IPv4"
+		}
+		return netip.Addr{}, fmt.Errorf("not an %s address: %q", family, s)
+	}
+	return addr, nil
+}
+
+// parseRangePrefix parses a custom range CIDR of the family of recordType. The prefix is
+// masked, so 10.1.2.3/8 and 10.0.0.0/8 describe the same range.
+func parseRangePrefix(cidr, recordType string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() {
+		// ::ffff:10.0.0.0/104 is the IPv4 range 10.0.0.0/8
+		if prefix.Bits() < 96 {
+			return netip.Prefix{}, fmt.Errorf("IPv4-mapped prefix %s is shorter than /96", cidr)
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	if prefix.Addr().Is4() != (recordType == "A") {
+		return netip.Prefix{}, fmt.Errorf("%s is not a range for %s records", cidr, recordType)
+	}
+	return prefix.Masked(), nil
+}
+
+// addrFromNet extracts the normalized address of an interface address
+func addrFromNet(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch v := addr.(type) {
+	case *net.IPNet:
+		ip = v.IP
+	case *net.IPAddr:
+		ip = v.IP
+	}
+	a, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return normalizeAddr(a), true
+}
+
+// containsAddr reports whether any of the prefixes contains addr
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrSet is a set of normalized addresses
+type addrSet map[netip.Addr]bool
+
+// newAddrSet builds a set from address strings, skipping any that do not parse
+func newAddrSet(addresses []string) addrSet {
+	set := make(addrSet, len(addresses))
+	for _, s := range addresses {
+		if addr, err := parseAddr(s); err == nil {
+			set[addr] = true
+		}
+	}
+	return set
+}
+
+// hasContent reports whether record content is an address in the set
+func (s addrSet) hasContent(content string) bool {
+	addr, err := parseAddr(content)
+	return err == nil && s[addr]
+}
+
+// sameContent reports whether two record contents are equal, comparing addresses by value
+// so that e.g. 2001:DB8::1 and 2001:db8:0::1 match
+func sameContent(a, b string) bool {
+	if a == b {
+		return true
+	}
+	addrA, errA := parseAddr(a)
+	addrB, errB := parseAddr(b)
+	return errA == nil && errB == nil && addrA == addrB
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseAddrNormalizes(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"192.0.2.1", "192.0.2.1"},
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"2001:DB8:0::1", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+	}
+	for _, tt := range tests {
+		addr, err := parseAddr(tt.in)
+		if err != nil || addr.String() != tt.want {
+			t.Errorf("parseAddr(%q) = %v, %v; want %s", tt.in, addr, err, tt.want)
+		}
+	}
+	if _, err := parseAddr("not-an-ip"); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
+
+func TestParseFamilyAddr(t *testing.T) {
+	if addr, err := parseFamilyAddr("::ffff:203.0.113.7", "A"); err != nil || addr.String() != "203.0.113.7" {
+		t.Errorf("mapped IPv4 = %v, %v", addr, err)
+	}
+	if _, err := parseFamilyAddr("2001:db8::1", "A"); err == nil {
+		t.Error("accepted IPv6 for A")
+	}
+	if _, err := parseFamilyAddr("203.0.113.7", "AAAA"); err == nil {
+		t.Error("accepted IPv4 for AAAA")
+	}
+	if _, err := parseFamilyAddr("::ffff:203.0.113.7", "AAAA"); err == nil {
+		t.Error("accepted a mapped IPv4 address for AAAA")
+	}
+}
+
+func TestParseRangePrefix(t *testing.T) {
+	tests := []struct {
+		cidr, recordType, want string
+	}{
+		{"100.64.1.2/10", "A", "100.64.0.0/10"},
+		{"::ffff:10.0.0.0/104", "A", "10.0.0.0/8"},
+		{"2001:DB8:1::/48", "AAAA", "2001:db8:1::/48"},
+	}
+	for _, tt := range tests {
+		prefix, err := parseRangePrefix(tt.cidr, tt.recordType)
+		if err != nil || prefix.String() != tt.want {
+			t.Errorf("parseRangePrefix(%q) = %v, %v; want %s", tt.cidr, prefix, err, tt.want)
+		}
+	}
+	for _, bad := range []struct{ cidr, recordType string }{
+		{"2001:db8::/32", "A"},
+		{"10.0.0.0/8", "AAAA"},
+		{"10.0.0.0", "A"},
+	} {
+		if _, err := parseRangePrefix(bad.cidr, bad.recordType); err == nil {
+			t.Errorf("parseRangePrefix(%q, %s) accepted", bad.cidr, bad.recordType)
+		}
+	}
+}
+
+func TestGetInternalIPv4(t *testing.T) {
+	addrs := []interfaceAddr{
+		{Addr: netip.MustParseAddr("100.64.0.5"), Interface: "tailscale0"},
+		{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "br0"}, // Duplicate
+		{Addr: netip.MustParseAddr("10.1.2.3"), Interface: "wg0"},
+	}
+	if got := getInternalIPv4(addrs); !reflect.DeepEqual(got, []string{"192.168.1.10", "10.1.2.3"}) {
+		t.Errorf("internal IPv4 = %v", got)
+	}
+}
+
+func TestAddrFromNet(t *testing.T) {
+	mapped := &net.IPNet{IP: net.ParseIP("::ffff:192.168.1.10"), Mask: net.CIDRMask(120, 128)}
+	if addr, ok := addrFromNet(mapped); !ok || addr.String() != "192.168.1.10" {
+		t.Errorf("mapped interface address = %v, %v", addr, ok)
+	}
+	if _, ok := addrFromNet(&net.UnixAddr{Name: "/tmp/sock"}); ok {
+		t.Error("accepted a non-IP address")
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"2001:db8::1", "2001:DB8:0:0::1", true},
+		{"192.0.2.1", "::ffff:192.0.2.1", true},
+		{"192.0.2.1", "192.0.2.2", false},
+		{"host.example.com", "host.example.com", true},
+		{"host.example.com", "other.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := sameContent(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameContent(%q, %q) = %v", tt.a, tt.b, got)
+		}
+	}
+
+	set := newAddrSet([]string{"2001:db8::1", "bogus"})
+	if !set.hasContent("2001:DB8::1") || set.hasContent("2001:db8::2") || set.hasContent("bogus") {
+		t.Errorf("addrSet lookups wrong: %v", set)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Service and account names used for the OS credential store entry
+const (
+	keychainService = "dynipupdate"
+	keychainAccount = "cf-api-token"
+)
+
+// errKeychainNotFound is returned when no token is stored in the OS credential store
+var errKeychainNotFound = errors.New("no token stored in the OS credential store")
+
+// verifyCloudFlareToken checks the token against CloudFlare's token verification endpoint
+func verifyCloudFlareToken(baseURL, token string) error {
+	req, err := http.NewRequest("GET", baseURL+"/user/tokens/verify", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool              `json:"success"`
+		Errors  []json.RawMessage `json:"errors"`
+		Result  struct {
+			Status string `json:"status"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("token rejected: %s", formatErrors(result.Errors))
+	}
+	if result.Result.Status != "active" {
+		return fmt.Errorf("token status is %q", result.Result.Status)
+	}
+	return nil
+}
+
+// runLogin implements the login subcommand: stores the API token in the OS credential store
+func runLogin(args []string) int {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	skipVerify := fs.Bool("skip-verify", false, "Store the token without verifying it with CloudFlare")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	fmt.Fprint(os.Stderr, "CloudFlare API token: ")
+	restore := hideInput(os.Stdin)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if restore != nil {
+		restore()
+		fmt.Fprintln(os.Stderr) // The Enter key was not echoed either
+	}
+	if err != nil && line == "" {
+		fmt.Fprintf(os.Stderr, "\nError reading token: %v\n", err)
+		return 1
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		fmt.Fprintln(os.Stderr, "No token entered")
+		return 1
+	}
+
+	if !*skipVerify {
+		if err := verifyCloudFlareToken("https://api.cloudflare.com/client/v4", token); err != nil {
+			fmt.Fprintf(os.Stderr, "Token verification failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(os.Stderr, "Token verified")
+	}
+
+	if err := keychainStore(token); err != nil {
+		fmt.Fprintf(os.Stderr, "Error storing token: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "Token stored in the OS credential store. %sCF_API_TOKEN can now be left unset.\n", envPrefix)
+	return 0
+}
+
+// runLogout implements the logout subcommand: removes the stored API token
+func runLogout(args []string) int {
+	if err := keychainDelete(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error removing token: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "Token removed from the OS credential store")
+	return 0
+}
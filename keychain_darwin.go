@@ -0,0 +1,38 @@
+//go:build darwin
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// The ioctl requests reading and setting a terminal's attributes (see hideInput)
+const ioctlGetTermios, ioctlSetTermios = syscall.TIOCGETA, syscall.TIOCSETA
+
+// keychainStore saves the token in the macOS login keychain. -w without a value (it must
+// be the last option) makes security read the token from stdin, so it never appears in
+// the process list; security asks for it twice.
+func keychainStore(token string) error {
+	cmd := exec.Command("security", "add-generic-password", "-U",
+		"-s", keychainService, "-a", keychainAccount, "-w")
+	cmd.Stdin = strings.NewReader(token + "\n" + token + "\n")
+	return cmd.Run()
+}
+
+// keychainLoad reads the token from the macOS login keychain
+func keychainLoad() (string, error) {
+	out, err := exec.Command("security", "find-generic-password",
+		"-s", keychainService, "-a", keychainAccount, "-w").Output()
+	if err != nil {
+		return "", errKeychainNotFound
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keychainDelete removes the token from the macOS login keychain
+func keychainDelete() error {
+	return exec.Command("security", "delete-generic-password",
+		"-s", keychainService, "-a", keychainAccount).Run()
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// The ioctl requests reading and setting a terminal's attributes (see hideInput)
+const ioctlGetTermios, ioctlSetTermios = syscall.TCGETS, syscall.TCSETS
+
+// keychainStore saves the token via libsecret (GNOME Keyring, KWallet) using secret-tool
+func keychainStore(token string) error {
+	cmd := exec.Command("secret-tool", "store", "--label=dynipupdate CloudFlare API token",
+		"service", keychainService, "account", keychainAccount)
+	cmd.Stdin = strings.NewReader(token)
+	return cmd.Run()
+}
+
+// keychainLoad reads the token via libsecret using secret-tool
+func keychainLoad() (string, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return "", errKeychainNotFound
+	}
+	out, err := exec.Command("secret-tool", "lookup",
+		"service", keychainService, "account", keychainAccount).Output()
+	if err != nil || len(out) == 0 {
+		return "", errKeychainNotFound
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keychainDelete removes the token via libsecret using secret-tool
+func keychainDelete() error {
+	return exec.Command("secret-tool", "clear",
+		"service", keychainService, "account", keychainAccount).Run()
+}
@@ -0,0 +1,28 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errKeychainUnsupported is returned on platforms without a supported credential store
+var errKeychainUnsupported = errors.New("OS credential store is not supported on this platform")
+
+func keychainStore(token string) error {
+	return errKeychainUnsupported
+}
+
+func keychainLoad() (string, error) {
+	return "", errKeychainNotFound
+}
+
+func keychainDelete() error {
+	return errKeychainUnsupported
+}
+
+// hideInput leaves the echo on: the credential store is not supported here anyway
+func hideInput(f *os.File) func() {
+	return nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHideInputPiped(t *testing.T) {
+	// A token piped to login is read as is, without touching a terminal
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if restore := hideInput(r); restore != nil {
+		restore()
+		t.Error("hid the input of a pipe")
+	}
+}
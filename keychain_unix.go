@@ -0,0 +1,28 @@
+//go:build darwin || linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// hideInput turns off the echo of a terminal, so a token typed at the login prompt does not
+// show; it returns the function restoring the terminal, or nil when f is not a terminal
+// (piped input is read as is)
+func hideInput(f *os.File) func() {
+	fd := f.Fd()
+	var saved syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(&saved))); errno != 0 {
+		return nil
+	}
+	hidden := saved
+	hidden.Lflag &^= syscall.ECHO // Lines are still read whole (ICANON stays on)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(&hidden))); errno != 0 {
+		return nil
+	}
+	return func() {
+		syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(&saved)))
+	}
+}
@@ -0,0 +1,117 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+	procSetConsoleMode     = kernel32.NewProc("SetConsoleMode")
+)
+
+// CRYPTPROTECT_UI_FORBIDDEN: fail rather than prompt
+const cryptProtectUIForbidden = 0x1
+
+// ENABLE_ECHO_INPUT: the console echoes typed characters
+const consoleEchoInput = 0x4
+
+// dataBlob mirrors the Win32 DATA_BLOB structure
+type dataBlob struct {
+	cbData uint32
+	pbData *byte
+}
+
+// dpapiCall runs CryptProtectData or CryptUnprotectData over data for the current user
+func dpapiCall(proc *syscall.LazyProc, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errKeychainNotFound
+	}
+	in := dataBlob{cbData: uint32(len(data)), pbData: &data[0]}
+	var out dataBlob
+
+	r, _, err := proc.Call(uintptr(unsafe.Pointer(&in)), 0, 0, 0, 0,
+		cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.pbData)))
+
+	return append([]byte(nil), unsafe.Slice(out.pbData, out.cbData)...), nil
+}
+
+// dpapiTokenPath returns the path of the DPAPI-encrypted token file
+func dpapiTokenPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, keychainService, keychainAccount+".dpapi"), nil
+}
+
+// keychainStore encrypts the token with DPAPI (current user scope) and writes it to %AppData%
+func keychainStore(token string) error {
+	path, err := dpapiTokenPath()
+	if err != nil {
+		return err
+	}
+	encrypted, err := dpapiCall(procCryptProtectData, []byte(token))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, encrypted, 0o600)
+}
+
+// keychainLoad reads and decrypts the DPAPI-protected token
+func keychainLoad() (string, error) {
+	path, err := dpapiTokenPath()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := os.ReadFile(path)
+	if err != nil {
+		return "", errKeychainNotFound
+	}
+	decrypted, err := dpapiCall(procCryptUnprotectData, encrypted)
+	if err != nil {
+		return "", err
+	}
+	return string(decrypted), nil
+}
+
+// keychainDelete removes the DPAPI-protected token file
+func keychainDelete() error {
+	path, err := dpapiTokenPath()
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// hideInput turns off the echo of the console, so a token typed at the login prompt does not
+// show; it returns the function restoring the console, or nil when f is not a console (piped
+// input is read as is)
+func hideInput(f *os.File) func() {
+	handle := syscall.Handle(f.Fd())
+	var saved uint32
+	if err := syscall.GetConsoleMode(handle, &saved); err != nil {
+		return nil
+	}
+	if ok, _, _ := procSetConsoleMode.Call(uintptr(handle), uintptr(saved&^consoleEchoInput)); ok == 0 {
+		return nil
+	}
+	return func() {
+		procSetConsoleMode.Call(uintptr(handle), uintptr(saved))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// When the published external address changes, the ISP may also have moved the line to
+// other equipment. With BEES_IP_UPDATE_LATENCY_PROBE_TARGET set, a few TCP connections are
+// timed to a reference host right after the change, and the result is stored with the
+// change history and sent as a notification, so line quality can be compared across
+// address changes. TCP connects are used rather than ICMP, which needs privileges.
+
+// Latency probe parameters
+const (
+	latencyProbeCount   = 5
+	latencyProbeTimeout = 3 * time.Second
+)
+
+// LatencyProbe is the result of a latency probe
+type LatencyProbe struct {
+	Target   string  `json:"target"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	MinMs    float64 `json:"min_ms,omitempty"`
+	MedianMs float64 `json:"median_ms,omitempty"`
+	MaxMs    float64 `json:"max_ms,omitempty"`
+}
+
+// String describes the result for logs and notifications
+func (p *LatencyProbe) String() string {
+	if p.Received == 0 {
+		return fmt.Sprintf("%s unreachable (0/%d connections)", p.Target, p.Sent)
+	}
+	return fmt.Sprintf("%s: min %.1f ms, median %.1f ms, max %.1f ms (%d/%d connections)",
+		p.Target, p.MinMs, p.MedianMs, p.MaxMs, p.Received, p.Sent)
+}
+
+// probeLatency times count TCP connections to target (host:port)
+func probeLatency(target string, count int, timeout time.Duration) *LatencyProbe {
+	probe := &LatencyProbe{Target: target, Sent: count}
+	var samples []float64
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", target, timeout)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, float64(time.Since(start).Microseconds())/1000)
+		conn.Close()
+	}
+
+	probe.Received = len(samples)
+	if len(samples) > 0 {
+		sort.Float64s(samples)
+		round := func(ms float64) float64 { return math.Round(ms*10) / 10 }
+		probe.MinMs = round(samples[0])
+		probe.MedianMs = round(samples[len(samples)/2])
+		probe.MaxMs = round(samples[len(samples)-1])
+	}
+	return probe
+}
+
+// externalAddressDomains returns the domains publishing the external addresses
+func externalAddressDomains(config *Config) map[string]bool {
+	domains := make(map[string]bool)
+	for _, domain := range []string{config.ExternalDomain, config.IPv6Domain, config.CombinedDomain} {
+		if domain != "" {
+			domains[domain] = true
+		}
+	}
+	return domains
+}
+
+// probeAfterExternalChange probes the line when this run changed an external address
+// record, notifying the result. Returns nil when disabled or nothing external changed.
+func probeAfterExternalChange(config *Config, changes []RecordChange, notifier Notifier) *LatencyProbe {
+	if config.LatencyProbeTarget == "" {
+		return nil
+	}
+	external := externalAddressDomains(config)
+	var changed []string
+	for _, c := range changes {
+		if (c.Type == "A" || c.Type == "AAAA") && c.Action != "delete" && external[c.Name] {
+			changed = append(changed, fmt.Sprintf("%s %s -> %s", c.Type, c.Name, c.Content))
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	probe := probeLatency(config.LatencyProbeTarget, latencyProbeCount, latencyProbeTimeout)
+	log.Printf("Latency after external address change: %s", probe)
+
+	message := strings.Join(changed, "\n") + "\n\nLatency to " + probe.String() + "\n"
+	if err := notifier.Notify("external-address-change", "External address changed", message); err != nil {
+		log.Printf("Failed to send external address change notification: %v", err)
+	}
+	return probe
+}
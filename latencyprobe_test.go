@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// acceptTCP listens on a local port, accepting and closing connections, and returns its address
+func acceptTCP(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestProbeLatency(t *testing.T) {
+	target := acceptTCP(t)
+
+	probe := probeLatency(target, 3, time.Second)
+	if probe.Sent != 3 || probe.Received != 3 {
+		t.Errorf("probe = %+v, want 3/3 connections", probe)
+	}
+	if probe.MinMs > probe.MedianMs || probe.MedianMs > probe.MaxMs {
+		t.Errorf("probe = %+v, want min <= median <= max", probe)
+	}
+
+	// A closed port refuses every connection
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := closed.Addr().String()
+	closed.Close()
+	probe = probeLatency(refused, 2, time.Second)
+	if probe.Received != 0 || !strings.Contains(probe.String(), "unreachable (0/2 connections)") {
+		t.Errorf("probe of closed port = %+v (%s)", probe, probe)
+	}
+}
+
+func TestProbeAfterExternalChange(t *testing.T) {
+	target := acceptTCP(t)
+
+	config := &Config{ExternalDomain: "home.example.com", InternalDomain: "nas.example.com", LatencyProbeTarget: target}
+	notifier := &recordingNotifier{}
+
+	// Internal changes, deletions and heartbeats are not probed
+	internal := []RecordChange{
+		{Action: "update", Type: "A", Name: "nas.example.com", Content: "192.168.1.2"},
+		{Action: "delete", Type: "A", Name: "home.example.com", Content: "203.0.113.1"},
+		{Action: "update", Type: "TXT", Name: "home.example.com", Content: "heartbeat"},
+	}
+	if probe := probeAfterExternalChange(config, internal, notifier); probe != nil || len(notifier.events) != 0 {
+		t.Errorf("probe = %+v, events %q; want nothing", probe, notifier.events)
+	}
+
+	external := append(internal, RecordChange{Action: "update", Type: "A", Name: "home.example.com", Content: "203.0.113.2"})
+	probe := probeAfterExternalChange(config, external, notifier)
+	if probe == nil || probe.Received != latencyProbeCount {
+		t.Fatalf("probe = %+v", probe)
+	}
+	if len(notifier.events) != 1 || notifier.events[0] != "external-address-change: External address changed" {
+		t.Errorf("events = %q", notifier.events)
+	}
+
+	// The probe is stored with the external domain's change and shows in the report
+	state := newState()
+	recordRunStats(config, state, external, time.Now(), probe)
+	if state.Domains["home.example.com"].Changes[0].Probe != probe || state.Domains["nas.example.com"].Changes[0].Probe != nil {
+		t.Errorf("probe not attached to the external domain only: %+v", state.Domains)
+	}
+	stats := computeDomainStats("home.example.com", state.Domains["home.example.com"].Changes, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), time.Minute)
+	if stats.ProbedChanges != 1 || !strings.Contains(formatStatsReport([]DomainStats{stats}), "Latency after changes:") {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	config.LatencyProbeTarget = ""
+	if probe := probeAfterExternalChange(config, external, notifier); probe != nil {
+		t.Errorf("probe without a target = %+v", probe)
+	}
+}
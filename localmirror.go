@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The local mirror (LOCAL_MIRROR) writes the internal records to a resolver on the LAN in
+// addition to the main provider - with RFC 2136 updates to a local zone (unbound, BIND,
+// Knot, PowerDNS), to a hosts file (dnsmasq, Pi-hole) or to an unbound local-data include
+// file - so names keep resolving on the LAN while the internet link, and with it the public
+// DNS provider, is down. Only records
+// useful on the LAN are mirrored: the internal domain, the custom ranges, the combined
+// domain and its top-level alias. Heartbeats are not mirrored.
+
+// localMirrorUnbound selects the unbound local-data writer, which is only a mirror target
+const localMirrorUnbound = "unbound"
+
+// localMirrorReloadTimeout bounds LOCAL_MIRROR_RELOAD_COMMAND
+const localMirrorReloadTimeout = 30 * time.Second
+
+// localMirror is the client for the local mirror, kept across runs
+var (
+	localMirrorOnce   sync.Once
+	localMirror       providerClient
+	localMirrorFailed error
+)
+
+// newLocalMirrorClient creates the mirror's provider client from the LOCAL_MIRROR_* settings
+func newLocalMirrorClient(config *Config) (providerClient, error) {
+	mirror := *config
+	mirror.Provider = config.LocalMirror
+	mirror.RFC2136Server = config.LocalMirrorServer
+	mirror.RFC2136Zone = config.LocalMirrorZone
+	mirror.HostsFilePath = config.LocalMirrorHostsFile
+	mirror.Proxied = false
+	mirror.DomainProxied = nil
+	mirror.RecordTags = nil
+	mirror.Changelog = false
+	if config.LocalMirror == localMirrorUnbound {
+		return newUnboundFileProvider(config.LocalMirrorUnboundFile, &mirror)
+	}
+	return newProviderClient(&mirror)
+}
+
+// mirrorRecordSet is the wanted content of one name and type on the local mirror
+type mirrorRecordSet struct {
+	Name   string
+	Type   string
+	Values []string
+}
+
+// localMirrorRecords returns the record sets to mirror
+func localMirrorRecords(config *Config, ips *IPAddresses) []mirrorRecordSet {
+	var sets []mirrorRecordSet
+	set := func(name, recordType string, values []string) {
+		if name != "" {
+			sets = append(sets, mirrorRecordSet{Name: name, Type: recordType, Values: values})
+		}
+	}
+
+	set(config.InternalDomain, "A", ips.InternalIPv4)
+	for _, r := range config.CustomIPv4Ranges {
+		set(r.Domain, "A", ips.CustomRangeIPs[r.Domain])
+	}
+	for _, r := range config.CustomIPv6Ranges {
+		set(r.Domain, "AAAA", ips.CustomRangeIPs[r.Domain])
+	}
+
+	if config.CombinedDomain != "" {
+		combined := append([]string{}, ips.InternalIPv4...)
+		for _, r := range config.CustomIPv4Ranges {
+			combined = append(combined, ips.CustomRangeIPs[r.Domain]...)
+		}
+		if ips.ExternalIPv4 != "" {
+			combined = append(combined, ips.ExternalIPv4)
+		}
+		set(config.CombinedDomain, "A", combined)
+		var ipv6 []string
+		if ips.ExternalIPv6 != "" {
+			ipv6 = []string{ips.ExternalIPv6}
+		}
+		set(config.CombinedDomain, "AAAA", ipv6)
+		set(config.TopLevelDomain, "CNAME", []string{config.CombinedDomain})
+	}
+	return sets
+}
+
+// syncRecordSet makes the records of a name and type exactly values
+func syncRecordSet(cf providerClient, name, recordType string, values []string) (successCount, totalCount int) {
+	if recordType == "CNAME" {
+		totalCount++
+		if succeeded(cf.upsertRecord(name, recordType, values[0], false)) {
+			successCount++
+		}
+		return successCount, totalCount
+	}
+
+	wanted := newAddrSet(values)
+	records, err := cf.getAllRecords(name, recordType)
+	if !succeeded(err) {
+		totalCount++
+	}
+	for _, record := range records {
+		if !wanted.hasContent(record.Content) {
+			totalCount++
+			if succeeded(cf.deleteRecord(record.ID, name, recordType)) {
+				successCount++
+			}
+		}
+	}
+	for _, value := range values {
+		totalCount++
+		if succeeded(cf.ensureRecordExists(name, recordType, value, false)) {
+			successCount++
+		}
+	}
+	return successCount, totalCount
+}
+
+// pushLocalMirror mirrors the internal records to the local resolver and runs the reload
+// command after changes. Returns the number of successful/attempted operations.
+func pushLocalMirror(config *Config, ips *IPAddresses) (successCount, totalCount int) {
+	if config.LocalMirror == "" {
+		return 0, 0
+	}
+	localMirrorOnce.Do(func() {
+		localMirror, localMirrorFailed = newLocalMirrorClient(config)
+	})
+	if localMirrorFailed != nil {
+		log.Printf("ERROR: Local mirror unavailable: %v", localMirrorFailed)
+		return 0, 1
+	}
+
+	changed := 0
+	localMirror.hooks().OnChange = func(action, recordType, name, content string) { changed++ }
+	defer func() { localMirror.hooks().OnChange = nil }()
+
+	log.Printf("Mirroring internal records to the local %s resolver", config.LocalMirror)
+	for _, set := range localMirrorRecords(config, ips) {
+		success, total := syncRecordSet(localMirror, set.Name, set.Type, set.Values)
+		successCount += success
+		totalCount += total
+	}
+
+	if changed > 0 && config.LocalMirrorReloadCommand != "" {
+		totalCount++
+		if runLocalMirrorReload(config.LocalMirrorReloadCommand) {
+			successCount++
+		}
+	}
+	return successCount, totalCount
+}
+
+// runLocalMirrorReload runs the reload command (split on spaces, no shell)
+func runLocalMirrorReload(command string) bool {
+	args := strings.Fields(command)
+	ctx, cancel := context.WithTimeout(context.Background(), localMirrorReloadTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+
+	log.Printf("Reloading local resolver: %s", command)
+	if err := cmd.Run(); err != nil {
+		log.Printf("ERROR: Local resolver reload failed: %v", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestLocalMirrorReloadHelper is not a real test: the local mirror tests run the test binary
+// as the reload command, which appends a line to the file named by the environment
+func TestLocalMirrorReloadHelper(t *testing.T) {
+	path := os.Getenv("DYNIPUPDATE_RELOAD_LOG")
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		os.Exit(1)
+	}
+	f.WriteString("reload\n")
+	f.Close()
+	os.Exit(0)
+}
+
+func TestPushLocalMirror(t *testing.T) {
+	t.Cleanup(func() { localMirrorOnce, localMirror, localMirrorFailed = sync.Once{}, nil, nil })
+	dir := t.TempDir()
+	hostsPath := filepath.Join(dir, "hosts")
+	reloadLog := filepath.Join(dir, "reloads")
+	t.Setenv("DYNIPUPDATE_RELOAD_LOG", reloadLog)
+
+	config := &Config{
+		InternalDomain:           "nas.i.example.com",
+		ExternalDomain:           "nas.e.example.com",
+		CombinedDomain:           "nas.example.com",
+		TopLevelDomain:           "nas.example.org",
+		CustomIPv4Ranges:         []CustomIPRange{{CIDR: "100.64.0.0/10", Domain: "nas.ts.example.com", Type: "A"}},
+		LocalMirror:              providerHosts,
+		LocalMirrorHostsFile:     hostsPath,
+		LocalMirrorReloadCommand: os.Args[0] + " -test.run=^TestLocalMirrorReloadHelper$",
+		Changelog:                true,
+		RecordTTL:                120,
+	}
+	ips := &IPAddresses{
+		InternalIPv4:   []string{"192.168.1.10"},
+		ExternalIPv4:   "203.0.113.1",
+		CustomRangeIPs: map[string][]string{"nas.ts.example.com": {"100.64.0.5"}},
+	}
+	reloads := func() int {
+		data, _ := os.ReadFile(reloadLog)
+		return strings.Count(string(data), "reload")
+	}
+
+	success, total := pushLocalMirror(config, ips)
+	if success != total || total == 0 {
+		t.Fatalf("pushLocalMirror = %d/%d", success, total)
+	}
+	data, err := os.ReadFile(hostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"192.168.1.10\tnas.i.example.com",
+		"100.64.0.5\tnas.ts.example.com",
+		"192.168.1.10\tnas.example.com",
+		"100.64.0.5\tnas.example.com",
+		"203.0.113.1\tnas.example.com",
+		"# CNAME nas.example.org -> nas.example.com",
+		"203.0.113.1\tnas.example.org",
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("hosts file lacks %q:\n%s", line, data)
+		}
+	}
+	if strings.Contains(string(data), "nas.e.example.com") {
+		t.Errorf("external domain mirrored:\n%s", data)
+	}
+	if reloads() != 1 {
+		t.Errorf("reloads = %d, want 1", reloads())
+	}
+	if !config.Changelog {
+		t.Error("mirror changed the main configuration")
+	}
+
+	// Unchanged records do not reload; stale addresses are removed
+	pushLocalMirror(config, ips)
+	if reloads() != 1 {
+		t.Errorf("reloads after unchanged push = %d, want 1", reloads())
+	}
+	ips.InternalIPv4 = []string{"192.168.1.11"}
+	pushLocalMirror(config, ips)
+	data, _ = os.ReadFile(hostsPath)
+	if strings.Contains(string(data), "192.168.1.10") || !strings.Contains(string(data), "192.168.1.11\tnas.i.example.com\n") {
+		t.Errorf("hosts file after address change:\n%s", data)
+	}
+	if reloads() != 2 {
+		t.Errorf("reloads after change = %d, want 2", reloads())
+	}
+}
+
+func TestPushLocalMirrorUnbound(t *testing.T) {
+	t.Cleanup(func() { localMirrorOnce, localMirror, localMirrorFailed = sync.Once{}, nil, nil })
+	path := filepath.Join(t.TempDir(), "dynipupdate.conf")
+	config := &Config{
+		InternalDomain:         "nas.i.example.com",
+		CombinedDomain:         "nas.example.com",
+		TopLevelDomain:         "nas.example.org",
+		LocalMirror:            localMirrorUnbound,
+		LocalMirrorUnboundFile: path,
+		RecordTTL:              120,
+	}
+	ips := &IPAddresses{InternalIPv4: []string{"192.168.1.10"}, ExternalIPv4: "203.0.113.1"}
+
+	if success, total := pushLocalMirror(config, ips); success != total || total == 0 {
+		t.Fatalf("pushLocalMirror = %d/%d", success, total)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`local-data: "nas.i.example.com. 120 IN A 192.168.1.10"`,
+		`local-data: "nas.example.com. 120 IN A 203.0.113.1"`,
+		`local-data: "nas.example.org. 120 IN CNAME nas.example.com."`,
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("include file lacks %s:\n%s", line, data)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Local detection sources. Besides HTTP echo services, IPV4_SOURCES and IPV6_SOURCES accept
+// sources that never leave the host:
+//
+//   - interface, interface:<name>: the first public address of the family on the host's
+//     interfaces (or on the named one) - a host with a public address of its own, or a global
+//     IPv6 address from SLAAC
+//   - command:<command>: the first line a command prints (split on spaces, no shell), e.g. a
+//     script asking the router for its WAN address
+//
+// ECHO_SERVICES=false never queries echo services: they are dropped from both lists (the
+// defaults included), so only the local sources listed are used (and cloudflare-trace with
+// PROVIDER=cloudflare, see cftrace.go). A domain that needs an external address of a family
+// without a local source stops the configuration with an error, rather than leaving the
+// domain unpublished on every run.
+
+// Prefixes of the local detection sources
+const (
+	interfaceSourcePrefix = "interface"
+	commandSourcePrefix   = "command:"
+)
+
+// Time a source command may take
+const sourceCommandTimeout = 10 * time.Second
+
+// isLocalSource reports whether a detection source is answered on the host
+func isLocalSource(source string) bool {
+	return source == interfaceSourcePrefix || strings.HasPrefix(source, interfaceSourcePrefix+":") ||
+		strings.HasPrefix(source, commandSourcePrefix)
+}
+
+// querySource asks a detection source for the external address of recordType's family
+// ("A" or "AAAA"); client is used for echo services, addrs for interface sources
+func querySource(client *http.Client, source, recordType string, addrs []interfaceAddr) (string, error) {
+	var value string
+	var err error
+	switch {
+	case source == cloudflareTraceSource:
+		value, err = queryEchoService(client, cloudflareTraceURLs[recordType])
+	case strings.HasPrefix(source, commandSourcePrefix):
+		value, err = runSourceCommand(strings.TrimPrefix(source, commandSourcePrefix))
+	case isLocalSource(source):
+		_, iface, _ := strings.Cut(source, ":")
+		value, err = publicInterfaceAddr(addrs, iface, recordType)
+	default:
+		value, err = queryEchoService(client, source)
+	}
+	if err != nil {
+		return "", err
+	}
+	addr, err := parseFamilyAddr(value, recordType)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// cgnatPrefix is the shared address space of carrier-grade NAT (RFC 6598), not reachable
+// from the internet
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicInterfaceAddr returns the first public address of recordType's family on the
+// interfaces (on iface only, if set)
+func publicInterfaceAddr(addrs []interfaceAddr, iface, recordType string) (string, error) {
+	for _, a := range addrs {
+		if iface != "" && a.Interface != iface {
+			continue
+		}
+		if (recordType == "A") != a.Addr.Is4() || !a.Addr.IsGlobalUnicast() || a.Addr.IsPrivate() || cgnatPrefix.Contains(a.Addr) {
+			continue
+		}
+		return a.Addr.String(), nil
+	}
+	if iface != "" {
+		return "", fmt.Errorf("no public %s address on interface %s", recordType, iface)
+	}
+	return "", fmt.Errorf("no public %s address on any interface", recordType)
+}
+
+// runSourceCommand returns the first line printed by a source command
+func runSourceCommand(command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", fmt.Errorf("empty source command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sourceCommandTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", args[0], err)
+	}
+	line, _ := bufio.NewReader(bytes.NewReader(output)).ReadString('\n')
+	if line = strings.TrimSpace(line); line == "" {
+		return "", fmt.Errorf("%s printed no address", args[0])
+	}
+	return line, nil
+}
+
+// applyEchoServicesSetting drops the echo services from the source lists when
+// ECHO_SERVICES=false, and stops if a domain publishing the external addresses is left
+// without a source for a family it needs
+func applyEchoServicesSetting(config *Config) {
+	if config.EchoServices {
+		return
+	}
+	// CloudFlare's trace endpoint is no third party when CloudFlare serves the records anyway
+	keepTrace := config.Provider == providerCloudFlare
+	config.IPv4Sources = localSources(config.IPv4Sources, keepTrace)
+	config.IPv6Sources = localSources(config.IPv6Sources, keepTrace)
+
+	// The domains publishing each external address, as "SETTING domain"
+	var ipv4Domains, ipv6Domains []string
+	if config.ExternalDomain != "" {
+		ipv4Domains = append(ipv4Domains, "EXTERNAL_DOMAIN "+config.ExternalDomain)
+	}
+	if config.IPv6Domain != "" {
+		ipv6Domains = append(ipv6Domains, "IPV6_DOMAIN "+config.IPv6Domain)
+	}
+	if config.CombinedDomain != "" {
+		ipv4Domains = append(ipv4Domains, "COMBINED_DOMAIN "+config.CombinedDomain)
+		ipv6Domains = append(ipv6Domains, "COMBINED_DOMAIN "+config.CombinedDomain)
+	}
+	requireLocalSource("IPv4", "IPV4_SOURCES", config.IPv4Sources, ipv4Domains)
+	requireLocalSource("IPv6", "IPV6_SOURCES", config.IPv6Sources, ipv6Domains)
+	// DynDNS2 hostnames are sent whichever addresses were found, but need one of them
+	if len(config.DynDNS2Hostnames) > 0 && len(config.IPv4Sources) == 0 && len(config.IPv6Sources) == 0 {
+		configFatalf("ERROR: %sECHO_SERVICES=false leaves no source for the external addresses sent to %sDYNDNS2_HOSTNAMES %s - add a local source to %sIPV4_SOURCES or %sIPV6_SOURCES (interface, interface:<name> or command:<command>; cloudflare-trace with CloudFlare)",
+			envPrefix, envPrefix, strings.Join(config.DynDNS2Hostnames, ", "), envPrefix, envPrefix)
+	}
+	log.Printf("Echo services disabled - detecting external addresses from local sources only (IPv4: %s; IPv6: %s)",
+		sourceList(config.IPv4Sources), sourceList(config.IPv6Sources))
+}
+
+// requireLocalSource stops if domains need the external address of a family left without
+// sources by ECHO_SERVICES=false
+func requireLocalSource(family, setting string, sources, domains []string) {
+	if len(domains) == 0 || len(sources) > 0 {
+		return
+	}
+	configFatalf("ERROR: %sECHO_SERVICES=false leaves no source for the external %s address published by %s - add a local source to %s%s (interface, interface:<name> or command:<command>; cloudflare-trace with CloudFlare)",
+		envPrefix, family, strings.Join(domains, ", "), envPrefix, setting)
+}
+
+// localSources returns the local sources of a list, and cloudflare-trace if keepTrace
+func localSources(sources []string, keepTrace bool) []string {
+	var local []string
+	for _, source := range sources {
+		if isLocalSource(source) || (keepTrace && source == cloudflareTraceSource) {
+			local = append(local, source)
+		}
+	}
+	return local
+}
+
+// sourceList formats a source list for the log
+func sourceList(sources []string) string {
+	if len(sources) == 0 {
+		return "none"
+	}
+	return strings.Join(sources, ", ")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPublicInterfaceAddr(t *testing.T) {
+	addrs := []interfaceAddr{
+		{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("100.72.0.5"), Interface: "tailscale0"},
+		{Addr: netip.MustParseAddr("fd00::10"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("fe80::1"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("2001:db8::10"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("203.0.113.7"), Interface: "ppp0"},
+	}
+	tests := []struct {
+		iface, recordType, want string
+	}{
+		{"", "A", "203.0.113.7"},
+		{"ppp0", "A", "203.0.113.7"},
+		{"", "AAAA", "2001:db8::10"},
+		{"eth0", "A", ""},
+		{"ppp0", "AAAA", ""},
+	}
+	for _, tt := range tests {
+		got, err := publicInterfaceAddr(addrs, tt.iface, tt.recordType)
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("publicInterfaceAddr(%q, %s) = %q, %v, want %q", tt.iface, tt.recordType, got, err, tt.want)
+		}
+	}
+}
+
+func TestQueryLocalSources(t *testing.T) {
+	addrs := []interfaceAddr{{Addr: netip.MustParseAddr("203.0.113.7"), Interface: "ppp0"}}
+	if got, err := querySource(nil, "interface:ppp0", "A", addrs); err != nil || got != "203.0.113.7" {
+		t.Errorf("interface source = %q, %v", got, err)
+	}
+	if got, err := querySource(nil, "command:echo 198.51.100.4", "A", nil); err != nil || got != "198.51.100.4" {
+		t.Errorf("command source = %q, %v", got, err)
+	}
+	if _, err := querySource(nil, "command:echo 2001:db8::1", "A", nil); err == nil {
+		t.Error("command source printing an IPv6 address accepted for A")
+	}
+	if _, err := querySource(nil, "command:false", "A", nil); err == nil {
+		t.Error("failing command source accepted")
+	}
+}
+
+func TestEchoServicesDisabled(t *testing.T) {
+	t.Setenv(envPrefix+"CF_API_TOKEN", "token")
+	t.Setenv(envPrefix+"CF_ZONE_ID", "zone")
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+	t.Setenv(envPrefix+"ECHO_SERVICES", "false")
+
+	if _, err := reloadConfig(false); err == nil || !strings.Contains(err.Error(), "IPV4_SOURCES") {
+		t.Errorf("no local IPv4 source: err = %v", err)
+	}
+
+	t.Setenv(envPrefix+"IPV4_SOURCES", "https://api.ipify.org,interface:ppp0,command:/usr/local/bin/wan-ip")
+	config, err := reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"interface:ppp0", "command:/usr/local/bin/wan-ip"}; !reflect.DeepEqual(config.IPv4Sources, want) {
+		t.Errorf("IPv4 sources = %v, want %v", config.IPv4Sources, want)
+	}
+	if len(config.IPv6Sources) != 0 {
+		t.Errorf("IPv6 sources = %v, want none", config.IPv6Sources)
+	}
+	if got := getExternalIPv6(config.IPv6Sources, newSourceTracker(nil), nil); got != "" {
+		t.Errorf("external IPv6 without sources = %q", got)
+	}
+
+	// The combined domain publishes both external addresses
+	t.Setenv(envPrefix+"COMBINED_DOMAIN", "all.example.com")
+	_, err = reloadConfig(false)
+	if err == nil || !strings.Contains(err.Error(), "IPv6 address published by COMBINED_DOMAIN all.example.com") || !strings.Contains(err.Error(), "IPV6_SOURCES") {
+		t.Errorf("combined domain without an IPv6 source: err = %v", err)
+	}
+	t.Setenv(envPrefix+"IPV6_SOURCES", "interface")
+	if _, err := reloadConfig(false); err != nil {
+		t.Errorf("combined domain with local sources: %v", err)
+	}
+
+	// DynDNS2 hostnames take whichever address a local source finds
+	t.Setenv(envPrefix+"COMBINED_DOMAIN", "")
+	t.Setenv(envPrefix+"DYNDNS2_HOSTNAMES", "home.dyndns.example")
+	t.Setenv(envPrefix+"DYNDNS2_SERVER", "https://members.dyndns.example")
+	t.Setenv(envPrefix+"DYNDNS2_USERNAME", "user")
+	t.Setenv(envPrefix+"DYNDNS2_PASSWORD", "password")
+	if _, err := reloadConfig(false); err != nil {
+		t.Errorf("DynDNS2 hostnames with an IPv4 source only: %v", err)
+	}
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "")
+	t.Setenv(envPrefix+"IPV4_SOURCES", "https://api.ipify.org")
+	t.Setenv(envPrefix+"IPV6_SOURCES", "https://api6.ipify.org")
+	_, err = reloadConfig(false)
+	if err == nil || !strings.Contains(err.Error(), "DYNDNS2_HOSTNAMES home.dyndns.example") {
+		t.Errorf("DynDNS2 hostnames without sources: err = %v", err)
+	}
+}
+
+func TestEchoServicesDisabledKeepsCloudFlareTrace(t *testing.T) {
+	t.Setenv(envPrefix+"CF_API_TOKEN", "token")
+	t.Setenv(envPrefix+"CF_ZONE_ID", "zone")
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+	t.Setenv(envPrefix+"ECHO_SERVICES", "false")
+	t.Setenv(envPrefix+"IPV4_SOURCES", "https://api.ipify.org,cloudflare-trace")
+
+	config, err := reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"cloudflare-trace"}; !reflect.DeepEqual(config.IPv4Sources, want) {
+		t.Errorf("IPv4 sources = %v, want %v", config.IPv4Sources, want)
+	}
+
+	if got := localSources([]string{"cloudflare-trace", "interface"}, false); !reflect.DeepEqual(got, []string{"interface"}) {
+		t.Errorf("local sources without CloudFlare = %v", got)
+	}
+}
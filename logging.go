@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Log output. Messages are written with the standard log package and handed to log/slog,
+// which formats them as LOG_FORMAT says:
+//
+//   - plain (default): the classic "2024/01/02 15:04:05 message" lines
+//   - text: slog key=value lines, "time=... level=WARN msg=... provider=cloudflare"
+//   - json: one JSON object per line, for Loki, Elasticsearch or CloudWatch
+//
+// The level of a message comes from its prefix ("ERROR: ", "WARNING: " and their
+// translations), which the text and JSON formats move into the level field. LOG_LEVEL
+// (debug, info, warn or error; default info) drops the messages below it; the API request
+// lines are debug messages. Record changes carry the fields action, domain, record_type and
+// content, and every message of the text and JSON formats carries the provider.
+
+// Supported values for LOG_FORMAT
+const (
+	logFormatPlain = "plain"
+	logFormatText  = "text"
+	logFormatJSON  = "json"
+)
+
+// logLevels maps the LOG_LEVEL values to slog levels
+var logLevels = map[string]slog.Level{
+	"debug":   slog.LevelDebug,
+	"info":    slog.LevelInfo,
+	"warn":    slog.LevelWarn,
+	"warning": slog.LevelWarn,
+	"error":   slog.LevelError,
+}
+
+// logLevelPrefixes are the message prefixes that set a level, with their translations
+var logLevelPrefixes = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{"ERROR: ", slog.LevelError},
+	{"FEHLER: ", slog.LevelError},
+	{"WARNING: ", slog.LevelWarn},
+	{"WARNUNG: ", slog.LevelWarn},
+}
+
+// logOutput is where log messages go: the logger, and whether it writes plain lines (which
+// keep the level prefixes in the message)
+type logOutput struct {
+	logger *slog.Logger
+	plain  bool
+}
+
+// currentLog is the log output in use, swapped by setupLogging
+var currentLog atomic.Pointer[logOutput]
+
+// logWriter receives the formatted log lines (tests replace it)
+var logWriter io.Writer = os.Stderr
+
+func init() {
+	currentLog.Store(&logOutput{logger: slog.New(newPlainHandler(logWriter, slog.LevelInfo)), plain: true})
+}
+
+// parseLogSettings reads LOG_FORMAT and LOG_LEVEL
+func parseLogSettings() (format, level string) {
+	format = strings.ToLower(getEnvOrDefault("LOG_FORMAT", logFormatPlain))
+	switch format {
+	case logFormatPlain, logFormatText, logFormatJSON:
+	default:
+		configFatalf("ERROR: %sLOG_FORMAT must be %q, %q or %q, got %q", envPrefix, logFormatPlain, logFormatText, logFormatJSON, format)
+	}
+	level = strings.ToLower(getEnvOrDefault("LOG_LEVEL", "info"))
+	if _, ok := logLevels[level]; !ok {
+		configFatalf("ERROR: %sLOG_LEVEL must be debug, info, warn or error, got %q", envPrefix, level)
+	}
+	return format, level
+}
+
+// setupLogging sends the output of the log package to a slog handler of the format. Unknown
+// formats and levels fall back to plain and info (readConfig rejects them).
+func setupLogging(format, level, provider string) {
+	logLevel, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		logLevel = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	output := &logOutput{}
+	switch strings.ToLower(format) {
+	case logFormatText:
+		output.logger = slog.New(slog.NewTextHandler(logWriter, opts))
+	case logFormatJSON:
+		output.logger = slog.New(slog.NewJSONHandler(logWriter, opts))
+	default:
+		output.logger, output.plain = slog.New(newPlainHandler(logWriter, opts.Level)), true
+	}
+	if provider != "" {
+		output.logger = output.logger.With("provider", provider)
+	}
+	currentLog.Store(output)
+	log.SetFlags(0) // The handlers add the time
+	log.SetOutput(logBridge{})
+}
+
+// logBridge receives the lines of the log package and passes them to the current logger
+type logBridge struct{}
+
+func (logBridge) Write(p []byte) (int, error) {
+	output := currentLog.Load()
+	message := strings.TrimSuffix(string(p), "\n")
+	level := slog.LevelInfo
+	for _, l := range logLevelPrefixes {
+		if strings.HasPrefix(message, l.prefix) {
+			level = l.level
+			if !output.plain {
+				message = strings.TrimPrefix(message, l.prefix)
+			}
+			break
+		}
+	}
+	output.logger.Log(context.Background(), level, message)
+	return len(p), nil
+}
+
+// logDebugf logs a debug message
+func logDebugf(format string, v ...any) {
+	output := currentLog.Load()
+	if output.logger.Enabled(context.Background(), slog.LevelDebug) {
+		output.logger.Debug(fmt.Sprintf(format, v...))
+	}
+}
+
+// logRecordChange logs a created, updated or deleted record with its fields
+func logRecordChange(action, recordType, name, content string) {
+	var message string
+	switch action {
+	case "create":
+		message = fmt.Sprintf("Created %s record for %s -> %s", recordType, name, content)
+	case "update":
+		message = fmt.Sprintf("Updated %s record for %s -> %s", recordType, name, content)
+	default:
+		message = fmt.Sprintf("Deleted %s record for %s", recordType, name)
+	}
+	attrs := []any{"action", action, "domain", name, "record_type", recordType}
+	if content != "" {
+		attrs = append(attrs, "content", content)
+	}
+	currentLog.Load().logger.Info(message, attrs...)
+}
+
+// plainHandler writes messages like the log package does by default, without their fields
+type plainHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+}
+
+func newPlainHandler(w io.Writer, level slog.Leveler) *plainHandler {
+	return &plainHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintf(h.w, "%s %s\n", r.Time.Format("2006/01/02 15:04:05"), r.Message)
+	return err
+}
+
+func (h *plainHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *plainHandler) WithGroup(string) slog.Handler { return h }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the log output of the format and level to a buffer until the test ends
+func captureLogs(t *testing.T, format, level string) *bytes.Buffer {
+	var buf bytes.Buffer
+	logWriter = &buf
+	setupLogging(format, level, "cloudflare")
+	t.Cleanup(func() {
+		logWriter = os.Stderr
+		setupLogging(logFormatPlain, "info", "")
+	})
+	return &buf
+}
+
+func TestJSONLogs(t *testing.T) {
+	buf := captureLogs(t, logFormatJSON, "info")
+	log.Printf("WARNING: Could not reach %s", "api.ipify.org")
+	logDebugf("API Request: GET /zones")
+	logRecordChange("create", "A", "home.example.com", "203.0.113.1")
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("not JSON: %s", line)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2 (debug dropped): %s", len(entries), buf.String())
+	}
+	if e := entries[0]; e["level"] != "WARN" || e["msg"] != "Could not reach api.ipify.org" || e["provider"] != "cloudflare" {
+		t.Errorf("warning = %v", e)
+	}
+	if e := entries[1]; e["domain"] != "home.example.com" || e["record_type"] != "A" || e["content"] != "203.0.113.1" || e["action"] != "create" {
+		t.Errorf("record change = %v", e)
+	}
+}
+
+func TestPlainLogs(t *testing.T) {
+	buf := captureLogs(t, logFormatPlain, "warn")
+	log.Println("Found 2 internal IPv4 address(es)")
+	log.Println("WARNUNG: Ungültige CIDR-Notation")
+	logRecordChange("delete", "A", "home.example.com", "")
+
+	output := buf.String()
+	if strings.Contains(output, "Found") || strings.Contains(output, "Deleted") {
+		t.Errorf("info messages logged at level warn: %s", output)
+	}
+	if !strings.HasSuffix(output, " WARNUNG: Ungültige CIDR-Notation\n") || strings.Contains(output, "provider=") {
+		t.Errorf("plain output = %q", output)
+	}
+}
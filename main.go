@@ -1,17 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,18 +36,38 @@ var rfc1918Ranges = []string{
 
 // CustomIPRange represents a user-defined IP range to detect and publish
 type CustomIPRange struct {
-	CIDR   string // CIDR notation, e.g., "100.0.0.0/8"
-	Domain string // DNS domain for this range, e.g., "host.vpn.example.com"
-	Type   string // "A" for IPv4, "AAAA" for IPv6
+	CIDR    string       // CIDR notation, e.g., "100.0.0.0/8"
+	Prefix  netip.Prefix // CIDR parsed and masked
+	Domain  string       // DNS domain for this range, e.g., "host.vpn.example.com"
+	Type    string       // "A" for IPv4, "AAAA" for IPv6
+	TTL     int          // Published TTL for this range's records (0 = RECORD_TTL)
+	Proxied *bool        // Proxied override for this range's records (nil = CF_PROXIED)
 }
 
 // CloudFlare API structures
 type CFListResponse struct {
-	Success bool              `json:"success"`
-	Errors  []json.RawMessage `json:"errors"`
-	Result  []CFRecord        `json:"result"`
+	Success    bool              `json:"success"`
+	Errors     []json.RawMessage `json:"errors"`
+	Result     []CFRecord        `json:"result"`
+	ResultInfo CFResultInfo      `json:"result_info"`
+}
+
+// CFResultInfo describes the page of a list response
+type CFResultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
+	TotalPages int `json:"total_pages"`
 }
 
+// cfListPageSize is the number of records requested per page of a list call
+const cfListPageSize = 500
+
+// cfMaxListPages bounds the pages fetched by one list call, in case the API keeps
+// reporting more pages
+const cfMaxListPages = 1000
+
 type CFSingleResponse struct {
 	Success bool              `json:"success"`
 	Errors  []json.RawMessage `json:"errors"`
@@ -49,10 +75,15 @@ type CFSingleResponse struct {
 }
 
 type CFRecord struct {
-	ID      string `json:"id"`
-	Type    string `json:"type"`
-	Name    string `json:"name"`
-	Content string `json:"content"`
+	ID      string        `json:"id"`
+	Type    string        `json:"type"`
+	Name    string        `json:"name"`
+	Content string        `json:"content"`
+	TTL     int           `json:"ttl,omitempty"`
+	Proxied bool          `json:"proxied,omitempty"`
+	Tags    []string      `json:"tags,omitempty"`
+	Comment string        `json:"comment,omitempty"`
+	Data    *cfRecordData `json:"data,omitempty"` // Structured content of SRV and HTTPS records
 }
 
 type CFError struct {
@@ -61,125 +92,626 @@ type CFError struct {
 }
 
 type CFCreateUpdateRequest struct {
-	Type    string `json:"type"`
-	Name    string `json:"name"`
-	Content string `json:"content"`
-	TTL     int    `json:"ttl"`
-	Proxied bool   `json:"proxied"`
+	Type    string         `json:"type"`
+	Name    string         `json:"name"`
+	Content string         `json:"content,omitempty"`
+	Data    map[string]any `json:"data,omitempty"` // Instead of content for SRV and HTTPS records
+	TTL     int            `json:"ttl"`
+	Proxied bool           `json:"proxied"`
+	Tags    []string       `json:"tags,omitempty"`
+	Comment string         `json:"comment,omitempty"`
 }
 
 // Config holds application configuration
 type Config struct {
-	CFAPIToken       string
-	CFZoneID         string
-	InternalDomain   string
-	ExternalDomain   string
-	IPv6Domain       string
-	CustomIPv4Ranges []CustomIPRange // User-defined IPv4 ranges
-	CustomIPv6Ranges []CustomIPRange // User-defined IPv6 ranges
-	CombinedDomain   string
-	TopLevelDomain   string // CNAME alias pointing to CombinedDomain
-	Proxied          bool
-	StaleThreshold   int // seconds (for cleanup mode)
-	CleanupInterval  int // seconds (for cleanup mode)
+	Provider                 string // DNS provider: cloudflare, route53, azure, rfc2136, dyndns2, porkbun, ns1, dynv6, exec, webhook, hosts, etcd, zonefile or grpc
+	CFAPIToken               string
+	CFZoneID                 string
+	CFZoneIDs                []string // Every zone of CF_ZONE_ID, CFZoneID being the first
+	CFAccountID              string   // CloudFlare account ID, needed to read the audit log
+	Route53HostedZoneID      string
+	AzureSubscriptionID      string
+	AzureResourceGroup       string
+	AzureDNSZone             string // Azure DNS zone name, e.g. example.com
+	DynDNS2Server            string // DynDNS2 update service base URL
+	DynDNS2Username          string
+	DynDNS2Password          string
+	DynDNS2Hostnames         []string // Hostnames receiving the external addresses alongside the provider
+	RFC2136Server            string   // Primary server accepting dynamic updates (host or host:port)
+	RFC2136Zone              string   // Zone updated with RFC 2136, e.g. example.com
+	TSIGKeyName              string   // TSIG key signing RFC 2136 requests; empty sends them unsigned
+	TSIGSecret               string   // Base64 TSIG secret
+	TSIGAlgorithm            string   // hmac-sha1, hmac-sha256 or hmac-sha512
+	PorkbunAPIKey            string   // Porkbun API key (pk1_...)
+	PorkbunSecretKey         string   // Porkbun secret API key (sk1_...)
+	PorkbunDomain            string   // Domain at Porkbun, e.g. example.com
+	NS1APIKey                string   // NS1 API key
+	NS1Zone                  string   // NS1 zone, e.g. example.com
+	Dynv6Token               string   // dynv6 HTTP token
+	Dynv6Zone                string   // dynv6 zone, e.g. home.dynv6.net
+	Dynv6UpdateURL           bool     // Use the dynv6 update URL instead of the REST API
+	Dynv6PrefixLength        int      // Publish the zone's IPv6 as a prefix of this length (0 = address)
+	ExecCommand              string   // Executable run by the exec provider
+	ExecArgs                 []string // Arguments passed to the executable
+	ExecTimeout              int      // Seconds an exec provider run may take
+	GRPCSocket               string   // Unix socket of the gRPC provider plugin
+	WebhookURL               string   // URL receiving record change events
+	WebhookSecret            string   // HMAC-SHA256 key signing webhook requests
+	HostsFilePath            string   // Hosts-format file written by the hosts provider
+	EtcdEndpoint             string   // etcd client URL written to by the etcd provider
+	EtcdPrefix               string   // Path prefix of the CoreDNS etcd plugin
+	EtcdUsername             string   // etcd user (optional)
+	EtcdPassword             string   // etcd password
+	ZoneFilePath             string   // RFC 1035 zone file written by the zonefile provider
+	ZoneFileOrigin           string   // Zone of the zone file
+	ZoneFileNameserver       string   // Nameserver of the SOA and NS records of a new zone file
+	RelayURL                 string   // Relay receiving the record changes of an agent
+	RelayToken               string   // Bearer token of the agent at the relay
+	RelayClientCert          string   // Client certificate (PEM) of the agent at the relay
+	RelayClientKey           string   // Private key (PEM) of the agent's client certificate
+	RelayCACert              string   // CA certificate (PEM) verifying the relay; empty uses the system roots
+	LocalMirror              string   // Also mirror internal records locally: rfc2136, hosts or unbound; empty disables
+	LocalMirrorServer        string   // Local DNS server accepting RFC 2136 updates (host or host:port)
+	LocalMirrorZone          string   // Local zone updated with RFC 2136
+	LocalMirrorHostsFile     string   // Hosts file of the local resolver
+	LocalMirrorUnboundFile   string   // unbound include file receiving local-data statements
+	LocalMirrorReloadCommand string   // Command run after the local mirror changed, e.g. to reload dnsmasq
+	InternalDomain           string
+	ExternalDomain           string
+	IPv6Domain               string
+	CustomIPv4Ranges         []CustomIPRange   // User-defined IPv4 ranges
+	CustomIPv6Ranges         []CustomIPRange   // User-defined IPv6 ranges
+	rangeMatcher             *rangeMatcher     // Index of the custom ranges, built on first use
+	interfaces               *interfaceScanner // Interface scans shared within a cycle, created on first use
+	InterfaceScanMaxAge      int               // Seconds an interface scan is reused (0 = default)
+	IncludeLinkLocal         bool              // Publish link-local addresses (169.254/16, fe80::/10)
+	IncludeUnstableAddresses bool              // Publish tentative, deprecated and expired-lease addresses
+	DHCPLeaseFiles           []string          // Glob patterns of dhclient lease files
+	DADProbe                 bool              // ARP/NDP-probe addresses for conflicts before publishing
+	DADProbeTimeoutMs        int               // How long to wait for answers to a probe (0 = default)
+	prober                   addressProber     // Sends the probes, created on first use
+	CombinedDomain           string
+	TopLevelDomain           string // CNAME alias pointing to CombinedDomain
+	Proxied                  bool
+	RecordTTL                int              // Published TTL in seconds (1 = CloudFlare automatic)
+	HeartbeatTTL             int              // TTL of heartbeat TXT records
+	HeartbeatFormat          string           // Serializer of heartbeat TXT records: timestamp or json
+	LogFormat                string           // Log output: plain, text or json
+	LogLevel                 string           // Lowest level logged: debug, info, warn or error
+	HeartbeatHost            string           // Host name carried by JSON heartbeats
+	DomainTTLs               map[string]int   // Per-name TTL overrides (normalized name -> TTL)
+	DomainProxied            map[string]bool  // Per-name overrides of Proxied (normalized name -> proxied)
+	CriticalDomains          []string         // Names whose address changes wait for the old TTL to expire
+	Services                 []Service        // Published as SRV (and HTTPS) records of the combined domain
+	TunnelTarget             string           // CNAME target of the Cloudflare Tunnel the external domain falls back to
+	TunnelMode               string           // "fallback" (when unreachable) or "always"
+	TunnelCheckPort          int              // Port connected to on the external address to check reachability (0 = no check)
+	CanaryTTL                int              // TTL of critical names while a change waits
+	clock                    Clock            // Time source of heartbeats and cleanup (nil = system clock)
+	vault                    *vaultSecret     // Keeps CFAPIToken up to date from Vault (nil without VAULT_SECRET_PATH)
+	APIRateLimit             int              // Provider API requests per second (0 = unlimited)
+	APIRetryAttempts         int              // Attempts per provider API request (1 = no retries)
+	APIRetryBackoff          int              // Seconds before the first retry, doubling per attempt
+	APIRetryMaxBackoff       int              // Longest backoff (and Retry-After waited for) in seconds
+	FaultInjectionPercent    int              // Share of provider API attempts failed on purpose, for testing (0 = none)
+	UpdateConcurrency        int              // Record changes applied at the same time by the batch engine (0 = record by record)
+	Changelog                bool             // Maintain a rolling _changelog TXT record of recent changes
+	StateFile                string           // Path to persistent state (change history); empty disables
+	HistoryFile              string           // Path to the signed change journal; empty disables
+	HistorySigningKey        string           // Ed25519 private key (PEM) signing the change journal
+	VerifyPropagation        bool             // Wait for changed records to resolve before recording latency
+	VerifyResolver           string           // DNS server (host:port) used for propagation checks
+	VerifyTimeout            int              // seconds to wait for propagation
+	VerifyDNSSEC             bool             // Require propagated records to be signed and validated by VerifyResolver
+	verifications            *verifyQueue     // Daemon and serve mode: verifies propagation in a job of its own (nil = the run waits)
+	VerifyDeletes            bool             // Look up deleted CloudFlare records again and retry deletes that did not take
+	VerifyDeleteDelay        int              // Seconds before a deleted record is looked up again
+	SLOTargetSeconds         int              // Propagation SLO target for reports
+	StatusPageDir            string           // Directory receiving status.html and status.json; empty disables
+	StatusPageS3URL          string           // s3://bucket/prefix receiving the status page; empty disables
+	StatusPageS3Region       string           // Region of the status page bucket
+	StatusPageS3Endpoint     string           // Endpoint of S3-compatible storage (path-style); empty uses AWS
+	StatusPageMinInterval    int              // seconds; an unchanged status page is republished at most this often
+	LatencyProbeTarget       string           // host:port timed with TCP connects after an external address change; empty disables
+	NotifyURL                string           // Webhook URL for notifications (reports); empty logs them instead
+	NotifyTelegramBotToken   string           // Telegram bot sending notifications to NotifyTelegramChatID
+	NotifyTelegramChatID     string           // Telegram chat receiving notifications
+	NotifyDiscordURL         string           // Discord webhook receiving notifications
+	NotifySlackURL           string           // Slack incoming webhook receiving notifications
+	NotifyNtfyURL            string           // ntfy topic URL receiving notifications
+	NotifyNtfyToken          string           // Access token of a protected ntfy topic
+	NotifyPushoverToken      string           // Pushover application token sending notifications
+	NotifyPushoverUser       string           // Pushover user or group key receiving notifications
+	NotifyEvents             []string         // Routine events notified: ip-change, update-failed, cleanup-deleted
+	IPChangeWebhookURL       string           // URL receiving a request when an external address changes; empty disables
+	IPChangeWebhookSecret    string           // HMAC-SHA256 key signing IP change requests; empty sends them unsigned
+	AuditLogInterval         int              // seconds between CloudFlare audit log polls in daemon mode; 0 disables
+	NetworkProfiles          []NetworkProfile // Network-aware domain selection (first match wins)
+	QueueRetrySeconds        int              // How long to wait for the API to come back before giving up on queued changes
+	FastStart                bool             // Republish cached addresses at startup before full detection
+	FastStartMaxAge          int              // seconds; cached addresses older than this are not republished
+	IPv4Sources              []string         // Echo services and local sources for external IPv4 detection
+	IPv6Sources              []string         // Echo services and local sources for external IPv6 detection
+	EchoServices             bool             // Query HTTP echo services; false detects from local sources only
+	RecordTags               []string         // CloudFlare record tags for DNS analytics (host, site, environment)
+	RecordInterfaceComments  bool             // Comment CloudFlare records with the interface their address was found on
+	MetricsFile              string           // Prometheus textfile collector output; empty disables metrics
+	MetricsListen            string           // Address serving /metrics in daemon and serve modes; empty disables
+	DockerMode               bool             // Defaults for one name in one container (see dockermode.go)
+	HealthListen             string           // Address serving /healthz and /readyz in daemon and serve modes; empty disables
+	HealthMaxAge             int              // seconds without a finished cycle before /healthz fails; 0 = three intervals
+	MetricsAddressLabels     string           // Per-address labels: none, hash or full
+	MetricsMaxAddressLabels  int              // Cap on per-address series per domain and record type
+	DetectOutput             string           // Detection-only mode output: stdout, webhook or mqtt
+	DetectWebhookURL         string           // Webhook receiving detection results
+	MQTTURL                  string           // MQTT broker receiving detection results
+	MQTTTopic                string           // MQTT topic for detection results
+	EventsListen             string           // Event API listen address for "serve"; empty disables the API
+	EventsTokens             []string         // Bearer tokens accepted by the event API
+	EventsTLSCert            string           // Event API TLS certificate (PEM); empty serves plain HTTP
+	EventsTLSKey             string           // Event API TLS private key (PEM)
+	RelayListen              string           // Listen address of "dynipupdate relay"
+	RelayAgentsFile          string           // JSON file of the agents allowed to use the relay
+	RelayTLSCert             string           // Relay TLS certificate (PEM); empty serves plain HTTP
+	RelayTLSKey              string           // Relay TLS private key (PEM)
+	RelayClientCA            string           // CA certificates (PEM) verifying agents' client certificates
+	StaleThreshold           int              // seconds (for cleanup mode)
+	CleanupInterval          int              // seconds (for cleanup mode)
+	UpdateInterval           int              // seconds between updates; > 0 runs plain updates in daemon mode
+	UpdateJitter             int              // maximum random seconds added to each update interval in daemon mode
+	CycleTimeout             int              // seconds a run may take before it is aborted; 0 disables
+	WatchNetwork             bool             // Daemon mode: update right away when interface addresses or the default route change (Linux)
 }
 
 // IPAddresses holds detected IP addresses
 type IPAddresses struct {
-	InternalIPv4   []string
-	ExternalIPv4   string
-	ExternalIPv6   string
-	CustomRangeIPs map[string][]string // domain -> detected IPs for that custom range
+	InternalIPv4   []string            `json:"internal_ipv4,omitempty"`
+	ExternalIPv4   string              `json:"external_ipv4,omitempty"`
+	ExternalIPv6   string              `json:"external_ipv6,omitempty"`
+	CustomRangeIPs map[string][]string `json:"custom_range_ips,omitempty"` // domain -> detected IPs for that custom range
+	Interfaces     map[string]string   `json:"interfaces,omitempty"`       // address -> interface it was found on (RECORD_INTERFACE_COMMENTS)
+}
+
+// subcommands maps a subcommand name to its entry point.
+// Each entry point receives the remaining arguments and returns the process exit code.
+var subcommands = map[string]func(args []string) int{
+	"detect":                     runDetect,
+	"gen-fleet":                  runGenFleet,
+	"gen-packaging":              runGenPackaging,
+	"init":                       runInit,
+	"list-managed":               runListManaged,
+	"login":                      runLogin,
+	"logout":                     runLogout,
+	"print-required-permissions": runPrintRequiredPermissions,
+	"relay":                      runRelay,
+	"serve":                      runServe,
+	"stats":                      runStats,
+	"status":                     runStatus,
+	"support-bundle":             runSupportBundle,
+	"sweep":                      runSweep,
+	"verify-history":             runVerifyHistory,
 }
 
 func main() {
-	log.SetFlags(log.LstdFlags)
+	// Until the configuration is read, only the environment selects the log format
+	setupLogging(earlyLogFormat(), os.Getenv(envPrefix+"LOG_LEVEL"), "")
+	currentLocale = selectLocale()
+
+	// Dispatch subcommands (e.g. "dynipupdate print-required-permissions")
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
 
 	// Parse command-line flags
 	cleanupMode := flag.Bool("cleanup", false, "Run in cleanup mode (monitors and removes stale DNS records)")
+	waitUntilSynced := flag.Bool("wait-until-synced", false, "After updating, block until all managed names resolve to the detected addresses")
+	waitTimeout := flag.Int("wait-timeout", 600, "Seconds to wait with -wait-until-synced before failing (0 waits forever)")
+	verifyOnly := flag.Bool("verify-only", false, "With -wait-until-synced, skip the update and only wait for DNS to match")
+	daemon := flag.Bool("daemon", false, "Keep running and update every -interval seconds (with -cleanup, also run the cleanup service)")
+	interval := flag.Int("interval", 300, "Seconds between update runs with -daemon")
+	check := flag.Bool("check", false, "Show what an update (or with -cleanup, a cleanup run) would change without changing anything (exit 0 = in sync, 2 = changes pending, 1 = failed)")
+	flag.BoolVar(check, "dry-run", false, "Alias for -check")
+	ci := flag.Bool("ci", false, "Print one JSON result on stdout for CI and configuration management (logs stay on stderr)")
+	only := flag.String("only", "", "Only reconcile these domains: comma-separated classes (internal, external, ipv6, combined, toplevel, ipv4_range_N, ipv6_range_N) or names")
+	skip := flag.String("skip", "", "Leave these domains alone (same syntax as -only)")
+	adopt := flag.Bool("adopt", false, "Take over records at managed names that dynipupdate did not create (default: warn and leave those domains alone)")
+	refuseUnmanaged := flag.Bool("refuse-unmanaged", false, "Abort if managed names hold records that dynipupdate did not create")
+	force := flag.Bool("force", false, "Rewrite every managed record (TTL, proxied flag, tags) even when its content already matches")
+	replay := flag.String("replay", "", "Plan the run of this change journal entry (sequence number or \"last\") again from its recorded detection, without changing anything (exit 0 = same changes, 2 = different, 1 = failed)")
+	flag.StringVar(&configFileFlag, "config", "", "Config file (JSON, YAML or TOML by extension); overrides BEES_IP_UPDATE_CONFIG_FILE, environment variables still override its settings")
 	flag.Parse()
 
-	config := loadConfig(*cleanupMode)
+	if *check && (*daemon || *waitUntilSynced) {
+		log.Fatal("-check cannot be combined with -daemon or -wait-until-synced")
+	}
+	if *force && (*check || *daemon || *cleanupMode || *verifyOnly) {
+		log.Fatal("-force cannot be combined with -check, -daemon, -cleanup or -verify-only")
+	}
+	if *replay != "" && (*check || *daemon || *cleanupMode || *waitUntilSynced || *force || *adopt) {
+		log.Fatal("-replay cannot be combined with -check, -daemon, -cleanup, -wait-until-synced, -force or -adopt")
+	}
+	unmanagedMode := unmanagedWarn
+	switch {
+	case *adopt && (*refuseUnmanaged || *check):
+		log.Fatal("-adopt cannot be combined with -refuse-unmanaged or -check")
+	case *adopt:
+		unmanagedMode = unmanagedAdopt
+	case *refuseUnmanaged:
+		unmanagedMode = unmanagedRefuse
+	}
+
+	// Read the configuration and connect to the provider, waiting for them with
+	// BOOTSTRAP_TIMEOUT_SECONDS
+	config, cf := bootstrap(*cleanupMode, func(config *Config) error {
+		setupLogging(config.LogFormat, config.LogLevel, config.Provider)
+		return filterDomains(config, splitList(*only), splitList(*skip))
+	})
+
+	// BEES_IP_UPDATE_INTERVAL_SECONDS sets the interval and, for plain update runs, turns on
+	// daemon mode (for containers and service managers without command line flags)
+	if config.UpdateInterval > 0 {
+		if !flagPassed("interval") {
+			*interval = config.UpdateInterval
+		}
+		if !*check && !*cleanupMode && !*waitUntilSynced && !*force && *replay == "" {
+			*daemon = true
+		}
+	}
+
+	if *replay != "" {
+		os.Exit(replayEntry(cf, config, *replay, *ci))
+	}
 
-	cf := &CloudFlareClient{
-		APIToken: config.CFAPIToken,
-		ZoneID:   config.CFZoneID,
-		BaseURL:  "https://api.cloudflare.com/client/v4",
+	if *daemon {
+		if *interval <= 0 {
+			log.Fatal("-interval must be positive")
+		}
+		if !handleUnmanagedRecords(cf, config, unmanagedMode) {
+			os.Exit(1)
+		}
+		runDaemon(cf, config, time.Duration(*interval)*time.Second, *cleanupMode, newConfigReloader(*cleanupMode, splitList(*only), splitList(*skip), unmanagedMode))
+		return
 	}
 
+	if *cleanupMode && *check {
+		result := runCleanupCheck(cf, config)
+		result.write(os.Stdout, *ci)
+		os.Exit(result.exitCode())
+	}
 	if *cleanupMode {
-		runCleanupService(cf, config)
+		runCleanupService(cf, config, newConfigReloader(true, splitList(*only), splitList(*skip), ""))
 		return
 	}
 
 	// Update mode
-	log.Println("Starting Dynamic DNS Updater")
-	ips := detectIPs(config)
+	log.Println(tr("update.start"))
+
+	// End the run at CYCLE_TIMEOUT_SECONDS, however far it got
+	mode := "update"
+	if *check {
+		mode = "check"
+	}
+	watchdog := startCycleWatchdog(time.Duration(config.CycleTimeout)*time.Second, func(changes []RecordChange) {
+		if *ci {
+			result := newRunResult(mode, changes, 0, 0)
+			result.Failed, result.TimedOut = true, true
+			result.write(os.Stdout, true)
+		}
+	})
 
-	successCount := 0
-	totalCount := 0
+	// Restrict domains to the active network profile (if profiles are configured)
+	if !selectNetworkProfile(config) {
+		if *ci || *check {
+			newRunResult(mode, nil, 0, 0).write(os.Stdout, *ci)
+		}
+		os.Exit(0)
+	}
 
-	// Update internal IPv4 records (support multiple addresses)
-	if len(ips.InternalIPv4) > 0 {
-		// Get all existing records for the internal domain
-		existingRecords := cf.getAllRecords(config.InternalDomain, "A")
+	// Leave alone, take over or refuse records that dynipupdate did not create
+	if !*verifyOnly && !handleUnmanagedRecords(cf, config, unmanagedMode) {
+		os.Exit(1)
+	}
+
+	if *check {
+		result := runCheck(cf, config)
+		result.write(os.Stdout, *ci)
+		os.Exit(result.exitCode())
+	}
 
-		// Create a map of existing record contents for quick lookup
-		existingIPs := make(map[string]string) // content -> recordID
-		for _, record := range existingRecords {
-			existingIPs[record.Content] = record.ID
+	if *verifyOnly {
+		if !*waitUntilSynced {
+			log.Fatal("-verify-only requires -wait-until-synced")
 		}
+		if !waitUntilDNSSynced(config, detectIPs(config), time.Duration(*waitTimeout)*time.Second) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Rewrite records whose content already matches
+	if *force {
+		log.Println("Force mode: rewriting every managed record")
+		cf = newForceClient(cf)
+	}
+
+	// Queue changes that fail because the API is unreachable
+	var queue []PendingMutation
+	cf.hooks().OnUnreachable = newUnreachableRecorder(&queue)
+
+	// Collect this run's changes for the -ci result
+	var changes []RecordChange
+	cf.hooks().OnChange = watchdog.recorder(newChangeRecorder(&changes))
+
+	ips, successCount, totalCount := runUpdate(cf, config)
 
-		// Create a map of detected IPs
-		detectedIPs := make(map[string]bool)
-		for _, ip := range ips.InternalIPv4 {
-			detectedIPs[ip] = true
+	if len(queue) > 0 {
+		if processOfflineQueue(cf, config, queue) {
+			log.Println("All queued changes applied after connectivity returned")
+			successCount = totalCount
 		}
+	} else if config.StateFile != "" {
+		// A full reconcile supersedes anything queued by an earlier run
+		if state, err := loadState(config.StateFile); err == nil && len(state.PendingMutations) > 0 {
+			log.Printf("Cleared %d queued change(s) from a previous run (superseded by this update)", len(state.PendingMutations))
+			saveQueue(config, nil)
+		}
+	}
 
-		// Create/update records for each detected IP
-		for _, ip := range ips.InternalIPv4 {
-			totalCount++
-			if cf.ensureRecordExists(config.InternalDomain, "A", ip, config.Proxied) {
-				successCount++
+	// Waiting for DNS has its own -wait-timeout
+	watchdog.stop()
+
+	// Optionally block until DNS reflects the detected addresses
+	if *waitUntilSynced {
+		if !waitUntilDNSSynced(config, ips, time.Duration(*waitTimeout)*time.Second) {
+			if *ci {
+				result := newRunResult("update", changes, successCount, totalCount)
+				result.Failed = true
+				result.write(os.Stdout, true)
 			}
+			os.Exit(1)
+		}
+	}
+
+	// Report results
+	writeRunMetrics(config, ips, successCount, totalCount)
+	log.Println(tr("update.completed", successCount, totalCount))
+	if *ci {
+		result := newRunResult("update", changes, successCount, totalCount)
+		result.Failed = successCount != totalCount || totalCount == 0 // Matches the exit code below
+		result.write(os.Stdout, true)
+	}
+
+	if successCount == totalCount && totalCount > 0 {
+		log.Println(tr("update.all_ok"))
+		os.Exit(0)
+	} else if successCount > 0 {
+		log.Println(tr("update.some_failed"))
+		os.Exit(1)
+	} else {
+		log.Println(tr("update.all_failed"))
+		os.Exit(1)
+	}
+}
+
+// flagPassed reports whether a flag was given on the command line
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+// runUpdate detects IPs and reconciles all managed records.
+// Returns the detected addresses and the number of successful/attempted operations.
+func runUpdate(cf providerClient, config *Config) (ips *IPAddresses, successCount, totalCount int) {
+	detectedAt := config.now()
+	ips = detectIPs(config)
+	saveLastDetection(config, ips, detectedAt)
+
+	successCount, totalCount = reconcileRecords(cf, config, ips, detectedAt)
+	return ips, successCount, totalCount
+}
+
+// reconcileRecords brings all managed records in line with the given addresses, then
+// reports the run. Returns the number of successful/attempted operations.
+func reconcileRecords(cf providerClient, config *Config, ips *IPAddresses, detectedAt time.Time) (successCount, totalCount int) {
+	changes, successCount, totalCount := applyRecords(cf, config, ips)
+	reportRun(config, ips, changes, detectedAt, successCount, totalCount)
+	return successCount, totalCount
+}
+
+// applyRecords brings all managed records in line with the given addresses, without
+// reporting the run. Returns the changes made and the number of successful/attempted
+// operations.
+func applyRecords(cf providerClient, config *Config, ips *IPAddresses) (changes []RecordChange, successCount, totalCount int) {
+	// Journal this run in the state file, so that a run interrupted half-way is repaired by
+	// the next one (see runjournal.go)
+	journal, interrupted, pendingRepairs := openRunJournal(config, config.now())
+	defer journal.close()
+
+	// Collect record changes for the changelog TXT record and change statistics,
+	// still passing them on to any hook the caller installed
+	recordChange := newChangeRecorder(&changes)
+	callerHook := cf.hooks().OnChange
+	cf.hooks().OnChange = func(action, recordType, name, content string) {
+		recordChange(action, recordType, name, content)
+		journal.record(action, recordType, name, content)
+		if callerHook != nil {
+			callerHook(action, recordType, name, content)
 		}
+	}
+	defer func() { cf.hooks().OnChange = callerHook }()
+
+	// Reconcile the managed records, in one batch where the provider supports it. The
+	// external domain may point at the tunnel instead, and changes of critical names wait
+	// for the old TTL to expire.
+	applyInterfaceComments(cf, config, ips)
+	tunnelConfig, recordSuccess, recordTotal := reconcileTunnel(cf, config, ips)
+	successCount += recordSuccess
+	totalCount += recordTotal
+	if interrupted != nil || len(pendingRepairs) > 0 {
+		recordSuccess, recordTotal = repairInterruptedRun(cf, tunnelConfig, ips, interrupted, pendingRepairs, journal)
+		successCount += recordSuccess
+		totalCount += recordTotal
+	}
+	batched := false
+	stagedConfig, stagedIPs := stageCriticalChanges(cf, tunnelConfig, ips)
+	if batch, ok := cf.(batchProvider); ok && config.UpdateConcurrency > 0 {
+		recordSuccess, recordTotal, batched = reconcileBatch(batch, stagedConfig, stagedIPs)
+	}
+	if !batched {
+		recordSuccess, recordTotal = reconcileRecordByRecord(cf, stagedConfig, stagedIPs)
+	}
+	successCount += recordSuccess
+	totalCount += recordTotal
+
+	// Push the external addresses to DynDNS2 hostnames alongside the provider
+	dynSuccess, dynTotal := pushDynDNS2Hostnames(config, ips)
+	successCount += dynSuccess
+	totalCount += dynTotal
+
+	// Mirror the internal records to the local resolver
+	mirrorSuccess, mirrorTotal := pushLocalMirror(config, ips)
+	successCount += mirrorSuccess
+	totalCount += mirrorTotal
 
-		// Create/update heartbeat for this domain
-		heartbeatName := heartbeatRecordName(config.InternalDomain)
-		heartbeatData := heartbeatContent()
+	// Create/update single heartbeat for this host
+	heartbeatDomain := hostHeartbeatDomain(config)
+	if heartbeatDomain != "" {
+		heartbeatName := heartbeatRecordName(heartbeatDomain)
+		heartbeatData := heartbeatRecordContent(config, config.now())
 		totalCount++
-		if cf.upsertRecord(heartbeatName, "TXT", heartbeatData, false) {
+		if succeeded(cf.upsertHeartbeat(heartbeatName, heartbeatData)) {
 			successCount++
-			log.Printf("Updated heartbeat for %s", config.InternalDomain)
+			log.Printf("Updated heartbeat for %s", heartbeatDomain)
+		}
+
+		// Record this run's changes in the rolling changelog
+		if config.Changelog && len(changes) > 0 {
+			totalCount++
+			if succeeded(updateChangelog(cf, heartbeatDomain, changes)) {
+				successCount++
+			}
+		}
+	}
+
+	// Send heartbeats held back by the provider that did not ride along with a change
+	if buffer, ok := cf.(heartbeatBuffer); ok {
+		if sent, err := buffer.flushHeartbeats(); sent > 0 || err != nil {
+			totalCount++
+			if succeeded(err) {
+				successCount++
+			}
+		}
+	}
+	return changes, successCount, totalCount
+}
+
+// reportRun records a run's changes (journal, statistics) and tells downstream systems
+// and people about them
+func reportRun(config *Config, ips *IPAddresses, changes []RecordChange, detectedAt time.Time, successCount, totalCount int) {
+	// Record this run's changes in the signed journal
+	recordHistory(config, changes, ips)
+
+	// Tell downstream systems when an external address changed
+	notifyIPChange(config, ips)
+
+	// Measure the line after an external address change
+	probe := probeAfterExternalChange(config, changes, newNotifier(config))
+
+	// Record change statistics and send the monthly report
+	if config.StateFile != "" {
+		state, err := loadState(config.StateFile)
+		if err != nil {
+			log.Printf("WARNING: Could not load state, statistics not recorded: %v", err)
+		} else {
+			recordRunStats(config, state, changes, detectedAt, probe)
+			notifyUpdateResult(config, state, successCount, totalCount)
+			maybeSendMonthlyReport(config, state, newNotifier(config), time.Now())
+			maybePublishStatusPage(config, ips, state, time.Now())
+			if err := state.save(config.StateFile); err != nil {
+				log.Printf("WARNING: Could not save state: %v", err)
+			}
 		}
+	} else {
+		notifyUpdateResult(config, nil, successCount, totalCount)
+		maybePublishStatusPage(config, ips, nil, time.Now())
+	}
+}
+
+// reconcileRecordByRecord reconciles the managed records one lookup and change at a time
+func reconcileRecordByRecord(cf providerClient, config *Config, ips *IPAddresses) (successCount, totalCount int) {
+	// Update internal IPv4 records (support multiple addresses)
+	if config.InternalDomain != "" {
+		if len(ips.InternalIPv4) > 0 {
+			// Get all existing records for the internal domain
+			existingRecords, err := cf.getAllRecords(config.InternalDomain, "A")
+			if !succeeded(err) {
+				totalCount++
+			}
+
+			// Create a map of existing record contents for quick lookup
+			existingIPs := make(map[string]string, len(existingRecords)) // content -> recordID
+			for _, record := range existingRecords {
+				existingIPs[record.Content] = record.ID
+			}
+
+			// Create a set of detected IPs (compared by address, not spelling)
+			detectedIPs := newAddrSet(ips.InternalIPv4)
 
-		// Delete stale records (IPs that exist in DNS but not in detected list)
-		for content, recordID := range existingIPs {
-			if !detectedIPs[content] {
+			// Create/update records for each detected IP
+			for _, ip := range ips.InternalIPv4 {
 				totalCount++
-				log.Printf("Deleting stale internal IPv4 record: %s", content)
-				if cf.deleteRecord(recordID, config.InternalDomain, "A") {
+				if succeeded(cf.ensureRecordExists(config.InternalDomain, "A", ip, config.proxiedFor(config.InternalDomain, ip))) {
 					successCount++
 				}
 			}
-		}
-	} else {
-		// No internal IPs found - delete all existing records and heartbeat
-		existingRecords := cf.getAllRecords(config.InternalDomain, "A")
-		for _, record := range existingRecords {
+
+			// Create/update heartbeat for this domain
+			heartbeatName := heartbeatRecordName(config.InternalDomain)
+			heartbeatData := heartbeatRecordContent(config, config.now())
 			totalCount++
-			log.Printf("No internal IPv4 addresses found - deleting record: %s", record.Content)
-			if cf.deleteRecord(record.ID, config.InternalDomain, "A") {
+			if succeeded(cf.upsertHeartbeat(heartbeatName, heartbeatData)) {
 				successCount++
+				log.Printf("Updated heartbeat for %s", config.InternalDomain)
 			}
-		}
 
-		// Delete the heartbeat
-		heartbeatName := heartbeatRecordName(config.InternalDomain)
-		totalCount++
-		if cf.deleteRecordIfExists(heartbeatName, "TXT") {
-			successCount++
-			log.Printf("Deleted heartbeat for %s", config.InternalDomain)
+			// Delete stale records (IPs that exist in DNS but not in detected list)
+			for content, recordID := range existingIPs {
+				if !detectedIPs.hasContent(content) {
+					totalCount++
+					log.Printf("Deleting stale internal IPv4 record: %s", content)
+					if succeeded(cf.deleteRecord(recordID, config.InternalDomain, "A")) {
+						successCount++
+					}
+				}
+			}
+		} else {
+			// No internal IPs found - delete all existing records and heartbeat
+			existingRecords, err := cf.getAllRecords(config.InternalDomain, "A")
+			if !succeeded(err) {
+				totalCount++
+			}
+			for _, record := range existingRecords {
+				totalCount++
+				log.Printf("No internal IPv4 addresses found - deleting record: %s", record.Content)
+				if succeeded(cf.deleteRecord(record.ID, config.InternalDomain, "A")) {
+					successCount++
+				}
+			}
+
+			// Delete the heartbeat
+			heartbeatName := heartbeatRecordName(config.InternalDomain)
+			totalCount++
+			if succeeded(cf.deleteRecordIfExists(heartbeatName, "TXT")) {
+				successCount++
+				log.Printf("Deleted heartbeat for %s", config.InternalDomain)
+			}
 		}
 	}
 
@@ -189,54 +721,57 @@ func main() {
 
 		if exists && len(customIPs) > 0 {
 			// Get all existing records for this custom domain
-			existingRecords := cf.getAllRecords(customRange.Domain, "A")
+			existingRecords, err := cf.getAllRecords(customRange.Domain, "A")
+			if !succeeded(err) {
+				totalCount++
+			}
 
 			// Create a map of existing record contents for quick lookup
-			existingIPs := make(map[string]string) // content -> recordID
+			existingIPs := make(map[string]string, len(existingRecords)) // content -> recordID
 			for _, record := range existingRecords {
 				existingIPs[record.Content] = record.ID
 			}
 
-			// Create a map of detected IPs
-			detectedIPs := make(map[string]bool)
-			for _, ip := range customIPs {
-				detectedIPs[ip] = true
-			}
+			// Create a set of detected IPs (compared by address, not spelling)
+			detectedIPs := newAddrSet(customIPs)
 
 			// Create/update records for each detected IP
 			for _, ip := range customIPs {
 				totalCount++
-				if cf.ensureRecordExists(customRange.Domain, "A", ip, config.Proxied) {
+				if succeeded(cf.ensureRecordExists(customRange.Domain, "A", ip, config.proxiedFor(customRange.Domain, ip))) {
 					successCount++
 				}
 			}
 
 			// Create/update heartbeat for this domain
 			heartbeatName := heartbeatRecordName(customRange.Domain)
-			heartbeatData := heartbeatContent()
+			heartbeatData := heartbeatRecordContent(config, config.now())
 			totalCount++
-			if cf.upsertRecord(heartbeatName, "TXT", heartbeatData, false) {
+			if succeeded(cf.upsertHeartbeat(heartbeatName, heartbeatData)) {
 				successCount++
 				log.Printf("Updated heartbeat for %s", customRange.Domain)
 			}
 
 			// Delete stale records (IPs that exist in DNS but not in detected list)
 			for content, recordID := range existingIPs {
-				if !detectedIPs[content] {
+				if !detectedIPs.hasContent(content) {
 					totalCount++
 					log.Printf("Deleting stale custom range IPv4 record: %s", content)
-					if cf.deleteRecord(recordID, customRange.Domain, "A") {
+					if succeeded(cf.deleteRecord(recordID, customRange.Domain, "A")) {
 						successCount++
 					}
 				}
 			}
 		} else {
 			// No IPs found for this custom range - delete all existing records and heartbeat
-			existingRecords := cf.getAllRecords(customRange.Domain, "A")
+			existingRecords, err := cf.getAllRecords(customRange.Domain, "A")
+			if !succeeded(err) {
+				totalCount++
+			}
 			for _, record := range existingRecords {
 				totalCount++
 				log.Printf("No IPs found in custom range %s - deleting record: %s", customRange.CIDR, record.Content)
-				if cf.deleteRecord(record.ID, customRange.Domain, "A") {
+				if succeeded(cf.deleteRecord(record.ID, customRange.Domain, "A")) {
 					successCount++
 				}
 			}
@@ -244,7 +779,7 @@ func main() {
 			// Delete the heartbeat
 			heartbeatName := heartbeatRecordName(customRange.Domain)
 			totalCount++
-			if cf.deleteRecordIfExists(heartbeatName, "TXT") {
+			if succeeded(cf.deleteRecordIfExists(heartbeatName, "TXT")) {
 				successCount++
 				log.Printf("Deleted heartbeat for %s", customRange.Domain)
 			}
@@ -257,54 +792,57 @@ func main() {
 
 		if exists && len(customIPs) > 0 {
 			// Get all existing records for this custom domain
-			existingRecords := cf.getAllRecords(customRange.Domain, "AAAA")
+			existingRecords, err := cf.getAllRecords(customRange.Domain, "AAAA")
+			if !succeeded(err) {
+				totalCount++
+			}
 
 			// Create a map of existing record contents for quick lookup
-			existingIPs := make(map[string]string) // content -> recordID
+			existingIPs := make(map[string]string, len(existingRecords)) // content -> recordID
 			for _, record := range existingRecords {
 				existingIPs[record.Content] = record.ID
 			}
 
-			// Create a map of detected IPs
-			detectedIPs := make(map[string]bool)
-			for _, ip := range customIPs {
-				detectedIPs[ip] = true
-			}
+			// Create a set of detected IPs (compared by address, not spelling)
+			detectedIPs := newAddrSet(customIPs)
 
 			// Create/update records for each detected IP
 			for _, ip := range customIPs {
 				totalCount++
-				if cf.ensureRecordExists(customRange.Domain, "AAAA", ip, config.Proxied) {
+				if succeeded(cf.ensureRecordExists(customRange.Domain, "AAAA", ip, config.proxiedFor(customRange.Domain, ip))) {
 					successCount++
 				}
 			}
 
 			// Create/update heartbeat for this domain
 			heartbeatName := heartbeatRecordName(customRange.Domain)
-			heartbeatData := heartbeatContent()
+			heartbeatData := heartbeatRecordContent(config, config.now())
 			totalCount++
-			if cf.upsertRecord(heartbeatName, "TXT", heartbeatData, false) {
+			if succeeded(cf.upsertHeartbeat(heartbeatName, heartbeatData)) {
 				successCount++
 				log.Printf("Updated heartbeat for %s", customRange.Domain)
 			}
 
 			// Delete stale records (IPs that exist in DNS but not in detected list)
 			for content, recordID := range existingIPs {
-				if !detectedIPs[content] {
+				if !detectedIPs.hasContent(content) {
 					totalCount++
 					log.Printf("Deleting stale custom range IPv6 record: %s", content)
-					if cf.deleteRecord(recordID, customRange.Domain, "AAAA") {
+					if succeeded(cf.deleteRecord(recordID, customRange.Domain, "AAAA")) {
 						successCount++
 					}
 				}
 			}
 		} else {
 			// No IPs found for this custom range - delete all existing records and heartbeat
-			existingRecords := cf.getAllRecords(customRange.Domain, "AAAA")
+			existingRecords, err := cf.getAllRecords(customRange.Domain, "AAAA")
+			if !succeeded(err) {
+				totalCount++
+			}
 			for _, record := range existingRecords {
 				totalCount++
 				log.Printf("No IPs found in custom range %s - deleting record: %s", customRange.CIDR, record.Content)
-				if cf.deleteRecord(record.ID, customRange.Domain, "AAAA") {
+				if succeeded(cf.deleteRecord(record.ID, customRange.Domain, "AAAA")) {
 					successCount++
 				}
 			}
@@ -312,7 +850,7 @@ func main() {
 			// Delete the heartbeat
 			heartbeatName := heartbeatRecordName(customRange.Domain)
 			totalCount++
-			if cf.deleteRecordIfExists(heartbeatName, "TXT") {
+			if succeeded(cf.deleteRecordIfExists(heartbeatName, "TXT")) {
 				successCount++
 				log.Printf("Deleted heartbeat for %s", customRange.Domain)
 			}
@@ -320,30 +858,34 @@ func main() {
 	}
 
 	// Update external IPv4 record
-	totalCount++
-	if ips.ExternalIPv4 != "" {
-		if cf.upsertRecord(config.ExternalDomain, "A", ips.ExternalIPv4, config.Proxied) {
-			successCount++
-			log.Printf("Updated external IPv4: %s -> %s", config.ExternalDomain, ips.ExternalIPv4)
-		}
-	} else {
-		log.Println("No external IPv4 address found - deleting any existing record")
-		if cf.deleteRecordIfExists(config.ExternalDomain, "A") {
-			successCount++
+	if config.ExternalDomain != "" {
+		totalCount++
+		if ips.ExternalIPv4 != "" {
+			if succeeded(cf.upsertRecord(config.ExternalDomain, "A", ips.ExternalIPv4, config.proxiedFor(config.ExternalDomain, ips.ExternalIPv4))) {
+				successCount++
+				log.Printf("Updated external IPv4: %s -> %s", config.ExternalDomain, ips.ExternalIPv4)
+			}
+		} else {
+			log.Println("No external IPv4 address found - deleting any existing record")
+			if succeeded(cf.deleteRecordIfExists(config.ExternalDomain, "A")) {
+				successCount++
+			}
 		}
 	}
 
 	// Update external IPv6 record
-	totalCount++
-	if ips.ExternalIPv6 != "" {
-		if cf.upsertRecord(config.IPv6Domain, "AAAA", ips.ExternalIPv6, config.Proxied) {
-			successCount++
-			log.Printf("Updated external IPv6: %s -> %s", config.IPv6Domain, ips.ExternalIPv6)
-		}
-	} else {
-		log.Println("No external IPv6 address found - deleting any existing record")
-		if cf.deleteRecordIfExists(config.IPv6Domain, "AAAA") {
-			successCount++
+	if config.IPv6Domain != "" {
+		totalCount++
+		if ips.ExternalIPv6 != "" {
+			if succeeded(cf.upsertRecord(config.IPv6Domain, "AAAA", ips.ExternalIPv6, config.proxiedFor(config.IPv6Domain, ips.ExternalIPv6))) {
+				successCount++
+				log.Printf("Updated external IPv6: %s -> %s", config.IPv6Domain, ips.ExternalIPv6)
+			}
+		} else {
+			log.Println("No external IPv6 address found - deleting any existing record")
+			if succeeded(cf.deleteRecordIfExists(config.IPv6Domain, "AAAA")) {
+				successCount++
+			}
 		}
 	}
 
@@ -369,45 +911,48 @@ func main() {
 		// Update A records for all IPv4s
 		if len(allIPv4s) > 0 {
 			// Get all existing A records for the combined domain
-			existingRecords := cf.getAllRecords(config.CombinedDomain, "A")
+			existingRecords, err := cf.getAllRecords(config.CombinedDomain, "A")
+			if !succeeded(err) {
+				totalCount++
+			}
 
 			// Create a map of existing record contents for quick lookup
-			existingIPs := make(map[string]string) // content -> recordID
+			existingIPs := make(map[string]string, len(existingRecords)) // content -> recordID
 			for _, record := range existingRecords {
 				existingIPs[record.Content] = record.ID
 			}
 
-			// Create a map of detected IPs
-			detectedIPs := make(map[string]bool)
-			for _, ip := range allIPv4s {
-				detectedIPs[ip] = true
-			}
+			// Create a set of detected IPs (compared by address, not spelling)
+			detectedIPs := newAddrSet(allIPv4s)
 
 			// Create/update records for each IPv4
 			for _, ip := range allIPv4s {
 				totalCount++
-				if cf.ensureRecordExists(config.CombinedDomain, "A", ip, config.Proxied) {
+				if succeeded(cf.ensureRecordExists(config.CombinedDomain, "A", ip, config.proxiedFor(config.CombinedDomain, ip))) {
 					successCount++
 				}
 			}
 
 			// Delete stale A records (IPs that exist in DNS but not in detected list)
 			for content, recordID := range existingIPs {
-				if !detectedIPs[content] {
+				if !detectedIPs.hasContent(content) {
 					totalCount++
 					log.Printf("Deleting stale combined domain A record: %s", content)
-					if cf.deleteRecord(recordID, config.CombinedDomain, "A") {
+					if succeeded(cf.deleteRecord(recordID, config.CombinedDomain, "A")) {
 						successCount++
 					}
 				}
 			}
 		} else {
 			// No IPv4s found - delete all A records
-			existingRecords := cf.getAllRecords(config.CombinedDomain, "A")
+			existingRecords, err := cf.getAllRecords(config.CombinedDomain, "A")
+			if !succeeded(err) {
+				totalCount++
+			}
 			for _, record := range existingRecords {
 				totalCount++
 				log.Printf("No IPv4 addresses found - deleting combined domain A record: %s", record.Content)
-				if cf.deleteRecord(record.ID, config.CombinedDomain, "A") {
+				if succeeded(cf.deleteRecord(record.ID, config.CombinedDomain, "A")) {
 					successCount++
 				}
 			}
@@ -416,13 +961,13 @@ func main() {
 		// Update AAAA record for external IPv6
 		totalCount++
 		if ips.ExternalIPv6 != "" {
-			if cf.upsertRecord(config.CombinedDomain, "AAAA", ips.ExternalIPv6, config.Proxied) {
+			if succeeded(cf.upsertRecord(config.CombinedDomain, "AAAA", ips.ExternalIPv6, config.proxiedFor(config.CombinedDomain, ips.ExternalIPv6))) {
 				successCount++
 				log.Printf("Updated combined domain IPv6: %s -> %s", config.CombinedDomain, ips.ExternalIPv6)
 			}
 		} else {
 			log.Println("No external IPv6 address found - deleting combined domain AAAA record")
-			if cf.deleteRecordIfExists(config.CombinedDomain, "AAAA") {
+			if succeeded(cf.deleteRecordIfExists(config.CombinedDomain, "AAAA")) {
 				successCount++
 			}
 		}
@@ -434,59 +979,151 @@ func main() {
 
 		// Create/update CNAME record pointing to combined domain
 		totalCount++
-		if cf.upsertRecord(config.TopLevelDomain, "CNAME", config.CombinedDomain, config.Proxied) {
+		if succeeded(cf.upsertRecord(config.TopLevelDomain, "CNAME", config.CombinedDomain, config.proxiedName(config.TopLevelDomain))) {
 			successCount++
 			log.Printf("Updated CNAME: %s -> %s", config.TopLevelDomain, config.CombinedDomain)
 		}
 	} else if config.TopLevelDomain != "" && config.CombinedDomain == "" {
-		log.Println("WARNING: TOP_LEVEL_DOMAIN is set but COMBINED_DOMAIN is not - skipping CNAME creation")
-	}
-
-	// Create/update single heartbeat for this host
-	// Use TOP_LEVEL_DOMAIN if set, otherwise COMBINED_DOMAIN, otherwise first available domain
-	heartbeatDomain := ""
-	if config.TopLevelDomain != "" {
-		heartbeatDomain = config.TopLevelDomain
-	} else if config.CombinedDomain != "" {
-		heartbeatDomain = config.CombinedDomain
-	} else if config.InternalDomain != "" {
-		heartbeatDomain = config.InternalDomain
-	} else if config.ExternalDomain != "" {
-		heartbeatDomain = config.ExternalDomain
-	} else if config.IPv6Domain != "" {
-		heartbeatDomain = config.IPv6Domain
+		log.Println(tr("update.toplevel_no_combined"))
 	}
 
-	if heartbeatDomain != "" {
-		heartbeatName := heartbeatRecordName(heartbeatDomain)
-		heartbeatData := heartbeatContent()
+	// Update the service records pointing at the combined domain
+	for _, set := range serviceRecordSets(config) {
 		totalCount++
-		if cf.upsertRecord(heartbeatName, "TXT", heartbeatData, false) {
+		if succeeded(cf.upsertRecord(set.Name, set.Type, set.Values[0], false)) {
 			successCount++
-			log.Printf("Updated heartbeat for %s", heartbeatDomain)
 		}
 	}
 
-	// Report results
-	log.Printf("Completed: %d/%d records updated successfully\n", successCount, totalCount)
-
-	if successCount == totalCount && totalCount > 0 {
-		log.Println("All updates successful!")
-		os.Exit(0)
-	} else if successCount > 0 {
-		log.Println("Some updates failed")
-		os.Exit(1)
-	} else {
-		log.Println("All updates failed")
-		os.Exit(1)
-	}
+	return successCount, totalCount
 }
 
 func loadConfig(cleanupMode bool) *Config {
-	apiToken := getEnvOrExit("CF_API_TOKEN")
+	return readConfig(cleanupMode, true)
+}
+
+// readConfig loads configuration from the environment (and the config file, if one is set).
+// When requireCredentials is false, missing CloudFlare credentials and domains are tolerated
+// so that inspection subcommands and detection-only mode can run before a token has been created.
+func readConfig(cleanupMode bool, requireCredentials bool) *Config {
+	loadConfigFileFromEnv()
+	dockerMode := applyDockerMode()
+
+	provider := strings.ToLower(getEnvOrDefault("PROVIDER", providerCloudFlare))
+	providerSetting := getEnv
+	if requireCredentials {
+		providerSetting = getEnvOrExit
+	}
+
+	var apiToken, zoneID, hostedZoneID string
+	var vault *vaultSecret
+	var azureSubscriptionID, azureResourceGroup, azureZone string
+	var rfc2136Server, rfc2136Zone string
+	var porkbunAPIKey, porkbunSecretKey, porkbunDomain string
+	var ns1APIKey, ns1Zone string
+	var dynv6Token, dynv6Zone string
+	var dynv6PrefixLength int
+	var execCommand string
+	var execArgs []string
+	execTimeout := 30
+	var grpcSocket string
+	var webhookURL, webhookSecret string
+	var hostsFilePath string
+	var etcdEndpoint, etcdUsername, etcdPassword string
+	var zoneFilePath, zoneFileOrigin string
+	var relayURL, relayToken string
+	dynDNS2Server := getEnv("DYNDNS2_SERVER")
+	dynDNS2Username, dynDNS2Password := getEnv("DYNDNS2_USERNAME"), getEnv("DYNDNS2_PASSWORD")
+	switch {
+	case provider == providerRoute53:
+		// Route53 authenticates with the AWS credential chain instead of an API token
+		hostedZoneID = providerSetting("ROUTE53_HOSTED_ZONE_ID")
+	case provider == providerAzure:
+		// Azure DNS authenticates with Azure AD (service principal, workload or managed identity)
+		azureSubscriptionID = providerSetting("AZURE_SUBSCRIPTION_ID")
+		azureResourceGroup = providerSetting("AZURE_RESOURCE_GROUP")
+		azureZone = providerSetting("AZURE_DNS_ZONE")
+	case provider == providerRFC2136:
+		// RFC 2136 updates are signed with a TSIG key (or allowed by the server by address)
+		rfc2136Server = providerSetting("RFC2136_SERVER")
+		rfc2136Zone = providerSetting("RFC2136_ZONE")
+	case provider == providerDynDNS2:
+		// DynDNS2 services authenticate with basic auth
+		dynDNS2Server = providerSetting("DYNDNS2_SERVER")
+		dynDNS2Username = providerSetting("DYNDNS2_USERNAME")
+		dynDNS2Password = providerSetting("DYNDNS2_PASSWORD")
+	case provider == providerPorkbun:
+		// Porkbun authenticates every request with an API key pair
+		porkbunAPIKey = providerSetting("PORKBUN_API_KEY")
+		porkbunSecretKey = providerSetting("PORKBUN_SECRET_API_KEY")
+		porkbunDomain = providerSetting("PORKBUN_DOMAIN")
+	case provider == providerNS1:
+		// NS1 authenticates with an API key in the X-NSONE-Key header
+		ns1APIKey = providerSetting("NS1_API_KEY")
+		ns1Zone = providerSetting("NS1_ZONE")
+	case provider == providerDynv6:
+		// dynv6 authenticates with an HTTP token (a bearer token for the REST API)
+		dynv6Token = providerSetting("DYNV6_TOKEN")
+		dynv6Zone = providerSetting("DYNV6_ZONE")
+		dynv6PrefixLength = getEnvOrDefaultInt("DYNV6_PREFIX_LENGTH", 0)
+	case provider == providerExec:
+		// The exec provider runs a user-supplied executable, which handles its own credentials
+		execCommand = providerSetting("EXEC_COMMAND")
+		execArgs = splitList(getEnv("EXEC_ARGS"))
+		execTimeout = getEnvOrDefaultInt("EXEC_TIMEOUT_SECONDS", execTimeout)
+	case provider == providerGRPC:
+		// A gRPC plugin handles its own credentials, like the exec provider's executable
+		grpcSocket = providerSetting("GRPC_SOCKET")
+	case provider == providerWebhook:
+		// Webhook requests are signed with a shared secret
+		webhookURL = providerSetting("WEBHOOK_URL")
+		webhookSecret = providerSetting("WEBHOOK_SECRET")
+	case provider == providerHosts:
+		// The hosts provider writes a local file and needs no credentials
+		hostsFilePath = providerSetting("HOSTS_FILE_PATH")
+	case provider == providerEtcd:
+		// etcd authenticates with a user and password if authentication is enabled
+		etcdEndpoint = providerSetting("ETCD_ENDPOINT")
+		etcdUsername, etcdPassword = getEnv("ETCD_USERNAME"), getEnv("ETCD_PASSWORD")
+	case provider == providerZoneFile:
+		// The zonefile provider writes a local file served by an authoritative server
+		zoneFilePath = providerSetting("ZONE_FILE_PATH")
+		zoneFileOrigin = providerSetting("ZONE_FILE_ORIGIN")
+	case provider == providerRelay:
+		// Agents of a relay authenticate with their own token and/or client certificate;
+		// the relay holds the DNS credentials
+		relayURL = providerSetting("RELAY_URL")
+		relayToken = getEnv("RELAY_TOKEN")
+	case provider != providerCloudFlare:
+		configFatalf("ERROR: %sPROVIDER must be %q, %q, %q, %q, %q, %q, %q, %q, %q, %q, %q, %q, %q, %q or %q, got %q", envPrefix, providerCloudFlare, providerRoute53, providerAzure, providerRFC2136, providerDynDNS2, providerPorkbun, providerNS1, providerDynv6, providerExec, providerWebhook, providerHosts, providerEtcd, providerZoneFile, providerRelay, providerGRPC, provider)
+	case requireCredentials:
+		apiToken = getEnv("CF_API_TOKEN")
+		if vault = parseVaultConfig(); vault != nil {
+			apiToken = vaultAPIToken(vault, apiToken)
+		}
+		if apiToken == "" {
+			// Fall back to a token stored with "dynipupdate login"
+			if token, err := keychainLoad(); err == nil {
+				log.Println("Using API token from the OS credential store")
+				apiToken = token
+			}
+		}
+		if apiToken == "" {
+			configFatal(tr("config.required", envPrefix, "CF_API_TOKEN"))
+		}
+		zoneID = getEnv("CF_ZONE_ID") // Discovered from the domains when unset
+	default:
+		apiToken = getEnv("CF_API_TOKEN")
+		vault = parseVaultConfig() // The token is read from Vault on the first API request
+		zoneID = getEnv("CF_ZONE_ID")
+	}
 
 	// Trim any whitespace that might have been included
 	apiToken = strings.TrimSpace(apiToken)
+	zoneIDs := splitList(zoneID)
+	if len(zoneIDs) > 0 {
+		zoneID = zoneIDs[0]
+	}
 
 	// Parse custom IP ranges (supports up to 20 ranges for each type)
 	customIPv4Ranges := parseCustomRanges("IPV4_RANGE", "A", 20)
@@ -494,60 +1131,266 @@ func loadConfig(cleanupMode bool) *Config {
 
 	// Debug: Check for common issues
 	if strings.HasPrefix(apiToken, "\"") || strings.HasPrefix(apiToken, "'") {
-		log.Printf("WARNING: API token appears to have quotes around it (len=%d, first char=%q, last char=%q)",
-			len(apiToken), apiToken[0], apiToken[len(apiToken)-1])
+		log.Println(tr("token.quoted", len(apiToken), apiToken[0], apiToken[len(apiToken)-1]))
 	}
 
-	log.Printf("API token loaded (length: %d chars, starts with: %.8s..., ends with: ...%.4s)",
-		len(apiToken), apiToken, apiToken[max(0, len(apiToken)-4):])
+	if apiToken != "" {
+		log.Println(tr("token.loaded", len(apiToken), apiToken, apiToken[max(0, len(apiToken)-4):]))
+	}
 
+	logFormat, logLevel := parseLogSettings()
 	config := &Config{
-		CFAPIToken:       apiToken,
-		CFZoneID:         getEnvOrExit("CF_ZONE_ID"),
-		InternalDomain:   getEnv("INTERNAL_DOMAIN"),
-		ExternalDomain:   getEnv("EXTERNAL_DOMAIN"),
-		IPv6Domain:       getEnv("IPV6_DOMAIN"),
-		CustomIPv4Ranges: customIPv4Ranges,
-		CustomIPv6Ranges: customIPv6Ranges,
-		CombinedDomain:   getEnv("COMBINED_DOMAIN"),
-		TopLevelDomain:   getEnv("TOP_LEVEL_DOMAIN"),
-		Proxied:          strings.ToLower(getEnv("CF_PROXIED")) == "true",
-		StaleThreshold:   getEnvOrDefaultInt("STALE_THRESHOLD_SECONDS", 3600), // 1 hour
-		CleanupInterval:  getEnvOrDefaultInt("CLEANUP_INTERVAL_SECONDS", 300), // 5 minutes
-	}
-
-	// At least one domain must be configured (both modes require this for safety)
+		Provider:                 provider,
+		DockerMode:               dockerMode,
+		CFAPIToken:               apiToken,
+		vault:                    vault,
+		CFZoneID:                 zoneID,
+		CFZoneIDs:                zoneIDs,
+		CFAccountID:              getEnv("CF_ACCOUNT_ID"),
+		Route53HostedZoneID:      hostedZoneID,
+		AzureSubscriptionID:      azureSubscriptionID,
+		AzureResourceGroup:       azureResourceGroup,
+		AzureDNSZone:             azureZone,
+		RFC2136Server:            rfc2136Server,
+		DynDNS2Server:            dynDNS2Server,
+		DynDNS2Username:          dynDNS2Username,
+		DynDNS2Password:          dynDNS2Password,
+		DynDNS2Hostnames:         splitList(getEnv("DYNDNS2_HOSTNAMES")),
+		RFC2136Zone:              rfc2136Zone,
+		PorkbunAPIKey:            porkbunAPIKey,
+		PorkbunSecretKey:         porkbunSecretKey,
+		PorkbunDomain:            porkbunDomain,
+		NS1APIKey:                ns1APIKey,
+		NS1Zone:                  ns1Zone,
+		Dynv6Token:               dynv6Token,
+		Dynv6Zone:                dynv6Zone,
+		Dynv6UpdateURL:           provider == providerDynv6 && strings.ToLower(getEnv("DYNV6_UPDATE_URL")) == "true",
+		Dynv6PrefixLength:        dynv6PrefixLength,
+		ExecCommand:              execCommand,
+		ExecArgs:                 execArgs,
+		ExecTimeout:              execTimeout,
+		GRPCSocket:               grpcSocket,
+		WebhookURL:               webhookURL,
+		WebhookSecret:            webhookSecret,
+		HostsFilePath:            hostsFilePath,
+		EtcdEndpoint:             etcdEndpoint,
+		EtcdPrefix:               getEnvOrDefault("ETCD_PREFIX", "/skydns"),
+		EtcdUsername:             etcdUsername,
+		EtcdPassword:             etcdPassword,
+		ZoneFilePath:             zoneFilePath,
+		ZoneFileOrigin:           zoneFileOrigin,
+		ZoneFileNameserver:       getEnv("ZONE_FILE_NAMESERVER"),
+		RelayURL:                 relayURL,
+		RelayToken:               relayToken,
+		RelayClientCert:          getEnv("RELAY_CLIENT_CERT"),
+		RelayClientKey:           getEnv("RELAY_CLIENT_KEY"),
+		RelayCACert:              getEnv("RELAY_CA_CERT"),
+		LocalMirror:              strings.ToLower(getEnv("LOCAL_MIRROR")),
+		LocalMirrorServer:        getEnv("LOCAL_MIRROR_SERVER"),
+		LocalMirrorZone:          getEnv("LOCAL_MIRROR_ZONE"),
+		LocalMirrorHostsFile:     getEnv("LOCAL_MIRROR_HOSTS_FILE"),
+		LocalMirrorUnboundFile:   getEnv("LOCAL_MIRROR_UNBOUND_FILE"),
+		LocalMirrorReloadCommand: getEnv("LOCAL_MIRROR_RELOAD_COMMAND"),
+		TSIGKeyName:              getEnv("TSIG_KEY_NAME"),
+		TSIGSecret:               getEnv("TSIG_SECRET"),
+		TSIGAlgorithm:            getEnvOrDefault("TSIG_ALGORITHM", "hmac-sha256"),
+		InternalDomain:           getEnv("INTERNAL_DOMAIN"),
+		ExternalDomain:           getEnv("EXTERNAL_DOMAIN"),
+		IPv6Domain:               getEnv("IPV6_DOMAIN"),
+		CustomIPv4Ranges:         customIPv4Ranges,
+		CustomIPv6Ranges:         customIPv6Ranges,
+		CombinedDomain:           getEnv("COMBINED_DOMAIN"),
+		TopLevelDomain:           getEnv("TOP_LEVEL_DOMAIN"),
+		Proxied:                  strings.ToLower(getEnv("CF_PROXIED")) == "true",
+		RecordTTL:                parseRecordTTL(),
+		HeartbeatTTL:             parseHeartbeatTTL(),
+		HeartbeatFormat:          parseHeartbeatFormat(),
+		LogFormat:                logFormat,
+		LogLevel:                 logLevel,
+		HeartbeatHost:            heartbeatHost(),
+		CriticalDomains:          splitList(getEnv("CRITICAL_DOMAINS")),
+		Services:                 parseServices(),
+		TunnelTarget:             parseTunnelTarget(),
+		TunnelMode:               strings.ToLower(getEnvOrDefault("TUNNEL_MODE", tunnelModeFallback)),
+		TunnelCheckPort:          getEnvOrDefaultInt("TUNNEL_CHECK_PORT", 0),
+		CanaryTTL:                parseTTLSetting("CANARY_TTL", defaultCanaryTTL),
+		APIRateLimit:             getEnvOrDefaultInt("API_RATE_LIMIT", defaultAPIRateLimit),
+		APIRetryAttempts:         getEnvOrDefaultInt("API_RETRY_ATTEMPTS", defaultAPIRetryAttempts),
+		APIRetryBackoff:          getEnvOrDefaultInt("API_RETRY_BACKOFF_SECONDS", defaultAPIRetryBackoff),
+		APIRetryMaxBackoff:       getEnvOrDefaultInt("API_RETRY_MAX_BACKOFF_SECONDS", defaultAPIRetryMaxBackoff),
+		FaultInjectionPercent:    getEnvOrDefaultInt("FAULT_INJECTION_PERCENT", 0),
+		UpdateConcurrency:        getEnvOrDefaultInt("UPDATE_CONCURRENCY", defaultUpdateConcurrency),
+		Changelog:                strings.ToLower(getEnv("CHANGELOG")) == "true",
+		StateFile:                getEnv("STATE_FILE"),
+		HistoryFile:              getEnv("HISTORY_FILE"),
+		HistorySigningKey:        getEnv("HISTORY_SIGNING_KEY"),
+		VerifyPropagation:        strings.ToLower(getEnv("VERIFY_PROPAGATION")) == "true",
+		VerifyResolver:           getEnvOrDefault("VERIFY_RESOLVER", "1.1.1.1:53"),
+		VerifyTimeout:            getEnvOrDefaultInt("VERIFY_TIMEOUT_SECONDS", 120),
+		VerifyDNSSEC:             strings.ToLower(getEnv("VERIFY_DNSSEC")) == "true",
+		VerifyDeletes:            strings.ToLower(getEnv("VERIFY_DELETES")) == "true",
+		VerifyDeleteDelay:        getEnvOrDefaultInt("VERIFY_DELETE_DELAY_SECONDS", defaultVerifyDeleteDelay),
+		SLOTargetSeconds:         getEnvOrDefaultInt("SLO_TARGET_SECONDS", 300),
+		LatencyProbeTarget:       getEnv("LATENCY_PROBE_TARGET"),
+		StatusPageDir:            getEnv("STATUS_PAGE_DIR"),
+		StatusPageS3URL:          getEnv("STATUS_PAGE_S3_URL"),
+		StatusPageS3Region:       getEnvOrDefault("STATUS_PAGE_S3_REGION", "us-east-1"),
+		StatusPageS3Endpoint:     getEnv("STATUS_PAGE_S3_ENDPOINT"),
+		StatusPageMinInterval:    getEnvOrDefaultInt("STATUS_PAGE_MIN_INTERVAL_SECONDS", 300),
+		NotifyURL:                getEnv("NOTIFY_URL"),
+		NotifyTelegramBotToken:   getEnv("NOTIFY_TELEGRAM_BOT_TOKEN"),
+		NotifyTelegramChatID:     getEnv("NOTIFY_TELEGRAM_CHAT_ID"),
+		NotifyDiscordURL:         getEnv("NOTIFY_DISCORD_URL"),
+		NotifySlackURL:           getEnv("NOTIFY_SLACK_URL"),
+		NotifyNtfyURL:            getEnv("NOTIFY_NTFY_URL"),
+		NotifyNtfyToken:          getEnv("NOTIFY_NTFY_TOKEN"),
+		NotifyPushoverToken:      getEnv("NOTIFY_PUSHOVER_TOKEN"),
+		NotifyPushoverUser:       getEnv("NOTIFY_PUSHOVER_USER"),
+		NotifyEvents:             getEnvListOrDefault("NOTIFY_EVENTS", defaultNotifyEvents),
+		IPChangeWebhookURL:       getEnv("IP_CHANGE_WEBHOOK_URL"),
+		IPChangeWebhookSecret:    getEnv("IP_CHANGE_WEBHOOK_SECRET"),
+		AuditLogInterval:         getEnvOrDefaultInt("AUDIT_LOG_INTERVAL_SECONDS", 0),
+		NetworkProfiles:          parseNetworkProfiles(maxNetworkProfiles),
+		QueueRetrySeconds:        getEnvOrDefaultInt("QUEUE_RETRY_SECONDS", 120),
+		FastStart:                strings.ToLower(getEnv("FAST_START")) == "true",
+		FastStartMaxAge:          getEnvOrDefaultInt("FAST_START_MAX_AGE_SECONDS", 3600),
+		IPv4Sources:              getEnvListOrDefault("IPV4_SOURCES", defaultIPv4Sources),
+		IPv6Sources:              getEnvListOrDefault("IPV6_SOURCES", defaultIPv6Sources),
+		EchoServices:             strings.ToLower(getEnvOrDefault("ECHO_SERVICES", "true")) == "true",
+		RecordTags:               parseRecordTags(),
+		RecordInterfaceComments:  strings.ToLower(getEnv("RECORD_INTERFACE_COMMENTS")) == "true",
+		MetricsFile:              getEnv("METRICS_FILE"),
+		MetricsListen:            getEnv("METRICS_LISTEN"),
+		HealthListen:             getEnv("HEALTH_LISTEN"),
+		HealthMaxAge:             getEnvOrDefaultInt("HEALTH_MAX_AGE_SECONDS", 0),
+		InterfaceScanMaxAge:      getEnvOrDefaultInt("INTERFACE_SCAN_MAX_AGE_SECONDS", 0),
+		IncludeLinkLocal:         strings.ToLower(getEnv("INCLUDE_LINK_LOCAL")) == "true",
+		IncludeUnstableAddresses: strings.ToLower(getEnv("INCLUDE_UNSTABLE_ADDRESSES")) == "true",
+		DHCPLeaseFiles:           getEnvListOrDefault("DHCP_LEASE_FILES", defaultDHCPLeaseFiles),
+		DADProbe:                 strings.ToLower(getEnv("DAD_PROBE")) == "true",
+		DADProbeTimeoutMs:        getEnvOrDefaultInt("DAD_PROBE_TIMEOUT_MS", 0),
+		MetricsAddressLabels:     parseMetricsAddressLabels(),
+		MetricsMaxAddressLabels:  getEnvOrDefaultInt("METRICS_MAX_ADDRESS_LABELS", 10),
+		DetectOutput:             strings.ToLower(getEnvOrDefault("DETECT_OUTPUT", detectOutputStdout)),
+		DetectWebhookURL:         getEnv("DETECT_WEBHOOK_URL"),
+		MQTTURL:                  getEnv("MQTT_URL"),
+		MQTTTopic:                getEnv("MQTT_TOPIC"),
+		EventsListen:             getEnv("EVENTS_LISTEN"),
+		EventsTokens:             getEnvListOrDefault("EVENTS_TOKENS", nil),
+		EventsTLSCert:            getEnv("EVENTS_TLS_CERT"),
+		EventsTLSKey:             getEnv("EVENTS_TLS_KEY"),
+		RelayListen:              getEnv("RELAY_LISTEN"),
+		RelayAgentsFile:          getEnv("RELAY_AGENTS_FILE"),
+		RelayTLSCert:             getEnv("RELAY_TLS_CERT"),
+		RelayTLSKey:              getEnv("RELAY_TLS_KEY"),
+		RelayClientCA:            getEnv("RELAY_CLIENT_CA"),
+		StaleThreshold:           getEnvOrDefaultInt("STALE_THRESHOLD_SECONDS", 3600), // 1 hour
+		CleanupInterval:          getEnvOrDefaultInt("CLEANUP_INTERVAL_SECONDS", 300), // 5 minutes
+		UpdateInterval:           getEnvOrDefaultInt("INTERVAL_SECONDS", 0),
+		UpdateJitter:             getEnvOrDefaultInt("INTERVAL_JITTER_SECONDS", 0),
+		CycleTimeout:             getEnvOrDefaultInt("CYCLE_TIMEOUT_SECONDS", 0),
+		WatchNetwork:             strings.ToLower(getEnvOrDefault("WATCH_NETWORK", "true")) == "true",
+	}
+
+	validateEchoSources(config.IPv4Sources)
+	validateEchoSources(config.IPv6Sources)
+	readBaseDomain(config)
+	normalizeConfigNames(config)
+	applyEchoServicesSetting(config)
+	validateIPChangeWebhook(config)
+	validateNotifiers(config)
+	validatePushNotifiers(config)
+	validateFaultInjection(config)
+
+	// At least one domain must be configured (both modes require this for safety).
+	// Inspection subcommands and detection-only mode never touch DNS, so they run without.
 	hasCustomRanges := len(config.CustomIPv4Ranges) > 0 || len(config.CustomIPv6Ranges) > 0
-	if config.InternalDomain == "" && config.ExternalDomain == "" &&
+	if requireCredentials && config.InternalDomain == "" && config.ExternalDomain == "" &&
 		config.IPv6Domain == "" && !hasCustomRanges &&
 		config.CombinedDomain == "" && config.TopLevelDomain == "" {
-		log.Fatalf("At least one domain must be configured (%sINTERNAL_DOMAIN, %sEXTERNAL_DOMAIN, %sIPV6_DOMAIN, %sIPV4_RANGE_N/%sIPV6_RANGE_N, %sCOMBINED_DOMAIN, or %sTOP_LEVEL_DOMAIN)",
-			envPrefix, envPrefix, envPrefix, envPrefix, envPrefix, envPrefix, envPrefix)
+		configFatal(tr("config.no_domains", envPrefix, envPrefix, envPrefix, envPrefix, envPrefix, envPrefix, envPrefix, envPrefix))
 	}
 
 	// Log configured custom ranges
 	if len(customIPv4Ranges) > 0 {
-		log.Printf("Configured %d custom IPv4 range(s):", len(customIPv4Ranges))
+		log.Println(tr("config.ipv4_ranges", len(customIPv4Ranges)))
 		for i, r := range customIPv4Ranges {
 			log.Printf("  [%d] %s -> %s", i+1, r.CIDR, r.Domain)
 		}
 	}
 	if len(customIPv6Ranges) > 0 {
-		log.Printf("Configured %d custom IPv6 range(s):", len(customIPv6Ranges))
+		log.Println(tr("config.ipv6_ranges", len(customIPv6Ranges)))
 		for i, r := range customIPv6Ranges {
 			log.Printf("  [%d] %s -> %s", i+1, r.CIDR, r.Domain)
 		}
 	}
 
+	if len(config.DynDNS2Hostnames) > 0 {
+		if config.DynDNS2Server == "" || config.DynDNS2Username == "" || config.DynDNS2Password == "" {
+			configFatalf("ERROR: %sDYNDNS2_HOSTNAMES needs %sDYNDNS2_SERVER, %sDYNDNS2_USERNAME and %sDYNDNS2_PASSWORD", envPrefix, envPrefix, envPrefix, envPrefix)
+		}
+		log.Printf("Also sending external addresses to DynDNS2 hostnames: %s", strings.Join(config.DynDNS2Hostnames, ", "))
+	}
+
+	switch config.LocalMirror {
+	case "":
+	case providerRFC2136:
+		if config.LocalMirrorServer == "" || config.LocalMirrorZone == "" {
+			configFatalf("ERROR: %sLOCAL_MIRROR=%s needs %sLOCAL_MIRROR_SERVER and %sLOCAL_MIRROR_ZONE", envPrefix, providerRFC2136, envPrefix, envPrefix)
+		}
+		log.Printf("Also mirroring internal records to zone %s on %s", config.LocalMirrorZone, config.LocalMirrorServer)
+	case providerHosts:
+		if config.LocalMirrorHostsFile == "" {
+			configFatalf("ERROR: %sLOCAL_MIRROR=%s needs %sLOCAL_MIRROR_HOSTS_FILE", envPrefix, providerHosts, envPrefix)
+		}
+		log.Printf("Also mirroring internal records to %s", config.LocalMirrorHostsFile)
+	case localMirrorUnbound:
+		if config.LocalMirrorUnboundFile == "" {
+			configFatalf("ERROR: %sLOCAL_MIRROR=%s needs %sLOCAL_MIRROR_UNBOUND_FILE", envPrefix, localMirrorUnbound, envPrefix)
+		}
+		log.Printf("Also mirroring internal records to %s", config.LocalMirrorUnboundFile)
+	default:
+		configFatalf("ERROR: %sLOCAL_MIRROR must be %q, %q or %q, got %q", envPrefix, providerRFC2136, providerHosts, localMirrorUnbound, config.LocalMirror)
+	}
+
+	if config.HistoryFile != "" {
+		if config.HistorySigningKey == "" {
+			configFatalf("ERROR: %sHISTORY_FILE needs %sHISTORY_SIGNING_KEY", envPrefix, envPrefix)
+		}
+		if _, err := loadSigningKey(config.HistorySigningKey); err != nil {
+			configFatalf("ERROR: %sHISTORY_SIGNING_KEY: %v", envPrefix, err)
+		}
+	}
+
+	validateTunnel(config, provider)
+
+	if config.AuditLogInterval > 0 {
+		if provider != providerCloudFlare {
+			configFatalf("ERROR: %sAUDIT_LOG_INTERVAL_SECONDS is only supported with the %s provider", envPrefix, providerCloudFlare)
+		}
+		if requireCredentials && config.CFAccountID == "" {
+			configFatal(tr("config.required", envPrefix, "CF_ACCOUNT_ID"))
+		}
+	}
+
 	if cleanupMode {
-		log.Printf("Cleanup Configuration:")
-		log.Printf("  Stale Threshold: %d seconds", config.StaleThreshold)
-		log.Printf("  Cleanup Interval: %d seconds", config.CleanupInterval)
-		log.Printf("  Mode: Will only clean up configured managed domains")
+		log.Println(tr("config.cleanup"))
+		log.Println(tr("config.stale", config.StaleThreshold))
+		log.Println(tr("config.interval", config.CleanupInterval))
+		log.Println(tr("config.cleanup_mode"))
 	}
 
-	// Validate that all BEES_IP_UPDATE_* env vars were consumed
+	config.DomainTTLs = parseDomainTTLs(config)
+	config.DomainProxied = parseDomainProxied(config)
+	warnCriticalDomains(config)
+	if len(config.Services) > 0 && config.CombinedDomain == "" {
+		log.Printf("WARNING: services are published under %sCOMBINED_DOMAIN, which is not set - skipping %d service(s)", envPrefix, len(config.Services))
+	}
+	warnLinkLocalRanges(config)
+
+	// Validate that all BEES_IP_UPDATE_* env vars and config file keys were consumed
 	validateUnusedEnvVars()
+	validateUnusedConfigKeys()
 
 	return config
 }
@@ -574,28 +1417,57 @@ func heartbeatRecordName(domain string) string {
 	return domain
 }
 
-// heartbeatContent creates the TXT record content with current timestamp
-// Format: "timestamp" (quoted string)
-func heartbeatContent() string {
-	timestamp := time.Now().Unix()
+// hostHeartbeatDomain returns the domain carrying the host's heartbeat (and changelog):
+// TOP_LEVEL_DOMAIN if set, otherwise COMBINED_DOMAIN, otherwise the first available domain
+func hostHeartbeatDomain(config *Config) string {
+	for _, domain := range []string{config.TopLevelDomain, config.CombinedDomain, config.InternalDomain, config.ExternalDomain, config.IPv6Domain} {
+		if domain != "" {
+			return domain
+		}
+	}
+	return ""
+}
+
+// heartbeatContent creates the TXT record content with the timestamp of now
+// Format: "timestamp" (quoted string), see heartbeatformat.go for the others
+func heartbeatContent(now time.Time) string {
+	timestamp := now.Unix()
 	// TXT records should be quoted strings - just the timestamp
 	return fmt.Sprintf("\"%d\"", timestamp)
 }
 
-// getEnv gets an environment variable with the BEES_IP_UPDATE_ prefix and tracks consumption
+// parseHeartbeat reads the timestamp of heartbeat TXT content in any format, quoted or not
+// and possibly split into several strings. Other TXT records at managed names (verification
+// tokens, SPF, content from other tools) are not heartbeats and report false.
+func parseHeartbeat(content string) (int64, bool) {
+	payload, ok := decodeHeartbeat(content)
+	return payload.Timestamp, ok
+}
+
+// getEnv gets an environment variable with the BEES_IP_UPDATE_ prefix and tracks consumption.
+// Falls back to the config file when the variable is not set.
 func getEnv(key string) string {
+	if secretSettings[key] {
+		if value, ok := secretFromFile(key); ok {
+			return value
+		}
+	}
 	fullKey := envPrefix + key
 	value := os.Getenv(fullKey)
 	if value != "" {
 		consumedEnvVars[fullKey] = true
+		return value
 	}
-	return value
+	if value := configFileValue(key); value != "" {
+		return value
+	}
+	return dockerModeDefault(key)
 }
 
 func getEnvOrExit(key string) string {
 	value := getEnv(key)
 	if value == "" {
-		log.Fatalf("Required environment variable %s%s not set", envPrefix, key)
+		configFatal(tr("config.required", envPrefix, key))
 	}
 	return value
 }
@@ -607,6 +1479,14 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvListOrDefault gets a comma-separated list, or defaultValue if unset
+func getEnvListOrDefault(key string, defaultValue []string) []string {
+	if items := splitList(getEnv(key)); len(items) > 0 {
+		return items
+	}
+	return defaultValue
+}
+
 func getEnvOrDefaultInt(key string, defaultValue int) int {
 	value := getEnv(key)
 	if value == "" {
@@ -614,7 +1494,7 @@ func getEnvOrDefaultInt(key string, defaultValue int) int {
 	}
 	intValue, err := strconv.Atoi(value)
 	if err != nil {
-		log.Printf("Invalid integer value for %s%s: %s, using default %d", envPrefix, key, value, defaultValue)
+		log.Println(tr("config.invalid_int", envPrefix, key, value, defaultValue))
 		return defaultValue
 	}
 	return intValue
@@ -644,7 +1524,7 @@ func validateUnusedEnvVars() {
 	}
 
 	if len(unusedVars) > 0 {
-		log.Printf("WARNING: Found %d unused environment variable(s) with %s prefix:", len(unusedVars), envPrefix)
+		log.Println(tr("unused.header", len(unusedVars), envPrefix))
 		for _, key := range unusedVars {
 			// Remove prefix for cleaner display
 			shortKey := strings.TrimPrefix(key, envPrefix)
@@ -653,12 +1533,12 @@ func validateUnusedEnvVars() {
 			// Provide helpful explanations for common issues
 			explanation := getUnusedVarExplanation(shortKey, value)
 			if explanation != "" {
-				log.Printf("  - %s (value: %q) - %s", key, value, explanation)
+				log.Println(tr("unused.entry", key, value, explanation))
 			} else {
-				log.Printf("  - %s (value: %q) - Unknown configuration variable", key, value)
+				log.Println(tr("unused.entry", key, value, tr("unused.unknown")))
 			}
 		}
-		log.Printf("These variables had no effect. Check for typos or see documentation for valid variable names.")
+		log.Println(tr("unused.footer"))
 	}
 }
 
@@ -672,45 +1552,43 @@ func getUnusedVarExplanation(key, value string) string {
 		rangeNum = strings.TrimSuffix(rangeNum, "_DOMAIN")
 		cidrKey := fmt.Sprintf("IPV4_RANGE_%s", rangeNum)
 		if getEnv(cidrKey) == "" {
-			return fmt.Sprintf("Missing companion variable %s%s (both CIDR and DOMAIN must be set)", envPrefix, cidrKey)
+			return tr("unused.missing_pair", envPrefix, cidrKey)
 		}
-		return "Paired CIDR variable exists but may have validation issues"
+		return tr("unused.cidr_paired")
 
 	case strings.HasPrefix(key, "IPV4_RANGE_"):
 		// Extract the number
 		rangeNum := strings.TrimPrefix(key, "IPV4_RANGE_")
 		domainKey := fmt.Sprintf("IPV4_RANGE_%s_DOMAIN", rangeNum)
 		if getEnv(domainKey) == "" {
-			return fmt.Sprintf("Missing companion variable %s%s (both CIDR and DOMAIN must be set)", envPrefix, domainKey)
+			return tr("unused.missing_pair", envPrefix, domainKey)
 		}
 		// Check if CIDR is valid
-		_, _, err := net.ParseCIDR(value)
-		if err != nil {
-			return fmt.Sprintf("Invalid CIDR notation: %v", err)
+		if _, err := parseRangePrefix(value, "A"); err != nil {
+			return tr("unused.invalid_cidr", err)
 		}
-		return "Paired DOMAIN variable exists but may have validation issues"
+		return tr("unused.domain_paired")
 
 	case strings.HasPrefix(key, "IPV6_RANGE_") && strings.HasSuffix(key, "_DOMAIN"):
 		rangeNum := strings.TrimPrefix(key, "IPV6_RANGE_")
 		rangeNum = strings.TrimSuffix(rangeNum, "_DOMAIN")
 		cidrKey := fmt.Sprintf("IPV6_RANGE_%s", rangeNum)
 		if getEnv(cidrKey) == "" {
-			return fmt.Sprintf("Missing companion variable %s%s (both CIDR and DOMAIN must be set)", envPrefix, cidrKey)
+			return tr("unused.missing_pair", envPrefix, cidrKey)
 		}
-		return "Paired CIDR variable exists but may have validation issues"
+		return tr("unused.cidr_paired")
 
 	case strings.HasPrefix(key, "IPV6_RANGE_"):
 		rangeNum := strings.TrimPrefix(key, "IPV6_RANGE_")
 		domainKey := fmt.Sprintf("IPV6_RANGE_%s_DOMAIN", rangeNum)
 		if getEnv(domainKey) == "" {
-			return fmt.Sprintf("Missing companion variable %s%s (both CIDR and DOMAIN must be set)", envPrefix, domainKey)
+			return tr("unused.missing_pair", envPrefix, domainKey)
 		}
 		// Check if CIDR is valid
-		_, _, err := net.ParseCIDR(value)
-		if err != nil {
-			return fmt.Sprintf("Invalid CIDR notation: %v", err)
+		if _, err := parseRangePrefix(value, "AAAA"); err != nil {
+			return tr("unused.invalid_cidr", err)
 		}
-		return "Paired DOMAIN variable exists but may have validation issues"
+		return tr("unused.domain_paired")
 	}
 
 	return ""
@@ -725,6 +1603,8 @@ func parseCustomRanges(prefix string, recordType string, maxRanges int) []Custom
 	for i := 1; i <= maxRanges; i++ {
 		cidrKey := fmt.Sprintf("%s_%d", prefix, i)
 		domainKey := fmt.Sprintf("%s_%d_DOMAIN", prefix, i)
+		ttlKey := fmt.Sprintf("%s_%d_TTL", prefix, i)
+		proxiedKey := fmt.Sprintf("%s_%d_PROXIED", prefix, i)
 
 		cidr := getEnv(cidrKey)
 		domain := getEnv(domainKey)
@@ -735,26 +1615,29 @@ func parseCustomRanges(prefix string, recordType string, maxRanges int) []Custom
 		}
 
 		if cidr == "" {
-			log.Printf("WARNING: %s%s is set but %s%s is not - skipping", envPrefix, domainKey, envPrefix, cidrKey)
+			log.Println(tr("range.missing_pair", envPrefix, domainKey, envPrefix, cidrKey))
 			continue
 		}
 
 		if domain == "" {
-			log.Printf("WARNING: %s%s is set but %s%s is not - skipping", envPrefix, cidrKey, envPrefix, domainKey)
+			log.Println(tr("range.missing_pair", envPrefix, cidrKey, envPrefix, domainKey))
 			continue
 		}
 
-		// Validate CIDR notation
-		_, _, err := net.ParseCIDR(cidr)
+		// Validate CIDR notation (and that it matches the record type)
+		prefix, err := parseRangePrefix(cidr, recordType)
 		if err != nil {
-			log.Printf("WARNING: Invalid CIDR notation in %s%s: %s (%v) - skipping", envPrefix, cidrKey, cidr, err)
+			log.Println(tr("range.invalid_cidr", envPrefix, cidrKey, cidr, err))
 			continue
 		}
 
 		ranges = append(ranges, CustomIPRange{
-			CIDR:   cidr,
-			Domain: domain,
-			Type:   recordType,
+			CIDR:    cidr,
+			Prefix:  prefix,
+			Domain:  domain,
+			Type:    recordType,
+			TTL:     parseOptionalTTL(ttlKey),
+			Proxied: parseProxiedOverride(proxiedKey),
 		})
 	}
 
@@ -762,84 +1645,55 @@ func parseCustomRanges(prefix string, recordType string, maxRanges int) []Custom
 }
 
 func detectIPs(config *Config) *IPAddresses {
+	// Track per-source health so flaky echo services are tried last
+	sources := loadSourceTracker(config)
+
+	// All detectors read the same interface scan, without addresses unfit to publish
+	addrs := publishableAddrs(config.interfaceSnapshot().Addrs, config)
+	if config.DADProbe {
+		addrs = probeAddrs(addrs, config.dadProber(), config.dadProbeTimeout())
+	}
+
 	ips := &IPAddresses{
-		InternalIPv4:   getInternalIPv4(),
-		ExternalIPv4:   getExternalIPv4(),
-		ExternalIPv6:   getExternalIPv6(),
+		InternalIPv4:   getInternalIPv4(addrs),
+		ExternalIPv4:   getExternalIPv4(config.IPv4Sources, sources, addrs),
+		ExternalIPv6:   getExternalIPv6(config.IPv6Sources, sources, addrs),
 		CustomRangeIPs: make(map[string][]string),
 	}
+	saveSourceTracker(config, sources)
 
-	// Detect IPs for custom IPv4 ranges
-	for _, customRange := range config.CustomIPv4Ranges {
-		detectedIPs := getIPsInRange(customRange.CIDR, customRange.Domain)
-		if len(detectedIPs) > 0 {
-			ips.CustomRangeIPs[customRange.Domain] = detectedIPs
+	// Detect IPs for custom IPv4 and IPv6 ranges in one pass over the addresses
+	matcher := config.customRangeMatcher()
+	for i, detectedIPs := range matcher.match(addrs) {
+		customRange := matcher.ranges[i]
+		if len(detectedIPs) == 0 {
+			log.Printf("No IPs found in range %s (for domain %s)", customRange.CIDR, customRange.Domain)
+			continue
 		}
+		log.Printf("Found %d IP(s) in range %s (for domain %s)", len(detectedIPs), customRange.CIDR, customRange.Domain)
+		ips.CustomRangeIPs[customRange.Domain] = detectedIPs
 	}
-
-	// Detect IPs for custom IPv6 ranges
-	for _, customRange := range config.CustomIPv6Ranges {
-		detectedIPs := getIPsInRange(customRange.CIDR, customRange.Domain)
-		if len(detectedIPs) > 0 {
-			ips.CustomRangeIPs[customRange.Domain] = detectedIPs
-		}
+	if config.RecordInterfaceComments {
+		recordInterfaces(ips, addrs)
 	}
 
 	return ips
 }
 
-func getInternalIPv4() []string {
-	// Parse RFC1918 ranges
-	var privateNets []*net.IPNet
-	for _, cidr := range rfc1918Ranges {
-		_, ipNet, _ := net.ParseCIDR(cidr)
-		privateNets = append(privateNets, ipNet)
-	}
-
-	// Get all network interfaces
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		log.Printf("Error getting network interfaces: %v", err)
-		return []string{}
-	}
-
-	var internalIPs []string
-	seen := make(map[string]bool)
+// getInternalIPv4 returns the interface addresses in the RFC1918 ranges
+func getInternalIPv4(addrs []interfaceAddr) []string {
+	internalIPs := []string{}
+	seen := make(addrSet)
 
-	// Check each interface for RFC1918 addresses
-	for _, iface := range interfaces {
-		addrs, err := iface.Addrs()
-		if err != nil {
+	for _, a := range addrs {
+		// Mapped forms were normalized to IPv4, so Contains sees them too
+		if !containsAddr(rfc1918Prefixes, a.Addr) || seen[a.Addr] {
 			continue
 		}
-
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-
-			// Check if it's IPv4 and in RFC1918 range
-			if ip == nil || ip.To4() == nil {
-				continue
-			}
-
-			for _, privateNet := range privateNets {
-				if privateNet.Contains(ip) {
-					ipStr := ip.String()
-					// Avoid duplicates
-					if !seen[ipStr] {
-						seen[ipStr] = true
-						internalIPs = append(internalIPs, ipStr)
-						log.Printf("Found internal IPv4: %s on interface %s", ipStr, iface.Name)
-					}
-				}
-			}
-		}
-	}
+		seen[a.Addr] = true
+		internalIPs = append(internalIPs, a.Addr.String())
+		log.Printf("Found internal IPv4: %s on interface %s", a.Addr, a.Interface)
+	}
 
 	if len(internalIPs) == 0 {
 		log.Println("No internal IPv4 addresses found")
@@ -850,161 +1704,99 @@ func getInternalIPv4() []string {
 	return internalIPs
 }
 
-// getIPsInRange detects IPs on network interfaces that fall within the specified CIDR range
-// Supports both IPv4 and IPv6 ranges
-func getIPsInRange(cidr string, domain string) []string {
-	// Parse the CIDR
-	_, ipNet, err := net.ParseCIDR(cidr)
-	if err != nil {
-		log.Printf("Error parsing CIDR %s: %v", cidr, err)
-		return []string{}
-	}
-
-	// Get all network interfaces
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		log.Printf("Error getting network interfaces: %v", err)
-		return []string{}
-	}
-
-	var foundIPs []string
-	seen := make(map[string]bool)
-
-	// Check each interface for matching addresses
-	for _, iface := range interfaces {
-		addrs, err := iface.Addrs()
-		if err != nil {
-			continue
-		}
-
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-
-			if ip == nil {
-				continue
-			}
-
-			// Check if IP is in the specified range
-			if ipNet.Contains(ip) {
-				ipStr := ip.String()
-				// Avoid duplicates
-				if !seen[ipStr] {
-					seen[ipStr] = true
-					foundIPs = append(foundIPs, ipStr)
-					log.Printf("Found IP in range %s: %s on interface %s (for domain %s)", cidr, ipStr, iface.Name, domain)
-				}
-			}
-		}
-	}
-
-	if len(foundIPs) == 0 {
-		log.Printf("No IPs found in range %s (for domain %s)", cidr, domain)
-	} else {
-		log.Printf("Found %d IP(s) in range %s (for domain %s)", len(foundIPs), cidr, domain)
-	}
-
-	return foundIPs
+// Default echo services for external IP detection (overridable via IPV4_SOURCES / IPV6_SOURCES)
+var defaultIPv4Sources = []string{
+	"https://api.ipify.org",
+	"https://api4.ipify.org",
+	"https://icanhazip.com",
+	"https://ifconfig.me/ip",
 }
 
-func getExternalIPv4() string {
-	// Use multiple services for redundancy
-	services := []string{
-		"https://api.ipify.org",
-		"https://api4.ipify.org",
-		"https://icanhazip.com",
-		"https://ifconfig.me/ip",
-	}
+var defaultIPv6Sources = []string{
+	"https://api6.ipify.org",
+	"https://icanhazip.com",
+	"https://ifconfig.me/ip",
+}
 
-	client := &http.Client{
+// newFamilyHTTPClient returns an HTTP client that only dials over the given network ("tcp4" or "tcp6")
+func newFamilyHTTPClient(network string) *http.Client {
+	return &http.Client{
 		Timeout: 10 * time.Second,
 		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				// Force IPv4
-				return (&net.Dialer{}).DialContext(ctx, "tcp4", addr)
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
 			},
 		},
 	}
+}
 
-	for _, service := range services {
-		resp, err := client.Get(service)
-		if err != nil {
-			continue
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			continue
-		}
+// queryEchoService fetches the caller's IP address from an echo service.
+// The response format (plain text by default) is selected by the source's URL fragment.
+func queryEchoService(client *http.Client, service string) (string, error) {
+	url, format, err := parseEchoSource(service)
+	if err != nil {
+		return "", err
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			continue
-		}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
 
-		ipStr := strings.TrimSpace(string(body))
-		// Validate it's an IPv4 address
-		ip := net.ParseIP(ipStr)
-		if ip != nil && ip.To4() != nil {
-			log.Printf("Found external IPv4: %s", ipStr)
-			return ipStr
-		}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
 	}
 
-	log.Println("Error detecting external IPv4")
-	return ""
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEchoResponseBytes))
+	if err != nil {
+		return "", err
+	}
+	value, err := format.Extract(resp, body)
+	if err != nil {
+		return "", err
+	}
+	return clientAddress(value), nil
 }
 
-func getExternalIPv6() string {
-	// Use multiple services for redundancy
-	services := []string{
-		"https://api6.ipify.org",
-		"https://icanhazip.com",
-		"https://ifconfig.me/ip",
+func getExternalIPv4(sources []string, health *SourceTracker, addrs []interfaceAddr) string {
+	if len(sources) == 0 {
+		return "" // ECHO_SERVICES=false without a local source for this family
 	}
+	// Force IPv4
+	client := newFamilyHTTPClient("tcp4")
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				// Force IPv6
-				return (&net.Dialer{}).DialContext(ctx, "tcp6", addr)
-			},
-		},
-	}
+	// querySource validates it's an IPv4 address (mapped forms are accepted and unmapped)
+	ipStr := detectFromSources("ipv4", sources, health, func(service string) (string, error) {
+		return querySource(client, service, "A", addrs)
+	})
 
-	for _, service := range services {
-		resp, err := client.Get(service)
-		if err != nil {
-			continue
-		}
-		defer resp.Body.Close()
+	if ipStr == "" {
+		log.Println("Error detecting external IPv4")
+		return ""
+	}
+	log.Printf("Found external IPv4: %s", ipStr)
+	return ipStr
+}
 
-		if resp.StatusCode != http.StatusOK {
-			continue
-		}
+func getExternalIPv6(sources []string, health *SourceTracker, addrs []interfaceAddr) string {
+	if len(sources) == 0 {
+		return "" // ECHO_SERVICES=false without a local source for this family
+	}
+	// Force IPv6
+	client := newFamilyHTTPClient("tcp6")
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			continue
-		}
+	// querySource validates it's an IPv6 address
+	ipStr := detectFromSources("ipv6", sources, health, func(service string) (string, error) {
+		return querySource(client, service, "AAAA", addrs)
+	})
 
-		ipStr := strings.TrimSpace(string(body))
-		// Validate it's an IPv6 address
-		ip := net.ParseIP(ipStr)
-		if ip != nil && ip.To4() == nil && ip.To16() != nil {
-			log.Printf("Found external IPv6: %s", ipStr)
-			return ipStr
-		}
+	if ipStr == "" {
+		log.Println("Error detecting external IPv6")
+		return ""
 	}
-
-	log.Println("Error detecting external IPv6")
-	return ""
+	log.Printf("Found external IPv6: %s", ipStr)
+	return ipStr
 }
 
 // DNSRecord represents a generic DNS record (provider-agnostic)
@@ -1017,36 +1809,85 @@ type DNSRecord struct {
 
 // DNSProvider defines a generic interface for DNS operations
 // This allows supporting multiple providers: CloudFlare, Route53, DigitalOcean, etc.
+// Lookups of absent records are not errors: GetRecordID returns "" and GetRecord nil.
+// Errors wrap errProviderUnreachable, errProviderAuth, errProviderRateLimited,
+// errRecordNotFound or errDuplicateRecord when they are caused by one of those.
 type DNSProvider interface {
-	GetRecordID(name, recordType string) string
-	GetRecord(name, recordType string) *DNSRecord
-	GetAllRecords(name, recordType string) []DNSRecord
-	CreateRecord(name, recordType, content string, proxied bool) bool
-	UpdateRecord(recordID, name, recordType, content string, proxied bool) bool
-	DeleteRecord(recordID, name, recordType string) bool
-	DeleteRecordIfExists(name, recordType string) bool
-	UpsertRecord(name, recordType, content string, proxied bool) bool
-	EnsureRecordExists(name, recordType, content string, proxied bool) bool
+	GetRecordID(name, recordType string) (string, error)
+	GetRecord(name, recordType string) (*DNSRecord, error)
+	GetAllRecords(name, recordType string) ([]DNSRecord, error)
+	CreateRecord(name, recordType, content string, proxied bool) error
+	UpdateRecord(recordID, name, recordType, content string, proxied bool) error
+	DeleteRecord(recordID, name, recordType string) error
+	DeleteRecordIfExists(name, recordType string) error
+	UpsertRecord(name, recordType, content string, proxied bool) error
+	EnsureRecordExists(name, recordType, content string, proxied bool) error
 }
 
 // CloudFlareAPI defines the interface for CloudFlare DNS operations (deprecated, use DNSProvider)
 type CloudFlareAPI interface {
-	getRecordID(name, recordType string) string
-	getRecord(name, recordType string) *CFRecord
-	getAllRecords(name, recordType string) []CFRecord
-	createRecord(name, recordType, content string, proxied bool) bool
-	updateRecord(recordID, name, recordType, content string, proxied bool) bool
-	deleteRecord(recordID, name, recordType string) bool
-	deleteRecordIfExists(name, recordType string) bool
-	upsertRecord(name, recordType, content string, proxied bool) bool
-	ensureRecordExists(name, recordType, content string, proxied bool) bool
+	getRecordID(name, recordType string) (string, error)
+	getRecord(name, recordType string) (*CFRecord, error)
+	getAllRecords(name, recordType string) ([]CFRecord, error)
+	createRecord(name, recordType, content string, proxied bool) error
+	updateRecord(recordID, name, recordType, content string, proxied bool) error
+	deleteRecord(recordID, name, recordType string) error
+	deleteRecordIfExists(name, recordType string) error
+	upsertRecord(name, recordType, content string, proxied bool) error
+	ensureRecordExists(name, recordType, content string, proxied bool) error
 }
 
 // CloudFlareClient implements both DNSProvider and CloudFlareAPI
 type CloudFlareClient struct {
-	APIToken string
-	ZoneID   string
-	BaseURL  string
+	APIToken     string
+	ZoneID       string
+	Zones        []CFZone       // Zones records are routed to by name (optional; all go to ZoneID otherwise)
+	discovery    *zoneDiscovery // Looks up the zones of names outside Zones (nil = no discovery)
+	vault        *vaultSecret   // Reads APIToken from Vault, refreshed on lease expiry (optional)
+	BaseURL      string
+	Tags         []string       // "name:value" tags attached to created/updated records (optional)
+	TTL          int            // TTL for created/updated records (0 uses defaultRecordTTL)
+	HeartbeatTTL int            // TTL for heartbeat records (0 uses defaultHeartbeatTTL)
+	NameTTLs     map[string]int // Per-name TTL overrides of TTL (optional)
+	Limiter      *rateLimiter   // Spaces out API requests (nil = unlimited)
+	Retry        *retryPolicy   // Retries transient API failures (nil = no retries)
+	Faults       int            // Percentage of API attempts failed on purpose (FAULT_INJECTION_PERCENT)
+	client       *http.Client   // Sends API requests through the middleware stack (nil = built per request)
+
+	Comments      map[string]string // Comments of the records publishing each address (optional)
+	VerifyDeletes *deleteVerifier   // Looks up deleted records again (nil = trust the API)
+
+	recordHooks
+}
+
+// recordTTL returns the TTL to publish records at name with
+func (cf *CloudFlareClient) recordTTL(name string) int {
+	if ttl, ok := cf.NameTTLs[normalizeName(name)]; ok {
+		return ttl
+	}
+	if cf.TTL == 0 {
+		return defaultRecordTTL
+	}
+	return cf.TTL
+}
+
+// upsertHeartbeat creates or updates a heartbeat TXT record with the heartbeat TTL
+func (cf *CloudFlareClient) upsertHeartbeat(name, content string) error {
+	heartbeat := *cf
+	heartbeat.TTL = defaultHeartbeatTTL
+	heartbeat.NameTTLs = nil // Heartbeats share the name of a domain, not its TTL
+	if cf.HeartbeatTTL != 0 {
+		heartbeat.TTL = cf.HeartbeatTTL
+	}
+	return heartbeat.upsertRecord(name, "TXT", content, false)
+}
+
+// withTTL returns a copy of the client publishing every record with ttl seconds
+func (cf *CloudFlareClient) withTTL(ttl int) CloudFlareAPI {
+	published := *cf
+	published.TTL = ttl
+	published.NameTTLs = nil
+	return &published
 }
 
 // Verify CloudFlareClient implements both interfaces
@@ -1092,268 +1933,420 @@ func formatErrors(errors []json.RawMessage) string {
 	return strings.Join(errorStrings, ", ")
 }
 
-func (cf *CloudFlareClient) makeRequest(method, path string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(method, cf.BaseURL+path, body)
-	if err != nil {
+// decodeListResponse decodes a CloudFlare list response; records are only returned
+// when the API reported success
+func decodeListResponse(body io.Reader) ([]CFRecord, error) {
+	var records []CFRecord
+	if _, err := decodeListPage(body, func(record *CFRecord) { records = append(records, *record) }); err != nil {
 		return nil, err
 	}
+	return records, nil
+}
 
-	authHeader := "Bearer " + cf.APIToken
-	req.Header.Set("Authorization", authHeader)
-	req.Header.Set("Content-Type", "application/json")
+// decodeListPage stream-decodes one page of a CloudFlare list response, passing each record
+// to each as it is read instead of holding the page as a second copy. The record is reused
+// for the next one, so each must copy what it keeps. CloudFlare may send "success" after
+// "result": on an error, the records already passed must be discarded.
+func decodeListPage(body io.Reader, each func(record *CFRecord)) (CFResultInfo, error) {
+	var info CFResultInfo
+	var success bool
+	var errs []json.RawMessage
+	dec := json.NewDecoder(body)
+	decodingError := func(err error) (CFResultInfo, error) {
+		return info, fmt.Errorf("decoding response: %w", err)
+	}
 
-	// Debug: Log request details (without full token)
-	log.Printf("API Request: %s %s (token length: %d, auth header length: %d)",
-		method, path, len(cf.APIToken), len(authHeader))
+	if err := expectJSONDelim(dec, '{'); err != nil {
+		return decodingError(err)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return decodingError(err)
+		}
+		switch tok {
+		case "result":
+			err = decodeRecordArray(dec, each)
+		case "result_info":
+			err = dec.Decode(&info)
+		case "success":
+			err = dec.Decode(&success)
+		case "errors":
+			err = dec.Decode(&errs)
+		default:
+			var skipped json.RawMessage
+			err = dec.Decode(&skipped)
+		}
+		if err != nil {
+			return decodingError(err)
+		}
+	}
+	if err := expectJSONDelim(dec, '}'); err != nil {
+		return decodingError(err)
+	}
+	if !success {
+		return info, fmt.Errorf("request failed: %s", formatErrors(errs))
+	}
+	return info, nil
+}
 
-	// Use a client with timeout instead of context
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// decodeRecordArray decodes the "result" array of a list response one record at a time
+func decodeRecordArray(dec *json.Decoder, each func(record *CFRecord)) error {
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return err // null: no records
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("result is %v, not a list of records", tok)
+	}
+	var record CFRecord
+	for dec.More() {
+		record = CFRecord{} // Decoding merges into the previous record otherwise
+		if err := dec.Decode(&record); err != nil {
+			return err
+		}
+		each(&record)
 	}
+	return expectJSONDelim(dec, ']')
+}
 
-	resp, err := client.Do(req)
+// expectJSONDelim reads the next token, which must be the delimiter
+func expectJSONDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	// Log response status for debugging
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("API Response: %s (status: %d %s)", path, resp.StatusCode, resp.Status)
+	if tok != delim {
+		return fmt.Errorf("expected %q, got %v", delim, tok)
 	}
-
-	return resp, nil
+	return nil
 }
 
-func (cf *CloudFlareClient) getRecordID(name, recordType string) string {
-	path := fmt.Sprintf("/zones/%s/dns_records?name=%s&type=%s", cf.ZoneID, name, recordType)
+// hasErrorCode reports whether a CloudFlare error list contains the given code
+func hasErrorCode(errors []json.RawMessage, code int) bool {
+	for _, errRaw := range errors {
+		var cfErr CFError
+		if err := json.Unmarshal(errRaw, &cfErr); err == nil && cfErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
 
-	resp, err := cf.makeRequest("GET", path, nil)
+func (cf *CloudFlareClient) makeRequest(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, cf.BaseURL+path, body)
 	if err != nil {
-		log.Printf("Error getting record ID for %s: %v", name, err)
-		return ""
+		return nil, err
 	}
-	defer resp.Body.Close()
+	req.Header.Set("Content-Type", "application/json")
+	return cf.httpClient().Do(req)
+}
 
-	var result CFListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("Error decoding response: %v", err)
-		return ""
+// transportStack describes the middleware stack of the API requests (see transport.go)
+func (cf *CloudFlareClient) transportStack() *providerStack {
+	return &providerStack{
+		name:    providerCloudFlare,
+		auth:    cf.authenticate,
+		limiter: cf.Limiter,
+		observe: cf.observeRateLimit,
+		retry:   cf.Retry,
+		timeout: providerRequestTimeout,
+		faults:  cf.Faults,
 	}
+}
 
-	if result.Success && len(result.Result) > 0 {
-		return result.Result[0].ID
+// httpClient returns the client sending API requests: the one built with the client, or a
+// new one for a client assembled without it
+func (cf *CloudFlareClient) httpClient() *http.Client {
+	if cf.client != nil {
+		return cf.client
 	}
-
-	return ""
+	return cf.transportStack().client()
 }
 
-// getRecord returns the full record details, or nil if not found
-func (cf *CloudFlareClient) getRecord(name, recordType string) *CFRecord {
-	path := fmt.Sprintf("/zones/%s/dns_records?name=%s&type=%s", cf.ZoneID, name, recordType)
+// authenticate sets the API token of a request, read from Vault when it is kept there
+func (cf *CloudFlareClient) authenticate(req *http.Request) {
+	apiToken := cf.APIToken
+	if cf.vault != nil {
+		apiToken = cf.vault.token()
+	}
+	req.Header.Set("Authorization", "Bearer "+apiToken)
+}
 
-	resp, err := cf.makeRequest("GET", path, nil)
+// listRecords fetches the records of every page of a list request (the path must carry
+// a query string)
+func (cf *CloudFlareClient) listRecords(path string) ([]CFRecord, error) {
+	var records []CFRecord
+	err := cf.eachListedRecord(path, func(record *CFRecord, total int) {
+		if total > cap(records) {
+			records = slices.Grow(records, total-len(records)) // Once the first page told the size
+		}
+		records = append(records, *record)
+	})
 	if err != nil {
-		log.Printf("Error getting record for %s: %v", name, err)
-		return nil
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return records, nil
+}
 
-	var result CFListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("Error decoding response: %v", err)
-		return nil
+// eachListedRecord passes the records of every page of a list request to each without
+// keeping them, with the total reported by the previous page (0 on the first). The record
+// is reused for the next one. On an error, the records already passed must be discarded.
+func (cf *CloudFlareClient) eachListedRecord(path string, each func(record *CFRecord, total int)) error {
+	total := 0
+	for page := 1; page <= cfMaxListPages; page++ {
+		count := 0
+		info, err := cf.listPage(fmt.Sprintf("%s&page=%d&per_page=%d", path, page, cfListPageSize), func(record *CFRecord) {
+			count++
+			record.Content = record.serviceContent()
+			each(record, total)
+		})
+		if err != nil {
+			return err
+		}
+		total = info.TotalCount
+		// Stop at the last page; an empty page ends the list even if total_pages says
+		// otherwise (records deleted while paging)
+		if page >= info.TotalPages || count == 0 {
+			return nil
+		}
 	}
+	return fmt.Errorf("list has more than %d pages", cfMaxListPages)
+}
 
-	if result.Success && len(result.Result) > 0 {
-		return &result.Result[0]
+// eachRecordByType passes every record of the type in the zone to each, for callers that
+// keep only some of a large zone (see eachListedRecord)
+func (cf *CloudFlareClient) eachRecordByType(recordType string, each func(record *CFRecord)) error {
+	for _, zoneID := range cf.zoneIDs() {
+		path := fmt.Sprintf("/zones/%s/dns_records?type=%s", zoneID, recordType)
+		err := cf.eachListedRecord(path, func(record *CFRecord, _ int) { each(record) })
+		if err != nil {
+			return fmt.Errorf("getting all %s records: %w", recordType, err)
+		}
 	}
-
 	return nil
 }
 
-// getAllRecords returns all records matching the name and type
-func (cf *CloudFlareClient) getAllRecords(name, recordType string) []CFRecord {
-	path := fmt.Sprintf("/zones/%s/dns_records?name=%s&type=%s", cf.ZoneID, name, recordType)
-
+// listPage fetches one page of a list request, passing its records to each
+func (cf *CloudFlareClient) listPage(path string, each func(record *CFRecord)) (CFResultInfo, error) {
 	resp, err := cf.makeRequest("GET", path, nil)
 	if err != nil {
-		log.Printf("Error getting records for %s: %v", name, err)
-		return []CFRecord{}
+		return CFResultInfo{}, fmt.Errorf("%w: %v", errProviderUnreachable, err)
 	}
 	defer resp.Body.Close()
 
-	var result CFListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("Error decoding response: %v", err)
-		return []CFRecord{}
-	}
-
-	if result.Success {
-		return result.Result
+	info, err := decodeListPage(resp.Body, each)
+	if err != nil && resp.StatusCode != http.StatusOK {
+		return info, statusError(resp.StatusCode, err.Error())
 	}
+	return info, err
+}
 
-	return []CFRecord{}
+// cfResponseError returns the error of an unsuccessful response, typed by CloudFlare's
+// error codes or else by the HTTP status
+func cfResponseError(status int, errs []json.RawMessage) error {
+	switch {
+	case hasErrorCode(errs, 81057) || hasErrorCode(errs, 81058):
+		return fmt.Errorf("%w: %s", errDuplicateRecord, formatErrors(errs))
+	case hasErrorCode(errs, 81044):
+		return fmt.Errorf("%w: %s", errRecordNotFound, formatErrors(errs))
+	case hasErrorCode(errs, 10000):
+		return fmt.Errorf("%w: %s", errProviderAuth, formatErrors(errs))
+	case status != http.StatusOK:
+		return statusError(status, formatErrors(errs))
+	}
+	return fmt.Errorf("request failed: %s", formatErrors(errs))
 }
 
-// getAllRecordsByType returns all records in the zone matching the type (no name filter)
-func (cf *CloudFlareClient) getAllRecordsByType(recordType string) []CFRecord {
-	path := fmt.Sprintf("/zones/%s/dns_records?type=%s&per_page=1000", cf.ZoneID, recordType)
+// requestBuffers keeps request body buffers for reuse, so that a run changing many records
+// does not allocate a body per request
+var requestBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// maxPooledRequestBuffer is the largest buffer kept for reuse
+const maxPooledRequestBuffer = 64 << 10
+
+// change sends a create, update or delete request (body may be nil)
+func (cf *CloudFlareClient) change(method, path string, body interface{}) error {
+	var data io.Reader
+	if body != nil {
+		buf := requestBuffers.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer func() {
+			if buf.Cap() <= maxPooledRequestBuffer {
+				requestBuffers.Put(buf)
+			}
+		}()
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return fmt.Errorf("marshaling request: %w", err)
+		}
+		data = buf
+	}
 
-	resp, err := cf.makeRequest("GET", path, nil)
+	resp, err := cf.makeRequest(method, path, data)
 	if err != nil {
-		log.Printf("Error getting all %s records: %v", recordType, err)
-		return []CFRecord{}
+		return fmt.Errorf("%w: %v", errProviderUnreachable, err)
 	}
 	defer resp.Body.Close()
 
-	var result CFListResponse
+	var result CFSingleResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("Error decoding response: %v", err)
-		return []CFRecord{}
+		if resp.StatusCode != http.StatusOK {
+			return statusError(resp.StatusCode, "")
+		}
+		return fmt.Errorf("decoding response: %w", err)
 	}
-
-	if result.Success {
-		return result.Result
+	if !result.Success {
+		return cfResponseError(resp.StatusCode, result.Errors)
 	}
-
-	return []CFRecord{}
+	return nil
 }
 
-func (cf *CloudFlareClient) createRecord(name, recordType, content string, proxied bool) bool {
-	path := fmt.Sprintf("/zones/%s/dns_records", cf.ZoneID)
-
-	reqBody := CFCreateUpdateRequest{
-		Type:    recordType,
-		Name:    name,
-		Content: content,
-		TTL:     120, // 2 minutes for dynamic DNS
-		Proxied: proxied,
+func (cf *CloudFlareClient) getRecordID(name, recordType string) (string, error) {
+	record, err := cf.getRecord(name, recordType)
+	if record == nil {
+		return "", err
 	}
+	return record.ID, nil
+}
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		log.Printf("Error marshaling request: %v", err)
-		return false
+// getRecord returns the full record details, or nil if not found
+func (cf *CloudFlareClient) getRecord(name, recordType string) (*CFRecord, error) {
+	records, err := cf.getAllRecords(name, recordType)
+	if err != nil || len(records) == 0 {
+		return nil, err
 	}
+	return &records[0], nil
+}
 
-	resp, err := cf.makeRequest("POST", path, strings.NewReader(string(jsonData)))
+// getAllRecords returns all records matching the name and type
+func (cf *CloudFlareClient) getAllRecords(name, recordType string) ([]CFRecord, error) {
+	path := fmt.Sprintf("/zones/%s/dns_records?name=%s&type=%s", cf.zoneFor(name), name, recordType)
+	records, err := cf.listRecords(path)
 	if err != nil {
-		log.Printf("Error creating record for %s: %v", name, err)
-		return false
-	}
-	defer resp.Body.Close()
-
-	var result CFSingleResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("Error decoding response: %v", err)
-		return false
-	}
-
-	if result.Success {
-		log.Printf("Created %s record for %s -> %s", recordType, name, content)
-		return true
+		return nil, fmt.Errorf("getting %s records for %s: %w", recordType, name, err)
 	}
+	return records, nil
+}
 
-	// Check if record already exists (error code 81058)
-	for _, errRaw := range result.Errors {
-		var cfErr CFError
-		if err := json.Unmarshal(errRaw, &cfErr); err == nil {
-			if cfErr.Code == 81058 {
-				// Record already exists - try to get its ID and update instead
-				log.Printf("Record already exists for %s, attempting update...", name)
-				recordID := cf.getRecordID(name, recordType)
-				if recordID != "" {
-					return cf.updateRecord(recordID, name, recordType, content, proxied)
-				}
-				log.Printf("Failed to get record ID for existing record: %s", name)
-				return false
-			}
+// getAllRecordsByType returns all records in the zones matching the type (no name filter)
+func (cf *CloudFlareClient) getAllRecordsByType(recordType string) ([]CFRecord, error) {
+	var records []CFRecord
+	for _, zoneID := range cf.zoneIDs() {
+		zoneRecords, err := cf.listRecords(fmt.Sprintf("/zones/%s/dns_records?type=%s", zoneID, recordType))
+		if err != nil {
+			return nil, fmt.Errorf("getting all %s records: %w", recordType, err)
 		}
+		records = append(records, zoneRecords...)
 	}
-
-	log.Printf("Failed to create record: %s", formatErrors(result.Errors))
-	return false
+	return records, nil
 }
 
-func (cf *CloudFlareClient) updateRecord(recordID, name, recordType, content string, proxied bool) bool {
-	path := fmt.Sprintf("/zones/%s/dns_records/%s", cf.ZoneID, recordID)
-
-	reqBody := CFCreateUpdateRequest{
+// recordRequest returns the body creating or updating a record. SRV and HTTPS records are
+// sent as structured data and are never proxied.
+func (cf *CloudFlareClient) recordRequest(name, recordType, content string, proxied bool) CFCreateUpdateRequest {
+	request := CFCreateUpdateRequest{
 		Type:    recordType,
 		Name:    name,
 		Content: content,
-		TTL:     120,
+		TTL:     cf.recordTTL(name),
 		Proxied: proxied,
+		Tags:    cf.Tags,
+		Comment: cf.recordComment(content),
 	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		log.Printf("Error marshaling request: %v", err)
-		return false
+	if data := cfServiceData(recordType, content); data != nil {
+		request.Content, request.Data, request.Proxied = "", data, false
 	}
+	return request
+}
 
-	resp, err := cf.makeRequest("PUT", path, strings.NewReader(string(jsonData)))
-	if err != nil {
-		log.Printf("Error updating record for %s: %v", name, err)
-		return false
-	}
-	defer resp.Body.Close()
+func (cf *CloudFlareClient) createRecord(name, recordType, content string, proxied bool) error {
+	path := fmt.Sprintf("/zones/%s/dns_records", cf.zoneFor(name))
 
-	var result CFSingleResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("Error decoding response: %v", err)
-		return false
-	}
+	reqBody := cf.recordRequest(name, recordType, content, proxied)
 
-	if result.Success {
-		log.Printf("Updated %s record for %s -> %s", recordType, name, content)
-		return true
+	err := cf.change("POST", path, reqBody)
+	switch {
+	case err == nil:
+		logRecordChange("create", recordType, name, content)
+		cf.notifyChange("create", recordType, name, content)
+		return nil
+	case errors.Is(err, errProviderUnreachable):
+		cf.notifyUnreachable("create", recordType, name, content, "")
+	case errors.Is(err, errDuplicateRecord):
+		// Record already exists - try to get its ID and update instead
+		log.Printf("Record already exists for %s, attempting update...", name)
+		recordID, lookupErr := cf.getRecordID(name, recordType)
+		if recordID != "" {
+			return cf.updateRecord(recordID, name, recordType, content, proxied)
+		}
+		if lookupErr != nil {
+			return lookupErr
+		}
 	}
-
-	log.Printf("Failed to update record: %s", formatErrors(result.Errors))
-	return false
+	return fmt.Errorf("creating %s record for %s: %w", recordType, name, err)
 }
 
-func (cf *CloudFlareClient) deleteRecord(recordID, name, recordType string) bool {
-	path := fmt.Sprintf("/zones/%s/dns_records/%s", cf.ZoneID, recordID)
+func (cf *CloudFlareClient) updateRecord(recordID, name, recordType, content string, proxied bool) error {
+	path := fmt.Sprintf("/zones/%s/dns_records/%s", cf.zoneFor(name), recordID)
 
-	resp, err := cf.makeRequest("DELETE", path, nil)
-	if err != nil {
-		log.Printf("Error deleting record for %s: %v", name, err)
-		return false
-	}
-	defer resp.Body.Close()
+	reqBody := cf.recordRequest(name, recordType, content, proxied)
 
-	var result CFSingleResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("Error decoding response: %v", err)
-		return false
+	if err := cf.change("PUT", path, reqBody); err != nil {
+		if errors.Is(err, errProviderUnreachable) {
+			cf.notifyUnreachable("update", recordType, name, content, recordID)
+		}
+		return fmt.Errorf("updating %s record for %s: %w", recordType, name, err)
 	}
+	logRecordChange("update", recordType, name, content)
+	cf.notifyChange("update", recordType, name, content)
+	return nil
+}
 
-	if result.Success {
-		log.Printf("Deleted %s record for %s", recordType, name)
-		return true
-	}
+func (cf *CloudFlareClient) deleteRecord(recordID, name, recordType string) error {
+	path := fmt.Sprintf("/zones/%s/dns_records/%s", cf.zoneFor(name), recordID)
 
-	log.Printf("Failed to delete record: %s", formatErrors(result.Errors))
-	return false
+	if err := cf.change("DELETE", path, nil); err != nil {
+		if errors.Is(err, errProviderUnreachable) {
+			cf.notifyUnreachable("delete", recordType, name, "", recordID)
+		}
+		return fmt.Errorf("deleting %s record for %s: %w", recordType, name, err)
+	}
+	logRecordChange("delete", recordType, name, "")
+	cf.verifyDeleted(recordID, name, recordType)
+	cf.notifyChange("delete", recordType, name, "")
+	return nil
 }
 
-func (cf *CloudFlareClient) deleteRecordIfExists(name, recordType string) bool {
-	recordID := cf.getRecordID(name, recordType)
+func (cf *CloudFlareClient) deleteRecordIfExists(name, recordType string) error {
+	recordID, err := cf.getRecordID(name, recordType)
 	if recordID != "" {
 		return cf.deleteRecord(recordID, name, recordType)
 	}
-	return true
+	return err
 }
 
-func (cf *CloudFlareClient) upsertRecord(name, recordType, content string, proxied bool) bool {
-	record := cf.getRecord(name, recordType)
+func (cf *CloudFlareClient) upsertRecord(name, recordType, content string, proxied bool) error {
+	record, err := cf.getRecord(name, recordType)
+	if err != nil {
+		return cf.lookupFailed(err, recordType, name, content)
+	}
 	if record != nil {
-		// Record exists - check if content has changed
-		if record.Content == content {
+		// Record exists - check if content, TTL or proxying has changed
+		if sameContent(record.Content, content) {
+			if record.Proxied != proxied {
+				log.Printf("Proxied changed for %s record %s: %t -> %t", recordType, name, record.Proxied, proxied)
+				return cf.updateRecord(record.ID, name, recordType, content, proxied)
+			}
+			if ttl := cf.recordTTL(name); ttlDiffers(record.TTL, ttl, proxied) {
+				log.Printf("TTL changed for %s record %s: %d -> %d", recordType, name, record.TTL, ttl)
+				return cf.updateRecord(record.ID, name, recordType, content, proxied)
+			}
+			if comment := cf.recordComment(content); commentChanged(record.Comment, comment) {
+				log.Printf("Comment changed for %s record %s: %q -> %q", recordType, name, record.Comment, comment)
+				return cf.updateRecord(record.ID, name, recordType, content, proxied)
+			}
 			log.Printf("No change needed for %s record %s (already %s)", recordType, name, content)
-			return true
+			return nil
 		}
 		log.Printf("Content changed for %s record %s: %s -> %s", recordType, name, record.Content, content)
 		return cf.updateRecord(record.ID, name, recordType, content, proxied)
@@ -1363,14 +2356,29 @@ func (cf *CloudFlareClient) upsertRecord(name, recordType, content string, proxi
 
 // ensureRecordExists creates a record only if one with this exact content doesn't already exist.
 // This is used for domains with multiple records of the same type (e.g., multiple A records).
-func (cf *CloudFlareClient) ensureRecordExists(name, recordType, content string, proxied bool) bool {
-	allRecords := cf.getAllRecords(name, recordType)
+func (cf *CloudFlareClient) ensureRecordExists(name, recordType, content string, proxied bool) error {
+	allRecords, err := cf.getAllRecords(name, recordType)
+	if err != nil {
+		return cf.lookupFailed(err, recordType, name, content)
+	}
 
 	// Check if a record with this specific content already exists
 	for _, record := range allRecords {
-		if record.Content == content {
+		if sameContent(record.Content, content) {
+			if record.Proxied != proxied {
+				log.Printf("Proxied changed for %s record %s (%s): %t -> %t", recordType, name, content, record.Proxied, proxied)
+				return cf.updateRecord(record.ID, name, recordType, content, proxied)
+			}
+			if ttl := cf.recordTTL(name); ttlDiffers(record.TTL, ttl, proxied) {
+				log.Printf("TTL changed for %s record %s (%s): %d -> %d", recordType, name, content, record.TTL, ttl)
+				return cf.updateRecord(record.ID, name, recordType, content, proxied)
+			}
+			if comment := cf.recordComment(content); commentChanged(record.Comment, comment) {
+				log.Printf("Comment changed for %s record %s (%s): %q -> %q", recordType, name, content, record.Comment, comment)
+				return cf.updateRecord(record.ID, name, recordType, content, proxied)
+			}
 			log.Printf("No change needed for %s record %s (already %s)", recordType, name, content)
-			return true
+			return nil
 		}
 	}
 
@@ -1378,84 +2386,90 @@ func (cf *CloudFlareClient) ensureRecordExists(name, recordType, content string,
 	return cf.createRecord(name, recordType, content, proxied)
 }
 
-
 // DNSProvider interface implementation (capitalized wrapper methods)
 
-func (cf *CloudFlareClient) GetRecordID(name, recordType string) string {
+func (cf *CloudFlareClient) GetRecordID(name, recordType string) (string, error) {
 	return cf.getRecordID(name, recordType)
 }
 
-func (cf *CloudFlareClient) GetRecord(name, recordType string) *DNSRecord {
-	return cfRecordToDNSRecord(cf.getRecord(name, recordType))
+func (cf *CloudFlareClient) GetRecord(name, recordType string) (*DNSRecord, error) {
+	record, err := cf.getRecord(name, recordType)
+	return cfRecordToDNSRecord(record), err
 }
 
-func (cf *CloudFlareClient) GetAllRecords(name, recordType string) []DNSRecord {
-	return cfRecordsToDNSRecords(cf.getAllRecords(name, recordType))
+func (cf *CloudFlareClient) GetAllRecords(name, recordType string) ([]DNSRecord, error) {
+	records, err := cf.getAllRecords(name, recordType)
+	return cfRecordsToDNSRecords(records), err
 }
 
-func (cf *CloudFlareClient) CreateRecord(name, recordType, content string, proxied bool) bool {
+func (cf *CloudFlareClient) CreateRecord(name, recordType, content string, proxied bool) error {
 	return cf.createRecord(name, recordType, content, proxied)
 }
 
-func (cf *CloudFlareClient) UpdateRecord(recordID, name, recordType, content string, proxied bool) bool {
+func (cf *CloudFlareClient) UpdateRecord(recordID, name, recordType, content string, proxied bool) error {
 	return cf.updateRecord(recordID, name, recordType, content, proxied)
 }
 
-func (cf *CloudFlareClient) DeleteRecord(recordID, name, recordType string) bool {
+func (cf *CloudFlareClient) DeleteRecord(recordID, name, recordType string) error {
 	return cf.deleteRecord(recordID, name, recordType)
 }
 
-func (cf *CloudFlareClient) DeleteRecordIfExists(name, recordType string) bool {
+func (cf *CloudFlareClient) DeleteRecordIfExists(name, recordType string) error {
 	return cf.deleteRecordIfExists(name, recordType)
 }
 
-func (cf *CloudFlareClient) UpsertRecord(name, recordType, content string, proxied bool) bool {
+func (cf *CloudFlareClient) UpsertRecord(name, recordType, content string, proxied bool) error {
 	return cf.upsertRecord(name, recordType, content, proxied)
 }
 
-func (cf *CloudFlareClient) EnsureRecordExists(name, recordType, content string, proxied bool) bool {
+func (cf *CloudFlareClient) EnsureRecordExists(name, recordType, content string, proxied bool) error {
 	return cf.ensureRecordExists(name, recordType, content, proxied)
 }
 
 // Cleanup service functions
 
-func runCleanupService(cf *CloudFlareClient, config *Config) {
-	log.Println("Starting DNS Cleanup Service")
+func runCleanupService(cf providerClient, config *Config, reload configReloader) {
+	log.Println(tr("cleanup.start"))
 
 	// Run cleanup immediately on startup
 	runCleanup(cf, config)
 
-	// Then run periodically
+	// Then run periodically, reloading the configuration on SIGHUP
 	ticker := time.NewTicker(time.Duration(config.CleanupInterval) * time.Second)
 	defer ticker.Stop()
+	hangups := reloadSignal()
 
-	log.Printf("Cleanup service running. Will check every %d seconds for records older than %d seconds",
-		config.CleanupInterval, config.StaleThreshold)
+	log.Println(tr("cleanup.running", config.CleanupInterval, config.StaleThreshold))
 
-	for range ticker.C {
-		runCleanup(cf, config)
+	for {
+		select {
+		case <-ticker.C:
+			runCleanup(cf, config)
+		case <-hangups:
+			next, nextCF, ok := applyReload(reload, config)
+			if !ok {
+				continue
+			}
+			cf, config = nextCF, next
+			ticker.Reset(time.Duration(config.CleanupInterval) * time.Second)
+		}
 	}
 }
 
-func runCleanup(cf *CloudFlareClient, config *Config) {
-	log.Println("Running cleanup cycle...")
+func runCleanup(cf providerClient, config *Config) {
+	log.Println(tr("cleanup.cycle"))
+	managedDomains := cleanupManagedDomains(config)
+	cleanupStaleDomains(cf, config, func(name string) bool { return managedDomains[name] })
+}
 
-	// Build list of managed domains (only clean up domains we're responsible for)
+// cleanupManagedDomains returns the domains the cleanup may touch (only the domains we're
+// responsible for), exiting if none are configured
+func cleanupManagedDomains(config *Config) map[string]bool {
 	managedDomains := make(map[string]bool)
-	if config.InternalDomain != "" {
-		managedDomains[config.InternalDomain] = true
-	}
-	if config.ExternalDomain != "" {
-		managedDomains[config.ExternalDomain] = true
-	}
-	if config.IPv6Domain != "" {
-		managedDomains[config.IPv6Domain] = true
-	}
-	if config.CombinedDomain != "" {
-		managedDomains[config.CombinedDomain] = true
-	}
-	if config.TopLevelDomain != "" {
-		managedDomains[config.TopLevelDomain] = true
+	for _, domain := range []string{config.InternalDomain, config.ExternalDomain, config.IPv6Domain, config.CombinedDomain, config.TopLevelDomain} {
+		if domain != "" {
+			managedDomains[normalizeName(domain)] = true
+		}
 	}
 
 	if len(managedDomains) == 0 {
@@ -1463,85 +2477,127 @@ func runCleanup(cf *CloudFlareClient, config *Config) {
 	}
 
 	log.Printf("Cleanup will only affect these managed domains: %v", getMapKeys(managedDomains))
+	return managedDomains
+}
+
+// cleanupDeletion is a record the cleanup deletes because its name's heartbeat is stale
+type cleanupDeletion struct {
+	Domain string
+	Reason string // Why the domain is stale
+	Record CFRecord
+	Label  string // "A record", "TXT heartbeat", ...
+}
+
+// cleanupStaleDomains deletes the records of every managed name whose heartbeat is stale
+func cleanupStaleDomains(cf providerClient, config *Config, managed func(name string) bool) {
+	deletions, staleCount, err := planCleanup(cf, config, managed)
+	if err != nil {
+		log.Printf("Error: %v - skipping this cleanup run", err)
+		return
+	}
+	if staleCount == 0 {
+		log.Println(tr("cleanup.none_stale"))
+		log.Println(tr("cleanup.done_zero"))
+		return
+	}
+
+	log.Println(tr("cleanup.found", staleCount))
+	deleted := executeCleanup(cf, deletions)
+	log.Println(tr("cleanup.done", len(deleted), staleCount))
+	if len(deleted) > 0 {
+		notifyEvent(config, notifyEventCleanupDeleted, fmt.Sprintf("Cleanup deleted %d record(s)", len(deleted)), formatCleanupDeletions(deleted))
+	}
+}
 
+// planCleanup finds the managed names whose heartbeat is stale and lists their records,
+// without deleting anything. It returns the records to delete and the number of stale names.
+func planCleanup(cf providerClient, config *Config, managed func(name string) bool) ([]cleanupDeletion, int, error) {
 	// Get all TXT records in the zone (potential heartbeats)
-	txtRecords := cf.getAllRecordsByType("TXT")
+	txtRecords, err := cf.getAllRecordsByType("TXT")
+	if err != nil {
+		return nil, 0, err
+	}
 	log.Printf("Found %d TXT records in zone", len(txtRecords))
 
-	totalDeleted := 0
 	staleDomains := make(map[string]string) // domain -> reason
+	now := config.now().Unix()
 
 	// Check each TXT record to see if it's a heartbeat and if it's stale
 	for _, txtRecord := range txtRecords {
 		// SAFETY CHECK: Only consider domains we manage
-		if !managedDomains[txtRecord.Name] {
+		name := normalizeName(txtRecord.Name)
+		if !managed(name) {
 			continue
 		}
 
-		content := strings.Trim(txtRecord.Content, "\"")
-
-		// Try to parse as a timestamp (heartbeat format)
-		timestamp, err := strconv.ParseInt(content, 10, 64)
-		if err != nil {
+		timestamp, ok := parseHeartbeat(txtRecord.Content)
+		if !ok {
 			// Not a heartbeat (not a valid timestamp)
 			continue
 		}
 
 		// Check if heartbeat is stale
-		age := time.Now().Unix() - timestamp
+		age := now - timestamp
 		if age > int64(config.StaleThreshold) {
-			staleDomains[txtRecord.Name] = fmt.Sprintf("stale heartbeat (age: %ds)", age)
+			staleDomains[name] = fmt.Sprintf("stale heartbeat (age: %ds)", age)
 		}
 	}
 
-	if len(staleDomains) == 0 {
-		log.Println("No stale domains found")
-		log.Printf("Cleanup cycle complete. Total deleted: 0")
-		return
+	// List all records of the stale domains: addresses, aliases, the heartbeat and the
+	// changelog (if the host maintained one)
+	domains := make([]string, 0, len(staleDomains))
+	for domain := range staleDomains {
+		domains = append(domains, domain)
 	}
+	sort.Strings(domains)
 
-	log.Printf("Found %d stale domain(s) to clean up", len(staleDomains))
-
-	// Delete all records for stale domains
-	for domain, reason := range staleDomains {
-		log.Printf("Cleaning up stale domain: %s (%s)", domain, reason)
+	// The service records go with the host's heartbeat
+	type recordKind struct{ name, recordType, label string }
+	var services []recordKind
+	for _, set := range serviceRecordSets(config) {
+		services = append(services, recordKind{set.Name, set.Type, set.Type + " service record"})
+	}
+	hostDomain := normalizeName(hostHeartbeatDomain(config))
 
-		// Delete A records
-		aRecords := cf.getAllRecords(domain, "A")
-		for _, record := range aRecords {
-			if cf.deleteRecord(record.ID, record.Name, "A") {
-				totalDeleted++
-				log.Printf("  Deleted A record: %s -> %s", record.Name, record.Content)
-			}
+	var deletions []cleanupDeletion
+	for _, domain := range domains {
+		kinds := []recordKind{
+			{domain, "A", "A record"},
+			{domain, "AAAA", "AAAA record"},
+			{domain, "CNAME", "CNAME record"},
+			{domain, "TXT", "TXT heartbeat"},
+			{changelogRecordName(domain), "TXT", "TXT changelog"},
 		}
-
-		// Delete AAAA records
-		aaaaRecords := cf.getAllRecords(domain, "AAAA")
-		for _, record := range aaaaRecords {
-			if cf.deleteRecord(record.ID, record.Name, "AAAA") {
-				totalDeleted++
-				log.Printf("  Deleted AAAA record: %s -> %s", record.Name, record.Content)
-			}
+		if domain == hostDomain {
+			kinds = append(kinds, services...)
 		}
-
-		// Delete CNAME records
-		cnameRecords := cf.getAllRecords(domain, "CNAME")
-		for _, record := range cnameRecords {
-			if cf.deleteRecord(record.ID, record.Name, "CNAME") {
-				totalDeleted++
-				log.Printf("  Deleted CNAME record: %s -> %s", record.Name, record.Content)
+		for _, kind := range kinds {
+			records, err := cf.getAllRecords(kind.name, kind.recordType)
+			succeeded(err)
+			for _, record := range records {
+				record.Type = kind.recordType
+				deletions = append(deletions, cleanupDeletion{Domain: domain, Reason: staleDomains[domain], Record: record, Label: kind.label})
 			}
 		}
+	}
+	return deletions, len(staleDomains), nil
+}
 
-		// Delete TXT heartbeat record
-		txtRecords := cf.getAllRecords(domain, "TXT")
-		for _, record := range txtRecords {
-			if cf.deleteRecord(record.ID, record.Name, "TXT") {
-				totalDeleted++
-				log.Printf("  Deleted TXT heartbeat: %s", record.Name)
+// executeCleanup deletes the planned records and returns the ones deleted
+func executeCleanup(cf providerClient, deletions []cleanupDeletion) []cleanupDeletion {
+	var deleted []cleanupDeletion
+	for i, d := range deletions {
+		if i == 0 || deletions[i-1].Domain != d.Domain {
+			log.Printf("Cleaning up stale domain: %s (%s)", d.Domain, d.Reason)
+		}
+		if succeeded(cf.deleteRecord(d.Record.ID, d.Record.Name, d.Record.Type)) {
+			deleted = append(deleted, d)
+			if d.Record.Type == "TXT" {
+				log.Printf("  Deleted %s: %s", d.Label, d.Record.Name)
+			} else {
+				log.Printf("  Deleted %s: %s -> %s", d.Label, d.Record.Name, d.Record.Content)
 			}
 		}
 	}
-
-	log.Printf("Cleanup cycle complete. Total deleted: %d records from %d domain(s)", totalDeleted, len(staleDomains))
+	return deleted
 }
@@ -2,7 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestCFListResponse verifies that CloudFlare's list response (GET requests) unmarshals correctly
@@ -252,3 +256,127 @@ func TestCFErrorCode81058(t *testing.T) {
 		t.Errorf("Expected message 'An identical record already exists.', got %s", cfErr.Message)
 	}
 }
+
+// TestParseHeartbeat verifies heartbeat timestamps are read from every TXT spelling and
+// that other TXT content at a managed name is not mistaken for one
+func TestParseHeartbeat(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int64
+		ok      bool
+	}{
+		{`"1699564820"`, 1699564820, true},
+		{`1699564820`, 1699564820, true},
+		{`"1699" "564820"`, 1699564820, true}, // Split into several strings
+		{` "1699564820" `, 1699564820, true},
+		{`"-1699564820"`, 0, false}, // Would always look stale
+		{`"+1699564820"`, 0, false},
+		{`"0"`, 0, false},
+		{`"1699564820 "`, 0, false},
+		{`"v=spf1 -all"`, 0, false},
+		{`"google-site-verification=abc"`, 0, false},
+		{`"99999999999999999999"`, 0, false},
+		{`""`, 0, false},
+		{``, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseHeartbeat(tt.content)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parseHeartbeat(%q) = %d, %v, want %d, %v", tt.content, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// FuzzParseHeartbeat feeds arbitrary TXT content to the heartbeat parser: the cleanup
+// service reads every TXT record at managed names, including ones it did not write
+func FuzzParseHeartbeat(f *testing.F) {
+	for _, seed := range []string{`"1699564820"`, `1699564820`, `"1699" "564820"`, `"a\"b"`, `"`, `"-1"`, `"v=spf1 -all"`, "\"\\", "\"\xff\""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, content string) {
+		timestamp, ok := parseHeartbeat(content)
+		if !ok {
+			return
+		}
+		if timestamp <= 0 {
+			t.Fatalf("parseHeartbeat(%q) accepted %d", content, timestamp)
+		}
+		// Whatever the spelling, the digits are the timestamp the updater would write
+		if got, ok := parseHeartbeat(heartbeatContent(time.Unix(timestamp, 0))); !ok || got != timestamp {
+			t.Fatalf("round trip of %d = %d, %v", timestamp, got, ok)
+		}
+		digits := strings.TrimLeft(strings.Join(parseTXTStrings(content), ""), "0")
+		if digits != strconv.FormatInt(timestamp, 10) {
+			t.Fatalf("parseHeartbeat(%q) = %d, but the strings are %q", content, timestamp, digits)
+		}
+	})
+}
+
+// TestDecodeListResponse verifies records are only returned from successful responses
+func TestDecodeListResponse(t *testing.T) {
+	records, err := decodeListResponse(strings.NewReader(`{"success":true,"errors":[],"result":[{"id":"1","type":"TXT","name":"a.example.com","content":"\"1\""}]}`))
+	if err != nil || len(records) != 1 || records[0].ID != "1" {
+		t.Errorf("success: records = %+v, err = %v", records, err)
+	}
+	records, err = decodeListResponse(strings.NewReader(`{"success":false,"errors":[{"code":10000,"message":"Authentication error"}],"result":[{"id":"1"}]}`))
+	if err == nil || !strings.Contains(err.Error(), "Authentication error") || records != nil {
+		t.Errorf("failure: records = %+v, err = %v", records, err)
+	}
+	if _, err := decodeListResponse(strings.NewReader(`<html>502 Bad Gateway</html>`)); err == nil {
+		t.Error("accepted a non-JSON body")
+	}
+}
+
+// BenchmarkDecodeListPage decodes a full page of a thousand-record zone
+func BenchmarkDecodeListPage(b *testing.B) {
+	var page strings.Builder
+	page.WriteString(`{"success":true,"errors":[],"messages":[],"result":[`)
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			page.WriteString(",")
+		}
+		fmt.Fprintf(&page, `{"id":"%032d","zone_id":"z","zone_name":"example.com","type":"A","name":"host%d.example.com","content":"192.0.2.%d","proxiable":true,"proxied":false,"ttl":1,"meta":{"auto_added":false},"comment":null,"tags":[],"created_on":"2024-01-01T00:00:00Z","modified_on":"2024-01-01T00:00:00Z"}`, i, i, i%256)
+	}
+	page.WriteString(`],"result_info":{"page":1,"per_page":1000,"count":1000,"total_count":1000,"total_pages":1}}`)
+	body := page.String()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		count := 0
+		if _, err := decodeListPage(strings.NewReader(body), func(*CFRecord) { count++ }); err != nil || count != 1000 {
+			b.Fatalf("decoded %d records, err = %v", count, err)
+		}
+	}
+}
+
+// FuzzCFResponses decodes arbitrary bodies as list and single responses, as a proxy,
+// captive portal or API change might return them
+func FuzzCFResponses(f *testing.F) {
+	for _, seed := range []string{
+		`{"success":true,"errors":[],"result":[{"id":"1","type":"A","name":"a.example.com","content":"192.0.2.1"}]}`,
+		`{"success":true,"errors":[],"result":{"id":"1","type":"A","name":"a.example.com","content":"192.0.2.1"}}`,
+		`{"success":false,"errors":[{"code":81058,"message":"An identical record already exists."}],"result":null}`,
+		`{"success":false,"errors":["text",1,null,{"code":"81058"}]}`,
+		`{"success":true,"result":null}`,
+		`<html>502 Bad Gateway</html>`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		records, err := decodeListResponse(strings.NewReader(string(body)))
+		if err != nil && records != nil {
+			t.Fatalf("records %+v returned with error %v", records, err)
+		}
+
+		// Failed writes are logged and checked for the duplicate record code
+		var single CFSingleResponse
+		if json.Unmarshal(body, &single) == nil && !single.Success {
+			if formatErrors(single.Errors) == "" {
+				t.Fatalf("empty error description for %s", body)
+			}
+			hasErrorCode(single.Errors, 81058)
+		}
+	})
+}
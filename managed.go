@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// The list-managed subcommand prints the (name, type) pairs this configuration claims
+// ownership of, so external audits and the cleanup service's allowlist can be generated
+// from the same configuration the updater runs with. It lists what an update may write
+// or delete, independent of what is currently detected or published.
+
+// ManagedRecord is a name and type owned by this configuration
+type ManagedRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"` // Domain class, as accepted by -only and -skip
+	Role  string `json:"role"`  // address, alias, service, heartbeat, changelog or dyndns2
+}
+
+// managedRecords returns the records an update manages, sorted by name and type
+func managedRecords(config *Config) []ManagedRecord {
+	var records []ManagedRecord
+	seen := make(map[[2]string]bool)
+	add := func(name, recordType, class, role string) {
+		key := [2]string{name, recordType}
+		if name == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		records = append(records, ManagedRecord{Name: name, Type: recordType, Class: class, Role: role})
+	}
+
+	// Domains with several addresses have their own heartbeat
+	add(config.InternalDomain, "A", "internal", "address")
+	add(heartbeatRecordName(config.InternalDomain), "TXT", "internal", "heartbeat")
+	for i, r := range config.CustomIPv4Ranges {
+		class := fmt.Sprintf("ipv4_range_%d", i+1)
+		add(r.Domain, "A", class, "address")
+		add(heartbeatRecordName(r.Domain), "TXT", class, "heartbeat")
+	}
+	for i, r := range config.CustomIPv6Ranges {
+		class := fmt.Sprintf("ipv6_range_%d", i+1)
+		add(r.Domain, "AAAA", class, "address")
+		add(heartbeatRecordName(r.Domain), "TXT", class, "heartbeat")
+	}
+	add(config.ExternalDomain, "A", "external", "address")
+	if config.TunnelTarget != "" {
+		add(config.ExternalDomain, "CNAME", "external", "alias")
+	}
+	add(config.IPv6Domain, "AAAA", "ipv6", "address")
+	add(config.CombinedDomain, "A", "combined", "address")
+	add(config.CombinedDomain, "AAAA", "combined", "address")
+	if config.CombinedDomain != "" {
+		add(config.TopLevelDomain, "CNAME", "toplevel", "alias")
+	}
+	for _, set := range serviceRecordSets(config) {
+		add(set.Name, set.Type, "combined", "service")
+	}
+
+	// The host heartbeat and changelog
+	if domain := hostHeartbeatDomain(config); domain != "" {
+		class := ""
+		for _, c := range domainClasses(config) {
+			if c[1] == domain {
+				class = c[0]
+				break
+			}
+		}
+		add(heartbeatRecordName(domain), "TXT", class, "heartbeat")
+		if config.Changelog {
+			add(changelogRecordName(domain), "TXT", class, "changelog")
+		}
+	}
+
+	for _, hostname := range config.DynDNS2Hostnames {
+		add(hostname, "A", "dyndns2_hostnames", "dyndns2")
+		add(hostname, "AAAA", "dyndns2_hostnames", "dyndns2")
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Type < records[j].Type
+	})
+	return records
+}
+
+// runListManaged implements the list-managed subcommand
+func runListManaged(args []string) int {
+	fs := flag.NewFlagSet("list-managed", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config := readConfig(false, false)
+	records := managedRecords(config)
+	if records == nil {
+		records = []ManagedRecord{} // Encode as [] rather than null
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(records); err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding managed records: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestManagedRecords(t *testing.T) {
+	config := &Config{
+		InternalDomain:   "anubis.i.4.bees.wtf",
+		ExternalDomain:   "anubis.e.4.bees.wtf",
+		IPv6Domain:       "anubis.6.bees.wtf",
+		CombinedDomain:   "anubis.bees.wtf",
+		TopLevelDomain:   "bees.wtf",
+		CustomIPv4Ranges: []CustomIPRange{{CIDR: "10.0.0.0/8", Domain: "anubis.vpn.bees.wtf"}},
+		Changelog:        true,
+		DynDNS2Hostnames: []string{"anubis.ddns.net"},
+	}
+
+	want := []ManagedRecord{
+		{"_changelog.bees.wtf", "TXT", "toplevel", "changelog"},
+		{"anubis.6.bees.wtf", "AAAA", "ipv6", "address"},
+		{"anubis.bees.wtf", "A", "combined", "address"},
+		{"anubis.bees.wtf", "AAAA", "combined", "address"},
+		{"anubis.ddns.net", "A", "dyndns2_hostnames", "dyndns2"},
+		{"anubis.ddns.net", "AAAA", "dyndns2_hostnames", "dyndns2"},
+		{"anubis.e.4.bees.wtf", "A", "external", "address"},
+		{"anubis.i.4.bees.wtf", "A", "internal", "address"},
+		{"anubis.i.4.bees.wtf", "TXT", "internal", "heartbeat"},
+		{"anubis.vpn.bees.wtf", "A", "ipv4_range_1", "address"},
+		{"anubis.vpn.bees.wtf", "TXT", "ipv4_range_1", "heartbeat"},
+		{"bees.wtf", "CNAME", "toplevel", "alias"},
+		{"bees.wtf", "TXT", "toplevel", "heartbeat"},
+	}
+	if got := managedRecords(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("managedRecords =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestManagedRecordsSharedNames(t *testing.T) {
+	// Without a combined domain the top-level alias is not written, but the host heartbeat
+	// still goes to the top-level domain
+	config := &Config{InternalDomain: "nas.example.com", TopLevelDomain: "example.com"}
+	want := []ManagedRecord{
+		{"example.com", "TXT", "toplevel", "heartbeat"},
+		{"nas.example.com", "A", "internal", "address"},
+		{"nas.example.com", "TXT", "internal", "heartbeat"},
+	}
+	if got := managedRecords(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("managedRecords = %v, want %v", got, want)
+	}
+
+	// Domains sharing a name are listed once
+	config = &Config{InternalDomain: "nas.example.com", CombinedDomain: "nas.example.com"}
+	want = []ManagedRecord{
+		{"nas.example.com", "A", "internal", "address"},
+		{"nas.example.com", "AAAA", "combined", "address"},
+		{"nas.example.com", "TXT", "internal", "heartbeat"},
+	}
+	if got := managedRecords(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("managedRecords = %v, want %v", got, want)
+	}
+
+	if got := managedRecords(&Config{}); got != nil {
+		t.Errorf("managedRecords of an empty config = %v", got)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Per-address metric label modes (METRICS_ADDRESS_LABELS)
+const (
+	addressLabelsNone = "none" // Only per-domain address counts (default)
+	addressLabelsHash = "hash" // Address label is a short hash (stable, does not reveal the address)
+	addressLabelsFull = "full" // Address label is the address itself
+)
+
+// metricSample is one labelled value of a metric family
+type metricSample struct {
+	Labels [][2]string
+	Value  float64
+}
+
+// metricFamily is a Prometheus metric with its samples
+type metricFamily struct {
+	Name    string
+	Help    string
+	Type    string // "gauge" or "counter"
+	Samples []metricSample
+}
+
+// escapeLabelValue escapes a Prometheus label value
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+// formatPrometheus renders metric families in the Prometheus text exposition format
+func formatPrometheus(families []metricFamily) string {
+	var b strings.Builder
+	for _, f := range families {
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.Name, f.Help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			b.WriteString(f.Name)
+			if len(s.Labels) > 0 {
+				pairs := make([]string, len(s.Labels))
+				for i, l := range s.Labels {
+					pairs[i] = fmt.Sprintf(`%s="%s"`, l[0], escapeLabelValue(l[1]))
+				}
+				fmt.Fprintf(&b, "{%s}", strings.Join(pairs, ","))
+			}
+			fmt.Fprintf(&b, " %g\n", s.Value)
+		}
+	}
+	return b.String()
+}
+
+// addressLabel returns the label value identifying an address in the given mode
+func addressLabel(mode, address string) string {
+	if mode == addressLabelsHash {
+		sum := sha256.Sum256([]byte(address))
+		return hex.EncodeToString(sum[:4])
+	}
+	return address
+}
+
+// buildRunMetrics returns the metrics describing one update run.
+// Per-domain labels are always emitted (bounded by configuration). Per-address labels are
+// opt-in and capped at maxAddressLabels per domain and record type; addresses beyond the cap
+// are only reflected in the per-domain count, so a host publishing dozens of addresses
+// cannot create an unbounded number of series.
+func buildRunMetrics(config *Config, ips *IPAddresses, successCount, totalCount int, now time.Time) []metricFamily {
+	success := 0.0
+	if totalCount > 0 && successCount == totalCount {
+		success = 1
+	}
+
+	families := []metricFamily{
+		{Name: "dynipupdate_last_run_timestamp_seconds", Help: "Unix time of the last update run.", Type: "gauge",
+			Samples: []metricSample{{Value: float64(now.Unix())}}},
+		{Name: "dynipupdate_last_run_success", Help: "Whether every record operation of the last run succeeded (1) or not (0).", Type: "gauge",
+			Samples: []metricSample{{Value: success}}},
+		{Name: "dynipupdate_last_run_operations", Help: "Record operations attempted in the last run.", Type: "gauge",
+			Samples: []metricSample{{Value: float64(totalCount)}}},
+		{Name: "dynipupdate_last_run_operations_failed", Help: "Record operations that failed in the last run.", Type: "gauge",
+			Samples: []metricSample{{Value: float64(totalCount - successCount)}}},
+	}
+
+	desired := desiredAddresses(config, ips)
+	domains := make([]string, 0, len(desired))
+	for domain := range desired {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	counts := metricFamily{Name: "dynipupdate_published_addresses", Help: "Addresses published per domain and record type.", Type: "gauge"}
+	perAddress := metricFamily{Name: "dynipupdate_published_address", Help: "Published addresses (1 per address; capped per domain and record type).", Type: "gauge"}
+	truncated := metricFamily{Name: "dynipupdate_published_address_labels_dropped", Help: "Addresses not exported as labels because of the per-domain cap.", Type: "gauge"}
+
+	for _, domain := range domains {
+		types := make([]string, 0, len(desired[domain]))
+		for recordType := range desired[domain] {
+			types = append(types, recordType)
+		}
+		sort.Strings(types)
+
+		for _, recordType := range types {
+			addresses := append([]string(nil), desired[domain][recordType]...)
+			sort.Strings(addresses)
+			labels := [][2]string{{"domain", domain}, {"type", recordType}}
+			counts.Samples = append(counts.Samples, metricSample{Labels: labels, Value: float64(len(addresses))})
+
+			if config.MetricsAddressLabels == addressLabelsNone {
+				continue
+			}
+			exported := addresses
+			if len(exported) > config.MetricsMaxAddressLabels {
+				exported = exported[:config.MetricsMaxAddressLabels]
+				truncated.Samples = append(truncated.Samples, metricSample{Labels: labels, Value: float64(len(addresses) - len(exported))})
+			}
+			for _, address := range exported {
+				perAddress.Samples = append(perAddress.Samples, metricSample{
+					Labels: [][2]string{{"domain", domain}, {"type", recordType}, {"address", addressLabel(config.MetricsAddressLabels, address)}},
+					Value:  1,
+				})
+			}
+		}
+	}
+
+	families = append(families, counts)
+	if config.MetricsAddressLabels != addressLabelsNone {
+		families = append(families, perAddress, truncated)
+	}
+	families = append(families, sourceMetrics(config, loadSourceTracker(config))...)
+	families = append(families, rateLimitMetrics()...)
+	return append(families, providerRequestMetrics()...)
+}
+
+// sourceMetrics returns the health of the detection sources and the order they are tried in
+// (1 = first); sources without history have a rank only
+func sourceMetrics(config *Config, tracker *SourceTracker) []metricFamily {
+	success := metricFamily{Name: "dynipupdate_detection_source_success_rate", Help: "Moving average of the detection source's success (0..1).", Type: "gauge"}
+	latency := metricFamily{Name: "dynipupdate_detection_source_latency_seconds", Help: "Moving average of the detection source's response time.", Type: "gauge"}
+	rank := metricFamily{Name: "dynipupdate_detection_source_rank", Help: "Position the detection source is tried in (1 = first).", Type: "gauge"}
+	for _, family := range []struct {
+		name    string
+		sources []string
+	}{{"ipv4", config.IPv4Sources}, {"ipv6", config.IPv6Sources}} {
+		for i, source := range tracker.rank(family.name, family.sources) {
+			labels := [][2]string{{"family", family.name}, {"source", source}}
+			rank.Samples = append(rank.Samples, metricSample{Labels: labels, Value: float64(i + 1)})
+			if h := tracker.get(family.name, source); h != nil {
+				success.Samples = append(success.Samples, metricSample{Labels: labels, Value: h.SuccessRate})
+				latency.Samples = append(latency.Samples, metricSample{Labels: labels, Value: h.LatencyMs / 1000})
+			}
+		}
+	}
+	if len(rank.Samples) == 0 {
+		return nil
+	}
+	return []metricFamily{success, latency, rank}
+}
+
+// writeMetricsFile atomically writes metrics for the node_exporter textfile collector
+func writeMetricsFile(path, content string) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, ".metrics-*.prom")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeRunMetrics writes the run's metrics to METRICS_FILE (if configured)
+func writeRunMetrics(config *Config, ips *IPAddresses, successCount, totalCount int) {
+	if config.MetricsFile == "" || ips == nil {
+		return
+	}
+	families := buildRunMetrics(config, ips, successCount, totalCount, time.Now())
+	if err := writeMetricsFile(config.MetricsFile, formatPrometheus(families)); err != nil {
+		log.Printf("WARNING: Could not write metrics file: %v", err)
+	}
+}
+
+// parseMetricsAddressLabels validates METRICS_ADDRESS_LABELS
+func parseMetricsAddressLabels() string {
+	mode := strings.ToLower(getEnvOrDefault("METRICS_ADDRESS_LABELS", addressLabelsNone))
+	switch mode {
+	case addressLabelsNone, addressLabelsHash, addressLabelsFull:
+		return mode
+	}
+	log.Printf("WARNING: Invalid %sMETRICS_ADDRESS_LABELS=%q (use none, hash or full) - using none", envPrefix, mode)
+	return addressLabelsNone
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildRunMetricsCapsAddressLabels(t *testing.T) {
+	config := &Config{
+		InternalDomain:          "host.int.example.com",
+		ExternalDomain:          "host.example.com",
+		MetricsAddressLabels:    addressLabelsFull,
+		MetricsMaxAddressLabels: 2,
+	}
+	ips := &IPAddresses{
+		InternalIPv4: []string{"192.168.1.3", "192.168.1.1", "192.168.1.2"},
+		ExternalIPv4: "203.0.113.1",
+	}
+
+	out := formatPrometheus(buildRunMetrics(config, ips, 3, 4, time.Unix(1700000000, 0)))
+
+	for _, want := range []string{
+		`dynipupdate_last_run_timestamp_seconds 1.7e+09`,
+		`dynipupdate_last_run_success 0`,
+		`dynipupdate_last_run_operations_failed 1`,
+		`dynipupdate_published_addresses{domain="host.int.example.com",type="A"} 3`,
+		`dynipupdate_published_address{domain="host.int.example.com",type="A",address="192.168.1.1"} 1`,
+		`dynipupdate_published_address{domain="host.int.example.com",type="A",address="192.168.1.2"} 1`,
+		`dynipupdate_published_address_labels_dropped{domain="host.int.example.com",type="A"} 1`,
+		`dynipupdate_published_address{domain="host.example.com",type="A",address="203.0.113.1"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `address="192.168.1.3"`) {
+		t.Error("address beyond the cap was exported")
+	}
+}
+
+func TestBuildRunMetricsAddressLabelModes(t *testing.T) {
+	ips := &IPAddresses{ExternalIPv4: "203.0.113.1"}
+
+	config := &Config{ExternalDomain: "host.example.com", MetricsAddressLabels: addressLabelsNone, MetricsMaxAddressLabels: 10}
+	out := formatPrometheus(buildRunMetrics(config, ips, 1, 1, time.Now()))
+	if strings.Contains(out, "dynipupdate_published_address{") {
+		t.Errorf("per-address labels must be opt-in:\n%s", out)
+	}
+
+	config.MetricsAddressLabels = addressLabelsHash
+	out = formatPrometheus(buildRunMetrics(config, ips, 1, 1, time.Now()))
+	if strings.Contains(out, "203.0.113.1") {
+		t.Errorf("hash mode leaked the address:\n%s", out)
+	}
+	if !strings.Contains(out, `address="`+addressLabel(addressLabelsHash, "203.0.113.1")+`"`) {
+		t.Errorf("hash mode missing hashed label:\n%s", out)
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	if got := escapeLabelValue("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("escapeLabelValue = %q", got)
+	}
+}
+
+func TestSourceMetrics(t *testing.T) {
+	tracker := newSourceTracker(map[string]*SourceHealth{
+		"ipv4:https://slow.example": {Successes: 4, SuccessRate: 1, LatencyMs: 800},
+		"ipv4:https://fast.example": {Successes: 4, SuccessRate: 0.9, LatencyMs: 50},
+	})
+	config := &Config{IPv4Sources: []string{"https://slow.example", "https://fast.example", "https://new.example"}}
+
+	out := formatPrometheus(sourceMetrics(config, tracker))
+	for _, want := range []string{
+		`dynipupdate_detection_source_success_rate{family="ipv4",source="https://fast.example"} 0.9`,
+		`dynipupdate_detection_source_latency_seconds{family="ipv4",source="https://slow.example"} 0.8`,
+		`dynipupdate_detection_source_rank{family="ipv4",source="https://fast.example"} 1`,
+		`dynipupdate_detection_source_rank{family="ipv4",source="https://new.example"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `success_rate{family="ipv4",source="https://new.example"}`) {
+		t.Errorf("source without history has a success rate:\n%s", out)
+	}
+	if sourceMetrics(&Config{}, tracker) != nil {
+		t.Error("metrics without configured sources")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Multiple zones. CF_ZONE_ID takes a comma-separated list of zone IDs for hosts whose names
+// live in different zones (home.example.com and lan.example.net, say), so that one run
+// updates them all. The zone names are read from the API at startup; every record then goes
+// to the zone its name falls in (the most specific one when zones are nested), and listing
+// a record type lists every zone.
+
+// zoneFor returns the ID of the zone name belongs to: the zone with the longest matching
+// name, a discovered one, or the first zone when none matches
+func (cf *CloudFlareClient) zoneFor(name string) string {
+	name = normalizeName(name)
+	zoneID, matched := cf.ZoneID, 0
+	for _, zone := range cf.Zones {
+		if zoneContains(zone.Name, name) && len(zone.Name) > matched {
+			zoneID, matched = zone.ID, len(zone.Name)
+		}
+	}
+	if matched == 0 && cf.discovery != nil {
+		// A failed lookup leaves the first zone, whose API rejects the name
+		if zone, err := cf.discovery.find(cf, name); err != nil {
+			log.Printf("WARNING: Could not look up the zone of %s: %v", name, err)
+		} else if zone != nil {
+			return zone.ID
+		}
+	}
+	return zoneID
+}
+
+// zoneIDs returns the IDs of every zone the client manages records in
+func (cf *CloudFlareClient) zoneIDs() []string {
+	if len(cf.Zones) == 0 {
+		return []string{cf.ZoneID}
+	}
+	ids := make([]string, len(cf.Zones))
+	for i, zone := range cf.Zones {
+		ids[i] = zone.ID
+	}
+	return ids
+}
+
+// hasZone reports whether the client manages records in the zone
+func (cf *CloudFlareClient) hasZone(zoneID string) bool {
+	for _, id := range cf.zoneIDs() {
+		if id == zoneID {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneContains reports whether name is the zone apex or a name below it
+func zoneContains(zone, name string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// setupZones reads the details of every configured zone, adapting config to their setup
+// types, or discovers the zones when none is configured. With several zones the names are
+// needed for routing, so failing to read them is an error; a single zone is assumed to be
+// a full setup when its details cannot be read.
+func setupZones(cf *CloudFlareClient, config *Config) error {
+	switch {
+	case config.CFZoneID == "":
+		return discoverZones(cf, config)
+	case len(config.CFZoneIDs) <= 1:
+		return checkZoneSetup(cf, config)
+	}
+	for _, id := range config.CFZoneIDs {
+		zone, err := cf.forZone(id).getZone()
+		if err != nil {
+			return fmt.Errorf("reading the details of zone %s: %w", id, err)
+		}
+		if err := adaptToZoneSetup(config, zone); err != nil {
+			return err
+		}
+		cf.Zones = append(cf.Zones, CFZone{ID: id, Name: normalizeName(zone.Name), Type: zone.Type, Status: zone.Status})
+	}
+
+	names := make([]string, len(cf.Zones))
+	for i, zone := range cf.Zones {
+		names[i] = zone.Name
+	}
+	log.Printf("Routing records to %d zones: %s", len(cf.Zones), strings.Join(names, ", "))
+	for _, domain := range managedDomainList(config) {
+		if !cf.coversName(domain) {
+			log.Printf("WARNING: %s is in none of the zones of %sCF_ZONE_ID - its records go to %s, which will reject them", domain, envPrefix, cf.Zones[0].Name)
+		}
+	}
+	return nil
+}
+
+// coversName reports whether name falls in one of the client's zones
+func (cf *CloudFlareClient) coversName(name string) bool {
+	name = normalizeName(name)
+	for _, zone := range cf.Zones {
+		if zoneContains(zone.Name, name) {
+			return true
+		}
+	}
+	return false
+}
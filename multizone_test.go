@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTwoZoneServer serves the details and lookups of zones a (example.com) and
+// b (lan.example.net) and empty record lists, recording the requests it gets
+func newTwoZoneServer(t *testing.T) (*CloudFlareClient, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/zones":
+			zones := []CFZone{}
+			switch r.URL.Query().Get("name") {
+			case "example.com":
+				zones = append(zones, CFZone{ID: "a", Name: "example.com", Type: zoneTypeFull})
+			case "lan.example.net":
+				zones = append(zones, CFZone{ID: "b", Name: "lan.example.net", Type: zoneTypeFull})
+			}
+			json.NewEncoder(w).Encode(CFZoneListResponse{Success: true, Result: zones, ResultInfo: CFResultInfo{TotalPages: 1}})
+		case "/zones/a":
+			json.NewEncoder(w).Encode(CFZoneResponse{Success: true, Result: CFZone{ID: "a", Name: "example.com", Type: zoneTypeFull}})
+		case "/zones/b":
+			json.NewEncoder(w).Encode(CFZoneResponse{Success: true, Result: CFZone{ID: "b", Name: "lan.example.net", Type: zoneTypeFull}})
+		default:
+			if r.Method == "GET" {
+				json.NewEncoder(w).Encode(CFListResponse{Success: true, Result: []CFRecord{}, ResultInfo: CFResultInfo{TotalPages: 1}})
+			} else {
+				json.NewEncoder(w).Encode(CFSingleResponse{Success: true})
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	cf := &CloudFlareClient{APIToken: "test-token", ZoneID: "a", BaseURL: server.URL}
+	return cf, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		seen := requests
+		requests = nil
+		return seen
+	}
+}
+
+func TestMultipleZonesRouteByName(t *testing.T) {
+	cf, requests := newTwoZoneServer(t)
+	config := &Config{CFZoneID: "a", CFZoneIDs: []string{"a", "b"}, ExternalDomain: "home.example.com", InternalDomain: "host.lan.example.net"}
+	if err := setupZones(cf, config); err != nil {
+		t.Fatal(err)
+	}
+	requests()
+
+	if err := cf.createRecord("home.example.com", "A", "192.0.2.1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.updateRecord("rec1", "host.lan.example.net", "A", "10.0.0.1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.deleteRecord("rec2", "LAN.example.net.", "TXT"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"POST /zones/a/dns_records", "PUT /zones/b/dns_records/rec1", "DELETE /zones/b/dns_records/rec2"}
+	if got := requests(); !slices.Equal(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+
+	if _, err := cf.getAllRecordsByType("TXT"); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(requests(), " ")
+	if !strings.Contains(got, "/zones/a/dns_records") || !strings.Contains(got, "/zones/b/dns_records") {
+		t.Errorf("listing by type requested %s, want both zones", got)
+	}
+}
+
+func TestZoneForPrefersTheMostSpecificZone(t *testing.T) {
+	cf := &CloudFlareClient{ZoneID: "parent", Zones: []CFZone{{ID: "parent", Name: "example.com"}, {ID: "child", Name: "lan.example.com"}}}
+	for name, want := range map[string]string{
+		"example.com":          "parent",
+		"www.example.com":      "parent",
+		"lan.example.com":      "child",
+		"host.lan.example.com": "child",
+		"badexample.com":       "parent", // No match: the first zone
+		"notlan.example.com":   "parent",
+	} {
+		if got := cf.zoneFor(name); got != want {
+			t.Errorf("zoneFor(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestReadConfigSplitsZoneIDs(t *testing.T) {
+	t.Setenv(envPrefix+"CF_API_TOKEN", "token")
+	t.Setenv(envPrefix+"CF_ZONE_ID", " a, b ")
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+
+	config, err := reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CFZoneID != "a" || !slices.Equal(config.CFZoneIDs, []string{"a", "b"}) {
+		t.Errorf("zones = %q %q, want a and [a b]", config.CFZoneID, config.CFZoneIDs)
+	}
+}
+
+func TestDiscoverZones(t *testing.T) {
+	cf, requests := newTwoZoneServer(t)
+	cf.ZoneID = ""
+	config := &Config{ExternalDomain: "home.example.com", CombinedDomain: "www.example.com"}
+	if err := setupZones(cf, config); err != nil {
+		t.Fatal(err)
+	}
+	if config.CFZoneID != "a" || !slices.Equal(config.CFZoneIDs, []string{"a"}) {
+		t.Errorf("discovered zones = %q %q, want a", config.CFZoneID, config.CFZoneIDs)
+	}
+	want := []string{"GET /zones?name=home.example.com", "GET /zones?name=example.com", "GET /zones?name=www.example.com"}
+	if got := requests(); !slices.Equal(got, want) {
+		t.Errorf("lookups = %v, want %v (example.com cached)", got, want)
+	}
+
+	// Names outside the managed domains are looked up when first used, once
+	for i := 0; i < 2; i++ {
+		if err := cf.createRecord("host.lan.example.net", "A", "10.0.0.1", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = []string{"GET /zones?name=host.lan.example.net", "GET /zones?name=lan.example.net", "POST /zones/b/dns_records", "POST /zones/b/dns_records"}
+	if got := requests(); !slices.Equal(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+
+	config = &Config{ExternalDomain: "home.example.org"}
+	cf.ZoneID, cf.Zones = "", nil
+	if err := setupZones(cf, config); err == nil || !strings.Contains(err.Error(), "no zone visible") {
+		t.Errorf("unknown zone: error = %v", err)
+	}
+}
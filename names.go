@@ -0,0 +1,35 @@
+package main
+
+import "strings"
+
+// Provider APIs return record names lowercase and without the trailing dot of the fully
+// qualified form (anubis.bees.wtf, never Anubis.Bees.WTF.), while configured names may
+// have either. Names are normalized when the configuration is loaded, and names read back
+// from a provider are normalized before they are compared with configured ones, so a
+// spelling difference never hides an existing record.
+
+// normalizeName returns the canonical spelling of a DNS name
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+// normalizeConfigNames rewrites every configured record name to its canonical spelling
+func normalizeConfigNames(config *Config) {
+	for _, name := range []*string{
+		&config.InternalDomain,
+		&config.ExternalDomain,
+		&config.IPv6Domain,
+		&config.CombinedDomain,
+		&config.TopLevelDomain,
+	} {
+		*name = normalizeName(*name)
+	}
+	for _, ranges := range [][]CustomIPRange{config.CustomIPv4Ranges, config.CustomIPv6Ranges} {
+		for i := range ranges {
+			ranges[i].Domain = normalizeName(ranges[i].Domain)
+		}
+	}
+	for i, hostname := range config.DynDNS2Hostnames {
+		config.DynDNS2Hostnames[i] = normalizeName(hostname)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := map[string]string{
+		"anubis.bees.wtf":    "anubis.bees.wtf",
+		"Anubis.Bees.WTF":    "anubis.bees.wtf",
+		"anubis.bees.wtf.":   "anubis.bees.wtf",
+		" Anubis.bees.wtf. ": "anubis.bees.wtf",
+		"":                   "",
+	}
+	for name, want := range tests {
+		if got := normalizeName(name); got != want {
+			t.Errorf("normalizeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNormalizeConfigNames(t *testing.T) {
+	config := &Config{
+		InternalDomain:   "NAS.int.Example.com.",
+		ExternalDomain:   "nas.ext.example.com.",
+		CombinedDomain:   "Nas.Example.com",
+		TopLevelDomain:   "www.example.com",
+		CustomIPv4Ranges: []CustomIPRange{{CIDR: "100.64.0.0/10", Domain: "NAS.ts.example.com."}},
+		DynDNS2Hostnames: []string{"Home.DDNS.net."},
+	}
+	normalizeConfigNames(config)
+
+	got := []string{config.InternalDomain, config.ExternalDomain, config.IPv6Domain, config.CombinedDomain, config.TopLevelDomain, config.CustomIPv4Ranges[0].Domain, config.DynDNS2Hostnames[0]}
+	want := []string{"nas.int.example.com", "nas.ext.example.com", "", "nas.example.com", "www.example.com", "nas.ts.example.com", "home.ddns.net"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %v, want %v", got, want)
+	}
+}
+
+func TestNormalizedNamesMatchExistingRecords(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	fake.add("A", "nas.example.com", "192.168.1.10")
+	fake.add("CNAME", "www.example.com", "nas.example.com")
+
+	// Configured with mixed case and trailing dots, the existing records are found
+	config := &Config{CombinedDomain: "NAS.Example.com.", TopLevelDomain: "www.example.com.", clock: newFakeClock(time.Unix(1700000000, 0))}
+	normalizeConfigNames(config)
+	var changes []RecordChange
+	cf.hooks().OnChange = newChangeRecorder(&changes)
+	reconcileRecords(cf, config, &IPAddresses{InternalIPv4: []string{"192.168.1.10"}}, config.now())
+	cf.hooks().OnChange = nil
+	if len(changes) != 0 {
+		t.Errorf("records changed: %+v", changes)
+	}
+	if got := fake.contents("nas.example.com", "A"); len(got) != 1 {
+		t.Errorf("A records = %v, want one", got)
+	}
+}
+
+func TestCleanupMatchesNamesCaseInsensitively(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	stale := strconv.FormatInt(time.Unix(1700000000, 0).Add(-2*time.Hour).Unix(), 10)
+	fake.add("TXT", "NAS.example.com", stale) // As written by another tool
+	fake.add("A", "nas.example.com", "192.168.1.10")
+
+	config := &Config{CombinedDomain: "nas.example.com.", StaleThreshold: 3600, clock: newFakeClock(time.Unix(1700000000, 0))}
+	runCleanup(cf, config)
+	if got := fake.contents("nas.example.com", "A"); len(got) != 0 {
+		t.Errorf("A records left: %v", got)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// networkSettleDelay is how long to wait for the burst of events of one network change
+// (link up, DHCP, SLAAC, route changes) to end before looking at the interfaces
+const networkSettleDelay = 2 * time.Second
+
+// Reasons reported by watchNetworkChanges
+const (
+	networkChangeAddress      = "address"
+	networkChangeDefaultRoute = "default route"
+)
+
+// startNetworkWatch runs wake whenever the interface addresses or the default route change
+// (daemon mode, BEES_IP_UPDATE_WATCH_NETWORK), so updates don't wait for the next interval.
+// Address events are also sent when only lifetimes are refreshed (IPv6 router
+// advertisements), so the interfaces are compared and unchanged addresses are ignored.
+func startNetworkWatch(config *Config, wake func()) {
+	if !config.WatchNetwork {
+		return
+	}
+	changes := make(chan string, 64)
+	if err := watchNetworkChanges(changes); err != nil {
+		log.Printf("WARNING: Not watching for network changes: %v", err)
+		return
+	}
+	log.Println("Watching network interfaces - address and default route changes trigger an update")
+
+	snapshot := func() string {
+		config.interfaces.invalidate()
+		return addressFingerprint(config.interfaceSnapshot())
+	}
+	config.interfaceSnapshot() // Create the scanner before the watcher uses it
+	go watchNetworkLoop(changes, snapshot, networkSettleDelay, wake)
+}
+
+// watchNetworkLoop waits for each change to settle and runs wake if the addresses (as
+// fingerprinted by snapshot) or the default route changed. It returns when changes is closed.
+func watchNetworkLoop(changes <-chan string, snapshot func() string, settle time.Duration, wake func()) {
+	last := snapshot()
+	for reason := range changes {
+		reasons := map[string]bool{reason: true}
+		deadline := time.After(settle)
+		for waiting := true; waiting; {
+			select {
+			case r, ok := <-changes:
+				if !ok {
+					return
+				}
+				reasons[r] = true
+				deadline = time.After(settle)
+			case <-deadline:
+				waiting = false
+			}
+		}
+
+		current := snapshot()
+		if current == last && !reasons[networkChangeDefaultRoute] {
+			continue
+		}
+		last = current
+		var list []string
+		for r := range reasons {
+			list = append(list, r)
+		}
+		sort.Strings(list)
+		log.Printf("Network change (%s) - updating now", strings.Join(list, ", "))
+		wake()
+	}
+}
+
+// addressFingerprint describes the addresses of a snapshot and their states
+func addressFingerprint(s *InterfaceSnapshot) string {
+	var entries []string
+	for _, a := range s.Addrs {
+		entries = append(entries, a.Interface+" "+a.Addr.String()+" "+a.State.String())
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "\n")
+}
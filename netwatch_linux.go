@@ -0,0 +1,82 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"syscall"
+)
+
+// rtnetlink multicast groups (RTMGRP_* from linux/rtnetlink.h)
+const (
+	rtmgrpIPv4Ifaddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6Ifaddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// watchNetworkChanges subscribes to rtnetlink address and route events and sends the
+// reason of each relevant one to changes (dropping events while changes is full)
+func watchNetworkChanges(changes chan<- string) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("netlink socket: %w", err)
+	}
+	groups := uint32(rtmgrpIPv4Ifaddr | rtmgrpIPv4Route | rtmgrpIPv6Ifaddr | rtmgrpIPv6Route)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("netlink bind: %w", err)
+	}
+
+	send := func(reason string) {
+		select {
+		case changes <- reason:
+		default:
+		}
+	}
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 64*1024)
+		for {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			switch {
+			case errors.Is(err, syscall.EINTR):
+				continue
+			case errors.Is(err, syscall.ENOBUFS):
+				// Events were lost: assume the addresses changed
+				send(networkChangeAddress)
+				continue
+			case err != nil:
+				log.Printf("WARNING: Stopped watching for network changes: %v", err)
+				return
+			}
+			messages, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range messages {
+				if reason := netlinkChangeReason(m); reason != "" {
+					send(reason)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// netlinkChangeReason classifies a message: address changes, and changes of a default
+// route in the main table. Other routes (e.g. of VPNs or containers) are ignored.
+func netlinkChangeReason(m syscall.NetlinkMessage) string {
+	switch m.Header.Type {
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		return networkChangeAddress
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		// struct rtmsg: family, dst_len, src_len, tos, table, protocol, scope, type, flags
+		if len(m.Data) >= syscall.SizeofRtMsg && m.Data[1] == 0 && m.Data[4] == syscall.RT_TABLE_MAIN && m.Data[7] == syscall.RTN_UNICAST {
+			return networkChangeDefaultRoute
+		}
+	}
+	return ""
+}
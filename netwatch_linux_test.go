@@ -0,0 +1,33 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestNetlinkChangeReason(t *testing.T) {
+	route := func(msgType uint16, dstLen, table, routeType byte) syscall.NetlinkMessage {
+		data := make([]byte, syscall.SizeofRtMsg)
+		data[1], data[4], data[7] = dstLen, table, routeType
+		return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: msgType}, Data: data}
+	}
+	for _, c := range []struct {
+		name string
+		m    syscall.NetlinkMessage
+		want string
+	}{
+		{"new address", syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWADDR}}, networkChangeAddress},
+		{"deleted address", syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_DELADDR}}, networkChangeAddress},
+		{"new default route", route(syscall.RTM_NEWROUTE, 0, syscall.RT_TABLE_MAIN, syscall.RTN_UNICAST), networkChangeDefaultRoute},
+		{"deleted default route", route(syscall.RTM_DELROUTE, 0, syscall.RT_TABLE_MAIN, syscall.RTN_UNICAST), networkChangeDefaultRoute},
+		{"subnet route", route(syscall.RTM_NEWROUTE, 24, syscall.RT_TABLE_MAIN, syscall.RTN_UNICAST), ""},
+		{"local table", route(syscall.RTM_NEWROUTE, 0, syscall.RT_TABLE_LOCAL, syscall.RTN_UNICAST), ""},
+		{"link", syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWLINK}}, ""},
+	} {
+		if got := netlinkChangeReason(c.m); got != c.want {
+			t.Errorf("%s: reason = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
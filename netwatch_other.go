@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// watchNetworkChanges is only implemented with rtnetlink on Linux; elsewhere changes are
+// picked up at the next interval
+func watchNetworkChanges(changes chan<- string) error {
+	return errors.New("only supported on Linux")
+}
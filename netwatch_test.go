@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchNetworkLoop(t *testing.T) {
+	var mu sync.Mutex
+	addresses := "eth0 192.168.1.10"
+	snapshot := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return addresses
+	}
+	changes := make(chan string)
+	wakes := make(chan struct{}, 4)
+	done := make(chan struct{})
+	go func() {
+		watchNetworkLoop(changes, snapshot, 10*time.Millisecond, func() { wakes <- struct{}{} })
+		close(done)
+	}()
+	expectWake := func(want bool, what string) {
+		t.Helper()
+		select {
+		case <-wakes:
+			if !want {
+				t.Errorf("%s woke the update", what)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if want {
+				t.Errorf("%s did not wake the update", what)
+			}
+		}
+	}
+
+	// Refreshed lifetimes send address events without a change
+	changes <- networkChangeAddress
+	changes <- networkChangeAddress
+	expectWake(false, "unchanged addresses")
+
+	// A burst of events for one change wakes once
+	mu.Lock()
+	addresses = "eth0 192.168.1.20"
+	mu.Unlock()
+	changes <- networkChangeAddress
+	changes <- networkChangeAddress
+	changes <- networkChangeDefaultRoute
+	expectWake(true, "new address")
+	expectWake(false, "the same burst")
+
+	// A new default route (e.g. failover to another uplink) wakes even with the same addresses
+	changes <- networkChangeDefaultRoute
+	expectWake(true, "default route change")
+
+	close(changes)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop did not stop")
+	}
+}
+
+func TestAddressFingerprint(t *testing.T) {
+	a := &InterfaceSnapshot{Addrs: []interfaceAddr{
+		{Addr: netip.MustParseAddr("2001:db8::1"), Interface: "eth0", State: addrTentative},
+		{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "eth0"},
+	}}
+	b := &InterfaceSnapshot{Addrs: []interfaceAddr{a.Addrs[1], a.Addrs[0]}}
+	if addressFingerprint(a) != addressFingerprint(b) {
+		t.Error("fingerprint depends on the order of addresses")
+	}
+	b.Addrs[1].State = 0
+	if addressFingerprint(a) == addressFingerprint(b) {
+		t.Error("fingerprint ignores address states")
+	}
+	if !strings.Contains(addressFingerprint(a), "192.168.1.10") {
+		t.Errorf("fingerprint = %q", addressFingerprint(a))
+	}
+}
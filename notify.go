@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Notifier delivers operator notifications (reports, alerts)
+type Notifier interface {
+	Notify(event, subject, message string) error
+}
+
+// LogNotifier writes notifications to the log (used when no notifier is configured)
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(event, subject, message string) error {
+	log.Printf("[%s] %s\n%s", event, subject, message)
+	return nil
+}
+
+// WebhookNotifier POSTs notifications as JSON to a URL
+type WebhookNotifier struct {
+	URL string
+}
+
+// webhookPayload is the JSON body sent by WebhookNotifier
+type webhookPayload struct {
+	Event     string `json:"event"`
+	Subject   string `json:"subject"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (w *WebhookNotifier) Notify(event, subject, message string) error {
+	body, err := json.Marshal(webhookPayload{
+		Event:     event,
+		Subject:   subject,
+		Message:   message,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// newNotifier builds the configured notifier: the webhook, the chat notifiers (see
+// chatnotify.go) and the push notifiers (see pushnotify.go), or the log if none is configured
+func newNotifier(config *Config) Notifier {
+	var notifiers multiNotifier
+	if config.NotifyURL != "" {
+		notifiers = append(notifiers, &WebhookNotifier{URL: config.NotifyURL})
+	}
+	notifiers = append(notifiers, chatNotifiers(config)...)
+	notifiers = append(notifiers, pushNotifiers(config)...)
+	switch len(notifiers) {
+	case 0:
+		return LogNotifier{}
+	case 1:
+		return notifiers[0]
+	}
+	return notifiers
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// NS1Provider implements DNSProvider (and the internal record operations) for zones
+// hosted on NS1 (IBM NS1 Connect). NS1 keeps all values of a name and type in one record
+// as a list of answers; as a recordSetBackend, storing a set diffs the answers so that
+// answers that stay keep their IDs and metadata (filter chain data, notes).
+type NS1Provider struct {
+	Zone     string // Zone name, e.g. example.com
+	Endpoint string // API base URL, e.g. https://api.nsone.net/v1
+	APIKey   string
+
+	client *http.Client
+
+	recordSetClient
+}
+
+// Verify NS1Provider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*NS1Provider)(nil)
+var _ DNSProvider = (*NS1Provider)(nil)
+var _ providerClient = (*NS1Provider)(nil)
+
+// NS1 API structures
+type ns1Answer struct {
+	ID     string                     `json:"id,omitempty"`
+	Answer []string                   `json:"answer"`
+	Meta   map[string]json.RawMessage `json:"meta,omitempty"`
+}
+
+type ns1Record struct {
+	Zone    string      `json:"zone"`
+	Domain  string      `json:"domain"`
+	Type    string      `json:"type"`
+	TTL     int         `json:"ttl,omitempty"`
+	Answers []ns1Answer `json:"answers"`
+}
+
+type ns1Zone struct {
+	Records []ns1ZoneRecord `json:"records"`
+}
+
+type ns1ZoneRecord struct {
+	Domain       string   `json:"domain"`
+	Type         string   `json:"type"`
+	ShortAnswers []string `json:"short_answers"` // Answers with their fields joined by spaces
+}
+
+// newNS1Provider creates an NS1 provider
+func newNS1Provider(config *Config) (*NS1Provider, error) {
+	p := &NS1Provider{
+		Zone:     strings.ToLower(strings.TrimSuffix(config.NS1Zone, ".")),
+		Endpoint: "https://api.nsone.net/v1",
+		APIKey:   config.NS1APIKey,
+		client:   newProviderHTTPClient(config, providerNS1),
+	}
+	p.recordSetClient = newRecordSetClient(p, config)
+	log.Printf("Using NS1 zone %s", p.Zone)
+
+	warnUnsupportedOptions(config, "NS1")
+	return p, nil
+}
+
+// recordURL returns the URL of a record, checking that the name is in the zone
+func (p *NS1Provider) recordURL(name, recordType string) (string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name != p.Zone && !strings.HasSuffix(name, "."+p.Zone) {
+		return "", fmt.Errorf("%s is not in zone %s", name, p.Zone)
+	}
+	return fmt.Sprintf("%s/zones/%s/%s/%s", p.Endpoint, url.PathEscape(p.Zone), url.PathEscape(name), url.PathEscape(recordType)), nil
+}
+
+// request performs an authenticated API request
+func (p *NS1Provider) request(method, requestURL string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, requestURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-NSONE-Key", p.APIKey)
+
+	log.Printf("API Request: %s %s", method, req.URL.Path)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	return resp, nil
+}
+
+// ns1APIError describes an unsuccessful response
+func ns1APIError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var errResp struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &errResp) == nil && errResp.Message != "" {
+		return statusError(resp.StatusCode, errResp.Message)
+	}
+	return statusError(resp.StatusCode, "")
+}
+
+// record returns a record, or nil if the name has none of the type
+func (p *NS1Provider) record(name, recordType string) (*ns1Record, error) {
+	requestURL, err := p.recordURL(name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.request("GET", requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, ns1APIError(resp)
+	}
+	var record ns1Record
+	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return &record, nil
+}
+
+// ns1Value converts answer rdata to a record content: TXT answers hold the strings
+// of the record, every other type a single field
+func ns1Value(recordType string, rdata []string) string {
+	switch {
+	case recordType == "TXT" && len(rdata) > 1:
+		return formatTXTStrings(rdata)
+	case len(rdata) == 0:
+		return ""
+	case recordType == "CNAME":
+		return strings.TrimSuffix(rdata[0], ".")
+	}
+	return rdata[0]
+}
+
+// ns1Rdata converts a record content to answer rdata
+func ns1Rdata(recordType, value string) []string {
+	if recordType == "TXT" {
+		return parseTXTStrings(value)
+	}
+	return []string{value}
+}
+
+// ns1AnswerHolds reports whether an answer holds value
+func ns1AnswerHolds(recordType string, a ns1Answer, value string) bool {
+	if recordType == "TXT" {
+		return slices.Equal(a.Answer, ns1Rdata(recordType, value))
+	}
+	return sameContent(ns1Value(recordType, a.Answer), value)
+}
+
+// diffAnswers returns the answers holding values: existing answers with a wanted value
+// are kept as they are, new answers are added for the others
+func diffAnswers(recordType string, existing []ns1Answer, values []string) (answers []ns1Answer, changed bool) {
+	kept := make([]bool, len(existing))
+	for _, v := range values {
+		match := -1
+		for i, a := range existing {
+			if !kept[i] && ns1AnswerHolds(recordType, a, v) {
+				match = i
+				break
+			}
+		}
+		if match >= 0 {
+			kept[match] = true
+			answers = append(answers, existing[match])
+			continue
+		}
+		answers = append(answers, ns1Answer{Answer: ns1Rdata(recordType, v)})
+		changed = true
+	}
+	return answers, changed || len(answers) != len(existing)
+}
+
+// recordSetBackend implementation
+
+func (p *NS1Provider) fetchValues(name, recordType string) ([]string, error) {
+	record, err := p.record(name, recordType)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	var values []string
+	for _, a := range record.Answers {
+		values = append(values, ns1Value(recordType, a.Answer))
+	}
+	return values, nil
+}
+
+func (p *NS1Provider) storeValues(name, recordType string, ttl int, values []string) error {
+	requestURL, err := p.recordURL(name, recordType)
+	if err != nil {
+		return err
+	}
+	existing, err := p.record(name, recordType)
+	if err != nil {
+		return err
+	}
+
+	var resp *http.Response
+	switch {
+	case len(values) == 0 && existing == nil:
+		return nil
+	case len(values) == 0:
+		resp, err = p.request("DELETE", requestURL, nil)
+	case existing == nil:
+		// PUT creates a record, POST changes one
+		record := ns1Record{Zone: p.Zone, Domain: strings.TrimSuffix(name, "."), Type: recordType, TTL: ttl}
+		record.Answers, _ = diffAnswers(recordType, nil, values)
+		resp, err = p.request("PUT", requestURL, record)
+	default:
+		answers, changed := diffAnswers(recordType, existing.Answers, values)
+		if !changed && existing.TTL == ttl {
+			return nil
+		}
+		resp, err = p.request("POST", requestURL, map[string]interface{}{"ttl": ttl, "answers": answers})
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ns1APIError(resp)
+	}
+	return nil
+}
+
+func (p *NS1Provider) listRecords(recordType string) ([]CFRecord, error) {
+	resp, err := p.request("GET", fmt.Sprintf("%s/zones/%s", p.Endpoint, url.PathEscape(p.Zone)), nil)
+	if err != nil {
+		return []CFRecord{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return []CFRecord{}, ns1APIError(resp)
+	}
+	var zone ns1Zone
+	if err := json.NewDecoder(resp.Body).Decode(&zone); err != nil {
+		return []CFRecord{}, fmt.Errorf("decoding response: %w", err)
+	}
+	records := []CFRecord{}
+	for _, r := range zone.Records {
+		if r.Type == recordType {
+			records = append(records, valueRecords(r.Domain, recordType, r.ShortAnswers)...)
+		}
+	}
+	return records, nil
+}
+
+func (p *NS1Provider) probe() bool {
+	resp, err := p.request("GET", fmt.Sprintf("%s/zones/%s", p.Endpoint, url.PathEscape(p.Zone)), nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
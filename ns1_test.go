@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeNS1 is an in-memory NS1 API for the zone example.com
+type fakeNS1 struct {
+	mu      sync.Mutex
+	records map[string]*ns1Record // domain/type -> record
+	nextID  int
+	calls   []string // Methods and paths of all requests
+}
+
+func newFakeNS1(t *testing.T) (*fakeNS1, *NS1Provider) {
+	t.Helper()
+	fake := &fakeNS1{records: make(map[string]*ns1Record)}
+	server := httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(server.Close)
+
+	p, err := newNS1Provider(&Config{NS1Zone: "Example.com.", NS1APIKey: "test-key", RecordTTL: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Endpoint = server.URL
+	return fake, p
+}
+
+// answers returns the rdata of the answers of a record, in order
+func (f *fakeNS1) answers(domain, recordType string) [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.records[domain+"/"+recordType]
+	if r == nil {
+		return nil
+	}
+	var result [][]string
+	for _, a := range r.Answers {
+		result = append(result, a.Answer)
+	}
+	return result
+}
+
+// answerIDs returns the answer IDs of a record
+func (f *fakeNS1) answerIDs(domain, recordType string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, a := range f.records[domain+"/"+recordType].Answers {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+// assignIDs gives new answers an ID, as NS1 does
+func (f *fakeNS1) assignIDs(answers []ns1Answer) {
+	for i := range answers {
+		if answers[i].ID == "" {
+			f.nextID++
+			answers[i].ID = fmt.Sprintf("ans%d", f.nextID)
+		}
+	}
+}
+
+func (f *fakeNS1) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+
+	reply := func(status int, body interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+	if r.Header.Get("X-NSONE-Key") != "test-key" {
+		reply(http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "zones" || parts[1] != "example.com" {
+		reply(http.StatusNotFound, map[string]string{"message": "zone not found"})
+		return
+	}
+	if len(parts) == 2 {
+		var zone ns1Zone
+		for _, rec := range f.records {
+			entry := ns1ZoneRecord{Domain: rec.Domain, Type: rec.Type}
+			for _, a := range rec.Answers {
+				entry.ShortAnswers = append(entry.ShortAnswers, strings.Join(a.Answer, " "))
+			}
+			zone.Records = append(zone.Records, entry)
+		}
+		reply(http.StatusOK, zone)
+		return
+	}
+
+	key := parts[2] + "/" + parts[3]
+	existing := f.records[key]
+	switch r.Method {
+	case "GET":
+		if existing == nil {
+			reply(http.StatusNotFound, map[string]string{"message": "record not found"})
+			return
+		}
+		reply(http.StatusOK, existing)
+	case "PUT":
+		if existing != nil {
+			reply(http.StatusBadRequest, map[string]string{"message": "record already exists"})
+			return
+		}
+		var rec ns1Record
+		json.NewDecoder(r.Body).Decode(&rec)
+		f.assignIDs(rec.Answers)
+		f.records[key] = &rec
+		reply(http.StatusOK, rec)
+	case "POST":
+		if existing == nil {
+			reply(http.StatusNotFound, map[string]string{"message": "record not found"})
+			return
+		}
+		var change ns1Record
+		json.NewDecoder(r.Body).Decode(&change)
+		f.assignIDs(change.Answers)
+		existing.TTL, existing.Answers = change.TTL, change.Answers
+		reply(http.StatusOK, existing)
+	case "DELETE":
+		if existing == nil {
+			reply(http.StatusNotFound, map[string]string{"message": "record not found"})
+			return
+		}
+		delete(f.records, key)
+		reply(http.StatusOK, map[string]string{})
+	}
+}
+
+func TestNS1MultipleAnswersInOneRecord(t *testing.T) {
+	fake, p := newFakeNS1(t)
+
+	for _, ip := range []string{"192.168.1.10", "192.168.1.11", "192.168.1.12"} {
+		if p.ensureRecordExists("nas.example.com", "A", ip, false) != nil {
+			t.Fatalf("ensureRecordExists(%s) failed", ip)
+		}
+	}
+	want := [][]string{{"192.168.1.10"}, {"192.168.1.11"}, {"192.168.1.12"}}
+	if got := fake.answers("nas.example.com", "A"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("answers = %v, want %v", got, want)
+	}
+	ids := fake.answerIDs("nas.example.com", "A")
+
+	// Removing one address keeps the other answers (and their IDs and metadata)
+	if p.deleteRecord("192.168.1.11", "nas.example.com", "A") != nil {
+		t.Fatal("deleteRecord failed")
+	}
+	if got := fake.answerIDs("nas.example.com", "A"); !reflect.DeepEqual(got, []string{ids[0], ids[2]}) {
+		t.Errorf("answer IDs = %v, want %v", got, []string{ids[0], ids[2]})
+	}
+
+	// An address that is already answered causes no write
+	fake.calls = nil
+	if p.ensureRecordExists("nas.example.com", "A", "192.168.1.10", false) != nil {
+		t.Fatal("ensureRecordExists failed")
+	}
+	for _, call := range fake.calls {
+		if !strings.HasPrefix(call, "GET ") {
+			t.Errorf("unchanged record written: %s", call)
+		}
+	}
+
+	// Deleting the last answers deletes the record
+	p.deleteRecord("192.168.1.10", "nas.example.com", "A")
+	p.deleteRecord("192.168.1.12", "nas.example.com", "A")
+	if got := fake.answers("nas.example.com", "A"); got != nil {
+		t.Errorf("answers after deleting all = %v", got)
+	}
+}
+
+func TestDiffAnswers(t *testing.T) {
+	existing := []ns1Answer{
+		{ID: "a", Answer: []string{"192.0.2.1"}, Meta: map[string]json.RawMessage{"note": json.RawMessage(`"rack 4"`)}},
+		{ID: "b", Answer: []string{"192.0.2.2"}},
+	}
+
+	answers, changed := diffAnswers("A", existing, []string{"192.0.2.2", "192.0.2.1"})
+	if changed || len(answers) != 2 || answers[1].ID != "a" || string(answers[1].Meta["note"]) != `"rack 4"` {
+		t.Errorf("reordered values: changed = %v, answers = %+v", changed, answers)
+	}
+
+	answers, changed = diffAnswers("A", existing, []string{"192.0.2.1", "192.0.2.3"})
+	if !changed || len(answers) != 2 || answers[0].ID != "a" || answers[1].ID != "" || answers[1].Answer[0] != "192.0.2.3" {
+		t.Errorf("replaced value: changed = %v, answers = %+v", changed, answers)
+	}
+
+	if _, changed = diffAnswers("A", existing, []string{"192.0.2.1"}); !changed {
+		t.Error("removed value not reported as a change")
+	}
+
+	// TXT answers hold the strings of the record
+	txt := []ns1Answer{{ID: "t", Answer: []string{"part one", "part two"}}}
+	if _, changed = diffAnswers("TXT", txt, []string{`"part one" "part two"`}); changed {
+		t.Error("multi-string TXT value not matched")
+	}
+	if got := ns1Value("TXT", txt[0].Answer); got != `"part one" "part two"` {
+		t.Errorf("ns1Value = %s", got)
+	}
+}
+
+func TestNS1RecordOperations(t *testing.T) {
+	fake, p := newFakeNS1(t)
+
+	if p.upsertRecord("home.example.com", "A", "203.0.113.1", false) != nil || p.upsertRecord("home.example.com", "A", "203.0.113.2", false) != nil {
+		t.Fatal("upsertRecord failed")
+	}
+	if got := fake.answers("home.example.com", "A"); !reflect.DeepEqual(got, [][]string{{"203.0.113.2"}}) {
+		t.Errorf("answers = %v", got)
+	}
+	if r, _ := p.getRecord("home.example.com", "A"); r == nil || r.Content != "203.0.113.2" {
+		t.Errorf("getRecord = %+v", r)
+	}
+
+	if p.upsertRecord("www.example.com", "CNAME", "home.example.com", false) != nil {
+		t.Fatal("upsertRecord CNAME failed")
+	}
+	if r, _ := p.getRecord("www.example.com", "CNAME"); r == nil || r.Content != "home.example.com" {
+		t.Errorf("CNAME = %+v", r)
+	}
+
+	if p.upsertHeartbeat("home.example.com", `"1700000000"`) != nil {
+		t.Fatal("upsertHeartbeat failed")
+	}
+	if got := fake.answers("home.example.com", "TXT"); !reflect.DeepEqual(got, [][]string{{"1700000000"}}) {
+		t.Errorf("heartbeat answers = %v", got)
+	}
+	records, _ := p.getAllRecordsByType("TXT")
+	if len(records) != 1 || records[0].Name != "home.example.com" || records[0].Content != "1700000000" {
+		t.Errorf("listed = %+v", records)
+	}
+
+	if p.deleteRecordIfExists("home.example.com", "A") != nil || fake.answers("home.example.com", "A") != nil {
+		t.Errorf("deleteRecordIfExists left %v", fake.answers("home.example.com", "A"))
+	}
+	if p.upsertRecord("home.example.org", "A", "192.0.2.1", false) == nil {
+		t.Error("accepted a name outside the zone")
+	}
+}
+
+func TestNS1Errors(t *testing.T) {
+	_, p := newFakeNS1(t)
+
+	if !p.probeConnectivity() {
+		t.Error("probe failed")
+	}
+	p.APIKey = "wrong"
+	if err := p.storeValues("home.example.com", "A", 120, []string{"192.0.2.1"}); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("err = %v", err)
+	}
+
+	p.Endpoint = "http://127.0.0.1:1"
+	if err := p.storeValues("home.example.com", "A", 120, []string{"192.0.2.1"}); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("err = %v, want unreachable", err)
+	}
+	if p.probeConnectivity() {
+		t.Error("probe succeeded without a server")
+	}
+}
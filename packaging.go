@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// packagingLayout is where a packaged install keeps its files on a platform
+type packagingLayout struct {
+	Binary    string // Installed binary
+	ConfigDir string // Holds config.json
+	StateDir  string // Holds state.json (change history, queue, source health)
+	ShareDir  string // Holds config.schema.json
+	LogDir    string // launchd only: stdout/stderr logs
+}
+
+func (l packagingLayout) configPath() string { return filepath.Join(l.ConfigDir, "config.json") }
+func (l packagingLayout) statePath() string  { return filepath.Join(l.StateDir, "state.json") }
+func (l packagingLayout) schemaPath() string { return filepath.Join(l.ShareDir, "config.schema.json") }
+
+// packagingLayoutFor returns the default layout for a platform.
+// prefix only applies to darwin, where Homebrew installs under /usr/local or /opt/homebrew.
+func packagingLayoutFor(platform, prefix string) (packagingLayout, error) {
+	switch platform {
+	case "linux":
+		return packagingLayout{
+			Binary:    "/usr/bin/dynipupdate",
+			ConfigDir: "/etc/dynipupdate",
+			StateDir:  "/var/lib/dynipupdate",
+			ShareDir:  "/usr/share/dynipupdate",
+		}, nil
+	case "darwin":
+		if prefix == "" {
+			prefix = "/usr/local"
+		}
+		return packagingLayout{
+			Binary:    filepath.Join(prefix, "bin", "dynipupdate"),
+			ConfigDir: filepath.Join(prefix, "etc", "dynipupdate"),
+			StateDir:  filepath.Join(prefix, "var", "lib", "dynipupdate"),
+			ShareDir:  filepath.Join(prefix, "share", "dynipupdate"),
+			LogDir:    filepath.Join(prefix, "var", "log"),
+		}, nil
+	default:
+		return packagingLayout{}, fmt.Errorf("no packaging defaults for %s (supported: linux, darwin)", platform)
+	}
+}
+
+// packagedConfig returns the default config file shipped in packages.
+// It only sets the state location; the user adds credentials and domains (e.g. with "dynipupdate init").
+func packagedConfig(layout packagingLayout) []byte {
+	data, _ := json.MarshalIndent(map[string]interface{}{
+		"$schema":    layout.schemaPath(),
+		"state_file": layout.statePath(),
+	}, "", "  ")
+	return append(data, '\n')
+}
+
+// launchdPlist returns a launchd job running the updater every 5 minutes
+func launchdPlist(layout packagingLayout) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>com.richleigh.dynipupdate</string>
+    <key>ProgramArguments</key>
+    <array>
+        <string>%s</string>
+    </array>
+    <key>EnvironmentVariables</key>
+    <dict>
+        <key>%sCONFIG_FILE</key>
+        <string>%s</string>
+    </dict>
+    <key>StartInterval</key>
+    <integer>300</integer>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>StandardOutPath</key>
+    <string>%s</string>
+    <key>StandardErrorPath</key>
+    <string>%s</string>
+</dict>
+</plist>
+`, layout.Binary, envPrefix, layout.configPath(),
+		filepath.Join(layout.LogDir, "dynipupdate.log"), filepath.Join(layout.LogDir, "dynipupdate.log"))
+}
+
+// postinstallScript returns the deb/rpm post-install script
+func postinstallScript(layout packagingLayout) string {
+	return fmt.Sprintf(`#!/bin/sh
+set -e
+mkdir -p %s
+chmod 700 %s
+chmod 600 %s
+if command -v systemctl >/dev/null 2>&1; then
+    systemctl daemon-reload || true
+fi
+echo "dynipupdate installed. Configure it with:"
+echo "  sudo dynipupdate init -output %s -force"
+echo "then enable the timer:"
+echo "  sudo systemctl enable --now dynipupdate.timer"
+`, layout.StateDir, layout.StateDir, layout.configPath(), layout.configPath())
+}
+
+// packagingFiles returns the files (name -> content) to generate for a platform
+func packagingFiles(platform string, layout packagingLayout) map[string]string {
+	files := map[string]string{
+		"config.json":        string(packagedConfig(layout)),
+		"config.schema.json": string(configSchemaJSON),
+	}
+	switch platform {
+	case "linux":
+		service, timer := systemdUnits(layout.Binary, layout.configPath(), filepath.Base(layout.StateDir))
+		files["dynipupdate.service"] = service
+		files["dynipupdate.timer"] = timer
+		files["postinstall.sh"] = postinstallScript(layout)
+	case "darwin":
+		files["com.richleigh.dynipupdate.plist"] = launchdPlist(layout)
+	}
+	return files
+}
+
+// runGenPackaging implements the gen-packaging subcommand: emits service files and default
+// config for distribution packages (used by the "make package" build)
+func runGenPackaging(args []string) int {
+	fs := flag.NewFlagSet("gen-packaging", flag.ContinueOnError)
+	platform := fs.String("platform", runtime.GOOS, "Target platform (linux, darwin)")
+	prefix := fs.String("prefix", "", "Install prefix for darwin/Homebrew (default /usr/local)")
+	output := fs.String("output", "packaging", "Directory to write files to")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	layout, err := packagingLayoutFor(*platform, *prefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := os.MkdirAll(*output, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	for name, content := range packagingFiles(*platform, layout) {
+		perm := os.FileMode(0o644)
+		if strings.HasSuffix(name, ".sh") {
+			perm = 0o755
+		}
+		path := filepath.Join(*output, name)
+		if err := os.WriteFile(path, []byte(content), perm); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Println(path)
+	}
+
+	fmt.Fprintf(os.Stderr, "Binary: %s\nConfig: %s\nState:  %s\n", layout.Binary, layout.configPath(), layout.statePath())
+	return 0
+}
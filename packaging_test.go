@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPackagingFilesLinux(t *testing.T) {
+	layout, err := packagingLayoutFor("linux", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := packagingFiles("linux", layout)
+
+	service := files["dynipupdate.service"]
+	for _, want := range []string{
+		"ExecStart=/usr/bin/dynipupdate",
+		"Environment=" + envPrefix + "CONFIG_FILE=/etc/dynipupdate/config.json",
+		"StateDirectory=dynipupdate",
+	} {
+		if !strings.Contains(service, want) {
+			t.Errorf("service missing %q:\n%s", want, service)
+		}
+	}
+	if _, ok := files["dynipupdate.timer"]; !ok {
+		t.Error("missing timer")
+	}
+
+	// The shipped config must pass our own schema validation
+	raw, err := parseConfigFileData("config.json", []byte(files["config.json"]))
+	if err != nil {
+		t.Fatalf("packaged config is invalid: %v", err)
+	}
+	if raw["state_file"] != "/var/lib/dynipupdate/state.json" {
+		t.Errorf("state_file = %v", raw["state_file"])
+	}
+}
+
+func TestPackagingFilesDarwin(t *testing.T) {
+	layout, err := packagingLayoutFor("darwin", "/opt/homebrew")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := packagingFiles("darwin", layout)
+
+	plist := files["com.richleigh.dynipupdate.plist"]
+	if !strings.Contains(plist, "<string>/opt/homebrew/bin/dynipupdate</string>") ||
+		!strings.Contains(plist, "<string>/opt/homebrew/etc/dynipupdate/config.json</string>") {
+		t.Errorf("unexpected plist:\n%s", plist)
+	}
+
+	var config map[string]string
+	if err := json.Unmarshal([]byte(files["config.json"]), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config["state_file"] != "/opt/homebrew/var/lib/dynipupdate/state.json" {
+		t.Errorf("state_file = %q", config["state_file"])
+	}
+}
+
+func TestPackagingLayoutUnsupported(t *testing.T) {
+	if _, err := packagingLayoutFor("plan9", ""); err == nil {
+		t.Error("expected an error for unsupported platforms")
+	}
+}
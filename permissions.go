@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// TokenPermission describes a single CloudFlare API token permission requirement
+type TokenPermission struct {
+	Scope  string `json:"scope"`  // "Zone" or "Account"
+	Group  string `json:"group"`  // Permission group as named in the dashboard, e.g. "DNS"
+	Level  string `json:"level"`  // "Read" or "Edit"
+	Reason string `json:"reason"` // Which configured feature needs it
+}
+
+// TokenRequirements is the full permission set plus resource scoping for a configuration
+type TokenRequirements struct {
+	Permissions    []TokenPermission `json:"permissions"`
+	ZoneResources  string            `json:"zone_resources"`
+	ManagedDomains []string          `json:"managed_domains"`
+}
+
+// requiredTokenPermissions computes the least-privilege token permissions for a configuration
+func requiredTokenPermissions(config *Config, cleanupMode bool) TokenRequirements {
+	reqs := TokenRequirements{
+		Permissions: []TokenPermission{
+			{
+				Scope:  "Zone",
+				Group:  "DNS",
+				Level:  "Edit",
+				Reason: "create, update and delete managed A/AAAA/CNAME/TXT records",
+			},
+		},
+		ManagedDomains: managedDomainList(config),
+	}
+
+	if cleanupMode {
+		reqs.Permissions[0].Reason += "; list zone TXT records for heartbeat cleanup"
+	}
+
+	if config.AuditLogInterval > 0 {
+		reqs.Permissions = append(reqs.Permissions, TokenPermission{
+			Scope:  "Account",
+			Group:  "Account Settings",
+			Level:  "Read",
+			Reason: "read the audit log to report changes to managed records by others",
+		})
+	}
+
+	switch {
+	case config.CFZoneID == "":
+		reqs.Permissions = append(reqs.Permissions, TokenPermission{
+			Scope:  "Zone",
+			Group:  "Zone",
+			Level:  "Read",
+			Reason: "look up the zones of the managed domains (no " + envPrefix + "CF_ZONE_ID set)",
+		})
+	case len(config.CFZoneIDs) > 1:
+		reqs.Permissions = append(reqs.Permissions, TokenPermission{
+			Scope:  "Zone",
+			Group:  "Zone",
+			Level:  "Read",
+			Reason: "read the names of the zones in " + envPrefix + "CF_ZONE_ID to route each domain to its zone",
+		})
+	}
+
+	switch {
+	case len(config.CFZoneIDs) > 1:
+		reqs.ZoneResources = fmt.Sprintf("Include > Specific zone > each of %s", strings.Join(config.CFZoneIDs, ", "))
+	case config.CFZoneID != "":
+		reqs.ZoneResources = fmt.Sprintf("Include > Specific zone > %s", config.CFZoneID)
+	default:
+		reqs.ZoneResources = "Include > Specific zone > (the zone containing your managed domains)"
+	}
+
+	return reqs
+}
+
+// managedDomainList returns the sorted, de-duplicated set of configured domains
+func managedDomainList(config *Config) []string {
+	seen := make(map[string]bool)
+	for _, domain := range []string{
+		config.InternalDomain,
+		config.ExternalDomain,
+		config.IPv6Domain,
+		config.CombinedDomain,
+		config.TopLevelDomain,
+	} {
+		if domain != "" {
+			seen[domain] = true
+		}
+	}
+	for _, r := range config.CustomIPv4Ranges {
+		seen[r.Domain] = true
+	}
+	for _, r := range config.CustomIPv6Ranges {
+		seen[r.Domain] = true
+	}
+
+	domains := getMapKeys(seen)
+	sort.Strings(domains)
+	return domains
+}
+
+// runPrintRequiredPermissions implements the print-required-permissions subcommand
+func runPrintRequiredPermissions(args []string) int {
+	fs := flag.NewFlagSet("print-required-permissions", flag.ContinueOnError)
+	cleanupMode := fs.Bool("cleanup", false, "Include permissions needed by the cleanup service")
+	jsonOutput := fs.Bool("json", false, "Output as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config := readConfig(*cleanupMode, false)
+	reqs := requiredTokenPermissions(config, *cleanupMode)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reqs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding permissions: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Println("Required CloudFlare API token permissions:")
+	for _, p := range reqs.Permissions {
+		fmt.Printf("  - %s > %s > %s  (%s)\n", p.Scope, p.Group, p.Level, p.Reason)
+	}
+	fmt.Println()
+	fmt.Println("Zone Resources:")
+	fmt.Printf("  - %s\n", reqs.ZoneResources)
+	fmt.Println()
+	fmt.Println("Managed domains covered by this token:")
+	for _, domain := range reqs.ManagedDomains {
+		fmt.Printf("  - %s\n", domain)
+	}
+	fmt.Println()
+	fmt.Println("Create the token at https://dash.cloudflare.com/profile/api-tokens using \"Create Custom Token\".")
+
+	return 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestRequiredTokenPermissions verifies the base permission set and zone scoping
+func TestRequiredTokenPermissions(t *testing.T) {
+	config := &Config{
+		CFZoneID:       "abc123",
+		CombinedDomain: "anubis.bees.wtf",
+		TopLevelDomain: "anubis.example.com",
+		CustomIPv4Ranges: []CustomIPRange{
+			{CIDR: "100.64.0.0/10", Domain: "anubis.ts.bees.wtf", Type: "A"},
+		},
+	}
+
+	reqs := requiredTokenPermissions(config, false)
+
+	if len(reqs.Permissions) != 1 {
+		t.Fatalf("Expected 1 permission, got %d", len(reqs.Permissions))
+	}
+	if p := reqs.Permissions[0]; p.Scope != "Zone" || p.Group != "DNS" || p.Level != "Edit" {
+		t.Errorf("Expected Zone > DNS > Edit, got %s > %s > %s", p.Scope, p.Group, p.Level)
+	}
+	if !strings.Contains(reqs.ZoneResources, "abc123") {
+		t.Errorf("Expected zone resources to reference the zone ID, got %q", reqs.ZoneResources)
+	}
+
+	expectedDomains := []string{"anubis.bees.wtf", "anubis.example.com", "anubis.ts.bees.wtf"}
+	if !reflect.DeepEqual(reqs.ManagedDomains, expectedDomains) {
+		t.Errorf("Expected domains %v, got %v", expectedDomains, reqs.ManagedDomains)
+	}
+}
+
+// TestRequiredTokenPermissionsMultipleZones verifies that routing over several zones needs
+// Zone > Zone > Read, as their names are read at startup
+func TestRequiredTokenPermissionsMultipleZones(t *testing.T) {
+	config := &Config{CFZoneID: "zone1", CFZoneIDs: []string{"zone1", "zone2"}, ExternalDomain: "home.example.com"}
+
+	reqs := requiredTokenPermissions(config, false)
+
+	if len(reqs.Permissions) != 2 {
+		t.Fatalf("Expected 2 permissions, got %+v", reqs.Permissions)
+	}
+	if p := reqs.Permissions[1]; p.Scope != "Zone" || p.Group != "Zone" || p.Level != "Read" {
+		t.Errorf("Expected Zone > Zone > Read, got %s > %s > %s", p.Scope, p.Group, p.Level)
+	}
+	if !strings.Contains(reqs.ZoneResources, "zone1, zone2") {
+		t.Errorf("Expected zone resources to list both zones, got %q", reqs.ZoneResources)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// porkbunMinTTL is the lowest TTL Porkbun accepts
+const porkbunMinTTL = 600
+
+// PorkbunProvider implements DNSProvider (and the internal record operations) for
+// domains registered at Porkbun, using the v3 JSON API. Porkbun stores individual
+// records with IDs; as a recordSetBackend, storing a set creates and deletes records
+// until the name holds exactly the wanted values.
+type PorkbunProvider struct {
+	Domain    string // Domain at Porkbun, e.g. example.com
+	Endpoint  string // API base URL, e.g. https://api.porkbun.com/api/json/v3
+	APIKey    string
+	SecretKey string
+
+	client     *http.Client
+	ttlWarning sync.Once
+
+	recordSetClient
+}
+
+// Verify PorkbunProvider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*PorkbunProvider)(nil)
+var _ DNSProvider = (*PorkbunProvider)(nil)
+var _ providerClient = (*PorkbunProvider)(nil)
+
+// Porkbun API structures
+type porkbunRecord struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"` // Fully qualified
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     string `json:"ttl"`
+}
+
+type porkbunResponse struct {
+	Status  string          `json:"status"` // "SUCCESS" or "ERROR"
+	Message string          `json:"message"`
+	Records []porkbunRecord `json:"records"`
+}
+
+// newPorkbunProvider creates a Porkbun provider
+func newPorkbunProvider(config *Config) (*PorkbunProvider, error) {
+	p := &PorkbunProvider{
+		Domain:    strings.ToLower(strings.TrimSuffix(config.PorkbunDomain, ".")),
+		Endpoint:  "https://api.porkbun.com/api/json/v3",
+		APIKey:    config.PorkbunAPIKey,
+		SecretKey: config.PorkbunSecretKey,
+		client:    newProviderHTTPClient(config, providerPorkbun),
+	}
+	p.recordSetClient = newRecordSetClient(p, config)
+	log.Printf("Using Porkbun domain %s", p.Domain)
+
+	warnUnsupportedOptions(config, "Porkbun")
+	return p, nil
+}
+
+// subdomain returns the name relative to the domain ("" for the domain itself)
+func (p *PorkbunProvider) subdomain(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == p.Domain {
+		return "", nil
+	}
+	if relative, ok := strings.CutSuffix(name, "."+p.Domain); ok {
+		return relative, nil
+	}
+	return "", fmt.Errorf("%s is not in domain %s", name, p.Domain)
+}
+
+// call POSTs an API request (credentials are part of every body) and decodes the response
+func (p *PorkbunProvider) call(path string, fields map[string]string) (*porkbunResponse, error) {
+	body := map[string]string{"apikey": p.APIKey, "secretapikey": p.SecretKey}
+	for k, v := range fields {
+		body[k] = v
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("API Request: POST %s", path)
+	resp, err := p.client.Post(p.Endpoint+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	var result porkbunResponse
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("status %d: unexpected response", resp.StatusCode)
+	}
+	if result.Status != "SUCCESS" {
+		return nil, statusError(resp.StatusCode, result.Message)
+	}
+	return &result, nil
+}
+
+// porkbunPath joins escaped path segments, skipping empty ones
+func porkbunPath(segments ...string) string {
+	var b strings.Builder
+	for _, s := range segments {
+		if s != "" {
+			b.WriteString("/" + url.PathEscape(s))
+		}
+	}
+	return b.String()
+}
+
+// records returns the records of a name and type
+func (p *PorkbunProvider) records(name, recordType string) ([]porkbunRecord, error) {
+	sub, err := p.subdomain(name)
+	if err != nil {
+		return nil, err
+	}
+	result, err := p.call("/dns/retrieveByNameType"+porkbunPath(p.Domain, recordType, sub), nil)
+	if err != nil {
+		return nil, err
+	}
+	return result.Records, nil
+}
+
+// porkbunContent converts a record value to Porkbun's content: TXT records with one
+// string are stored unquoted, as the web interface does
+func porkbunContent(recordType, value string) string {
+	if recordType == "TXT" {
+		if parts := parseTXTStrings(value); len(parts) == 1 {
+			return parts[0]
+		}
+	}
+	return value
+}
+
+// recordSetBackend implementation
+
+func (p *PorkbunProvider) fetchValues(name, recordType string) ([]string, error) {
+	records, err := p.records(name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, r := range records {
+		values = append(values, r.Content)
+	}
+	return values, nil
+}
+
+func (p *PorkbunProvider) storeValues(name, recordType string, ttl int, values []string) error {
+	sub, err := p.subdomain(name)
+	if err != nil {
+		return err
+	}
+	if ttl < porkbunMinTTL {
+		p.ttlWarning.Do(func() {
+			log.Printf("WARNING: Porkbun requires a TTL of at least %d seconds - using %d instead of %d", porkbunMinTTL, porkbunMinTTL, ttl)
+		})
+		ttl = porkbunMinTTL
+	}
+	existing, err := p.records(name, recordType)
+	if err != nil {
+		return err
+	}
+
+	// Keep records that already hold a wanted value, delete the rest, create what is missing
+	wanted := make([]string, len(values))
+	for i, v := range values {
+		wanted[i] = porkbunContent(recordType, v)
+	}
+	kept, stale := matchValues(existing, wanted, func(r porkbunRecord, w string) bool { return sameContent(r.Content, w) })
+	for _, r := range stale {
+		if _, err := p.call("/dns/delete"+porkbunPath(p.Domain, r.ID), nil); err != nil {
+			return err
+		}
+	}
+	for i, w := range wanted {
+		if kept[i] {
+			continue
+		}
+		fields := map[string]string{"name": sub, "type": recordType, "content": w, "ttl": fmt.Sprint(ttl)}
+		if _, err := p.call("/dns/create"+porkbunPath(p.Domain), fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *PorkbunProvider) listRecords(recordType string) ([]CFRecord, error) {
+	result, err := p.call("/dns/retrieve"+porkbunPath(p.Domain), nil)
+	if err != nil {
+		return []CFRecord{}, err
+	}
+	records := []CFRecord{}
+	for _, r := range result.Records {
+		if r.Type == recordType {
+			records = append(records, valueRecords(r.Name, recordType, []string{r.Content})...)
+		}
+	}
+	return records, nil
+}
+
+// probe reports whether the API answers; an error response (e.g. bad keys) still counts
+func (p *PorkbunProvider) probe() bool {
+	_, err := p.call("/ping", nil)
+	return !errors.Is(err, errProviderUnreachable)
+}
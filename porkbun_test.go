@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePorkbun is an in-memory Porkbun API for example.com
+type fakePorkbun struct {
+	mu      sync.Mutex
+	records map[string]porkbunRecord // id -> record
+	nextID  int
+	calls   []string // Paths of all requests
+}
+
+func newFakePorkbun(t *testing.T) (*fakePorkbun, *PorkbunProvider) {
+	t.Helper()
+	fake := &fakePorkbun{records: make(map[string]porkbunRecord)}
+	server := httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(server.Close)
+
+	p, err := newPorkbunProvider(&Config{PorkbunDomain: "Example.com.", PorkbunAPIKey: "pk1_test", PorkbunSecretKey: "sk1_test", RecordTTL: 600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Endpoint = server.URL
+	return fake, p
+}
+
+func (f *fakePorkbun) add(recordType, name, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	id := fmt.Sprint(f.nextID)
+	f.records[id] = porkbunRecord{ID: id, Name: name, Type: recordType, Content: content, TTL: "600"}
+}
+
+// sorted returns the records in creation order, as the API does
+func (f *fakePorkbun) sorted() []porkbunRecord {
+	records := make([]porkbunRecord, 0, len(f.records))
+	for _, r := range f.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i].ID, records[j].ID
+		return len(a) < len(b) || len(a) == len(b) && a < b
+	})
+	return records
+}
+
+// contents returns the sorted contents of the records of a name and type
+func (f *fakePorkbun) contents(name, recordType string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []string
+	for _, r := range f.records {
+		if r.Name == name && r.Type == recordType {
+			result = append(result, r.Content+"/"+r.TTL)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (f *fakePorkbun) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, r.URL.Path)
+
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+	reply := func(status int, resp map[string]interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}
+	if r.Method != "POST" || body["apikey"] != "pk1_test" || body["secretapikey"] != "sk1_test" {
+		reply(http.StatusBadRequest, map[string]interface{}{"status": "ERROR", "message": "Invalid API key. (002)"})
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	fqdn := func(sub string) string {
+		if sub == "" {
+			return "example.com"
+		}
+		return sub + ".example.com"
+	}
+	switch {
+	case parts[0] == "ping":
+		reply(http.StatusOK, map[string]interface{}{"status": "SUCCESS", "yourIp": "203.0.113.1"})
+	case len(parts) >= 4 && parts[1] == "retrieveByNameType" && parts[2] == "example.com":
+		sub := ""
+		if len(parts) > 4 {
+			sub = parts[4]
+		}
+		var records []porkbunRecord
+		for _, rec := range f.sorted() {
+			if rec.Name == fqdn(sub) && rec.Type == parts[3] {
+				records = append(records, rec)
+			}
+		}
+		reply(http.StatusOK, map[string]interface{}{"status": "SUCCESS", "records": records})
+	case len(parts) == 3 && parts[1] == "retrieve":
+		reply(http.StatusOK, map[string]interface{}{"status": "SUCCESS", "records": f.sorted()})
+	case len(parts) == 3 && parts[1] == "create":
+		if body["ttl"] < "600" && len(body["ttl"]) <= 3 {
+			reply(http.StatusBadRequest, map[string]interface{}{"status": "ERROR", "message": "TTL too low"})
+			return
+		}
+		f.nextID++
+		id := fmt.Sprint(f.nextID)
+		f.records[id] = porkbunRecord{ID: id, Name: fqdn(body["name"]), Type: body["type"], Content: body["content"], TTL: body["ttl"]}
+		reply(http.StatusOK, map[string]interface{}{"status": "SUCCESS", "id": f.nextID})
+	case len(parts) == 4 && parts[1] == "delete":
+		if _, ok := f.records[parts[3]]; !ok {
+			reply(http.StatusBadRequest, map[string]interface{}{"status": "ERROR", "message": "Invalid record ID."})
+			return
+		}
+		delete(f.records, parts[3])
+		reply(http.StatusOK, map[string]interface{}{"status": "SUCCESS"})
+	default:
+		reply(http.StatusNotFound, map[string]interface{}{"status": "ERROR", "message": "unknown endpoint"})
+	}
+}
+
+func TestPorkbunRecordOperations(t *testing.T) {
+	fake, p := newFakePorkbun(t)
+	fake.add("A", "nas.example.com", "192.168.1.10")
+	fake.add("A", "nas.example.com", "192.168.1.99")
+
+	// Existing values are kept, missing ones created
+	if p.ensureRecordExists("nas.example.com", "A", "192.168.1.10", false) != nil ||
+		p.ensureRecordExists("nas.example.com", "A", "192.168.1.11", false) != nil {
+		t.Fatal("ensureRecordExists failed")
+	}
+	if p.deleteRecord("192.168.1.99", "nas.example.com", "A") != nil {
+		t.Fatal("deleteRecord failed")
+	}
+	want := []string{"192.168.1.10/600", "192.168.1.11/600"}
+	if got := fake.contents("nas.example.com", "A"); !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+
+	// The apex has no subdomain
+	if p.upsertRecord("example.com", "AAAA", "2001:db8::1", false) != nil || p.upsertRecord("example.com", "AAAA", "2001:db8::2", false) != nil {
+		t.Fatal("upsertRecord failed")
+	}
+	if got := fake.contents("example.com", "AAAA"); !reflect.DeepEqual(got, []string{"2001:db8::2/600"}) {
+		t.Errorf("apex records = %v", got)
+	}
+
+	// Like the other providers, deleteRecordIfExists deletes the first record
+	if p.deleteRecordIfExists("nas.example.com", "A") != nil {
+		t.Fatal("deleteRecordIfExists failed")
+	}
+	if got := fake.contents("nas.example.com", "A"); !reflect.DeepEqual(got, []string{"192.168.1.11/600"}) {
+		t.Errorf("deleteRecordIfExists left %v", got)
+	}
+	if p.upsertRecord("nas.example.org", "A", "192.0.2.1", false) == nil {
+		t.Error("accepted a name outside the domain")
+	}
+}
+
+func TestPorkbunHeartbeatsAndListing(t *testing.T) {
+	fake, p := newFakePorkbun(t)
+	p.HeartbeatTTL = 60 // Below Porkbun's minimum
+
+	if p.upsertHeartbeat("nas.example.com", `"1700000000"`) != nil {
+		t.Fatal("upsertHeartbeat failed")
+	}
+	if got := fake.contents("nas.example.com", "TXT"); !reflect.DeepEqual(got, []string{"1700000000/600"}) {
+		t.Errorf("heartbeat = %v", got)
+	}
+
+	records, _ := p.getAllRecordsByType("TXT")
+	if len(records) != 1 || records[0].Name != "nas.example.com" || records[0].Content != "1700000000" {
+		t.Errorf("listed = %+v", records)
+	}
+}
+
+func TestPorkbunErrors(t *testing.T) {
+	_, p := newFakePorkbun(t)
+
+	if !p.probeConnectivity() {
+		t.Error("probe failed")
+	}
+	p.SecretKey = "wrong"
+	if err := p.storeValues("nas.example.com", "A", 600, []string{"192.0.2.1"}); err == nil || !strings.Contains(err.Error(), "Invalid API key") {
+		t.Errorf("err = %v", err)
+	}
+	if !p.probeConnectivity() {
+		t.Error("an error response means the API is reachable")
+	}
+
+	p.Endpoint = "http://127.0.0.1:1"
+	if err := p.storeValues("nas.example.com", "A", 600, []string{"192.0.2.1"}); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("err = %v, want unreachable", err)
+	}
+	if p.probeConnectivity() {
+		t.Error("probe succeeded without a server")
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Maximum number of network profiles (BEES_IP_UPDATE_PROFILE_1 .. _N)
+const maxNetworkProfiles = 10
+
+// NetworkProfile selects which domains are published when the host is on a given network
+type NetworkProfile struct {
+	Name        string
+	SSIDs       []string // Match when connected to any of these Wi-Fi networks
+	GatewayMACs []string // Match when the default gateway has any of these MAC addresses
+	Subnets     []string // Match when any interface has an address in one of these CIDRs
+	Domains     []string // Domain classes (internal, external, ipv6, combined, toplevel, ipv4_range_N, ipv6_range_N) or full domain names
+}
+
+// NetworkEnvironment describes the network the host is currently attached to
+type NetworkEnvironment struct {
+	SSID       string
+	GatewayMAC string
+	Addresses  []netip.Addr
+}
+
+// splitList splits a comma-separated list, trimming whitespace and dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseNetworkProfiles reads PROFILE_N_* variables
+// Format: PROFILE_1_NAME=home, PROFILE_1_SSID=HomeWifi, PROFILE_1_GATEWAY_MAC=aa:bb:..,
+// PROFILE_1_SUBNET=192.168.1.0/24, PROFILE_1_DOMAINS=internal,combined
+func parseNetworkProfiles(maxProfiles int) []NetworkProfile {
+	var profiles []NetworkProfile
+
+	for i := 1; i <= maxProfiles; i++ {
+		prefix := fmt.Sprintf("PROFILE_%d_", i)
+		profile := NetworkProfile{
+			Name:        getEnvOrDefault(prefix+"NAME", fmt.Sprintf("profile-%d", i)),
+			SSIDs:       splitList(getEnv(prefix + "SSID")),
+			GatewayMACs: splitList(strings.ToLower(getEnv(prefix + "GATEWAY_MAC"))),
+			Subnets:     splitList(getEnv(prefix + "SUBNET")),
+			Domains:     splitList(getEnv(prefix + "DOMAINS")),
+		}
+
+		hasMatcher := len(profile.SSIDs) > 0 || len(profile.GatewayMACs) > 0 || len(profile.Subnets) > 0
+		if !hasMatcher && len(profile.Domains) == 0 {
+			continue
+		}
+		if !hasMatcher {
+			log.Printf("WARNING: %s%sDOMAINS is set but no SSID, GATEWAY_MAC or SUBNET matcher - skipping", envPrefix, prefix)
+			continue
+		}
+		if len(profile.Domains) == 0 {
+			log.Printf("WARNING: %s%sDOMAINS is not set - profile %s would publish nothing", envPrefix, prefix, profile.Name)
+		}
+
+		for _, subnet := range profile.Subnets {
+			if _, err := netip.ParsePrefix(subnet); err != nil {
+				log.Printf("WARNING: Invalid CIDR notation in %s%sSUBNET: %s (%v)", envPrefix, prefix, subnet, err)
+			}
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles
+}
+
+// matches reports whether the profile applies to the given network environment
+func (p NetworkProfile) matches(env NetworkEnvironment) bool {
+	for _, ssid := range p.SSIDs {
+		if env.SSID != "" && ssid == env.SSID {
+			return true
+		}
+	}
+	for _, mac := range p.GatewayMACs {
+		if env.GatewayMAC != "" && strings.EqualFold(mac, env.GatewayMAC) {
+			return true
+		}
+	}
+	for _, subnet := range p.Subnets {
+		prefix, err := netip.ParsePrefix(subnet)
+		if err != nil {
+			continue
+		}
+		prefix = prefix.Masked()
+		for _, addr := range env.Addresses {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchNetworkProfile returns the first profile matching env, or nil
+func matchNetworkProfile(profiles []NetworkProfile, env NetworkEnvironment) *NetworkProfile {
+	for i := range profiles {
+		if profiles[i].matches(env) {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
+
+// applyNetworkProfile restricts config to the domains enabled by profile.
+// Domains that are not enabled are removed from the config so they are neither updated nor deleted.
+func applyNetworkProfile(config *Config, profile *NetworkProfile) {
+	enabled := make(map[string]bool)
+	for _, d := range profile.Domains {
+		enabled[normalizeName(d)] = true
+	}
+	retainDomains(config, func(class, domain string) bool {
+		return enabled[class] || (domain != "" && enabled[normalizeName(domain)])
+	})
+}
+
+// retainDomains removes the domains for which keep returns false from config, so they are
+// neither updated nor deleted. keep gets the domain class (internal, external, ipv6,
+// combined, toplevel, ipv4_range_N, ipv6_range_N) and the configured name.
+func retainDomains(config *Config, keep func(class, domain string) bool) {
+	if !keep("internal", config.InternalDomain) {
+		config.InternalDomain = ""
+	}
+	if !keep("external", config.ExternalDomain) {
+		config.ExternalDomain = ""
+	}
+	if !keep("ipv6", config.IPv6Domain) {
+		config.IPv6Domain = ""
+	}
+	if !keep("combined", config.CombinedDomain) {
+		config.CombinedDomain = ""
+	}
+	if !keep("toplevel", config.TopLevelDomain) {
+		config.TopLevelDomain = ""
+	}
+
+	filterRanges := func(ranges []CustomIPRange, class string) []CustomIPRange {
+		var kept []CustomIPRange
+		for i, r := range ranges {
+			if keep(fmt.Sprintf("%s_%d", class, i+1), r.Domain) {
+				kept = append(kept, r)
+			}
+		}
+		return kept
+	}
+	config.CustomIPv4Ranges = filterRanges(config.CustomIPv4Ranges, "ipv4_range")
+	config.CustomIPv6Ranges = filterRanges(config.CustomIPv6Ranges, "ipv6_range")
+	config.rangeMatcher = nil
+}
+
+// detectNetworkEnvironment gathers the current SSID, gateway MAC and interface addresses
+func detectNetworkEnvironment(interfaces *InterfaceSnapshot) NetworkEnvironment {
+	return NetworkEnvironment{
+		SSID:       detectSSID(),
+		GatewayMAC: detectGatewayMAC(),
+		Addresses:  interfaces.Addresses(),
+	}
+}
+
+// commandOutput runs a command and returns its trimmed output ("" on error)
+func commandOutput(name string, args ...string) string {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// detectSSID returns the SSID of the connected Wi-Fi network, or "" if unknown
+func detectSSID() string {
+	switch runtime.GOOS {
+	case "linux":
+		if ssid := commandOutput("iwgetid", "-r"); ssid != "" {
+			return ssid
+		}
+		for _, line := range strings.Split(commandOutput("nmcli", "-t", "-f", "active,ssid", "dev", "wifi"), "\n") {
+			if strings.HasPrefix(line, "yes:") {
+				return strings.TrimPrefix(line, "yes:")
+			}
+		}
+	case "darwin":
+		out := commandOutput("networksetup", "-getairportnetwork", "en0")
+		if i := strings.Index(out, "Network: "); i >= 0 {
+			return strings.TrimSpace(out[i+len("Network: "):])
+		}
+	case "windows":
+		for _, line := range strings.Split(commandOutput("netsh", "wlan", "show", "interfaces"), "\n") {
+			fields := strings.SplitN(line, ":", 2)
+			if len(fields) == 2 && strings.TrimSpace(fields[0]) == "SSID" {
+				return strings.TrimSpace(fields[1])
+			}
+		}
+	}
+	return ""
+}
+
+// macPattern matches a MAC address in command output
+var macPattern = regexp.MustCompile(`(?i)([0-9a-f]{1,2}[:-]){5}[0-9a-f]{1,2}`)
+
+// detectGatewayMAC returns the MAC address of the default gateway, or "" if unknown
+func detectGatewayMAC() string {
+	gateway := detectDefaultGateway()
+	if gateway == "" {
+		return ""
+	}
+
+	if runtime.GOOS == "linux" {
+		f, err := os.Open("/proc/net/arp")
+		if err == nil {
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) >= 4 && fields[0] == gateway {
+					return strings.ToLower(fields[3])
+				}
+			}
+		}
+		return ""
+	}
+
+	mac := macPattern.FindString(commandOutput("arp", "-n", gateway))
+	if mac == "" {
+		mac = macPattern.FindString(commandOutput("arp", "-a", gateway))
+	}
+	return strings.ToLower(strings.ReplaceAll(mac, "-", ":"))
+}
+
+// detectDefaultGateway returns the IPv4 default gateway address, or "" if unknown
+func detectDefaultGateway() string {
+	switch runtime.GOOS {
+	case "linux":
+		f, err := os.Open("/proc/net/route")
+		if err != nil {
+			return ""
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			// Destination 00000000 is the default route; gateway is little-endian hex
+			if len(fields) >= 3 && fields[1] == "00000000" && len(fields[2]) == 8 {
+				var b [4]byte
+				if _, err := fmt.Sscanf(fields[2], "%02x%02x%02x%02x", &b[3], &b[2], &b[1], &b[0]); err == nil {
+					return net.IPv4(b[0], b[1], b[2], b[3]).String()
+				}
+			}
+		}
+	case "darwin", "freebsd", "openbsd", "netbsd":
+		for _, line := range strings.Split(commandOutput("route", "-n", "get", "default"), "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "gateway:" {
+				return fields[1]
+			}
+		}
+	case "windows":
+		out := commandOutput("powershell", "-NoProfile", "-Command",
+			"(Get-NetRoute -DestinationPrefix 0.0.0.0/0 | Sort-Object RouteMetric | Select-Object -First 1).NextHop")
+		return out
+	}
+	return ""
+}
+
+// selectNetworkProfile applies the first matching network profile to config.
+// Returns false if profiles are configured but none matches (nothing should be published).
+func selectNetworkProfile(config *Config) bool {
+	if len(config.NetworkProfiles) == 0 {
+		return true
+	}
+
+	env := detectNetworkEnvironment(config.interfaceSnapshot())
+	log.Printf("Network environment: SSID=%q gateway MAC=%q", env.SSID, env.GatewayMAC)
+
+	profile := matchNetworkProfile(config.NetworkProfiles, env)
+	if profile == nil {
+		log.Println("No network profile matches the current network - not publishing any records")
+		return false
+	}
+
+	log.Printf("Active network profile: %s (domains: %s)", profile.Name, strings.Join(profile.Domains, ", "))
+	applyNetworkProfile(config, profile)
+	return true
+}
+
+// cycleConfig returns the config of one daemon cycle: with network profiles, a copy
+// restricted to the domains of the profile matching the network now, or nil if none
+// matches. The daemon's config keeps every domain, so a later cycle on another network
+// selects that network's profile.
+func cycleConfig(config *Config) *Config {
+	if len(config.NetworkProfiles) == 0 {
+		return config
+	}
+	config.interfaceSnapshot() // The copy shares the interface scanner and its cache
+	cycle := *config
+	if !selectNetworkProfile(&cycle) {
+		return nil
+	}
+	return &cycle
+}
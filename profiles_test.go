@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// TestMatchNetworkProfile verifies SSID, gateway MAC and subnet matching with first-match-wins ordering
+func TestMatchNetworkProfile(t *testing.T) {
+	profiles := []NetworkProfile{
+		{Name: "home", SSIDs: []string{"HomeWifi"}, GatewayMACs: []string{"aa:bb:cc:dd:ee:ff"}},
+		{Name: "office", Subnets: []string{"10.50.0.0/16"}},
+	}
+
+	tests := []struct {
+		name     string
+		env      NetworkEnvironment
+		expected string
+	}{
+		{"ssid", NetworkEnvironment{SSID: "HomeWifi"}, "home"},
+		{"gateway mac case-insensitive", NetworkEnvironment{GatewayMAC: "AA:BB:CC:DD:EE:FF"}, "home"},
+		{"subnet", NetworkEnvironment{Addresses: []netip.Addr{netip.MustParseAddr("10.50.3.4")}}, "office"},
+		{"no match", NetworkEnvironment{SSID: "CoffeeShop", Addresses: []netip.Addr{netip.MustParseAddr("192.168.7.2")}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := matchNetworkProfile(profiles, tt.env)
+			name := ""
+			if profile != nil {
+				name = profile.Name
+			}
+			if name != tt.expected {
+				t.Errorf("Expected profile %q, got %q", tt.expected, name)
+			}
+		})
+	}
+}
+
+// TestApplyNetworkProfile verifies that only enabled domain classes and names remain configured
+func TestApplyNetworkProfile(t *testing.T) {
+	config := &Config{
+		InternalDomain: "laptop.i.home.example",
+		ExternalDomain: "laptop.e.home.example",
+		CombinedDomain: "laptop.home.example",
+		CustomIPv4Ranges: []CustomIPRange{
+			{CIDR: "100.64.0.0/10", Domain: "laptop.ts.example", Type: "A"},
+			{CIDR: "10.20.0.0/16", Domain: "laptop.wg.example", Type: "A"},
+		},
+	}
+
+	applyNetworkProfile(config, &NetworkProfile{Domains: []string{"internal", "ipv4_range_1", "laptop.wg.example"}})
+
+	if config.InternalDomain != "laptop.i.home.example" {
+		t.Errorf("Expected internal domain to be kept, got %q", config.InternalDomain)
+	}
+	if config.ExternalDomain != "" || config.CombinedDomain != "" {
+		t.Errorf("Expected external and combined domains to be removed, got %q and %q", config.ExternalDomain, config.CombinedDomain)
+	}
+	if len(config.CustomIPv4Ranges) != 2 {
+		t.Errorf("Expected both custom ranges to be kept (by class and by name), got %d", len(config.CustomIPv4Ranges))
+	}
+}
+
+// TestCycleConfig verifies that each daemon cycle selects the profile of the current network
+// on a copy, leaving the daemon's config with every domain
+func TestCycleConfig(t *testing.T) {
+	source := &fakeInterfaces{addrs: []interfaceAddr{{Addr: netip.MustParseAddr("10.50.3.4"), Interface: "eth0"}}}
+	config := &Config{
+		ExternalDomain:   "laptop.e.home.example",
+		NetworkProfiles:  []NetworkProfile{{Name: "office", Subnets: []string{"10.50.0.0/16"}, Domains: []string{"ipv4_range_1"}}},
+		CustomIPv4Ranges: []CustomIPRange{testRange("10.50.0.0/16", "laptop.office.example")},
+		interfaces:       newInterfaceScanner(source, time.Nanosecond),
+	}
+
+	cycle := cycleConfig(config)
+	if cycle == nil || cycle == config || cycle.ExternalDomain != "" || len(cycle.CustomIPv4Ranges) != 1 {
+		t.Fatalf("office cycle = %+v", cycle)
+	}
+	if config.ExternalDomain == "" || len(config.CustomIPv4Ranges) != 1 {
+		t.Errorf("daemon config changed: %+v", config)
+	}
+
+	source.addrs = []interfaceAddr{{Addr: netip.MustParseAddr("192.168.7.2"), Interface: "wlan0"}}
+	if cycle := cycleConfig(config); cycle != nil {
+		t.Errorf("cycle on an unknown network = %+v, want none", cycle)
+	}
+
+	config.NetworkProfiles = nil
+	if cycle := cycleConfig(config); cycle != config {
+		t.Error("without profiles, the cycle did not use the daemon's config")
+	}
+}
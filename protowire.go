@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Protocol buffers wire format, as far as the messages of plugin/dnsprovider.proto and
+// api/events.proto need it: varints (int32, int64, bool), length-delimited fields (string,
+// embedded and repeated messages, map entries) and skipping the fixed-width fields of a
+// newer peer. Proto3 leaves fields holding their
+// default value out of the encoding, and so does protoAppend*.
+
+// Wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// protoField is one field of a decoded message
+type protoField struct {
+	Num    int
+	Varint uint64 // Value of a varint field
+	Bytes  []byte // Value of a length-delimited field
+}
+
+// protoAppendTag appends the key of a field
+func protoAppendTag(b []byte, num, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(wireType))
+}
+
+// protoAppendString appends a string field (nothing if empty)
+func protoAppendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protoAppendTag(b, num, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// protoAppendInt appends an int32 field (nothing if 0); negative values take ten bytes,
+// as in the proto encoding of int32
+func protoAppendInt(b []byte, num int, v int) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protoAppendTag(b, num, protoVarint)
+	return binary.AppendUvarint(b, uint64(int64(int32(v))))
+}
+
+// protoAppendInt64 appends an int64 field (nothing if 0)
+func protoAppendInt64(b []byte, num int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protoAppendTag(b, num, protoVarint)
+	return binary.AppendUvarint(b, uint64(v))
+}
+
+// protoAppendBool appends a bool field (nothing if false)
+func protoAppendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protoAppendTag(b, num, protoVarint)
+	return append(b, 1)
+}
+
+// protoAppendMessage appends an embedded message field, also when it is empty
+func protoAppendMessage(b []byte, num int, m []byte) []byte {
+	b = protoAppendTag(b, num, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(m)))
+	return append(b, m...)
+}
+
+// errProtoTruncated reports a message that ends inside a field
+var errProtoTruncated = errors.New("truncated protobuf message")
+
+// protoDecode splits a message into its varint and length-delimited fields; fixed-width
+// fields are skipped
+func protoDecode(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errProtoTruncated
+		}
+		data = data[n:]
+		field := protoField{Num: int(key >> 3)}
+		switch wireType := int(key & 7); wireType {
+		case protoVarint:
+			if field.Varint, n = binary.Uvarint(data); n <= 0 {
+				return nil, errProtoTruncated
+			}
+			data = data[n:]
+		case protoBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return nil, errProtoTruncated
+			}
+			field.Bytes, data = data[n:n+int(length)], data[n+int(length):]
+		case protoFixed64, protoFixed32:
+			size := 8
+			if wireType == protoFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return nil, errProtoTruncated
+			}
+			data = data[size:]
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
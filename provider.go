@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Typed provider errors. Record operations wrap these (with %w) so callers can tell the
+// failures apart with errors.Is; errProviderUnreachable covers network failures.
+var (
+	errProviderAuth        = errors.New("provider rejected the credentials")
+	errProviderRateLimited = errors.New("provider rate limit exceeded")
+	errRecordNotFound      = errors.New("record not found")
+	errDuplicateRecord     = errors.New("record already exists")
+)
+
+// retryable reports whether a failed operation may succeed when simply tried again later
+func retryable(err error) bool {
+	return errors.Is(err, errProviderUnreachable) || errors.Is(err, errProviderRateLimited)
+}
+
+// statusError returns the error for an unsuccessful API response, wrapping the typed error
+// the HTTP status stands for. The message (the API's own error text) may be empty.
+func statusError(status int, message string) error {
+	var kind error
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		kind = errProviderAuth
+	case http.StatusTooManyRequests:
+		kind = errProviderRateLimited
+	}
+	switch {
+	case kind != nil && message != "":
+		return fmt.Errorf("%w (status %d: %s)", kind, status, message)
+	case kind != nil:
+		return fmt.Errorf("%w (status %d)", kind, status)
+	case message != "":
+		return fmt.Errorf("status %d: %s", status, message)
+	}
+	return fmt.Errorf("status %d", status)
+}
+
+// succeeded logs a failed record operation and reports whether it succeeded
+func succeeded(err error) bool {
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return false
+	}
+	return true
+}
+
+// recordHooks are optional callbacks invoked by a provider's record operations
+type recordHooks struct {
+	// OnChange is called after every successful create/update/delete (optional)
+	OnChange func(action, recordType, name, content string)
+
+	// OnUnreachable is called when a create/update/delete fails because the API
+	// could not be reached at all (network error, not an API error response)
+	OnUnreachable func(action, recordType, name, content, recordID string)
+}
+
+// hooks gives providerClient users access to the hooks
+func (h *recordHooks) hooks() *recordHooks {
+	return h
+}
+
+// notifyChange invokes the OnChange hook if one is set
+func (h *recordHooks) notifyChange(action, recordType, name, content string) {
+	if h.OnChange != nil {
+		h.OnChange(action, recordType, name, content)
+	}
+}
+
+// notifyUnreachable invokes the OnUnreachable hook if one is set
+func (h *recordHooks) notifyUnreachable(action, recordType, name, content, recordID string) {
+	if h.OnUnreachable != nil {
+		h.OnUnreachable(action, recordType, name, content, recordID)
+	}
+}
+
+// lookupFailed passes on the error of the lookup before a create or update, reporting
+// the create through the OnUnreachable hook when the API could not be reached
+func (h *recordHooks) lookupFailed(err error, recordType, name, content string) error {
+	if errors.Is(err, errProviderUnreachable) {
+		h.notifyUnreachable("create", recordType, name, content, "")
+	}
+	return err
+}
+
+// providerClient is what the update, queue and cleanup logic needs from a DNS provider
+type providerClient interface {
+	CloudFlareAPI
+	getAllRecordsByType(recordType string) ([]CFRecord, error)
+	upsertHeartbeat(name, content string) error
+	probeConnectivity() bool
+	hooks() *recordHooks
+}
+
+var _ providerClient = (*CloudFlareClient)(nil)
+
+// heartbeatBuffer is implemented by providers that hold heartbeats back to send them along
+// with other requests; flushHeartbeats sends the rest at the end of a run and returns how
+// many were sent
+type heartbeatBuffer interface {
+	flushHeartbeats() (int, error)
+}
+
+// Supported values for BEES_IP_UPDATE_PROVIDER
+const (
+	providerCloudFlare = "cloudflare"
+	providerRoute53    = "route53"
+	providerAzure      = "azure"
+	providerRFC2136    = "rfc2136"
+	providerDynDNS2    = "dyndns2"
+	providerPorkbun    = "porkbun"
+	providerNS1        = "ns1"
+	providerDynv6      = "dynv6"
+	providerExec       = "exec"
+	providerWebhook    = "webhook"
+	providerHosts      = "hosts"
+	providerEtcd       = "etcd"
+	providerZoneFile   = "zonefile"
+	providerGRPC       = "grpc"
+)
+
+// newProviderClient creates the client for the configured DNS provider
+func newProviderClient(config *Config) (providerClient, error) {
+	switch config.Provider {
+	case providerRoute53:
+		return newRoute53Provider(config)
+	case providerAzure:
+		return newAzureDNSProvider(config)
+	case providerRFC2136:
+		return newRFC2136Provider(config)
+	case providerPorkbun:
+		return newPorkbunProvider(config)
+	case providerNS1:
+		return newNS1Provider(config)
+	case providerDynv6:
+		return newDynv6Provider(config)
+	case providerExec:
+		return newExecProvider(config)
+	case providerWebhook:
+		return newWebhookProvider(config)
+	case providerHosts:
+		return newHostsFileProvider(config)
+	case providerEtcd:
+		return newEtcdProvider(config)
+	case providerZoneFile:
+		return newZoneFileProvider(config)
+	case providerRelay:
+		return newRelayProvider(config)
+	case providerGRPC:
+		return newGRPCProvider(config)
+	case providerDynDNS2:
+		warnUnsupportedOptions(config, "DynDNS2")
+		if config.InternalDomain != "" || config.CombinedDomain != "" || config.TopLevelDomain != "" ||
+			len(config.CustomIPv4Ranges) > 0 || len(config.CustomIPv6Ranges) > 0 {
+			log.Printf("WARNING: DynDNS2 services hold one address per hostname - only %sEXTERNAL_DOMAIN and %sIPV6_DOMAIN are published reliably", envPrefix, envPrefix)
+		}
+		return newDynDNS2Provider(config)
+	}
+
+	cf := &CloudFlareClient{
+		APIToken:     config.CFAPIToken,
+		vault:        config.vault,
+		ZoneID:       config.CFZoneID,
+		BaseURL:      "https://api.cloudflare.com/client/v4",
+		Tags:         config.RecordTags,
+		TTL:          config.RecordTTL,
+		HeartbeatTTL: config.HeartbeatTTL,
+		NameTTLs:     config.DomainTTLs,
+		Limiter:      newRateLimiter(config.APIRateLimit),
+		Retry:        newRetryPolicy(config),
+		Faults:       config.FaultInjectionPercent,
+
+		VerifyDeletes: newDeleteVerifier(config),
+	}
+	cf.client = cf.transportStack().client()
+
+	// Partial (CNAME setup) and secondary zones restrict what can be published
+	if err := setupZones(cf, config); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+// warnUnsupportedOptions warns about CloudFlare-only settings for other providers
+func warnUnsupportedOptions(config *Config, provider string) {
+	if config.anyProxied() {
+		log.Printf("WARNING: %sCF_PROXIED has no effect with %s - records are always DNS only", envPrefix, provider)
+	}
+	if len(config.RecordTags) > 0 {
+		log.Printf("WARNING: %sRECORD_TAGS has no effect with %s - record sets cannot be tagged", envPrefix, provider)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		status    int
+		kind      error
+		retryable bool
+	}{
+		{http.StatusUnauthorized, errProviderAuth, false},
+		{http.StatusForbidden, errProviderAuth, false},
+		{http.StatusTooManyRequests, errProviderRateLimited, true},
+		{http.StatusBadRequest, nil, false},
+	}
+	for _, tt := range tests {
+		err := statusError(tt.status, "message")
+		for _, kind := range []error{errProviderAuth, errProviderRateLimited, errRecordNotFound, errDuplicateRecord} {
+			if errors.Is(err, kind) != (kind == tt.kind) {
+				t.Errorf("statusError(%d) = %v, errors.Is(%v) = %v", tt.status, err, kind, !(kind == tt.kind))
+			}
+		}
+		if retryable(err) != tt.retryable {
+			t.Errorf("retryable(statusError(%d)) = %v", tt.status, !tt.retryable)
+		}
+	}
+	if !retryable(errProviderUnreachable) {
+		t.Error("unreachable API not retryable")
+	}
+}
+
+// TestCloudFlareTypedErrors verifies CloudFlare failures are typed by error code and status
+func TestCloudFlareTypedErrors(t *testing.T) {
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && status == http.StatusOK {
+			w.Write([]byte(`{"success":true,"errors":[],"result":[]}`))
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	cf := &CloudFlareClient{APIToken: "test-token", ZoneID: "zone", BaseURL: server.URL}
+
+	status, body = http.StatusForbidden, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`
+	if _, err := cf.getAllRecords("nas.example.com", "A"); !errors.Is(err, errProviderAuth) {
+		t.Errorf("getAllRecords with a bad token = %v", err)
+	}
+	status, body = http.StatusTooManyRequests, `rate limited`
+	if err := cf.upsertRecord("nas.example.com", "A", "203.0.113.1", false); !errors.Is(err, errProviderRateLimited) || !retryable(err) {
+		t.Errorf("upsertRecord when rate limited = %v", err)
+	}
+
+	// Lists succeed from here on; only the writes fail
+	status, body = http.StatusOK, `{"success":false,"errors":[{"code":81058,"message":"An identical record already exists."}]}`
+	if err := cf.createRecord("nas.example.com", "A", "203.0.113.1", false); !errors.Is(err, errDuplicateRecord) {
+		t.Errorf("createRecord of a duplicate = %v", err)
+	}
+	body = `{"success":false,"errors":[{"code":81044,"message":"Record does not exist."}]}`
+	if err := cf.deleteRecord("rec1", "nas.example.com", "A"); !errors.Is(err, errRecordNotFound) {
+		t.Errorf("deleteRecord of a missing record = %v", err)
+	}
+	if _, err := cf.getRecord("nas.example.com", "A"); err != nil {
+		t.Errorf("getRecord of an absent record = %v, want no error", err)
+	}
+
+	server.Close()
+	if _, err := cf.getAllRecords("nas.example.com", "A"); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("getAllRecords with the API down = %v", err)
+	}
+}
+
+func TestCloudFlareListPagination(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	const total = 2*cfListPageSize + 7
+	for i := 0; i < total; i++ {
+		fake.add("TXT", fmt.Sprintf("host%d.example.com", i), "1705320000")
+	}
+	fake.add("A", "host0.example.com", "203.0.113.1")
+
+	records, err := cf.getAllRecordsByType("TXT")
+	if err != nil || len(records) != total {
+		t.Fatalf("getAllRecordsByType(TXT) = %d records, %v; want %d", len(records), err, total)
+	}
+	seen := make(map[string]bool)
+	for _, r := range records {
+		if seen[r.ID] {
+			t.Fatalf("record %s listed twice", r.ID)
+		}
+		seen[r.ID] = true
+	}
+	if records, err := cf.getAllRecords("host0.example.com", "A"); err != nil || len(records) != 1 {
+		t.Errorf("getAllRecords(host0 A) = %+v, %v", records, err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"net/netip"
+	"strings"
+)
+
+// Proxying. BEES_IP_UPDATE_CF_PROXIED is the default for every name and per-domain settings
+// (EXTERNAL_DOMAIN_PROXIED, IPV4_RANGE_N_PROXIED, ...) override it. Private, loopback,
+// link-local and shared (CGNAT) addresses are never proxied whatever the setting: CloudFlare's
+// edge cannot reach them, so proxying them would only break the records on the LAN.
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598)
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// parseProxiedOverride reads a per-domain proxied setting, returning nil if it is not set
+// or invalid
+func parseProxiedOverride(key string) *bool {
+	var proxied bool
+	switch value := strings.ToLower(getEnv(key)); value {
+	case "":
+		return nil
+	case "true":
+		proxied = true
+	case "false":
+	default:
+		log.Printf("WARNING: %s%s must be true or false, got %q - using %sCF_PROXIED", envPrefix, key, value, envPrefix)
+		return nil
+	}
+	return &proxied
+}
+
+// parseDomainProxied collects the per-name proxied overrides: INTERNAL_DOMAIN_PROXIED and the
+// like for the domain classes, IPV4_RANGE_N_PROXIED and IPV6_RANGE_N_PROXIED for custom ranges
+func parseDomainProxied(config *Config) map[string]bool {
+	overrides := make(map[string]bool)
+	set := func(domain string, proxied *bool) {
+		if domain != "" && proxied != nil {
+			overrides[normalizeName(domain)] = *proxied
+		}
+	}
+	set(config.InternalDomain, parseProxiedOverride("INTERNAL_DOMAIN_PROXIED"))
+	set(config.ExternalDomain, parseProxiedOverride("EXTERNAL_DOMAIN_PROXIED"))
+	set(config.IPv6Domain, parseProxiedOverride("IPV6_DOMAIN_PROXIED"))
+	set(config.CombinedDomain, parseProxiedOverride("COMBINED_DOMAIN_PROXIED"))
+	set(config.TopLevelDomain, parseProxiedOverride("TOP_LEVEL_DOMAIN_PROXIED"))
+	for _, r := range append(append([]CustomIPRange{}, config.CustomIPv4Ranges...), config.CustomIPv6Ranges...) {
+		set(r.Domain, r.Proxied)
+	}
+	if len(overrides) == 0 {
+		return nil
+	}
+	return overrides
+}
+
+// proxiedName reports whether the records at name are configured to be proxied
+func (c *Config) proxiedName(name string) bool {
+	if proxied, ok := c.DomainProxied[normalizeName(name)]; ok {
+		return proxied
+	}
+	return c.Proxied
+}
+
+// proxiedFor reports whether the record at name with the given content is published proxied
+func (c *Config) proxiedFor(name, content string) bool {
+	return proxiedContent(c.proxiedName(name), content)
+}
+
+// anyProxied reports whether any record may be published proxied
+func (c *Config) anyProxied() bool {
+	for _, proxied := range c.DomainProxied {
+		if proxied {
+			return true
+		}
+	}
+	return c.Proxied
+}
+
+// proxiedContent applies a name's proxied setting to one record: addresses CloudFlare's edge
+// cannot reach are published DNS only. Aliases follow the setting.
+func proxiedContent(proxied bool, content string) bool {
+	addr, err := parseAddr(content)
+	if err != nil {
+		return proxied
+	}
+	return proxied && !addr.IsPrivate() && !addr.IsLoopback() && !addr.IsLinkLocalUnicast() &&
+		!addr.IsUnspecified() && !sharedAddressSpace.Contains(addr)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDomainProxied(t *testing.T) {
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN_PROXIED", "true")
+	t.Setenv(envPrefix+"COMBINED_DOMAIN_PROXIED", "yes") // Invalid: falls back to CF_PROXIED
+	t.Setenv(envPrefix+"IPV6_RANGE_1", "2001:db8:1::/48")
+	t.Setenv(envPrefix+"IPV6_RANGE_1_DOMAIN", "lab.example.com")
+	t.Setenv(envPrefix+"IPV6_RANGE_1_PROXIED", "false")
+
+	config := &Config{
+		ExternalDomain:   "nas.e.example.com",
+		CombinedDomain:   "nas.example.com",
+		Proxied:          true,
+		CustomIPv6Ranges: parseCustomRanges("IPV6_RANGE", "AAAA", 20),
+	}
+	config.DomainProxied = parseDomainProxied(config)
+
+	for name, want := range map[string]bool{
+		"nas.e.example.com": true,
+		"nas.example.com":   true,
+		"lab.example.com":   false,
+	} {
+		if got := config.proxiedName(name); got != want {
+			t.Errorf("proxiedName(%s) = %t, want %t", name, got, want)
+		}
+	}
+	config.Proxied = false
+	if config.proxiedName("nas.example.com") || !config.anyProxied() {
+		t.Error("names without an override should follow CF_PROXIED, and an override should count as proxied")
+	}
+}
+
+func TestProxiedContent(t *testing.T) {
+	for content, want := range map[string]bool{
+		"203.0.113.1":      true,
+		"2001:db8::1":      true,
+		"home.example.com": true, // Aliases follow the setting
+		"192.168.1.10":     false,
+		"10.0.0.1":         false,
+		"100.64.0.1":       false,
+		"127.0.0.1":        false,
+		"169.254.0.1":      false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+	} {
+		if got := proxiedContent(true, content); got != want {
+			t.Errorf("proxiedContent(true, %s) = %t, want %t", content, got, want)
+		}
+		if proxiedContent(false, content) {
+			t.Errorf("proxiedContent(false, %s) = true, want false", content)
+		}
+	}
+}
+
+func TestPerDomainProxiedApplied(t *testing.T) {
+	for _, concurrency := range []int{0, 4} {
+		fake, cf := newFakeCloudFlare(t)
+		config := &Config{
+			InternalDomain:    "nas.i.example.com",
+			ExternalDomain:    "nas.e.example.com",
+			CombinedDomain:    "nas.example.com",
+			Proxied:           true,
+			DomainProxied:     map[string]bool{"nas.e.example.com": false},
+			UpdateConcurrency: concurrency,
+		}
+		ips := &IPAddresses{InternalIPv4: []string{"192.168.1.10"}, ExternalIPv4: "203.0.113.1"}
+		reconcileRecords(cf, config, ips, time.Now())
+
+		proxied := func() map[string]bool {
+			result := make(map[string]bool)
+			for _, record := range fake.records {
+				if record.Type == "A" {
+					result[record.Name+" "+record.Content] = record.Proxied
+				}
+			}
+			return result
+		}
+		want := map[string]bool{
+			"nas.i.example.com 192.168.1.10": false, // Private: never proxied
+			"nas.e.example.com 203.0.113.1":  false, // Overridden
+			"nas.example.com 192.168.1.10":   false,
+			"nas.example.com 203.0.113.1":    true,
+		}
+		got := proxied()
+		for record, wantProxied := range want {
+			if gotProxied, ok := got[record]; !ok || gotProxied != wantProxied {
+				t.Errorf("concurrency %d: %s proxied = %t (present %t), want %t", concurrency, record, gotProxied, ok, wantProxied)
+			}
+		}
+
+		// Changing an override rewrites the records in place
+		config.DomainProxied = map[string]bool{"nas.example.com": false}
+		reconcileRecords(cf, config, ips, time.Now())
+		got = proxied()
+		if !got["nas.e.example.com 203.0.113.1"] || got["nas.example.com 203.0.113.1"] {
+			t.Errorf("concurrency %d: after changing the overrides got %v", concurrency, got)
+		}
+	}
+}
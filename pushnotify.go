@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Push notifications, for phones without a chat platform:
+//
+//   - ntfy: NOTIFY_NTFY_URL, the topic URL (https://ntfy.sh/<topic> or a self-hosted server),
+//     with NOTIFY_NTFY_TOKEN for a protected topic
+//   - Pushover: NOTIFY_PUSHOVER_TOKEN (the application's API token) and NOTIFY_PUSHOVER_USER
+//     (the user or group key)
+//
+// They receive the same notifications as the chat notifiers, routine events selected by
+// NOTIFY_EVENTS. update-failed is sent with high priority, so it is not silenced with the rest.
+
+// Pushover message API, and the longest messages ntfy and Pushover accept
+const (
+	pushoverAPIURL     = "https://api.pushover.net/1/messages.json"
+	pushoverMaxMessage = 1024
+	pushoverMaxTitle   = 250
+	ntfyMaxMessage     = 4096
+)
+
+// NtfyNotifier publishes notifications to an ntfy topic
+type NtfyNotifier struct {
+	URL   string // Topic URL
+	Token string // Access token of a protected topic (optional)
+}
+
+func (n *NtfyNotifier) Notify(event, subject, message string) error {
+	req, err := http.NewRequest("POST", n.URL, strings.NewReader(truncateMessage(message, ntfyMaxMessage)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", subject)
+	req.Header.Set("Tags", event)
+	if pushHighPriority(event) {
+		req.Header.Set("Priority", "high")
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return sendPushRequest(req)
+}
+
+// PushoverNotifier sends notifications through Pushover
+type PushoverNotifier struct {
+	APIURL string // Message API URL (pushoverAPIURL)
+	Token  string
+	User   string
+}
+
+func (p *PushoverNotifier) Notify(event, subject, message string) error {
+	form := url.Values{
+		"token":   {p.Token},
+		"user":    {p.User},
+		"title":   {truncateMessage(subject, pushoverMaxTitle)},
+		"message": {truncateMessage(message, pushoverMaxMessage)},
+	}
+	if pushHighPriority(event) {
+		form.Set("priority", "1")
+	}
+	req, err := http.NewRequest("POST", p.APIURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return sendPushRequest(req)
+}
+
+// pushHighPriority reports whether an event is pushed with high priority
+func pushHighPriority(event string) bool {
+	return event == notifyEventUpdateFailed
+}
+
+// pushNotifiers returns the configured push notifiers
+func pushNotifiers(config *Config) []Notifier {
+	var notifiers []Notifier
+	if config.NotifyNtfyURL != "" {
+		notifiers = append(notifiers, &NtfyNotifier{URL: config.NotifyNtfyURL, Token: config.NotifyNtfyToken})
+	}
+	if config.NotifyPushoverToken != "" {
+		notifiers = append(notifiers, &PushoverNotifier{APIURL: pushoverAPIURL, Token: config.NotifyPushoverToken, User: config.NotifyPushoverUser})
+	}
+	return notifiers
+}
+
+// sendPushRequest sends a push notification request
+func sendPushRequest(req *http.Request) error {
+	client := &http.Client{Timeout: chatNotifierTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The topic URL is as good as a password: report the host only
+		return fmt.Errorf("%s: request failed", req.URL.Host)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned status %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// validatePushNotifiers checks the push notifier settings
+func validatePushNotifiers(config *Config) {
+	if config.NotifyPushoverToken != "" && config.NotifyPushoverUser == "" {
+		configFatalf("ERROR: %sNOTIFY_PUSHOVER_TOKEN needs %sNOTIFY_PUSHOVER_USER", envPrefix, envPrefix)
+	}
+	if config.NotifyNtfyURL != "" && !strings.HasPrefix(config.NotifyNtfyURL, "https://") && !strings.HasPrefix(config.NotifyNtfyURL, "http://") {
+		configFatalf("ERROR: %sNOTIFY_NTFY_URL must be an http or https URL of the topic, e.g. https://ntfy.sh/my-topic", envPrefix)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNtfyNotifier(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got, body = r, string(data)
+	}))
+	defer srv.Close()
+
+	n := &NtfyNotifier{URL: srv.URL + "/dns-alerts", Token: "tk_secret"}
+	if err := n.Notify(notifyEventUpdateFailed, "nas.example.com: DNS update failed", "1 of 3 record operations failed"); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/dns-alerts" || body != "1 of 3 record operations failed" {
+		t.Errorf("published %q to %s", body, got.URL.Path)
+	}
+	for header, want := range map[string]string{
+		"Title":         "nas.example.com: DNS update failed",
+		"Tags":          notifyEventUpdateFailed,
+		"Priority":      "high",
+		"Authorization": "Bearer tk_secret",
+	} {
+		if got.Header.Get(header) != want {
+			t.Errorf("%s = %q, want %q", header, got.Header.Get(header), want)
+		}
+	}
+
+	if err := (&NtfyNotifier{URL: srv.URL + "/dns-alerts"}).Notify(notifyEventIPChange, "s", "m"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Header.Get("Priority") != "" || got.Header.Get("Authorization") != "" {
+		t.Errorf("ip-change headers = %v", got.Header)
+	}
+}
+
+func TestPushoverNotifier(t *testing.T) {
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		form = r.PostForm
+		if form.Get("user") == "" {
+			http.Error(w, `{"status":0,"errors":["user identifier is invalid"]}`, http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	p := &PushoverNotifier{APIURL: srv.URL, Token: "app-token", User: "user-key"}
+	if err := p.Notify(notifyEventCleanupDeleted, "Cleanup deleted 2 record(s)", strings.Repeat("x", 2000)); err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("token") != "app-token" || form.Get("title") != "Cleanup deleted 2 record(s)" || form.Get("priority") != "" {
+		t.Errorf("form = %v", form)
+	}
+	if n := len([]rune(form.Get("message"))); n != pushoverMaxMessage {
+		t.Errorf("message of %d characters, want %d", n, pushoverMaxMessage)
+	}
+
+	p.User = ""
+	if err := p.Notify(notifyEventUpdateFailed, "s", "m"); err == nil || !strings.Contains(err.Error(), "user identifier is invalid") {
+		t.Errorf("err = %v", err)
+	}
+	if form.Get("priority") != "1" {
+		t.Errorf("update-failed priority = %q, want 1", form.Get("priority"))
+	}
+}
+
+func TestPushNotifierSettings(t *testing.T) {
+	t.Setenv(envPrefix+"CF_API_TOKEN", "token")
+	t.Setenv(envPrefix+"CF_ZONE_ID", "zone")
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+	t.Setenv(envPrefix+"NOTIFY_PUSHOVER_TOKEN", "app-token")
+
+	if _, err := reloadConfig(false); err == nil || !strings.Contains(err.Error(), "NOTIFY_PUSHOVER_USER") {
+		t.Errorf("token without user: err = %v", err)
+	}
+	t.Setenv(envPrefix+"NOTIFY_PUSHOVER_USER", "user-key")
+	t.Setenv(envPrefix+"NOTIFY_NTFY_URL", "https://ntfy.sh/dns-alerts")
+	config, err := reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notifiers, ok := newNotifier(config).(multiNotifier); !ok || len(notifiers) != 2 {
+		t.Errorf("notifier = %#v, want ntfy and Pushover", newNotifier(config))
+	}
+	if !config.notifies(notifyEventCleanupDeleted) || !isSecretSetting("NOTIFY_NTFY_URL") {
+		t.Error("push notifiers do not receive routine events, or the topic URL is not masked")
+	}
+}
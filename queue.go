@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// PendingMutation is a record change that could not be applied because the API was unreachable
+type PendingMutation struct {
+	Action   string `json:"action"` // "create", "update" or "delete"
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Content  string `json:"content,omitempty"`
+	RecordID string `json:"record_id,omitempty"`
+	QueuedAt int64  `json:"queued_at"`
+}
+
+func (m PendingMutation) String() string {
+	if m.Content != "" {
+		return fmt.Sprintf("%s %s %s -> %s", m.Action, m.Type, m.Name, m.Content)
+	}
+	return fmt.Sprintf("%s %s %s", m.Action, m.Type, m.Name)
+}
+
+// newUnreachableRecorder returns a hook for recordHooks.OnUnreachable that queues mutations
+func newUnreachableRecorder(queue *[]PendingMutation) func(action, recordType, name, content, recordID string) {
+	return func(action, recordType, name, content, recordID string) {
+		*queue = append(*queue, PendingMutation{
+			Action:   action,
+			Type:     recordType,
+			Name:     name,
+			Content:  content,
+			RecordID: recordID,
+			QueuedAt: time.Now().Unix(),
+		})
+	}
+}
+
+// probeConnectivity reports whether the CloudFlare API answers at all
+func (cf *CloudFlareClient) probeConnectivity() bool {
+	resp, err := cf.makeRequest("GET", fmt.Sprintf("/zones/%s", cf.ZoneID), nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// waitForConnectivity polls the API with exponential backoff until it answers or timeout expires
+func waitForConnectivity(cf providerClient, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	delay := 5 * time.Second
+
+	for {
+		if cf.probeConnectivity() {
+			return true
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return false
+		}
+		log.Printf("CloudFlare API still unreachable - retrying in %s", delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > time.Minute {
+			delay = time.Minute
+		}
+	}
+}
+
+// isDesired reports whether content is still a desired address for name/type
+func isDesired(desired map[string]map[string][]string, name, recordType, content string) bool {
+	for _, address := range desired[name][recordType] {
+		if sameContent(address, content) {
+			return true
+		}
+	}
+	return false
+}
+
+// replayPendingMutations re-applies queued mutations after re-checking each one against
+// freshly detected addresses and the zone's current contents. Mutations that no longer
+// match the desired state (the IP changed again while offline) are dropped.
+// Returns the mutations that still could not be applied.
+func replayPendingMutations(cf providerClient, config *Config, queue []PendingMutation, ips *IPAddresses) []PendingMutation {
+	desired := desiredAddresses(config, ips)
+	var remaining []PendingMutation
+
+	for _, m := range queue {
+		var err error
+		switch {
+		case m.Type == "TXT" && !strings.HasPrefix(m.Name, "_changelog."):
+			// Heartbeats get a fresh timestamp rather than the one from when they were queued
+			err = cf.upsertHeartbeat(m.Name, heartbeatRecordContent(config, config.now()))
+
+		case m.Type == "TXT" || m.Type == "CNAME":
+			// Changelogs and aliases are not address-derived - always re-apply
+			err = cf.upsertRecord(m.Name, m.Type, m.Content, m.Type == "CNAME" && config.proxiedName(m.Name))
+
+		case m.Action == "delete":
+			// Only delete if the record still exists and its content is not desired again
+			var records []CFRecord
+			records, err = cf.getAllRecords(m.Name, m.Type)
+			for _, record := range records {
+				if record.ID == m.RecordID && !isDesired(desired, m.Name, m.Type, record.Content) {
+					err = cf.deleteRecord(record.ID, m.Name, m.Type)
+				}
+			}
+
+		case !isDesired(desired, m.Name, m.Type, m.Content):
+			log.Printf("Dropping queued change (superseded by current addresses): %s", m)
+			continue
+
+		case m.Action == "update":
+			err = cf.upsertRecord(m.Name, m.Type, m.Content, config.proxiedFor(m.Name, m.Content))
+
+		default:
+			err = cf.ensureRecordExists(m.Name, m.Type, m.Content, config.proxiedFor(m.Name, m.Content))
+		}
+
+		switch {
+		case err == nil:
+			log.Printf("Applied queued change: %s", m)
+		case retryable(err):
+			remaining = append(remaining, m)
+		default:
+			// Trying again would fail the same way; the next full update reconciles it
+			log.Printf("Dropping queued change %s: %v", m, err)
+		}
+	}
+
+	return remaining
+}
+
+// saveQueue persists the pending mutation queue in the state file (if configured)
+func saveQueue(config *Config, queue []PendingMutation) {
+	if config.StateFile == "" {
+		return
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, queue not saved: %v", err)
+		return
+	}
+	state.PendingMutations = queue
+	if err := state.save(config.StateFile); err != nil {
+		log.Printf("WARNING: Could not save state: %v", err)
+	}
+}
+
+// processOfflineQueue handles mutations that failed because the API was unreachable:
+// they are persisted, then retried as soon as the API answers again (up to the
+// configured retry window). Returns true if every queued mutation was eventually applied.
+func processOfflineQueue(cf providerClient, config *Config, queue []PendingMutation) bool {
+	log.Printf("CloudFlare API unreachable - queued %d change(s)", len(queue))
+	saveQueue(config, queue)
+
+	if config.QueueRetrySeconds <= 0 {
+		return false
+	}
+	if !waitForConnectivity(cf, time.Duration(config.QueueRetrySeconds)*time.Second) {
+		log.Printf("CloudFlare API still unreachable after %ds - %d change(s) remain queued", config.QueueRetrySeconds, len(queue))
+		return false
+	}
+
+	log.Println("CloudFlare API reachable again - re-detecting addresses and replaying queued changes")
+	cf.hooks().OnUnreachable = nil
+	remaining := replayPendingMutations(cf, config, queue, detectIPs(config))
+
+	saveQueue(config, remaining)
+	if len(remaining) > 0 {
+		log.Printf("%d queued change(s) could not be applied", len(remaining))
+		return false
+	}
+	return true
+}
+
+// replayQueue applies the changes queued by earlier cycles - or by earlier runs, kept in the
+// state file - at the start of a daemon cycle, once the provider answers again. Returns the
+// changes still queued.
+func replayQueue(cf providerClient, config *Config, queue []PendingMutation, ips *IPAddresses) []PendingMutation {
+	if config.StateFile != "" {
+		if state, err := loadState(config.StateFile); err == nil {
+			queue = state.PendingMutations
+		}
+	}
+	if len(queue) == 0 {
+		return nil
+	}
+	if !cf.probeConnectivity() {
+		log.Printf("DNS provider still unreachable - %d queued change(s) wait for the next cycle", len(queue))
+		return queue
+	}
+
+	log.Printf("Replaying %d queued change(s)", len(queue))
+	remaining := replayPendingMutations(cf, config, queue, ips)
+	saveQueue(config, remaining)
+	return remaining
+}
+
+// updateQueue returns (and saves) the queue after a daemon cycle's reconcile: the changes
+// it queued join those still queued, and a reconcile that reached the provider for every
+// change supersedes the rest
+func updateQueue(config *Config, queue, queued []PendingMutation) []PendingMutation {
+	if len(queued) == 0 {
+		if len(queue) > 0 {
+			log.Printf("Cleared %d queued change(s) (superseded by this update)", len(queue))
+			saveQueue(config, nil)
+		}
+		return nil
+	}
+
+	log.Printf("DNS provider unreachable - queued %d change(s) for the next cycle", len(queued))
+	for _, m := range queued {
+		if !containsMutation(queue, m) {
+			queue = append(queue, m)
+		}
+	}
+	saveQueue(config, queue)
+	return queue
+}
+
+// containsMutation reports whether queue already holds the change m (queued at any time)
+func containsMutation(queue []PendingMutation, m PendingMutation) bool {
+	for _, q := range queue {
+		q.QueuedAt = m.QueuedAt
+		if q == m {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestReplayPendingMutations verifies queued changes are re-checked against current addresses before being applied
+func TestReplayPendingMutations(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	staleID := fake.add("A", "host.example.com", "198.51.100.1")
+	fake.add("A", "host.i.example.com", "192.168.1.10")
+
+	config := &Config{ExternalDomain: "host.example.com", InternalDomain: "host.i.example.com"}
+	queue := []PendingMutation{
+		{Action: "update", Type: "A", Name: "host.example.com", Content: "203.0.113.1"},    // superseded
+		{Action: "update", Type: "A", Name: "host.example.com", Content: "203.0.113.2"},    // still desired
+		{Action: "create", Type: "A", Name: "host.i.example.com", Content: "192.168.1.10"}, // already present
+		{Action: "delete", Type: "A", Name: "host.example.com", RecordID: staleID},
+	}
+	ips := &IPAddresses{ExternalIPv4: "203.0.113.2", InternalIPv4: []string{"192.168.1.10"}}
+
+	remaining := replayPendingMutations(cf, config, queue, ips)
+
+	if len(remaining) != 0 {
+		t.Errorf("Expected all mutations to be resolved, %d remain", len(remaining))
+	}
+	if got := fake.contents("host.example.com", "A"); !reflect.DeepEqual(got, []string{"203.0.113.2"}) {
+		t.Errorf("Expected external record 203.0.113.2, got %v", got)
+	}
+	if got := fake.contents("host.i.example.com", "A"); !reflect.DeepEqual(got, []string{"192.168.1.10"}) {
+		t.Errorf("Expected internal record unchanged, got %v", got)
+	}
+}
+
+// TestReplayKeepsOnlyRetryableFailures verifies that queued changes failing for good are dropped
+func TestReplayKeepsOnlyRetryableFailures(t *testing.T) {
+	status := http.StatusTooManyRequests
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"success":false,"errors":[{"code":10000,"message":"error"}]}`))
+	}))
+	defer server.Close()
+	cf := &CloudFlareClient{APIToken: "test-token", ZoneID: "zone", BaseURL: server.URL}
+
+	config := &Config{ExternalDomain: "host.example.com"}
+	queue := []PendingMutation{{Action: "update", Type: "A", Name: "host.example.com", Content: "203.0.113.2"}}
+	ips := &IPAddresses{ExternalIPv4: "203.0.113.2"}
+
+	if remaining := replayPendingMutations(cf, config, queue, ips); len(remaining) != 1 {
+		t.Errorf("rate limited change: %d remain, want 1", len(remaining))
+	}
+	status = http.StatusForbidden
+	if remaining := replayPendingMutations(cf, config, queue, ips); len(remaining) != 0 {
+		t.Errorf("change rejected for its credentials: %d remain, want 0", len(remaining))
+	}
+}
+
+// TestDaemonQueue verifies that daemon cycles replay the persisted queue and keep what they
+// could not apply, once per change
+func TestDaemonQueue(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	config := &Config{ExternalDomain: "host.example.com", StateFile: filepath.Join(t.TempDir(), "state.json")}
+	change := PendingMutation{Action: "create", Type: "A", Name: "host.example.com", Content: "203.0.113.2", QueuedAt: 1700000000}
+	saveQueue(config, []PendingMutation{change})
+
+	ips := &IPAddresses{ExternalIPv4: "203.0.113.2"}
+	if remaining := replayQueue(cf, config, nil, ips); len(remaining) != 0 {
+		t.Errorf("%d change(s) remain after the replay", len(remaining))
+	}
+	if got := fake.contents("host.example.com", "A"); !reflect.DeepEqual(got, []string{"203.0.113.2"}) {
+		t.Errorf("records = %v", got)
+	}
+	pending := func() int {
+		state, err := loadState(config.StateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(state.PendingMutations)
+	}
+	if n := pending(); n != 0 {
+		t.Errorf("%d change(s) still persisted after the replay", n)
+	}
+
+	// A change queued again by a later cycle is kept once
+	again := change
+	again.QueuedAt++
+	queue := updateQueue(config, []PendingMutation{change}, []PendingMutation{again})
+	if len(queue) != 1 || pending() != 1 {
+		t.Errorf("queue = %v, %d persisted", queue, pending())
+	}
+
+	// A cycle that reached the provider supersedes the queue
+	if queue := updateQueue(config, queue, nil); queue != nil || pending() != 0 {
+		t.Errorf("queue = %v, %d persisted", queue, pending())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"net/netip"
+)
+
+// prefixTrie is a binary trie over address bits holding values at prefix nodes.
+// A lookup walks one path (at most 32 or 128 steps) regardless of how many prefixes
+// are stored, instead of testing the address against every prefix.
+type prefixTrie struct {
+	roots [2]*trieNode // IPv4, IPv6
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	values   []int // Values of prefixes ending at this node
+}
+
+// addrBit returns bit i (0 = most significant) of addr
+func addrBit(addr netip.Addr, i int) int {
+	b := addr.AsSlice()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+func familyIndex(addr netip.Addr) int {
+	if addr.Is4() {
+		return 0
+	}
+	return 1
+}
+
+// insert stores value at prefix, which must be masked
+func (t *prefixTrie) insert(prefix netip.Prefix, value int) {
+	root := &t.roots[familyIndex(prefix.Addr())]
+	if *root == nil {
+		*root = &trieNode{}
+	}
+	node := *root
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := addrBit(prefix.Addr(), i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.values = append(node.values, value)
+}
+
+// lookup calls visit with the value of every prefix containing addr, shortest first
+func (t *prefixTrie) lookup(addr netip.Addr, visit func(value int)) {
+	node := t.roots[familyIndex(addr)]
+	for i := 0; node != nil; i++ {
+		for _, v := range node.values {
+			visit(v)
+		}
+		if i == addr.BitLen() {
+			return
+		}
+		node = node.children[addrBit(addr, i)]
+	}
+}
+
+// rangeMatcher assigns interface addresses to the custom ranges containing them.
+// It is built once per configuration (see Config.customRangeMatcher).
+type rangeMatcher struct {
+	ranges []CustomIPRange
+	trie   prefixTrie
+}
+
+// newRangeMatcher indexes the ranges; ranges without a parsed prefix never match
+func newRangeMatcher(ranges []CustomIPRange) *rangeMatcher {
+	m := &rangeMatcher{ranges: ranges}
+	for i, r := range ranges {
+		if r.Prefix.IsValid() {
+			m.trie.insert(r.Prefix.Masked(), i)
+		}
+	}
+	return m
+}
+
+// match returns, for each range, the addresses it contains in interface order without
+// duplicates. Overlapping ranges each receive the address.
+func (m *rangeMatcher) match(addrs []interfaceAddr) [][]string {
+	found := make([][]string, len(m.ranges))
+	seen := make([]addrSet, len(m.ranges))
+	for _, a := range addrs {
+		m.trie.lookup(a.Addr, func(i int) {
+			if seen[i] == nil {
+				seen[i] = make(addrSet)
+			}
+			if seen[i][a.Addr] {
+				return
+			}
+			seen[i][a.Addr] = true
+			found[i] = append(found[i], a.Addr.String())
+			log.Printf("Found IP in range %s: %s on interface %s (for domain %s)", m.ranges[i].CIDR, a.Addr, a.Interface, m.ranges[i].Domain)
+		})
+	}
+	return found
+}
+
+// customRangeMatcher returns the matcher for the configured custom ranges, building it
+// on first use. Changing the ranges requires resetting it (see applyNetworkProfile).
+func (c *Config) customRangeMatcher() *rangeMatcher {
+	if c.rangeMatcher == nil {
+		ranges := append(append([]CustomIPRange{}, c.CustomIPv4Ranges...), c.CustomIPv6Ranges...)
+		c.rangeMatcher = newRangeMatcher(ranges)
+	}
+	return c.rangeMatcher
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func testRange(cidr, domain string) CustomIPRange {
+	return CustomIPRange{CIDR: cidr, Prefix: netip.MustParsePrefix(cidr).Masked(), Domain: domain}
+}
+
+func TestRangeMatcher(t *testing.T) {
+	addrs := []interfaceAddr{
+		{Addr: netip.MustParseAddr("100.64.0.5"), Interface: "tailscale0"},
+		{Addr: netip.MustParseAddr("192.168.1.10"), Interface: "eth0"},
+		{Addr: netip.MustParseAddr("100.64.0.5"), Interface: "tun1"}, // Duplicate
+		{Addr: netip.MustParseAddr("fd7a:115c::5"), Interface: "tailscale0"},
+		{Addr: netip.MustParseAddr("10.1.2.3"), Interface: "wg0"},
+		{Addr: netip.MustParseAddr("10.9.0.1"), Interface: "wg1"},
+	}
+	m := newRangeMatcher([]CustomIPRange{
+		testRange("100.64.0.0/10", "vpn.example.com"),
+		testRange("10.0.0.0/8", "ten.example.com"),
+		testRange("10.1.0.0/16", "wg.example.com"), // Overlaps the /8
+		testRange("fd7a:115c::/32", "vpn6.example.com"),
+		testRange("0.0.0.0/0", "any4.example.com"),
+		{CIDR: "bad", Domain: "unparsed.example.com"},
+	})
+
+	want := [][]string{
+		{"100.64.0.5"},
+		{"10.1.2.3", "10.9.0.1"},
+		{"10.1.2.3"},
+		{"fd7a:115c::5"},
+		{"100.64.0.5", "192.168.1.10", "10.1.2.3", "10.9.0.1"},
+		nil,
+	}
+	if got := m.match(addrs); !reflect.DeepEqual(got, want) {
+		t.Errorf("match = %v, want %v", got, want)
+	}
+}
+
+func TestPrefixTrieHostRoutes(t *testing.T) {
+	var trie prefixTrie
+	trie.insert(netip.MustParsePrefix("192.0.2.1/32"), 1)
+	trie.insert(netip.MustParsePrefix("2001:db8::1/128"), 2)
+
+	for addr, want := range map[string][]int{
+		"192.0.2.1":   {1},
+		"192.0.2.2":   nil,
+		"2001:db8::1": {2},
+		"2001:db8::2": nil,
+	} {
+		var got []int
+		trie.lookup(netip.MustParseAddr(addr), func(v int) { got = append(got, v) })
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("lookup(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCustomRangeMatcherIsCachedPerConfig(t *testing.T) {
+	config := &Config{CustomIPv4Ranges: []CustomIPRange{testRange("10.0.0.0/8", "a.example.com")}}
+	m := config.customRangeMatcher()
+	if config.customRangeMatcher() != m {
+		t.Error("matcher rebuilt for the same config")
+	}
+
+	profile := &NetworkProfile{Domains: []string{"b.example.com"}}
+	applyNetworkProfile(config, profile)
+	if got := config.customRangeMatcher(); got == m || len(got.ranges) != 0 {
+		t.Errorf("matcher not rebuilt after the ranges changed: %v", got.ranges)
+	}
+}
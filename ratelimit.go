@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultAPIRateLimit keeps well inside CloudFlare's 1200 requests / 5 minutes and
+// Route53's 5 requests / second per account
+const defaultAPIRateLimit = 4
+
+// rateLimiter spaces provider API requests evenly. It is safe for concurrent use, so
+// the update and cleanup jobs of one process share a single request budget.
+// A nil limiter does not limit.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+
+	// Slower spacing derived from the provider's rate limit headers, until pacedUntil
+	paced      time.Duration
+	pacedUntil time.Time
+}
+
+// newRateLimiter allows perSecond requests per second (0 or less disables limiting)
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// reserve returns how long the caller must wait before its request may be sent
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	interval := l.interval
+	if l.next.Before(l.pacedUntil) && l.paced > interval {
+		interval = l.paced
+	}
+	l.next = l.next.Add(interval)
+	return wait
+}
+
+// adapt paces requests by the provider's rate limit: the remaining requests are spread
+// over the time until the limit resets, so hosts sharing one account limit slow down
+// together before it is exhausted. It never speeds requests up beyond the configured rate.
+func (l *rateLimiter) adapt(remaining int, reset time.Duration, now time.Time) {
+	if l == nil || reset <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pacedUntil = now.Add(reset)
+	if remaining <= 0 {
+		// Nothing left: hold everything until the window resets
+		l.paced = 0
+		if l.next.Before(l.pacedUntil) {
+			l.next = l.pacedUntil
+		}
+		return
+	}
+	l.paced = reset / time.Duration(remaining)
+}
+
+// wait blocks until the next request may be sent
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	if d := l.reserve(time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := newRateLimiter(4)
+	now := time.Unix(1700000000, 0)
+
+	var waits []time.Duration
+	for i := 0; i < 3; i++ {
+		waits = append(waits, l.reserve(now))
+	}
+	want := []time.Duration{0, 250 * time.Millisecond, 500 * time.Millisecond}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Errorf("waits = %v, want %v", waits, want)
+			break
+		}
+	}
+
+	// After an idle period there is no backlog to wait for
+	if got := l.reserve(now.Add(10 * time.Second)); got != 0 {
+		t.Errorf("wait after idle = %s, want 0", got)
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	if l := newRateLimiter(0); l != nil {
+		t.Fatal("limit 0 should disable the limiter")
+	}
+	var l *rateLimiter
+	l.wait() // Must not panic
+}
+
+func TestRateLimiterAdapt(t *testing.T) {
+	l := newRateLimiter(4)
+	now := time.Unix(1700000000, 0)
+
+	// 10 requests left in 60s: space them 6s apart until the window resets
+	l.adapt(10, time.Minute, now)
+	l.reserve(now)
+	if got := l.reserve(now); got != 6*time.Second {
+		t.Errorf("paced wait = %s, want 6s", got)
+	}
+
+	// A generous remaining budget never speeds up beyond the configured rate
+	l = newRateLimiter(4)
+	l.adapt(1000, time.Minute, now)
+	l.reserve(now)
+	if got := l.reserve(now); got != 250*time.Millisecond {
+		t.Errorf("wait = %s, want 250ms", got)
+	}
+
+	// Exhausted: hold requests until the reset, then go back to the configured rate
+	l = newRateLimiter(4)
+	l.adapt(0, 30*time.Second, now)
+	if got := l.reserve(now); got != 30*time.Second {
+		t.Errorf("wait when exhausted = %s, want 30s", got)
+	}
+	if got := l.reserve(now.Add(time.Minute)); got != 0 {
+		t.Errorf("wait after reset = %s, want 0", got)
+	}
+
+	var disabled *rateLimiter
+	disabled.adapt(0, time.Minute, now) // Must not panic
+}
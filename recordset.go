@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Providers such as Route53 and Azure DNS group all values of a name and type into one
+// record set. recordSetClient implements the per-record operations used by the update
+// logic on top of a recordSetBackend: each value is exposed as a separate record whose
+// ID is the value itself, so these providers and CloudFlare can be treated alike.
+
+// errProviderUnreachable marks errors caused by not reaching the provider API at all
+// (as opposed to an API error response); such failures are queued for retry
+var errProviderUnreachable = errors.New("provider API unreachable")
+
+// recordSetBackend is the provider-specific half of a record-set based provider.
+// Values are record contents as used elsewhere (TXT contents as produced by
+// formatTXTStrings for multi-string records, CNAME targets without a trailing dot).
+type recordSetBackend interface {
+	// fetchValues returns the values of a record set (empty if it does not exist)
+	fetchValues(name, recordType string) ([]string, error)
+	// storeValues replaces the values of a record set, published with ttl; no values deletes it
+	storeValues(name, recordType string, ttl int, values []string) error
+	// listRecords returns one record per value of every record set of the given type
+	listRecords(recordType string) ([]CFRecord, error)
+	// probe reports whether the API answers at all
+	probe() bool
+}
+
+// proxiedBackend is implemented by backends that can publish values proxied: the relay
+// agent, whose relay passes it on to its own provider
+type proxiedBackend interface {
+	storeProxiedValues(name, recordType string, ttl int, proxied bool, values []string) error
+}
+
+// matchValues pairs a provider's records with the wanted values of a record set: kept[i]
+// reports whether values[i] is already held by a record, and stale lists the records
+// holding none of the values, in order, to be changed in place or deleted
+func matchValues[R any](existing []R, values []string, holds func(R, string) bool) (kept []bool, stale []R) {
+	kept = make([]bool, len(values))
+	for _, r := range existing {
+		match := -1
+		for i, v := range values {
+			if !kept[i] && holds(r, v) {
+				match = i
+				break
+			}
+		}
+		if match >= 0 {
+			kept[match] = true
+		} else {
+			stale = append(stale, r)
+		}
+	}
+	return kept, stale
+}
+
+// recordSetClient implements providerClient for a recordSetBackend
+type recordSetClient struct {
+	backend      recordSetBackend
+	TTL          int            // TTL in seconds for stored record sets
+	HeartbeatTTL int            // TTL in seconds for heartbeat record sets
+	NameTTLs     map[string]int // Per-name TTL overrides in seconds (optional)
+
+	recordHooks
+}
+
+// newRecordSetClient creates the client for a backend with the configured TTLs.
+// Record-set providers have no automatic TTL, so CloudFlare's "automatic" maps to its value.
+func newRecordSetClient(backend recordSetBackend, config *Config) recordSetClient {
+	return recordSetClient{
+		backend:      backend,
+		TTL:          int(effectiveTTL(config.RecordTTL).Seconds()),
+		HeartbeatTTL: int(effectiveTTL(config.HeartbeatTTL).Seconds()),
+		NameTTLs:     nameTTLSeconds(config.DomainTTLs),
+	}
+}
+
+// nameTTLSeconds converts per-name TTL overrides to seconds
+func nameTTLSeconds(ttls map[string]int) map[string]int {
+	if len(ttls) == 0 {
+		return nil
+	}
+	seconds := make(map[string]int, len(ttls))
+	for name, ttl := range ttls {
+		seconds[name] = int(effectiveTTL(ttl).Seconds())
+	}
+	return seconds
+}
+
+// ttlFor returns the TTL of the record sets at name
+func (c *recordSetClient) ttlFor(name string) int {
+	if ttl, ok := c.NameTTLs[normalizeName(name)]; ok {
+		return ttl
+	}
+	return c.TTL
+}
+
+// valueRecords expands record set values into one record per value
+func valueRecords(name, recordType string, values []string) []CFRecord {
+	records := make([]CFRecord, 0, len(values))
+	for _, v := range values {
+		records = append(records, CFRecord{ID: v, Type: recordType, Name: strings.TrimSuffix(name, "."), Content: v})
+	}
+	return records
+}
+
+// store writes values and reports the outcome through the hooks
+func (c *recordSetClient) store(action, name, recordType, content, recordID string, proxied bool, values []string) error {
+	var err error
+	if backend, ok := c.backend.(proxiedBackend); ok {
+		err = backend.storeProxiedValues(name, recordType, c.ttlFor(name), proxied, values)
+	} else {
+		err = c.backend.storeValues(name, recordType, c.ttlFor(name), values)
+	}
+	if err != nil {
+		if errors.Is(err, errProviderUnreachable) {
+			c.notifyUnreachable(action, recordType, name, content, recordID)
+		}
+		return fmt.Errorf("changing %s records for %s: %w", recordType, name, err)
+	}
+
+	logRecordChange(action, recordType, name, content)
+	c.notifyChange(action, recordType, name, content)
+	return nil
+}
+
+// fetch reads the current values, reporting failures through the hooks
+func (c *recordSetClient) fetch(action, name, recordType, content, recordID string) ([]string, error) {
+	values, err := c.backend.fetchValues(name, recordType)
+	if err != nil {
+		if errors.Is(err, errProviderUnreachable) {
+			c.notifyUnreachable(action, recordType, name, content, recordID)
+		}
+		return nil, fmt.Errorf("getting %s records for %s: %w", recordType, name, err)
+	}
+	return values, nil
+}
+
+func (c *recordSetClient) getRecordID(name, recordType string) (string, error) {
+	record, err := c.getRecord(name, recordType)
+	if record == nil {
+		return "", err
+	}
+	return record.ID, nil
+}
+
+// getRecord returns the first value of the record set, or nil if not found
+func (c *recordSetClient) getRecord(name, recordType string) (*CFRecord, error) {
+	records, err := c.getAllRecords(name, recordType)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+// getAllRecords returns one record per value of the record set
+func (c *recordSetClient) getAllRecords(name, recordType string) ([]CFRecord, error) {
+	values, err := c.backend.fetchValues(name, recordType)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s records for %s: %w", recordType, name, err)
+	}
+	return valueRecords(name, recordType, values), nil
+}
+
+// getAllRecordsByType returns all records in the zone matching the type
+func (c *recordSetClient) getAllRecordsByType(recordType string) ([]CFRecord, error) {
+	records, err := c.backend.listRecords(recordType)
+	if err != nil {
+		return nil, fmt.Errorf("getting all %s records: %w", recordType, err)
+	}
+	return records, nil
+}
+
+func (c *recordSetClient) createRecord(name, recordType, content string, proxied bool) error {
+	values, err := c.fetch("create", name, recordType, content, "")
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if sameContent(v, content) {
+			return nil
+		}
+	}
+	if recordType == "CNAME" {
+		values = nil // A name can only have one CNAME
+	}
+	return c.store("create", name, recordType, content, "", proxied, append(values, content))
+}
+
+func (c *recordSetClient) updateRecord(recordID, name, recordType, content string, proxied bool) error {
+	current, err := c.fetch("update", name, recordType, content, recordID)
+	if err != nil {
+		return err
+	}
+	var values []string
+	for _, v := range current {
+		if v != recordID && !sameContent(v, content) {
+			values = append(values, v)
+		}
+	}
+	return c.store("update", name, recordType, content, recordID, proxied, append(values, content))
+}
+
+func (c *recordSetClient) deleteRecord(recordID, name, recordType string) error {
+	current, err := c.fetch("delete", name, recordType, "", recordID)
+	if err != nil {
+		return err
+	}
+	var remaining []string
+	found := false
+	for _, v := range current {
+		if v == recordID {
+			found = true
+		} else {
+			remaining = append(remaining, v)
+		}
+	}
+	if !found {
+		return nil // Already gone
+	}
+	return c.store("delete", name, recordType, "", recordID, false, remaining)
+}
+
+func (c *recordSetClient) deleteRecordIfExists(name, recordType string) error {
+	recordID, err := c.getRecordID(name, recordType)
+	if recordID != "" {
+		return c.deleteRecord(recordID, name, recordType)
+	}
+	return err
+}
+
+func (c *recordSetClient) upsertRecord(name, recordType, content string, proxied bool) error {
+	record, err := c.getRecord(name, recordType)
+	if err != nil {
+		return c.lookupFailed(err, recordType, name, content)
+	}
+	if record != nil {
+		// Record exists - check if content has changed
+		if sameContent(record.Content, content) {
+			log.Printf("No change needed for %s record %s (already %s)", recordType, name, content)
+			return nil
+		}
+		log.Printf("Content changed for %s record %s: %s -> %s", recordType, name, record.Content, content)
+		return c.updateRecord(record.ID, name, recordType, content, proxied)
+	}
+	return c.createRecord(name, recordType, content, proxied)
+}
+
+// ensureRecordExists adds a value to the record set only if it is not already present
+func (c *recordSetClient) ensureRecordExists(name, recordType, content string, proxied bool) error {
+	records, err := c.getAllRecords(name, recordType)
+	if err != nil {
+		return c.lookupFailed(err, recordType, name, content)
+	}
+	for _, record := range records {
+		if sameContent(record.Content, content) {
+			log.Printf("No change needed for %s record %s (already %s)", recordType, name, content)
+			return nil
+		}
+	}
+	return c.createRecord(name, recordType, content, proxied)
+}
+
+// upsertHeartbeat creates or updates a heartbeat TXT record with the heartbeat TTL
+func (c *recordSetClient) upsertHeartbeat(name, content string) error {
+	heartbeat := *c
+	heartbeat.TTL = c.HeartbeatTTL
+	heartbeat.NameTTLs = nil
+	return heartbeat.upsertRecord(name, "TXT", content, false)
+}
+
+// withTTL returns a copy of the client publishing every record set with ttl seconds
+func (c *recordSetClient) withTTL(ttl int) CloudFlareAPI {
+	published := *c
+	published.TTL = ttl
+	published.NameTTLs = nil
+	return &published
+}
+
+func (c *recordSetClient) probeConnectivity() bool {
+	return c.backend.probe()
+}
+
+// DNSProvider interface implementation (capitalized wrapper methods)
+
+func (c *recordSetClient) GetRecordID(name, recordType string) (string, error) {
+	return c.getRecordID(name, recordType)
+}
+
+func (c *recordSetClient) GetRecord(name, recordType string) (*DNSRecord, error) {
+	record, err := c.getRecord(name, recordType)
+	return cfRecordToDNSRecord(record), err
+}
+
+func (c *recordSetClient) GetAllRecords(name, recordType string) ([]DNSRecord, error) {
+	records, err := c.getAllRecords(name, recordType)
+	return cfRecordsToDNSRecords(records), err
+}
+
+func (c *recordSetClient) CreateRecord(name, recordType, content string, proxied bool) error {
+	return c.createRecord(name, recordType, content, proxied)
+}
+
+func (c *recordSetClient) UpdateRecord(recordID, name, recordType, content string, proxied bool) error {
+	return c.updateRecord(recordID, name, recordType, content, proxied)
+}
+
+func (c *recordSetClient) DeleteRecord(recordID, name, recordType string) error {
+	return c.deleteRecord(recordID, name, recordType)
+}
+
+func (c *recordSetClient) DeleteRecordIfExists(name, recordType string) error {
+	return c.deleteRecordIfExists(name, recordType)
+}
+
+func (c *recordSetClient) UpsertRecord(name, recordType, content string, proxied bool) error {
+	return c.upsertRecord(name, recordType, content, proxied)
+}
+
+func (c *recordSetClient) EnsureRecordExists(name, recordType, content string, proxied bool) error {
+	return c.ensureRecordExists(name, recordType, content, proxied)
+}
@@ -0,0 +1,752 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In server-assisted mode only a relay ("dynipupdate relay") holds DNS provider
+// credentials. Agents run with BEES_IP_UPDATE_PROVIDER=relay and send the record sets
+// derived from their detected addresses to the relay over HTTPS, authenticated with a
+// per-agent bearer token, a client certificate, or both; the relay checks each name
+// against the names the agent may change and applies the change with its own provider
+// client, so credentials, rate limiting, the change journal and cleanup live in one place.
+//
+//	GET  /v1/records?type=A[&name=nas.example.com]  record sets the agent may see
+//	PUT  /v1/records                                 replace a record set (relayRecordSet)
+//	POST /v1/heartbeats                              refresh heartbeats (relayHeartbeats)
+//	GET  /v1/health                                  check the relay and the credentials
+//
+// Heartbeats are held back by the agent and ride along with its next PUT; only those left
+// at the end of a run are sent with one POST. If the relay cannot be reached, changes are
+// queued by the agent like with any provider and held-back heartbeats wait for the next run.
+
+// providerRelay is the BEES_IP_UPDATE_PROVIDER value of agents of a relay
+const providerRelay = "relay"
+
+// relayMaxBody bounds the size of a record set sent to the relay
+const relayMaxBody = 64 * 1024
+
+// relayRecordTypes are the record types agents may change through the relay
+var relayRecordTypes = []string{"A", "AAAA", "CNAME", "TXT"}
+
+// relayRecordSet is a record set sent to or listed by the relay
+type relayRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl,omitempty"`
+	Proxied bool     `json:"proxied,omitempty"` // Publish the values proxied (CloudFlare)
+	Values  []string `json:"values"`
+
+	Heartbeats map[string]string `json:"heartbeats,omitempty"` // Piggybacked heartbeats (name -> content) of a PUT
+}
+
+// relayHeartbeats is the body of a heartbeat request
+type relayHeartbeats struct {
+	Heartbeats map[string]string `json:"heartbeats"`
+}
+
+// relayRecordList is the response of a record list request
+type relayRecordList struct {
+	Records []relayRecordSet `json:"records"`
+}
+
+// RelayProvider sends record changes to a relay instead of talking to a DNS service
+type RelayProvider struct {
+	URL   string
+	Token string
+
+	client *http.Client
+
+	mu         sync.Mutex
+	heartbeats map[string]string // Held back until the next request (name -> content)
+
+	recordSetClient
+}
+
+var _ heartbeatBuffer = (*RelayProvider)(nil)
+
+// Verify RelayProvider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*RelayProvider)(nil)
+var _ DNSProvider = (*RelayProvider)(nil)
+var _ providerClient = (*RelayProvider)(nil)
+
+// newRelayProvider creates the provider of a relay agent
+func newRelayProvider(config *Config) (*RelayProvider, error) {
+	if !strings.HasPrefix(config.RelayURL, "https://") && !strings.HasPrefix(config.RelayURL, "http://") {
+		return nil, fmt.Errorf("%sRELAY_URL must be an http or https URL, got %q", envPrefix, config.RelayURL)
+	}
+	if config.RelayToken == "" && config.RelayClientCert == "" {
+		return nil, fmt.Errorf("%sRELAY_TOKEN or %sRELAY_CLIENT_CERT is required to authenticate at the relay", envPrefix, envPrefix)
+	}
+	if strings.HasPrefix(config.RelayURL, "http://") {
+		log.Printf("WARNING: %sRELAY_URL uses http - the relay token is sent in clear text", envPrefix)
+	}
+	tlsConfig, err := relayClientTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	stack := newProviderStack(config, providerRelay)
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		stack.base = transport
+	}
+	p := &RelayProvider{
+		URL:        strings.TrimSuffix(config.RelayURL, "/"),
+		Token:      config.RelayToken,
+		client:     stack.client(),
+		heartbeats: make(map[string]string),
+	}
+	p.recordSetClient = newRecordSetClient(p, config)
+	log.Printf("Sending record changes to relay %s", p.URL)
+
+	if len(config.RecordTags) > 0 {
+		log.Printf("WARNING: %sRECORD_TAGS has no effect on a relay agent - the relay's own tags apply", envPrefix)
+	}
+	return p, nil
+}
+
+// request sends one request to the relay and decodes its JSON response into out (if not nil)
+func (p *RelayProvider) request(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, p.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	req.Header.Set("User-Agent", dynDNS2UserAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	log.Printf("Relay Request: %s %s", method, req.URL.RequestURI())
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		message := strings.TrimSpace(string(data))
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			// The relay (or the DNS provider behind it) is down: queue like a network error
+			return fmt.Errorf("%w: relay returned %d: %s", errProviderUnreachable, resp.StatusCode, message)
+		}
+		return fmt.Errorf("relay returned %w", statusError(resp.StatusCode, message))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding relay response: %w", err)
+	}
+	return nil
+}
+
+// recordSetBackend implementation
+
+func (p *RelayProvider) fetchValues(name, recordType string) ([]string, error) {
+	var list relayRecordList
+	query := url.Values{"type": {recordType}, "name": {normalizeName(name)}}
+	if err := p.request("GET", "/v1/records?"+query.Encode(), nil, &list); err != nil {
+		return nil, err
+	}
+	for _, set := range list.Records {
+		if set.Name == normalizeName(name) && set.Type == recordType {
+			return set.Values, nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *RelayProvider) storeValues(name, recordType string, ttl int, values []string) error {
+	return p.storeProxiedValues(name, recordType, ttl, false, values)
+}
+
+// storeProxiedValues sends a record set, asking the relay to publish it proxied or not
+func (p *RelayProvider) storeProxiedValues(name, recordType string, ttl int, proxied bool, values []string) error {
+	set := relayRecordSet{Name: normalizeName(name), Type: recordType, TTL: ttl, Proxied: proxied, Values: values}
+	if set.Values == nil {
+		set.Values = []string{}
+	}
+	set.Heartbeats = p.takeHeartbeats()
+	if err := p.request("PUT", "/v1/records", set, nil); err != nil {
+		p.restoreHeartbeats(set.Heartbeats)
+		return err
+	}
+	return nil
+}
+
+// upsertHeartbeat holds the heartbeat back: it is sent with the next record change, or by
+// flushHeartbeats at the end of the run
+func (p *RelayProvider) upsertHeartbeat(name, content string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.heartbeats[normalizeName(name)] = content
+	return nil
+}
+
+// flushHeartbeats sends the heartbeats that did not ride along with a change
+func (p *RelayProvider) flushHeartbeats() (int, error) {
+	heartbeats := p.takeHeartbeats()
+	if len(heartbeats) == 0 {
+		return 0, nil
+	}
+	if err := p.request("POST", "/v1/heartbeats", relayHeartbeats{Heartbeats: heartbeats}, nil); err != nil {
+		p.restoreHeartbeats(heartbeats)
+		return len(heartbeats), fmt.Errorf("sending heartbeats: %w", err)
+	}
+	return len(heartbeats), nil
+}
+
+// takeHeartbeats removes and returns the held-back heartbeats (nil if there are none)
+func (p *RelayProvider) takeHeartbeats() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.heartbeats) == 0 {
+		return nil
+	}
+	heartbeats := p.heartbeats
+	p.heartbeats = make(map[string]string)
+	return heartbeats
+}
+
+// restoreHeartbeats holds back heartbeats that could not be sent, unless newer ones were
+// held back in the meantime
+func (p *RelayProvider) restoreHeartbeats(heartbeats map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, content := range heartbeats {
+		if _, newer := p.heartbeats[name]; !newer {
+			p.heartbeats[name] = content
+		}
+	}
+}
+
+// listRecords returns the records of the type that the agent may change
+func (p *RelayProvider) listRecords(recordType string) ([]CFRecord, error) {
+	var list relayRecordList
+	if err := p.request("GET", "/v1/records?"+url.Values{"type": {recordType}}.Encode(), nil, &list); err != nil {
+		return nil, err
+	}
+	records := []CFRecord{}
+	for _, set := range list.Records {
+		records = append(records, valueRecords(set.Name, set.Type, set.Values)...)
+	}
+	return records, nil
+}
+
+// probe reports whether the relay answers and accepts the credentials
+func (p *RelayProvider) probe() bool {
+	return p.request("GET", "/v1/health", nil, nil) == nil
+}
+
+// relayAgent is one agent allowed to use the relay (BEES_IP_UPDATE_RELAY_AGENTS_FILE).
+// An agent with both a token and a certificate must present both.
+type relayAgent struct {
+	Name        string   `json:"name"`                  // Shown in logs and the change journal
+	Token       string   `json:"token,omitempty"`       // Bearer token of the agent
+	Certificate string   `json:"certificate,omitempty"` // Common name or DNS name of the agent's client certificate
+	Names       []string `json:"names"`                 // Record names the agent may change; "*.example.com" allows every name below
+
+	relayPolicy // Overrides the file's default policy
+}
+
+// relayAgentsFile is the format of BEES_IP_UPDATE_RELAY_AGENTS_FILE
+type relayAgentsFile struct {
+	Policy relayPolicy  `json:"policy"` // Defaults for every agent
+	Agents []relayAgent `json:"agents"`
+}
+
+// loadRelayAgents reads and validates the agents file
+func loadRelayAgents(path string) ([]relayAgent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file relayAgentsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Agents) == 0 {
+		return nil, fmt.Errorf("%s lists no agents", path)
+	}
+	tokens := make(map[string]string)
+	certificates := make(map[string]string)
+	for i, agent := range file.Agents {
+		switch {
+		case agent.Name == "":
+			return nil, fmt.Errorf("%s: agent %d has no name", path, i+1)
+		case agent.Token == "" && agent.Certificate == "":
+			return nil, fmt.Errorf("%s: agent %s needs a token or a certificate", path, agent.Name)
+		case agent.Token != "" && len(agent.Token) < 16:
+			return nil, fmt.Errorf("%s: agent %s needs a token of at least 16 characters", path, agent.Name)
+		case len(agent.Names) == 0:
+			return nil, fmt.Errorf("%s: agent %s may not change any names", path, agent.Name)
+		}
+		if other, ok := tokens[agent.Token]; ok && agent.Token != "" {
+			return nil, fmt.Errorf("%s: agents %s and %s share a token", path, other, agent.Name)
+		}
+		if other, ok := certificates[agent.Certificate]; ok && agent.Certificate != "" {
+			return nil, fmt.Errorf("%s: agents %s and %s share a certificate name", path, other, agent.Name)
+		}
+		tokens[agent.Token] = agent.Name
+		certificates[agent.Certificate] = agent.Name
+		for j, name := range agent.Names {
+			file.Agents[i].Names[j] = normalizeName(name)
+		}
+		if err := file.Agents[i].relayPolicy.resolve(file.Policy); err != nil {
+			return nil, fmt.Errorf("%s: agent %s: %w", path, agent.Name, err)
+		}
+	}
+	return file.Agents, nil
+}
+
+// allowed reports whether the agent may change a record name. The _changelog record of
+// an allowed name is allowed too.
+func (a *relayAgent) allowed(name string) bool {
+	name = strings.TrimPrefix(normalizeName(name), changelogRecordName(""))
+	for _, pattern := range a.Names {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(name, "."+suffix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// relayServer serves the relay API on top of a provider client
+type relayServer struct {
+	provider providerClient
+	agents   []relayAgent
+	config   *Config
+
+	mu sync.Mutex // Serializes changes, so concurrent agents never interleave on one name
+}
+
+// authenticate returns the agent whose credentials the request carries (nil if none).
+// Every token is compared in constant time.
+func (s *relayServer) authenticate(r *http.Request) *relayAgent {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	certNames := verifiedCertificateNames(r)
+
+	var found *relayAgent
+	for i := range s.agents {
+		agent := &s.agents[i]
+		tokenOK := agent.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(agent.Token)) == 1
+		certOK := agent.Certificate != "" && slices.Contains(certNames, agent.Certificate)
+		switch {
+		case agent.Token != "" && agent.Certificate != "":
+			if tokenOK && certOK {
+				found = agent
+			}
+		case tokenOK || certOK:
+			found = agent
+		}
+	}
+	return found
+}
+
+// verifiedCertificateNames returns the common name and DNS names of the request's client
+// certificate, if it was verified against BEES_IP_UPDATE_RELAY_CLIENT_CA
+func verifiedCertificateNames(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	return append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+}
+
+// loadCertPool reads PEM certificates into a pool
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// relayClientTLSConfig returns the TLS settings of an agent: its client certificate and
+// the CA of the relay's certificate (nil if neither is configured)
+func relayClientTLSConfig(config *Config) (*tls.Config, error) {
+	if config.RelayClientCert == "" && config.RelayClientKey == "" && config.RelayCACert == "" {
+		return nil, nil
+	}
+	if (config.RelayClientCert == "") != (config.RelayClientKey == "") {
+		return nil, fmt.Errorf("%sRELAY_CLIENT_CERT and %sRELAY_CLIENT_KEY must be set together", envPrefix, envPrefix)
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.RelayClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.RelayClientCert, config.RelayClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("%sRELAY_CLIENT_CERT: %w", envPrefix, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if config.RelayCACert != "" {
+		pool, err := loadCertPool(config.RelayCACert)
+		if err != nil {
+			return nil, fmt.Errorf("%sRELAY_CA_CERT: %w", envPrefix, err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// relayErrorStatus maps a provider error to the status returned to the agent
+func relayErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errProviderUnreachable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errProviderRateLimited):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadGateway
+}
+
+func (s *relayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	agent := s.authenticate(r)
+	if agent == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dynipupdate-relay"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v1/health" && r.Method == http.MethodGet:
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/v1/records" && r.Method == http.MethodGet:
+		s.listRecordSets(w, r, agent)
+	case r.URL.Path == "/v1/records" && r.Method == http.MethodPut:
+		s.putRecordSet(w, r, agent)
+	case r.URL.Path == "/v1/heartbeats" && r.Method == http.MethodPost:
+		s.postHeartbeats(w, r, agent)
+	case r.URL.Path == "/v1/records" || r.URL.Path == "/v1/heartbeats" || r.URL.Path == "/v1/health":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// validRelayType reports whether agents may use a record type
+func validRelayType(recordType string) bool {
+	for _, t := range relayRecordTypes {
+		if t == recordType {
+			return true
+		}
+	}
+	return false
+}
+
+// listRecordSets answers a record list request with the sets the agent may change
+func (s *relayServer) listRecordSets(w http.ResponseWriter, r *http.Request, agent *relayAgent) {
+	recordType, name := r.URL.Query().Get("type"), normalizeName(r.URL.Query().Get("name"))
+	if !validRelayType(recordType) {
+		http.Error(w, "unsupported record type", http.StatusBadRequest)
+		return
+	}
+	if name != "" && !agent.allowed(name) {
+		http.Error(w, "name not allowed for agent "+agent.Name, http.StatusForbidden)
+		return
+	}
+
+	var records []CFRecord
+	var err error
+	if name != "" {
+		records, err = s.provider.getAllRecords(name, recordType)
+	} else {
+		records, err = s.provider.getAllRecordsByType(recordType)
+	}
+	if err != nil {
+		log.Printf("Relay: listing %s records for agent %s failed: %v", recordType, agent.Name, err)
+		http.Error(w, err.Error(), relayErrorStatus(err))
+		return
+	}
+
+	list := relayRecordList{Records: []relayRecordSet{}}
+	index := make(map[string]int)
+	for _, record := range records {
+		recordName := normalizeName(record.Name)
+		if !agent.allowed(recordName) {
+			continue
+		}
+		i, ok := index[recordName]
+		if !ok {
+			i = len(list.Records)
+			index[recordName] = i
+			list.Records = append(list.Records, relayRecordSet{Name: recordName, Type: recordType})
+		}
+		list.Records[i].Values = append(list.Records[i].Values, record.Content)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// putRecordSet replaces a record set on behalf of an agent
+func (s *relayServer) putRecordSet(w http.ResponseWriter, r *http.Request, agent *relayAgent) {
+	var set relayRecordSet
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, relayMaxBody)).Decode(&set); err != nil {
+		http.Error(w, "invalid record set: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	set.Name = normalizeName(set.Name)
+	if !validRelayType(set.Type) {
+		http.Error(w, "unsupported record type", http.StatusBadRequest)
+		return
+	}
+	if err := checkRelayHeartbeats(agent, set.Heartbeats); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	if err := s.authorize(agent, set); err != nil {
+		s.mu.Unlock()
+		status := http.StatusForbidden
+		if !errors.Is(err, errRelayPolicy) {
+			status = relayErrorStatus(err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	changes, err := s.apply(set)
+	if err == nil {
+		err = s.applyHeartbeats(set.Heartbeats)
+	}
+	// Journaled under the lock, so entries follow the order the changes were made in
+	recordHistory(s.config, changes, nil)
+	s.mu.Unlock()
+	for _, c := range changes {
+		log.Printf("Relay: agent %s: %s %s %s %s", agent.Name, c.Action, c.Type, c.Name, c.Content)
+	}
+	if err != nil {
+		log.Printf("Relay: changing %s %s for agent %s failed: %v", set.Type, set.Name, agent.Name, err)
+		http.Error(w, err.Error(), relayErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// postHeartbeats refreshes heartbeats on behalf of an agent
+func (s *relayServer) postHeartbeats(w http.ResponseWriter, r *http.Request, agent *relayAgent) {
+	var body relayHeartbeats
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, relayMaxBody)).Decode(&body); err != nil {
+		http.Error(w, "invalid heartbeats: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkRelayHeartbeats(agent, body.Heartbeats); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	err := s.applyHeartbeats(body.Heartbeats)
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("Relay: heartbeats of agent %s failed: %v", agent.Name, err)
+		http.Error(w, err.Error(), relayErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkRelayHeartbeats checks that the agent may refresh every heartbeat and that each is
+// a heartbeat, not another TXT value
+func checkRelayHeartbeats(agent *relayAgent, heartbeats map[string]string) error {
+	for name, content := range heartbeats {
+		if !agent.allowed(name) {
+			log.Printf("Relay: agent %s may not refresh the heartbeat of %s - rejected", agent.Name, name)
+			return fmt.Errorf("heartbeat of %s not allowed for agent %s", name, agent.Name)
+		}
+		if _, ok := parseHeartbeat(content); !ok {
+			return fmt.Errorf("%q is not a heartbeat", content)
+		}
+	}
+	return nil
+}
+
+// applyHeartbeats writes heartbeats with the relay's heartbeat TTL
+func (s *relayServer) applyHeartbeats(heartbeats map[string]string) error {
+	for name, content := range heartbeats {
+		if err := s.provider.upsertHeartbeat(normalizeName(name), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// managed reports whether any agent may change a name, i.e. whether the relay's cleanup
+// may delete its records once its heartbeat is stale
+func (s *relayServer) managed(name string) bool {
+	for i := range s.agents {
+		if s.agents[i].allowed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanup runs the cleanup service over the agents' names, returning the delay until the next run
+func (s *relayServer) cleanup() time.Duration {
+	log.Println(tr("cleanup.cycle"))
+	s.mu.Lock()
+	cleanupStaleDomains(s.provider, s.config, s.managed)
+	s.mu.Unlock()
+	return time.Duration(s.config.CleanupInterval) * time.Second
+}
+
+// ttlPublisher is implemented by providers that can publish records with another TTL, so
+// the relay applies the TTL an agent asks for
+type ttlPublisher interface {
+	withTTL(ttl int) CloudFlareAPI
+}
+
+// apply makes the provider's records of a name and type match the set, updating replaced
+// values in place, and returns the changes made (also when failing part-way)
+func (s *relayServer) apply(set relayRecordSet) ([]RecordChange, error) {
+	var provider CloudFlareAPI = s.provider
+	if p, ok := s.provider.(ttlPublisher); ok && set.TTL > 0 {
+		provider = p.withTTL(set.TTL)
+	}
+	existing, err := provider.getAllRecords(set.Name, set.Type)
+	if err != nil {
+		return nil, err
+	}
+	kept, stale := matchValues(existing, set.Values, func(r CFRecord, v string) bool { return sameContent(r.Content, v) })
+
+	now := s.config.now().Unix()
+	var changes []RecordChange
+	for i, v := range set.Values {
+		if kept[i] {
+			continue
+		}
+		action := "create"
+		if len(stale) > 0 {
+			action = "update"
+			err = provider.updateRecord(stale[0].ID, set.Name, set.Type, v, set.Proxied)
+			if err == nil {
+				stale = stale[1:]
+			}
+		} else {
+			err = provider.createRecord(set.Name, set.Type, v, set.Proxied)
+		}
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, RecordChange{Timestamp: now, Action: action, Type: set.Type, Name: set.Name, Content: v})
+	}
+	for _, record := range stale {
+		if err := provider.deleteRecord(record.ID, set.Name, set.Type); err != nil {
+			return changes, err
+		}
+		changes = append(changes, RecordChange{Timestamp: now, Action: "delete", Type: set.Type, Name: set.Name, Content: record.Content})
+	}
+	return changes, nil
+}
+
+// runRelay implements "dynipupdate relay": serve the relay API for agents, applying their
+// changes with the configured DNS provider
+func runRelay(args []string) int {
+	fs := flag.NewFlagSet("relay", flag.ContinueOnError)
+	listen := fs.String("listen", "", "Address to listen on (default: "+envPrefix+"RELAY_LISTEN)")
+	cleanup := fs.Bool("cleanup", false, "Also run the cleanup service over the agents' names")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// The relay has no domains of its own; its agents' names come from the agents file
+	var agents []relayAgent
+	config, provider := bootstrapConfig(false, false, func(config *Config) error {
+		setupLogging(config.LogFormat, config.LogLevel, config.Provider)
+		if *listen == "" {
+			*listen = config.RelayListen
+		}
+		switch {
+		case *listen == "":
+			return fmt.Errorf("set %sRELAY_LISTEN or pass -listen", envPrefix)
+		case config.RelayAgentsFile == "":
+			return fmt.Errorf("%sRELAY_AGENTS_FILE must list the agents allowed to use the relay", envPrefix)
+		case (config.RelayTLSCert == "") != (config.RelayTLSKey == ""):
+			return fmt.Errorf("%sRELAY_TLS_CERT and %sRELAY_TLS_KEY must be set together", envPrefix, envPrefix)
+		case config.Provider == providerRelay:
+			return errors.New("a relay cannot forward to another relay - configure the DNS provider")
+		case config.Provider == providerCloudFlare && config.CFAPIToken == "" && config.vault == nil:
+			return fmt.Errorf("%sCF_API_TOKEN is required", envPrefix)
+		case config.RelayClientCA != "" && config.RelayTLSCert == "":
+			return fmt.Errorf("%sRELAY_CLIENT_CA needs %sRELAY_TLS_CERT and %sRELAY_TLS_KEY", envPrefix, envPrefix, envPrefix)
+		}
+
+		var err error
+		if agents, err = loadRelayAgents(config.RelayAgentsFile); err != nil {
+			return err
+		}
+		for _, agent := range agents {
+			if agent.Certificate != "" && config.RelayClientCA == "" {
+				return fmt.Errorf("agent %s authenticates with a certificate - set %sRELAY_CLIENT_CA", agent.Name, envPrefix)
+			}
+		}
+		return nil
+	})
+
+	relay := &relayServer{provider: provider, agents: agents, config: config}
+	server := &http.Server{Addr: *listen, Handler: relay, ReadHeaderTimeout: 10 * time.Second}
+	if config.RelayClientCA != "" {
+		pool, err := loadCertPool(config.RelayClientCA)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %sRELAY_CLIENT_CA: %v\n", envPrefix, err)
+			return 2
+		}
+		// Agents authenticating with a token only need no certificate
+		server.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven, MinVersion: tls.VersionTLS12}
+	}
+
+	if *cleanup {
+		s := newScheduler()
+		s.add("cleanup", relay.cleanup)
+		go s.loop(nil)
+		log.Printf("Relay cleanup running every %ds (stale after %ds)", config.CleanupInterval, config.StaleThreshold)
+	}
+	var err error
+	if config.RelayTLSCert != "" {
+		log.Printf("Relay listening on https://%s for %d agents", *listen, len(agents))
+		err = server.ListenAndServeTLS(config.RelayTLSCert, config.RelayTLSKey)
+	} else {
+		log.Printf("WARNING: Relay listening on http://%s without TLS - agent tokens are sent in clear text", *listen)
+		err = server.ListenAndServe()
+	}
+	fmt.Fprintf(os.Stderr, "ERROR: Relay stopped: %v\n", err)
+	return 1
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRelay starts a relay in front of a fake CloudFlare API and returns an agent
+// provider using the given token
+func newTestRelay(t *testing.T, token string) (*fakeCloudFlare, *httptest.Server, *RelayProvider) {
+	t.Helper()
+	fake, cf := newFakeCloudFlare(t)
+	relay := &relayServer{
+		provider: cf,
+		agents: []relayAgent{
+			{Name: "nas", Token: "nas-token-0123456789", Names: []string{"nas.example.com", "*.nas.example.com"}},
+			{Name: "laptop", Token: "laptop-token-0123456789", Names: []string{"laptop.example.com"}},
+		},
+		config: &Config{clock: newFakeClock(time.Unix(1700000000, 0))},
+	}
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+
+	agent, err := newRelayProvider(&Config{RelayURL: server.URL, RelayToken: token, RecordTTL: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, server, agent
+}
+
+func TestRelayAppliesAllowedChanges(t *testing.T) {
+	fake, _, agent := newTestRelay(t, "nas-token-0123456789")
+
+	if err := agent.upsertRecord("nas.example.com", "A", "203.0.113.1", false); err != nil {
+		t.Fatalf("upsertRecord = %v", err)
+	}
+	if err := agent.upsertRecord("nas.example.com", "A", "203.0.113.2", false); err != nil {
+		t.Fatalf("upsertRecord = %v", err)
+	}
+	if got := fake.contents("nas.example.com", "A"); !slices.Equal(got, []string{"203.0.113.2"}) {
+		t.Errorf("A records = %v, want the updated address only", got)
+	}
+	if err := agent.ensureRecordExists("v6.nas.example.com", "AAAA", "2001:db8::1", false); err != nil {
+		t.Fatalf("ensureRecordExists below a wildcard = %v", err)
+	}
+	if err := agent.upsertHeartbeat("nas.example.com", "1700000000"); err != nil {
+		t.Fatalf("upsertHeartbeat = %v", err)
+	}
+	if sent, err := agent.flushHeartbeats(); sent != 1 || err != nil {
+		t.Fatalf("flushHeartbeats = %d, %v", sent, err)
+	}
+	if got := fake.contents("nas.example.com", "TXT"); !slices.Equal(got, []string{"1700000000"}) {
+		t.Errorf("heartbeat = %v", got)
+	}
+
+	record, err := agent.getRecord("nas.example.com", "A")
+	if err != nil || record == nil || record.Content != "203.0.113.2" {
+		t.Errorf("getRecord = %+v, %v", record, err)
+	}
+	if err := agent.deleteRecordIfExists("v6.nas.example.com", "AAAA"); err != nil {
+		t.Fatalf("deleteRecordIfExists = %v", err)
+	}
+	if got := fake.contents("v6.nas.example.com", "AAAA"); len(got) != 0 {
+		t.Errorf("AAAA records after delete = %v", got)
+	}
+}
+
+func TestRelayRejectsOtherNames(t *testing.T) {
+	fake, _, agent := newTestRelay(t, "nas-token-0123456789")
+	fake.add("A", "laptop.example.com", "198.51.100.7")
+
+	err := agent.upsertRecord("laptop.example.com", "A", "203.0.113.1", false)
+	if !errors.Is(err, errProviderAuth) {
+		t.Errorf("upsertRecord of another agent's name = %v, want an auth error", err)
+	}
+	if got := fake.contents("laptop.example.com", "A"); !slices.Equal(got, []string{"198.51.100.7"}) {
+		t.Errorf("laptop record changed to %v", got)
+	}
+
+	// Listing only shows the agent's own names
+	fake.add("A", "nas.example.com", "203.0.113.1")
+	records, err := agent.getAllRecordsByType("A")
+	if err != nil || len(records) != 1 || records[0].Name != "nas.example.com" {
+		t.Errorf("getAllRecordsByType = %+v, %v", records, err)
+	}
+}
+
+func TestRelayAuthentication(t *testing.T) {
+	_, _, agent := newTestRelay(t, "wrong-token-0123456789")
+	if agent.probeConnectivity() {
+		t.Error("probe succeeded with an unknown token")
+	}
+	if err := agent.upsertRecord("nas.example.com", "A", "203.0.113.1", false); !errors.Is(err, errProviderAuth) {
+		t.Errorf("upsertRecord with an unknown token = %v", err)
+	}
+
+	_, _, agent = newTestRelay(t, "laptop-token-0123456789")
+	if !agent.probeConnectivity() {
+		t.Error("probe failed with a valid token")
+	}
+}
+
+func TestRelayProviderDown(t *testing.T) {
+	fake, _, agent := newTestRelay(t, "nas-token-0123456789")
+	fake.server.Close()
+
+	if err := agent.upsertRecord("nas.example.com", "A", "203.0.113.1", false); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("upsertRecord with the provider down = %v, want it queued as unreachable", err)
+	}
+
+	_, relay, agent := newTestRelay(t, "nas-token-0123456789")
+	relay.Close()
+	if err := agent.upsertRecord("nas.example.com", "A", "203.0.113.1", false); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("upsertRecord with the relay down = %v", err)
+	}
+}
+
+func TestRelayHeartbeatPiggyback(t *testing.T) {
+	fake, _, agent := newTestRelay(t, "nas-token-0123456789")
+
+	// A held-back heartbeat rides along with the next change
+	agent.upsertHeartbeat("nas.example.com", "1700000000")
+	if got := fake.contents("nas.example.com", "TXT"); len(got) != 0 {
+		t.Fatalf("heartbeat sent before a change: %v", got)
+	}
+	if err := agent.upsertRecord("nas.example.com", "A", "203.0.113.1", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.contents("nas.example.com", "TXT"); !slices.Equal(got, []string{"1700000000"}) {
+		t.Errorf("heartbeat after a change = %v", got)
+	}
+	if sent, err := agent.flushHeartbeats(); sent != 0 || err != nil {
+		t.Errorf("flushHeartbeats after piggybacking = %d, %v, want nothing left", sent, err)
+	}
+
+	// Heartbeats of other names or non-heartbeat values are rejected
+	agent.upsertHeartbeat("laptop.example.com", "1700000000")
+	if _, err := agent.flushHeartbeats(); !errors.Is(err, errProviderAuth) {
+		t.Errorf("heartbeat of another agent's name = %v", err)
+	}
+	agent.heartbeats = map[string]string{}
+	agent.upsertHeartbeat("nas.example.com", "v=spf1 -all")
+	if _, err := agent.flushHeartbeats(); err == nil {
+		t.Error("non-heartbeat TXT accepted")
+	}
+}
+
+func TestRelayHeartbeatsKeptWhileDown(t *testing.T) {
+	_, relay, agent := newTestRelay(t, "nas-token-0123456789")
+	relay.Close()
+
+	agent.upsertHeartbeat("nas.example.com", "1700000000")
+	if _, err := agent.flushHeartbeats(); !errors.Is(err, errProviderUnreachable) {
+		t.Fatalf("flushHeartbeats with the relay down = %v", err)
+	}
+	if err := agent.upsertRecord("nas.example.com", "A", "203.0.113.1", false); err == nil {
+		t.Fatal("upsertRecord with the relay down succeeded")
+	}
+	if got := agent.heartbeats["nas.example.com"]; got != "1700000000" {
+		t.Fatalf("held-back heartbeat = %q after failures", got)
+	}
+
+	// A newer heartbeat is not overwritten by a failed older one
+	agent.upsertHeartbeat("nas.example.com", "1700000060")
+	agent.restoreHeartbeats(map[string]string{"nas.example.com": "1700000000"})
+	if got := agent.heartbeats["nas.example.com"]; got != "1700000060" {
+		t.Errorf("restored heartbeat = %q, want the newer one", got)
+	}
+}
+
+// writeTestCertificates creates a CA and a client certificate with the given common name
+// signed by it, returning the paths of the CA certificate, client certificate and key
+func writeTestCertificates(t *testing.T, commonName string) (caFile, certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test agents CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile = filepath.Join(dir, "ca.pem")
+	certFile = filepath.Join(dir, "agent.pem")
+	keyFile = filepath.Join(dir, "agent-key.pem")
+	for path, block := range map[string]*pem.Block{
+		caFile:   {Type: "CERTIFICATE", Bytes: caDER},
+		certFile: {Type: "CERTIFICATE", Bytes: certDER},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return caFile, certFile, keyFile
+}
+
+func TestRelayMutualTLS(t *testing.T) {
+	resetProviderRequests(t)
+	clientCA, certFile, keyFile := writeTestCertificates(t, "nas-agent")
+	_, otherCert, otherKey := writeTestCertificates(t, "nas-agent") // Same name, unknown CA
+
+	fake, cf := newFakeCloudFlare(t)
+	relay := &relayServer{
+		provider: cf,
+		agents: []relayAgent{
+			{Name: "nas", Certificate: "nas-agent", Names: []string{"nas.example.com"}},
+			{Name: "laptop", Token: "laptop-token-0123456789", Certificate: "laptop-agent", Names: []string{"laptop.example.com"}},
+		},
+		config: &Config{clock: newFakeClock(time.Unix(1700000000, 0))},
+	}
+	pool, err := loadCertPool(clientCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(relay)
+	server.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	serverCA := filepath.Join(t.TempDir(), "relay-ca.pem")
+	os.WriteFile(serverCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+
+	newAgent := func(token, cert, key string) *RelayProvider {
+		t.Helper()
+		agent, err := newRelayProvider(&Config{RelayURL: server.URL, RelayToken: token,
+			RelayClientCert: cert, RelayClientKey: key, RelayCACert: serverCA, RecordTTL: 120})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return agent
+	}
+
+	if err := newAgent("", certFile, keyFile).upsertRecord("nas.example.com", "A", "203.0.113.1", false); err != nil {
+		t.Fatalf("upsertRecord with a client certificate = %v", err)
+	}
+	if got := fake.contents("nas.example.com", "A"); !slices.Equal(got, []string{"203.0.113.1"}) {
+		t.Errorf("A records = %v", got)
+	}
+	// The client certificate is added below the middleware stack, not in place of it
+	providerRequests.mu.Lock()
+	counted := providerRequests.counts[[2]string{providerRelay, "200"}]
+	providerRequests.mu.Unlock()
+	if counted == 0 {
+		t.Error("requests with a client certificate bypassed the provider middleware stack")
+	}
+	if newAgent("", otherCert, otherKey).probeConnectivity() {
+		t.Error("probe succeeded with a certificate of an unknown CA")
+	}
+	// The laptop agent must present both its token and its certificate
+	if newAgent("laptop-token-0123456789", "", "").probeConnectivity() {
+		t.Error("probe succeeded with the token only of an agent that also needs a certificate")
+	}
+
+	if _, err := newRelayProvider(&Config{RelayURL: server.URL}); err == nil {
+		t.Error("agent without a token or certificate accepted")
+	}
+	if _, err := newRelayProvider(&Config{RelayURL: server.URL, RelayClientCert: certFile}); err == nil {
+		t.Error("client certificate without a key accepted")
+	}
+}
+
+func TestRelayCleanup(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	now := time.Unix(1700000000, 0)
+	relay := &relayServer{
+		provider: cf,
+		agents:   []relayAgent{{Name: "nas", Token: "nas-token-0123456789", Names: []string{"*.lab.example.com"}}},
+		config:   &Config{clock: newFakeClock(now), StaleThreshold: 3600, CleanupInterval: 300},
+	}
+	stale := heartbeatContent(now.Add(-2 * time.Hour))
+	fake.add("A", "old.lab.example.com", "203.0.113.1")
+	fake.add("TXT", "old.lab.example.com", stale)
+	fake.add("A", "new.lab.example.com", "203.0.113.2")
+	fake.add("TXT", "new.lab.example.com", heartbeatContent(now))
+	fake.add("A", "other.example.com", "203.0.113.3")
+	fake.add("TXT", "other.example.com", stale)
+
+	if next := relay.cleanup(); next != 300*time.Second {
+		t.Errorf("next cleanup in %s, want 5m", next)
+	}
+	if got := fake.contents("old.lab.example.com", "A"); len(got) != 0 {
+		t.Errorf("stale agent name kept %v", got)
+	}
+	if got := fake.contents("new.lab.example.com", "A"); len(got) != 1 {
+		t.Errorf("fresh agent name = %v", got)
+	}
+	if got := fake.contents("other.example.com", "A"); len(got) != 1 {
+		t.Errorf("name no agent may change = %v, want it left alone", got)
+	}
+}
+
+func TestRelayAgentAllowed(t *testing.T) {
+	agent := &relayAgent{Names: []string{"nas.example.com", "*.lab.example.com"}}
+	for name, want := range map[string]bool{
+		"nas.example.com":               true,
+		"NAS.example.com.":              true,
+		"_changelog.nas.example.com":    true,
+		"a.lab.example.com":             true,
+		"a.b.lab.example.com":           true,
+		"lab.example.com":               false,
+		"evilnas.example.com":           false,
+		"x.nas.example.com":             false,
+		"_changelog.laptop.example.com": false,
+	} {
+		if got := agent.allowed(name); got != want {
+			t.Errorf("allowed(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLoadRelayAgents(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		file    string
+		wantErr string
+	}{
+		{`{"agents": [{"name": "nas", "token": "nas-token-0123456789", "names": ["NAS.example.com."]}]}`, ""},
+		{`{"agents": []}`, "no agents"},
+		{`{"agents": [{"name": "nas", "token": "short", "names": ["nas.example.com"]}]}`, "at least 16"},
+		{`{"agents": [{"name": "nas", "certificate": "nas-agent", "names": ["nas.example.com"]}]}`, ""},
+		{`{"agents": [{"name": "nas", "names": ["nas.example.com"]}]}`, "token or a certificate"},
+		{`{"agents": [{"name": "nas", "token": "nas-token-0123456789"}]}`, "may not change any names"},
+		{`{"agents": [{"name": "a", "token": "same-token-0123456789", "names": ["a.example.com"]},
+			{"name": "b", "token": "same-token-0123456789", "names": ["b.example.com"]}]}`, "share a token"},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, "agents.json")
+		if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		agents, err := loadRelayAgents(path)
+		if tt.wantErr == "" {
+			if err != nil || len(agents) != 1 || agents[0].Names[0] != "nas.example.com" {
+				t.Errorf("%d: loadRelayAgents = %+v, %v", i, agents, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%d: loadRelayAgents = %v, want error containing %q", i, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRelayPassesProxiedAndTTL(t *testing.T) {
+	fake, server, _ := newTestRelay(t, "nas-token-0123456789")
+	agent, err := newRelayProvider(&Config{RelayURL: server.URL, RelayToken: "nas-token-0123456789", RecordTTL: 600})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := agent.upsertRecord("nas.example.com", "A", "203.0.113.1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.upsertRecord("nas.example.com", "A", "203.0.113.2", true); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	var found bool
+	for _, r := range fake.records {
+		if r.Name == "nas.example.com" && r.Type == "A" {
+			found = true
+			if r.Content != "203.0.113.2" || !r.Proxied || r.TTL != 600 {
+				t.Errorf("record = %+v, want 203.0.113.2 proxied with TTL 600", r)
+			}
+		}
+	}
+	if !found {
+		t.Error("no A record published")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// The relay checks every record set an agent sends against a policy before applying it, so
+// a compromised agent can only affect its own names, within limits. A policy holds the
+// record types an agent may change and how many records it may hold; the "policy" section
+// of the agents file sets defaults that agents can override:
+//
+//	{"policy": {"types": ["A", "AAAA"], "max_records": 16},
+//	 "agents": [{"name": "nas", "token": "...", "names": ["nas.example.com"], "max_records": 64}]}
+//
+// The checks themselves are relayRules, run in order; the first violation rejects the
+// change with 403 Forbidden.
+
+// errRelayPolicy marks changes rejected by the relay policy
+var errRelayPolicy = errors.New("relay policy")
+
+// relayPolicy limits what an agent may change
+type relayPolicy struct {
+	Types      []string `json:"types,omitempty"`       // Record types the agent may change (empty = all relay types)
+	MaxRecords int      `json:"max_records,omitempty"` // Records the agent may hold across its names (0 = unlimited)
+}
+
+// relayRule checks one aspect of a record set an agent wants to store and describes the
+// violation, if any. Rules run while the relay holds its change lock, so they see the
+// records as they are when the change is applied.
+type relayRule func(s *relayServer, agent *relayAgent, set relayRecordSet) error
+
+// relayRules are the checks applied to every record set, in order
+var relayRules = []relayRule{relayRuleNames, relayRuleTypes, relayRuleMaxRecords}
+
+// authorize runs the relay rules for a record set
+func (s *relayServer) authorize(agent *relayAgent, set relayRecordSet) error {
+	for _, rule := range relayRules {
+		if err := rule(s, agent, set); err != nil {
+			log.Printf("Relay: agent %s may not store %s %s: %v - rejected", agent.Name, set.Type, set.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// relayRuleNames allows only the agent's own names
+func relayRuleNames(s *relayServer, agent *relayAgent, set relayRecordSet) error {
+	if !agent.allowed(set.Name) {
+		return fmt.Errorf("%w: name not allowed for agent %s", errRelayPolicy, agent.Name)
+	}
+	return nil
+}
+
+// relayRuleTypes allows only the agent's record types
+func relayRuleTypes(s *relayServer, agent *relayAgent, set relayRecordSet) error {
+	if len(agent.Types) > 0 && !slices.Contains(agent.Types, set.Type) {
+		return fmt.Errorf("%w: record type %s not allowed for agent %s", errRelayPolicy, set.Type, agent.Name)
+	}
+	return nil
+}
+
+// relayRuleMaxRecords keeps the agent within its record quota. Heartbeats and changelogs
+// do not count; the records the set replaces do not either.
+func relayRuleMaxRecords(s *relayServer, agent *relayAgent, set relayRecordSet) error {
+	if agent.MaxRecords <= 0 || len(set.Values) == 0 {
+		return nil
+	}
+	types := agent.Types
+	if len(types) == 0 {
+		types = relayRecordTypes
+	}
+	count := len(set.Values)
+	for _, recordType := range types {
+		records, err := s.provider.getAllRecordsByType(recordType)
+		if err != nil {
+			return fmt.Errorf("counting the records of agent %s: %w", agent.Name, err)
+		}
+		for _, record := range records {
+			name := normalizeName(record.Name)
+			switch {
+			case !agent.allowed(name), name == set.Name && recordType == set.Type:
+			case recordType == "TXT" && strings.HasPrefix(name, changelogRecordName("")):
+			case recordType == "TXT" && isHeartbeat(record.Content):
+			default:
+				count++
+			}
+		}
+	}
+	if count > agent.MaxRecords {
+		return fmt.Errorf("%w: agent %s would hold %d records, more than its limit of %d", errRelayPolicy, agent.Name, count, agent.MaxRecords)
+	}
+	return nil
+}
+
+// isHeartbeat reports whether TXT content is a heartbeat
+func isHeartbeat(content string) bool {
+	_, ok := parseHeartbeat(content)
+	return ok
+}
+
+// resolve applies the defaults to an agent's policy and checks it
+func (p *relayPolicy) resolve(defaults relayPolicy) error {
+	if p.Types == nil {
+		p.Types = defaults.Types
+	}
+	if p.MaxRecords == 0 {
+		p.MaxRecords = defaults.MaxRecords
+	}
+	types := make([]string, 0, len(p.Types))
+	for _, t := range p.Types {
+		t = strings.ToUpper(t)
+		if !validRelayType(t) {
+			return fmt.Errorf("record type %q cannot be changed through the relay (use %s)", t, strings.Join(relayRecordTypes, ", "))
+		}
+		types = append(types, t)
+	}
+	p.Types = types
+	if p.MaxRecords < 0 {
+		return fmt.Errorf("max_records must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPolicyTestRelay starts a relay serving one agent "nas" with the given policy and
+// returns the fake zone and the agent's provider
+func newPolicyTestRelay(t *testing.T, policy relayPolicy) (*fakeCloudFlare, *RelayProvider) {
+	t.Helper()
+	fake, cf := newFakeCloudFlare(t)
+	relay := &relayServer{
+		provider: cf,
+		agents: []relayAgent{{Name: "nas", Token: "nas-token-0123456789",
+			Names: []string{"nas.example.com", "*.nas.example.com"}, relayPolicy: policy}},
+		config: &Config{clock: newFakeClock(time.Unix(1700000000, 0))},
+	}
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+
+	agent, err := newRelayProvider(&Config{RelayURL: server.URL, RelayToken: "nas-token-0123456789"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, agent
+}
+
+func TestRelayPolicyTypes(t *testing.T) {
+	fake, agent := newPolicyTestRelay(t, relayPolicy{Types: []string{"A", "AAAA"}})
+
+	if err := agent.upsertRecord("nas.example.com", "A", "203.0.113.1", false); err != nil {
+		t.Fatalf("allowed type rejected: %v", err)
+	}
+	if err := agent.upsertRecord("nas.example.com", "CNAME", "evil.example.net", false); !errors.Is(err, errProviderAuth) {
+		t.Errorf("CNAME with only A and AAAA allowed = %v, want an auth error", err)
+	}
+	if got := fake.contents("nas.example.com", "CNAME"); len(got) != 0 {
+		t.Errorf("CNAME stored: %v", got)
+	}
+
+	// Heartbeats are not subject to the types
+	agent.upsertHeartbeat("nas.example.com", "1700000000")
+	if _, err := agent.flushHeartbeats(); err != nil {
+		t.Errorf("heartbeat rejected: %v", err)
+	}
+}
+
+func TestRelayPolicyMaxRecords(t *testing.T) {
+	fake, agent := newPolicyTestRelay(t, relayPolicy{MaxRecords: 3})
+	fake.add("A", "nas.example.com", "192.168.1.10")
+	fake.add("A", "nas.example.com", "192.168.1.11")
+	fake.add("TXT", "nas.example.com", `"1700000000"`)      // Heartbeats don't count
+	fake.add("TXT", "_changelog.nas.example.com", "v1;...") // Nor do changelogs
+	fake.add("A", "laptop.example.com", "192.168.1.50")     // Nor do other agents' records
+
+	if err := agent.ensureRecordExists("v6.nas.example.com", "AAAA", "2001:db8::1", false); err != nil {
+		t.Fatalf("third record rejected: %v", err)
+	}
+	err := agent.ensureRecordExists("nas.example.com", "A", "192.168.1.12", false)
+	if !errors.Is(err, errProviderAuth) || !strings.Contains(err.Error(), "limit of 3") {
+		t.Errorf("fourth record = %v, want it rejected by the limit", err)
+	}
+	if got := fake.contents("nas.example.com", "A"); len(got) != 2 {
+		t.Errorf("A records = %v after a rejected change", got)
+	}
+
+	// Replacing a record stays within the limit
+	if err := agent.upsertRecord("v6.nas.example.com", "AAAA", "2001:db8::2", false); err != nil {
+		t.Errorf("replacing a record = %v", err)
+	}
+	if got := fake.contents("v6.nas.example.com", "AAAA"); !slices.Equal(got, []string{"2001:db8::2"}) {
+		t.Errorf("AAAA records = %v", got)
+	}
+}
+
+func TestLoadRelayAgentsPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agents.json")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"policy": {"types": ["a", "AAAA"], "max_records": 8}, "agents": [
+		{"name": "nas", "token": "nas-token-0123456789", "names": ["nas.example.com"]},
+		{"name": "web", "token": "web-token-0123456789", "names": ["www.example.com"], "types": ["CNAME"], "max_records": 1}]}`)
+	agents, err := loadRelayAgents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(agents[0].Types, []string{"A", "AAAA"}) || agents[0].MaxRecords != 8 {
+		t.Errorf("defaults not applied: %+v", agents[0].relayPolicy)
+	}
+	if !slices.Equal(agents[1].Types, []string{"CNAME"}) || agents[1].MaxRecords != 1 {
+		t.Errorf("agent policy not kept: %+v", agents[1].relayPolicy)
+	}
+
+	write(`{"agents": [{"name": "nas", "token": "nas-token-0123456789", "names": ["nas.example.com"], "types": ["MX"]}]}`)
+	if _, err := loadRelayAgents(path); err == nil || !strings.Contains(err.Error(), `"MX"`) {
+		t.Errorf("unsupported type = %v", err)
+	}
+}
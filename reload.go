@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+// Configuration reload. In daemon and cleanup modes, SIGHUP reads the environment and the
+// config file again between runs, validating them like at startup. An invalid configuration
+// is reported and the running one kept; a valid one replaces it (with a new provider client,
+// so that changed credentials apply) and what changed is logged.
+
+// configFatalf and configFatal report invalid configuration. They exit at startup; a reload
+// swaps them for ones that abort the reload instead.
+var (
+	configFatalf = log.Fatalf
+	configFatal  = log.Fatal
+)
+
+// configReloadError carries the error that aborted a reload out of readConfig
+type configReloadError string
+
+func (e configReloadError) Error() string {
+	return strings.TrimPrefix(string(e), "ERROR: ")
+}
+
+// configReloader reads and validates the configuration again, returning it with a provider
+// client for it
+type configReloader func() (*Config, providerClient, error)
+
+// newConfigReloader returns a reloader applying the -only and -skip domain filters and, if
+// unmanaged is set, handling pre-existing records in that mode like at startup, so that a
+// reload does not take over the records warn mode left alone
+func newConfigReloader(cleanupMode bool, only, skip []string, unmanaged string) configReloader {
+	return func() (*Config, providerClient, error) {
+		config, err := reloadConfig(cleanupMode)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := filterDomains(config, only, skip); err != nil {
+			return nil, nil, err
+		}
+		cf, err := newProviderClient(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		if unmanaged != "" && !handleUnmanagedRecords(cf, config, unmanaged) {
+			return nil, nil, fmt.Errorf("the new configuration manages records dynipupdate did not create")
+		}
+		return config, cf, nil
+	}
+}
+
+// reloadConfig reads the configuration like loadConfig, but returns an error instead of
+// exiting when it is invalid. The config file values in use are kept on errors.
+func reloadConfig(cleanupMode bool) (*Config, error) {
+	return tryReadConfig(cleanupMode, true)
+}
+
+// tryReadConfig is readConfig returning an error instead of exiting, like reloadConfig
+func tryReadConfig(cleanupMode, requireCredentials bool) (config *Config, err error) {
+	savedValues, savedConsumed := configFileValues, consumedConfigKeys
+	configFatalf = func(format string, v ...interface{}) { panic(configReloadError(fmt.Sprintf(format, v...))) }
+	configFatal = func(v ...interface{}) { panic(configReloadError(fmt.Sprint(v...))) }
+	defer func() {
+		configFatalf, configFatal = log.Fatalf, log.Fatal
+		r := recover()
+		if r == nil {
+			return
+		}
+		reloadErr, ok := r.(configReloadError)
+		if !ok {
+			panic(r)
+		}
+		configFileValues, consumedConfigKeys = savedValues, savedConsumed
+		config, err = nil, reloadErr
+	}()
+
+	configFileValues, consumedConfigKeys = make(map[string]string), make(map[string]bool)
+	return readConfig(cleanupMode, requireCredentials), nil
+}
+
+// reloadSignal returns a channel receiving SIGHUP
+func reloadSignal() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	return signals
+}
+
+// restartOnlySettings are read once at startup; changes to them are reported as needing a restart
+var restartOnlySettings = map[string]bool{
+	"DockerMode":    true,
+	"HealthListen":  true,
+	"HealthMaxAge":  true,
+	"MetricsListen": true,
+	"WatchNetwork":  true,
+}
+
+// configDiff describes the settings that differ between two configurations, one line per
+// setting with secrets masked
+func configDiff(old, next *Config) []string {
+	var changes []string
+	oldValue, nextValue := reflect.ValueOf(*old), reflect.ValueOf(*next)
+	for i := 0; i < oldValue.NumField(); i++ {
+		field := oldValue.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		a, b := oldValue.Field(i).Interface(), nextValue.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		line := fmt.Sprintf("%s: %s -> %s", field.Name, formatConfigValue(field.Name, a), formatConfigValue(field.Name, b))
+		if restartOnlySettings[field.Name] {
+			line += " (takes effect after a restart)"
+		}
+		changes = append(changes, line)
+	}
+	return changes
+}
+
+// formatConfigValue formats a setting for the reload diff and the support bundle, masking
+// secrets and the user:password of URLs
+func formatConfigValue(name string, value interface{}) string {
+	if isSecretSetting(name) {
+		if reflect.ValueOf(value).IsZero() {
+			return "(unset)"
+		}
+		return "(secret)"
+	}
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return "(unset)"
+		}
+		return maskURLUserinfo(v)
+	case []string:
+		if v != nil {
+			masked := make([]string, len(v))
+			for i, s := range v {
+				masked[i] = maskURLUserinfo(s)
+			}
+			value = masked
+		}
+	case bool, int:
+		return fmt.Sprint(v)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// applyReload reloads the configuration, logging what changed. It returns false (and logs
+// why) when the running configuration is kept.
+func applyReload(reload configReloader, config *Config) (*Config, providerClient, bool) {
+	log.Println("Received SIGHUP - reloading configuration")
+	next, cf, err := reload()
+	if err != nil {
+		log.Printf("WARNING: Configuration not reloaded, keeping the running one: %v", err)
+		return nil, nil, false
+	}
+	setupLogging(next.LogFormat, next.LogLevel, next.Provider)
+	changes := configDiff(config, next)
+	if len(changes) == 0 {
+		log.Println("Configuration reloaded: nothing changed")
+	} else {
+		log.Printf("Configuration reloaded: %d setting(s) changed", len(changes))
+		for _, change := range changes {
+			log.Printf("  %s", change)
+		}
+	}
+	return next, cf, true
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Replay. Each entry of the change journal (HISTORY_FILE) records the addresses its run
+// reconciled to. "dynipupdate -replay <seq|last>" runs the planner again on that detection,
+// against the check mode client: the live records are read, nothing is written. The clock
+// is set to the time of the entry and random delays (retry backoff) come from a source
+// seeded with it, so the replay decides the same way on every run and its log shows why.
+// The plan is printed and compared with the changes the journal recorded:
+//
+//	0  the replay planned the recorded changes
+//	1  the entry or the provider could not be read
+//	2  the plan differs from the recorded changes
+//
+// The records are read as they are now, so once a run's changes are in place its replay
+// usually plans nothing; replaying is most useful right after a surprising change, or to
+// see how the current configuration treats an old detection.
+
+// fixedClock always reads the same time
+type fixedClock struct {
+	t time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.t
+}
+
+// readHistoryEntry returns the journal entry with the sequence number ("last" for the
+// newest entry)
+func readHistoryEntry(r io.Reader, selector string) (*HistoryEntry, error) {
+	seq := -1
+	if selector != "last" {
+		n, err := strconv.Atoi(selector)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid journal entry %q (use a sequence number or \"last\")", selector)
+		}
+		seq = n
+	}
+
+	var found *HistoryEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // Verification reports broken lines; only the selected entry matters here
+		}
+		if seq < 0 || entry.Seq == seq {
+			found = &entry
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("journal entry %s not found", selector)
+	}
+	return found, nil
+}
+
+// runReplay plans the run of a journal entry again from its recorded detection
+func runReplay(cf providerClient, config *Config, entry *HistoryEntry) (*runResult, error) {
+	if entry.Detection == nil {
+		return nil, fmt.Errorf("journal entry %d has no detection to replay (recorded by a relay or an older version)", entry.Seq)
+	}
+	disableSideEffects(config)
+	recordedAt := time.Unix(entry.Timestamp, 0)
+	config.clock = fixedClock{t: recordedAt}
+	jitterSource = newSeededRandom(entry.Timestamp)
+
+	if !cf.probeConnectivity() {
+		return nil, fmt.Errorf("DNS provider API is unreachable")
+	}
+	log.Printf("Replaying journal entry %d of %s", entry.Seq, recordedAt.Format(time.RFC3339))
+
+	plan := newDryRunClient(cf)
+	var changes []RecordChange
+	plan.hooks().OnChange = newChangeRecorder(&changes)
+	ips := *entry.Detection
+	successCount, totalCount := reconcileRecords(plan, config, &ips, recordedAt)
+	return newRunResult("replay", changes, successCount, totalCount), nil
+}
+
+// replayDifferences lists the changes only the journal entry or only the replay has. Deletes
+// are compared by name and type: the journal of a real run does not always know the
+// content a delete removed.
+func replayDifferences(entry *HistoryEntry, result *runResult) []string {
+	key := func(action, recordType, name, content string) string {
+		if action == "delete" {
+			return fmt.Sprintf("%s %s %s", action, recordType, name)
+		}
+		return fmt.Sprintf("%s %s %s %s", action, recordType, name, content)
+	}
+	recorded := make(map[string]bool)
+	for _, c := range entry.Changes {
+		if c.Type != "TXT" {
+			recorded[key(c.Action, c.Type, c.Name, c.Content)] = true
+		}
+	}
+	var differences []string
+	for _, c := range result.Changes {
+		k := key(c.Action, c.Type, c.Name, c.Content)
+		if recorded[k] {
+			delete(recorded, k)
+			continue
+		}
+		differences = append(differences, "planned, not recorded: "+k)
+	}
+	for _, c := range entry.Changes {
+		if k := key(c.Action, c.Type, c.Name, c.Content); recorded[k] {
+			differences = append(differences, "recorded, not planned: "+k)
+			delete(recorded, k)
+		}
+	}
+	return differences
+}
+
+// replayEntry implements -replay: it replays the selected journal entry and returns the exit code
+func replayEntry(cf providerClient, config *Config, selector string, ci bool) int {
+	if config.HistoryFile == "" {
+		log.Printf("ERROR: -replay needs the change journal (%sHISTORY_FILE)", envPrefix)
+		return checkExitFailed
+	}
+	f, err := os.Open(config.HistoryFile)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return checkExitFailed
+	}
+	entry, err := readHistoryEntry(f, selector)
+	f.Close()
+	if err != nil {
+		log.Printf("ERROR: %s: %v", config.HistoryFile, err)
+		return checkExitFailed
+	}
+
+	result, err := runReplay(cf, config, entry)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return checkExitFailed
+	}
+	result.write(os.Stdout, ci)
+	differences := replayDifferences(entry, result)
+	for _, d := range differences {
+		log.Printf("Replay differs from the journal: %s", d)
+	}
+	if result.Failed {
+		return checkExitFailed
+	}
+	if len(differences) > 0 {
+		return checkExitChanged
+	}
+	log.Printf("Replay planned the %d change(s) recorded in journal entry %d", len(result.Changes), entry.Seq)
+	return checkExitInSync
+}
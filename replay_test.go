@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayJournalEntry(t *testing.T) {
+	t.Cleanup(func() { jitterSource = systemRandom{} })
+	dir := t.TempDir()
+	keyPath, _ := writeTestSigningKey(t, dir)
+	newConfig := func() *Config {
+		return &Config{
+			InternalDomain:    "nas.int.example.com",
+			ExternalDomain:    "home.example.com",
+			HistoryFile:       filepath.Join(dir, "history.jsonl"),
+			HistorySigningKey: keyPath,
+			clock:             newFakeClock(time.Unix(1700000000, 0)),
+		}
+	}
+	seed := func(fake *fakeCloudFlare) {
+		fake.add("A", "home.example.com", "203.0.113.1")
+		fake.add("A", "nas.int.example.com", "192.168.1.99")
+	}
+
+	// A run records its changes and detection
+	fake, runCF := newFakeCloudFlare(t)
+	seed(fake)
+	ips := &IPAddresses{InternalIPv4: []string{"192.168.1.10"}, ExternalIPv4: "203.0.113.2"}
+	reconcileRecords(runCF, newConfig(), ips, time.Now())
+	journal, _ := os.ReadFile(filepath.Join(dir, "history.jsonl"))
+
+	// Against the records as they were, the replay plans the same changes
+	before, cf := newFakeCloudFlare(t)
+	seed(before)
+	if code := replayEntry(cf, newConfig(), "last", true); code != checkExitInSync {
+		t.Errorf("replay before the run: exit code %d, want %d", code, checkExitInSync)
+	}
+	if got := before.contents("home.example.com", "A"); len(got) != 1 || got[0] != "203.0.113.1" {
+		t.Errorf("replay changed the external record to %v", got)
+	}
+
+	// Once the changes are in place, the replay plans nothing and differs from the journal
+	if code := replayEntry(runCF, newConfig(), "0", true); code != checkExitChanged {
+		t.Errorf("replay after the run: exit code %d, want %d", code, checkExitChanged)
+	}
+
+	if after, _ := os.ReadFile(filepath.Join(dir, "history.jsonl")); !bytes.Equal(after, journal) {
+		t.Errorf("replay wrote to the journal:\n%s", after)
+	}
+	if code := replayEntry(cf, newConfig(), "5", true); code != checkExitFailed {
+		t.Errorf("replay of a missing entry: exit code %d, want %d", code, checkExitFailed)
+	}
+}
+
+func TestReadHistoryEntry(t *testing.T) {
+	journal := `{"seq":0,"ts":1700000000,"changes":[],"detection":{"external_ipv4":"203.0.113.1"},"prev":""}
+{"seq":1,"ts":1700000300,"changes":[],"prev":"ab"}
+`
+	entry, err := readHistoryEntry(strings.NewReader(journal), "0")
+	if err != nil || entry.Detection == nil || entry.Detection.ExternalIPv4 != "203.0.113.1" {
+		t.Fatalf("entry 0 = %+v, %v", entry, err)
+	}
+	entry, err = readHistoryEntry(strings.NewReader(journal), "last")
+	if err != nil || entry.Seq != 1 {
+		t.Fatalf("last entry = %+v, %v", entry, err)
+	}
+	if _, err := runReplay(nil, &Config{}, entry); err == nil || !strings.Contains(err.Error(), "no detection") {
+		t.Errorf("replay of an entry without detection: err = %v", err)
+	}
+	for _, selector := range []string{"2", "-1", "first"} {
+		if _, err := readHistoryEntry(strings.NewReader(journal), selector); err == nil {
+			t.Errorf("entry %q: no error", selector)
+		}
+	}
+}
+
+func TestSeededRandomIsDeterministic(t *testing.T) {
+	a, b := newSeededRandom(1700000000), newSeededRandom(1700000000)
+	for i := 0; i < 10; i++ {
+		if x, y := a.Int63n(1000), b.Int63n(1000); x != y {
+			t.Fatalf("draw %d: %d != %d", i, x, y)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Defaults for retrying failed provider API requests
+const (
+	defaultAPIRetryAttempts   = 3
+	defaultAPIRetryBackoff    = 1  // Seconds before the first retry, doubling with each attempt
+	defaultAPIRetryMaxBackoff = 30 // Seconds; also the longest Retry-After that is waited for
+)
+
+// providerRequestTimeout bounds each attempt of a provider API request
+const providerRequestTimeout = 30 * time.Second
+
+// retryPolicy describes how provider API requests are retried
+type retryPolicy struct {
+	attempts   int // Including the first attempt
+	backoff    time.Duration
+	maxBackoff time.Duration
+	sleep      func(time.Duration) // nil = time.Sleep
+}
+
+// newRetryPolicy returns the configured retry policy (nil if requests are not retried)
+func newRetryPolicy(config *Config) *retryPolicy {
+	if config.APIRetryAttempts <= 1 {
+		return nil
+	}
+	return &retryPolicy{
+		attempts:   config.APIRetryAttempts,
+		backoff:    time.Duration(config.APIRetryBackoff) * time.Second,
+		maxBackoff: time.Duration(config.APIRetryMaxBackoff) * time.Second,
+	}
+}
+
+// delay returns the wait before the given retry (1 = first retry): exponential backoff
+// with jitter, so hosts failing at the same moment don't retry in lockstep
+func (p *retryPolicy) delay(retry int) time.Duration {
+	d := p.backoff
+	for i := 1; i < retry && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(jitterSource.Int63n(int64(d/2)+1))
+}
+
+// newProviderHTTPClient returns the HTTP client of a provider, sending its requests through
+// the configured middleware stack (see transport.go): each attempt is bounded by
+// providerRequestTimeout, and transient failures are retried as configured
+func newProviderHTTPClient(config *Config, name string) *http.Client {
+	return newProviderStack(config, name).client()
+}
+
+// newRetryHTTPClient returns an HTTP client retrying with the policy (nil = no retries)
+func newRetryHTTPClient(policy *retryPolicy, timeout time.Duration) *http.Client {
+	if policy == nil {
+		return &http.Client{Timeout: timeout}
+	}
+	// The timeout applies per attempt; http.Client.Timeout would also cover the backoff
+	return &http.Client{Transport: &retryTransport{policy: policy, timeout: timeout}}
+}
+
+// retryTransport retries requests that failed with a network error, a 5xx response that
+// is likely transient, or 429 Too Many Requests (honoring its Retry-After header)
+type retryTransport struct {
+	policy  *retryPolicy
+	timeout time.Duration
+	base    http.RoundTripper // nil = http.DefaultTransport
+}
+
+// retryableStatus reports whether a response status is worth retrying
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header (seconds or an HTTP date); false if absent
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	sleep := t.policy.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptReq, cancel, err := t.prepare(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := base.RoundTrip(attemptReq)
+
+		var reason string
+		switch {
+		case err != nil:
+			cancel()
+			if cancelled := cycleCancelled(); cancelled != nil {
+				return nil, cancelled
+			}
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			reason = err.Error()
+		case retryableStatus(resp.StatusCode):
+			reason = resp.Status
+		default:
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		if attempt >= t.policy.attempts || (req.Body != nil && req.GetBody == nil) {
+			return t.giveUp(resp, err, cancel)
+		}
+		wait := t.policy.delay(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp.Header, time.Now()); ok {
+				// A rate limit that resets later than we are willing to wait is left
+				// to the caller (and the offline queue) instead of blocking the run
+				if after > t.policy.maxBackoff {
+					return t.giveUp(resp, nil, cancel)
+				}
+				wait = after
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+			cancel()
+		}
+
+		log.Printf("%s %s failed (%s), retrying in %s (attempt %d of %d)",
+			req.Method, req.URL.Path, reason, wait.Round(time.Millisecond), attempt+1, t.policy.attempts)
+		sleep(wait)
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		if err := cycleCancelled(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// prepare returns the request of one attempt, with a fresh body and its own timeout; the
+// attempt is also cancelled with the running update cycle (see cycle.go)
+func (t *retryTransport) prepare(req *http.Request, attempt int) (*http.Request, context.CancelFunc, error) {
+	if err := cycleCancelled(); err != nil {
+		return nil, nil, err
+	}
+	var ctx context.Context
+	var cancelAttempt context.CancelFunc
+	if t.timeout > 0 {
+		ctx, cancelAttempt = context.WithTimeout(req.Context(), t.timeout)
+	} else {
+		ctx, cancelAttempt = context.WithCancel(req.Context())
+	}
+	stopCycle := context.AfterFunc(cycleContext(), cancelAttempt)
+	cancel := func() {
+		stopCycle()
+		cancelAttempt()
+	}
+	attemptReq := req.Clone(ctx)
+	if attempt > 1 && req.Body != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		attemptReq.Body = body
+	}
+	return attemptReq, cancel, nil
+}
+
+// giveUp returns the last failure of a request
+func (t *retryTransport) giveUp(resp *http.Response, err error, cancel context.CancelFunc) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases an attempt's context once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
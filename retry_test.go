@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// retryTestServer answers with the given statuses in turn (200 once they run out),
+// recording the request bodies
+func retryTestServer(t *testing.T, statuses []int, header http.Header) (*httptest.Server, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		n := len(bodies)
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		if n < len(statuses) {
+			w.WriteHeader(statuses[n])
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(server.Close)
+	return server, &bodies
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		header    http.Header
+		wantCode  int
+		wantCalls int
+		wantWaits []time.Duration // nil = only check the count against the backoff bounds
+	}{
+		{"success", nil, nil, 200, 1, []time.Duration{}},
+		{"transient errors", []int{503, 502}, nil, 200, 3, nil},
+		{"gives up after the attempts", []int{500, 500, 500, 500}, nil, 500, 3, nil},
+		{"client error not retried", []int{400}, nil, 400, 1, []time.Duration{}},
+		{"retry-after honored", []int{429}, http.Header{"Retry-After": {"2"}}, 200, 2, []time.Duration{2 * time.Second}},
+		{"retry-after too long", []int{429}, http.Header{"Retry-After": {"300"}}, 429, 1, []time.Duration{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, bodies := retryTestServer(t, tt.statuses, tt.header)
+			var waits []time.Duration
+			policy := &retryPolicy{attempts: 3, backoff: time.Second, maxBackoff: 30 * time.Second,
+				sleep: func(d time.Duration) { waits = append(waits, d) }}
+			client := newRetryHTTPClient(policy, 5*time.Second)
+
+			resp, err := client.Post(server.URL, "application/json", strings.NewReader(`{"content":"203.0.113.1"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantCode || len(*bodies) != tt.wantCalls {
+				t.Errorf("status %d after %d calls, want %d after %d", resp.StatusCode, len(*bodies), tt.wantCode, tt.wantCalls)
+			}
+			for i, body := range *bodies {
+				if body != `{"content":"203.0.113.1"}` {
+					t.Errorf("attempt %d sent body %q", i+1, body)
+				}
+			}
+			if tt.wantWaits != nil {
+				if len(waits) != len(tt.wantWaits) || len(waits) > 0 && waits[0] != tt.wantWaits[0] {
+					t.Errorf("waits = %v, want %v", waits, tt.wantWaits)
+				}
+				return
+			}
+			for i, d := range waits {
+				full := time.Second << i
+				if d < full/2 || d > full {
+					t.Errorf("wait %d = %s, want between %s and %s", i+1, d, full/2, full)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	attempts := 0
+	policy := &retryPolicy{attempts: 3, backoff: time.Second, maxBackoff: 30 * time.Second,
+		sleep: func(time.Duration) { attempts++ }}
+	if _, err := newRetryHTTPClient(policy, time.Second).Get(url); err == nil {
+		t.Fatal("request to a closed server succeeded")
+	}
+	if attempts != 2 {
+		t.Errorf("slept %d times, want 2", attempts)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := &retryPolicy{backoff: time.Second, maxBackoff: 5 * time.Second}
+	for retry := 1; retry <= 70; retry++ {
+		full := 5 * time.Second
+		if retry <= 3 {
+			full = time.Second << (retry - 1)
+		}
+		if d := p.delay(retry); d < full/2 || d > full {
+			t.Fatalf("delay(%d) = %s, want between %s and %s", retry, d, full/2, full)
+		}
+	}
+	if newRetryPolicy(&Config{APIRetryAttempts: 1}) != nil {
+		t.Error("1 attempt should disable retries")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"7", 7 * time.Second, true},
+		{"Mon, 15 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 15 Jan 2024 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.value != "" {
+			h.Set("Retry-After", tt.value)
+		}
+		if got, ok := retryAfter(h, now); got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCloudFlareRetriesTransientErrors(t *testing.T) {
+	server, bodies := retryTestServer(t, []int{503}, nil)
+	cf := &CloudFlareClient{APIToken: "test-token", ZoneID: "zone", BaseURL: server.URL,
+		Retry: &retryPolicy{attempts: 2, sleep: func(time.Duration) {}}}
+	resp, err := cf.makeRequest("GET", "/zones/zone", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || len(*bodies) != 2 {
+		t.Errorf("status %d after %d calls, want 200 after 2", resp.StatusCode, len(*bodies))
+	}
+}
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// RFC2136Provider implements DNSProvider (and the internal record operations) for
+// self-hosted authoritative servers (BIND, Knot, PowerDNS, ...) as a recordSetBackend.
+// Record sets are read with queries, replaced with RFC 2136 dynamic updates and listed
+// with a zone transfer, all over TCP and signed with TSIG (RFC 8945) when a key is set.
+type RFC2136Provider struct {
+	Server string // Primary server as host:port
+	Zone   string // Zone name without the trailing dot
+	Key    *tsigKey
+
+	timeout time.Duration
+	limiter *rateLimiter
+
+	recordSetClient
+}
+
+// Verify RFC2136Provider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*RFC2136Provider)(nil)
+var _ DNSProvider = (*RFC2136Provider)(nil)
+var _ providerClient = (*RFC2136Provider)(nil)
+
+// DNS wire format constants
+const (
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsTypeSOA   = 6
+	dnsTypeTXT   = 16
+	dnsTypeAAAA  = 28
+	dnsTypeTSIG  = 250
+	dnsTypeAXFR  = 252
+
+	dnsClassIN  = 1
+	dnsClassANY = 255
+
+	dnsOpcodeQuery  = 0
+	dnsOpcodeUpdate = 5
+
+	dnsRcodeNXDomain = 3
+
+	tsigFudge = 300 // Seconds of clock skew accepted between us and the server
+)
+
+var dnsTypes = map[string]uint16{"A": dnsTypeA, "AAAA": dnsTypeAAAA, "CNAME": dnsTypeCNAME, "TXT": dnsTypeTXT}
+
+var dnsRcodeNames = map[int]string{
+	1: "FORMERR", 2: "SERVFAIL", 3: "NXDOMAIN", 4: "NOTIMP", 5: "REFUSED",
+	6: "YXDOMAIN", 7: "YXRRSET", 8: "NXRRSET", 9: "NOTAUTH", 10: "NOTZONE",
+	16: "BADSIG", 17: "BADKEY", 18: "BADTIME",
+}
+
+// rcodeName returns the mnemonic of a DNS (or TSIG) response code
+func rcodeName(rcode int) string {
+	if name, ok := dnsRcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// tsigKey is a shared secret used to sign requests and verify responses
+type tsigKey struct {
+	Name      string // Key name as configured on the server, without the trailing dot
+	Algorithm string // hmac-sha1, hmac-sha256 or hmac-sha512
+	Secret    []byte
+}
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1":   sha1.New,
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// newTSIGKey checks the key settings; an empty name means requests are not signed
+func newTSIGKey(name, algorithm, secret string) (*tsigKey, error) {
+	if name == "" {
+		return nil, nil
+	}
+	algorithm = strings.ToLower(strings.TrimSuffix(algorithm, "."))
+	if _, ok := tsigAlgorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported TSIG algorithm %q (use hmac-sha1, hmac-sha256 or hmac-sha512)", algorithm)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(secret))
+	if err != nil || len(decoded) == 0 {
+		return nil, fmt.Errorf("TSIG secret must be base64 encoded, as in the server's key file")
+	}
+	return &tsigKey{Name: strings.ToLower(strings.TrimSuffix(name, ".")), Algorithm: algorithm, Secret: decoded}, nil
+}
+
+// newRFC2136Provider creates an RFC 2136 provider and checks the TSIG key settings
+func newRFC2136Provider(config *Config) (*RFC2136Provider, error) {
+	key, err := newTSIGKey(config.TSIGKeyName, config.TSIGAlgorithm, config.TSIGSecret)
+	if err != nil {
+		return nil, err
+	}
+	server := config.RFC2136Server
+	if _, _, splitErr := net.SplitHostPort(server); splitErr != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	p := &RFC2136Provider{
+		Server:  server,
+		Zone:    strings.ToLower(strings.TrimSuffix(config.RFC2136Zone, ".")),
+		Key:     key,
+		timeout: 10 * time.Second,
+		limiter: newRateLimiter(config.APIRateLimit),
+	}
+	p.recordSetClient = newRecordSetClient(p, config)
+
+	if key == nil {
+		log.Printf("WARNING: No TSIG key set - updates to %s are unsigned and rely on the server's address-based policy", p.Server)
+		log.Printf("Using RFC 2136 updates for zone %s on %s", p.Zone, p.Server)
+	} else {
+		log.Printf("Using RFC 2136 updates for zone %s on %s with TSIG key %s (%s)", p.Zone, p.Server, key.Name, key.Algorithm)
+	}
+
+	warnUnsupportedOptions(config, "RFC 2136")
+	return p, nil
+}
+
+// dnsRR is a resource record; Data is the uncompressed RDATA
+type dnsRR struct {
+	Name  string // Lower case, without the trailing dot
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+// dnsMessage is a DNS message. For updates, Question is the zone section and
+// Authority the update section (RFC 2136 section 2).
+type dnsMessage struct {
+	ID         uint16
+	Flags      uint16
+	Question   []dnsRR // Only Name, Type and Class are used
+	Answer     []dnsRR
+	Authority  []dnsRR
+	Additional []dnsRR
+
+	tsigOffset int // Where the TSIG record starts in a parsed message (0 if unsigned)
+}
+
+func (m *dnsMessage) rcode() int {
+	return int(m.Flags & 0xf)
+}
+
+// appendName appends a name in uncompressed wire format
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid DNS name %q", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+// pack encodes the message (names are never compressed)
+func (m *dnsMessage) pack() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[2:], m.Flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Question)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answer)))
+	binary.BigEndian.PutUint16(b[8:], uint16(len(m.Authority)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additional)))
+
+	var err error
+	for _, q := range m.Question {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, q.Class)
+	}
+	for _, section := range [][]dnsRR{m.Answer, m.Authority, m.Additional} {
+		for _, rr := range section {
+			if b, err = appendName(b, rr.Name); err != nil {
+				return nil, err
+			}
+			b = binary.BigEndian.AppendUint16(b, rr.Type)
+			b = binary.BigEndian.AppendUint16(b, rr.Class)
+			b = binary.BigEndian.AppendUint32(b, rr.TTL)
+			b = binary.BigEndian.AppendUint16(b, uint16(len(rr.Data)))
+			b = append(b, rr.Data...)
+		}
+	}
+	return b, nil
+}
+
+var errDNSTruncated = errors.New("truncated DNS message")
+
+// readName reads a possibly compressed name at off, returning it and the offset after it
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNSTruncated
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), end, nil
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errDNSTruncated
+			}
+			if jumps++; jumps > 32 {
+				return "", 0, errors.New("DNS name compression loop")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errDNSTruncated
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// unpackDNSMessage parses a message, expanding compressed names inside CNAME and SOA data
+func unpackDNSMessage(msg []byte) (*dnsMessage, error) {
+	if len(msg) < 12 {
+		return nil, errDNSTruncated
+	}
+	m := &dnsMessage{ID: binary.BigEndian.Uint16(msg[0:]), Flags: binary.BigEndian.Uint16(msg[2:])}
+	counts := [4]int{}
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(msg[4+2*i:]))
+	}
+
+	off := 12
+	for i := 0; i < counts[0]; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return nil, errDNSTruncated
+		}
+		m.Question = append(m.Question, dnsRR{Name: name, Type: binary.BigEndian.Uint16(msg[next:]), Class: binary.BigEndian.Uint16(msg[next+2:])})
+		off = next + 4
+	}
+
+	sections := []*[]dnsRR{&m.Answer, &m.Authority, &m.Additional}
+	for s, section := range sections {
+		for i := 0; i < counts[s+1]; i++ {
+			start := off
+			name, next, err := readName(msg, off)
+			if err != nil || next+10 > len(msg) {
+				return nil, errDNSTruncated
+			}
+			rr := dnsRR{
+				Name:  name,
+				Type:  binary.BigEndian.Uint16(msg[next:]),
+				Class: binary.BigEndian.Uint16(msg[next+2:]),
+				TTL:   binary.BigEndian.Uint32(msg[next+4:]),
+			}
+			length := int(binary.BigEndian.Uint16(msg[next+8:]))
+			dataStart := next + 10
+			if dataStart+length > len(msg) {
+				return nil, errDNSTruncated
+			}
+			rr.Data = msg[dataStart : dataStart+length]
+			if rr.Type == dnsTypeCNAME && length > 0 {
+				target, _, err := readName(msg, dataStart)
+				if err != nil {
+					return nil, err
+				}
+				rr.Data, _ = appendName(nil, target)
+			}
+			if rr.Type == dnsTypeTSIG {
+				m.tsigOffset = start
+			}
+			*section = append(*section, rr)
+			off = dataStart + length
+		}
+	}
+	return m, nil
+}
+
+// rrContent converts record data to record content as used elsewhere
+func rrContent(rr dnsRR) (string, bool) {
+	switch rr.Type {
+	case dnsTypeA, dnsTypeAAAA:
+		addr, ok := netip.AddrFromSlice(rr.Data)
+		if !ok {
+			return "", false
+		}
+		return addr.Unmap().String(), true
+	case dnsTypeCNAME:
+		target, _, err := readName(rr.Data, 0)
+		return target, err == nil
+	case dnsTypeTXT:
+		var values []string
+		for off := 0; off < len(rr.Data); {
+			length := int(rr.Data[off])
+			if off+1+length > len(rr.Data) {
+				return "", false
+			}
+			values = append(values, string(rr.Data[off+1:off+1+length]))
+			off += 1 + length
+		}
+		if len(values) == 1 {
+			return values[0], true
+		}
+		return formatTXTStrings(values), true
+	}
+	return "", false
+}
+
+// rrData converts record content to record data
+func rrData(recordType, content string) ([]byte, error) {
+	switch recordType {
+	case "A", "AAAA":
+		addr, err := netip.ParseAddr(content)
+		if err != nil || addr.Is4() != (recordType == "A") {
+			return nil, fmt.Errorf("invalid %s record content %q", recordType, content)
+		}
+		return addr.AsSlice(), nil
+	case "CNAME":
+		return appendName(nil, strings.ToLower(content))
+	case "TXT":
+		var data []byte
+		for _, s := range parseTXTStrings(content) {
+			// Character strings hold at most 255 bytes; split longer ones
+			for len(s) > 255 {
+				data = append(append(data, 255), s[:255]...)
+				s = s[255:]
+			}
+			data = append(append(data, byte(len(s))), s...)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("record type %s is not supported", recordType)
+}
+
+// tsigRData is the data of a TSIG record (RFC 8945 section 4.2)
+type tsigRData struct {
+	Algorithm  string
+	TimeSigned uint64
+	Fudge      uint16
+	MAC        []byte
+	OriginalID uint16
+	Error      uint16
+	Other      []byte
+}
+
+func (t tsigRData) pack() []byte {
+	b, _ := appendName(nil, t.Algorithm)
+	b = append(b, byte(t.TimeSigned>>40), byte(t.TimeSigned>>32))
+	b = binary.BigEndian.AppendUint32(b, uint32(t.TimeSigned))
+	b = binary.BigEndian.AppendUint16(b, t.Fudge)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(t.MAC)))
+	b = append(b, t.MAC...)
+	b = binary.BigEndian.AppendUint16(b, t.OriginalID)
+	b = binary.BigEndian.AppendUint16(b, t.Error)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(t.Other)))
+	return append(b, t.Other...)
+}
+
+func unpackTSIG(data []byte) (tsigRData, error) {
+	var t tsigRData
+	algorithm, off, err := readName(data, 0)
+	if err != nil || off+10 > len(data) {
+		return t, errDNSTruncated
+	}
+	t.Algorithm = algorithm
+	t.TimeSigned = uint64(binary.BigEndian.Uint16(data[off:]))<<32 | uint64(binary.BigEndian.Uint32(data[off+2:]))
+	t.Fudge = binary.BigEndian.Uint16(data[off+6:])
+	macLen := int(binary.BigEndian.Uint16(data[off+8:]))
+	off += 10
+	if off+macLen+6 > len(data) {
+		return t, errDNSTruncated
+	}
+	t.MAC = data[off : off+macLen]
+	off += macLen
+	t.OriginalID = binary.BigEndian.Uint16(data[off:])
+	t.Error = binary.BigEndian.Uint16(data[off+2:])
+	otherLen := int(binary.BigEndian.Uint16(data[off+4:]))
+	if off+6+otherLen > len(data) {
+		return t, errDNSTruncated
+	}
+	t.Other = data[off+6 : off+6+otherLen]
+	return t, nil
+}
+
+// mac computes the TSIG MAC over a message (without its TSIG record) and the TSIG
+// variables. Responses also cover the MAC of the request they answer.
+func (k *tsigKey) mac(requestMAC, msg []byte, t tsigRData) []byte {
+	h := hmac.New(tsigAlgorithms[k.Algorithm], k.Secret)
+	if requestMAC != nil {
+		h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC))))
+		h.Write(requestMAC)
+	}
+	h.Write(msg)
+
+	// The TSIG variables: key name, class, TTL and the TSIG data except the MAC and original ID
+	vars, _ := appendName(nil, k.Name)
+	vars = binary.BigEndian.AppendUint16(vars, dnsClassANY)
+	vars = binary.BigEndian.AppendUint32(vars, 0)
+	vars, _ = appendName(vars, t.Algorithm)
+	vars = append(vars, byte(t.TimeSigned>>40), byte(t.TimeSigned>>32))
+	vars = binary.BigEndian.AppendUint32(vars, uint32(t.TimeSigned))
+	vars = binary.BigEndian.AppendUint16(vars, t.Fudge)
+	vars = binary.BigEndian.AppendUint16(vars, t.Error)
+	vars = binary.BigEndian.AppendUint16(vars, uint16(len(t.Other)))
+	vars = append(vars, t.Other...)
+	h.Write(vars)
+	return h.Sum(nil)
+}
+
+// sign packs the message with a TSIG record appended, returning it and the MAC
+func (k *tsigKey) sign(m *dnsMessage, requestMAC []byte, now time.Time) ([]byte, []byte, error) {
+	unsigned, err := m.pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	t := tsigRData{Algorithm: k.Algorithm, TimeSigned: uint64(now.Unix()), Fudge: tsigFudge, OriginalID: m.ID}
+	t.MAC = k.mac(requestMAC, unsigned, t)
+
+	signed := *m
+	signed.Additional = append(append([]dnsRR{}, m.Additional...), dnsRR{Name: k.Name, Type: dnsTypeTSIG, Class: dnsClassANY, Data: t.pack()})
+	packed, err := signed.pack()
+	return packed, t.MAC, err
+}
+
+// verify checks the TSIG record of a parsed message, returning its MAC
+func (k *tsigKey) verify(raw []byte, m *dnsMessage, requestMAC []byte, now time.Time) ([]byte, error) {
+	if m.tsigOffset == 0 || len(m.Additional) == 0 || m.Additional[len(m.Additional)-1].Type != dnsTypeTSIG {
+		return nil, errors.New("message is not signed")
+	}
+	rr := m.Additional[len(m.Additional)-1]
+	t, err := unpackTSIG(rr.Data)
+	if err != nil {
+		return nil, err
+	}
+	if rr.Name != k.Name || t.Algorithm != k.Algorithm {
+		return nil, fmt.Errorf("signed with unknown key %s (%s)", rr.Name, t.Algorithm)
+	}
+	if t.Error != 0 {
+		return nil, fmt.Errorf("TSIG error %s", rcodeName(int(t.Error)))
+	}
+
+	// The MAC covers the message as it was before the TSIG record was added
+	unsigned := append([]byte{}, raw[:m.tsigOffset]...)
+	binary.BigEndian.PutUint16(unsigned[0:], t.OriginalID)
+	binary.BigEndian.PutUint16(unsigned[10:], uint16(len(m.Additional)-1))
+	if !hmac.Equal(t.MAC, k.mac(requestMAC, unsigned, t)) {
+		return nil, errors.New("TSIG signature does not match (check the key secret)")
+	}
+	if skew := now.Unix() - int64(t.TimeSigned); skew > int64(t.Fudge) || -skew > int64(t.Fudge) {
+		return nil, fmt.Errorf("TSIG time is %ds off (check the clocks)", skew)
+	}
+	return t.MAC, nil
+}
+
+// rfc2136Conn is a TCP connection to the server exchanging length-prefixed messages
+type rfc2136Conn struct {
+	net.Conn
+}
+
+func (p *RFC2136Provider) dial() (*rfc2136Conn, error) {
+	p.limiter.wait()
+	conn, err := net.DialTimeout("tcp", p.Server, p.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	return &rfc2136Conn{conn}, nil
+}
+
+func (c *rfc2136Conn) write(msg []byte) error {
+	if _, err := c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)); err != nil {
+		return fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	return nil
+}
+
+func (c *rfc2136Conn) read() ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(c, length[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(c, msg); err != nil {
+		return nil, fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	return msg, nil
+}
+
+// newMessageID returns a random message ID
+func newMessageID() uint16 {
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// send signs (if a key is set) and writes a request, returning the request MAC
+func (p *RFC2136Provider) send(conn *rfc2136Conn, m *dnsMessage) ([]byte, error) {
+	var packed, mac []byte
+	var err error
+	if p.Key != nil {
+		packed, mac, err = p.Key.sign(m, nil, time.Now())
+	} else {
+		packed, err = m.pack()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mac, conn.write(packed)
+}
+
+// receive reads the response to a request, checking its ID and (if signed) its TSIG
+func (p *RFC2136Provider) receive(conn *rfc2136Conn, request *dnsMessage, requestMAC []byte) (*dnsMessage, error) {
+	raw, err := conn.read()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := unpackDNSMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ID != request.ID {
+		return nil, fmt.Errorf("response ID %d does not match request ID %d", resp.ID, request.ID)
+	}
+	if p.Key != nil {
+		if _, err := p.Key.verify(raw, resp, requestMAC, time.Now()); err != nil {
+			return nil, fmt.Errorf("%s response: %w", rcodeName(resp.rcode()), err)
+		}
+	}
+	return resp, nil
+}
+
+// exchange sends one request and returns its response
+func (p *RFC2136Provider) exchange(m *dnsMessage) (*dnsMessage, error) {
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	mac, err := p.send(conn, m)
+	if err != nil {
+		return nil, err
+	}
+	return p.receive(conn, m, mac)
+}
+
+// inZone checks that a name belongs to the zone, returning it in canonical form
+func (p *RFC2136Provider) inZone(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name != p.Zone && !strings.HasSuffix(name, "."+p.Zone) {
+		return "", fmt.Errorf("%s is not in zone %s", name, p.Zone)
+	}
+	return name, nil
+}
+
+// recordSetBackend implementation
+
+func (p *RFC2136Provider) fetchValues(name, recordType string) ([]string, error) {
+	name, err := p.inZone(name)
+	if err != nil {
+		return nil, err
+	}
+	rrType, ok := dnsTypes[recordType]
+	if !ok {
+		return nil, fmt.Errorf("record type %s is not supported", recordType)
+	}
+
+	query := &dnsMessage{ID: newMessageID(), Flags: dnsOpcodeQuery << 11,
+		Question: []dnsRR{{Name: name, Type: rrType, Class: dnsClassIN}}}
+	resp, err := p.exchange(query)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.rcode() {
+	case 0:
+	case dnsRcodeNXDomain:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("query failed: %s", rcodeName(resp.rcode()))
+	}
+
+	var values []string
+	for _, rr := range resp.Answer {
+		if rr.Name == name && rr.Type == rrType {
+			if content, ok := rrContent(rr); ok {
+				values = append(values, content)
+			}
+		}
+	}
+	return values, nil
+}
+
+// storeValues replaces the record set in one update: the deletion of the whole set and
+// the additions are applied atomically by the server
+func (p *RFC2136Provider) storeValues(name, recordType string, ttl int, values []string) error {
+	name, err := p.inZone(name)
+	if err != nil {
+		return err
+	}
+	rrType, ok := dnsTypes[recordType]
+	if !ok {
+		return fmt.Errorf("record type %s is not supported", recordType)
+	}
+
+	update := &dnsMessage{ID: newMessageID(), Flags: dnsOpcodeUpdate << 11,
+		Question:  []dnsRR{{Name: p.Zone, Type: dnsTypeSOA, Class: dnsClassIN}},
+		Authority: []dnsRR{{Name: name, Type: rrType, Class: dnsClassANY}}}
+	for _, v := range values {
+		data, err := rrData(recordType, v)
+		if err != nil {
+			return err
+		}
+		update.Authority = append(update.Authority, dnsRR{Name: name, Type: rrType, Class: dnsClassIN, TTL: uint32(ttl), Data: data})
+	}
+
+	resp, err := p.exchange(update)
+	if err != nil {
+		return err
+	}
+	if resp.rcode() != 0 {
+		return fmt.Errorf("update refused: %s", rcodeName(resp.rcode()))
+	}
+	return nil
+}
+
+// listRecords transfers the zone (AXFR) and returns the records of the given type. The
+// server must allow transfers to the key (or address) used for updates. Only the first
+// message of the transfer is checked against the TSIG key.
+func (p *RFC2136Provider) listRecords(recordType string) ([]CFRecord, error) {
+	records := []CFRecord{}
+	rrType, ok := dnsTypes[recordType]
+	if !ok {
+		return records, fmt.Errorf("record type %s is not supported", recordType)
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return records, err
+	}
+	defer conn.Close()
+
+	query := &dnsMessage{ID: newMessageID(), Flags: dnsOpcodeQuery << 11,
+		Question: []dnsRR{{Name: p.Zone, Type: dnsTypeAXFR, Class: dnsClassIN}}}
+	mac, err := p.send(conn, query)
+	if err != nil {
+		return records, err
+	}
+
+	// The transfer starts and ends with the zone's SOA record
+	soaCount := 0
+	for first := true; soaCount < 2; first = false {
+		var resp *dnsMessage
+		if first {
+			resp, err = p.receive(conn, query, mac)
+		} else {
+			var raw []byte
+			if raw, err = conn.read(); err == nil {
+				resp, err = unpackDNSMessage(raw)
+			}
+		}
+		if err != nil {
+			return records, err
+		}
+		if resp.rcode() != 0 {
+			return records, fmt.Errorf("zone transfer refused: %s", rcodeName(resp.rcode()))
+		}
+		if len(resp.Answer) == 0 {
+			return records, errors.New("zone transfer ended early")
+		}
+
+		for _, rr := range resp.Answer {
+			switch rr.Type {
+			case dnsTypeSOA:
+				soaCount++
+			case rrType:
+				if content, ok := rrContent(rr); ok {
+					records = append(records, CFRecord{ID: content, Type: recordType, Name: rr.Name, Content: content})
+				}
+			}
+		}
+	}
+	return records, nil
+}
+
+func (p *RFC2136Provider) probe() bool {
+	query := &dnsMessage{ID: newMessageID(), Flags: dnsOpcodeQuery << 11,
+		Question: []dnsRR{{Name: p.Zone, Type: dnsTypeSOA, Class: dnsClassIN}}}
+	_, err := p.exchange(query)
+	return !errors.Is(err, errProviderUnreachable)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRFC2136 is an in-memory authoritative server accepting queries, TSIG-signed
+// updates and zone transfers over TCP
+type fakeRFC2136 struct {
+	mu      sync.Mutex
+	zone    string
+	key     *tsigKey
+	records []dnsRR
+}
+
+func newFakeRFC2136(t *testing.T) (*fakeRFC2136, *RFC2136Provider) {
+	t.Helper()
+	key, err := newTSIGKey("ddns-key.", "hmac-sha256", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeRFC2136{zone: "example.com", key: key}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fake.serve(&rfc2136Conn{conn})
+		}
+	}()
+
+	p := &RFC2136Provider{Server: listener.Addr().String(), Zone: "example.com", Key: key, timeout: 5 * time.Second}
+	p.recordSetClient = recordSetClient{backend: p, TTL: 120, HeartbeatTTL: 3600}
+	return fake, p
+}
+
+// add inserts a record directly
+func (f *fakeRFC2136) add(name, recordType, content string) {
+	data, err := rrData(recordType, content)
+	if err != nil {
+		panic(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records = append(f.records, dnsRR{Name: name, Type: dnsTypes[recordType], Class: dnsClassIN, TTL: 60, Data: data})
+}
+
+// values returns the contents of the records matching name and type
+func (f *fakeRFC2136) values(name, recordType string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []string
+	for _, rr := range f.records {
+		if rr.Name == name && rr.Type == dnsTypes[recordType] {
+			content, _ := rrContent(rr)
+			result = append(result, content)
+		}
+	}
+	return result
+}
+
+func (f *fakeRFC2136) soa() dnsRR {
+	data, _ := appendName(nil, "ns1.example.com")
+	data, _ = appendName(data, "hostmaster.example.com")
+	return dnsRR{Name: f.zone, Type: dnsTypeSOA, Class: dnsClassIN, TTL: 60, Data: append(data, make([]byte, 20)...)}
+}
+
+func (f *fakeRFC2136) serve(conn *rfc2136Conn) {
+	defer conn.Close()
+	raw, err := conn.read()
+	if err != nil {
+		return
+	}
+	req, err := unpackDNSMessage(raw)
+	if err != nil {
+		return
+	}
+	resp := &dnsMessage{ID: req.ID, Flags: 1<<15 | req.Flags&0x7800, Question: req.Question}
+	mac, err := f.key.verify(raw, req, nil, time.Now())
+	if err != nil {
+		resp.Flags |= 9 // NOTAUTH, unsigned
+		packed, _ := resp.pack()
+		conn.write(packed)
+		return
+	}
+	reply := func(m *dnsMessage) {
+		packed, _, _ := f.key.sign(m, mac, time.Now())
+		conn.write(packed)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := req.Question[0]
+	switch {
+	case req.Flags>>11&0xf == dnsOpcodeUpdate:
+		for _, rr := range req.Authority {
+			if rr.Class == dnsClassANY {
+				kept := f.records[:0]
+				for _, existing := range f.records {
+					if existing.Name != rr.Name || existing.Type != rr.Type {
+						kept = append(kept, existing)
+					}
+				}
+				f.records = kept
+			} else {
+				f.records = append(f.records, rr)
+			}
+		}
+		reply(resp)
+	case q.Type == dnsTypeAXFR:
+		// Split the zone over two messages; only the first is signed
+		resp.Answer = []dnsRR{f.soa()}
+		half := len(f.records) / 2
+		resp.Answer = append(resp.Answer, f.records[:half]...)
+		reply(resp)
+		rest := &dnsMessage{ID: req.ID, Flags: resp.Flags, Answer: append(append([]dnsRR{}, f.records[half:]...), f.soa())}
+		packed, _ := rest.pack()
+		conn.write(packed)
+	case q.Type == dnsTypeSOA:
+		resp.Answer = []dnsRR{f.soa()}
+		reply(resp)
+	default:
+		found := false
+		for _, rr := range f.records {
+			if rr.Name == q.Name {
+				found = true
+				if rr.Type == q.Type {
+					resp.Answer = append(resp.Answer, rr)
+				}
+			}
+		}
+		if !found {
+			resp.Flags |= dnsRcodeNXDomain
+		}
+		reply(resp)
+	}
+}
+
+func TestRFC2136RecordOperations(t *testing.T) {
+	fake, p := newFakeRFC2136(t)
+
+	if r, err := p.getRecord("home.example.com", "A"); r != nil || err != nil {
+		t.Fatalf("getRecord of an unknown name = %v, %v", r, err)
+	}
+	if p.createRecord("home.example.com", "A", "192.0.2.1", false) != nil {
+		t.Fatal("createRecord failed")
+	}
+	if p.ensureRecordExists("home.example.com", "A", "192.0.2.2", false) != nil {
+		t.Fatal("ensureRecordExists failed")
+	}
+	if got := fake.values("home.example.com", "A"); !reflect.DeepEqual(got, []string{"192.0.2.1", "192.0.2.2"}) {
+		t.Errorf("A values = %v", got)
+	}
+
+	if p.updateRecord("192.0.2.1", "home.example.com", "A", "192.0.2.3", false) != nil {
+		t.Fatal("updateRecord failed")
+	}
+	if got := fake.values("home.example.com", "A"); !reflect.DeepEqual(got, []string{"192.0.2.2", "192.0.2.3"}) {
+		t.Errorf("A values after update = %v", got)
+	}
+
+	if p.deleteRecord("192.0.2.2", "home.example.com", "A") != nil || p.deleteRecord("192.0.2.3", "home.example.com", "A") != nil {
+		t.Fatal("deleteRecord failed")
+	}
+	if got := fake.values("home.example.com", "A"); len(got) != 0 {
+		t.Errorf("A values after delete = %v", got)
+	}
+}
+
+func TestRFC2136RecordTypes(t *testing.T) {
+	fake, p := newFakeRFC2136(t)
+
+	if p.upsertRecord("home.example.com", "AAAA", "2001:db8::1", false) != nil {
+		t.Fatal("AAAA upsert failed")
+	}
+	if p.upsertRecord("www.example.com", "CNAME", "home.example.com", false) != nil {
+		t.Fatal("CNAME upsert failed")
+	}
+	if p.upsertHeartbeat("home.example.com", "1700000000") != nil {
+		t.Fatal("heartbeat upsert failed")
+	}
+
+	if got, _ := p.getRecord("home.example.com", "AAAA"); got == nil || got.Content != "2001:db8::1" {
+		t.Errorf("AAAA record = %+v", got)
+	}
+	if got, _ := p.getRecord("www.example.com", "CNAME"); got == nil || got.Content != "home.example.com" {
+		t.Errorf("CNAME record = %+v", got)
+	}
+	if got := fake.values("home.example.com", "TXT"); !reflect.DeepEqual(got, []string{"1700000000"}) {
+		t.Errorf("TXT values = %v", got)
+	}
+	for _, rr := range fake.records {
+		if rr.Type == dnsTypeTXT && rr.TTL != 3600 {
+			t.Errorf("heartbeat TTL = %d, want 3600", rr.TTL)
+		}
+	}
+}
+
+func TestRFC2136ListRecordsByZoneTransfer(t *testing.T) {
+	fake, p := newFakeRFC2136(t)
+	fake.add("a.example.com", "TXT", "1")
+	fake.add("a.example.com", "A", "192.0.2.1")
+	fake.add("b.example.com", "TXT", "2")
+	fake.add("c.example.com", "TXT", "3")
+
+	records, _ := p.getAllRecordsByType("TXT")
+	var names []string
+	for _, r := range records {
+		names = append(names, r.Name+"="+r.Content)
+	}
+	if !reflect.DeepEqual(names, []string{"a.example.com=1", "b.example.com=2", "c.example.com=3"}) {
+		t.Errorf("TXT records = %v", names)
+	}
+}
+
+func TestRFC2136Errors(t *testing.T) {
+	_, p := newFakeRFC2136(t)
+
+	// A name outside the zone is rejected before anything is sent
+	if _, err := p.fetchValues("home.example.org", "A"); err == nil {
+		t.Error("expected an error for a name outside the zone")
+	}
+
+	// A wrong secret is refused by the server
+	wrong, _ := newTSIGKey("ddns-key", "hmac-sha256", base64.StdEncoding.EncodeToString([]byte("wrong")))
+	bad := *p
+	bad.Key = wrong
+	if err := bad.storeValues("home.example.com", "A", 60, []string{"192.0.2.1"}); err == nil {
+		t.Error("expected an error for a bad TSIG key")
+	} else if errors.Is(err, errProviderUnreachable) {
+		t.Errorf("bad key reported as unreachable: %v", err)
+	}
+
+	// A closed server is unreachable and queued by the hooks
+	unreachable := *p
+	unreachable.Server = "127.0.0.1:1"
+	unreachable.backend = &unreachable
+	var queued string
+	unreachable.OnUnreachable = func(action, recordType, name, content, recordID string) {
+		queued = action + " " + name
+	}
+	if unreachable.createRecord("home.example.com", "A", "192.0.2.1", false) == nil {
+		t.Error("expected createRecord to fail")
+	}
+	if queued != "create home.example.com" {
+		t.Errorf("OnUnreachable got %q", queued)
+	}
+	if unreachable.probeConnectivity() {
+		t.Error("probe succeeded against a closed port")
+	}
+	if !p.probeConnectivity() {
+		t.Error("probe failed against the fake server")
+	}
+}
+
+func TestTSIGRoundTrip(t *testing.T) {
+	key, err := newTSIGKey("key", "HMAC-SHA512.", base64.StdEncoding.EncodeToString([]byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &dnsMessage{ID: 42, Question: []dnsRR{{Name: "example.com", Type: dnsTypeSOA, Class: dnsClassIN}}}
+	now := time.Unix(1700000000, 0)
+	raw, mac, err := key.sign(m, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := unpackDNSMessage(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := key.verify(raw, parsed, nil, now.Add(time.Minute)); err != nil || !reflect.DeepEqual(got, mac) {
+		t.Errorf("verify = %x, %v", got, err)
+	}
+	if _, err := key.verify(raw, parsed, nil, now.Add(time.Hour)); err == nil {
+		t.Error("expected a time error outside the fudge")
+	}
+
+	raw[len(raw)-30] ^= 1 // Corrupt the MAC
+	parsed, _ = unpackDNSMessage(raw)
+	if _, err := key.verify(raw, parsed, nil, now); err == nil {
+		t.Error("expected a signature error")
+	}
+
+	if _, err := newTSIGKey("key", "hmac-md5", "c2VjcmV0"); err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+	if _, err := newTSIGKey("key", "hmac-sha256", "not base64!"); err == nil {
+		t.Error("expected an error for a bad secret")
+	}
+}
+
+func TestRRDataRoundTrip(t *testing.T) {
+	long := string(make([]byte, 300))
+	for _, tc := range []struct{ recordType, content, want string }{
+		{"A", "192.0.2.1", "192.0.2.1"},
+		{"AAAA", "2001:db8::1", "2001:db8::1"},
+		{"CNAME", "Home.Example.com", "home.example.com"},
+		{"TXT", "v=1", "v=1"},
+		{"TXT", `"a" "b"`, `"a" "b"`},
+		{"TXT", long, formatTXTStrings([]string{long[:255], long[255:]})},
+	} {
+		data, err := rrData(tc.recordType, tc.content)
+		if err != nil {
+			t.Errorf("rrData(%s, %q): %v", tc.recordType, tc.content, err)
+			continue
+		}
+		got, ok := rrContent(dnsRR{Type: dnsTypes[tc.recordType], Data: data})
+		if !ok || got != tc.want {
+			t.Errorf("%s %q round trip = %q", tc.recordType, tc.content, got)
+		}
+	}
+	if _, err := rrData("A", "2001:db8::1"); err == nil {
+		t.Error("expected an error for an IPv6 address in an A record")
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const route53APIVersion = "2013-04-01"
+
+// Route53Provider implements DNSProvider (and the internal record operations) for AWS Route53
+// as a recordSetBackend
+type Route53Provider struct {
+	HostedZoneID string
+	Endpoint     string // API endpoint, e.g. https://route53.amazonaws.com
+	Region       string // Signing region (Route53 is global and signs with us-east-1)
+
+	credentials *awsCredentialChain
+	client      *http.Client
+
+	recordSetClient
+}
+
+// Verify Route53Provider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*Route53Provider)(nil)
+var _ DNSProvider = (*Route53Provider)(nil)
+var _ providerClient = (*Route53Provider)(nil)
+
+// Route53 API structures
+type r53ResourceRecord struct {
+	Value string `xml:"Value"`
+}
+
+type r53RecordSet struct {
+	Name            string              `xml:"Name"`
+	Type            string              `xml:"Type"`
+	SetIdentifier   string              `xml:"SetIdentifier,omitempty"`
+	TTL             int                 `xml:"TTL,omitempty"`
+	ResourceRecords []r53ResourceRecord `xml:"ResourceRecords>ResourceRecord,omitempty"`
+	AliasTarget     *struct {
+		DNSName string `xml:"DNSName"`
+	} `xml:"AliasTarget,omitempty"`
+}
+
+type r53ListResponse struct {
+	RecordSets     []r53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	NextRecordName string         `xml:"NextRecordName"`
+	NextRecordType string         `xml:"NextRecordType"`
+}
+
+type r53Change struct {
+	Action    string       `xml:"Action"`
+	RecordSet r53RecordSet `xml:"ResourceRecordSet"`
+}
+
+type r53ChangeRequest struct {
+	XMLName xml.Name    `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Comment string      `xml:"ChangeBatch>Comment,omitempty"`
+	Changes []r53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type r53ErrorResponse struct {
+	Errors []struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+// newRoute53Provider creates a Route53 provider and resolves AWS credentials up front so
+// that missing credentials are reported at startup rather than on the first change
+func newRoute53Provider(config *Config) (*Route53Provider, error) {
+	r := &Route53Provider{
+		HostedZoneID: strings.TrimPrefix(config.Route53HostedZoneID, "/hostedzone/"),
+		Endpoint:     "https://route53.amazonaws.com",
+		Region:       "us-east-1",
+		credentials:  newAWSCredentialChain(),
+		client:       newProviderHTTPClient(config, providerRoute53),
+	}
+	r.recordSetClient = newRecordSetClient(r, config)
+
+	creds, err := r.credentials.get()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Using Route53 hosted zone %s with AWS credentials from %s", r.HostedZoneID, creds.Source)
+
+	warnUnsupportedOptions(config, "Route53")
+	return r, nil
+}
+
+// fqdn returns name as Route53 stores it: lower case with a trailing dot
+func fqdn(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
+
+// r53Name converts a Route53 name back to the form used in configuration
+func r53Name(name string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(name, `\052`, "*"), ".")
+}
+
+// r53Value converts record content to a Route53 value (TXT values must be quoted)
+func r53Value(recordType, content string) string {
+	if recordType == "TXT" && !strings.HasPrefix(content, "\"") {
+		return formatTXTStrings([]string{content})
+	}
+	return content
+}
+
+// r53Content converts a Route53 value back to record content (single TXT strings are unquoted)
+func r53Content(recordType, value string) string {
+	switch recordType {
+	case "CNAME":
+		return strings.TrimSuffix(value, ".")
+	case "TXT":
+		if strs := parseTXTStrings(value); len(strs) == 1 {
+			return strs[0]
+		}
+	}
+	return value
+}
+
+// request performs a signed Route53 API request
+func (r *Route53Provider) request(method, path string, query url.Values, body []byte) (*http.Response, error) {
+	u := r.Endpoint + "/" + route53APIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+
+	creds, err := r.credentials.get()
+	if err != nil {
+		return nil, err
+	}
+	signAWSRequestV4(req, body, creds, r.Region, "route53", time.Now())
+
+	log.Printf("API Request: %s %s", method, path)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	return resp, nil
+}
+
+// apiError describes a non-200 Route53 response
+func r53APIError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var errResp r53ErrorResponse
+	if xml.Unmarshal(data, &errResp) == nil && len(errResp.Errors) > 0 {
+		var msgs []string
+		throttled := false
+		for _, e := range errResp.Errors {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", e.Code, e.Message))
+			throttled = throttled || e.Code == "Throttling" || e.Code == "PriorRequestNotComplete"
+		}
+		if throttled {
+			return fmt.Errorf("%w: %s", errProviderRateLimited, strings.Join(msgs, ", "))
+		}
+		return statusError(resp.StatusCode, strings.Join(msgs, ", "))
+	}
+	return statusError(resp.StatusCode, "")
+}
+
+// listRecordSets lists record sets starting at name/type (both optional)
+func (r *Route53Provider) listRecordSets(name, recordType string, maxItems int) (*r53ListResponse, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+	if recordType != "" {
+		query.Set("type", recordType)
+	}
+	if maxItems > 0 {
+		query.Set("maxitems", fmt.Sprint(maxItems))
+	}
+
+	resp, err := r.request("GET", "/hostedzone/"+r.HostedZoneID+"/rrset", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, r53APIError(resp)
+	}
+
+	var result r53ListResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return &result, nil
+}
+
+// getRecordSet returns the record set for name/type, or nil if there is none.
+// The error is non-nil only if the API could not be queried.
+func (r *Route53Provider) getRecordSet(name, recordType string) (*r53RecordSet, error) {
+	result, err := r.listRecordSets(fqdn(name), recordType, 1)
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range result.RecordSets {
+		if fqdn(r53Name(set.Name)) == fqdn(name) && set.Type == recordType && set.AliasTarget == nil {
+			return &set, nil
+		}
+	}
+	return nil, nil
+}
+
+// recordsFromSet expands a record set into one record per value
+func recordsFromSet(set *r53RecordSet) []CFRecord {
+	if set == nil {
+		return []CFRecord{}
+	}
+	values := make([]string, len(set.ResourceRecords))
+	for i, rr := range set.ResourceRecords {
+		values[i] = r53Content(set.Type, rr.Value)
+	}
+	return valueRecords(r53Name(set.Name), set.Type, values)
+}
+
+// changeRecordSet submits a single UPSERT or DELETE of the given Route53 values
+func (r *Route53Provider) changeRecordSet(action, name, recordType string, ttl int, values []string) error {
+	set := r53RecordSet{Name: fqdn(name), Type: recordType, TTL: ttl}
+	for _, v := range values {
+		set.ResourceRecords = append(set.ResourceRecords, r53ResourceRecord{Value: v})
+	}
+	body, err := xml.Marshal(r53ChangeRequest{
+		Comment: "dynipupdate",
+		Changes: []r53Change{{Action: action, RecordSet: set}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.request("POST", "/hostedzone/"+r.HostedZoneID+"/rrset", nil, append([]byte(xml.Header), body...))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return r53APIError(resp)
+	}
+	return nil
+}
+
+// recordSetBackend implementation
+
+func (r *Route53Provider) fetchValues(name, recordType string) ([]string, error) {
+	set, err := r.getRecordSet(name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, record := range recordsFromSet(set) {
+		values = append(values, record.Content)
+	}
+	return values, nil
+}
+
+func (r *Route53Provider) storeValues(name, recordType string, ttl int, values []string) error {
+	if len(values) > 0 {
+		r53Values := make([]string, len(values))
+		for i, v := range values {
+			r53Values[i] = r53Value(recordType, v)
+		}
+		return r.changeRecordSet("UPSERT", name, recordType, ttl, r53Values)
+	}
+
+	// DELETE must match the current record set exactly, including its TTL
+	current, err := r.getRecordSet(name, recordType)
+	if err != nil || current == nil {
+		return err
+	}
+	var old []string
+	for _, rr := range current.ResourceRecords {
+		old = append(old, rr.Value)
+	}
+	return r.changeRecordSet("DELETE", name, recordType, current.TTL, old)
+}
+
+func (r *Route53Provider) listRecords(recordType string) ([]CFRecord, error) {
+	records := []CFRecord{}
+	name, nextType := "", ""
+	for {
+		result, err := r.listRecordSets(name, nextType, 300)
+		if err != nil {
+			return records, err
+		}
+		for i := range result.RecordSets {
+			if set := &result.RecordSets[i]; set.Type == recordType && set.AliasTarget == nil {
+				records = append(records, recordsFromSet(set)...)
+			}
+		}
+		if !result.IsTruncated {
+			return records, nil
+		}
+		name, nextType = result.NextRecordName, result.NextRecordType
+	}
+}
+
+func (r *Route53Provider) probe() bool {
+	resp, err := r.request("GET", "/hostedzone/"+r.HostedZoneID, nil, nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRoute53 is an in-memory Route53 record set API used by tests
+type fakeRoute53 struct {
+	mu      sync.Mutex
+	sets    map[string]r53RecordSet // name + "/" + type -> record set
+	changes int
+	server  *httptest.Server
+}
+
+func newFakeRoute53(t *testing.T) (*fakeRoute53, *Route53Provider) {
+	t.Helper()
+	fake := &fakeRoute53{sets: make(map[string]r53RecordSet)}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(fake.server.Close)
+
+	chain := &awsCredentialChain{sources: []awsCredentialSource{
+		func(*http.Client) (*awsCredentials, error) {
+			return &awsCredentials{AccessKeyID: "AKIDTEST", SecretAccessKey: "secret", Source: "test"}, nil
+		},
+	}}
+	r := &Route53Provider{
+		HostedZoneID: "Z123",
+		Endpoint:     fake.server.URL,
+		Region:       "us-east-1",
+		credentials:  chain,
+		client:       fake.server.Client(),
+	}
+	r.recordSetClient = recordSetClient{backend: r, TTL: 120, HeartbeatTTL: 3600}
+	return fake, r
+}
+
+// values returns the values of a record set
+func (f *fakeRoute53) values(name, recordType string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var values []string
+	for _, rr := range f.sets[fqdn(name)+"/"+recordType].ResourceRecords {
+		values = append(values, rr.Value)
+	}
+	return values
+}
+
+func (f *fakeRoute53) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/2013-04-01/hostedzone/Z123":
+		w.Write([]byte("<GetHostedZoneResponse/>"))
+
+	case r.Method == "GET" && r.URL.Path == "/2013-04-01/hostedzone/Z123/rrset":
+		keys := make([]string, 0, len(f.sets))
+		for k := range f.sets {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		// Listing starts at name/type; a page size of 2 exercises pagination
+		start := r.URL.Query().Get("name") + "/" + r.URL.Query().Get("type")
+		var resp r53ListResponse
+		for _, k := range keys {
+			if k < start {
+				continue
+			}
+			if len(resp.RecordSets) == 2 {
+				resp.IsTruncated = true
+				resp.NextRecordName, resp.NextRecordType = f.sets[k].Name, f.sets[k].Type
+				break
+			}
+			resp.RecordSets = append(resp.RecordSets, f.sets[k])
+		}
+		out, _ := xml.Marshal(struct {
+			XMLName xml.Name `xml:"ListResourceRecordSetsResponse"`
+			r53ListResponse
+		}{r53ListResponse: resp})
+		w.Write(out)
+
+	case r.Method == "POST" && r.URL.Path == "/2013-04-01/hostedzone/Z123/rrset":
+		var req r53ChangeRequest
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, change := range req.Changes {
+			key := change.RecordSet.Name + "/" + change.RecordSet.Type
+			switch change.Action {
+			case "UPSERT":
+				f.sets[key] = change.RecordSet
+			case "DELETE":
+				if !reflect.DeepEqual(f.sets[key], change.RecordSet) {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("<ErrorResponse><Error><Code>InvalidChangeBatch</Code><Message>record set not found</Message></Error></ErrorResponse>"))
+					return
+				}
+				delete(f.sets, key)
+			}
+		}
+		f.changes++
+		w.Write([]byte("<ChangeResourceRecordSetsResponse/>"))
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRoute53RecordOperations(t *testing.T) {
+	fake, r := newFakeRoute53(t)
+	var changes []string
+	r.OnChange = func(action, recordType, name, content string) {
+		changes = append(changes, action+" "+recordType+" "+name)
+	}
+
+	if r.upsertRecord("home.example.com", "A", "203.0.113.1", false) != nil {
+		t.Fatal("upsert (create) failed")
+	}
+	if r.ensureRecordExists("home.example.com", "A", "203.0.113.2", false) != nil {
+		t.Fatal("ensureRecordExists failed")
+	}
+	if got := fake.values("home.example.com", "A"); !reflect.DeepEqual(got, []string{"203.0.113.1", "203.0.113.2"}) {
+		t.Errorf("values = %v", got)
+	}
+
+	// Unchanged content makes no API change
+	before := fake.changes
+	r.upsertRecord("home.example.com", "A", "203.0.113.1", false)
+	if fake.changes != before {
+		t.Error("unchanged upsert submitted a change")
+	}
+
+	// Update replaces only the value identified by the record ID
+	if r.updateRecord("203.0.113.1", "home.example.com", "A", "203.0.113.9", false) != nil {
+		t.Fatal("update failed")
+	}
+	if got := fake.values("home.example.com", "A"); !reflect.DeepEqual(got, []string{"203.0.113.2", "203.0.113.9"}) {
+		t.Errorf("values after update = %v", got)
+	}
+
+	// Deleting values shrinks the set and finally removes it
+	r.deleteRecord("203.0.113.2", "home.example.com", "A")
+	r.deleteRecordIfExists("home.example.com", "A")
+	if got := fake.values("home.example.com", "A"); got != nil {
+		t.Errorf("values after delete = %v", got)
+	}
+
+	want := []string{"create A home.example.com", "create A home.example.com", "update A home.example.com", "delete A home.example.com", "delete A home.example.com"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+}
+
+func TestRoute53TXTAndCNAMEValues(t *testing.T) {
+	fake, r := newFakeRoute53(t)
+
+	r.createRecord("_changelog.example.com", "TXT", "v=1 entry", false)
+	if got := fake.values("_changelog.example.com", "TXT"); !reflect.DeepEqual(got, []string{`"v=1 entry"`}) {
+		t.Errorf("TXT values = %v", got)
+	}
+	if record, _ := r.getRecord("_changelog.example.com", "TXT"); record == nil || record.Content != "v=1 entry" {
+		t.Errorf("TXT record = %+v", record)
+	}
+	if r.ensureRecordExists("_changelog.example.com", "TXT", "v=1 entry", false) != nil || len(fake.values("_changelog.example.com", "TXT")) != 1 {
+		t.Error("existing TXT value not recognised")
+	}
+
+	r.createRecord("example.com", "CNAME", "all.example.com", false)
+	record, _ := r.getRecord("example.com", "CNAME")
+	if record == nil || record.Content != "all.example.com" || record.Name != "example.com" {
+		t.Errorf("CNAME record = %+v", record)
+	}
+}
+
+func TestRoute53GetAllRecordsByTypePaginates(t *testing.T) {
+	_, r := newFakeRoute53(t)
+	for _, name := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		r.createRecord(name, "A", "192.0.2.1", false)
+		r.createRecord(name, "AAAA", "2001:db8::1", false)
+	}
+
+	records, _ := r.getAllRecordsByType("A")
+	var names []string
+	for _, record := range records {
+		names = append(names, record.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"a.example.com", "b.example.com", "c.example.com"}) {
+		t.Errorf("names = %v", names)
+	}
+}
+
+func TestRoute53Errors(t *testing.T) {
+	fake, r := newFakeRoute53(t)
+
+	// Deleting a set that changed underneath us surfaces the API error but stays reachable
+	fake.sets["x.example.com./A"] = r53RecordSet{Name: "x.example.com.", Type: "A", TTL: 300, ResourceRecords: []r53ResourceRecord{{Value: "192.0.2.1"}}}
+	err := r.changeRecordSet("DELETE", "x.example.com", "A", 60, []string{"192.0.2.1"})
+	if err == nil || errors.Is(err, errProviderUnreachable) || !strings.Contains(err.Error(), "InvalidChangeBatch") {
+		t.Errorf("mismatched DELETE = %v", err)
+	}
+
+	// Network failures are reported as unreachable
+	var unreachable []string
+	r.OnUnreachable = func(action, recordType, name, content, recordID string) {
+		unreachable = append(unreachable, action)
+	}
+	fake.server.Close()
+	if r.createRecord("y.example.com", "A", "192.0.2.2", false) == nil {
+		t.Error("create succeeded with the API down")
+	}
+	if !reflect.DeepEqual(unreachable, []string{"create"}) {
+		t.Errorf("unreachable = %v", unreachable)
+	}
+	if r.probeConnectivity() {
+		t.Error("probeConnectivity reported the API as reachable")
+	}
+}
+
+func TestRoute53ReconcileRecords(t *testing.T) {
+	fake, r := newFakeRoute53(t)
+	config := &Config{ExternalDomain: "home.example.com", IPv6Domain: "v6.example.com"}
+	ips := &IPAddresses{ExternalIPv4: "203.0.113.5", ExternalIPv6: "2001:db8::5"}
+
+	success, total := reconcileRecords(r, config, ips, time.Now())
+	if success != total || total == 0 {
+		t.Errorf("reconcile = %d/%d", success, total)
+	}
+	if got := fake.values("home.example.com", "A"); !reflect.DeepEqual(got, []string{"203.0.113.5"}) {
+		t.Errorf("A values = %v", got)
+	}
+	if got := fake.values("v6.example.com", "AAAA"); !reflect.DeepEqual(got, []string{"2001:db8::5"}) {
+		t.Errorf("AAAA values = %v", got)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Interrupted runs. A run killed between creating new records and deleting the ones they
+// replace (power cut, OOM killer, cycle timeout) leaves names holding old and new addresses
+// side by side. With STATE_FILE set, every run keeps a journal in the state file: it is
+// opened before the first change, each applied change is added to it, and it is closed when
+// the run finishes. A journal still open at the start of a run belongs to a run that did not
+// finish: before the records are reconciled, the names it changed are brought back to what
+// the configuration wants now, and the repairs are logged. Names this run does not manage
+// (filtered with -only/-skip, or without addresses this time) are kept in the state file as
+// pending repairs for a later run. With record tags enabled, records without the managed-by
+// tag are never deleted by a repair.
+
+// RunJournal is the journal of a run in the state file
+type RunJournal struct {
+	Started int64          `json:"started"` // Unix time the run started
+	PID     int            `json:"pid"`
+	Changes []JournalEntry `json:"changes,omitempty"`
+}
+
+// JournalEntry is one change applied by a run
+type JournalEntry struct {
+	Action  string `json:"action"` // "create", "update" or "delete"
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content,omitempty"`
+}
+
+// runJournal keeps the journal of the running run up to date in the state file
+type runJournal struct {
+	mu        sync.Mutex
+	stateFile string
+}
+
+// openRunJournal starts the journal of a run. It returns the journal of a previous run that
+// did not finish, if any, and the repairs left pending by earlier runs. Without a state file
+// there is no journal (nil).
+func openRunJournal(config *Config, now time.Time) (*runJournal, *RunJournal, []JournalEntry) {
+	if config.StateFile == "" {
+		return nil, nil, nil
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, run not journaled: %v", err)
+		return nil, nil, nil
+	}
+	interrupted := state.ActiveRun
+	state.ActiveRun = &RunJournal{Started: now.Unix(), PID: os.Getpid()}
+	if err := state.save(config.StateFile); err != nil {
+		log.Printf("WARNING: Could not save state, run not journaled: %v", err)
+		return nil, interrupted, state.PendingRepairs
+	}
+	return &runJournal{stateFile: config.StateFile}, interrupted, state.PendingRepairs
+}
+
+// update applies change to the state file
+func (j *runJournal) update(change func(state *State)) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	state, err := loadState(j.stateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, change not journaled: %v", err)
+		return
+	}
+	change(state)
+	if err := state.save(j.stateFile); err != nil {
+		log.Printf("WARNING: Could not save state, change not journaled: %v", err)
+	}
+}
+
+// record adds an applied change. Heartbeats and the changelog are rewritten by every run
+// and are not journaled.
+func (j *runJournal) record(action, recordType, name, content string) {
+	if recordType == "TXT" {
+		return
+	}
+	j.update(func(state *State) {
+		if state.ActiveRun == nil {
+			state.ActiveRun = &RunJournal{Started: time.Now().Unix(), PID: os.Getpid()}
+		}
+		state.ActiveRun.Changes = append(state.ActiveRun.Changes, JournalEntry{Action: action, Type: recordType, Name: name, Content: content})
+	})
+}
+
+// setPendingRepairs replaces the repairs left for a later run
+func (j *runJournal) setPendingRepairs(entries []JournalEntry) {
+	j.update(func(state *State) { state.PendingRepairs = entries })
+}
+
+// close ends the journal once the run has finished
+func (j *runJournal) close() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	state, err := loadState(j.stateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, run journal left open: %v", err)
+		return
+	}
+	state.ActiveRun = nil
+	if err := state.save(j.stateFile); err != nil {
+		log.Printf("WARNING: Could not save state, run journal left open: %v", err)
+	}
+}
+
+// repairInterruptedRun deletes the records an interrupted run (nil if the last one finished)
+// left at the names it changed that the configuration no longer wants, along with the
+// pending repairs of earlier runs. The names this run does not manage stay pending.
+// Returns the number of successful/attempted operations.
+func repairInterruptedRun(cf providerClient, config *Config, ips *IPAddresses, interrupted *RunJournal, pending []JournalEntry, journal *runJournal) (successCount, totalCount int) {
+	changes := slices.Clone(pending)
+	if interrupted != nil {
+		started := time.Unix(interrupted.Started, 0).Format(time.RFC3339)
+		if len(interrupted.Changes) == 0 {
+			log.Printf("The run started at %s did not finish, but had not changed any records", started)
+		} else {
+			log.Printf("WARNING: The run started at %s did not finish after %d change(s) - repairing the names it changed", started, len(interrupted.Changes))
+		}
+		changes = append(changes, interrupted.Changes...)
+	}
+	if len(changes) == 0 {
+		return 0, 0
+	}
+	if len(pending) > 0 {
+		log.Printf("Repairing %d change(s) left pending by earlier interrupted runs", len(pending))
+	}
+
+	desired := make(map[recordKey]desiredRecordSet)
+	for _, set := range desiredRecordSets(config, ips) {
+		desired[recordKey{normalizeName(set.Name), set.Type}] = set
+	}
+	var touched []recordKey
+	entries := make(map[recordKey][]JournalEntry)
+	for _, entry := range changes {
+		key := recordKey{normalizeName(entry.Name), entry.Type}
+		if _, seen := entries[key]; !seen {
+			touched = append(touched, key)
+		}
+		entries[key] = append(entries[key], entry)
+	}
+
+	var carried []JournalEntry
+	repaired := 0
+	for _, key := range touched {
+		set, managed := desired[key]
+		switch {
+		case slices.ContainsFunc(config.CriticalDomains, func(name string) bool { return sameName(name, key.Name) }):
+			log.Printf("Repair: leaving %s %s to the staged change of the critical domain", key.Type, key.Name)
+			continue
+		case !managed || len(set.Values) == 0:
+			log.Printf("Repair: %s %s is not reconciled by this run - left for a later one", key.Type, key.Name)
+			carried = append(carried, entries[key]...)
+			continue
+		}
+
+		records, err := cf.getAllRecords(set.Name, set.Type)
+		if !succeeded(err) {
+			totalCount++
+			carried = append(carried, entries[key]...)
+			continue
+		}
+		for _, record := range records {
+			if slices.ContainsFunc(set.Values, func(value string) bool { return sameContent(value, record.Content) || sameName(value, record.Content) }) {
+				continue
+			}
+			if len(config.RecordTags) > 0 && !slices.Contains(record.Tags, managedByTag) {
+				log.Printf("Repair: keeping %s record %s -> %s, which is not tagged %s", record.Type, record.Name, record.Content, managedByTag)
+				continue
+			}
+			totalCount++
+			log.Printf("Repair: deleting %s record %s -> %s left by the interrupted run", record.Type, record.Name, record.Content)
+			if succeeded(cf.deleteRecord(record.ID, set.Name, set.Type)) {
+				successCount++
+				repaired++
+			}
+		}
+	}
+	journal.setPendingRepairs(carried)
+	log.Printf("Repair of interrupted runs: %d leftover record(s) deleted, %d change(s) left pending", repaired, len(carried))
+	return successCount, totalCount
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunJournalLifecycle(t *testing.T) {
+	_, cf := newFakeCloudFlare(t)
+	config := &Config{ExternalDomain: "home.example.com", StateFile: filepath.Join(t.TempDir(), "state.json")}
+	ips := &IPAddresses{ExternalIPv4: "203.0.113.1"}
+
+	var journaled []JournalEntry
+	cf.hooks().OnChange = func(action, recordType, name, content string) {
+		if state, err := loadState(config.StateFile); err == nil && state.ActiveRun != nil {
+			journaled = state.ActiveRun.Changes
+		}
+	}
+	reconcileRecords(cf, config, ips, config.now())
+
+	want := []JournalEntry{{Action: "create", Type: "A", Name: "home.example.com", Content: "203.0.113.1"}}
+	if !reflect.DeepEqual(journaled, want) {
+		t.Errorf("journal during the run = %+v, want %+v", journaled, want)
+	}
+	if state, _ := loadState(config.StateFile); state.ActiveRun != nil {
+		t.Errorf("journal left open after the run: %+v", state.ActiveRun)
+	}
+}
+
+func TestRepairInterruptedRun(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	fake.add("A", "home.example.com", "203.0.113.1")
+	fake.add("A", "home.example.com", "198.51.100.9") // Created before the crash, the old one was never deleted
+	fake.add("A", "lab.example.com", "10.0.0.5")
+	config := &Config{ExternalDomain: "home.example.com", StateFile: filepath.Join(t.TempDir(), "state.json")}
+	state := newState()
+	state.ActiveRun = &RunJournal{Started: 1700000000, Changes: []JournalEntry{
+		{Action: "create", Type: "A", Name: "home.example.com", Content: "198.51.100.9"},
+		{Action: "create", Type: "A", Name: "lab.example.com", Content: "10.0.0.5"},
+	}}
+	if err := state.save(config.StateFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// The address went back to the old one: the record of the interrupted run is a leftover
+	journal, interrupted, pending := openRunJournal(config, config.now())
+	if interrupted == nil || len(pending) != 0 {
+		t.Fatalf("interrupted = %+v, pending = %+v", interrupted, pending)
+	}
+	success, total := repairInterruptedRun(cf, config, &IPAddresses{ExternalIPv4: "203.0.113.1"}, interrupted, pending, journal)
+	if success != 1 || total != 1 {
+		t.Errorf("repair = %d/%d, want 1/1", success, total)
+	}
+	if got := fake.contents("home.example.com", "A"); !reflect.DeepEqual(got, []string{"203.0.113.1"}) {
+		t.Errorf("home.example.com after the repair = %v", got)
+	}
+
+	// lab.example.com is not managed by this configuration: kept, and left pending
+	if got := fake.contents("lab.example.com", "A"); !reflect.DeepEqual(got, []string{"10.0.0.5"}) {
+		t.Errorf("lab.example.com after the repair = %v", got)
+	}
+	state, _ = loadState(config.StateFile)
+	if len(state.PendingRepairs) != 1 || state.PendingRepairs[0].Name != "lab.example.com" {
+		t.Errorf("pending repairs = %+v, want lab.example.com", state.PendingRepairs)
+	}
+
+	// With record tags, records without the managed-by tag are never deleted
+	fake.add("A", "lab.example.com", "10.0.0.6")
+	config = &Config{ExternalDomain: "lab.example.com", RecordTags: []string{managedByTag}}
+	repairInterruptedRun(cf, config, &IPAddresses{ExternalIPv4: "10.0.0.6"}, nil, state.PendingRepairs, nil)
+	if got := fake.contents("lab.example.com", "A"); len(got) != 2 {
+		t.Errorf("untagged record deleted: %v", got)
+	}
+}
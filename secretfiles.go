@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Secrets from files. Each secret setting can be given as <SETTING>_FILE instead, the path of
+// a file holding it (e.g. a Docker or Kubernetes secret mounted under /run/secrets), so that
+// the secret never has to be in the environment of the process, where `docker inspect` and
+// /proc/<pid>/environ show it. Whitespace around the value, such as a trailing newline, is
+// trimmed. Setting both the secret and its file is an error.
+
+// secretSettings are the settings that may be read from a file with the _FILE suffix
+var secretSettings = map[string]bool{
+	"CF_API_TOKEN":              true,
+	"DYNDNS2_PASSWORD":          true,
+	"PORKBUN_API_KEY":           true,
+	"PORKBUN_SECRET_API_KEY":    true,
+	"NS1_API_KEY":               true,
+	"DYNV6_TOKEN":               true,
+	"WEBHOOK_SECRET":            true,
+	"ETCD_PASSWORD":             true,
+	"RELAY_TOKEN":               true,
+	"TSIG_SECRET":               true,
+	"EVENTS_TOKENS":             true,
+	"IP_CHANGE_WEBHOOK_SECRET":  true,
+	"NOTIFY_TELEGRAM_BOT_TOKEN": true,
+	"NOTIFY_DISCORD_URL":        true,
+	"NOTIFY_SLACK_URL":          true,
+	"NOTIFY_NTFY_URL":           true,
+	"NOTIFY_NTFY_TOKEN":         true,
+	"NOTIFY_PUSHOVER_TOKEN":     true,
+}
+
+// secretFromFile reads the secret setting key from the file named by key_FILE. It returns
+// false when key_FILE is not set.
+func secretFromFile(key string) (string, bool) {
+	path := getEnv(key + "_FILE")
+	if path == "" {
+		return "", false
+	}
+	if os.Getenv(envPrefix+key) != "" || configFileValue(key) != "" {
+		configFatalf("ERROR: Both %s%s and %s%s_FILE are set - use one of them", envPrefix, key, envPrefix, key)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		configFatalf("ERROR: Cannot read %s%s_FILE: %v", envPrefix, key, err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		configFatalf("ERROR: %s%s_FILE (%s) is empty", envPrefix, key, path)
+	}
+	return value, true
+}
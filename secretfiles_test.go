@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cf_api_token")
+	if err := os.WriteFile(path, []byte("  token-from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envPrefix+"CF_API_TOKEN_FILE", path)
+	t.Setenv(envPrefix+"CF_ZONE_ID", "zone")
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+
+	config, err := reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CFAPIToken != "token-from-file" {
+		t.Errorf("token = %q, want token-from-file", config.CFAPIToken)
+	}
+
+	t.Setenv(envPrefix+"CF_API_TOKEN", "token")
+	if _, err := reloadConfig(false); err == nil || !strings.Contains(err.Error(), "Both") {
+		t.Errorf("both the token and its file set: err = %v", err)
+	}
+	t.Setenv(envPrefix+"CF_API_TOKEN", "")
+
+	if err := os.WriteFile(path, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloadConfig(false); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("empty token file: err = %v", err)
+	}
+	t.Setenv(envPrefix+"CF_API_TOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := reloadConfig(false); err == nil || !strings.Contains(err.Error(), "Cannot read") {
+		t.Errorf("missing token file: err = %v", err)
+	}
+}
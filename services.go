@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Services (SERVICE_N with SERVICE_N_PROTO and SERVICE_N_PORT) are published as SRV records
+// pointing at the combined domain, e.g. _minecraft._tcp.home.example.com -> port 25565 of
+// home.example.com. An "https" service also gets an HTTPS record (RFC 9460), at the combined
+// domain itself for port 443 and at _<port>._https.<combined domain> otherwise. The records
+// are reconciled with the combined domain's addresses and removed by the cleanup along with
+// the host's heartbeat.
+
+const maxServices = 10
+
+// Service is a service published under the combined domain
+type Service struct {
+	Name  string // Service name without the underscore, e.g. "minecraft"
+	Proto string // "tcp" or "udp"
+	Port  int
+}
+
+// serviceNamePattern matches service names (RFC 6335: letters, digits and inner hyphens)
+var serviceNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,13}[a-z0-9])?$`)
+
+// parseServices reads SERVICE_1 to SERVICE_10, skipping invalid entries with a warning
+func parseServices() []Service {
+	var services []Service
+	for i := 1; i <= maxServices; i++ {
+		nameKey := fmt.Sprintf("SERVICE_%d", i)
+		protoKey := fmt.Sprintf("SERVICE_%d_PROTO", i)
+		portKey := fmt.Sprintf("SERVICE_%d_PORT", i)
+
+		name := strings.ToLower(strings.TrimPrefix(getEnv(nameKey), "_"))
+		proto := strings.ToLower(strings.TrimPrefix(getEnvOrDefault(protoKey, "tcp"), "_"))
+		portValue := getEnv(portKey)
+		if name == "" && portValue == "" {
+			continue
+		}
+		if !serviceNamePattern.MatchString(name) {
+			log.Printf("WARNING: %s%s must be a service name like \"minecraft\", got %q - skipping", envPrefix, nameKey, name)
+			continue
+		}
+		if proto != "tcp" && proto != "udp" {
+			log.Printf("WARNING: %s%s must be tcp or udp, got %q - skipping service %s", envPrefix, protoKey, proto, name)
+			continue
+		}
+		port, err := strconv.Atoi(portValue)
+		if err != nil || port < 1 || port > 65535 {
+			log.Printf("WARNING: %s%s must be a port number (1-65535), got %q - skipping service %s", envPrefix, portKey, portValue, name)
+			continue
+		}
+		services = append(services, Service{Name: name, Proto: proto, Port: port})
+	}
+	return services
+}
+
+// serviceRecordSets lists the SRV and HTTPS records of the configured services
+func serviceRecordSets(config *Config) []desiredRecordSet {
+	if config.CombinedDomain == "" {
+		return nil
+	}
+	target := normalizeName(config.CombinedDomain)
+	var sets []desiredRecordSet
+	add := func(name, recordType, content string) {
+		sets = append(sets, desiredRecordSet{Name: name, Type: recordType, Values: []string{content}, Single: true,
+			Label: recordType + " service record", TTL: config.ttlFor(name)})
+	}
+	for _, service := range config.Services {
+		add(fmt.Sprintf("_%s._%s.%s", service.Name, service.Proto, target), "SRV", fmt.Sprintf("0 0 %d %s.", service.Port, target))
+		switch {
+		case service.Name != "https" || service.Proto != "tcp":
+		case service.Port == 443:
+			add(target, "HTTPS", "1 .")
+		default:
+			add(fmt.Sprintf("_%d._https.%s", service.Port, target), "HTTPS", "1 "+target+".")
+		}
+	}
+	return sets
+}
+
+// cfRecordData is the structured content CloudFlare keeps for SRV and HTTPS records
+type cfRecordData struct {
+	Priority int    `json:"priority"`
+	Weight   int    `json:"weight"`
+	Port     int    `json:"port"`
+	Target   string `json:"target"`
+	Value    string `json:"value"`
+}
+
+// cfServiceData converts SRV and HTTPS content in presentation format to the data object
+// CloudFlare takes instead of content. Other types (and content that does not parse)
+// return nil and are sent as content.
+func cfServiceData(recordType, content string) map[string]any {
+	fields := strings.Fields(content)
+	switch {
+	case recordType == "SRV" && len(fields) == 4:
+		var numbers [3]int
+		for i := range numbers {
+			n, err := strconv.Atoi(fields[i])
+			if err != nil {
+				return nil
+			}
+			numbers[i] = n
+		}
+		return map[string]any{"priority": numbers[0], "weight": numbers[1], "port": numbers[2], "target": strings.TrimSuffix(fields[3], ".")}
+	case recordType == "HTTPS" && len(fields) >= 2:
+		priority, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil
+		}
+		target := fields[1]
+		if target != "." {
+			target = strings.TrimSuffix(target, ".")
+		}
+		return map[string]any{"priority": priority, "target": target, "value": strings.Join(fields[2:], " ")}
+	}
+	return nil
+}
+
+// serviceContent returns the presentation format of a listed CloudFlare SRV or HTTPS
+// record, so that it compares equal to the content it was created from
+func (r *CFRecord) serviceContent() string {
+	if r.Data == nil {
+		return r.Content
+	}
+	target := r.Data.Target
+	if target != "." && !strings.HasSuffix(target, ".") {
+		target += "."
+	}
+	switch r.Type {
+	case "SRV":
+		return fmt.Sprintf("%d %d %d %s", r.Data.Priority, r.Data.Weight, r.Data.Port, target)
+	case "HTTPS":
+		return strings.TrimSpace(fmt.Sprintf("%d %s %s", r.Data.Priority, target, r.Data.Value))
+	}
+	return r.Content
+}
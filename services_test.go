@@ -0,0 +1,108 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseServices(t *testing.T) {
+	t.Setenv(envPrefix+"SERVICE_1", "minecraft")
+	t.Setenv(envPrefix+"SERVICE_1_PORT", "25565")
+	t.Setenv(envPrefix+"SERVICE_2", "_sip")
+	t.Setenv(envPrefix+"SERVICE_2_PROTO", "UDP")
+	t.Setenv(envPrefix+"SERVICE_2_PORT", "5060")
+	t.Setenv(envPrefix+"SERVICE_3", "bad_name")
+	t.Setenv(envPrefix+"SERVICE_3_PORT", "80")
+	t.Setenv(envPrefix+"SERVICE_4", "https")
+	t.Setenv(envPrefix+"SERVICE_4_PORT", "70000")
+
+	want := []Service{{Name: "minecraft", Proto: "tcp", Port: 25565}, {Name: "sip", Proto: "udp", Port: 5060}}
+	if got := parseServices(); !slices.Equal(got, want) {
+		t.Errorf("parseServices() = %v, want %v", got, want)
+	}
+}
+
+func TestServiceRecordSets(t *testing.T) {
+	config := &Config{
+		CombinedDomain: "Home.Example.com",
+		RecordTTL:      300,
+		Services:       []Service{{"minecraft", "tcp", 25565}, {"https", "tcp", 443}, {"https", "tcp", 8443}},
+	}
+	var got []string
+	for _, set := range serviceRecordSets(config) {
+		got = append(got, set.Name+" "+set.Type+" "+set.Values[0])
+	}
+	want := []string{
+		"_minecraft._tcp.home.example.com SRV 0 0 25565 home.example.com.",
+		"_https._tcp.home.example.com SRV 0 0 443 home.example.com.",
+		"home.example.com HTTPS 1 .",
+		"_https._tcp.home.example.com SRV 0 0 8443 home.example.com.",
+		"_8443._https.home.example.com HTTPS 1 home.example.com.",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("service records =\n%v\nwant\n%v", got, want)
+	}
+
+	config.CombinedDomain = ""
+	if sets := serviceRecordSets(config); len(sets) != 0 {
+		t.Errorf("without a combined domain got %v, want no service records", sets)
+	}
+}
+
+func TestServiceRecordsReconciled(t *testing.T) {
+	for _, concurrency := range []int{0, 4} {
+		fake, cf := newFakeCloudFlare(t)
+		config := &Config{
+			CombinedDomain:    "home.example.com",
+			Proxied:           true,
+			UpdateConcurrency: concurrency,
+			Services:          []Service{{"https", "tcp", 8443}},
+		}
+		ips := &IPAddresses{ExternalIPv4: "203.0.113.1"}
+		reconcileRecords(cf, config, ips, time.Now())
+
+		if got := fake.contents("_https._tcp.home.example.com", "SRV"); len(got) != 1 || got[0] != "0 8443 home.example.com" {
+			t.Errorf("concurrency %d: SRV records = %v, want one for port 8443", concurrency, got)
+		}
+		if got := fake.contents("_8443._https.home.example.com", "HTTPS"); len(got) != 1 || got[0] != "1 home.example.com" {
+			t.Errorf("concurrency %d: HTTPS records = %v, want one pointing at the combined domain", concurrency, got)
+		}
+
+		// A second run finds the records in sync; a changed port updates the SRV record in place
+		var changes []string
+		cf.OnChange = func(action, recordType, name, content string) {
+			changes = append(changes, action+" "+recordType)
+		}
+		reconcileRecords(cf, config, ips, time.Now())
+		if len(changes) != 0 {
+			t.Errorf("concurrency %d: second run changed %v, want nothing", concurrency, changes)
+		}
+		config.Services[0].Port = 9443
+		reconcileRecords(cf, config, ips, time.Now())
+		if got := fake.contents("_https._tcp.home.example.com", "SRV"); len(got) != 1 || got[0] != "0 9443 home.example.com" {
+			t.Errorf("concurrency %d: SRV records = %v, want the new port", concurrency, got)
+		}
+	}
+}
+
+func TestCleanupRemovesServiceRecords(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	config := &Config{
+		CombinedDomain: "home.example.com",
+		StaleThreshold: 3600,
+		Services:       []Service{{"minecraft", "tcp", 25565}},
+	}
+	fake.add("A", "home.example.com", "203.0.113.1")
+	fake.add("TXT", "home.example.com", heartbeatContent(time.Now().Add(-2*time.Hour)))
+	fake.add("SRV", "_minecraft._tcp.home.example.com", "0 25565 home.example.com")
+	fake.add("SRV", "_minecraft._tcp.other.example.com", "0 25565 other.example.com")
+
+	cleanupStaleDomains(cf, config, func(name string) bool { return name == "home.example.com" })
+	if got := fake.contents("_minecraft._tcp.home.example.com", "SRV"); len(got) != 0 {
+		t.Errorf("SRV records = %v, want them deleted with the stale host", got)
+	}
+	if got := fake.contents("_minecraft._tcp.other.example.com", "SRV"); len(got) != 1 {
+		t.Errorf("other SRV records = %v, want them kept", got)
+	}
+}
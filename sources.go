@@ -0,0 +1,251 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Weight of the newest observation in the moving averages
+const sourceHealthAlpha = 0.3
+
+// Sources with a success rate below this are tried only after all healthier sources
+const flakySourceThreshold = 0.5
+
+// A deprioritized source not used for this long takes its configured position again, so a
+// source that recovered is probed and can regain its rank
+const sourceReprobeInterval = time.Hour
+
+// SourceHealth tracks the reliability of one detection source over time
+type SourceHealth struct {
+	Successes   int     `json:"successes"`
+	Failures    int     `json:"failures"`
+	SuccessRate float64 `json:"success_rate"` // Exponentially weighted moving average (0..1)
+	LatencyMs   float64 `json:"latency_ms"`   // Exponentially weighted moving average of successful queries
+	LastError   string  `json:"last_error,omitempty"`
+	LastUsed    int64   `json:"last_used"`
+}
+
+// record updates the health statistics with one observation
+func (h *SourceHealth) record(ok bool, latency time.Duration, errMsg string, now time.Time) {
+	observation := 0.0
+	if ok {
+		observation = 1.0
+	}
+
+	if h.Successes+h.Failures == 0 {
+		h.SuccessRate = observation
+	} else {
+		h.SuccessRate = sourceHealthAlpha*observation + (1-sourceHealthAlpha)*h.SuccessRate
+	}
+
+	if ok {
+		ms := float64(latency) / float64(time.Millisecond)
+		if h.Successes == 0 {
+			h.LatencyMs = ms
+		} else {
+			h.LatencyMs = sourceHealthAlpha*ms + (1-sourceHealthAlpha)*h.LatencyMs
+		}
+		h.Successes++
+		h.LastError = ""
+	} else {
+		h.Failures++
+		h.LastError = errMsg
+	}
+
+	h.SuccessRate = math.Round(h.SuccessRate*1000) / 1000
+	h.LatencyMs = math.Round(h.LatencyMs*10) / 10
+	h.LastUsed = now.Unix()
+}
+
+// deprioritized reports whether the source is tried only after all healthier sources
+func (h *SourceHealth) deprioritized(now time.Time) bool {
+	return h != nil && h.SuccessRate < flakySourceThreshold && now.Sub(time.Unix(h.LastUsed, 0)) < sourceReprobeInterval
+}
+
+// SourceTracker holds health for all detection sources, keyed by "<family>:<source>"
+type SourceTracker struct {
+	Sources map[string]*SourceHealth
+}
+
+// newSourceTracker wraps an existing health map (which may be nil)
+func newSourceTracker(sources map[string]*SourceHealth) *SourceTracker {
+	if sources == nil {
+		sources = make(map[string]*SourceHealth)
+	}
+	return &SourceTracker{Sources: sources}
+}
+
+// get returns the health entry for a source, or nil if never used
+func (t *SourceTracker) get(family, source string) *SourceHealth {
+	if t == nil {
+		return nil
+	}
+	return t.Sources[family+":"+source]
+}
+
+// record stores one observation for a source
+func (t *SourceTracker) record(family, source string, ok bool, latency time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	key := family + ":" + source
+	h, exists := t.Sources[key]
+	if !exists {
+		h = &SourceHealth{}
+		t.Sources[key] = h
+	}
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	h.record(ok, latency, errMsg, time.Now())
+}
+
+// rank orders sources by health: healthy sources first, flaky sources last (in their
+// configured order). Healthy sources with a latency history are ordered fastest first in
+// the positions they take in the configuration; sources without history, and flaky ones due
+// for a re-probe, keep their configured position.
+func (t *SourceTracker) rank(family string, sources []string) []string {
+	now := time.Now()
+	var healthy, flaky, measured []string
+	var slots []int // Positions in healthy of the measured sources
+	for _, s := range sources {
+		h := t.get(family, s)
+		switch {
+		case h.deprioritized(now):
+			flaky = append(flaky, s)
+		case h != nil && h.Successes > 0 && h.SuccessRate >= flakySourceThreshold:
+			slots = append(slots, len(healthy))
+			measured = append(measured, s)
+			healthy = append(healthy, s)
+		default:
+			healthy = append(healthy, s)
+		}
+	}
+
+	sort.SliceStable(measured, func(i, j int) bool {
+		return t.get(family, measured[i]).LatencyMs < t.get(family, measured[j]).LatencyMs
+	})
+	for i, slot := range slots {
+		healthy[slot] = measured[i]
+	}
+	return append(healthy, flaky...)
+}
+
+// detectFromSources tries sources in ranked order until query succeeds, recording health as it goes
+func detectFromSources(family string, sources []string, tracker *SourceTracker, query func(source string) (string, error)) string {
+	for _, source := range tracker.rank(family, sources) {
+		start := time.Now()
+		result, err := query(source)
+		tracker.record(family, source, err == nil, time.Since(start), err)
+		if err == nil {
+			return result
+		}
+		log.Printf("Detection source %s failed: %v", source, err)
+	}
+	return ""
+}
+
+// memorySources tracks source health for the life of the process without a state file
+var memorySources = newSourceTracker(nil)
+
+// loadSourceTracker loads source health from the state file (in-memory only if no state file)
+func loadSourceTracker(config *Config) *SourceTracker {
+	if config.StateFile == "" {
+		return memorySources
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, source health not tracked: %v", err)
+		return newSourceTracker(nil)
+	}
+	return newSourceTracker(state.Sources)
+}
+
+// saveSourceTracker persists source health in the state file (if configured)
+func saveSourceTracker(config *Config, tracker *SourceTracker) {
+	if config.StateFile == "" {
+		return
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, source health not saved: %v", err)
+		return
+	}
+	state.Sources = tracker.Sources
+	if err := state.save(config.StateFile); err != nil {
+		log.Printf("WARNING: Could not save state: %v", err)
+	}
+}
+
+// formatSourceRanking renders the ranked sources for one family
+func formatSourceRanking(tracker *SourceTracker, family string, sources []string) string {
+	var b strings.Builder
+	for i, source := range tracker.rank(family, sources) {
+		h := tracker.get(family, source)
+		if h == nil {
+			fmt.Fprintf(&b, "  %d. %-28s (no data)\n", i+1, source)
+			continue
+		}
+		status := "healthy"
+		switch {
+		case h.deprioritized(time.Now()):
+			status = "deprioritized"
+		case h.SuccessRate < flakySourceThreshold:
+			status = "re-probe due"
+		}
+		fmt.Fprintf(&b, "  %d. %-28s success %5.1f%%  latency %6.0fms  (%d ok / %d failed, %s)\n",
+			i+1, source, h.SuccessRate*100, h.LatencyMs, h.Successes, h.Failures, status)
+		if h.LastError != "" {
+			fmt.Fprintf(&b, "       last error: %s\n", h.LastError)
+		}
+	}
+	return b.String()
+}
+
+// runStatus implements the status subcommand
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config := readConfig(false, false)
+	if config.StateFile == "" {
+		fmt.Fprintf(os.Stderr, "%sSTATE_FILE is not set - no status is being recorded\n", envPrefix)
+		return 1
+	}
+
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading state: %v\n", err)
+		return 1
+	}
+
+	if state.LastDetection != nil {
+		d := state.LastDetection
+		fmt.Printf("Last detection: %s\n", time.Unix(d.Timestamp, 0).Format(time.RFC3339))
+		fmt.Printf("  Internal IPv4: %s\n", strings.Join(d.IPs.InternalIPv4, ", "))
+		fmt.Printf("  External IPv4: %s\n", d.IPs.ExternalIPv4)
+		fmt.Printf("  External IPv6: %s\n", d.IPs.ExternalIPv6)
+	} else {
+		fmt.Println("Last detection: never")
+	}
+	fmt.Printf("Queued changes: %d\n", len(state.PendingMutations))
+
+	tracker := newSourceTracker(state.Sources)
+	fmt.Println()
+	fmt.Println("IPv4 detection sources (in the order they will be tried):")
+	fmt.Print(formatSourceRanking(tracker, "ipv4", config.IPv4Sources))
+	fmt.Println()
+	fmt.Println("IPv6 detection sources (in the order they will be tried):")
+	fmt.Print(formatSourceRanking(tracker, "ipv6", config.IPv6Sources))
+
+	return 0
+}
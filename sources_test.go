@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSourceHealthRecord(t *testing.T) {
+	h := &SourceHealth{}
+	now := time.Unix(1700000000, 0)
+
+	h.record(true, 100*time.Millisecond, "", now)
+	if h.SuccessRate != 1 || h.LatencyMs != 100 {
+		t.Fatalf("first observation: rate=%v latency=%v", h.SuccessRate, h.LatencyMs)
+	}
+
+	h.record(false, 0, "timeout", now)
+	if h.SuccessRate != 0.7 {
+		t.Errorf("SuccessRate = %v, want 0.7", h.SuccessRate)
+	}
+	if h.LatencyMs != 100 {
+		t.Errorf("failures must not affect latency, got %v", h.LatencyMs)
+	}
+	if h.LastError != "timeout" || h.Failures != 1 || h.Successes != 1 {
+		t.Errorf("unexpected counters: %+v", h)
+	}
+
+	h.record(true, 200*time.Millisecond, "", now)
+	if h.LatencyMs != 130 {
+		t.Errorf("LatencyMs = %v, want 130", h.LatencyMs)
+	}
+	if h.LastError != "" {
+		t.Errorf("LastError not cleared on success: %q", h.LastError)
+	}
+}
+
+func TestSourceTrackerRank(t *testing.T) {
+	flaky := &SourceHealth{Successes: 1, Failures: 4, SuccessRate: 0.2, LatencyMs: 10, LastUsed: time.Now().Unix()}
+	tracker := newSourceTracker(map[string]*SourceHealth{
+		"ipv4:slow":  {Successes: 5, SuccessRate: 1, LatencyMs: 800},
+		"ipv4:fast":  {Successes: 5, SuccessRate: 1, LatencyMs: 50},
+		"ipv4:flaky": flaky,
+	})
+
+	// Measured sources trade places by latency; a source without history keeps its position
+	got := tracker.rank("ipv4", []string{"flaky", "slow", "new", "fast"})
+	want := []string{"fast", "new", "slow", "flaky"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rank = %v, want %v", got, want)
+	}
+	if got := tracker.rank("ipv4", []string{"new", "slow", "fast"}); !reflect.DeepEqual(got, []string{"new", "fast", "slow"}) {
+		t.Errorf("rank = %v, want the new source first as configured", got)
+	}
+
+	// A flaky source unused for the re-probe interval is tried in its configured position
+	flaky.LastUsed = time.Now().Add(-sourceReprobeInterval - time.Minute).Unix()
+	got = tracker.rank("ipv4", []string{"flaky", "slow", "new", "fast"})
+	if want := []string{"flaky", "fast", "new", "slow"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rank with a re-probe due = %v, want %v", got, want)
+	}
+
+	// Health is per family
+	got = tracker.rank("ipv6", []string{"flaky", "slow"})
+	if !reflect.DeepEqual(got, []string{"flaky", "slow"}) {
+		t.Errorf("ipv6 rank = %v, want configured order", got)
+	}
+}
+
+func TestDetectFromSources(t *testing.T) {
+	tracker := newSourceTracker(nil)
+	var tried []string
+	query := func(source string) (string, error) {
+		tried = append(tried, source)
+		if source == "down" {
+			return "", errors.New("connection refused")
+		}
+		return "203.0.113.7", nil
+	}
+
+	if got := detectFromSources("ipv4", []string{"down", "up"}, tracker, query); got != "203.0.113.7" {
+		t.Fatalf("detectFromSources = %q", got)
+	}
+	if !reflect.DeepEqual(tried, []string{"down", "up"}) {
+		t.Errorf("tried = %v", tried)
+	}
+	if h := tracker.get("ipv4", "down"); h == nil || h.Failures != 1 || h.LastError != "connection refused" {
+		t.Errorf("failure not recorded: %+v", h)
+	}
+
+	// The failed source is now deprioritized
+	tried = nil
+	detectFromSources("ipv4", []string{"down", "up"}, tracker, query)
+	if !reflect.DeepEqual(tried, []string{"up"}) {
+		t.Errorf("second run tried = %v, want [up]", tried)
+	}
+}
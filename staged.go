@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Staged changes. Resolvers keep serving a cached record until its TTL expires, so after an
+// ISP change a name with a long TTL points clients at the dead address for up to that long.
+// Address changes of the names in CRITICAL_DOMAINS are delivered in two steps: the first run
+// republishes the current address with CANARY_TTL, and the runs after it hold the record
+// until the previous TTL has expired before switching. Caches then hold the dead address for
+// at most CANARY_TTL. The stages are kept in the state file between runs.
+
+const defaultCanaryTTL = 60 // TTL while a staged change waits for the old TTL to expire
+
+// StagedChange is an address change of a critical name waiting for the old TTL to expire
+type StagedChange struct {
+	From      string `json:"from"`       // Address held with the canary TTL
+	To        string `json:"to"`         // Address the record switches to
+	OldTTL    int    `json:"old_ttl"`    // TTL in seconds caches may have fetched From with
+	LoweredAt int64  `json:"lowered_at"` // When the TTL was lowered (unix seconds)
+}
+
+// switchAt returns when caches no longer hold the record with its old TTL
+func (s *StagedChange) switchAt() time.Time {
+	return time.Unix(s.LoweredAt, 0).Add(time.Duration(s.OldTTL)*time.Second + ttlGrace)
+}
+
+// nameTTLSetter is implemented by providers that publish per-name TTLs, which staged changes
+// need to lower the TTL of a single name
+type nameTTLSetter interface {
+	setNameTTLs(ttls map[string]int)
+}
+
+func (cf *CloudFlareClient) setNameTTLs(ttls map[string]int) {
+	cf.NameTTLs = ttls
+}
+
+func (c *recordSetClient) setNameTTLs(ttls map[string]int) {
+	c.NameTTLs = nameTTLSeconds(ttls)
+}
+
+// warnCriticalDomains warns about critical names that cannot be staged
+func warnCriticalDomains(config *Config) {
+	if len(config.CriticalDomains) == 0 {
+		return
+	}
+	if config.StateFile == "" {
+		log.Printf("WARNING: %sCRITICAL_DOMAINS requires %sSTATE_FILE - changes are not staged", envPrefix, envPrefix)
+	}
+	for _, name := range config.CriticalDomains {
+		if config.proxiedName(name) {
+			log.Printf("WARNING: proxied records are not cached with their address - critical domain %s is not staged", name)
+		}
+		if !sameName(name, config.ExternalDomain) && !sameName(name, config.IPv6Domain) {
+			log.Printf("WARNING: critical domain %s is neither %sEXTERNAL_DOMAIN nor %sIPV6_DOMAIN - its changes are not staged", name, envPrefix, envPrefix)
+		}
+	}
+}
+
+// sameName reports whether two configured names are the same DNS name
+func sameName(a, b string) bool {
+	return a != "" && b != "" && normalizeName(a) == normalizeName(b)
+}
+
+// stageCriticalChanges holds back address changes of critical names until the old TTL has
+// expired, lowering the TTL first. It returns the configuration and addresses to reconcile
+// with this run: held names keep their current address and the canary TTL.
+func stageCriticalChanges(cf providerClient, config *Config, ips *IPAddresses) (*Config, *IPAddresses) {
+	if len(config.CriticalDomains) == 0 || config.StateFile == "" {
+		return config, ips
+	}
+	setter, ok := cf.(nameTTLSetter)
+	if !ok {
+		log.Printf("WARNING: the provider cannot lower the TTL of single names - changes to %sCRITICAL_DOMAINS are not staged", envPrefix)
+		return config, ips
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, changes are not staged: %v", err)
+		return config, ips
+	}
+	if state.Staged == nil {
+		state.Staged = make(map[string]*StagedChange)
+	}
+
+	held := *ips
+	ttls := maps.Clone(config.DomainTTLs)
+	if ttls == nil {
+		ttls = make(map[string]int)
+	}
+	setter.setNameTTLs(ttls) // Drop the holds of the previous run
+	now := config.now()
+	targets := []struct {
+		name, recordType string
+		address          *string
+	}{
+		{config.ExternalDomain, "A", &held.ExternalIPv4},
+		{config.IPv6Domain, "AAAA", &held.ExternalIPv6},
+	}
+	for _, target := range targets {
+		critical := slices.ContainsFunc(config.CriticalDomains, func(name string) bool { return sameName(name, target.name) })
+		if !critical || *target.address == "" || config.proxiedFor(target.name, *target.address) {
+			continue
+		}
+		key := target.recordType + " " + normalizeName(target.name)
+		record, err := cf.getRecord(target.name, target.recordType)
+		if err != nil {
+			log.Printf("WARNING: Could not look up %s record %s, not staging its change: %v", target.recordType, target.name, err)
+			continue
+		}
+		if record == nil || sameContent(record.Content, *target.address) {
+			delete(state.Staged, key) // Created, switched or changed back: nothing to hold
+			continue
+		}
+
+		oldTTL := record.TTL
+		if oldTTL == 0 {
+			oldTTL = config.ttlFor(target.name) // The provider does not report TTLs
+		}
+		if oldTTL == autoRecordTTL {
+			oldTTL = autoTTLSeconds
+		}
+		stage := state.Staged[key]
+		if stage != nil && !sameContent(stage.From, record.Content) {
+			stage = nil // The record changed since the stage began
+		}
+		if stage == nil && oldTTL <= config.CanaryTTL {
+			continue // Nothing is cached for longer than the canary TTL
+		}
+
+		ttls[normalizeName(target.name)] = config.CanaryTTL
+		setter.setNameTTLs(ttls)
+		if stage == nil || (record.TTL != 0 && record.TTL != config.CanaryTTL) {
+			log.Printf("Staging %s record %s change %s -> %s: lowering TTL %d -> %d first",
+				target.recordType, target.name, record.Content, *target.address, oldTTL, config.CanaryTTL)
+			if !succeeded(cf.updateRecord(record.ID, target.name, target.recordType, record.Content, config.proxiedFor(target.name, record.Content))) {
+				*target.address = record.Content // Retried next run; never switch before the drop
+				continue
+			}
+			if stage == nil {
+				stage = &StagedChange{From: record.Content, OldTTL: oldTTL}
+				state.Staged[key] = stage
+			}
+			stage.LoweredAt = now.Unix()
+		}
+		stage.To = *target.address
+
+		if wait := stage.switchAt().Sub(now); wait > 0 {
+			log.Printf("Holding %s record %s at %s for %s until caches expire the old TTL (then -> %s)",
+				target.recordType, target.name, record.Content, wait.Round(time.Second), stage.To)
+			*target.address = record.Content
+			continue
+		}
+		log.Printf("Old TTL of %s record %s has expired - switching %s -> %s", target.recordType, target.name, record.Content, stage.To)
+		if ttl, ok := config.DomainTTLs[normalizeName(target.name)]; ok {
+			ttls[normalizeName(target.name)] = ttl
+		} else {
+			delete(ttls, normalizeName(target.name))
+		}
+		setter.setNameTTLs(ttls)
+	}
+
+	if err := state.save(config.StateFile); err != nil {
+		log.Printf("WARNING: Could not save state: %v", err)
+	}
+	staged := *config
+	staged.DomainTTLs = ttls
+	return &staged, &held
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStagedChangeWaitsForOldTTL(t *testing.T) {
+	for _, concurrency := range []int{0, 4} {
+		fake, cf := newFakeCloudFlare(t)
+		cf.TTL = 3600
+		id := fake.add("A", "home.example.com", "203.0.113.1")
+		record := fake.records[id]
+		record.TTL = 3600
+		fake.records[id] = record
+
+		clock := newFakeClock(time.Unix(1700000000, 0))
+		config := &Config{
+			ExternalDomain:    "home.example.com",
+			RecordTTL:         3600,
+			CriticalDomains:   []string{"home.example.com"},
+			CanaryTTL:         60,
+			StateFile:         filepath.Join(t.TempDir(), "state.json"),
+			UpdateConcurrency: concurrency,
+			clock:             clock,
+		}
+		ips := &IPAddresses{ExternalIPv4: "198.51.100.7"}
+		check := func(step, content string, ttl int) {
+			t.Helper()
+			got := fake.records[id]
+			if got.Content != content || got.TTL != ttl {
+				t.Errorf("concurrency %d, %s: record = %s TTL %d, want %s TTL %d", concurrency, step, got.Content, got.TTL, content, ttl)
+			}
+		}
+
+		// The first run lowers the TTL and keeps the old address
+		reconcileRecords(cf, config, ips, clock.Now())
+		check("first run", "203.0.113.1", 60)
+
+		// Runs before the old TTL has expired keep holding it
+		clock.advance(30 * time.Minute)
+		reconcileRecords(cf, config, ips, clock.Now())
+		check("half the old TTL later", "203.0.113.1", 60)
+
+		// Once caches have expired the old TTL, the address switches with the normal TTL
+		clock.advance(31 * time.Minute)
+		reconcileRecords(cf, config, ips, clock.Now())
+		check("after the old TTL", "198.51.100.7", 3600)
+
+		state, err := loadState(config.StateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(state.Staged) != 1 {
+			t.Errorf("concurrency %d: stages = %v, want the switched one until the next run", concurrency, state.Staged)
+		}
+		reconcileRecords(cf, config, ips, clock.Now())
+		if state, _ := loadState(config.StateFile); len(state.Staged) != 0 {
+			t.Errorf("concurrency %d: stages = %v, want none after the switch", concurrency, state.Staged)
+		}
+	}
+}
+
+func TestStagedChangeSkipsShortTTLs(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	id := fake.add("A", "home.example.com", "203.0.113.1")
+	record := fake.records[id]
+	record.TTL = 60
+	fake.records[id] = record
+
+	// Nothing is cached for longer than the canary TTL, and other names are never held
+	config := &Config{
+		ExternalDomain:  "home.example.com",
+		InternalDomain:  "lan.example.com",
+		RecordTTL:       60,
+		CriticalDomains: []string{"HOME.example.com."},
+		CanaryTTL:       60,
+		StateFile:       filepath.Join(t.TempDir(), "state.json"),
+	}
+	reconcileRecords(cf, config, &IPAddresses{ExternalIPv4: "198.51.100.7", InternalIPv4: []string{"10.0.0.2"}}, time.Now())
+	if got := fake.records[id].Content; got != "198.51.100.7" {
+		t.Errorf("content = %s, want the new address right away", got)
+	}
+	if got := fake.contents("lan.example.com", "A"); len(got) != 1 || got[0] != "10.0.0.2" {
+		t.Errorf("internal records = %v, want [10.0.0.2]", got)
+	}
+}
+
+func TestStagedChangeRecordSetProvider(t *testing.T) {
+	r53, r := newFakeRoute53(t)
+	r.upsertRecord("home.example.com", "A", "203.0.113.1", false)
+
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	config := &Config{
+		ExternalDomain:  "home.example.com",
+		RecordTTL:       600,
+		CriticalDomains: []string{"home.example.com"},
+		CanaryTTL:       60,
+		StateFile:       filepath.Join(t.TempDir(), "state.json"),
+		clock:           clock,
+	}
+	ips := &IPAddresses{ExternalIPv4: "198.51.100.7"}
+	reconcileRecords(r, config, ips, clock.Now())
+	set := r53.sets["home.example.com./A"]
+	if set.TTL != 60 || len(set.ResourceRecords) != 1 || set.ResourceRecords[0].Value != "203.0.113.1" {
+		t.Errorf("record set = %v TTL %d, want the old address with the canary TTL", set.ResourceRecords, set.TTL)
+	}
+
+	// Record sets carry no TTL when read, so the configured TTL is waited for
+	clock.advance(11 * time.Minute)
+	reconcileRecords(r, config, ips, clock.Now())
+	set = r53.sets["home.example.com./A"]
+	if set.TTL != r.TTL || len(set.ResourceRecords) != 1 || set.ResourceRecords[0].Value != "198.51.100.7" {
+		t.Errorf("record set = %v TTL %d, want the new address with the normal TTL", set.ResourceRecords, set.TTL)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// State is persisted between runs in the state file (BEES_IP_UPDATE_STATE_FILE)
+type State struct {
+	Domains          map[string]*DomainState  `json:"domains"`
+	LastReportMonth  string                   `json:"last_report_month,omitempty"` // "YYYY-MM" of the last SLO report sent
+	PendingMutations []PendingMutation        `json:"pending_mutations,omitempty"` // Changes queued while the API was unreachable
+	LastDetection    *CachedDetection         `json:"last_detection,omitempty"`    // Most recent detection result (for fast start)
+	Sources          map[string]*SourceHealth `json:"sources,omitempty"`           // Detection source health, keyed by "<family>:<source>"
+	LastEmitted      *CachedDetection         `json:"last_emitted,omitempty"`      // Addresses last emitted by detection-only mode
+	StatusPage       *StatusPageState         `json:"status_page,omitempty"`       // Last published status page
+	Staged           map[string]*StagedChange `json:"staged,omitempty"`            // Changes of critical names waiting for the old TTL, keyed by "<type> <name>"
+	ActiveRun        *RunJournal              `json:"active_run,omitempty"`        // Journal of the running run; left behind by one that did not finish
+	PendingRepairs   []JournalEntry           `json:"pending_repairs,omitempty"`   // Changes of interrupted runs at names not reconciled since
+	LastExternal     *ExternalAddresses       `json:"last_external,omitempty"`     // External addresses last announced by the IP change webhook
+	UpdateFailing    bool                     `json:"update_failing,omitempty"`    // The last update run failed (update-failed was notified)
+}
+
+// CachedDetection is a detection result with the time it was taken
+type CachedDetection struct {
+	Timestamp int64       `json:"ts"`
+	IPs       IPAddresses `json:"ips"`
+}
+
+// DomainState holds per-domain history
+type DomainState struct {
+	Changes []ChangeEvent `json:"changes"`
+}
+
+// ChangeEvent records one run in which a domain's addresses changed
+type ChangeEvent struct {
+	Timestamp      int64         `json:"ts"`              // When the change was detected (unix seconds)
+	LatencySeconds float64       `json:"latency_s"`       // Detection -> propagation (or -> API applied if unverified)
+	Verified       bool          `json:"verified"`        // Whether propagation was confirmed via DNS
+	Probe          *LatencyProbe `json:"probe,omitempty"` // Line latency measured after an external address change
+}
+
+// newState returns an empty state
+func newState() *State {
+	return &State{Domains: make(map[string]*DomainState)}
+}
+
+// loadState reads the state file. A missing file yields an empty state.
+func loadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return newState(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := newState()
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	if state.Domains == nil {
+		state.Domains = make(map[string]*DomainState)
+	}
+	return state, nil
+}
+
+// save writes the state file atomically (write to temp file, then rename)
+func (s *State) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".state-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// domain returns the state for a domain, creating it if needed
+func (s *State) domain(name string) *DomainState {
+	ds, ok := s.Domains[name]
+	if !ok {
+		ds = &DomainState{}
+		s.Domains[name] = ds
+	}
+	return ds
+}
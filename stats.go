@@ -0,0 +1,280 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How long change history is kept in the state file
+const statsRetention = 400 * 24 * time.Hour
+
+// DomainStats summarises a domain's change history over a period
+type DomainStats struct {
+	Domain           string
+	Changes          int
+	ChangesPerDay    float64
+	MeanTimeBetween  time.Duration // Mean time between IP changes (0 if fewer than 2 changes)
+	MeanLatency      time.Duration // Mean detection -> propagation latency
+	MaxLatency       time.Duration
+	VerifiedChanges  int
+	WithinSLO        int // Verified changes propagated within the SLO target
+	SLOTarget        time.Duration
+	SLOAttainmentPct float64 // WithinSLO / VerifiedChanges * 100 (100 if nothing verified)
+	ProbedChanges    int     // Changes with a successful latency probe
+	MeanProbeMs      float64 // Mean median latency of those probes
+	PeriodStart      time.Time
+	PeriodEnd        time.Time
+}
+
+// computeDomainStats calculates statistics for the events within [from, to)
+func computeDomainStats(domain string, events []ChangeEvent, from, to time.Time, sloTarget time.Duration) DomainStats {
+	stats := DomainStats{
+		Domain:      domain,
+		SLOTarget:   sloTarget,
+		PeriodStart: from,
+		PeriodEnd:   to,
+	}
+
+	var timestamps []int64
+	var totalLatency float64
+	for _, e := range events {
+		if e.Timestamp < from.Unix() || e.Timestamp >= to.Unix() {
+			continue
+		}
+		timestamps = append(timestamps, e.Timestamp)
+		totalLatency += e.LatencySeconds
+		if e.Probe != nil && e.Probe.Received > 0 {
+			stats.ProbedChanges++
+			stats.MeanProbeMs += e.Probe.MedianMs
+		}
+
+		latency := time.Duration(e.LatencySeconds * float64(time.Second))
+		if latency > stats.MaxLatency {
+			stats.MaxLatency = latency
+		}
+		if e.Verified {
+			stats.VerifiedChanges++
+			if latency <= sloTarget {
+				stats.WithinSLO++
+			}
+		}
+	}
+
+	stats.Changes = len(timestamps)
+	if days := to.Sub(from).Hours() / 24; days > 0 {
+		stats.ChangesPerDay = float64(stats.Changes) / days
+	}
+	if stats.Changes > 0 {
+		stats.MeanLatency = time.Duration(totalLatency / float64(stats.Changes) * float64(time.Second))
+	}
+	if len(timestamps) > 1 {
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+		span := timestamps[len(timestamps)-1] - timestamps[0]
+		stats.MeanTimeBetween = time.Duration(span/int64(len(timestamps)-1)) * time.Second
+	}
+
+	if stats.ProbedChanges > 0 {
+		stats.MeanProbeMs = math.Round(stats.MeanProbeMs/float64(stats.ProbedChanges)*10) / 10
+	}
+
+	stats.SLOAttainmentPct = 100
+	if stats.VerifiedChanges > 0 {
+		stats.SLOAttainmentPct = float64(stats.WithinSLO) / float64(stats.VerifiedChanges) * 100
+	}
+
+	return stats
+}
+
+// addressChangesByDomain groups A/AAAA changes by domain, returning the
+// created/updated contents that should become visible for each domain
+func addressChangesByDomain(changes []RecordChange) map[string]map[string][]string {
+	byDomain := make(map[string]map[string][]string) // domain -> record type -> contents
+	for _, c := range changes {
+		if c.Type != "A" && c.Type != "AAAA" {
+			continue
+		}
+		if byDomain[c.Name] == nil {
+			byDomain[c.Name] = make(map[string][]string)
+		}
+		if c.Action != "delete" {
+			byDomain[c.Name][c.Type] = append(byDomain[c.Name][c.Type], c.Content)
+		} else if _, ok := byDomain[c.Name][c.Type]; !ok {
+			byDomain[c.Name][c.Type] = nil
+		}
+	}
+	return byDomain
+}
+
+// recordRunStats appends this run's change events to the state and prunes old history.
+// When propagation verification is enabled, it waits for each changed domain to resolve
+// to its new addresses so the recorded latency covers detection -> verified propagation;
+// daemon and serve mode leave that to their verify job instead (see verifyQueue).
+// A latency probe (may be nil) is attached to the events of external address domains.
+func recordRunStats(config *Config, state *State, changes []RecordChange, detectedAt time.Time, probe *LatencyProbe) {
+	byDomain := addressChangesByDomain(changes)
+	external := externalAddressDomains(config)
+
+	// Resolvers may serve the old addresses until the previous TTL expires, so checking
+	// any earlier could only fail (or succeed by luck against an uncached resolver)
+	verifyNow := config.VerifyPropagation
+	if config.VerifyPropagation && len(byDomain) > 0 {
+		due := cacheExpiry(config.RecordTTL, latestChange(changes))
+		if config.verifications != nil {
+			config.verifications.add(config, byDomain, detectedAt, due)
+			verifyNow = false
+		} else if wait := time.Until(due); wait > 0 {
+			log.Printf("Waiting %s for cached records to expire before verifying propagation", wait.Round(time.Second))
+			time.Sleep(wait)
+		}
+	}
+
+	resolver := newResolver(config.VerifyResolver)
+	for domain, types := range byDomain {
+		event := ChangeEvent{Timestamp: detectedAt.Unix()}
+		if external[domain] {
+			event.Probe = probe
+		}
+
+		if verifyNow {
+			verified := true
+			for recordType, expected := range types {
+				if len(expected) == 0 {
+					continue
+				}
+				if !waitForPropagation(resolver, domain, recordType, expected,
+					time.Duration(config.VerifyTimeout)*time.Second, 5*time.Second) {
+					verified = false
+					log.Printf("Propagation of %s %s not confirmed within %ds", recordType, domain, config.VerifyTimeout)
+				} else if config.VerifyDNSSEC {
+					if err := verifyDNSSEC(config.VerifyResolver, domain, recordType, expected, 10*time.Second); err != nil {
+						verified = false
+						log.Printf("DNSSEC verification of %s %s failed: %v", recordType, domain, err)
+					}
+				}
+			}
+			event.Verified = verified
+		}
+
+		event.LatencySeconds = math.Round(time.Since(detectedAt).Seconds()*10) / 10
+		ds := state.domain(domain)
+		ds.Changes = append(ds.Changes, event)
+	}
+
+	// Prune history beyond the retention window
+	cutoff := time.Now().Add(-statsRetention).Unix()
+	for _, ds := range state.Domains {
+		kept := ds.Changes[:0]
+		for _, e := range ds.Changes {
+			if e.Timestamp >= cutoff {
+				kept = append(kept, e)
+			}
+		}
+		ds.Changes = kept
+	}
+}
+
+// monthBounds returns the start of the month containing t and the start of the next month
+func monthBounds(t time.Time) (time.Time, time.Time) {
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// formatStatsReport renders stats for all domains as a plain-text report
+func formatStatsReport(all []DomainStats) string {
+	var b strings.Builder
+	for _, s := range all {
+		fmt.Fprintf(&b, "%s\n", s.Domain)
+		fmt.Fprintf(&b, "  IP changes:                %d (%.2f/day)\n", s.Changes, s.ChangesPerDay)
+		if s.MeanTimeBetween > 0 {
+			fmt.Fprintf(&b, "  Mean time between changes: %s\n", s.MeanTimeBetween.Round(time.Minute))
+		}
+		if s.Changes > 0 {
+			fmt.Fprintf(&b, "  Update latency:            mean %s, max %s\n", s.MeanLatency.Round(time.Second), s.MaxLatency.Round(time.Second))
+		}
+		if s.VerifiedChanges > 0 {
+			fmt.Fprintf(&b, "  Propagation SLO (<= %s):   %.1f%% (%d/%d verified changes)\n",
+				s.SLOTarget, s.SLOAttainmentPct, s.WithinSLO, s.VerifiedChanges)
+		}
+		if s.ProbedChanges > 0 {
+			fmt.Fprintf(&b, "  Latency after changes:     mean %.1f ms (%d probed changes)\n", s.MeanProbeMs, s.ProbedChanges)
+		}
+	}
+	return b.String()
+}
+
+// domainStatsForPeriod computes stats for every domain in the state over [from, to)
+func domainStatsForPeriod(state *State, from, to time.Time, sloTarget time.Duration) []DomainStats {
+	domains := make([]string, 0, len(state.Domains))
+	for domain := range state.Domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	all := make([]DomainStats, 0, len(domains))
+	for _, domain := range domains {
+		all = append(all, computeDomainStats(domain, state.Domains[domain].Changes, from, to, sloTarget))
+	}
+	return all
+}
+
+// maybeSendMonthlyReport sends the previous month's SLO report once per month
+func maybeSendMonthlyReport(config *Config, state *State, notifier Notifier, now time.Time) {
+	currentStart, _ := monthBounds(now.UTC())
+	previousStart := currentStart.AddDate(0, -1, 0)
+	previousMonth := previousStart.Format("2006-01")
+
+	if state.LastReportMonth == "" {
+		// First run with stats enabled - nothing to report yet
+		state.LastReportMonth = previousMonth
+		return
+	}
+	if state.LastReportMonth >= previousMonth {
+		return
+	}
+
+	stats := domainStatsForPeriod(state, previousStart, currentStart, time.Duration(config.SLOTargetSeconds)*time.Second)
+	subject := fmt.Sprintf("Dynamic DNS report for %s", previousMonth)
+	message := formatStatsReport(stats)
+	if message == "" {
+		message = "No IP changes recorded.\n"
+	}
+
+	if err := notifier.Notify("slo-report", subject, message); err != nil {
+		log.Printf("Failed to send monthly report: %v", err)
+		return
+	}
+	state.LastReportMonth = previousMonth
+}
+
+// runStats implements the stats subcommand
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	days := fs.Int("days", 30, "Number of days to report on")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config := readConfig(false, false)
+	if config.StateFile == "" {
+		fmt.Fprintf(os.Stderr, "%sSTATE_FILE is not set - no statistics are being recorded\n", envPrefix)
+		return 1
+	}
+
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading state: %v\n", err)
+		return 1
+	}
+
+	now := time.Now()
+	stats := domainStatsForPeriod(state, now.AddDate(0, 0, -*days), now, time.Duration(config.SLOTargetSeconds)*time.Second)
+	fmt.Printf("Statistics for the last %d day(s):\n\n", *days)
+	fmt.Print(formatStatsReport(stats))
+	return 0
+}
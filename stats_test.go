@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestComputeDomainStats verifies change rate, mean time between changes and SLO attainment
+func TestComputeDomainStats(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 10)
+
+	events := []ChangeEvent{
+		{Timestamp: from.Add(-time.Hour).Unix(), LatencySeconds: 1000, Verified: true}, // outside period
+		{Timestamp: from.Add(24 * time.Hour).Unix(), LatencySeconds: 60, Verified: true},
+		{Timestamp: from.Add(72 * time.Hour).Unix(), LatencySeconds: 600, Verified: true},
+		{Timestamp: from.Add(120 * time.Hour).Unix(), LatencySeconds: 30, Verified: false},
+	}
+
+	stats := computeDomainStats("example.com", events, from, to, 5*time.Minute)
+
+	if stats.Changes != 3 {
+		t.Errorf("Expected 3 changes, got %d", stats.Changes)
+	}
+	if stats.ChangesPerDay != 0.3 {
+		t.Errorf("Expected 0.3 changes/day, got %v", stats.ChangesPerDay)
+	}
+	if stats.MeanTimeBetween != 48*time.Hour {
+		t.Errorf("Expected 48h between changes, got %s", stats.MeanTimeBetween)
+	}
+	if stats.MaxLatency != 10*time.Minute {
+		t.Errorf("Expected max latency 10m, got %s", stats.MaxLatency)
+	}
+	if stats.VerifiedChanges != 2 || stats.WithinSLO != 1 {
+		t.Errorf("Expected 1/2 verified changes within SLO, got %d/%d", stats.WithinSLO, stats.VerifiedChanges)
+	}
+	if stats.SLOAttainmentPct != 50 {
+		t.Errorf("Expected 50%% SLO attainment, got %v", stats.SLOAttainmentPct)
+	}
+}
+
+type recordingNotifier struct {
+	events []string
+}
+
+func (n *recordingNotifier) Notify(event, subject, message string) error {
+	n.events = append(n.events, event+": "+subject)
+	return nil
+}
+
+// TestMaybeSendMonthlyReport verifies the report is sent exactly once per month
+func TestMaybeSendMonthlyReport(t *testing.T) {
+	config := &Config{SLOTargetSeconds: 300}
+	state := newState()
+	notifier := &recordingNotifier{}
+
+	// First run only initialises the marker
+	maybeSendMonthlyReport(config, state, notifier, time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC))
+	if len(notifier.events) != 0 {
+		t.Fatalf("Expected no report on first run, got %v", notifier.events)
+	}
+
+	// Same month - nothing new
+	maybeSendMonthlyReport(config, state, notifier, time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC))
+	if len(notifier.events) != 0 {
+		t.Fatalf("Expected no report within the same month, got %v", notifier.events)
+	}
+
+	// New month - report for February
+	maybeSendMonthlyReport(config, state, notifier, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	maybeSendMonthlyReport(config, state, notifier, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC))
+	if len(notifier.events) != 1 || notifier.events[0] != "slo-report: Dynamic DNS report for 2024-02" {
+		t.Errorf("Expected one report for 2024-02, got %v", notifier.events)
+	}
+}
+
+// fakeUDPResolver answers A queries for nas.example.com with address over UDP, returning
+// the server address
+func fakeUDPResolver(t *testing.T, address []byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q, err := unpackDNSMessage(buf[:n])
+			if err != nil || len(q.Question) != 1 {
+				continue
+			}
+			resp := &dnsMessage{ID: q.ID, Flags: 0x8180, Question: q.Question}
+			if q.Question[0].Type == dnsTypeA && q.Question[0].Name == "nas.example.com" {
+				resp.Answer = []dnsRR{{Name: "nas.example.com", Type: dnsTypeA, Class: dnsClassIN, TTL: 60, Data: address}}
+			}
+			packed, _ := resp.pack()
+			conn.WriteTo(packed, from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// TestPropagationChecks verifies that daemon mode verifies propagation in the verify job,
+// once the cached records have expired, instead of the update waiting for it
+func TestPropagationChecks(t *testing.T) {
+	checks := newVerifyQueue(newScheduler())
+	config := &Config{
+		StateFile:         filepath.Join(t.TempDir(), "state.json"),
+		RecordTTL:         60,
+		VerifyPropagation: true,
+		VerifyResolver:    fakeUDPResolver(t, []byte{203, 0, 113, 1}),
+		VerifyTimeout:     60,
+		verifications:     checks,
+	}
+	record := func(changedAt time.Time) {
+		state, err := loadState(config.StateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes := []RecordChange{{Action: "update", Type: "A", Name: "nas.example.com", Content: "203.0.113.1", Timestamp: changedAt.Unix()}}
+		start := time.Now()
+		recordRunStats(config, state, changes, changedAt, nil)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("recording the run took %s", elapsed)
+		}
+		if err := state.save(config.StateFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event := func() ChangeEvent {
+		state, _ := loadState(config.StateFile)
+		changes := state.domain("nas.example.com").Changes
+		return changes[len(changes)-1]
+	}
+
+	// A change whose cached records have not expired yet waits for them
+	record(time.Now())
+	if delay := checks.run(); delay < 30*time.Second || delay > 2*time.Minute || len(checks.checks) != 1 {
+		t.Errorf("next lookup in %s with %d check(s), want after the TTL", delay, len(checks.checks))
+	}
+	if event().Verified {
+		t.Error("change verified before its cached records expired")
+	}
+
+	// Once they have, the lookup confirms the change
+	checks.checks = nil
+	record(time.Now().Add(-time.Hour))
+	if delay := checks.run(); delay != onDemand || len(checks.checks) != 0 {
+		t.Errorf("next lookup in %s with %d check(s), want none", delay, len(checks.checks))
+	}
+	if e := event(); !e.Verified || e.LatencySeconds < 3600 {
+		t.Errorf("event = %+v, want verified with the latency since detection", e)
+	}
+
+	// A change that does not propagate is given up at the deadline
+	config.VerifyResolver = fakeUDPResolver(t, []byte{203, 0, 113, 2})
+	record(time.Now().Add(-time.Hour))
+	if delay := checks.run(); delay != onDemand || len(checks.checks) != 0 {
+		t.Errorf("next lookup in %s with %d check(s) after the deadline", delay, len(checks.checks))
+	}
+	if event().Verified {
+		t.Error("stale address verified")
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The status page is a small static page (status.html and status.json) listing the public
+// endpoints of this host and when they last changed, written to a directory served by any
+// web server and/or uploaded to an S3 bucket after each update. Internal addresses are never
+// included. Unchanged pages are republished at most every STATUS_PAGE_MIN_INTERVAL_SECONDS.
+
+// StatusPage is the content of the status page (status.json)
+type StatusPage struct {
+	Host      string               `json:"host"`
+	Generated int64                `json:"generated"` // Unix time the page was generated
+	Endpoints []StatusPageEndpoint `json:"endpoints"`
+}
+
+// StatusPageEndpoint is one public domain on the status page
+type StatusPageEndpoint struct {
+	Domain     string   `json:"domain"`
+	Alias      string   `json:"alias,omitempty"` // CNAME target, for the top-level domain
+	Addresses  []string `json:"addresses"`
+	LastChange int64    `json:"last_change,omitempty"` // Unix time of the last address change (needs STATE_FILE)
+}
+
+// StatusPageState remembers the last published status page
+type StatusPageState struct {
+	Published   int64  `json:"published"`
+	Fingerprint string `json:"fingerprint"` // Hash of the content, ignoring the generation time
+}
+
+// buildStatusPage returns the status page for the published external addresses.
+// state may be nil, in which case last change times are unknown.
+func buildStatusPage(config *Config, ips *IPAddresses, state *State, now time.Time) StatusPage {
+	page := StatusPage{Host: statusPageHost(config), Generated: now.Unix(), Endpoints: []StatusPageEndpoint{}}
+	addresses := func(candidates ...string) []string {
+		var result []string
+		for _, a := range candidates {
+			if a != "" {
+				result = append(result, a)
+			}
+		}
+		return result
+	}
+	public := addresses(ips.ExternalIPv4, ips.ExternalIPv6)
+
+	add := func(domain, alias string, addresses []string) {
+		if domain == "" {
+			return
+		}
+		endpoint := StatusPageEndpoint{Domain: domain, Alias: alias, Addresses: append([]string{}, addresses...)}
+		lastChangeDomain := domain
+		if alias != "" {
+			lastChangeDomain = alias
+		}
+		if state != nil {
+			if ds, ok := state.Domains[lastChangeDomain]; ok && len(ds.Changes) > 0 {
+				endpoint.LastChange = ds.Changes[len(ds.Changes)-1].Timestamp
+			}
+		}
+		page.Endpoints = append(page.Endpoints, endpoint)
+	}
+	add(config.ExternalDomain, "", addresses(ips.ExternalIPv4))
+	add(config.IPv6Domain, "", addresses(ips.ExternalIPv6))
+	add(config.CombinedDomain, "", public)
+	if config.CombinedDomain != "" {
+		add(config.TopLevelDomain, config.CombinedDomain, public)
+	}
+	return page
+}
+
+// statusPageHost names the host on the status page: its heartbeat domain, else the hostname
+func statusPageHost(config *Config) string {
+	if domain := hostHeartbeatDomain(config); domain != "" {
+		return domain
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// fingerprint hashes the page content, ignoring when it was generated
+func (p StatusPage) fingerprint() string {
+	p.Generated = 0
+	data, _ := json.Marshal(p)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"time": func(unix int64) string { return time.Unix(unix, 0).UTC().Format("2006-01-02 15:04 UTC") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Host}} status</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 50em; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.4em; text-align: left; vertical-align: top; }
+.none { color: #a00; }
+</style>
+</head>
+<body>
+<h1>{{.Host}}</h1>
+<table>
+<tr><th>Domain</th><th>Addresses</th><th>Last change</th></tr>
+{{range .Endpoints}}<tr><td>{{.Domain}}{{if .Alias}} &rarr; {{.Alias}}{{end}}</td><td>{{range $i, $a := .Addresses}}{{if $i}}<br>{{end}}{{$a}}{{else}}<span class="none">none</span>{{end}}</td><td>{{if .LastChange}}{{time .LastChange}}{{else}}-{{end}}</td></tr>
+{{end}}</table>
+<p>Updated {{time .Generated}}</p>
+</body>
+</html>
+`))
+
+// renderStatusPage returns the files of the status page
+func renderStatusPage(page StatusPage) (map[string][]byte, error) {
+	data, err := json.MarshalIndent(page, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var html bytes.Buffer
+	if err := statusPageTemplate.Execute(&html, page); err != nil {
+		return nil, err
+	}
+	return map[string][]byte{"status.json": append(data, '\n'), "status.html": html.Bytes()}, nil
+}
+
+// maybePublishStatusPage publishes the status page if it changed or the last publication is
+// older than the minimum interval. state may be nil (every run then publishes).
+func maybePublishStatusPage(config *Config, ips *IPAddresses, state *State, now time.Time) {
+	if config.StatusPageDir == "" && config.StatusPageS3URL == "" {
+		return
+	}
+	page := buildStatusPage(config, ips, state, now)
+	fingerprint := page.fingerprint()
+	if state != nil && state.StatusPage != nil && state.StatusPage.Fingerprint == fingerprint &&
+		now.Sub(time.Unix(state.StatusPage.Published, 0)) < time.Duration(config.StatusPageMinInterval)*time.Second {
+		return
+	}
+
+	files, err := renderStatusPage(page)
+	if err != nil {
+		log.Printf("WARNING: Could not render status page: %v", err)
+		return
+	}
+	if err := publishStatusPage(config, files); err != nil {
+		log.Printf("WARNING: Could not publish status page: %v", err)
+		return
+	}
+	log.Println("Published status page")
+	if state != nil {
+		state.StatusPage = &StatusPageState{Published: now.Unix(), Fingerprint: fingerprint}
+	}
+}
+
+// publishStatusPage writes the files to the directory and/or uploads them to S3
+func publishStatusPage(config *Config, files map[string][]byte) error {
+	if config.StatusPageDir != "" {
+		for name, data := range files {
+			if err := writeFileAtomic(filepath.Join(config.StatusPageDir, name), data); err != nil {
+				return err
+			}
+		}
+	}
+	if config.StatusPageS3URL != "" {
+		uploader, err := newS3Uploader(config)
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"status.json", "status.html"} {
+			if err := uploader.put(name, files[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file readable by a web server via a temporary file and rename
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".status-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// s3Uploader PUTs objects below a prefix of an S3 bucket
+type s3Uploader struct {
+	Bucket   string
+	Prefix   string
+	Region   string
+	Endpoint string // Path-style endpoint of S3-compatible storage; empty uses AWS
+
+	credentials *awsCredentialChain
+	client      *http.Client
+}
+
+// newS3Uploader creates the uploader for STATUS_PAGE_S3_URL (s3://bucket/prefix)
+func newS3Uploader(config *Config) (*s3Uploader, error) {
+	u, err := url.Parse(config.StatusPageS3URL)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("%sSTATUS_PAGE_S3_URL must look like s3://bucket/prefix, got %q", envPrefix, config.StatusPageS3URL)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &s3Uploader{
+		Bucket:      u.Host,
+		Prefix:      prefix,
+		Region:      config.StatusPageS3Region,
+		Endpoint:    strings.TrimSuffix(config.StatusPageS3Endpoint, "/"),
+		credentials: newAWSCredentialChain(),
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// put uploads one object
+func (s *s3Uploader) put(name string, data []byte) error {
+	key := s.Prefix + name
+	u := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Region, awsURIEncode(key, false))
+	if s.Endpoint != "" {
+		u = fmt.Sprintf("%s/%s/%s", s.Endpoint, s.Bucket, awsURIEncode(key, false))
+	}
+	req, err := http.NewRequest("PUT", u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	contentType := "application/json"
+	if strings.HasSuffix(name, ".html") {
+		contentType = "text/html; charset=utf-8"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cache-Control", "max-age=60")
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(data))
+
+	creds, err := s.credentials.get()
+	if err != nil {
+		return err
+	}
+	signAWSRequestV4(req, data, creds, s.Region, "s3", time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("uploading s3://%s/%s: status %d: %s", s.Bucket, key, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
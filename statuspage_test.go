@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildStatusPage(t *testing.T) {
+	config := &Config{
+		InternalDomain: "nas.i.example.com",
+		ExternalDomain: "nas.e.example.com",
+		IPv6Domain:     "nas.6.example.com",
+		CombinedDomain: "nas.example.com",
+		TopLevelDomain: "nas.example.org",
+	}
+	ips := &IPAddresses{InternalIPv4: []string{"192.168.1.10"}, ExternalIPv4: "203.0.113.1"}
+	state := newState()
+	state.domain("nas.example.com").Changes = []ChangeEvent{{Timestamp: 1700000000}, {Timestamp: 1700050000}}
+
+	page := buildStatusPage(config, ips, state, time.Unix(1700100000, 0))
+	if page.Host != "nas.example.org" || page.Generated != 1700100000 {
+		t.Errorf("host %q, generated %d", page.Host, page.Generated)
+	}
+	want := []StatusPageEndpoint{
+		{Domain: "nas.e.example.com", Addresses: []string{"203.0.113.1"}},
+		{Domain: "nas.6.example.com", Addresses: []string{}},
+		{Domain: "nas.example.com", Addresses: []string{"203.0.113.1"}, LastChange: 1700050000},
+		{Domain: "nas.example.org", Alias: "nas.example.com", Addresses: []string{"203.0.113.1"}, LastChange: 1700050000},
+	}
+	if !reflect.DeepEqual(page.Endpoints, want) {
+		t.Errorf("endpoints = %+v\nwant %+v", page.Endpoints, want)
+	}
+
+	files, err := renderStatusPage(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(files["status.html"])
+	for _, s := range []string{"<h1>nas.example.org</h1>", "nas.example.org &rarr; nas.example.com", "2023-11-15 12:06 UTC", `<span class="none">none</span>`} {
+		if !strings.Contains(html, s) {
+			t.Errorf("status.html lacks %q:\n%s", s, html)
+		}
+	}
+	if strings.Contains(html, "192.168.1.10") || strings.Contains(string(files["status.json"]), "192.168.1.10") {
+		t.Error("internal address published on the status page")
+	}
+}
+
+func TestMaybePublishStatusPageRateLimit(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{ExternalDomain: "home.example.com", StatusPageDir: dir, StatusPageMinInterval: 300}
+	state := newState()
+	ips := &IPAddresses{ExternalIPv4: "203.0.113.1"}
+	start := time.Unix(1700000000, 0)
+
+	generated := func() int64 {
+		data, err := os.ReadFile(filepath.Join(dir, "status.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page StatusPage
+		if err := json.Unmarshal(data, &page); err != nil {
+			t.Fatal(err)
+		}
+		return page.Generated
+	}
+
+	maybePublishStatusPage(config, ips, state, start)
+	if generated() != start.Unix() {
+		t.Fatal("status page not published")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "status.html")); err != nil {
+		t.Errorf("status.html not written: %v", err)
+	}
+
+	// Unchanged pages wait for the interval, changed ones are published right away
+	maybePublishStatusPage(config, ips, state, start.Add(time.Minute))
+	if generated() != start.Unix() {
+		t.Error("unchanged page republished within the interval")
+	}
+	ips.ExternalIPv4 = "203.0.113.2"
+	maybePublishStatusPage(config, ips, state, start.Add(2*time.Minute))
+	if generated() != start.Add(2*time.Minute).Unix() {
+		t.Error("changed page not published")
+	}
+	maybePublishStatusPage(config, ips, state, start.Add(8*time.Minute))
+	if generated() != start.Add(8*time.Minute).Unix() {
+		t.Error("unchanged page not republished after the interval")
+	}
+}
+
+func TestS3UploaderPut(t *testing.T) {
+	var got struct {
+		path, contentType, auth string
+		body                    string
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got.path, got.contentType, got.auth, got.body = r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Authorization"), string(body)
+		if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	u, err := newS3Uploader(&Config{StatusPageS3URL: "s3://status-bucket/hosts/nas", StatusPageS3Region: "eu-west-1", StatusPageS3Endpoint: server.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.credentials = &awsCredentialChain{sources: []awsCredentialSource{
+		func(*http.Client) (*awsCredentials, error) {
+			return &awsCredentials{AccessKeyID: "AKIDTEST", SecretAccessKey: "secret", Source: "test"}, nil
+		},
+	}}
+
+	if err := u.put("status.html", []byte("<html>")); err != nil {
+		t.Fatal(err)
+	}
+	if got.path != "/status-bucket/hosts/nas/status.html" || got.contentType != "text/html; charset=utf-8" || got.body != "<html>" {
+		t.Errorf("request = %+v", got)
+	}
+	if !strings.Contains(got.auth, "/eu-west-1/s3/aws4_request") {
+		t.Errorf("Authorization = %q", got.auth)
+	}
+
+	if _, err := newS3Uploader(&Config{StatusPageS3URL: "https://status-bucket"}); err == nil {
+		t.Error("non-s3 URL accepted")
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Support bundles. `dynipupdate support-bundle` collects what triage of an environment-specific
+// issue needs into one tarball to attach to a bug report:
+//
+//   - version.txt: build, Go version and platform
+//   - config.txt: the effective configuration and the settings given, secrets masked
+//   - doctor.txt: configuration, provider, state and interface checks
+//   - interfaces.txt: the interface scan, with the state of each address
+//   - state.json and history.log: the state file and the end of the change journal
+//   - logs.txt: the end of -log-file, or of the systemd journal of -unit
+//
+// Secrets (tokens, passwords, keys) are masked in the configuration and replaced wherever
+// they appear in the other files. Nothing is sent anywhere: the bundle is written to -o.
+
+// Lines of the change journal and of the logs put in a bundle
+const supportBundleTailLines = 1000
+
+// secretSettingSuffixes mark settings holding secrets, in the support bundle and the reload
+// diff; the chat webhook and ntfy topic URLs embed theirs, and a Pushover user key is a
+// credential. Other URLs may carry a user and password (e.g. MQTT_URL), masked on their own.
+var secretSettingSuffixes = []string{"TOKEN", "TOKENS", "PASSWORD", "SECRET", "KEY", "SIGNING_KEY", "DISCORD_URL", "SLACK_URL", "NTFY_URL", "PUSHOVER_USER"}
+
+// supportBundle holds the files of a bundle and the secrets to remove from them
+type supportBundle struct {
+	files   []bundleFile
+	secrets []string
+}
+
+// bundleFile is one file of a bundle
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// add adds a file, replacing every known secret in it
+func (b *supportBundle) add(name string, data []byte) {
+	for _, secret := range b.secrets {
+		data = bytes.ReplaceAll(data, []byte(secret), []byte("(redacted)"))
+	}
+	b.files = append(b.files, bundleFile{name: name, data: data})
+}
+
+// addSecret registers a value to redact. Short values are skipped, as replacing them would
+// mangle unrelated text.
+func (b *supportBundle) addSecret(value string) {
+	if value = strings.TrimSpace(value); len(value) >= 6 {
+		b.secrets = append(b.secrets, value)
+	}
+}
+
+// write writes the bundle as a gzipped tarball, with its files in the directory dir
+func (b *supportBundle) write(path, dir string, now time.Time) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, file := range b.files {
+		header := &tar.Header{Name: dir + "/" + file.name, Mode: 0o600, Size: int64(len(file.data)), ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// isSecretSetting reports whether a setting (KEY_NAME or FieldName) holds a secret
+func isSecretSetting(name string) bool {
+	name = strings.ReplaceAll(strings.ToUpper(name), "_", "")
+	for _, suffix := range secretSettingSuffixes {
+		if strings.HasSuffix(name, strings.ReplaceAll(suffix, "_", "")) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlUserinfo returns the user:password of a URL value ("" if it has none)
+func urlUserinfo(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil || u.Host == "" {
+		return ""
+	}
+	return u.User.String()
+}
+
+// maskURLUserinfo returns a URL value with its user:password masked
+func maskURLUserinfo(value string) string {
+	if userinfo := urlUserinfo(value); userinfo != "" {
+		return strings.Replace(value, userinfo+"@", "(secret)@", 1)
+	}
+	return value
+}
+
+// runSupportBundle implements the support-bundle subcommand
+func runSupportBundle(args []string) int {
+	fs := flag.NewFlagSet("support-bundle", flag.ContinueOnError)
+	now := time.Now()
+	dir := "dynipupdate-support-" + now.UTC().Format("20060102-150405")
+	output := fs.String("o", dir+".tar.gz", "Write the bundle to this file")
+	logFile := fs.String("log-file", "", "Include the end of this log file")
+	unit := fs.String("unit", "dynipupdate", "Include the end of the systemd journal of this unit (without -log-file)")
+	offline := fs.Bool("offline", false, "Do not contact the DNS provider")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	bundle := collectSupportBundle(*logFile, *unit, *offline, now)
+	if err := bundle.write(*output, dir, now); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the support bundle: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Support bundle written to %s - review it before attaching it to a bug report\n", *output)
+	return 0
+}
+
+// collectSupportBundle gathers the files of a bundle
+func collectSupportBundle(logFile, unit string, offline bool, now time.Time) *supportBundle {
+	bundle := &supportBundle{}
+	var doctor bytes.Buffer
+	check := func(name string, err error, detail string) {
+		switch {
+		case err != nil:
+			fmt.Fprintf(&doctor, "[FAIL] %s: %v\n", name, err)
+		case detail != "":
+			fmt.Fprintf(&doctor, "[ OK ] %s: %s\n", name, detail)
+		default:
+			fmt.Fprintf(&doctor, "[ OK ] %s\n", name)
+		}
+	}
+
+	config, configErr := reloadConfig(false)
+	settings := supportBundleSettings(bundle)
+	if config != nil {
+		for _, secret := range configSecrets(config) {
+			bundle.addSecret(secret)
+		}
+	}
+
+	bundle.add("version.txt", []byte(supportBundleVersion(now)))
+	check("Configuration", configErr, "")
+
+	var configText bytes.Buffer
+	fmt.Fprintf(&configText, "# Settings given (environment variables and config file)\n%s\n", settings)
+	if config != nil {
+		fmt.Fprintf(&configText, "# Effective configuration\n%s", formatEffectiveConfig(config))
+	}
+	bundle.add("config.txt", configText.Bytes())
+
+	switch {
+	case config == nil:
+		config = &Config{}
+	case offline:
+		// Creating the client already reads zone details from the API
+		check("Provider "+config.Provider, nil, "not contacted (-offline)")
+	default:
+		cf, err := newProviderClient(config)
+		switch {
+		case err != nil:
+			check("Provider "+config.Provider, err, "")
+		case cf.probeConnectivity():
+			check("Provider "+config.Provider, nil, "API reachable")
+		default:
+			check("Provider "+config.Provider, fmt.Errorf("API not reachable"), "")
+		}
+	}
+
+	snapshot := config.interfaceSnapshot()
+	var interfaces bytes.Buffer
+	for _, a := range snapshot.Addrs {
+		fmt.Fprintf(&interfaces, "%-12s %-40s %s\n", a.Interface, a.Addr, a.State)
+	}
+	bundle.add("interfaces.txt", interfaces.Bytes())
+	if internal := getInternalIPv4(publishableAddrs(snapshot.Addrs, config)); len(internal) > 0 {
+		check("Interfaces", nil, fmt.Sprintf("%d address(es), internal IPv4: %s", len(snapshot.Addrs), strings.Join(internal, ", ")))
+	} else {
+		fmt.Fprintf(&doctor, "[WARN] Interfaces: %d address(es), no internal IPv4\n", len(snapshot.Addrs))
+	}
+
+	if config.StateFile != "" {
+		state, err := loadState(config.StateFile)
+		check("State file "+config.StateFile, err, "")
+		if err == nil {
+			if data, err := json.MarshalIndent(state, "", "  "); err == nil {
+				bundle.add("state.json", data)
+			}
+			if state.ActiveRun != nil {
+				fmt.Fprintf(&doctor, "[WARN] A run started at %s did not finish\n", time.Unix(state.ActiveRun.Started, 0).Format(time.RFC3339))
+			}
+			if len(state.PendingMutations) > 0 {
+				fmt.Fprintf(&doctor, "[WARN] %d change(s) queued while the provider was unreachable\n", len(state.PendingMutations))
+			}
+		}
+	}
+	if config.HistoryFile != "" {
+		history, err := tailFile(config.HistoryFile, supportBundleTailLines)
+		check("Change journal "+config.HistoryFile, err, "")
+		if err == nil {
+			bundle.add("history.log", history)
+		}
+	}
+
+	logs, err := supportBundleLogs(logFile, unit)
+	check("Logs", err, "")
+	if err == nil {
+		bundle.add("logs.txt", logs)
+	}
+
+	bundle.add("doctor.txt", doctor.Bytes())
+	return bundle
+}
+
+// supportBundleVersion describes the build
+func supportBundleVersion(now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Collected: %s\n", now.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&b, "Module: %s %s\n", info.Main.Path, info.Main.Version)
+		for _, setting := range info.Settings {
+			if strings.HasPrefix(setting.Key, "vcs.") {
+				fmt.Fprintf(&b, "%s: %s\n", setting.Key, setting.Value)
+			}
+		}
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		fmt.Fprintf(&b, "Hostname: %s\n", hostname)
+	}
+	return b.String()
+}
+
+// supportBundleSettings lists the settings given in the environment and the config file,
+// masking secrets and registering them for redaction
+func supportBundleSettings(bundle *supportBundle) string {
+	values := make(map[string]string)
+	for key, value := range configFileValues {
+		values[key] = maskURLUserinfo(value) + " (config file)"
+		if isSecretSetting(key) {
+			bundle.addSecret(value)
+		}
+		for _, secret := range urlSecrets(value) {
+			bundle.addSecret(secret)
+		}
+	}
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, envPrefix) {
+			continue
+		}
+		values[strings.TrimPrefix(key, envPrefix)] = maskURLUserinfo(value)
+		if isSecretSetting(key) {
+			bundle.addSecret(value)
+		}
+		for _, secret := range urlSecrets(value) {
+			bundle.addSecret(secret)
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		value := values[key]
+		if isSecretSetting(key) {
+			value = "(secret)"
+		}
+		fmt.Fprintf(&b, "%s%s=%s\n", envPrefix, key, value)
+	}
+	return b.String()
+}
+
+// urlSecrets returns the user:password of a URL value and the password on its own (none
+// without userinfo)
+func urlSecrets(value string) []string {
+	userinfo := urlUserinfo(value)
+	if userinfo == "" {
+		return nil
+	}
+	if _, password, ok := strings.Cut(userinfo, ":"); ok {
+		return []string{userinfo, password}
+	}
+	return []string{userinfo}
+}
+
+// configSecrets returns the secret values of the configuration, including the userinfo
+// of URL settings
+func configSecrets(config *Config) []string {
+	var secrets []string
+	value := reflect.ValueOf(*config)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		var values []string
+		switch v := value.Field(i).Interface().(type) {
+		case string:
+			values = []string{v}
+		case []string:
+			values = v
+		}
+		if isSecretSetting(field.Name) {
+			secrets = append(secrets, values...)
+			continue
+		}
+		for _, v := range values {
+			secrets = append(secrets, urlSecrets(v)...)
+		}
+	}
+	return secrets
+}
+
+// formatEffectiveConfig lists the settings of the configuration, secrets masked
+func formatEffectiveConfig(config *Config) string {
+	var b strings.Builder
+	value := reflect.ValueOf(*config)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.IsExported() {
+			fmt.Fprintf(&b, "%s: %s\n", field.Name, formatConfigValue(field.Name, value.Field(i).Interface()))
+		}
+	}
+	return b.String()
+}
+
+// supportBundleLogs returns the end of the log file, or of the unit's systemd journal
+func supportBundleLogs(logFile, unit string) ([]byte, error) {
+	if logFile != "" {
+		return tailFile(logFile, supportBundleTailLines)
+	}
+	if _, err := exec.LookPath("journalctl"); err != nil {
+		return nil, fmt.Errorf("no -log-file given and no systemd journal")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "journalctl", "-u", unit, "--no-pager", "-o", "short-iso", "-n", fmt.Sprint(supportBundleTailLines)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("journalctl -u %s: %w", unit, err)
+	}
+	return output, nil
+}
+
+// tailFile returns the last n lines of a file
+func tailFile(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := make([]string, 0, n)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if len(lines) == n {
+			lines = lines[1:]
+		}
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
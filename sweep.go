@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The sweep ("dynipupdate sweep") is a cleanup service for accounts with hosts in many zones:
+// instead of one cleanup deployment per zone and environment, it lists every zone the token
+// can see and reaps the names whose heartbeat is stale. It has no configured domains to go
+// by, so ownership comes from the records themselves: only names whose heartbeat carries
+// the managed-by:dynipupdate tag are considered, which requires the hosts to publish with
+// RECORD_TAGS=true. Heartbeats without the tag are left alone, whatever their age.
+
+// sweepZonesPerPage is the page size of zone listings
+const sweepZonesPerPage = 50
+
+// CFZoneListResponse is one page of a zone listing
+type CFZoneListResponse struct {
+	Success    bool              `json:"success"`
+	Errors     []json.RawMessage `json:"errors"`
+	Result     []CFZone          `json:"result"`
+	ResultInfo CFResultInfo      `json:"result_info"`
+}
+
+// listZones lists the zones visible to the token
+func (cf *CloudFlareClient) listZones() ([]CFZone, error) {
+	var zones []CFZone
+	for page := 1; page <= cfMaxListPages; page++ {
+		resp, err := cf.makeRequest("GET", fmt.Sprintf("/zones?page=%d&per_page=%d", page, sweepZonesPerPage), nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errProviderUnreachable, err)
+		}
+		var result CFZoneListResponse
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		switch {
+		case err != nil:
+			return nil, statusError(resp.StatusCode, fmt.Sprintf("decoding zone list: %v", err))
+		case !result.Success:
+			return nil, cfResponseError(resp.StatusCode, result.Errors)
+		}
+		zones = append(zones, result.Result...)
+		if page >= result.ResultInfo.TotalPages || len(result.Result) == 0 {
+			return zones, nil
+		}
+	}
+	return nil, fmt.Errorf("zone list has more than %d pages", cfMaxListPages)
+}
+
+// forZone returns a copy of the client working on another zone, sharing its hooks
+func (cf *CloudFlareClient) forZone(zoneID string) *CloudFlareClient {
+	zoneClient := *cf
+	zoneClient.ZoneID, zoneClient.Zones, zoneClient.discovery = zoneID, nil, nil
+	return &zoneClient
+}
+
+// taggedHeartbeatNames returns the names of the zone whose heartbeat carries the ownership tag
+func taggedHeartbeatNames(cf providerClient) (map[string]bool, error) {
+	txtRecords, err := cf.getAllRecordsByType("TXT")
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool)
+	for _, record := range txtRecords {
+		if isHeartbeat(record.Content) && slices.Contains(record.Tags, managedByTag) {
+			owned[normalizeName(record.Name)] = true
+		}
+	}
+	return owned, nil
+}
+
+// sweepableZone reports why a zone is skipped by the sweep ("" to sweep it)
+func sweepableZone(zone CFZone, only []string) string {
+	switch {
+	case len(only) > 0 && !slices.Contains(only, normalizeName(zone.Name)):
+		return "not selected with -zones"
+	case zone.Type == zoneTypeSecondary:
+		return "secondary zone, records cannot be changed"
+	case zone.Status != "" && zone.Status != "active":
+		return "zone is " + zone.Status
+	}
+	return ""
+}
+
+// sweepZones plans the cleanup of every selected zone and, with execute, deletes the stale
+// records. It returns the planned deletions and whether any zone could not be swept.
+func sweepZones(cf *CloudFlareClient, config *Config, only []string, execute bool) ([]cleanupDeletion, bool) {
+	zones, err := cf.listZones()
+	if err != nil {
+		log.Printf("ERROR: Could not list zones: %v", err)
+		return nil, true
+	}
+	log.Printf("Sweep: %d zone(s) visible to the token", len(zones))
+
+	var all []cleanupDeletion
+	failed := false
+	for _, zone := range zones {
+		if reason := sweepableZone(zone, only); reason != "" {
+			log.Printf("Sweep: skipping %s (%s)", zone.Name, reason)
+			continue
+		}
+		zoneCF := cf.forZone(zone.ID)
+		owned, err := taggedHeartbeatNames(zoneCF)
+		if err != nil {
+			log.Printf("ERROR: Sweep of %s failed: %v", zone.Name, err)
+			failed = true
+			continue
+		}
+		if len(owned) == 0 {
+			log.Printf("Sweep: no tagged heartbeats in %s", zone.Name)
+			continue
+		}
+		deletions, staleCount, err := planCleanup(zoneCF, config, func(name string) bool { return owned[name] })
+		if err != nil {
+			log.Printf("ERROR: Sweep of %s failed: %v", zone.Name, err)
+			failed = true
+			continue
+		}
+		log.Printf("Sweep: %s has %d tagged name(s), %d stale", zone.Name, len(owned), staleCount)
+		if execute && len(deletions) > 0 {
+			deleted := len(executeCleanup(zoneCF, deletions))
+			log.Printf("Sweep: deleted %d of %d record(s) in %s", deleted, len(deletions), zone.Name)
+			failed = failed || deleted < len(deletions)
+		}
+		all = append(all, deletions...)
+	}
+	return all, failed
+}
+
+// runSweep implements "dynipupdate sweep"
+func runSweep(args []string) int {
+	fs := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	check := fs.Bool("dry-run", false, "Show what the sweep would delete without deleting anything (exit 0 = nothing stale, 2 = stale records, 1 = failed)")
+	fs.BoolVar(check, "check", false, "Alias for -dry-run")
+	ci := fs.Bool("ci", false, "With -dry-run, print one JSON result on stdout")
+	daemon := fs.Bool("daemon", false, "Keep running and sweep every "+envPrefix+"CLEANUP_INTERVAL_SECONDS")
+	zones := fs.String("zones", "", "Only sweep these zones (comma-separated zone names)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config := readConfig(false, false)
+	setupLogging(config.LogFormat, config.LogLevel, config.Provider)
+	switch {
+	case config.Provider != providerCloudFlare:
+		fmt.Fprintln(os.Stderr, "ERROR: the sweep lists CloudFlare zones - it only works with the cloudflare provider")
+		return 2
+	case config.CFAPIToken == "" && config.vault == nil:
+		fmt.Fprintf(os.Stderr, "ERROR: %sCF_API_TOKEN is required\n", envPrefix)
+		return 2
+	case *check && *daemon:
+		fmt.Fprintln(os.Stderr, "ERROR: -dry-run cannot be combined with -daemon")
+		return 2
+	}
+	var only []string
+	for _, zone := range strings.Split(*zones, ",") {
+		if zone = normalizeName(strings.TrimSpace(zone)); zone != "" {
+			only = append(only, zone)
+		}
+	}
+
+	cf := &CloudFlareClient{
+		APIToken: config.CFAPIToken,
+		vault:    config.vault,
+		BaseURL:  "https://api.cloudflare.com/client/v4",
+		Limiter:  newRateLimiter(config.APIRateLimit),
+		Retry:    newRetryPolicy(config),
+		Faults:   config.FaultInjectionPercent,
+
+		VerifyDeletes: newDeleteVerifier(config),
+	}
+	cf.client = cf.transportStack().client()
+
+	if *check {
+		deletions, failed := sweepZones(cf, config, only, false)
+		result := &runResult{Mode: "sweep-check", Failed: failed, Changes: []resultChange{}}
+		for _, d := range deletions {
+			result.Changes = append(result.Changes, resultChange{Action: "delete", Type: d.Record.Type, Name: d.Record.Name, Content: d.Record.Content, Reason: d.Reason})
+		}
+		result.Changed = len(result.Changes) > 0
+		result.write(os.Stdout, *ci)
+		return result.exitCode()
+	}
+
+	if *daemon {
+		s := newScheduler()
+		s.add("sweep", func() time.Duration {
+			sweepZones(cf, config, only, true)
+			return time.Duration(config.CleanupInterval) * time.Second
+		})
+		log.Printf("Sweep running every %ds (stale after %ds)", config.CleanupInterval, config.StaleThreshold)
+		s.loop(shutdownSignal())
+		return 0
+	}
+
+	if _, failed := sweepZones(cf, config, only, true); failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSweepTestAccount returns two fake zones served by one API, the first also listing a
+// secondary zone, and a client with no zone of its own
+func newSweepTestAccount(t *testing.T) (*fakeCloudFlare, *fakeCloudFlare, *CloudFlareClient) {
+	t.Helper()
+	first, cf := newFakeCloudFlare(t)
+	second, _ := newFakeCloudFlare(t)
+	zones := []CFZone{
+		{ID: "zone", Name: "example.com", Type: zoneTypeFull, Status: "active"},
+		{ID: "zone2", Name: "example.net", Type: zoneTypeFull, Status: "active"},
+		{ID: "zone3", Name: "example.org", Type: zoneTypeSecondary, Status: "active"},
+	}
+	handler := first.server.Config.Handler
+	first.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/zones":
+			// One zone per page, to cover paging
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			page = max(page, 1)
+			json.NewEncoder(w).Encode(CFZoneListResponse{Success: true, Result: zones[page-1 : page],
+				ResultInfo: CFResultInfo{Page: page, PerPage: 1, Count: 1, TotalCount: len(zones), TotalPages: len(zones)}})
+		case strings.HasPrefix(r.URL.Path, "/zones/zone2"):
+			r.URL.Path = "/zones/zone" + strings.TrimPrefix(r.URL.Path, "/zones/zone2")
+			second.handle(w, r)
+		case strings.HasPrefix(r.URL.Path, "/zones/zone3"):
+			t.Errorf("secondary zone accessed: %s %s", r.Method, r.URL.Path)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	})
+	cf.ZoneID = ""
+	return first, second, cf
+}
+
+// addTagged inserts a record carrying the given tags
+func (f *fakeCloudFlare) addTagged(recordType, name, content string, tags ...string) {
+	id := f.add(recordType, name, content)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	record := f.records[id]
+	record.Tags = tags
+	f.records[id] = record
+}
+
+func TestSweepReapsTaggedStaleNames(t *testing.T) {
+	first, second, cf := newSweepTestAccount(t)
+	config := &Config{StaleThreshold: 3600, clock: newFakeClock(time.Unix(1700000000, 0))}
+	stale, fresh := `"1699990000"`, `"1699999000"`
+
+	first.add("A", "nas.example.com", "192.168.1.10")
+	first.addTagged("TXT", "nas.example.com", stale, "host:nas", managedByTag)
+	first.add("A", "web.example.com", "192.168.1.20")
+	first.add("TXT", "web.example.com", stale) // Not tagged: not ours
+	second.add("AAAA", "pi.example.net", "2001:db8::1")
+	second.addTagged("TXT", "pi.example.net", stale, managedByTag)
+	second.add("A", "box.example.net", "10.0.0.1")
+	second.addTagged("TXT", "box.example.net", fresh, managedByTag)
+
+	// A dry run plans without deleting
+	deletions, failed := sweepZones(cf, config, nil, false)
+	var planned []string
+	for _, d := range deletions {
+		planned = append(planned, d.Record.Type+" "+d.Domain)
+	}
+	want := []string{"A nas.example.com", "TXT nas.example.com", "AAAA pi.example.net", "TXT pi.example.net"}
+	if failed || !slices.Equal(planned, want) {
+		t.Errorf("planned %v (failed: %v), want %v", planned, failed, want)
+	}
+	if got := first.contents("nas.example.com", "A"); len(got) != 1 {
+		t.Errorf("dry run deleted records: %v", got)
+	}
+
+	if _, failed := sweepZones(cf, config, nil, true); failed {
+		t.Error("sweep failed")
+	}
+	for _, name := range []string{"nas.example.com", "pi.example.net"} {
+		fake := first
+		if strings.HasSuffix(name, ".net") {
+			fake = second
+		}
+		if got := append(fake.contents(name, "A"), append(fake.contents(name, "AAAA"), fake.contents(name, "TXT")...)...); len(got) != 0 {
+			t.Errorf("%s still has %v", name, got)
+		}
+	}
+	if len(first.contents("web.example.com", "A")) != 1 || len(second.contents("box.example.net", "A")) != 1 {
+		t.Error("untagged or fresh names were swept")
+	}
+}
+
+func TestSweepZoneFilter(t *testing.T) {
+	first, second, cf := newSweepTestAccount(t)
+	config := &Config{StaleThreshold: 3600, clock: newFakeClock(time.Unix(1700000000, 0))}
+	first.addTagged("TXT", "nas.example.com", `"1699990000"`, managedByTag)
+	second.addTagged("TXT", "pi.example.net", `"1699990000"`, managedByTag)
+
+	sweepZones(cf, config, []string{"example.net"}, true)
+	if len(first.contents("nas.example.com", "TXT")) != 1 || len(second.contents("pi.example.net", "TXT")) != 0 {
+		t.Error("-zones did not restrict the sweep to example.net")
+	}
+}
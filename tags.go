@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// managedByTag marks records published by dynipupdate
+const managedByTag = "managed-by:dynipupdate"
+
+// parseRecordTags builds the CloudFlare record tags attached to every managed record.
+// Tags are opt-in (RECORD_TAGS=true) because they require a plan with DNS record tags;
+// with them, DNS analytics can be segmented by the host, site and environment that publish records.
+// Format: RECORD_TAGS=true, TAG_HOST=nas (defaults to the hostname), TAG_SITE=home, TAG_ENVIRONMENT=prod
+func parseRecordTags() []string {
+	if strings.ToLower(getEnv("RECORD_TAGS")) != "true" {
+		return nil
+	}
+
+	host := getEnv("TAG_HOST")
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	var tags []string
+	for _, tag := range []struct{ name, value string }{
+		{"host", host},
+		{"site", getEnv("TAG_SITE")},
+		{"environment", getEnv("TAG_ENVIRONMENT")},
+	} {
+		if value := sanitizeTagValue(tag.value); value != "" {
+			tags = append(tags, tag.name+":"+value)
+		}
+	}
+	tags = append(tags, managedByTag)
+
+	log.Printf("Record tags: %s", strings.Join(tags, ", "))
+	return tags
+}
+
+// sanitizeTagValue makes a value safe for use in a "name:value" tag
+func sanitizeTagValue(value string) string {
+	value = strings.Join(strings.Fields(value), "-")
+	return strings.ReplaceAll(value, ":", "-")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRecordTags(t *testing.T) {
+	t.Setenv(envPrefix+"RECORD_TAGS", "true")
+	t.Setenv(envPrefix+"TAG_HOST", "nas")
+	t.Setenv(envPrefix+"TAG_SITE", "Home Office")
+	t.Setenv(envPrefix+"TAG_ENVIRONMENT", "")
+
+	want := []string{"host:nas", "site:Home-Office", "managed-by:dynipupdate"}
+	if got := parseRecordTags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRecordTags() = %v, want %v", got, want)
+	}
+}
+
+func TestParseRecordTagsDisabled(t *testing.T) {
+	t.Setenv(envPrefix+"RECORD_TAGS", "")
+	t.Setenv(envPrefix+"TAG_SITE", "home")
+
+	if got := parseRecordTags(); got != nil {
+		t.Errorf("parseRecordTags() = %v, want nil when disabled", got)
+	}
+}
+
+func TestRecordTagsSentWithRecords(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	cf.Tags = []string{"host:nas"}
+
+	if cf.upsertRecord("nas.example.com", "A", "192.0.2.1", false) != nil {
+		t.Fatal("upsertRecord failed")
+	}
+	for _, rec := range fake.records {
+		if !reflect.DeepEqual(rec.Tags, cf.Tags) {
+			t.Errorf("record tags = %v, want %v", rec.Tags, cf.Tags)
+		}
+	}
+}
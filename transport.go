@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider HTTP transport. The HTTP providers send their API requests through one stack of
+// middlewares, each wrapping the next http.RoundTripper with one cross-cutting behavior:
+//
+//	logging -> rate limit -> retry -> metrics -> auth -> fault injection -> network
+//
+// Logging sees each request once, with its final outcome. The rate limiter spaces requests
+// before their first attempt (and paces them by the provider's rate limit headers); the
+// retry backoff spaces the attempts after it, each bounded by providerRequestTimeout.
+// Metrics count every attempt, injected faults included, and credentials are set per
+// attempt, so a retry carries a token refreshed in the meantime. A provider describes its
+// stack with a providerStack and gets the rest for free. The stack keeps no per-request
+// state, so one client is shared by the concurrent updates and jobs of a process.
+//
+// FAULT_INJECTION_PERCENT fails that share of provider API attempts on purpose - half with
+// a network error, half with 503 Service Unavailable - to try out retries, the offline
+// queue and alerting against a healthy provider. It is meant for testing only.
+
+// middleware wraps a transport with one behavior
+type middleware func(http.RoundTripper) http.RoundTripper
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// chainTransport wraps base in the middlewares, the first one outermost
+func chainTransport(base http.RoundTripper, middlewares ...middleware) http.RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		base = middlewares[i](base)
+	}
+	return base
+}
+
+// passThrough is the middleware of a behavior that is turned off
+func passThrough(next http.RoundTripper) http.RoundTripper {
+	return next
+}
+
+// providerStack describes the transport of a provider's API client
+type providerStack struct {
+	name    string                       // Provider, the label of its request metrics
+	auth    func(*http.Request)          // Sets the credentials of each attempt (optional)
+	limiter *rateLimiter                 // Spaces out requests (nil = unlimited)
+	observe func(http.Header, time.Time) // Sees the headers of each response (optional)
+	retry   *retryPolicy                 // Retries transient failures (nil = no retries)
+	timeout time.Duration                // Bounds each attempt
+	faults  int                          // Percentage of attempts failed on purpose
+	base    http.RoundTripper            // nil = http.DefaultTransport
+}
+
+// newProviderStack returns the configured stack of a provider
+func newProviderStack(config *Config, name string) *providerStack {
+	return &providerStack{
+		name:    name,
+		limiter: newRateLimiter(config.APIRateLimit),
+		retry:   newRetryPolicy(config),
+		timeout: providerRequestTimeout,
+		faults:  config.FaultInjectionPercent,
+	}
+}
+
+// transport builds the middleware chain
+func (s *providerStack) transport() http.RoundTripper {
+	base := s.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	retry := s.retry
+	if retry == nil {
+		retry = &retryPolicy{attempts: 1} // Still bounds the attempt by the timeout
+	}
+	return chainTransport(base,
+		logRequests,
+		limitRequests(s.limiter, s.observe),
+		retryRequests(retry, s.timeout),
+		countRequests(s.name),
+		authenticate(s.auth),
+		injectFaults(s.faults),
+	)
+}
+
+// client returns an HTTP client sending requests through the stack. The timeout applies
+// per attempt, so the client itself has none; http.Client.Timeout would also cover the
+// rate limiter's wait and the retry backoff.
+func (s *providerStack) client() *http.Client {
+	return &http.Client{Transport: s.transport()}
+}
+
+// logRequests logs each request at debug level, and responses that are not successful
+func logRequests(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		logDebugf("API Request: %s %s", req.Method, req.URL.Path)
+		resp, err := next.RoundTrip(req)
+		if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+			log.Printf("API Response: %s (status: %d %s)", req.URL.Path, resp.StatusCode, resp.Status)
+		}
+		return resp, err
+	})
+}
+
+// limitRequests waits for the rate limiter before each request and passes the response
+// headers to observe, which may pace the limiter by them
+func limitRequests(limiter *rateLimiter, observe func(http.Header, time.Time)) middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			limiter.wait()
+			resp, err := next.RoundTrip(req)
+			if err == nil && observe != nil {
+				observe(resp.Header, time.Now())
+			}
+			return resp, err
+		})
+	}
+}
+
+// retryRequests retries transient failures with the policy, bounding each attempt by timeout
+func retryRequests(policy *retryPolicy, timeout time.Duration) middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &retryTransport{policy: policy, timeout: timeout, base: next}
+	}
+}
+
+// authenticate sets the credentials of each attempt (nil = requests carry their own)
+func authenticate(auth func(*http.Request)) middleware {
+	if auth == nil {
+		return passThrough
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context()) // A RoundTripper must not modify the request
+			auth(req)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// errInjectedFault is the network error of an injected fault
+var errInjectedFault = errors.New("fault injected by " + envPrefix + "FAULT_INJECTION_PERCENT")
+
+// injectFaults fails percent of the attempts, with a network error or a 503 response
+func injectFaults(percent int) middleware {
+	if percent <= 0 {
+		return passThrough
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if jitterSource.Int63n(100) >= int64(percent) {
+				return next.RoundTrip(req)
+			}
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			if jitterSource.Int63n(2) == 0 {
+				return nil, errInjectedFault
+			}
+			return &http.Response{
+				Status:     "503 Service Unavailable",
+				StatusCode: http.StatusServiceUnavailable,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(errInjectedFault.Error())),
+				Request:    req,
+			}, nil
+		})
+	}
+}
+
+// validateFaultInjection checks FAULT_INJECTION_PERCENT, warning when it is on
+func validateFaultInjection(config *Config) {
+	if config.FaultInjectionPercent < 0 || config.FaultInjectionPercent > 100 {
+		configFatalf("ERROR: %sFAULT_INJECTION_PERCENT must be between 0 and 100", envPrefix)
+	}
+	if config.FaultInjectionPercent > 0 {
+		log.Printf("WARNING: %sFAULT_INJECTION_PERCENT=%d fails provider API requests on purpose - for testing only",
+			envPrefix, config.FaultInjectionPercent)
+	}
+}
+
+// providerRequests counts the provider API attempts of the process, exported as metrics
+var providerRequests struct {
+	mu      sync.Mutex
+	counts  map[[2]string]int  // By provider and status ("error" for network errors)
+	seconds map[string]float64 // Time until the response headers arrived, by provider
+}
+
+// countRequests counts each attempt and its duration in providerRequests
+func countRequests(provider string) middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			status := "error"
+			if err == nil {
+				status = strconv.Itoa(resp.StatusCode)
+			}
+			elapsed := time.Since(start)
+
+			providerRequests.mu.Lock()
+			defer providerRequests.mu.Unlock()
+			if providerRequests.counts == nil {
+				providerRequests.counts = make(map[[2]string]int)
+				providerRequests.seconds = make(map[string]float64)
+			}
+			providerRequests.counts[[2]string{provider, status}]++
+			providerRequests.seconds[provider] += elapsed.Seconds()
+			return resp, err
+		})
+	}
+}
+
+// providerRequestMetrics returns the provider API request metrics (none before the first
+// request)
+func providerRequestMetrics() []metricFamily {
+	providerRequests.mu.Lock()
+	defer providerRequests.mu.Unlock()
+	if len(providerRequests.counts) == 0 {
+		return nil
+	}
+
+	keys := make([][2]string, 0, len(providerRequests.counts))
+	for key := range providerRequests.counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	providers := make([]string, 0, len(providerRequests.seconds))
+	for provider := range providerRequests.seconds {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+
+	requests := metricFamily{Name: "dynipupdate_provider_requests_total", Help: "Provider API request attempts by provider and response status (error = no response).", Type: "counter"}
+	for _, key := range keys {
+		requests.Samples = append(requests.Samples, metricSample{
+			Labels: [][2]string{{"provider", key[0]}, {"status", key[1]}},
+			Value:  float64(providerRequests.counts[key]),
+		})
+	}
+	seconds := metricFamily{Name: "dynipupdate_provider_request_seconds_total", Help: "Time spent waiting for provider API responses, by provider.", Type: "counter"}
+	for _, provider := range providers {
+		seconds.Samples = append(seconds.Samples, metricSample{
+			Labels: [][2]string{{"provider", provider}},
+			Value:  providerRequests.seconds[provider],
+		})
+	}
+	return []metricFamily{requests, seconds}
+}
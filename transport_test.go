@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resetProviderRequests clears the request metrics before and after a test
+func resetProviderRequests(t *testing.T) {
+	reset := func() {
+		providerRequests.mu.Lock()
+		providerRequests.counts, providerRequests.seconds = nil, nil
+		providerRequests.mu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestChainTransportOrder(t *testing.T) {
+	var order []string
+	record := func(name string) middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		order = append(order, "base")
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if _, err := chainTransport(base, record("outer"), passThrough, record("inner")).RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, ","); got != "outer,inner,base" {
+		t.Errorf("order = %s", got)
+	}
+}
+
+func TestProviderStack(t *testing.T) {
+	resetProviderRequests(t)
+	server, bodies := retryTestServer(t, []int{503}, http.Header{"Ratelimit": {`"default";r=9;t=60`}})
+
+	var mu sync.Mutex
+	var tokens []string
+	observed := 0
+	stack := &providerStack{
+		name: "test",
+		auth: func(req *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			token := "token-" + string(rune('a'+len(tokens)))
+			tokens = append(tokens, token)
+			req.Header.Set("Authorization", "Bearer "+token)
+		},
+		observe: func(h http.Header, now time.Time) { observed++ },
+		retry:   &retryPolicy{attempts: 2, sleep: func(time.Duration) {}},
+		timeout: 5 * time.Second,
+	}
+	req, _ := http.NewRequest("POST", server.URL+"/records", strings.NewReader("body"))
+	resp, err := stack.client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Each attempt is authenticated anew; the response headers are observed once
+	if resp.StatusCode != 200 || len(*bodies) != 2 || (*bodies)[1] != "body" {
+		t.Errorf("status %d after bodies %q", resp.StatusCode, *bodies)
+	}
+	if len(tokens) != 2 || req.Header.Get("Authorization") != "" {
+		t.Errorf("tokens = %v, caller's request header %q", tokens, req.Header.Get("Authorization"))
+	}
+	if observed != 1 {
+		t.Errorf("observed %d responses, want 1", observed)
+	}
+
+	out := formatPrometheus(providerRequestMetrics())
+	for _, want := range []string{
+		`dynipupdate_provider_requests_total{provider="test",status="200"} 1` + "\n",
+		`dynipupdate_provider_requests_total{provider="test",status="503"} 1` + "\n",
+		`dynipupdate_provider_request_seconds_total{provider="test"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	resetProviderRequests(t)
+	server, bodies := retryTestServer(t, nil, nil)
+	jitterSource = newSeededRandom(1)
+	t.Cleanup(func() { jitterSource = systemRandom{} })
+
+	stack := &providerStack{
+		name:    "test",
+		retry:   &retryPolicy{attempts: 4, sleep: func(time.Duration) {}},
+		timeout: 5 * time.Second,
+		faults:  100,
+	}
+	resp, err := stack.client().Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("status %d, want an injected 503", resp.StatusCode)
+		}
+	} else if !errors.Is(err, errInjectedFault) {
+		t.Errorf("err = %v, want the injected fault", err)
+	}
+	if len(*bodies) != 0 {
+		t.Errorf("%d requests reached the server", len(*bodies))
+	}
+
+	providerRequests.mu.Lock()
+	attempts := providerRequests.counts[[2]string{"test", "error"}] + providerRequests.counts[[2]string{"test", "503"}]
+	providerRequests.mu.Unlock()
+	if attempts != 4 {
+		t.Errorf("%d attempts counted, want 4", attempts)
+	}
+}
+
+func TestFaultInjectionSetting(t *testing.T) {
+	t.Setenv(envPrefix+"CF_API_TOKEN", "token")
+	t.Setenv(envPrefix+"CF_ZONE_ID", "zone")
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+	t.Setenv(envPrefix+"FAULT_INJECTION_PERCENT", "101")
+	if _, err := reloadConfig(false); err == nil || !strings.Contains(err.Error(), "FAULT_INJECTION_PERCENT") {
+		t.Errorf("101 percent: err = %v", err)
+	}
+
+	t.Setenv(envPrefix+"FAULT_INJECTION_PERCENT", "25")
+	config, err := reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stack := newProviderStack(config, providerRoute53); stack.faults != 25 || stack.retry == nil || stack.limiter == nil {
+		t.Errorf("stack = %+v", stack)
+	}
+}
+
+func TestCloudFlareClientConcurrentRequests(t *testing.T) {
+	resetProviderRequests(t)
+	server, bodies := retryTestServer(t, nil, nil)
+	cf := &CloudFlareClient{APIToken: "test-token", ZoneID: "zone", BaseURL: server.URL, Limiter: newRateLimiter(1000)}
+	cf.client = cf.transportStack().client()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cf.makeRequest("GET", "/zones/zone", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	providerRequests.mu.Lock()
+	count := providerRequests.counts[[2]string{providerCloudFlare, "200"}]
+	providerRequests.mu.Unlock()
+	if len(*bodies) != 10 || count != 10 {
+		t.Errorf("%d requests served, %d counted, want 10", len(*bodies), count)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Published record TTL. Resolvers may keep serving the previous address until the TTL
+// of the record they cached has expired, so propagation checks and follow-up update
+// cycles are scheduled relative to it rather than immediately after a change.
+
+const (
+	defaultRecordTTL    = 120  // 2 minutes for dynamic DNS
+	defaultHeartbeatTTL = 3600 // Heartbeats are read through the API, never resolved
+	autoRecordTTL       = 1    // CloudFlare "automatic" TTL
+	autoTTLSeconds      = 300  // What CloudFlare publishes for the automatic TTL
+
+	// ttlGrace is added after TTL expiry before re-checking, to allow for clock skew
+	// and resolvers that fetched the old record just before the change
+	ttlGrace = 5 * time.Second
+)
+
+// parseTTLSetting reads a TTL setting: seconds (30-86400) or 1 for CloudFlare's automatic TTL
+func parseTTLSetting(key string, defaultValue int) int {
+	ttl := getEnvOrDefaultInt(key, defaultValue)
+	if ttl != autoRecordTTL && (ttl < 30 || ttl > 86400) {
+		log.Printf("WARNING: %s%s must be 1 (automatic) or 30-86400 seconds, got %d - using %d", envPrefix, key, ttl, defaultValue)
+		return defaultValue
+	}
+	return ttl
+}
+
+// parseRecordTTL reads RECORD_TTL (or its short form TTL), the TTL of address and alias records
+func parseRecordTTL() int {
+	if getEnv("RECORD_TTL") == "" && getEnv("TTL") != "" {
+		return parseTTLSetting("TTL", defaultRecordTTL)
+	}
+	return parseTTLSetting("RECORD_TTL", defaultRecordTTL)
+}
+
+// parseOptionalTTL reads a TTL override, returning 0 if it is not set or invalid
+func parseOptionalTTL(key string) int {
+	if getEnv(key) == "" {
+		return 0
+	}
+	return parseTTLSetting(key, 0)
+}
+
+// parseDomainTTLs collects the per-name TTL overrides: INTERNAL_DOMAIN_TTL and the like for
+// the domain classes, IPV4_RANGE_N_TTL and IPV6_RANGE_N_TTL for custom ranges. Names without
+// an override are published with RECORD_TTL.
+func parseDomainTTLs(config *Config) map[string]int {
+	ttls := make(map[string]int)
+	set := func(domain string, ttl int) {
+		if domain != "" && ttl != 0 {
+			ttls[normalizeName(domain)] = ttl
+		}
+	}
+	set(config.InternalDomain, parseOptionalTTL("INTERNAL_DOMAIN_TTL"))
+	set(config.ExternalDomain, parseOptionalTTL("EXTERNAL_DOMAIN_TTL"))
+	set(config.IPv6Domain, parseOptionalTTL("IPV6_DOMAIN_TTL"))
+	set(config.CombinedDomain, parseOptionalTTL("COMBINED_DOMAIN_TTL"))
+	set(config.TopLevelDomain, parseOptionalTTL("TOP_LEVEL_DOMAIN_TTL"))
+	for _, r := range append(append([]CustomIPRange{}, config.CustomIPv4Ranges...), config.CustomIPv6Ranges...) {
+		set(r.Domain, r.TTL)
+	}
+	if len(ttls) == 0 {
+		return nil
+	}
+	return ttls
+}
+
+// ttlFor returns the TTL records at name are published with
+func (c *Config) ttlFor(name string) int {
+	if ttl, ok := c.DomainTTLs[normalizeName(name)]; ok {
+		return ttl
+	}
+	return c.RecordTTL
+}
+
+// ttlDiffers reports whether a published TTL needs rewriting. Proxied records always
+// report the automatic TTL, and 0 means the TTL is unknown or not configured.
+func ttlDiffers(published, wanted int, proxied bool) bool {
+	return !proxied && published != 0 && wanted != 0 && published != wanted
+}
+
+// parseHeartbeatTTL reads HEARTBEAT_TTL. Heartbeat TXT records are only read by the
+// cleanup service through the provider API, so a long TTL only saves resolver churn.
+func parseHeartbeatTTL() int {
+	return parseTTLSetting("HEARTBEAT_TTL", defaultHeartbeatTTL)
+}
+
+// effectiveTTL returns how long resolvers may cache a record published with ttl
+func effectiveTTL(ttl int) time.Duration {
+	if ttl == autoRecordTTL {
+		return autoTTLSeconds * time.Second
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// cacheExpiry returns when every resolver cache must have dropped the records
+// that were published before changedAt
+func cacheExpiry(ttl int, changedAt time.Time) time.Time {
+	return changedAt.Add(effectiveTTL(ttl) + ttlGrace)
+}
+
+// nextCycleDelay returns how long to wait before the next update cycle. After a change
+// (changedAt non-zero) the cycle runs shortly after the TTL has expired if that is sooner
+// than the regular interval, so the result is re-checked once caches can have refreshed.
+func nextCycleDelay(interval time.Duration, ttl int, changedAt, now time.Time) time.Duration {
+	if changedAt.IsZero() {
+		return interval
+	}
+	if untilExpiry := cacheExpiry(ttl, changedAt).Sub(now); untilExpiry > 0 && untilExpiry < interval {
+		return untilExpiry
+	}
+	return interval
+}
+
+// latestChange returns the time of the most recent change (zero if there were none)
+func latestChange(changes []RecordChange) time.Time {
+	var latest int64
+	for _, c := range changes {
+		if c.Timestamp > latest {
+			latest = c.Timestamp
+		}
+	}
+	if latest == 0 {
+		return time.Time{}
+	}
+	return time.Unix(latest, 0)
+}
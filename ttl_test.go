@@ -0,0 +1,167 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRecordTTL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", defaultRecordTTL},
+		{"300", 300},
+		{"1", autoRecordTTL},
+		{"10", defaultRecordTTL},
+		{"100000", defaultRecordTTL},
+	}
+	for _, tt := range tests {
+		t.Setenv(envPrefix+"RECORD_TTL", tt.value)
+		if got := parseRecordTTL(); got != tt.want {
+			t.Errorf("RECORD_TTL=%q: got %d, want %d", tt.value, got, tt.want)
+		}
+	}
+
+	if got := parseHeartbeatTTL(); got != defaultHeartbeatTTL {
+		t.Errorf("HEARTBEAT_TTL unset: got %d, want %d", got, defaultHeartbeatTTL)
+	}
+}
+
+func TestNextCycleDelay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	interval := 10 * time.Minute
+
+	if got := nextCycleDelay(interval, 120, time.Time{}, now); got != interval {
+		t.Errorf("without changes: %s, want the interval", got)
+	}
+	// Changed 20s ago with a 120s TTL: re-check 105s from now (100s left + grace)
+	if got := nextCycleDelay(interval, 120, now.Add(-20*time.Second), now); got != 105*time.Second {
+		t.Errorf("after a change: %s, want 1m45s", got)
+	}
+	// TTL longer than the interval keeps the interval
+	if got := nextCycleDelay(interval, 3600, now, now); got != interval {
+		t.Errorf("long TTL: %s, want the interval", got)
+	}
+	// Automatic TTL counts as 300s
+	if got := nextCycleDelay(interval, autoRecordTTL, now, now); got != 305*time.Second {
+		t.Errorf("automatic TTL: %s, want 5m5s", got)
+	}
+}
+
+func TestLatestChange(t *testing.T) {
+	if !latestChange(nil).IsZero() {
+		t.Error("no changes should give the zero time")
+	}
+	changes := []RecordChange{{Timestamp: 100}, {Timestamp: 300}, {Timestamp: 200}}
+	if got := latestChange(changes); got.Unix() != 300 {
+		t.Errorf("latestChange = %d, want 300", got.Unix())
+	}
+}
+
+func TestHeartbeatTTL(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	cf.TTL = 300
+	cf.HeartbeatTTL = 7200
+
+	reconcileRecords(cf, &Config{ExternalDomain: "home.example.com"}, &IPAddresses{ExternalIPv4: "203.0.113.1"}, time.Now())
+
+	ttls := make(map[string]int)
+	for _, rec := range fake.records {
+		ttls[rec.Type] = rec.TTL
+	}
+	if ttls["A"] != 300 || ttls["TXT"] != 7200 {
+		t.Errorf("TTLs = %v, want A 300 and heartbeat TXT 7200", ttls)
+	}
+
+	// Record-set providers publish heartbeats with their own TTL too
+	r53, r := newFakeRoute53(t)
+	r.upsertHeartbeat("home.example.com", `"1700000000"`)
+	r.upsertRecord("home.example.com", "A", "203.0.113.1", false)
+	if got := r53.sets["home.example.com./TXT"].TTL; got != 3600 {
+		t.Errorf("Route53 heartbeat TTL = %d, want 3600", got)
+	}
+	if got := r53.sets["home.example.com./A"].TTL; got != 120 {
+		t.Errorf("Route53 A TTL = %d, want 120", got)
+	}
+}
+
+func TestParseDomainTTLs(t *testing.T) {
+	t.Setenv(envPrefix+"TTL", "600")
+	t.Setenv(envPrefix+"INTERNAL_DOMAIN_TTL", "60")
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN_TTL", "5") // Invalid: falls back to the global TTL
+	t.Setenv(envPrefix+"IPV4_RANGE_1", "172.16.0.0/12")
+	t.Setenv(envPrefix+"IPV4_RANGE_1_DOMAIN", "nas.lab.example.com")
+	t.Setenv(envPrefix+"IPV4_RANGE_1_TTL", "1")
+
+	config := &Config{
+		InternalDomain:   "nas.i.example.com",
+		ExternalDomain:   "nas.e.example.com",
+		RecordTTL:        parseRecordTTL(),
+		CustomIPv4Ranges: parseCustomRanges("IPV4_RANGE", "A", 20),
+	}
+	config.DomainTTLs = parseDomainTTLs(config)
+
+	for name, want := range map[string]int{
+		"nas.i.example.com":   60,
+		"nas.e.example.com":   600,
+		"nas.lab.example.com": autoRecordTTL,
+		"other.example.com":   600,
+	} {
+		if got := config.ttlFor(name); got != want {
+			t.Errorf("ttlFor(%s) = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestDomainTTLChangeApplied(t *testing.T) {
+	for _, concurrency := range []int{0, 4} {
+		fake, cf := newFakeCloudFlare(t)
+		for _, id := range []string{
+			fake.add("A", "nas.i.example.com", "10.0.0.1"),
+			fake.add("A", "nas.e.example.com", "203.0.113.1"),
+		} {
+			record := fake.records[id]
+			record.TTL = 120
+			fake.records[id] = record
+		}
+		config := &Config{
+			InternalDomain:    "nas.i.example.com",
+			ExternalDomain:    "nas.e.example.com",
+			RecordTTL:         120,
+			DomainTTLs:        map[string]int{"nas.i.example.com": 60, "nas.e.example.com": 300},
+			UpdateConcurrency: concurrency,
+			clock:             newFakeClock(time.Unix(1700000000, 0)),
+		}
+		cf.TTL, cf.NameTTLs = config.RecordTTL, config.DomainTTLs
+
+		var changes []RecordChange
+		cf.hooks().OnChange = newChangeRecorder(&changes)
+		ips := &IPAddresses{InternalIPv4: []string{"10.0.0.1"}, ExternalIPv4: "203.0.113.1"}
+		if success, total := reconcileRecords(cf, config, ips, config.now()); success != total {
+			t.Errorf("concurrency %d: %d of %d succeeded", concurrency, success, total)
+		}
+
+		ttls := make(map[string]int)
+		for _, rec := range fake.records {
+			ttls[rec.Type+" "+rec.Name] = rec.TTL
+		}
+		want := map[string]int{"A nas.i.example.com": 60, "A nas.e.example.com": 300, "TXT nas.i.example.com": defaultHeartbeatTTL}
+		for key, ttl := range want {
+			if ttls[key] != ttl {
+				t.Errorf("concurrency %d: TTLs = %v, want %v", concurrency, ttls, want)
+				break
+			}
+		}
+		if len(changes) != 2 || changes[0].Action != "update" {
+			t.Errorf("concurrency %d: changes = %+v, want two TTL updates", concurrency, changes)
+		}
+
+		// Applied TTLs are in sync
+		changes = nil
+		reconcileRecords(cf, config, ips, config.now())
+		if len(changes) != 0 {
+			t.Errorf("concurrency %d: second run changed %+v", concurrency, changes)
+		}
+	}
+}
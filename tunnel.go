@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloudFlare Tunnel fallback. Behind carrier-grade NAT (or a closed port) the external
+// address cannot be reached from outside, but a Cloudflare Tunnel (cloudflared) still can.
+// With TUNNEL_ID (or TUNNEL_HOSTNAME) set, the external domain becomes a proxied CNAME to
+// the tunnel when the reachability checks fail, and goes back to the A record once the
+// address is reachable again. TUNNEL_MODE=always publishes the tunnel unconditionally.
+
+// Supported values for BEES_IP_UPDATE_TUNNEL_MODE
+const (
+	tunnelModeFallback = "fallback"
+	tunnelModeAlways   = "always"
+)
+
+// tunnelCheckTimeout bounds the connection attempt of the port check
+const tunnelCheckTimeout = 5 * time.Second
+
+// parseTunnelTarget returns the CNAME target of the configured tunnel: TUNNEL_HOSTNAME, or
+// the tunnel's <id>.cfargotunnel.com name for TUNNEL_ID
+func parseTunnelTarget() string {
+	if hostname := getEnv("TUNNEL_HOSTNAME"); hostname != "" {
+		return normalizeName(hostname)
+	}
+	if id := strings.ToLower(getEnv("TUNNEL_ID")); id != "" {
+		return id + ".cfargotunnel.com"
+	}
+	return ""
+}
+
+// validateTunnel checks the tunnel settings, exiting on errors
+func validateTunnel(config *Config, provider string) {
+	if config.TunnelTarget == "" {
+		return
+	}
+	if provider != providerCloudFlare {
+		configFatalf("ERROR: %sTUNNEL_ID is only supported with the %s provider", envPrefix, providerCloudFlare)
+	}
+	if config.TunnelMode != tunnelModeFallback && config.TunnelMode != tunnelModeAlways {
+		configFatalf("ERROR: %sTUNNEL_MODE must be %q or %q, got %q", envPrefix, tunnelModeFallback, tunnelModeAlways, config.TunnelMode)
+	}
+	if config.ExternalDomain == "" {
+		log.Printf("WARNING: %sTUNNEL_ID has no effect without %sEXTERNAL_DOMAIN", envPrefix, envPrefix)
+		return
+	}
+	log.Printf("External domain %s falls back to tunnel %s (%s)", config.ExternalDomain, config.TunnelTarget, config.TunnelMode)
+}
+
+// externalReachable reports whether connections from outside can reach the external IPv4
+// address, and why not. Addresses in the carrier-grade NAT or private ranges are behind
+// another NAT; with TUNNEL_CHECK_PORT the port is also connected to (through the router's
+// hairpin NAT, so routers without it always report the port closed).
+func externalReachable(config *Config, ip string) (bool, string) {
+	if ip == "" {
+		return false, "no external IPv4 address"
+	}
+	addr, err := parseAddr(ip)
+	switch {
+	case err != nil:
+		return false, fmt.Sprintf("invalid external IPv4 address %q", ip)
+	case sharedAddressSpace.Contains(addr):
+		return false, ip + " is a carrier-grade NAT address"
+	case addr.IsPrivate():
+		return false, ip + " is a private address (double NAT)"
+	}
+	if config.TunnelCheckPort != 0 {
+		target := net.JoinHostPort(ip, strconv.Itoa(config.TunnelCheckPort))
+		conn, err := net.DialTimeout("tcp", target, tunnelCheckTimeout)
+		if err != nil {
+			return false, fmt.Sprintf("%s is not reachable: %v", target, err)
+		}
+		conn.Close()
+	}
+	return true, ""
+}
+
+// reconcileTunnel publishes the external domain as the tunnel CNAME or as the A record,
+// whichever the reachability checks select. When the tunnel is published it returns the
+// configuration for the rest of the run without the external domain; the records of the
+// other type are deleted first, as a name cannot hold a CNAME next to other records.
+func reconcileTunnel(cf providerClient, config *Config, ips *IPAddresses) (next *Config, successCount, totalCount int) {
+	if config.TunnelTarget == "" || config.ExternalDomain == "" {
+		return config, 0, 0
+	}
+	reachable, reason := false, "" // TUNNEL_MODE=always
+	if config.TunnelMode == tunnelModeFallback {
+		reachable, reason = externalReachable(config, ips.ExternalIPv4)
+	}
+
+	if reachable {
+		// The A record is reconciled as usual once a tunnel CNAME is gone
+		records, err := cf.getAllRecords(config.ExternalDomain, "CNAME")
+		if !succeeded(err) {
+			return config, 0, 1
+		}
+		for _, record := range records {
+			totalCount++
+			log.Printf("External address %s is reachable - replacing the tunnel CNAME of %s", ips.ExternalIPv4, config.ExternalDomain)
+			if succeeded(cf.deleteRecord(record.ID, config.ExternalDomain, "CNAME")) {
+				successCount++
+			}
+		}
+		return config, successCount, totalCount
+	}
+
+	if reason != "" {
+		log.Printf("External address not reachable (%s) - publishing %s through tunnel %s", reason, config.ExternalDomain, config.TunnelTarget)
+	}
+	records, err := cf.getAllRecords(config.ExternalDomain, "A")
+	if !succeeded(err) {
+		totalCount++
+	}
+	for _, record := range records {
+		totalCount++
+		if succeeded(cf.deleteRecord(record.ID, config.ExternalDomain, "A")) {
+			successCount++
+		}
+	}
+	totalCount++
+	if succeeded(cf.upsertRecord(config.ExternalDomain, "CNAME", config.TunnelTarget, true)) {
+		successCount++
+	}
+
+	tunneled := *config
+	tunneled.ExternalDomain = ""
+	return &tunneled, successCount, totalCount
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTunnelTarget(t *testing.T) {
+	t.Setenv(envPrefix+"TUNNEL_ID", "C0FFEE00-1234-5678-9abc-def012345678")
+	if got := parseTunnelTarget(); got != "c0ffee00-1234-5678-9abc-def012345678.cfargotunnel.com" {
+		t.Errorf("parseTunnelTarget() = %s, want the tunnel's cfargotunnel.com name", got)
+	}
+	t.Setenv(envPrefix+"TUNNEL_HOSTNAME", "Tunnel.example.com.")
+	if got := parseTunnelTarget(); got != "tunnel.example.com" {
+		t.Errorf("parseTunnelTarget() = %s, want TUNNEL_HOSTNAME", got)
+	}
+}
+
+func TestExternalReachable(t *testing.T) {
+	for ip, want := range map[string]string{
+		"":            "no external IPv4",
+		"100.72.1.5":  "carrier-grade NAT",
+		"192.168.0.2": "double NAT",
+		"203.0.113.1": "",
+	} {
+		reachable, reason := externalReachable(&Config{}, ip)
+		if reachable != (want == "") || !strings.Contains(reason, want) {
+			t.Errorf("externalReachable(%q) = %t, %q, want reason containing %q", ip, reachable, reason, want)
+		}
+	}
+
+	// The port check connects to the external address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	if reachable, reason := externalReachable(&Config{TunnelCheckPort: port}, "127.0.0.1"); !reachable {
+		t.Errorf("open port reported unreachable: %s", reason)
+	}
+	listener.Close()
+	if reachable, _ := externalReachable(&Config{TunnelCheckPort: port}, "127.0.0.1"); reachable {
+		t.Error("closed port reported reachable")
+	}
+}
+
+func TestTunnelFallback(t *testing.T) {
+	for _, concurrency := range []int{0, 4} {
+		fake, cf := newFakeCloudFlare(t)
+		fake.add("A", "home.example.com", "203.0.113.1")
+		config := &Config{
+			ExternalDomain:    "home.example.com",
+			CombinedDomain:    "all.example.com",
+			TunnelTarget:      "abc.cfargotunnel.com",
+			TunnelMode:        tunnelModeFallback,
+			UpdateConcurrency: concurrency,
+		}
+
+		// Behind carrier-grade NAT the external domain points at the tunnel
+		reconcileRecords(cf, config, &IPAddresses{ExternalIPv4: "100.64.3.4"}, time.Now())
+		if got := fake.contents("home.example.com", "A"); len(got) != 0 {
+			t.Errorf("concurrency %d: A records = %v, want none behind CGNAT", concurrency, got)
+		}
+		if got := fake.contents("home.example.com", "CNAME"); len(got) != 1 || got[0] != "abc.cfargotunnel.com" {
+			t.Errorf("concurrency %d: CNAME records = %v, want the tunnel", concurrency, got)
+		}
+		for _, record := range fake.records {
+			if record.Type == "CNAME" && !record.Proxied {
+				t.Errorf("concurrency %d: tunnel CNAME is not proxied", concurrency)
+			}
+		}
+
+		// A reachable address replaces the tunnel with the A record again
+		reconcileRecords(cf, config, &IPAddresses{ExternalIPv4: "203.0.113.9"}, time.Now())
+		if got := fake.contents("home.example.com", "CNAME"); len(got) != 0 {
+			t.Errorf("concurrency %d: CNAME records = %v, want the tunnel removed", concurrency, got)
+		}
+		if got := fake.contents("home.example.com", "A"); len(got) != 1 || got[0] != "203.0.113.9" {
+			t.Errorf("concurrency %d: A records = %v, want the reachable address", concurrency, got)
+		}
+
+		// TUNNEL_MODE=always never publishes the address
+		config.TunnelMode = tunnelModeAlways
+		reconcileRecords(cf, config, &IPAddresses{ExternalIPv4: "203.0.113.9"}, time.Now())
+		if got := fake.contents("home.example.com", "A"); len(got) != 0 {
+			t.Errorf("concurrency %d: A records = %v, want none with the tunnel always on", concurrency, got)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// unboundFileHeader is the first line of include files written by the unbound writer
+const unboundFileHeader = "# Written by dynipupdate - local-data lines are replaced on every update"
+
+// UnboundFileProvider writes the records as local-data statements to an include file of
+// unbound (include: "/etc/unbound/dynipupdate.conf" in the server: clause), so unbound
+// answers for them without asking anyone else. Unlike hosts files, local-data holds every
+// record type. Other lines of the file, such as local-zone statements, are kept.
+//
+//	local-data: "nas.example.com. 300 IN A 192.168.1.10"
+//	local-data: "www.example.com. 300 IN CNAME nas.example.com."
+//	local-data: 'hb.example.com. 300 IN TXT "1700000000"'
+type UnboundFileProvider struct {
+	Path string
+
+	mu sync.Mutex
+
+	recordSetClient
+}
+
+// Verify UnboundFileProvider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*UnboundFileProvider)(nil)
+var _ DNSProvider = (*UnboundFileProvider)(nil)
+var _ providerClient = (*UnboundFileProvider)(nil)
+
+// newUnboundFileProvider creates the unbound include file writer for path
+func newUnboundFileProvider(path string, config *Config) (*UnboundFileProvider, error) {
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	p := &UnboundFileProvider{Path: path}
+	p.recordSetClient = newRecordSetClient(p, config)
+	log.Printf("Writing records to unbound include file %s", p.Path)
+	return p, nil
+}
+
+// read returns the other lines of the file and its local-data statements.
+// A missing file reads as empty.
+func (p *UnboundFileProvider) read() (other []string, data []resourceRecord, err error) {
+	content, err := os.ReadFile(p.Path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == unboundFileHeader {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "local-data:") {
+			other = append(other, line)
+			continue
+		}
+		d, err := parseUnboundLocalData(strings.TrimSpace(strings.TrimPrefix(trimmed, "local-data:")))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", p.Path, err)
+		}
+		data = append(data, d)
+	}
+	return other, data, scanner.Err()
+}
+
+// parseUnboundLocalData parses the quoted resource record of a local-data statement
+func parseUnboundLocalData(quoted string) (resourceRecord, error) {
+	if len(quoted) < 2 || (quoted[0] != '"' && quoted[0] != '\'') || quoted[len(quoted)-1] != quoted[0] {
+		return resourceRecord{}, fmt.Errorf("local-data %s is not quoted", quoted)
+	}
+	return parseResourceRecord(quoted[1:len(quoted)-1], 3600) // unbound's default TTL for local-data
+}
+
+// formatUnboundLocalData renders the statement; TXT records are single-quoted to hold their
+// double quotes
+func formatUnboundLocalData(r resourceRecord) string {
+	if r.Type == "TXT" {
+		return fmt.Sprintf("local-data: '%s'", r)
+	}
+	return fmt.Sprintf("local-data: \"%s\"", r)
+}
+
+// write replaces the file with the other lines and the statements
+func (p *UnboundFileProvider) write(other []string, data []resourceRecord) error {
+	lines := append([]string{unboundFileHeader}, other...)
+	for _, r := range data {
+		lines = append(lines, formatUnboundLocalData(r))
+	}
+	return replaceFileAtomic(p.Path, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// recordSetBackend implementation
+
+func (p *UnboundFileProvider) fetchValues(name, recordType string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, data, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var values []string
+	for _, d := range data {
+		if d.Name == name && d.Type == recordType {
+			values = append(values, d.Value)
+		}
+	}
+	return values, nil
+}
+
+func (p *UnboundFileProvider) storeValues(name, recordType string, ttl int, values []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	other, data, err := p.read()
+	if err != nil {
+		return err
+	}
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return p.write(other, replaceRecordSet(data, name, recordType, ttl, values))
+}
+
+func (p *UnboundFileProvider) listRecords(recordType string) ([]CFRecord, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, data, err := p.read()
+	if err != nil {
+		return []CFRecord{}, err
+	}
+	records := []CFRecord{}
+	for _, d := range data {
+		if d.Type == recordType {
+			records = append(records, valueRecords(d.Name, recordType, []string{d.Value})...)
+		}
+	}
+	return records, nil
+}
+
+// probe reports whether the file can be read
+func (p *UnboundFileProvider) probe() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _, err := p.read()
+	return err == nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestUnboundFile(t *testing.T, content string) (*UnboundFileProvider, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dynipupdate.conf")
+	if content != "" {
+		if err := os.WriteFile(path, []byte(content), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := newUnboundFileProvider(path, &Config{RecordTTL: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, path
+}
+
+func TestUnboundFileProviderWritesLocalData(t *testing.T) {
+	p, path := newTestUnboundFile(t, "local-zone: \"example.com.\" transparent\n")
+
+	if p.upsertRecord("nas.example.com", "A", "192.168.1.10", false) != nil ||
+		p.ensureRecordExists("nas.example.com", "A", "192.168.1.11", false) != nil ||
+		p.upsertRecord("nas.example.com", "AAAA", "2001:db8::10", false) != nil ||
+		p.upsertRecord("www.example.com", "CNAME", "nas.example.com", false) != nil ||
+		p.upsertRecord("nas.example.com", "TXT", "hello world", false) != nil ||
+		p.upsertRecord("_changelog.example.com", "TXT", `"first entry" "second \"quoted\""`, false) != nil {
+		t.Fatal("record operation failed")
+	}
+	want := unboundFileHeader + "\n" +
+		"local-zone: \"example.com.\" transparent\n" +
+		"local-data: \"nas.example.com. 120 IN A 192.168.1.10\"\n" +
+		"local-data: \"nas.example.com. 120 IN A 192.168.1.11\"\n" +
+		"local-data: \"nas.example.com. 120 IN AAAA 2001:db8::10\"\n" +
+		"local-data: \"www.example.com. 120 IN CNAME nas.example.com.\"\n" +
+		"local-data: 'nas.example.com. 120 IN TXT \"hello world\"'\n" +
+		"local-data: '_changelog.example.com. 120 IN TXT \"first entry\" \"second \\\"quoted\\\"\"'\n"
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("file =\n%s\nwant\n%s", data, want)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("file mode not kept: %v %v", info.Mode(), err)
+	}
+
+	// Values read back as they were written
+	for _, c := range []struct{ name, recordType, want string }{
+		{"www.example.com", "CNAME", "nas.example.com"},
+		{"nas.example.com", "TXT", "hello world"},
+		{"_changelog.example.com", "TXT", `"first entry" "second \"quoted\""`},
+	} {
+		if r, _ := p.getRecord(c.name, c.recordType); r == nil || r.Content != c.want {
+			t.Errorf("%s %s = %+v, want %q", c.recordType, c.name, r, c.want)
+		}
+	}
+
+	// A replaced set keeps its position
+	if p.deleteRecord("192.168.1.11", "nas.example.com", "A") != nil || p.upsertRecord("nas.example.com", "A", "192.168.1.20", false) != nil {
+		t.Fatal("update failed")
+	}
+	data, _ = os.ReadFile(path)
+	if !strings.Contains(string(data), "transparent\nlocal-data: \"nas.example.com. 120 IN A 192.168.1.20\"\nlocal-data: \"nas.example.com. 120 IN AAAA") {
+		t.Errorf("file after update =\n%s", data)
+	}
+	list, err := p.listRecords("A")
+	if err != nil || len(list) != 1 || list[0].Content != "192.168.1.20" {
+		t.Errorf("listRecords(A) = %+v, %v", list, err)
+	}
+}
+
+func TestParseUnboundLocalData(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want resourceRecord
+	}{
+		{`"nas.example.com A 10.0.0.1"`, resourceRecord{Name: "nas.example.com", TTL: 3600, Type: "A", Value: "10.0.0.1"}},
+		{`"nas.example.com. IN AAAA 2001:db8::1"`, resourceRecord{Name: "nas.example.com", TTL: 3600, Type: "AAAA", Value: "2001:db8::1"}},
+		{`'hb.example.com. 60 txt "a b"'`, resourceRecord{Name: "hb.example.com", TTL: 60, Type: "TXT", Value: "a b"}},
+	} {
+		got, err := parseUnboundLocalData(c.in)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseUnboundLocalData(%s) = %+v, %v; want %+v", c.in, got, err, c.want)
+		}
+	}
+	for _, in := range []string{`nas.example.com A 10.0.0.1`, `"nas.example.com"`, `"nas.example.com A 10.0.0.1'`} {
+		if _, err := parseUnboundLocalData(in); err == nil {
+			t.Errorf("parseUnboundLocalData(%s) accepted", in)
+		}
+	}
+}
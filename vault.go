@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HashiCorp Vault. With VAULT_SECRET_PATH set, the CloudFlare API token is read from that
+// secret of a KV version 2 engine (VAULT_KV_MOUNT, default "secret") at startup instead of
+// CF_API_TOKEN, and read again when its lease expires, so that tokens rotated in Vault are
+// picked up by a running daemon. KV v2 secrets have no lease of their own: they are read again
+// every VAULT_REFRESH_SECONDS. A failed refresh keeps the current token and is retried a
+// minute later. The Vault token comes from VAULT_TOKEN, or VAULT_TOKEN_FILE, which is read
+// again on every refresh (the sink file of Vault Agent). VAULT_ADDR and VAULT_TOKEN without
+// the prefix, as used by the vault CLI, are honored when the prefixed settings are unset.
+
+// Defaults of the Vault settings
+const (
+	defaultVaultMount          = "secret"
+	defaultVaultSecretKey      = "cf_api_token"
+	defaultVaultRefreshSeconds = 3600
+)
+
+// vaultRetryDelay is how long a failed refresh waits before the next attempt
+const vaultRetryDelay = time.Minute
+
+// vaultSecret reads the API token from Vault and caches it until its lease expires
+type vaultSecret struct {
+	Addr      string // Vault server, e.g. https://vault.example.com:8200
+	Namespace string // Vault Enterprise namespace (optional)
+	Mount     string // Mount path of the KV v2 engine
+	Path      string // Secret path within the mount
+	Key       string // Key of the token within the secret
+	Token     string // Vault token (when TokenFile is empty)
+	TokenFile string // File holding the Vault token, read on every refresh (optional)
+	Refresh   time.Duration
+
+	client *http.Client
+	clock  Clock
+
+	mu      sync.Mutex
+	value   string
+	version int
+	expires time.Time
+}
+
+// vaultSecretResponse is the part of a KV v2 read response we use
+type vaultSecretResponse struct {
+	LeaseDuration int `json:"lease_duration"`
+	Data          struct {
+		Data     map[string]any `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// parseVaultConfig reads the VAULT_* settings; nil when VAULT_SECRET_PATH is unset
+func parseVaultConfig() *vaultSecret {
+	path := strings.Trim(getEnv("VAULT_SECRET_PATH"), "/")
+	if path == "" {
+		return nil
+	}
+	v := &vaultSecret{
+		Addr:      strings.TrimRight(getEnvOrDefault("VAULT_ADDR", os.Getenv("VAULT_ADDR")), "/"),
+		Namespace: getEnvOrDefault("VAULT_NAMESPACE", os.Getenv("VAULT_NAMESPACE")),
+		Mount:     strings.Trim(getEnvOrDefault("VAULT_KV_MOUNT", defaultVaultMount), "/"),
+		Path:      path,
+		Key:       getEnvOrDefault("VAULT_SECRET_KEY", defaultVaultSecretKey),
+		Token:     strings.TrimSpace(getEnvOrDefault("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))),
+		TokenFile: getEnv("VAULT_TOKEN_FILE"),
+		Refresh:   time.Duration(getEnvOrDefaultInt("VAULT_REFRESH_SECONDS", defaultVaultRefreshSeconds)) * time.Second,
+		client:    &http.Client{Timeout: providerRequestTimeout},
+	}
+	switch {
+	case v.Addr == "":
+		configFatalf("ERROR: %sVAULT_ADDR is required with %sVAULT_SECRET_PATH", envPrefix, envPrefix)
+	case v.Token == "" && v.TokenFile == "":
+		configFatalf("ERROR: %sVAULT_TOKEN or %sVAULT_TOKEN_FILE is required with %sVAULT_SECRET_PATH", envPrefix, envPrefix, envPrefix)
+	case v.Refresh <= 0:
+		configFatalf("ERROR: %sVAULT_REFRESH_SECONDS must be positive", envPrefix)
+	}
+	return v
+}
+
+// vaultAPIToken reads the API token from Vault at startup. CF_API_TOKEN (the token given
+// otherwise) must be unset.
+func vaultAPIToken(vault *vaultSecret, apiToken string) string {
+	if apiToken != "" {
+		configFatalf("ERROR: Both %sCF_API_TOKEN and %sVAULT_SECRET_PATH are set - use one of them", envPrefix, envPrefix)
+	}
+	token, err := vault.get()
+	if err != nil {
+		configFatalf("ERROR: Could not read the API token from Vault: %v", err)
+	}
+	log.Printf("Using API token from Vault (%s, version %d)", vault.secretName(), vault.version)
+	return token
+}
+
+// now returns the current time of the secret's clock
+func (v *vaultSecret) now() time.Time {
+	if v.clock == nil {
+		return time.Now()
+	}
+	return v.clock.Now()
+}
+
+// get returns the token, reading the secret again once its lease has expired. Until the
+// first successful read, errors are returned; after it, the last token is kept.
+func (v *vaultSecret) get() (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.value != "" && v.now().Before(v.expires) {
+		return v.value, nil
+	}
+	value, version, lease, err := v.read()
+	if err != nil {
+		if v.value == "" {
+			return "", err
+		}
+		log.Printf("WARNING: Could not refresh the API token from Vault, keeping the current one: %v", err)
+		v.expires = v.now().Add(vaultRetryDelay)
+		return v.value, nil
+	}
+	if v.value != "" && value != v.value {
+		log.Printf("Vault: API token rotated (%s version %d)", v.secretName(), version)
+	}
+	v.value, v.version = value, version
+	v.expires = v.now().Add(lease)
+	return value, nil
+}
+
+// token returns the token for an API request; "" when Vault never answered
+func (v *vaultSecret) token() string {
+	token, err := v.get()
+	if err != nil {
+		log.Printf("ERROR: Could not read the API token from Vault: %v", err)
+	}
+	return token
+}
+
+// secretName is the mount and path of the secret, for messages
+func (v *vaultSecret) secretName() string {
+	return v.Mount + "/" + v.Path
+}
+
+// read fetches the secret and returns the token, the secret version and how long to keep it
+func (v *vaultSecret) read() (string, int, time.Duration, error) {
+	vaultToken := v.Token
+	if v.TokenFile != "" {
+		data, err := os.ReadFile(v.TokenFile)
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("reading the Vault token: %w", err)
+		}
+		vaultToken = strings.TrimSpace(string(data))
+	}
+
+	endpoint := v.Addr + "/v1/" + escapeVaultPath(v.Mount) + "/data/" + escapeVaultPath(v.Path)
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	req.Header.Set("X-Vault-Token", vaultToken)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	var result vaultSecretResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	switch {
+	case resp.StatusCode != http.StatusOK && len(result.Errors) > 0:
+		return "", 0, 0, fmt.Errorf("%s: status %d: %s", v.secretName(), resp.StatusCode, strings.Join(result.Errors, "; "))
+	case resp.StatusCode == http.StatusNotFound:
+		return "", 0, 0, fmt.Errorf("%s: secret not found (is %s a KV version 2 mount?)", v.secretName(), v.Mount)
+	case resp.StatusCode != http.StatusOK:
+		return "", 0, 0, fmt.Errorf("%s: status %d", v.secretName(), resp.StatusCode)
+	case decodeErr != nil:
+		return "", 0, 0, fmt.Errorf("%s: %w", v.secretName(), decodeErr)
+	}
+
+	value, _ := result.Data.Data[v.Key].(string)
+	if value = strings.TrimSpace(value); value == "" {
+		return "", 0, 0, fmt.Errorf("%s has no %q key", v.secretName(), v.Key)
+	}
+	lease := v.Refresh
+	if result.LeaseDuration > 0 {
+		lease = time.Duration(result.LeaseDuration) * time.Second
+	}
+	return value, result.Data.Metadata.Version, lease, nil
+}
+
+// escapeVaultPath percent-encodes the segments of a Vault path
+func escapeVaultPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFakeVault serves the KV v2 secret secret/dns of a Vault accepting the token "root".
+// set changes the API token in the secret, and fail makes reads fail.
+func newFakeVault(t *testing.T) (url string, set func(token string), fail func(bool)) {
+	var mu sync.Mutex
+	token, version, failing := "token-1", 1, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case failing:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"Vault is sealed"}})
+		case r.Header.Get("X-Vault-Token") != "root":
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+		case r.URL.Path != "/v1/secret/data/dns":
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{}})
+		default:
+			json.NewEncoder(w).Encode(map[string]any{
+				"lease_duration": 0,
+				"data":           map[string]any{"data": map[string]any{"cf_api_token": token}, "metadata": map[string]any{"version": version}},
+			})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	set = func(value string) {
+		mu.Lock()
+		defer mu.Unlock()
+		token = value
+		version++
+	}
+	fail = func(f bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		failing = f
+	}
+	return srv.URL, set, fail
+}
+
+func TestVaultSecretRefresh(t *testing.T) {
+	addr, set, fail := newFakeVault(t)
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	vault := &vaultSecret{Addr: addr, Mount: "secret", Path: "dns", Key: "cf_api_token", Token: "root", Refresh: time.Hour, client: http.DefaultClient, clock: clock}
+
+	if token, err := vault.get(); err != nil || token != "token-1" {
+		t.Fatalf("get = %q, %v", token, err)
+	}
+	set("token-2")
+	if token := vault.token(); token != "token-1" {
+		t.Errorf("token read again before the lease expired: %q", token)
+	}
+	clock.advance(time.Hour)
+	if token := vault.token(); token != "token-2" {
+		t.Errorf("token after the lease expired = %q, want token-2", token)
+	}
+
+	// A failed refresh keeps the current token, and is retried a minute later
+	set("token-3")
+	fail(true)
+	clock.advance(time.Hour)
+	if token := vault.token(); token != "token-2" {
+		t.Errorf("token after a failed refresh = %q, want token-2", token)
+	}
+	fail(false)
+	clock.advance(vaultRetryDelay)
+	if token := vault.token(); token != "token-3" {
+		t.Errorf("token after the retry = %q, want token-3", token)
+	}
+}
+
+func TestVaultSecretErrors(t *testing.T) {
+	addr, _, _ := newFakeVault(t)
+	for _, tt := range []struct {
+		name, mount, key, token, want string
+	}{
+		{"denied", "secret", "cf_api_token", "wrong", "permission denied"},
+		{"missing", "kv", "cf_api_token", "root", "secret not found"},
+		{"key", "secret", "token", "root", `no "token" key`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := &vaultSecret{Addr: addr, Mount: tt.mount, Path: "dns", Key: tt.key, Token: tt.token, Refresh: time.Hour, client: http.DefaultClient}
+			if _, err := vault.get(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigFromVault(t *testing.T) {
+	addr, _, _ := newFakeVault(t)
+	t.Setenv(envPrefix+"VAULT_ADDR", addr)
+	t.Setenv(envPrefix+"VAULT_TOKEN", "root")
+	t.Setenv(envPrefix+"VAULT_SECRET_PATH", "dns")
+	t.Setenv(envPrefix+"CF_ZONE_ID", "zone")
+	t.Setenv(envPrefix+"EXTERNAL_DOMAIN", "home.example.com")
+
+	config, err := reloadConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CFAPIToken != "token-1" || config.vault == nil {
+		t.Errorf("token = %q, vault = %v", config.CFAPIToken, config.vault)
+	}
+
+	t.Setenv(envPrefix+"CF_API_TOKEN", "token")
+	if _, err := reloadConfig(false); err == nil || !strings.Contains(err.Error(), "Both") {
+		t.Errorf("both CF_API_TOKEN and Vault set: err = %v", err)
+	}
+}
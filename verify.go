@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"time"
+)
+
+// newResolver returns a resolver that queries the given DNS server (host:port) directly,
+// bypassing the system resolver and its caches
+func newResolver(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// lookupAddresses resolves A or AAAA records for name using resolver
+func lookupAddresses(resolver *net.Resolver, name, recordType string) ([]string, error) {
+	network := "ip4"
+	if recordType == "AAAA" {
+		network = "ip6"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addrs, err := resolver.LookupNetIP(ctx, network, name)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, len(addrs))
+	for i, addr := range addrs {
+		addresses[i] = normalizeAddr(addr).String()
+	}
+	return addresses, nil
+}
+
+// containsAll reports whether every expected address is present in actual
+func containsAll(actual, expected []string) bool {
+	present := newAddrSet(actual)
+	for _, e := range expected {
+		if !present.hasContent(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// waitForPropagation polls resolver until name resolves to all expected addresses
+// or the timeout expires. Returns true if propagation was confirmed.
+func waitForPropagation(resolver *net.Resolver, name, recordType string, expected []string, timeout, interval time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if actual, err := lookupAddresses(resolver, name, recordType); err == nil && containsAll(actual, expected) {
+			return true
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return false
+		}
+		time.Sleep(interval)
+	}
+}
+
+// propagationPollInterval spaces the lookups of a propagation check that is due
+const propagationPollInterval = 5 * time.Second
+
+// propagationCheck is the deferred propagation check of one run's changes
+type propagationCheck struct {
+	config     *Config
+	pending    map[string]map[string][]string // Domain -> record type -> addresses still to resolve
+	detectedAt time.Time
+	due        time.Time // When the cached records have expired
+	deadline   time.Time // due + VERIFY_TIMEOUT_SECONDS
+}
+
+// verifyQueue verifies propagation for daemon and serve mode. Instead of the update
+// job waiting for cached records to expire and the records to resolve, which would hold up
+// the scheduler's other jobs and shutdown, the update queues its changes here and the
+// verify job looks them up once they are due, one round of lookups per run. Confirmed
+// changes are marked verified in the state file, with their latency. Both jobs run on the
+// scheduler's goroutine.
+type verifyQueue struct {
+	checks []*propagationCheck
+	wake   func() // Requests a run of the verify job
+}
+
+// newVerifyQueue registers the verify job with the scheduler
+func newVerifyQueue(s *scheduler) *verifyQueue {
+	p := &verifyQueue{wake: func() { s.requestRun("verify") }}
+	s.add("verify", p.run)
+	return p
+}
+
+// add queues the check of a run's changes (domain -> record type -> new addresses)
+func (p *verifyQueue) add(config *Config, byDomain map[string]map[string][]string, detectedAt, due time.Time) {
+	check := &propagationCheck{config: config, pending: make(map[string]map[string][]string), detectedAt: detectedAt, due: due,
+		deadline: due.Add(time.Duration(config.VerifyTimeout) * time.Second)}
+	for domain, types := range byDomain {
+		for recordType, expected := range types {
+			if len(expected) == 0 {
+				continue
+			}
+			if check.pending[domain] == nil {
+				check.pending[domain] = make(map[string][]string)
+			}
+			check.pending[domain][recordType] = expected
+		}
+	}
+	if len(check.pending) == 0 {
+		return
+	}
+	if wait := time.Until(due); wait > 0 {
+		log.Printf("Verifying propagation in %s, once cached records have expired", wait.Round(time.Second))
+	}
+	p.checks = append(p.checks, check)
+	p.wake()
+}
+
+// run is the verify job: it looks up the changes that are due and returns the delay
+// until the next lookup
+func (p *verifyQueue) run() time.Duration {
+	now := time.Now()
+	next := onDemand
+	kept := p.checks[:0]
+	for _, check := range p.checks {
+		if now.Before(check.due) {
+			next = min(next, check.due.Sub(now))
+			kept = append(kept, check)
+			continue
+		}
+		if check.lookup(now) {
+			next = min(next, propagationPollInterval)
+			kept = append(kept, check)
+		}
+	}
+	p.checks = kept
+	return next
+}
+
+// lookup resolves the pending changes once, recording the domains that propagated; it
+// returns whether the check goes on
+func (c *propagationCheck) lookup(now time.Time) bool {
+	resolver := newResolver(c.config.VerifyResolver)
+	for domain, types := range c.pending {
+		verified := true
+		for recordType, expected := range types {
+			if actual, err := lookupAddresses(resolver, domain, recordType); err != nil || !containsAll(actual, expected) {
+				continue
+			}
+			delete(types, recordType)
+			if c.config.VerifyDNSSEC {
+				if err := verifyDNSSEC(c.config.VerifyResolver, domain, recordType, expected, 10*time.Second); err != nil {
+					verified = false
+					log.Printf("DNSSEC verification of %s %s failed: %v", recordType, domain, err)
+				}
+			}
+		}
+		if !verified {
+			delete(c.pending, domain) // Stays unverified
+		} else if len(types) == 0 {
+			delete(c.pending, domain)
+			c.markVerified(domain, now)
+		}
+	}
+	if len(c.pending) == 0 {
+		return false
+	}
+	if !now.Before(c.deadline) {
+		for domain, types := range c.pending {
+			for recordType := range types {
+				log.Printf("Propagation of %s %s not confirmed within %ds", recordType, domain, c.config.VerifyTimeout)
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// markVerified marks a domain's change event of the run verified in the state file
+func (c *propagationCheck) markVerified(domain string, now time.Time) {
+	state, err := loadState(c.config.StateFile)
+	if err != nil {
+		log.Printf("WARNING: Could not load state, propagation of %s not recorded: %v", domain, err)
+		return
+	}
+	ds, ok := state.Domains[domain]
+	if !ok {
+		return
+	}
+	for i := len(ds.Changes) - 1; i >= 0; i-- {
+		if ds.Changes[i].Timestamp == c.detectedAt.Unix() {
+			ds.Changes[i].Verified = true
+			ds.Changes[i].LatencySeconds = math.Round(now.Sub(c.detectedAt).Seconds()*10) / 10
+			break
+		}
+	}
+	if err := state.save(c.config.StateFile); err != nil {
+		log.Printf("WARNING: Could not save state: %v", err)
+	}
+}
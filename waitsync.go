@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"time"
+)
+
+// desiredAddresses returns the addresses each managed name should resolve to
+// (domain -> record type -> addresses), derived from the detected IPs
+func desiredAddresses(config *Config, ips *IPAddresses) map[string]map[string][]string {
+	desired := make(map[string]map[string][]string)
+	add := func(domain, recordType string, addresses ...string) {
+		if domain == "" {
+			return
+		}
+		for _, address := range addresses {
+			if address == "" {
+				continue
+			}
+			if desired[domain] == nil {
+				desired[domain] = make(map[string][]string)
+			}
+			desired[domain][recordType] = append(desired[domain][recordType], address)
+		}
+	}
+
+	add(config.InternalDomain, "A", ips.InternalIPv4...)
+	for _, r := range config.CustomIPv4Ranges {
+		add(r.Domain, "A", ips.CustomRangeIPs[r.Domain]...)
+	}
+	for _, r := range config.CustomIPv6Ranges {
+		add(r.Domain, "AAAA", ips.CustomRangeIPs[r.Domain]...)
+	}
+	add(config.ExternalDomain, "A", ips.ExternalIPv4)
+	add(config.IPv6Domain, "AAAA", ips.ExternalIPv6)
+
+	if config.CombinedDomain != "" {
+		var allIPv4s []string
+		allIPv4s = append(allIPv4s, ips.InternalIPv4...)
+		for _, r := range config.CustomIPv4Ranges {
+			allIPv4s = append(allIPv4s, ips.CustomRangeIPs[r.Domain]...)
+		}
+		allIPv4s = append(allIPv4s, ips.ExternalIPv4)
+		add(config.CombinedDomain, "A", allIPv4s...)
+		add(config.CombinedDomain, "AAAA", ips.ExternalIPv6)
+
+		// The top-level CNAME resolves through to the combined domain's addresses
+		if config.TopLevelDomain != "" {
+			for recordType, addresses := range desired[config.CombinedDomain] {
+				add(config.TopLevelDomain, recordType, addresses...)
+			}
+		}
+	}
+
+	return desired
+}
+
+// waitUntilDNSSynced blocks until every managed name resolves to its desired addresses.
+// Returns false if timeout (0 = no timeout) expires first.
+func waitUntilDNSSynced(config *Config, ips *IPAddresses, timeout time.Duration) bool {
+	desired := desiredAddresses(config, ips)
+	domains := make([]string, 0, len(desired))
+	for domain, records := range desired {
+		// Proxied records resolve to CloudFlare edge addresses
+		for recordType, addresses := range records {
+			addresses = slices.DeleteFunc(addresses, func(address string) bool { return config.proxiedFor(domain, address) })
+			if len(addresses) == 0 {
+				log.Printf("Not waiting for %s %s: its records are proxied", recordType, domain)
+				delete(records, recordType)
+			} else {
+				records[recordType] = addresses
+			}
+		}
+		if len(records) > 0 {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+
+	log.Printf("Waiting for %d name(s) to resolve to the detected addresses via %s", len(domains), config.VerifyResolver)
+
+	resolver := newResolver(config.VerifyResolver)
+	deadline := time.Now().Add(timeout)
+	pending := domains
+
+	for {
+		var stillPending []string
+		for _, domain := range pending {
+			synced := true
+			for recordType, expected := range desired[domain] {
+				actual, err := lookupAddresses(resolver, domain, recordType)
+				if err != nil || !containsAll(actual, expected) {
+					synced = false
+					break
+				}
+			}
+			if synced {
+				log.Printf("DNS synced: %s", domain)
+			} else {
+				stillPending = append(stillPending, domain)
+			}
+		}
+
+		if len(stillPending) == 0 {
+			log.Println("All managed names resolve to the detected addresses")
+			return true
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			log.Printf("Timed out waiting for DNS sync; still pending: %v", stillPending)
+			return false
+		}
+
+		pending = stillPending
+		time.Sleep(5 * time.Second)
+	}
+}
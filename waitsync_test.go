@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestDesiredAddresses verifies per-domain expected addresses, including the combined and top-level domains
+func TestDesiredAddresses(t *testing.T) {
+	config := &Config{
+		InternalDomain: "host.i.example.com",
+		ExternalDomain: "host.e.example.com",
+		CombinedDomain: "host.example.com",
+		TopLevelDomain: "host.example.org",
+		CustomIPv4Ranges: []CustomIPRange{
+			{CIDR: "100.64.0.0/10", Domain: "host.ts.example.com", Type: "A"},
+		},
+	}
+	ips := &IPAddresses{
+		InternalIPv4:   []string{"192.168.1.10"},
+		ExternalIPv4:   "203.0.113.1",
+		ExternalIPv6:   "2001:db8::1",
+		CustomRangeIPs: map[string][]string{"host.ts.example.com": {"100.64.1.5"}},
+	}
+
+	desired := desiredAddresses(config, ips)
+
+	expected := map[string]map[string][]string{
+		"host.i.example.com":  {"A": {"192.168.1.10"}},
+		"host.e.example.com":  {"A": {"203.0.113.1"}},
+		"host.ts.example.com": {"A": {"100.64.1.5"}},
+		"host.example.com":    {"A": {"192.168.1.10", "100.64.1.5", "203.0.113.1"}, "AAAA": {"2001:db8::1"}},
+		"host.example.org":    {"A": {"192.168.1.10", "100.64.1.5", "203.0.113.1"}, "AAAA": {"2001:db8::1"}},
+	}
+	if !reflect.DeepEqual(desired, expected) {
+		t.Errorf("desiredAddresses() = %v, expected %v", desired, expected)
+	}
+}
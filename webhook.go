@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookSignatureHeader carries the HMAC-SHA256 of the request body, as "sha256=<hex>"
+const webhookSignatureHeader = "X-Dynipupdate-Signature"
+
+// WebhookProvider POSTs record change events to a URL instead of talking to a DNS
+// service, so the updater can drive home automation or a custom DNS pipeline. Each
+// event is one JSON request, signed with HMAC-SHA256 over the body:
+//
+//	{"action": "update", "name": "home.example.com", "type": "A", "content": "203.0.113.2",
+//	 "previous": "203.0.113.1", "ttl": 120, "timestamp": 1700000000}
+//
+// A webhook cannot be read back, so the record sets are remembered in memory: after a
+// restart the current records are sent again as creates. Heartbeats are not sent, so the
+// cleanup service has no effect.
+type WebhookProvider struct {
+	URL    string
+	Secret string
+
+	client *http.Client
+	now    func() time.Time
+
+	mu               sync.Mutex
+	sets             map[string][]string // name/type -> values last sent
+	heartbeatWarning sync.Once
+
+	recordSetClient
+}
+
+// Verify WebhookProvider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*WebhookProvider)(nil)
+var _ DNSProvider = (*WebhookProvider)(nil)
+var _ providerClient = (*WebhookProvider)(nil)
+
+// webhookEvent is the body of a webhook request
+type webhookEvent struct {
+	Action    string `json:"action"` // create, update or delete
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Content   string `json:"content"`
+	Previous  string `json:"previous,omitempty"` // Content replaced by an update
+	TTL       int    `json:"ttl,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// newWebhookProvider creates a webhook provider
+func newWebhookProvider(config *Config) (*WebhookProvider, error) {
+	if !strings.HasPrefix(config.WebhookURL, "https://") && !strings.HasPrefix(config.WebhookURL, "http://") {
+		return nil, fmt.Errorf("%sWEBHOOK_URL must be an http or https URL, got %q", envPrefix, config.WebhookURL)
+	}
+	w := &WebhookProvider{
+		URL:    config.WebhookURL,
+		Secret: config.WebhookSecret,
+		client: newProviderHTTPClient(config, providerWebhook),
+		now:    config.now,
+		sets:   make(map[string][]string),
+	}
+	w.recordSetClient = newRecordSetClient(w, config)
+	log.Printf("Sending record changes to webhook %s", w.URL)
+
+	warnUnsupportedOptions(config, "webhooks")
+	return w, nil
+}
+
+// webhookSignature returns the signature header value of a body
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send POSTs one event
+func (w *WebhookProvider) send(event webhookEvent) error {
+	event.Timestamp = w.now().Unix()
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", dynDNS2UserAgent)
+	req.Header.Set(webhookSignatureHeader, webhookSignature(w.Secret, body))
+
+	log.Printf("Webhook: %s %s record %s", event.Action, event.Type, event.Name)
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("webhook returned %w", statusError(resp.StatusCode, strings.TrimSpace(string(data))))
+	}
+	return nil
+}
+
+// upsertHeartbeat does nothing: a heartbeat every run would drown out the changes, and
+// the webhook cannot be listed by the cleanup service anyway
+func (w *WebhookProvider) upsertHeartbeat(name, content string) error {
+	w.heartbeatWarning.Do(func() {
+		log.Println("Heartbeats are not sent to the webhook")
+	})
+	return nil
+}
+
+// recordSetBackend implementation
+
+func (w *WebhookProvider) fetchValues(name, recordType string) ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sets[dynDNS2Key(name, recordType)], nil
+}
+
+// storeValues sends the difference to the values last sent: a replaced value is one
+// update event, other values are created or deleted
+func (w *WebhookProvider) storeValues(name, recordType string, ttl int, values []string) error {
+	name = strings.TrimSuffix(name, ".")
+	key := dynDNS2Key(name, recordType)
+	w.mu.Lock()
+	current := w.sets[key]
+	w.mu.Unlock()
+
+	kept, removed := matchValues(current, values, sameContent)
+
+	// Record every event that was delivered, so a failure part-way resends only the rest
+	sent := append([]string(nil), current...)
+	remember := func() {
+		w.mu.Lock()
+		w.sets[key] = sent
+		w.mu.Unlock()
+	}
+	defer remember()
+	without := func(value string) {
+		for i, s := range sent {
+			if s == value {
+				sent = append(sent[:i], sent[i+1:]...)
+				return
+			}
+		}
+	}
+
+	for i, v := range values {
+		if kept[i] {
+			continue
+		}
+		event := webhookEvent{Action: "create", Name: name, Type: recordType, Content: v, TTL: ttl}
+		if len(removed) > 0 {
+			event.Action, event.Previous, removed = "update", removed[0], removed[1:]
+		}
+		if err := w.send(event); err != nil {
+			return err
+		}
+		if event.Previous != "" {
+			without(event.Previous)
+		}
+		sent = append(sent, v)
+	}
+	for _, r := range removed {
+		if err := w.send(webhookEvent{Action: "delete", Name: name, Type: recordType, Content: r}); err != nil {
+			return err
+		}
+		without(r)
+	}
+	return nil
+}
+
+// listRecords returns nothing: a webhook cannot be listed, so cleanup has no effect
+func (w *WebhookProvider) listRecords(recordType string) ([]CFRecord, error) {
+	return []CFRecord{}, nil
+}
+
+// probe reports whether the webhook's server answers at all
+func (w *WebhookProvider) probe() bool {
+	req, err := http.NewRequest("HEAD", w.URL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWebhook records the events it receives after checking their signature
+type fakeWebhook struct {
+	mu     sync.Mutex
+	events []webhookEvent
+	status int // Response status (0 = 200)
+}
+
+func newFakeWebhook(t *testing.T) (*fakeWebhook, *WebhookProvider, *httptest.Server) {
+	t.Helper()
+	fake := &fakeWebhook{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if r.Method == "HEAD" {
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(webhookSignatureHeader) != webhookSignature("test-secret", body) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if fake.status != 0 {
+			w.WriteHeader(fake.status)
+			io.WriteString(w, "pipeline broken")
+			return
+		}
+		var event webhookEvent
+		json.Unmarshal(body, &event)
+		fake.events = append(fake.events, event)
+	}))
+	t.Cleanup(server.Close)
+
+	p, err := newWebhookProvider(&Config{
+		WebhookURL:    server.URL + "/dns",
+		WebhookSecret: "test-secret",
+		RecordTTL:     120,
+		clock:         newFakeClock(time.Unix(1700000000, 0)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, p, server
+}
+
+// summary returns the received events as "action type name content [previous]"
+func (f *fakeWebhook) summary() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []string
+	for _, e := range f.events {
+		result = append(result, strings.TrimSpace(strings.Join([]string{e.Action, e.Type, e.Name, e.Content, e.Previous}, " ")))
+	}
+	return result
+}
+
+func TestWebhookEvents(t *testing.T) {
+	fake, p, _ := newFakeWebhook(t)
+
+	p.ensureRecordExists("nas.example.com", "A", "192.168.1.10", false)
+	p.ensureRecordExists("nas.example.com", "A", "192.168.1.11", false)
+	p.upsertRecord("home.example.com", "A", "203.0.113.1", false)
+	p.upsertRecord("home.example.com", "A", "203.0.113.1", false) // Unchanged: not sent
+	p.upsertRecord("home.example.com", "A", "203.0.113.2", false)
+	p.deleteRecord("192.168.1.11", "nas.example.com", "A")
+	p.upsertHeartbeat("home.example.com", `"1700000000"`)
+
+	want := []string{
+		"create A nas.example.com 192.168.1.10",
+		"create A nas.example.com 192.168.1.11",
+		"create A home.example.com 203.0.113.1",
+		"update A home.example.com 203.0.113.2 203.0.113.1",
+		"delete A nas.example.com 192.168.1.11",
+	}
+	if got := fake.summary(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events =\n%v\nwant\n%v", got, want)
+	}
+	if e := fake.events[0]; e.TTL != 120 || e.Timestamp != 1700000000 {
+		t.Errorf("event = %+v, want TTL 120 and the clock's timestamp", e)
+	}
+	if r, _ := p.getRecord("home.example.com", "A"); r == nil || r.Content != "203.0.113.2" {
+		t.Errorf("getRecord = %+v", r)
+	}
+	if records, _ := p.getAllRecordsByType("TXT"); len(records) != 0 {
+		t.Errorf("listed = %+v", records)
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	fake, p, server := newFakeWebhook(t)
+
+	if !p.probeConnectivity() {
+		t.Error("probe failed")
+	}
+
+	// A rejected event is an error and is sent again on the next attempt
+	fake.status = http.StatusInternalServerError
+	err := p.storeValues("home.example.com", "A", 120, []string{"203.0.113.1"})
+	if err == nil || errors.Is(err, errProviderUnreachable) || !strings.Contains(err.Error(), "pipeline broken") {
+		t.Errorf("err = %v", err)
+	}
+	fake.status = 0
+	if p.upsertRecord("home.example.com", "A", "203.0.113.1", false) != nil || len(fake.summary()) != 1 {
+		t.Errorf("events after retry = %v", fake.summary())
+	}
+
+	// A wrong secret is rejected by the receiver
+	p.Secret = "wrong"
+	if err := p.storeValues("home.example.com", "A", 120, []string{"203.0.113.2"}); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("err = %v, want status 403", err)
+	}
+
+	server.Close()
+	if err := p.storeValues("home.example.com", "A", 120, []string{"203.0.113.2"}); !errors.Is(err, errProviderUnreachable) {
+		t.Errorf("err = %v, want unreachable", err)
+	}
+	if p.probeConnectivity() {
+		t.Error("probe succeeded without a server")
+	}
+
+	if _, err := newWebhookProvider(&Config{WebhookURL: "hooks.example.com"}); err == nil {
+		t.Error("accepted a URL without a scheme")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// CloudFlare zone setup types (the "type" field of the zone details)
+const (
+	zoneTypeFull      = "full"      // CloudFlare is authoritative
+	zoneTypePartial   = "partial"   // CNAME setup: another provider is authoritative, records are DNS only
+	zoneTypeSecondary = "secondary" // Records are transferred from a primary and cannot be edited
+)
+
+// CFZone holds the zone details needed to adapt behaviour to the zone setup
+type CFZone struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+type CFZoneResponse struct {
+	Success bool              `json:"success"`
+	Errors  []json.RawMessage `json:"errors"`
+	Result  CFZone            `json:"result"`
+}
+
+// getZone fetches the details of the configured zone
+func (cf *CloudFlareClient) getZone() (*CFZone, error) {
+	resp, err := cf.makeRequest("GET", fmt.Sprintf("/zones/%s", cf.ZoneID), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result CFZoneResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding zone details: %w", err)
+	}
+	if !result.Success {
+		return nil, fmt.Errorf("%s", formatErrors(result.Errors))
+	}
+	return &result.Result, nil
+}
+
+// adaptToZoneSetup adjusts config for the zone's setup type.
+// Partial (CNAME setup) zones get DNS-only records and warnings for features that rely on
+// CloudFlare being authoritative. Returns an error if the zone's records cannot be managed at all.
+func adaptToZoneSetup(config *Config, zone *CFZone) error {
+	switch zone.Type {
+	case zoneTypePartial:
+		log.Printf("Zone %s uses a partial (CNAME) setup - another provider is authoritative for it", zone.Name)
+		if config.anyProxied() {
+			log.Printf("WARNING: %sCF_PROXIED=true is not supported for dynamic records in a partial zone - publishing DNS only records", envPrefix)
+			config.Proxied, config.DomainProxied = false, nil
+		}
+		if config.VerifyPropagation {
+			log.Printf("WARNING: %sVERIFY_PROPAGATION checks public DNS, which is served by the authoritative provider for %s - records may never be seen there unless it delegates to CloudFlare", envPrefix, zone.Name)
+		}
+		if config.TopLevelDomain == zone.Name {
+			log.Printf("WARNING: %sTOP_LEVEL_DOMAIN is the zone apex; a CNAME at the apex is only served by CloudFlare, not by the authoritative provider", envPrefix)
+		}
+
+	case zoneTypeSecondary:
+		return fmt.Errorf("zone %s is a secondary zone - its records are transferred from the primary nameserver and cannot be updated through the CloudFlare API", zone.Name)
+	}
+
+	return nil
+}
+
+// checkZoneSetup detects the zone setup type and adapts config to it.
+// Failing to read the zone details is not fatal - the zone is assumed to be a full setup.
+func checkZoneSetup(cf *CloudFlareClient, config *Config) error {
+	zone, err := cf.getZone()
+	if err != nil {
+		log.Printf("Could not read zone details (assuming full setup): %v", err)
+		return nil
+	}
+	return adaptToZoneSetup(config, zone)
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestCheckZoneSetupPartialForcesDNSOnly(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	fake.zoneType = zoneTypePartial
+
+	config := &Config{Proxied: true, TopLevelDomain: "example.com"}
+	if err := checkZoneSetup(cf, config); err != nil {
+		t.Fatalf("checkZoneSetup: %v", err)
+	}
+	if config.Proxied {
+		t.Error("Proxied should be forced off for partial zones")
+	}
+}
+
+func TestCheckZoneSetupFull(t *testing.T) {
+	_, cf := newFakeCloudFlare(t)
+
+	config := &Config{Proxied: true}
+	if err := checkZoneSetup(cf, config); err != nil {
+		t.Fatalf("checkZoneSetup: %v", err)
+	}
+	if !config.Proxied {
+		t.Error("Proxied should be left alone for full zones")
+	}
+}
+
+func TestCheckZoneSetupSecondaryFails(t *testing.T) {
+	fake, cf := newFakeCloudFlare(t)
+	fake.zoneType = zoneTypeSecondary
+
+	if err := checkZoneSetup(cf, &Config{}); err == nil {
+		t.Error("expected an error for secondary zones")
+	}
+}
+
+func TestCheckZoneSetupUnreadable(t *testing.T) {
+	_, cf := newFakeCloudFlare(t)
+	cf.ZoneID = "missing"
+
+	config := &Config{Proxied: true}
+	if err := checkZoneSetup(cf, config); err != nil {
+		t.Fatalf("unreadable zone details should not be fatal: %v", err)
+	}
+	if !config.Proxied {
+		t.Error("config should be unchanged when the zone cannot be read")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Zone discovery. Without CF_ZONE_ID, the zone of each managed name is looked up with
+// GET /zones?name=, trying the name itself and then each parent (host.lan.example.com,
+// lan.example.com, example.com) until a zone visible to the token has that name. Lookups,
+// misses included, are cached for the life of the process, so a daemon asks once per name.
+
+// zoneDiscovery finds and caches the zones of record names
+type zoneDiscovery struct {
+	mu    sync.Mutex
+	zones map[string]*CFZone // By the name looked up; nil when no zone has that name
+}
+
+// newZoneDiscovery returns a discovery with an empty cache
+func newZoneDiscovery() *zoneDiscovery {
+	return &zoneDiscovery{zones: make(map[string]*CFZone)}
+}
+
+// find returns the zone name belongs to, or nil when no zone visible to the token contains
+// it. The top-level domain is never looked up.
+func (d *zoneDiscovery) find(cf *CloudFlareClient, name string) (*CFZone, error) {
+	for candidate := normalizeName(name); strings.Contains(candidate, "."); candidate = candidate[strings.Index(candidate, ".")+1:] {
+		d.mu.Lock()
+		zone, cached := d.zones[candidate]
+		d.mu.Unlock()
+		if !cached {
+			var err error
+			if zone, err = cf.lookupZone(candidate); err != nil {
+				return nil, err
+			}
+			d.mu.Lock()
+			d.zones[candidate] = zone
+			d.mu.Unlock()
+		}
+		if zone != nil {
+			return zone, nil
+		}
+	}
+	return nil, nil
+}
+
+// lookupZone returns the zone with exactly this name, or nil when the token sees none
+func (cf *CloudFlareClient) lookupZone(name string) (*CFZone, error) {
+	resp, err := cf.makeRequest("GET", "/zones?name="+url.QueryEscape(name), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errProviderUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	var result CFZoneListResponse
+	switch err := json.NewDecoder(resp.Body).Decode(&result); {
+	case err != nil:
+		return nil, statusError(resp.StatusCode, fmt.Sprintf("decoding zone list: %v", err))
+	case !result.Success:
+		return nil, cfResponseError(resp.StatusCode, result.Errors)
+	case len(result.Result) == 0:
+		return nil, nil
+	}
+	zone := result.Result[0]
+	zone.Name = normalizeName(zone.Name)
+	return &zone, nil
+}
+
+// discoverZones looks up the zones of the managed domains, adapting config to their setup
+// types like configured zones. Names outside the managed domains (relayed ones) are looked
+// up when first used.
+func discoverZones(cf *CloudFlareClient, config *Config) error {
+	cf.discovery = newZoneDiscovery()
+	for _, domain := range managedDomainList(config) {
+		zone, err := cf.discovery.find(cf, domain)
+		if err != nil {
+			return fmt.Errorf("looking up the zone of %s: %w", domain, err)
+		}
+		if zone == nil {
+			return fmt.Errorf("no zone visible to the token contains %s - check the token's zone resources or set %sCF_ZONE_ID", domain, envPrefix)
+		}
+		if cf.hasZone(zone.ID) {
+			continue
+		}
+		if err := adaptToZoneSetup(config, zone); err != nil {
+			return err
+		}
+		log.Printf("Discovered zone %s (%s) for %s", zone.Name, zone.ID, domain)
+		cf.Zones = append(cf.Zones, *zone)
+	}
+	if len(cf.Zones) > 0 {
+		cf.ZoneID = cf.Zones[0].ID
+		config.CFZoneID, config.CFZoneIDs = cf.ZoneID, cf.zoneIDs()
+	}
+	return nil
+}
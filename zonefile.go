@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Markers delimiting the records written by the zone file provider
+const (
+	zoneBlockBegin = "; BEGIN dynipupdate managed records - do not edit"
+	zoneBlockEnd   = "; END dynipupdate managed records"
+)
+
+// ZoneFileProvider writes the records to an RFC 1035 zone file served by an authoritative
+// server without dynamic updates, such as CoreDNS (file plugin) or NSD. The records live
+// between two marker lines; the rest of the file (SOA, NS and any records of your own) is
+// left alone, except that the SOA serial is bumped on every change so the server and its
+// secondaries pick the new zone up. A missing file is created with a minimal SOA and NS.
+//
+//	$ORIGIN example.com.
+//	@ 3600 IN SOA ns.example.com. hostmaster.example.com. ( 2024011502 3600 600 604800 300 )
+//	@ 3600 IN NS ns.example.com.
+//	; BEGIN dynipupdate managed records - do not edit
+//	nas.example.com. 300 IN A 192.168.1.10
+//	; END dynipupdate managed records
+type ZoneFileProvider struct {
+	Path       string
+	Origin     string // Zone name, e.g. example.com
+	Nameserver string // Nameserver of the SOA and NS records of a new file
+
+	mu  sync.Mutex
+	now func() time.Time
+
+	recordSetClient
+}
+
+// Verify ZoneFileProvider implements the same interfaces as CloudFlareClient
+var _ CloudFlareAPI = (*ZoneFileProvider)(nil)
+var _ DNSProvider = (*ZoneFileProvider)(nil)
+var _ providerClient = (*ZoneFileProvider)(nil)
+
+// resourceRecord is a record in presentation format ("name. ttl IN type rdata"), as in
+// zone files and unbound's local-data
+type resourceRecord struct {
+	Name  string
+	TTL   int
+	Type  string
+	Value string // Record content as used elsewhere (TXT strings joined by formatTXTStrings)
+}
+
+// cutField splits the first whitespace-separated field off s
+func cutField(s string) (field, rest string) {
+	s = strings.TrimLeft(s, " \t")
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimLeft(s[i:], " \t")
+	}
+	return s, ""
+}
+
+// parseResourceRecord parses a record with an absolute name; the TTL and class are optional
+func parseResourceRecord(rr string, defaultTTL int) (resourceRecord, error) {
+	r := resourceRecord{TTL: defaultTTL}
+	field, rest := cutField(rr)
+	r.Name = strings.ToLower(strings.TrimSuffix(field, "."))
+	field, rest = cutField(rest)
+	if ttl, err := strconv.Atoi(field); err == nil {
+		r.TTL = ttl
+		field, rest = cutField(rest)
+	}
+	if strings.EqualFold(field, "IN") {
+		field, rest = cutField(rest)
+	}
+	r.Type = strings.ToUpper(field)
+	rest = strings.TrimSpace(rest)
+	if r.Name == "" || r.Type == "" || rest == "" {
+		return resourceRecord{}, fmt.Errorf("cannot parse record %q", rr)
+	}
+
+	switch r.Type {
+	case "TXT":
+		strs := parseTXTStrings(rest)
+		r.Value = formatTXTStrings(strs)
+		if len(strs) == 1 {
+			r.Value = strs[0]
+		}
+	case "CNAME":
+		r.Value = strings.TrimSuffix(rest, ".")
+	default:
+		r.Value = rest
+	}
+	return r, nil
+}
+
+// rdata returns the record data in presentation format
+func (r resourceRecord) rdata() string {
+	switch r.Type {
+	case "TXT":
+		if strings.HasPrefix(r.Value, "\"") {
+			return r.Value
+		}
+		return formatTXTStrings([]string{r.Value})
+	case "CNAME":
+		return r.Value + "."
+	}
+	return r.Value
+}
+
+func (r resourceRecord) String() string {
+	return fmt.Sprintf("%s. %d IN %s %s", r.Name, r.TTL, r.Type, r.rdata())
+}
+
+// replaceRecordSet replaces the records of a name and type, keeping the position of the
+// set (new sets are appended)
+func replaceRecordSet(records []resourceRecord, name, recordType string, ttl int, values []string) []resourceRecord {
+	var kept []resourceRecord
+	position := -1
+	for _, r := range records {
+		if r.Name == name && r.Type == recordType {
+			if position < 0 {
+				position = len(kept)
+			}
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if position < 0 {
+		position = len(kept)
+	}
+	var set []resourceRecord
+	for _, v := range values {
+		set = append(set, resourceRecord{Name: name, TTL: ttl, Type: recordType, Value: v})
+	}
+	return append(append(append([]resourceRecord(nil), kept[:position]...), set...), kept[position:]...)
+}
+
+// newZoneFileProvider creates a zone file provider
+func newZoneFileProvider(config *Config) (*ZoneFileProvider, error) {
+	if _, err := os.Stat(filepath.Dir(config.ZoneFilePath)); err != nil {
+		return nil, fmt.Errorf("%sZONE_FILE_PATH: %w", envPrefix, err)
+	}
+	origin := strings.ToLower(strings.TrimSuffix(config.ZoneFileOrigin, "."))
+	p := &ZoneFileProvider{
+		Path:       config.ZoneFilePath,
+		Origin:     origin,
+		Nameserver: strings.TrimSuffix(config.ZoneFileNameserver, "."),
+		now:        config.now,
+	}
+	if p.Nameserver == "" {
+		p.Nameserver = "ns." + origin
+	}
+	p.recordSetClient = newRecordSetClient(p, config)
+	log.Printf("Writing records of zone %s to %s", p.Origin, p.Path)
+
+	warnUnsupportedOptions(config, "zone files")
+	return p, nil
+}
+
+// inZone checks that a name belongs to the zone
+func (p *ZoneFileProvider) inZone(name string) error {
+	if name != p.Origin && !strings.HasSuffix(name, "."+p.Origin) {
+		return fmt.Errorf("%s is not in zone %s", name, p.Origin)
+	}
+	return nil
+}
+
+// read returns the lines before and after the managed block and the block's records.
+// A missing file reads as a new zone with only SOA and NS records.
+func (p *ZoneFileProvider) read() (before, after []string, records []resourceRecord, err error) {
+	data, err := os.ReadFile(p.Path)
+	if os.IsNotExist(err) {
+		return p.newZone(), nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	section := 0 // 0 before the block, 1 inside, 2 after
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case section == 0 && line == zoneBlockBegin:
+			section = 1
+		case section == 1 && line == zoneBlockEnd:
+			section = 2
+		case section == 0:
+			before = append(before, line)
+		case section == 2:
+			after = append(after, line)
+		case strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), ";"):
+		default:
+			r, err := parseResourceRecord(line, 0)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%s: %w", p.Path, err)
+			}
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	if section == 1 {
+		return nil, nil, nil, fmt.Errorf("%s: managed block is not terminated by %q", p.Path, zoneBlockEnd)
+	}
+	return before, after, records, nil
+}
+
+// newZone returns the SOA and NS records of a new zone file
+func (p *ZoneFileProvider) newZone() []string {
+	return []string{
+		fmt.Sprintf("$ORIGIN %s.", p.Origin),
+		fmt.Sprintf("@ 3600 IN SOA %s. hostmaster.%s. ( 0 3600 600 604800 300 )", p.Nameserver, p.Origin),
+		fmt.Sprintf("@ 3600 IN NS %s.", p.Nameserver),
+	}
+}
+
+// soaSerial finds the serial of the SOA record: the first number after its two names,
+// which may be on a later line when the record is split with parentheses
+var soaSerial = regexp.MustCompile(`(?is)\bSOA\s+\S+\s+\S+\s*\(?\s*(\d+)`)
+
+// bumpSerial increases the SOA serial in the lines, using the date-based YYYYMMDDnn scheme
+// when it is ahead of the current serial
+func bumpSerial(lines []string, now time.Time) ([]string, error) {
+	text := strings.Join(lines, "\n")
+	match := soaSerial.FindStringSubmatchIndex(text)
+	if match == nil {
+		return nil, fmt.Errorf("no SOA record found")
+	}
+	serial, err := strconv.ParseUint(text[match[2]:match[3]], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOA serial %s", text[match[2]:match[3]])
+	}
+	next := serial + 1
+	if dated, _ := strconv.ParseUint(now.UTC().Format("20060102")+"00", 10, 32); dated > serial {
+		next = dated
+	}
+	next &= 0xffffffff // Serial arithmetic wraps (RFC 1982)
+	text = text[:match[2]] + strconv.FormatUint(next, 10) + text[match[3]:]
+	return strings.Split(text, "\n"), nil
+}
+
+// write replaces the file with the block and the lines around it, bumping the serial
+func (p *ZoneFileProvider) write(before, after []string, records []resourceRecord) error {
+	lines := append(append([]string(nil), before...), zoneBlockBegin)
+	for _, r := range records {
+		lines = append(lines, r.String())
+	}
+	lines = append(append(lines, zoneBlockEnd), after...)
+
+	lines, err := bumpSerial(lines, p.now())
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.Path, err)
+	}
+	return replaceFileAtomic(p.Path, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// recordSetBackend implementation
+
+func (p *ZoneFileProvider) fetchValues(name, recordType string) ([]string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if err := p.inZone(name); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _, records, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, r := range records {
+		if r.Name == name && r.Type == recordType {
+			values = append(values, r.Value)
+		}
+	}
+	return values, nil
+}
+
+func (p *ZoneFileProvider) storeValues(name, recordType string, ttl int, values []string) error {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if err := p.inZone(name); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	before, after, records, err := p.read()
+	if err != nil {
+		return err
+	}
+	return p.write(before, after, replaceRecordSet(records, name, recordType, ttl, values))
+}
+
+func (p *ZoneFileProvider) listRecords(recordType string) ([]CFRecord, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _, records, err := p.read()
+	if err != nil {
+		return []CFRecord{}, err
+	}
+	result := []CFRecord{}
+	for _, r := range records {
+		if r.Type == recordType {
+			result = append(result, valueRecords(r.Name, recordType, []string{r.Value})...)
+		}
+	}
+	return result, nil
+}
+
+// probe reports whether the file can be read
+func (p *ZoneFileProvider) probe() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _, _, err := p.read()
+	return err == nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestZoneFile(t *testing.T, content string, clock Clock) (*ZoneFileProvider, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "db.example.com")
+	if content != "" {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := newZoneFileProvider(&Config{ZoneFilePath: path, ZoneFileOrigin: "Example.com.", RecordTTL: 120, HeartbeatTTL: 60, clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, path
+}
+
+func TestZoneFileProviderCreatesZone(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC))
+	p, path := newTestZoneFile(t, "", clock)
+
+	if p.upsertRecord("nas.example.com", "A", "192.168.1.10", false) != nil ||
+		p.upsertRecord("www.example.com", "CNAME", "nas.example.com", false) != nil ||
+		p.upsertHeartbeat("nas.example.com", "1705320000") != nil {
+		t.Fatal("record operation failed")
+	}
+	want := "$ORIGIN example.com.\n" +
+		"@ 3600 IN SOA ns.example.com. hostmaster.example.com. ( 2024011502 3600 600 604800 300 )\n" +
+		"@ 3600 IN NS ns.example.com.\n" +
+		zoneBlockBegin + "\n" +
+		"nas.example.com. 120 IN A 192.168.1.10\n" +
+		"www.example.com. 120 IN CNAME nas.example.com.\n" +
+		"nas.example.com. 60 IN TXT \"1705320000\"\n" +
+		zoneBlockEnd + "\n"
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("zone file =\n%s\nwant\n%s", data, want)
+	}
+
+	if r, _ := p.getRecord("www.example.com", "CNAME"); r == nil || r.Content != "nas.example.com" {
+		t.Errorf("CNAME = %+v", r)
+	}
+	if p.upsertRecord("nas.example.org", "A", "192.168.1.10", false) == nil {
+		t.Error("record outside the zone written")
+	}
+	if list, err := p.listRecords("TXT"); err != nil || len(list) != 1 || list[0].Content != "1705320000" {
+		t.Errorf("listRecords(TXT) = %+v, %v", list, err)
+	}
+}
+
+func TestZoneFileProviderKeepsZoneAndBumpsSerial(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC))
+	zone := "$TTL 3600\n" +
+		"@ IN SOA ns1.example.com. admin.example.com. (\n" +
+		"        2099010100 ; serial ahead of the date\n" +
+		"        3600 600 604800 300 )\n" +
+		"@ IN NS ns1.example.com.\n" +
+		"mail IN A 192.0.2.25\n"
+	p, path := newTestZoneFile(t, zone, clock)
+
+	if p.upsertRecord("nas.example.com", "A", "192.168.1.10", false) != nil {
+		t.Fatal("upsert failed")
+	}
+	data, _ := os.ReadFile(path)
+	got := string(data)
+	if !strings.HasPrefix(got, strings.Replace(zone, "2099010100", "2099010101", 1)+zoneBlockBegin+"\n") {
+		t.Errorf("zone file =\n%s", got)
+	}
+
+	// An unchanged record does not touch the file
+	if p.upsertRecord("nas.example.com", "A", "192.168.1.10", false) != nil {
+		t.Fatal("upsert failed")
+	}
+	if data, _ := os.ReadFile(path); string(data) != got {
+		t.Errorf("unchanged record rewrote the zone:\n%s", data)
+	}
+}
+
+func TestBumpSerial(t *testing.T) {
+	now := time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC)
+	for _, c := range []struct{ in, want string }{
+		{"@ IN SOA ns. host. 1 3600 600 604800 300", "@ IN SOA ns. host. 2024011500 3600 600 604800 300"},
+		{"@ IN SOA ns. host. 2024011500 3600 600 604800 300", "@ IN SOA ns. host. 2024011501 3600 600 604800 300"},
+		{"@ IN SOA ns. host. 4294967295 3600 600 604800 300", "@ IN SOA ns. host. 0 3600 600 604800 300"},
+	} {
+		got, err := bumpSerial([]string{c.in}, now)
+		if err != nil || got[0] != c.want {
+			t.Errorf("bumpSerial(%q) = %q, %v; want %q", c.in, got, err, c.want)
+		}
+	}
+	if _, err := bumpSerial([]string{"@ IN NS ns."}, now); err == nil {
+		t.Error("zone without SOA accepted")
+	}
+}